@@ -1,6 +1,10 @@
 package api
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 type PaymentStatus string
 
@@ -10,12 +14,138 @@ const (
 	StatusFailed   PaymentStatus = "FAILED"
 )
 
+type IdentifierType string
+
+const (
+	IdentifierPhone      IdentifierType = "phone"
+	IdentifierNationalID IdentifierType = "national_id"
+	IdentifierPartnerRef IdentifierType = "partner_ref"
+)
+
+type CustomerIdentifier struct {
+	Type  IdentifierType `json:"type" binding:"required,oneof=phone national_id partner_ref"`
+	Value string         `json:"value" binding:"required,max=100"`
+}
+
 type PaymentPayload struct {
-	CustomerID           string        `json:"customer_id" binding:"required,startswith=GIG"`
+	CustomerID         string              `json:"customer_id" binding:"required_without=CustomerIdentifier,omitempty,startswith=GIG"`
+	CustomerIdentifier *CustomerIdentifier `json:"customer_identifier,omitempty"`
+	// AccountID targets one of the customer's accounts; without it the
+	// payment goes to their primary account.
+	AccountID string `json:"account_id,omitempty" binding:"max=50"`
+	// IntentReference is the payment intent reference echoed back by the
+	// gateway.
+	IntentReference      string        `json:"intent_reference,omitempty" binding:"max=40"`
 	PaymentStatus        PaymentStatus `json:"payment_status" binding:"required"`
 	TransactionAmount    string        `json:"transaction_amount" binding:"required"`
 	TransactionDate      string        `json:"transaction_date" binding:"required"`
 	TransactionReference string        `json:"transaction_reference" binding:"required"`
+	Currency             string        `json:"currency,omitempty"`
+	Channel              string        `json:"channel,omitempty"`
+	Metadata             Metadata      `json:"metadata,omitempty"`
+	AgentID              string        `json:"agent_id,omitempty" binding:"max=50"`
+	EnqueuedAt           *time.Time    `json:"enqueued_at,omitempty"`
+}
+
+type PaymentPayloadV2 struct {
+	CustomerID           string              `json:"customer_id" binding:"required_without=CustomerIdentifier,omitempty,startswith=GIG"`
+	CustomerIdentifier   *CustomerIdentifier `json:"customer_identifier,omitempty"`
+	AccountID            string              `json:"account_id,omitempty" binding:"max=50"`
+	IntentReference      string              `json:"intent_reference,omitempty" binding:"max=40"`
+	PaymentStatus        PaymentStatus       `json:"payment_status" binding:"required"`
+	TransactionAmount    string              `json:"transaction_amount" binding:"required"`
+	TransactionDate      string              `json:"transaction_date" binding:"required"`
+	TransactionReference string              `json:"transaction_reference" binding:"required"`
+	Currency             string              `json:"currency" binding:"required,len=3,uppercase"`
+	Channel              string              `json:"channel" binding:"required,oneof=bank_transfer mobile_money card cash ussd"`
+	Metadata             Metadata            `json:"metadata,omitempty"`
+	AgentID              string              `json:"agent_id,omitempty" binding:"max=50"`
+}
+
+func (p PaymentPayloadV2) ToPayload() PaymentPayload {
+	return PaymentPayload{
+		CustomerID:           p.CustomerID,
+		CustomerIdentifier:   p.CustomerIdentifier,
+		AccountID:            p.AccountID,
+		IntentReference:      p.IntentReference,
+		PaymentStatus:        p.PaymentStatus,
+		TransactionAmount:    p.TransactionAmount,
+		TransactionDate:      p.TransactionDate,
+		TransactionReference: p.TransactionReference,
+		Currency:             p.Currency,
+		Channel:              p.Channel,
+		Metadata:             p.Metadata,
+		AgentID:              p.AgentID,
+	}
+}
+
+// SplitPaymentRequest spreads one incoming transfer across several customer
+// accounts, e.g. one transfer covering two assets. Each split is processed
+// as its own payment referenced "<transaction_reference>-SPLIT-<n>".
+type SplitPaymentRequest struct {
+	PaymentStatus        PaymentStatus  `json:"payment_status" binding:"required"`
+	TransactionAmount    string         `json:"transaction_amount" binding:"required"`
+	TransactionDate      string         `json:"transaction_date" binding:"required"`
+	TransactionReference string         `json:"transaction_reference" binding:"required,max=90"`
+	Currency             string         `json:"currency" binding:"required,len=3,uppercase"`
+	Channel              string         `json:"channel" binding:"required,oneof=bank_transfer mobile_money card cash ussd"`
+	Metadata             Metadata       `json:"metadata,omitempty"`
+	AgentID              string         `json:"agent_id,omitempty" binding:"max=50"`
+	Splits               []PaymentSplit `json:"splits" binding:"required,min=2,max=10,dive"`
+}
+
+type PaymentSplit struct {
+	SplitReference string `json:"split_reference,omitempty"`
+	CustomerID     string `json:"customer_id" binding:"required,startswith=GIG"`
+	Amount         string `json:"amount" binding:"required"`
+}
+
+// CustomerGroup ties accounts together, e.g. the members of a cooperative,
+// for rollups and group-level payments.
+type CustomerGroup struct {
+	GroupID        string        `json:"group_id" binding:"required,max=50"`
+	Name           string        `json:"name" binding:"required,max=100"`
+	AllocationRule string        `json:"allocation_rule" binding:"omitempty,oneof=ARREARS_FIRST PRO_RATA EQUAL"`
+	Members        []GroupMember `json:"members,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+const (
+	// AllocateArrearsFirst clears members' arrears, in proportion to them,
+	// before spreading the rest pro rata.
+	AllocateArrearsFirst = "ARREARS_FIRST"
+	// AllocateProRata spreads a payment in proportion to outstanding balances.
+	AllocateProRata = "PRO_RATA"
+	// AllocateEqual gives every member the same share.
+	AllocateEqual = "EQUAL"
+)
+
+const (
+	GroupRoleMember    = "MEMBER"
+	GroupRoleGuarantor = "GUARANTOR"
+)
+
+type GroupMember struct {
+	CustomerID         string    `json:"customer_id"`
+	Role               string    `json:"role"`
+	OutstandingBalance float64   `json:"outstanding_balance"`
+	TotalPaid          float64   `json:"total_paid"`
+	Arrears            float64   `json:"arrears"`
+	JoinedAt           time.Time `json:"joined_at"`
+}
+
+// GroupPaymentRequest is one payment for a whole group, allocated across its
+// members by the group's rule unless AllocationRule overrides it.
+type GroupPaymentRequest struct {
+	PaymentStatus        PaymentStatus `json:"payment_status" binding:"required"`
+	TransactionAmount    string        `json:"transaction_amount" binding:"required"`
+	TransactionDate      string        `json:"transaction_date" binding:"required"`
+	TransactionReference string        `json:"transaction_reference" binding:"required,max=90"`
+	Currency             string        `json:"currency" binding:"required,len=3,uppercase"`
+	Channel              string        `json:"channel" binding:"required,oneof=bank_transfer mobile_money card cash ussd"`
+	Metadata             Metadata      `json:"metadata,omitempty"`
+	AgentID              string        `json:"agent_id,omitempty" binding:"max=50"`
+	AllocationRule       string        `json:"allocation_rule,omitempty" binding:"omitempty,oneof=ARREARS_FIRST PRO_RATA EQUAL"`
 }
 
 type PaymentResponse struct {
@@ -24,10 +154,15 @@ type PaymentResponse struct {
 	TransactionReference string   `json:"transaction_reference"`
 	CustomerID           string   `json:"customer_id"`
 	RemainingBalance     *float64 `json:"remaining_balance,omitempty"`
+	Metadata             Metadata `json:"metadata,omitempty"`
 }
 
+// CustomerAccount is one financed asset. A customer's first account shares
+// their customer ID; further accounts have their own IDs and name the
+// customer in HolderID, which for a primary account equals CustomerID.
 type CustomerAccount struct {
 	CustomerID         string     `json:"customer_id"`
+	HolderID           string     `json:"holder_id"`
 	AssetValue         float64    `json:"asset_value"`
 	TermWeeks          int        `json:"term_weeks"`
 	TotalPaid          float64    `json:"total_paid"`
@@ -36,4 +171,1401 @@ type CustomerAccount struct {
 	LastPaymentDate    *time.Time `json:"last_payment_date,omitempty"`
 	PaymentCount       int        `json:"payment_count"`
 	Version            int        `json:"version"`
+	Metadata           Metadata   `json:"metadata"`
+	Region             string     `json:"region,omitempty"`
+	Branch             string     `json:"branch,omitempty"`
+	ProductID          string     `json:"product_id,omitempty"`
+	AssetType          string     `json:"asset_type,omitempty"`
+	InterestRate       float64    `json:"interest_rate"`
+	InterestMethod     string     `json:"interest_method,omitempty"`
+	GraceWeeks         int        `json:"grace_weeks"`
+	InstallmentAmount  float64    `json:"installment_amount,omitempty"`
+	RestructuredAt     *time.Time `json:"restructured_at,omitempty"`
+	ScheduleBaseline   float64    `json:"schedule_baseline,omitempty"`
+	WrittenOffAt       *time.Time `json:"written_off_at,omitempty"`
+	WrittenOffAmount   float64    `json:"written_off_amount,omitempty"`
+	RecoveredAmount    float64    `json:"recovered_amount,omitempty"`
+	CalendarCode       string     `json:"calendar_code,omitempty"`
+	// Set by the nightly risk scoring job.
+	ProjectedPayoffDate *time.Time `json:"projected_payoff_date,omitempty"`
+	RiskScore           *int       `json:"risk_score,omitempty"`
+	RiskScoredAt        *time.Time `json:"risk_scored_at,omitempty"`
+}
+
+const (
+	InterestFlat            = "FLAT"
+	InterestReducingBalance = "REDUCING_BALANCE"
+)
+
+type RestructuringStatus string
+
+const (
+	RestructuringPending  RestructuringStatus = "PENDING"
+	RestructuringApproved RestructuringStatus = "APPROVED"
+	RestructuringRejected RestructuringStatus = "REJECTED"
+)
+
+type RestructuringRequest struct {
+	NewTermWeeks      int    `json:"new_term_weeks" binding:"required,gt=0"`
+	CapitalizeArrears bool   `json:"capitalize_arrears"`
+	Reason            string `json:"reason" binding:"required,max=500"`
+	RequestedBy       string `json:"requested_by" binding:"required,max=100"`
+}
+
+// Restructuring records a change to an account's repayment schedule. The
+// installment and baseline are recalculated at approval time.
+type Restructuring struct {
+	ID                int64               `json:"id"`
+	CustomerID        string              `json:"customer_id"`
+	Status            RestructuringStatus `json:"status"`
+	OldTermWeeks      int                 `json:"old_term_weeks"`
+	NewTermWeeks      int                 `json:"new_term_weeks"`
+	CapitalizeArrears bool                `json:"capitalize_arrears"`
+	CapitalizedAmount float64             `json:"capitalized_amount"`
+	OldInstallment    float64             `json:"old_installment"`
+	NewInstallment    float64             `json:"new_installment"`
+	Reason            string              `json:"reason"`
+	RequestedBy       string              `json:"requested_by"`
+	DecidedBy         string              `json:"decided_by,omitempty"`
+	DecisionNote      string              `json:"decision_note,omitempty"`
+	RequestedAt       time.Time           `json:"requested_at"`
+	DecidedAt         *time.Time          `json:"decided_at,omitempty"`
+}
+
+type PromiseStatus string
+
+const (
+	PromisePending PromiseStatus = "PENDING"
+	PromiseKept    PromiseStatus = "KEPT"
+	PromiseBroken  PromiseStatus = "BROKEN"
+)
+
+type PromiseToPay struct {
+	ID           int64         `json:"id"`
+	CustomerID   string        `json:"customer_id"`
+	AgentID      string        `json:"agent_id" binding:"required"`
+	Amount       float64       `json:"amount" binding:"required,gt=0"`
+	PromisedDate string        `json:"promised_date" binding:"required,datetime=2006-01-02"`
+	Status       PromiseStatus `json:"status"`
+	PaidAmount   float64       `json:"paid_amount"`
+	Note         string        `json:"note,omitempty" binding:"max=500"`
+	CreatedAt    time.Time     `json:"created_at"`
+	ResolvedAt   *time.Time    `json:"resolved_at,omitempty"`
+}
+
+// ContactProfile holds a customer's contact details. Consent flags are per
+// notification channel and default to opted out.
+type ContactProfile struct {
+	CustomerID       string     `json:"customer_id"`
+	Phone            string     `json:"phone,omitempty"`
+	PhoneVerified    bool       `json:"phone_verified"`
+	Email            string     `json:"email,omitempty"`
+	EmailVerified    bool       `json:"email_verified"`
+	AddressLine1     string     `json:"address_line1,omitempty"`
+	AddressLine2     string     `json:"address_line2,omitempty"`
+	City             string     `json:"city,omitempty"`
+	PostalCode       string     `json:"postal_code,omitempty"`
+	Country          string     `json:"country,omitempty"`
+	ConsentSMS       bool       `json:"consent_sms"`
+	ConsentEmail     bool       `json:"consent_email"`
+	ConsentSource    string     `json:"consent_source,omitempty"`
+	ConsentUpdatedAt *time.Time `json:"consent_updated_at,omitempty"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+type ContactUpdate struct {
+	Phone        *string `json:"phone" binding:"omitempty,max=20"`
+	Email        *string `json:"email" binding:"omitempty,max=254"`
+	AddressLine1 *string `json:"address_line1" binding:"omitempty,max=200"`
+	AddressLine2 *string `json:"address_line2" binding:"omitempty,max=200"`
+	City         *string `json:"city" binding:"omitempty,max=100"`
+	PostalCode   *string `json:"postal_code" binding:"omitempty,max=20"`
+	Country      *string `json:"country" binding:"omitempty,len=2"`
+}
+
+type ConsentUpdate struct {
+	SMS    *bool  `json:"sms"`
+	Email  *bool  `json:"email"`
+	Source string `json:"source" binding:"required,max=100"`
+}
+
+// NotificationPreferences are how the customer wants to hear from us. SMS
+// and Email are the channel consents; Language picks the message language;
+// non-essential messages due between QuietHoursStart and QuietHoursEnd
+// ("HH:MM" in Timezone, wrapping past midnight) wait until the quiet hours
+// end.
+type NotificationPreferences struct {
+	CustomerID      string     `json:"customer_id"`
+	SMS             bool       `json:"sms"`
+	Email           bool       `json:"email"`
+	Language        string     `json:"language,omitempty"`
+	QuietHoursStart string     `json:"quiet_hours_start,omitempty"`
+	QuietHoursEnd   string     `json:"quiet_hours_end,omitempty"`
+	Timezone        string     `json:"timezone,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+// PreferencesUpdate applies the non-nil fields. An empty string clears a
+// preference; quiet hours are set or cleared together.
+type PreferencesUpdate struct {
+	SMS             *bool   `json:"sms"`
+	Email           *bool   `json:"email"`
+	Language        *string `json:"language" binding:"omitempty,oneof=en fr sw"`
+	QuietHoursStart *string `json:"quiet_hours_start" binding:"omitempty,datetime=15:04"`
+	QuietHoursEnd   *string `json:"quiet_hours_end" binding:"omitempty,datetime=15:04"`
+	Timezone        *string `json:"timezone" binding:"omitempty,timezone"`
+}
+
+// LoanProduct defines the pricing terms copied onto a customer account when
+// the product is assigned. InterestRate is annual, e.g. 0.24 for 24%.
+type LoanProduct struct {
+	ProductID      string  `json:"product_id" binding:"required,max=50"`
+	Name           string  `json:"name" binding:"required,max=100"`
+	InterestRate   float64 `json:"interest_rate" binding:"gte=0,lte=5"`
+	InterestMethod string  `json:"interest_method" binding:"required,oneof=FLAT REDUCING_BALANCE"`
+	GraceWeeks     int     `json:"grace_weeks" binding:"gte=0,lte=52"`
+	// DunningRetryDays are the days waited before retrying each failed
+	// automatic debit; DunningDelinquentDays is how long after the final
+	// notice an account still in arrears is marked delinquent. They default
+	// to 1, 3 and 5 days and 14 days.
+	DunningRetryDays      []int     `json:"dunning_retry_days" binding:"omitempty,max=10,dive,min=1,max=60"`
+	DunningDelinquentDays *int      `json:"dunning_delinquent_days" binding:"omitempty,gte=0,lte=180"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+// DunningPolicy is the dunning part of a loan product.
+type DunningPolicy struct {
+	RetryDays      []int `json:"retry_days"`
+	DelinquentDays int   `json:"delinquent_days"`
+}
+
+const (
+	DunningRetrying    = "RETRYING"
+	DunningFinalNotice = "FINAL_NOTICE"
+	DunningDelinquent  = "DELINQUENT"
+	DunningResolved    = "RESOLVED"
+	// DunningCurrent is reported for accounts that have never been in
+	// dunning; it isn't stored.
+	DunningCurrent = "CURRENT"
+)
+
+// DunningCase tracks an account through dunning after an automatic debit
+// fails: RETRYING while retries remain, FINAL_NOTICE once they run out,
+// DELINQUENT if still in arrears DelinquentAfter, and RESOLVED when a debit
+// succeeds or the arrears are paid some other way.
+type DunningCase struct {
+	CustomerID        string     `json:"customer_id"`
+	Status            string     `json:"status"`
+	FailedAttempts    int        `json:"failed_attempts"`
+	ChargeReference   string     `json:"charge_reference,omitempty"`
+	LastFailureReason string     `json:"last_failure_reason,omitempty"`
+	NextRetryAt       *time.Time `json:"next_retry_at,omitempty"`
+	DelinquentAfter   *time.Time `json:"delinquent_after,omitempty"`
+	StartedAt         time.Time  `json:"started_at"`
+	LastFailureAt     *time.Time `json:"last_failure_at,omitempty"`
+	DelinquentAt      *time.Time `json:"delinquent_at,omitempty"`
+	ResolvedAt        *time.Time `json:"resolved_at,omitempty"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// PaymentIntent reserves an expected payment. The gateway echoes Reference
+// as the payment's intent_reference; unreferenced payments of exactly the
+// intended amount are linked to the customer's oldest pending intent.
+type PaymentIntent struct {
+	Reference            string     `json:"reference"`
+	CustomerID           string     `json:"customer_id"`
+	Amount               float64    `json:"amount"`
+	Currency             string     `json:"currency,omitempty"`
+	Status               string     `json:"status"`
+	ExpiresAt            time.Time  `json:"expires_at"`
+	TransactionReference string     `json:"transaction_reference,omitempty"`
+	Metadata             Metadata   `json:"metadata"`
+	CreatedAt            time.Time  `json:"created_at"`
+	MatchedAt            *time.Time `json:"matched_at,omitempty"`
+	CancelledAt          *time.Time `json:"cancelled_at,omitempty"`
+}
+
+const (
+	IntentPending   = "PENDING"
+	IntentMatched   = "MATCHED"
+	IntentCancelled = "CANCELLED"
+	// IntentExpired is reported for pending intents past their expiry; it
+	// is not stored.
+	IntentExpired = "EXPIRED"
+)
+
+type PaymentIntentRequest struct {
+	CustomerID string `json:"customer_id" binding:"required,startswith=GIG"`
+	AccountID  string `json:"account_id,omitempty" binding:"max=50"`
+	Amount     string `json:"amount" binding:"required"`
+	Currency   string `json:"currency,omitempty" binding:"omitempty,len=3,uppercase"`
+	// ExpiresIn is the reservation's lifetime in seconds; zero uses the
+	// server default.
+	ExpiresIn int      `json:"expires_in,omitempty" binding:"omitempty,min=60,max=604800"`
+	Metadata  Metadata `json:"metadata,omitempty"`
+}
+
+// PaymentReferenceRequest asks for a dynamic payment reference for the
+// customer's next installments. The reference is a payment intent for the
+// amount due.
+type PaymentReferenceRequest struct {
+	AccountID string `json:"account_id,omitempty" binding:"max=50"`
+	// Installments is how many weekly installments the reference covers;
+	// zero means one.
+	Installments   int    `json:"installments,omitempty" binding:"omitempty,min=1,max=52"`
+	IncludeArrears bool   `json:"include_arrears,omitempty"`
+	Currency       string `json:"currency,omitempty" binding:"omitempty,len=3,uppercase"`
+	ExpiresIn      int    `json:"expires_in,omitempty" binding:"omitempty,min=60,max=604800"`
+	// QR also returns an EMVCo/NQR QR payload for the reference.
+	QR bool `json:"qr,omitempty"`
+}
+
+const (
+	VirtualAccountActive = "ACTIVE"
+	VirtualAccountClosed = "CLOSED"
+)
+
+// VirtualAccount is a bank account number dedicated to one customer
+// account. Transfers into it are applied to that account.
+type VirtualAccount struct {
+	AccountNumber     string     `json:"account_number"`
+	CustomerID        string     `json:"customer_id"`
+	Provider          string     `json:"provider"`
+	BankName          string     `json:"bank_name"`
+	AccountName       string     `json:"account_name"`
+	ProviderReference string     `json:"provider_reference,omitempty"`
+	Status            string     `json:"status"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ClosedAt          *time.Time `json:"closed_at,omitempty"`
+}
+
+const (
+	CardActive  = "ACTIVE"
+	CardRevoked = "REVOKED"
+)
+
+// CardToken is a customer's card as saved with the gateway. Only the
+// gateway's token and display details are kept; card numbers never reach
+// this service.
+type CardToken struct {
+	ID         int64      `json:"id"`
+	CustomerID string     `json:"customer_id"`
+	Gateway    string     `json:"gateway"`
+	Token      string     `json:"-"`
+	Brand      string     `json:"brand,omitempty"`
+	Last4      string     `json:"last4"`
+	ExpMonth   int        `json:"exp_month"`
+	ExpYear    int        `json:"exp_year"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CardTokenRequest saves a card the gateway tokenized, e.g. from its hosted
+// card form or setup callback.
+type CardTokenRequest struct {
+	Token    string `json:"token" binding:"required,max=200"`
+	Brand    string `json:"brand,omitempty" binding:"max=20"`
+	Last4    string `json:"last4" binding:"required,len=4,numeric"`
+	ExpMonth int    `json:"exp_month" binding:"required,min=1,max=12"`
+	ExpYear  int    `json:"exp_year" binding:"required,min=2000,max=2100"`
+}
+
+const (
+	CardChargePending   = "PENDING"
+	CardChargeSucceeded = "SUCCEEDED"
+	CardChargeFailed    = "FAILED"
+	CardChargeCancelled = "CANCELLED"
+)
+
+// CardCharge is the automatic charge for one installment. Failed attempts
+// are retried until the charge succeeds, the installment is paid some other
+// way, or the retries run out.
+type CardCharge struct {
+	ID               int64     `json:"id"`
+	Reference        string    `json:"reference"`
+	CustomerID       string    `json:"customer_id"`
+	CardID           int64     `json:"card_id"`
+	Installment      int       `json:"installment"`
+	Amount           float64   `json:"amount"`
+	Status           string    `json:"status"`
+	Attempts         int       `json:"attempts"`
+	NextAttemptAt    time.Time `json:"next_attempt_at"`
+	GatewayReference string    `json:"gateway_reference,omitempty"`
+	FailureReason    string    `json:"failure_reason,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Asset is a financed asset type in the catalog. Price and DefaultTermWeeks
+// are applied to new and seeded accounts; repricing an asset doesn't change
+// existing accounts. MaxTermWeeks, when set, caps restructured terms.
+type Asset struct {
+	AssetType        string    `json:"asset_type" binding:"required,max=50"`
+	Name             string    `json:"name" binding:"required,max=100"`
+	Price            float64   `json:"price" binding:"required,gt=0"`
+	DefaultTermWeeks int       `json:"default_term_weeks" binding:"required,min=1,max=520"`
+	MaxTermWeeks     int       `json:"max_term_weeks,omitempty" binding:"omitempty,gtefield=DefaultTermWeeks,max=520"`
+	Active           bool      `json:"active"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// HolidayCalendar is a country's or tenant's business calendar. Installments
+// due on its weekend days or holidays shift to the next business day.
+// Weekend holds three-letter day names, e.g. ["SAT", "SUN"].
+type HolidayCalendar struct {
+	Code      string    `json:"code" binding:"required,max=32"`
+	Name      string    `json:"name" binding:"required,max=100"`
+	Weekend   []string  `json:"weekend" binding:"max=6"`
+	IsDefault bool      `json:"is_default"`
+	Holidays  []Holiday `json:"holidays,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Holiday is a public holiday; Date is YYYY-MM-DD.
+type Holiday struct {
+	Date string `json:"date"`
+	Name string `json:"name,omitempty" binding:"max=100"`
+}
+
+const (
+	MaxMetadataKeys   = 20
+	MaxMetadataKeyLen = 40
+	MaxMetadataBytes  = 4096
+)
+
+type Metadata map[string]interface{}
+
+func (m Metadata) Validate() error {
+	if len(m) > MaxMetadataKeys {
+		return fmt.Errorf("metadata may have at most %d keys", MaxMetadataKeys)
+	}
+	for key := range m {
+		if key == "" || len(key) > MaxMetadataKeyLen {
+			return fmt.Errorf("metadata keys must be 1-%d characters", MaxMetadataKeyLen)
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("metadata must be valid JSON: %v", err)
+	}
+	if len(data) > MaxMetadataBytes {
+		return fmt.Errorf("metadata may be at most %d bytes", MaxMetadataBytes)
+	}
+	return nil
+}
+
+type Agent struct {
+	AgentID        string    `json:"agent_id" binding:"required,max=50"`
+	Name           string    `json:"name" binding:"required,max=100"`
+	Phone          string    `json:"phone,omitempty" binding:"max=20"`
+	CommissionRate float64   `json:"commission_rate" binding:"min=0,max=1"`
+	SchemeID       string    `json:"scheme_id,omitempty" binding:"max=50"`
+	Active         bool      `json:"active"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type PayoutStatus string
+
+const (
+	PayoutPending    PayoutStatus = "PENDING"
+	PayoutProcessing PayoutStatus = "PROCESSING"
+	PayoutSucceeded  PayoutStatus = "SUCCEEDED"
+	PayoutFailed     PayoutStatus = "FAILED"
+)
+
+type PayoutRequest struct {
+	Reference   string            `json:"reference" binding:"required,max=100"`
+	PayoutType  string            `json:"payout_type" binding:"required,oneof=refund withdrawal commission"`
+	CustomerID  string            `json:"customer_id,omitempty" binding:"max=50"`
+	AgentID     string            `json:"agent_id,omitempty" binding:"max=50"`
+	Amount      float64           `json:"amount" binding:"required,gt=0"`
+	Currency    string            `json:"currency" binding:"omitempty,len=3,uppercase"`
+	Method      string            `json:"method" binding:"required,oneof=bank_transfer mobile_money"`
+	Destination map[string]string `json:"destination" binding:"required"`
+}
+
+type Payout struct {
+	ID                int64             `json:"id"`
+	Reference         string            `json:"reference"`
+	PayoutType        string            `json:"payout_type"`
+	CustomerID        string            `json:"customer_id,omitempty"`
+	AgentID           string            `json:"agent_id,omitempty"`
+	Amount            float64           `json:"amount"`
+	Currency          string            `json:"currency"`
+	Method            string            `json:"method"`
+	Destination       map[string]string `json:"destination"`
+	Status            PayoutStatus      `json:"status"`
+	Provider          string            `json:"provider,omitempty"`
+	ProviderReference string            `json:"provider_reference,omitempty"`
+	FailureReason     string            `json:"failure_reason,omitempty"`
+	Attempts          int               `json:"attempts"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
+
+type BankFeedStatus string
+
+const (
+	BankFeedMatched   BankFeedStatus = "MATCHED"
+	BankFeedUnmatched BankFeedStatus = "UNMATCHED"
+	BankFeedAssigned  BankFeedStatus = "ASSIGNED"
+	BankFeedIgnored   BankFeedStatus = "IGNORED"
+)
+
+// BankFeedTransaction is an inbound credit reported by a bank statement feed.
+type BankFeedTransaction struct {
+	ID               int64          `json:"id"`
+	Provider         string         `json:"provider"`
+	ExternalID       string         `json:"external_id"`
+	AccountID        string         `json:"account_id,omitempty"`
+	Amount           float64        `json:"amount"`
+	Currency         string         `json:"currency"`
+	Narration        string         `json:"narration,omitempty"`
+	Reference        string         `json:"reference,omitempty"`
+	TransactionDate  time.Time      `json:"transaction_date"`
+	Status           BankFeedStatus `json:"status"`
+	CustomerID       string         `json:"customer_id,omitempty"`
+	PaymentReference string         `json:"payment_reference,omitempty"`
+	ReviewedBy       string         `json:"reviewed_by,omitempty"`
+	ReviewNote       string         `json:"review_note,omitempty"`
+	ReviewedAt       *time.Time     `json:"reviewed_at,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+}
+
+type ImportStatus string
+
+const (
+	ImportRunning   ImportStatus = "RUNNING"
+	ImportCompleted ImportStatus = "COMPLETED"
+	ImportFailed    ImportStatus = "FAILED"
+	ImportCancelled ImportStatus = "CANCELLED"
+)
+
+type ImportRowError struct {
+	Row       int    `json:"row"`
+	Reference string `json:"reference,omitempty"`
+	Error     string `json:"error"`
+}
+
+// ImportBatch tracks one payment file run through the import pipeline.
+type ImportBatch struct {
+	ID            int64            `json:"id"`
+	Source        string           `json:"source"`
+	FileName      string           `json:"file_name"`
+	Status        ImportStatus     `json:"status"`
+	TotalRows     int              `json:"total_rows"`
+	QueuedRows    int              `json:"queued_rows"`
+	DuplicateRows int              `json:"duplicate_rows"`
+	FailedRows    int              `json:"failed_rows"`
+	Errors        []ImportRowError `json:"errors"`
+	Error         string           `json:"error,omitempty"`
+	StartedAt     time.Time        `json:"started_at"`
+	CompletedAt   *time.Time       `json:"completed_at,omitempty"`
+	// Owner is the instance running the import. The counts cover the file
+	// up to CheckpointRow, where an import taken over from a crashed
+	// instance resumes.
+	Owner             string     `json:"owner,omitempty"`
+	FileBytes         int64      `json:"file_bytes,omitempty"`
+	CheckpointRow     int        `json:"checkpoint_row"`
+	CheckpointOffset  int64      `json:"checkpoint_offset"`
+	CheckpointAt      *time.Time `json:"checkpoint_at,omitempty"`
+	ResumedAt         *time.Time `json:"resumed_at,omitempty"`
+	ResumedRows       int        `json:"resumed_rows,omitempty"`
+	CancelRequestedAt *time.Time `json:"cancel_requested_at,omitempty"`
+	CancelledBy       string     `json:"cancelled_by,omitempty"`
+	// Progress is filled in by GET /api/v1/admin/imports/:id.
+	Progress *ImportProgress `json:"progress,omitempty"`
+}
+
+// ImportProgress is how far an import has got and, while it runs, how fast
+// it is going. The rate covers the current run, since the import started
+// or was resumed.
+type ImportProgress struct {
+	RowsProcessed int   `json:"rows_processed"`
+	BytesRead     int64 `json:"bytes_read"`
+	// PercentComplete and ETASeconds need the file size, and ETASeconds a
+	// running import with some progress to go on.
+	PercentComplete *float64  `json:"percent_complete,omitempty"`
+	RowsPerSecond   float64   `json:"rows_per_second"`
+	ETASeconds      *float64  `json:"eta_seconds,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type ImportCancelRequest struct {
+	CancelledBy string `json:"cancelled_by" binding:"required,max=100"`
+}
+
+// MetadataImportBatchID is set on imported payments to the ID of their
+// import batch; processed transactions are tagged with it.
+const MetadataImportBatchID = "import_batch_id"
+
+type ImportReversalStatus string
+
+const (
+	ImportReversalPending   ImportReversalStatus = "PENDING"
+	ImportReversalCompleted ImportReversalStatus = "COMPLETED"
+	ImportReversalRejected  ImportReversalStatus = "REJECTED"
+)
+
+type ImportReversalRequest struct {
+	Reason      string `json:"reason" binding:"required,max=500"`
+	RequestedBy string `json:"requested_by" binding:"required,max=100"`
+}
+
+// ImportReversal takes every payment of an import batch back off its
+// accounts. Payments and TotalAmount are what the batch held when it was
+// requested, and what was reversed once it completes.
+type ImportReversal struct {
+	ID            int64                `json:"id"`
+	ImportBatchID int64                `json:"import_batch_id"`
+	Status        ImportReversalStatus `json:"status"`
+	Reason        string               `json:"reason"`
+	RequestedBy   string               `json:"requested_by"`
+	DecidedBy     string               `json:"decided_by,omitempty"`
+	DecisionNote  string               `json:"decision_note,omitempty"`
+	Payments      int                  `json:"payments"`
+	TotalAmount   float64              `json:"total_amount"`
+	RequestedAt   time.Time            `json:"requested_at"`
+	DecidedAt     *time.Time           `json:"decided_at,omitempty"`
+}
+
+// FeatureFlag gates a processing change. An enabled flag applies to its
+// targets (customer IDs or payment channels) and to Percentage of all other
+// customers, picked by a stable hash of the customer ID.
+type FeatureFlag struct {
+	Name        string    `json:"name"`
+	Enabled     bool      `json:"enabled"`
+	Percentage  int       `json:"percentage" binding:"min=0,max=100"`
+	Targets     []string  `json:"targets,omitempty"`
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// MaintenanceMode is the maintenance switch shared by every instance. While
+// it is on, mutating requests get 503 with Message; reads still work. Until
+// is when the window is expected to end, sent as Retry-After.
+type MaintenanceMode struct {
+	Enabled   bool       `json:"enabled"`
+	Message   string     `json:"message,omitempty" binding:"max=500"`
+	Until     *time.Time `json:"until,omitempty"`
+	UpdatedBy string     `json:"updated_by" binding:"required,max=100"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// FieldViolation is one failed rule on a request field. Field is the JSON
+// path, e.g. "allocations[1].customer_id"; Code is the rule name, stable
+// across locales.
+type FieldViolation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationErrorResponse is the 400 body for requests that fail binding.
+type ValidationErrorResponse struct {
+	Error      string           `json:"error"`
+	Code       string           `json:"code"`
+	Violations []FieldViolation `json:"violations,omitempty"`
+}
+
+// PaymentEvent is one payment applied to an account, dated by when it took
+// effect: its value date, or when it was processed if that was later.
+type PaymentEvent struct {
+	Date   time.Time `json:"date"`
+	Amount float64   `json:"amount"`
+}
+
+const (
+	TemplateSMS     = "sms"
+	TemplateEmail   = "email"
+	TemplateReceipt = "receipt"
+	TemplateVoice   = "voice"
+)
+
+// MessageTemplate is one version of the copy for a message, e.g.
+// "dunning_final_notice", in one locale. Subject and Body are Go templates
+// over the declared Variables, written as {{.amount}}. Saving creates a new
+// version; the latest version per locale is the one used.
+type MessageTemplate struct {
+	TemplateID string    `json:"template_id"`
+	Locale     string    `json:"locale" binding:"omitempty,oneof=en fr sw"`
+	Version    int       `json:"version"`
+	Channel    string    `json:"channel" binding:"required,oneof=sms email receipt voice"`
+	Subject    string    `json:"subject,omitempty" binding:"max=200"`
+	Body       string    `json:"body" binding:"required,max=5000"`
+	Variables  []string  `json:"variables" binding:"max=30,dive,min=1,max=50"`
+	UpdatedBy  string    `json:"updated_by" binding:"required,max=100"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RenderedMessage is a template filled in with variable values.
+type RenderedMessage struct {
+	TemplateID string `json:"template_id"`
+	Locale     string `json:"locale"`
+	Version    int    `json:"version"`
+	Channel    string `json:"channel"`
+	Subject    string `json:"subject,omitempty"`
+	Body       string `json:"body"`
+}
+
+// TemplatePreview renders a template with sample values. Version 0 is the
+// latest; Recipient is only used by test sends.
+type TemplatePreview struct {
+	Locale    string            `json:"locale" binding:"omitempty,oneof=en fr sw"`
+	Version   int               `json:"version" binding:"gte=0"`
+	Variables map[string]string `json:"variables"`
+	Recipient string            `json:"recipient" binding:"max=254"`
+}
+
+// SavedReport is an admin-defined portfolio report: customer accounts grouped
+// by Dimensions with Measures aggregated per group, narrowed by Filters.
+// Dimension, measure and filter names come from a fixed whitelist. Reports
+// with a Schedule (HOURLY, DAILY or WEEKLY) run on their own and are sent to
+// every configured Delivery target; others only run on demand.
+type SavedReport struct {
+	ReportID      string            `json:"report_id"`
+	Name          string            `json:"name" binding:"required,max=100"`
+	Dimensions    []string          `json:"dimensions" binding:"max=5"`
+	Measures      []string          `json:"measures" binding:"required,min=1,max=10"`
+	Filters       map[string]string `json:"filters,omitempty"`
+	Schedule      string            `json:"schedule,omitempty" binding:"omitempty,oneof=HOURLY DAILY WEEKLY"`
+	Delivery      ReportDelivery    `json:"delivery"`
+	CreatedBy     string            `json:"created_by" binding:"required,max=100"`
+	NextRunAt     *time.Time        `json:"next_run_at,omitempty"`
+	LastRunAt     *time.Time        `json:"last_run_at,omitempty"`
+	LastRunStatus string            `json:"last_run_status,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// ReportDelivery lists where scheduled runs of a saved report are sent.
+type ReportDelivery struct {
+	Email      string `json:"email,omitempty" binding:"omitempty,email,max=255"`
+	WebhookURL string `json:"webhook_url,omitempty" binding:"omitempty,url,max=500"`
+	Storage    bool   `json:"storage,omitempty"`
+}
+
+// ReportResult is one run of a saved report: a row per group, with the
+// dimension values followed by the measures, in Columns order.
+type ReportResult struct {
+	ReportID    string          `json:"report_id"`
+	Name        string          `json:"name"`
+	Columns     []string        `json:"columns"`
+	Rows        [][]interface{} `json:"rows"`
+	Truncated   bool            `json:"truncated,omitempty"`
+	GeneratedAt time.Time       `json:"generated_at"`
+}
+
+// AMLAlert is a compliance alert raised when a customer's payments cross an
+// anti-money-laundering reporting threshold. Amount is the triggering
+// payment for SINGLE_PAYMENT alerts and the customer's total over the
+// window for AGGREGATE alerts.
+type AMLAlert struct {
+	ID                   int64      `json:"id"`
+	CustomerID           string     `json:"customer_id"`
+	Rule                 string     `json:"rule"`
+	TransactionReference string     `json:"transaction_reference"`
+	Amount               float64    `json:"amount"`
+	Threshold            float64    `json:"threshold"`
+	WindowStart          *time.Time `json:"window_start,omitempty"`
+	Status               string     `json:"status"`
+	Disposition          string     `json:"disposition,omitempty"`
+	ReviewedBy           string     `json:"reviewed_by,omitempty"`
+	Notes                string     `json:"notes,omitempty"`
+	TriggeredAt          time.Time  `json:"triggered_at"`
+	ReviewedAt           *time.Time `json:"reviewed_at,omitempty"`
+	ClosedAt             *time.Time `json:"closed_at,omitempty"`
+}
+
+const (
+	AMLRuleSinglePayment = "SINGLE_PAYMENT"
+	AMLRuleAggregate     = "AGGREGATE"
+
+	AMLStatusOpen        = "OPEN"
+	AMLStatusUnderReview = "UNDER_REVIEW"
+	AMLStatusClosed      = "CLOSED"
+
+	// AMLDispositionReported means a report was filed with the regulator.
+	AMLDispositionReported      = "REPORTED"
+	AMLDispositionFalsePositive = "FALSE_POSITIVE"
+)
+
+// ScreeningEntry is one sanctions/blacklist entry. CUSTOMER_ID entries match
+// the account, PHONE entries any phone identifier mapped to it, and
+// REFERENCE_PATTERN entries the transaction reference as a case-insensitive
+// SQL LIKE pattern, e.g. "SCAM-%". Phone values are only returned masked.
+type ScreeningEntry struct {
+	ID        int64     `json:"id"`
+	EntryType string    `json:"entry_type" binding:"required,oneof=CUSTOMER_ID PHONE REFERENCE_PATTERN"`
+	Value     string    `json:"value" binding:"required,max=100"`
+	Reason    string    `json:"reason" binding:"required,max=500"`
+	AddedBy   string    `json:"added_by" binding:"required,max=100"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	ScreeningCustomerID       = "CUSTOMER_ID"
+	ScreeningPhone            = "PHONE"
+	ScreeningReferencePattern = "REFERENCE_PATTERN"
+	// ScreeningRule is the entry type of holds placed by a HOLD payment
+	// rule; their matched value is the rule name.
+	ScreeningRule = "RULE"
+)
+
+// ScreeningHold is a payment that matched a screening entry and is held,
+// unapplied, until compliance releases or rejects it.
+type ScreeningHold struct {
+	ID                   int64          `json:"id"`
+	TransactionReference string         `json:"transaction_reference"`
+	CustomerID           string         `json:"customer_id"`
+	EntryID              *int64         `json:"entry_id,omitempty"`
+	EntryType            string         `json:"entry_type"`
+	MatchedValue         string         `json:"matched_value"`
+	Payment              PaymentPayload `json:"payment"`
+	Status               string         `json:"status"`
+	ReviewedBy           string         `json:"reviewed_by,omitempty"`
+	Notes                string         `json:"notes,omitempty"`
+	CreatedAt            time.Time      `json:"created_at"`
+	ReviewedAt           *time.Time     `json:"reviewed_at,omitempty"`
+}
+
+const (
+	HoldStatusHeld     = "HELD"
+	HoldStatusReleased = "RELEASED"
+	HoldStatusRejected = "REJECTED"
+)
+
+// MetadataCustomerUnverified marks a payment accepted without checking its
+// customer because the database was unreachable. The processor checks the
+// customer instead and sends payments for unknown customers to suspense.
+const MetadataCustomerUnverified = "customer_unverified"
+
+// SuspensePayment is an unverified payment whose customer turned out not to
+// exist. It stays unapplied until it is assigned to an account or rejected.
+type SuspensePayment struct {
+	ID                   int64          `json:"id"`
+	TransactionReference string         `json:"transaction_reference"`
+	CustomerID           string         `json:"customer_id"`
+	Amount               float64        `json:"amount"`
+	Payment              PaymentPayload `json:"payment"`
+	Reason               string         `json:"reason"`
+	Status               string         `json:"status"`
+	AssignedCustomerID   string         `json:"assigned_customer_id,omitempty"`
+	ReviewedBy           string         `json:"reviewed_by,omitempty"`
+	ReviewNote           string         `json:"review_note,omitempty"`
+	ReviewedAt           *time.Time     `json:"reviewed_at,omitempty"`
+	CreatedAt            time.Time      `json:"created_at"`
+}
+
+const (
+	SuspenseOpen     = "OPEN"
+	SuspenseAssigned = "ASSIGNED"
+	SuspenseRejected = "REJECTED"
+)
+
+// LedgerDrift is an account whose total_paid or payment_count disagreed with
+// its processed transactions when the ledger check ran. Repaired says the
+// account was reset to the ledger's figures.
+type LedgerDrift struct {
+	ID                   int64     `json:"id"`
+	CustomerID           string    `json:"customer_id"`
+	RecordedTotalPaid    float64   `json:"recorded_total_paid"`
+	LedgerTotalPaid      float64   `json:"ledger_total_paid"`
+	RecordedPaymentCount int       `json:"recorded_payment_count"`
+	LedgerPaymentCount   int       `json:"ledger_payment_count"`
+	Repaired             bool      `json:"repaired"`
+	DetectedAt           time.Time `json:"detected_at"`
+}
+
+// TransactionArchive is one Parquet file of processed transactions that the
+// archive job moved to blob storage.
+type TransactionArchive struct {
+	ID               int64     `json:"id"`
+	Month            string    `json:"month"`
+	ObjectKey        string    `json:"object_key"`
+	Rows             int       `json:"rows"`
+	TotalAmount      float64   `json:"total_amount"`
+	FirstProcessedAt time.Time `json:"first_processed_at"`
+	LastProcessedAt  time.Time `json:"last_processed_at"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// PaymentRule is an admin-configured rule the processor evaluates before
+// applying a payment. It matches when all its conditions hold, and rules
+// are evaluated in Position order.
+type PaymentRule struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name" binding:"required,max=100"`
+	Description string          `json:"description,omitempty" binding:"max=500"`
+	Position    int             `json:"position" binding:"gte=0"`
+	Enabled     bool            `json:"enabled"`
+	Conditions  []RuleCondition `json:"conditions" binding:"required,min=1,max=20,dive"`
+	Action      string          `json:"action" binding:"required,oneof=HOLD PRIORITY ALLOCATE NOTIFY"`
+	// Priority is the level PRIORITY rules give the payment.
+	Priority string `json:"priority,omitempty" binding:"required_if=Action PRIORITY,omitempty,oneof=high low"`
+	// TargetCustomerID is the account ALLOCATE rules apply the payment to.
+	TargetCustomerID string `json:"target_customer_id,omitempty" binding:"required_if=Action ALLOCATE,omitempty,startswith=GIG,max=50"`
+	// Message goes in the alert NOTIFY rules send.
+	Message   string    `json:"message,omitempty" binding:"max=500"`
+	UpdatedBy string    `json:"updated_by" binding:"required,max=100"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RuleCondition compares one attribute of the payment or its account with
+// Value. For in and not_in, Value is a comma-separated list; gt, gte, lt
+// and lte compare numerically.
+type RuleCondition struct {
+	Field    string `json:"field" binding:"required,oneof=amount channel currency customer_id reference agent_id region branch product_id asset_type outstanding_balance risk_score"`
+	Operator string `json:"operator" binding:"required,oneof=eq ne gt gte lt lte in not_in"`
+	Value    string `json:"value" binding:"max=500"`
+}
+
+const (
+	// RuleHold holds the payment for review with the screening holds.
+	RuleHold = "HOLD"
+	// RulePriority tags the payment with a priority in its metadata.
+	RulePriority = "PRIORITY"
+	// RuleAllocate applies the payment to another account.
+	RuleAllocate = "ALLOCATE"
+	// RuleNotify alerts operations and lets the payment through.
+	RuleNotify = "NOTIFY"
+)
+
+// DuplicateCandidate is a pair of accounts that look like the same customer.
+// Signals name what matched, e.g. "metadata:phone", "identifier:phone" or
+// "profile" (same asset, deployment day, region, branch and metadata).
+type DuplicateCandidate struct {
+	CustomerIDs []string `json:"customer_ids"`
+	Signals     []string `json:"signals"`
+}
+
+// CustomerMerge records one duplicate account folded into a surviving one.
+// Duplicate is the duplicate account as it stood before the merge; Moved
+// counts the rows re-pointed at the survivor per table, and Discarded the
+// duplicate's rows dropped because the survivor already had one.
+type CustomerMerge struct {
+	ID          int64            `json:"id"`
+	SurvivorID  string           `json:"survivor_id"`
+	DuplicateID string           `json:"duplicate_id"`
+	Duplicate   *CustomerAccount `json:"duplicate"`
+	Moved       map[string]int64 `json:"moved"`
+	Discarded   map[string]int64 `json:"discarded,omitempty"`
+	MergedBy    string           `json:"merged_by"`
+	Reason      string           `json:"reason"`
+	MergedAt    time.Time        `json:"merged_at"`
+}
+
+// CustomerNote is a free-text note a support agent keeps on an account,
+// or on one of its payments when TransactionReference is set.
+type CustomerNote struct {
+	ID                   int64      `json:"id"`
+	CustomerID           string     `json:"customer_id"`
+	TransactionReference string     `json:"transaction_reference,omitempty"`
+	Author               string     `json:"author" binding:"required,max=100"`
+	Body                 string     `json:"body" binding:"required,max=2000"`
+	Tags                 []string   `json:"tags" binding:"max=20,dive,min=1,max=50"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            *time.Time `json:"updated_at,omitempty"`
+}
+
+// CustomerNoteUpdate changes a note's body or tags; omitted fields are
+// left alone and an empty tags list clears them.
+type CustomerNoteUpdate struct {
+	Body *string  `json:"body" binding:"omitempty,min=1,max=2000"`
+	Tags []string `json:"tags" binding:"omitempty,max=20,dive,min=1,max=50"`
+}
+
+const (
+	NotificationSent     = "SENT"
+	NotificationDeferred = "DEFERRED"
+	NotificationFailed   = "FAILED"
+)
+
+// NotificationRecord is one notification sent, held for quiet hours or
+// failed, without its recipient or body. Template is the message template
+// or i18n key it was rendered from, if any. ProviderMessageID is set for
+// providers that report delivery, such as WhatsApp.
+type NotificationRecord struct {
+	CustomerID        string `json:"customer_id"`
+	Channel           string `json:"channel"`
+	Template          string `json:"template,omitempty"`
+	Subject           string `json:"subject,omitempty"`
+	Status            string `json:"status"`
+	Error             string `json:"error,omitempty"`
+	ProviderMessageID string `json:"provider_message_id,omitempty"`
+}
+
+// Delivery statuses reported by providers for sent notifications. They
+// only move forward, except that FAILED can follow SENT.
+const (
+	DeliverySent      = "SENT"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryRead      = "READ"
+	DeliveryFailed    = "FAILED"
+)
+
+// TimelineEvent is one entry in an account's activity feed. Amount and
+// Reference are set for events about money; Actor is who did it, when
+// known.
+type TimelineEvent struct {
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Amount     *float64  `json:"amount,omitempty"`
+	Reference  string    `json:"reference,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Details    Metadata  `json:"details,omitempty"`
+}
+
+type DisputeStatus string
+
+const (
+	DisputeOpen            DisputeStatus = "OPEN"
+	DisputeInvestigating   DisputeStatus = "INVESTIGATING"
+	DisputeResolvedInFavor DisputeStatus = "RESOLVED_IN_FAVOR"
+	DisputeResolvedAgainst DisputeStatus = "RESOLVED_AGAINST"
+)
+
+// Resolution actions. A dispute resolved in the customer's favour reverses
+// the payment or credits an adjustment; one resolved against them takes no
+// action.
+const (
+	DisputeActionReversal   = "REVERSAL"
+	DisputeActionAdjustment = "ADJUSTMENT"
+	DisputeActionNone       = "NONE"
+)
+
+// MetadataDisputeID is set on adjustment payments to the ID of the dispute
+// that credited them.
+const MetadataDisputeID = "dispute_id"
+
+type DisputeRequest struct {
+	Reason   string `json:"reason" binding:"required,max=2000"`
+	OpenedBy string `json:"opened_by" binding:"required,max=100"`
+}
+
+// DisputeResolution closes a dispute. Resolving in the customer's favour
+// needs an action, and an adjustment needs its amount.
+type DisputeResolution struct {
+	Status           DisputeStatus `json:"status" binding:"required,oneof=RESOLVED_IN_FAVOR RESOLVED_AGAINST"`
+	Action           string        `json:"action" binding:"omitempty,oneof=REVERSAL ADJUSTMENT NONE"`
+	AdjustmentAmount float64       `json:"adjustment_amount" binding:"omitempty,gt=0"`
+	ResolvedBy       string        `json:"resolved_by" binding:"required,max=100"`
+	Note             string        `json:"note" binding:"required,max=2000"`
+}
+
+// Dispute is a customer's challenge to one of their payments. Amount is
+// the disputed payment's.
+type Dispute struct {
+	ID                   int64         `json:"id"`
+	CustomerID           string        `json:"customer_id"`
+	TransactionReference string        `json:"transaction_reference"`
+	Amount               float64       `json:"amount"`
+	Reason               string        `json:"reason"`
+	OpenedBy             string        `json:"opened_by"`
+	Status               DisputeStatus `json:"status"`
+	AssignedTo           string        `json:"assigned_to,omitempty"`
+	ResolutionAction     string        `json:"resolution_action,omitempty"`
+	AdjustmentAmount     *float64      `json:"adjustment_amount,omitempty"`
+	AdjustmentReference  string        `json:"adjustment_reference,omitempty"`
+	ResolvedBy           string        `json:"resolved_by,omitempty"`
+	ResolutionNote       string        `json:"resolution_note,omitempty"`
+	OpenedAt             time.Time     `json:"opened_at"`
+	InvestigatingAt      *time.Time    `json:"investigating_at,omitempty"`
+	ResolvedAt           *time.Time    `json:"resolved_at,omitempty"`
+}
+
+// DisputeAgingBucket counts unresolved disputes by how long they have been
+// open.
+type DisputeAgingBucket struct {
+	Bucket        string  `json:"bucket"`
+	Open          int     `json:"open"`
+	Investigating int     `json:"investigating"`
+	Amount        float64 `json:"amount"`
+}
+
+type SettlementBatchStatus string
+
+const (
+	// SettlementOpen days have no batch yet, or were never closed.
+	SettlementOpen     SettlementBatchStatus = "OPEN"
+	SettlementClosed   SettlementBatchStatus = "CLOSED"
+	SettlementReopened SettlementBatchStatus = "REOPENED"
+)
+
+// SettlementBatch summarises one business day's processed transactions.
+// A closed batch's totals are those at closing; an open day's are live.
+// Payments and TotalAmount exclude the day's adjustments. FeeTotal is the
+// net amount of the day's fees, before FeeTax.
+type SettlementBatch struct {
+	BusinessDate    string                `json:"business_date"`
+	Status          SettlementBatchStatus `json:"status"`
+	Payments        int                   `json:"payments"`
+	TotalAmount     float64               `json:"total_amount"`
+	Customers       int                   `json:"customers"`
+	Adjustments     int                   `json:"adjustments"`
+	AdjustmentTotal float64               `json:"adjustment_total"`
+	Fees            int                   `json:"fees"`
+	FeeTotal        float64               `json:"fee_total"`
+	FeeTax          float64               `json:"fee_tax"`
+	ClosedBy        string                `json:"closed_by,omitempty"`
+	ClosedAt        *time.Time            `json:"closed_at,omitempty"`
+	ReopenedBy      string                `json:"reopened_by,omitempty"`
+	ReopenReason    string                `json:"reopen_reason,omitempty"`
+	ReopenedAt      *time.Time            `json:"reopened_at,omitempty"`
+}
+
+// SettlementAdjustment corrects an account with a dated transaction on the
+// current business day: a positive amount credits the account, a negative
+// one takes the amount back off it.
+type SettlementAdjustment struct {
+	ID                int64     `json:"id"`
+	Reference         string    `json:"reference"`
+	BusinessDate      string    `json:"business_date"`
+	CustomerID        string    `json:"customer_id" binding:"required,max=50"`
+	Amount            float64   `json:"amount" binding:"required,ne=0"`
+	Reason            string    `json:"reason" binding:"required,max=500"`
+	CorrectsReference string    `json:"corrects_reference,omitempty" binding:"max=100"`
+	CreatedBy         string    `json:"created_by" binding:"required,max=100"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// GLJournalLine is one side of a GL journal entry. Entries summarise a
+// day's activity of one kind, such as payments or write-offs, and each
+// entry's debits equal its credits.
+type GLJournalLine struct {
+	Date    string  `json:"date"`
+	Journal string  `json:"journal"`
+	Kind    string  `json:"kind"`
+	Role    string  `json:"role"`
+	Account string  `json:"account"`
+	Debit   float64 `json:"debit"`
+	Credit  float64 `json:"credit"`
+	Count   int     `json:"count"`
+	Memo    string  `json:"memo"`
+}
+
+// TrialBalanceLine is one GL account's balance over a period. Balances are
+// debit positive.
+type TrialBalanceLine struct {
+	Account string   `json:"account"`
+	Roles   []string `json:"roles"`
+	Opening float64  `json:"opening"`
+	Debits  float64  `json:"debits"`
+	Credits float64  `json:"credits"`
+	Closing float64  `json:"closing"`
+}
+
+type TrialBalance struct {
+	From         string              `json:"from"`
+	To           string              `json:"to"`
+	Lines        []*TrialBalanceLine `json:"lines"`
+	TotalDebits  float64             `json:"total_debits"`
+	TotalCredits float64             `json:"total_credits"`
+}
+
+// GLExport records a journal and trial balance pushed to the accounting
+// system.
+type GLExport struct {
+	ID               int64     `json:"id"`
+	PeriodStart      string    `json:"period_start"`
+	PeriodEnd        string    `json:"period_end"`
+	Format           string    `json:"format"`
+	JournalFile      string    `json:"journal_file"`
+	TrialBalanceFile string    `json:"trial_balance_file"`
+	Lines            int       `json:"lines"`
+	TotalDebits      float64   `json:"total_debits"`
+	ExportedBy       string    `json:"exported_by"`
+	ExportedAt       time.Time `json:"exported_at"`
+}
+
+// FeeType configures a fee we charge and the tax on it. TaxRate is a
+// fraction, 0.075 for 7.5% VAT. With TaxInclusive, amounts charged include
+// the tax; otherwise the tax is added on top.
+type FeeType struct {
+	FeeType      string    `json:"fee_type" binding:"required,max=50"`
+	Name         string    `json:"name" binding:"required,max=100"`
+	TaxCode      string    `json:"tax_code" binding:"max=20"`
+	TaxRate      float64   `json:"tax_rate" binding:"min=0,lt=1"`
+	TaxInclusive bool      `json:"tax_inclusive"`
+	Active       bool      `json:"active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type FeeChargeRequest struct {
+	FeeType     string  `json:"fee_type" binding:"required,max=50"`
+	Amount      float64 `json:"amount" binding:"required,gt=0"`
+	Description string  `json:"description" binding:"max=500"`
+	ChargedBy   string  `json:"charged_by" binding:"required,max=100"`
+}
+
+// FeeCharge is one entry of the fee ledger. GrossAmount, NetAmount plus
+// TaxAmount, is what the account owes for it.
+type FeeCharge struct {
+	ID          int64     `json:"id"`
+	Reference   string    `json:"reference"`
+	CustomerID  string    `json:"customer_id"`
+	FeeType     string    `json:"fee_type"`
+	NetAmount   float64   `json:"net_amount"`
+	TaxCode     string    `json:"tax_code"`
+	TaxRate     float64   `json:"tax_rate"`
+	TaxAmount   float64   `json:"tax_amount"`
+	GrossAmount float64   `json:"gross_amount"`
+	Description string    `json:"description,omitempty"`
+	ChargedBy   string    `json:"charged_by"`
+	ChargedAt   time.Time `json:"charged_at"`
+}
+
+// FeeTaxSummary totals one day's fees of one type and tax code.
+type FeeTaxSummary struct {
+	Date        string  `json:"date"`
+	FeeType     string  `json:"fee_type"`
+	TaxCode     string  `json:"tax_code"`
+	Charges     int     `json:"charges"`
+	NetAmount   float64 `json:"net_amount"`
+	TaxAmount   float64 `json:"tax_amount"`
+	GrossAmount float64 `json:"gross_amount"`
+}
+
+// CommissionTier applies Rate to collections from From up to the next
+// tier's From.
+type CommissionTier struct {
+	From float64 `json:"from" binding:"min=0"`
+	Rate float64 `json:"rate" binding:"min=0,max=1"`
+}
+
+// CommissionScheme pays agents a marginal percentage of their collections
+// by tier, plus BonusAmount when at least BonusOnTimeRate of the customers
+// they collected from, and no fewer than BonusMinCustomers, are not in
+// arrears.
+type CommissionScheme struct {
+	SchemeID          string           `json:"scheme_id" binding:"required,max=50"`
+	Name              string           `json:"name" binding:"required,max=100"`
+	Tiers             []CommissionTier `json:"tiers" binding:"required,min=1,max=20,dive"`
+	BonusOnTimeRate   float64          `json:"bonus_on_time_rate" binding:"min=0,max=1"`
+	BonusMinCustomers int              `json:"bonus_min_customers" binding:"min=0"`
+	BonusAmount       float64          `json:"bonus_amount" binding:"min=0"`
+	Active            bool             `json:"active"`
+	CreatedAt         time.Time        `json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
+}
+
+type CommissionRunStatus string
+
+const (
+	CommissionRunCalculated CommissionRunStatus = "CALCULATED"
+	CommissionRunApproved   CommissionRunStatus = "APPROVED"
+)
+
+// CommissionRun is the commission calculation for the days PeriodStart
+// through PeriodEnd.
+type CommissionRun struct {
+	ID           int64               `json:"id"`
+	PeriodStart  string              `json:"period_start"`
+	PeriodEnd    string              `json:"period_end"`
+	Status       CommissionRunStatus `json:"status"`
+	Agents       int                 `json:"agents"`
+	Total        float64             `json:"total"`
+	CalculatedBy string              `json:"calculated_by"`
+	CalculatedAt time.Time           `json:"calculated_at"`
+	ApprovedBy   string              `json:"approved_by,omitempty"`
+	ApprovedAt   *time.Time          `json:"approved_at,omitempty"`
+	Lines        []*CommissionLine   `json:"lines,omitempty"`
+}
+
+// CommissionLine is one agent's payable commission in a run.
+type CommissionLine struct {
+	AgentID         string  `json:"agent_id"`
+	SchemeID        string  `json:"scheme_id,omitempty"`
+	Payments        int     `json:"payments"`
+	Customers       int     `json:"customers"`
+	Collected       float64 `json:"collected"`
+	Commission      float64 `json:"commission"`
+	OnTimeCustomers int     `json:"on_time_customers"`
+	Bonus           float64 `json:"bonus"`
+	Total           float64 `json:"total"`
+	PayoutReference string  `json:"payout_reference,omitempty"`
+}
+
+type AgentDepositStatus string
+
+const (
+	AgentDepositDeclared  AgentDepositStatus = "DECLARED"
+	AgentDepositConfirmed AgentDepositStatus = "CONFIRMED"
+	AgentDepositRejected  AgentDepositStatus = "REJECTED"
+)
+
+// AgentDepositRequest declares cash an agent paid in at the bank.
+// Reference is the bank's deposit slip or teller reference.
+type AgentDepositRequest struct {
+	Reference   string  `json:"reference" binding:"required,max=100"`
+	Amount      float64 `json:"amount" binding:"required,gt=0"`
+	Bank        string  `json:"bank" binding:"max=100"`
+	DepositedOn string  `json:"deposited_on" binding:"required"`
+	DeclaredBy  string  `json:"declared_by" binding:"required,max=100"`
+}
+
+type AgentDeposit struct {
+	ID          int64              `json:"id"`
+	Reference   string             `json:"reference"`
+	AgentID     string             `json:"agent_id"`
+	Amount      float64            `json:"amount"`
+	Bank        string             `json:"bank,omitempty"`
+	DepositedOn string             `json:"deposited_on"`
+	Status      AgentDepositStatus `json:"status"`
+	DeclaredBy  string             `json:"declared_by"`
+	DeclaredAt  time.Time          `json:"declared_at"`
+	ReviewedBy  string             `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time         `json:"reviewed_at,omitempty"`
+	Reason      string             `json:"reason,omitempty"`
+}
+
+// AgentCashPosition is the cash an agent has collected against what they
+// have deposited. Outstanding is the cash still in the agent's hands by
+// their own declarations, of which Unconfirmed is declared but not yet seen
+// on the bank statement. OldestUndeposited is the earliest collection day
+// not covered by deposits, taking deposits against the oldest collections
+// first.
+type AgentCashPosition struct {
+	AgentID           string   `json:"agent_id"`
+	Name              string   `json:"name"`
+	Collected         float64  `json:"collected"`
+	Deposited         float64  `json:"deposited"`
+	Unconfirmed       float64  `json:"unconfirmed"`
+	Outstanding       float64  `json:"outstanding"`
+	OldestUndeposited string   `json:"oldest_undeposited,omitempty"`
+	DaysOutstanding   int      `json:"days_outstanding"`
+	LastDepositOn     string   `json:"last_deposit_on,omitempty"`
+	Variances         []string `json:"variances,omitempty"`
+}
+
+type VoiceCallStatus string
+
+const (
+	VoiceCallQueued   VoiceCallStatus = "QUEUED"
+	VoiceCallPlaced   VoiceCallStatus = "PLACED"
+	VoiceCallAnswered VoiceCallStatus = "ANSWERED"
+	VoiceCallNoAnswer VoiceCallStatus = "NO_ANSWER"
+	VoiceCallBusy     VoiceCallStatus = "BUSY"
+	VoiceCallFailed   VoiceCallStatus = "FAILED"
+)
+
+// VoiceCall is a reminder call to a customer. It is QUEUED until the
+// provider accepts it, PLACED while it rings, and then ends ANSWERED,
+// NO_ANSWER, BUSY or FAILED.
+type VoiceCall struct {
+	ID              int64           `json:"id"`
+	Reference       string          `json:"reference"`
+	CustomerID      string          `json:"customer_id"`
+	TemplateID      string          `json:"template_id"`
+	Provider        string          `json:"provider,omitempty"`
+	ProviderCallID  string          `json:"provider_call_id,omitempty"`
+	Script          string          `json:"script,omitempty"`
+	Status          VoiceCallStatus `json:"status"`
+	DurationSeconds int             `json:"duration_seconds,omitempty"`
+	Detail          string          `json:"detail,omitempty"`
+	RequestedBy     string          `json:"requested_by"`
+	CreatedAt       time.Time       `json:"created_at"`
+	PlacedAt        *time.Time      `json:"placed_at,omitempty"`
+	EndedAt         *time.Time      `json:"ended_at,omitempty"`
+}
+
+// WebhookSubscriptionRequest registers or changes a webhook subscription.
+// EventTypes hold event types such as payout.SUCCEEDED, families such as
+// payout, or * for everything. Secret is the first signing key, generated
+// when none is given; later keys come from rotation.
+type WebhookSubscriptionRequest struct {
+	ClientID   string   `json:"client_id" binding:"required,max=100"`
+	URL        string   `json:"url" binding:"required,url,max=500"`
+	EventTypes []string `json:"event_types" binding:"required,min=1,dive,required,max=100"`
+	Secret     string   `json:"secret" binding:"omitempty,min=16,max=200"`
+	Active     *bool    `json:"active"`
+}
+
+// WebhookSubscription is an endpoint an API client has registered for
+// outbound events. Secret and KeyID are the signing key the subscription
+// was created with, only returned then.
+type WebhookSubscription struct {
+	ID         int64     `json:"id"`
+	ClientID   string    `json:"client_id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"secret,omitempty"`
+	KeyID      string    `json:"key_id,omitempty"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// WebhookSigningKey is a version of a subscription's signing secret. Keys
+// sign deliveries until they expire; rotating sets the previous keys to
+// expire after a grace period. Secret is only returned by rotation.
+type WebhookSigningKey struct {
+	SubscriptionID int64      `json:"subscription_id"`
+	KeyID          string     `json:"key_id"`
+	Secret         string     `json:"secret,omitempty"`
+	Active         bool       `json:"active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// WebhookEvent is an entry in the outbound event log. Its ID is the cursor
+// consumers page through the log and replay from.
+type WebhookEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// WebhookDelivery is one attempt to deliver an event to a subscription.
+// Test deliveries have no EventID; replayed ones are marked Replay.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	EventID        *int64          `json:"event_id,omitempty"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Test           bool            `json:"test,omitempty"`
+	Replay         bool            `json:"replay,omitempty"`
+	Success        bool            `json:"success"`
+	StatusCode     int             `json:"status_code,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	DurationMs     int             `json:"duration_ms"`
+	DeliveredAt    time.Time       `json:"delivered_at"`
+}
+
+// PaymentAttemptOutcome is how one processing attempt of a payment ended.
+type PaymentAttemptOutcome string
+
+const (
+	AttemptApplied   PaymentAttemptOutcome = "APPLIED"
+	AttemptDuplicate PaymentAttemptOutcome = "DUPLICATE"
+	AttemptRejected  PaymentAttemptOutcome = "REJECTED"
+	AttemptSuspended PaymentAttemptOutcome = "SUSPENDED"
+	AttemptRequeued  PaymentAttemptOutcome = "REQUEUED"
+	AttemptFailed    PaymentAttemptOutcome = "FAILED"
+)
+
+// PaymentAttempt is one time the processor took a payment. QueuedMs is how
+// long it had waited on the queue since it was last enqueued, and
+// VersionConflicts how often its balance update lost a race and was
+// retried. WorkerID is empty for payments processed synchronously.
+type PaymentAttempt struct {
+	ID                   int64                 `json:"id"`
+	TransactionReference string                `json:"transaction_reference"`
+	CustomerID           string                `json:"customer_id"`
+	Instance             string                `json:"instance,omitempty"`
+	WorkerID             *int                  `json:"worker_id,omitempty"`
+	Worker               string                `json:"worker,omitempty"`
+	StartedAt            time.Time             `json:"started_at"`
+	QueuedMs             *int64                `json:"queued_ms,omitempty"`
+	DurationMs           int                   `json:"duration_ms"`
+	VersionConflicts     int                   `json:"version_conflicts"`
+	Outcome              PaymentAttemptOutcome `json:"outcome"`
+	Error                string                `json:"error,omitempty"`
 }
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/calendar"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// bootstrapDefaults is the reference data a new environment starts with,
+// read from -defaults. Entries that already exist are left as they are, so
+// changes made since through the API survive a re-run.
+type bootstrapDefaults struct {
+	Assets               []api.Asset                      `json:"assets"`
+	Products             []api.LoanProduct                `json:"products"`
+	Calendars            []api.HolidayCalendar            `json:"calendars"`
+	WebhookSubscriptions []api.WebhookSubscriptionRequest `json:"webhook_subscriptions"`
+}
+
+// runBootstrap implements `bootstrap`: bring a database up to the current
+// schema, load the default reference data, optionally seed demo accounts
+// and check Postgres and Redis answer. Every step is safe to repeat, so it
+// can run on each deploy. The service has no tenants or API keys of its
+// own; API clients are the client IDs on webhook subscriptions, which the
+// defaults file can register. It exits 0 on success and 2 on errors.
+func runBootstrap(ctx context.Context, config *tools.Config, args []string) int {
+	flags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	schemaPath := flags.String("schema", "db/init.sql", "schema applied to an empty database")
+	migrationsDir := flags.String("migrations", "db/migrations", "directory of migrations applied after it")
+	baseline := flags.String("baseline", "", "for a database from before migrations were recorded: the last migration it already has")
+	defaultsPath := flags.String("defaults", "", "JSON file of default assets, products, calendars and webhook subscriptions")
+	demo := flags.Int("demo", 0, "seed this many demo accounts (GIG00001 upwards; existing ones are kept)")
+	demoPaid := flags.Float64("demo-paid-percent", 60, "percentage of demo accounts given a payment history")
+	flags.Parse(args)
+
+	fail := func(format string, args ...interface{}) int {
+		fmt.Fprintf(os.Stderr, "bootstrap: "+format+"\n", args...)
+		return 2
+	}
+
+	db, err := tools.OpenDatabaseService(ctx, config)
+	if err != nil {
+		return fail("invalid DATABASE_URL: %v", err)
+	}
+	defer db.Close()
+	redisService, err := tools.OpenRedisService(config.RedisURL)
+	if err != nil {
+		return fail("invalid REDIS_URL: %v", err)
+	}
+	defer redisService.Close()
+
+	backoff := tools.Backoff{
+		Initial: config.StartupRetryInitial,
+		Max:     config.StartupRetryMax,
+		MaxWait: config.StartupMaxWait,
+	}
+	if err := waitForDependencies(ctx, backoff, db, redisService); err != nil {
+		return fail("%v", err)
+	}
+
+	if err := migrate(ctx, db, *schemaPath, *migrationsDir, *baseline); err != nil {
+		return fail("%v", err)
+	}
+
+	keyProvider, err := tools.NewKeyProvider(config)
+	if err != nil {
+		return fail("failed to configure PII key provider: %v", err)
+	}
+	if keyProvider != nil {
+		if err := db.EnablePII(ctx, keyProvider, config.PIIIndexKey); err != nil {
+			return fail("failed to enable PII encryption: %v", err)
+		}
+	}
+
+	if *defaultsPath != "" {
+		if err := loadDefaults(ctx, db, *defaultsPath); err != nil {
+			return fail("defaults: %v", err)
+		}
+	}
+
+	if *demo > 0 {
+		result, err := db.SeedCustomers(ctx, tools.SeedOptions{Count: *demo, PaidPercent: *demoPaid, OnTimeRate: 0.85})
+		if err != nil {
+			return fail("demo data: %v", err)
+		}
+		fmt.Printf("Demo data: %d accounts created, %d with %d payments\n", result.Created, result.PaidAccounts, result.Payments)
+	}
+
+	latency, err := db.Ping(ctx)
+	if err != nil {
+		return fail("postgres: %v", err)
+	}
+	if err := redisService.Client.Ping(ctx).Err(); err != nil {
+		return fail("redis: %v", err)
+	}
+	fmt.Printf("Bootstrap complete: Postgres answered in %s, Redis is up\n", latency)
+	return 0
+}
+
+// migrate applies the schema to an empty database, or the migrations it
+// hasn't had to an existing one.
+func migrate(ctx context.Context, db *tools.DatabaseService, schemaPath, migrationsDir, baseline string) error {
+	migrations, err := tools.LoadMigrations(migrationsDir)
+	if err != nil {
+		return err
+	}
+
+	initialized, err := db.SchemaInitialized(ctx)
+	if err != nil {
+		return err
+	}
+	if !initialized {
+		if err := db.ApplySQLFile(ctx, schemaPath); err != nil {
+			return fmt.Errorf("apply schema: %w", err)
+		}
+		fmt.Printf("Schema: applied %s\n", schemaPath)
+	}
+
+	applied, tracked, err := db.AppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if !tracked || baseline != "" {
+		// Migrations were run by hand before they were recorded, and not
+		// all of them are safe to run twice.
+		if baseline == "" {
+			return errors.New("this database predates recorded migrations; rerun with -baseline set to the last migration it has (e.g. 005_notification_delivery_status)")
+		}
+		var versions []string
+		for _, m := range migrations {
+			if m.Version <= baseline {
+				versions = append(versions, m.Version)
+			}
+		}
+		if len(versions) == 0 || versions[len(versions)-1] != baseline {
+			return fmt.Errorf("-baseline %s is not a migration in %s", baseline, migrationsDir)
+		}
+		if err := db.RecordMigrations(ctx, versions...); err != nil {
+			return err
+		}
+		if applied == nil {
+			applied = map[string]bool{}
+		}
+		for _, version := range versions {
+			applied[version] = true
+		}
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := db.ApplySQLFile(ctx, m.Path); err != nil {
+			return fmt.Errorf("migration %w", err)
+		}
+		if err := db.RecordMigrations(ctx, m.Version); err != nil {
+			return err
+		}
+		fmt.Printf("Migration: applied %s\n", m.Version)
+		pending++
+	}
+	fmt.Printf("Schema: %d migrations known, %d applied now\n", len(migrations), pending)
+	return nil
+}
+
+func loadDefaults(ctx context.Context, db *tools.DatabaseService, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var defaults bootstrapDefaults
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i := range defaults.Assets {
+		asset := &defaults.Assets[i]
+		created, err := createIfMissing(asset, func() error { _, err := db.GetAsset(ctx, asset.AssetType); return err }, func() error {
+			_, err := db.SaveAsset(ctx, asset)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("asset %s: %w", asset.AssetType, err)
+		}
+		report("asset", asset.AssetType, created)
+	}
+
+	for i := range defaults.Products {
+		product := &defaults.Products[i]
+		created, err := createIfMissing(product, func() error { _, err := db.GetLoanProduct(ctx, product.ProductID); return err }, func() error {
+			return db.CreateLoanProduct(ctx, product)
+		})
+		if err != nil {
+			return fmt.Errorf("product %s: %w", product.ProductID, err)
+		}
+		report("product", product.ProductID, created)
+	}
+
+	for i := range defaults.Calendars {
+		cal := &defaults.Calendars[i]
+		if cal.Weekend == nil {
+			cal.Weekend = []string{"SAT", "SUN"}
+		}
+		weekend, err := calendar.ParseWeekend(strings.Join(cal.Weekend, ","))
+		if err != nil {
+			return fmt.Errorf("calendar %s: weekend: %w", cal.Code, err)
+		}
+		cal.Weekend = cal.Weekend[:0]
+		for _, day := range weekend {
+			cal.Weekend = append(cal.Weekend, strings.ToUpper(day.String()[:3]))
+		}
+		for _, holiday := range cal.Holidays {
+			if _, err := time.Parse("2006-01-02", holiday.Date); err != nil {
+				return fmt.Errorf("calendar %s: holiday date %q must be YYYY-MM-DD", cal.Code, holiday.Date)
+			}
+		}
+		created, err := createIfMissing(cal, func() error { _, err := db.GetHolidayCalendar(ctx, cal.Code); return err }, func() error {
+			if _, err := db.SaveHolidayCalendar(ctx, cal); err != nil {
+				return err
+			}
+			for _, holiday := range cal.Holidays {
+				if err := db.SaveHoliday(ctx, cal.Code, holiday); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("calendar %s: %w", cal.Code, err)
+		}
+		report("calendar", cal.Code, created)
+	}
+
+	for i := range defaults.WebhookSubscriptions {
+		request := &defaults.WebhookSubscriptions[i]
+		name := request.ClientID + " " + request.URL
+		if err := binding.Validator.ValidateStruct(request); err != nil {
+			return fmt.Errorf("webhook subscription %s: %w", name, err)
+		}
+		if err := tools.ValidateWebhookEventTypes(request.EventTypes); err != nil {
+			return fmt.Errorf("webhook subscription %s: %w", name, err)
+		}
+		existing, err := db.ListWebhookSubscriptions(ctx, request.ClientID)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, subscription := range existing {
+			found = found || subscription.URL == request.URL
+		}
+		if found {
+			report("webhook subscription", name, false)
+			continue
+		}
+		subscription, err := db.CreateWebhookSubscription(ctx, request)
+		if err != nil {
+			return fmt.Errorf("webhook subscription %s: %w", name, err)
+		}
+		// The secret is only ever shown here; hand it to the client.
+		fmt.Printf("Defaults: created webhook subscription %d for %s, signing key %s secret %s\n",
+			subscription.ID, name, subscription.KeyID, subscription.Secret)
+	}
+	return nil
+}
+
+// createIfMissing validates entry and creates it unless get finds it.
+func createIfMissing(entry interface{}, get, create func() error) (bool, error) {
+	if err := binding.Validator.ValidateStruct(entry); err != nil {
+		return false, err
+	}
+	err := get()
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, tools.ErrNotFound) {
+		return false, err
+	}
+	return true, create()
+}
+
+func report(kind, name string, created bool) {
+	if created {
+		fmt.Printf("Defaults: created %s %s\n", kind, name)
+	} else {
+		fmt.Printf("Defaults: %s %s already exists, left unchanged\n", kind, name)
+	}
+}
@@ -2,49 +2,661 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/abjerry97/go_payment/internal/alerting"
+	"github.com/abjerry97/go_payment/internal/aml"
+	"github.com/abjerry97/go_payment/internal/bankfeeds"
+	"github.com/abjerry97/go_payment/internal/calendar"
+	"github.com/abjerry97/go_payment/internal/cards"
+	"github.com/abjerry97/go_payment/internal/dunning"
+	"github.com/abjerry97/go_payment/internal/flags"
+	"github.com/abjerry97/go_payment/internal/imports"
+	"github.com/abjerry97/go_payment/internal/intents"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/payouts"
 	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/rules"
+	"github.com/abjerry97/go_payment/internal/screening"
 	"github.com/abjerry97/go_payment/internal/server"
+	"github.com/abjerry97/go_payment/internal/settlements"
+	"github.com/abjerry97/go_payment/internal/sinks"
+	"github.com/abjerry97/go_payment/internal/templates"
 	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/ussd"
+	"github.com/abjerry97/go_payment/internal/virtualaccounts"
+	"github.com/abjerry97/go_payment/internal/webhooks"
 	log "github.com/sirupsen/logrus"
 )
 
+// queueHandoffInterval is how often an instance with queue handoff marks
+// itself alive and reclaims the payments of instances that stopped doing so.
+const queueHandoffInterval = 10 * time.Second
+
 func main() {
 	config := tools.LoadConfig()
 	ctx := context.Background()
+
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(ctx, config, os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap" {
+		os.Exit(runBootstrap(ctx, config, os.Args[2:]))
+	}
+
 	log.SetReportCaller(true)
-	db, err := tools.NewDatabaseService(ctx, config.DatabaseURL)
+	// Postgres and Redis may still be starting (docker-compose, Kubernetes);
+	// connections are made once they answer, see waitForDependencies below.
+	db, err := tools.OpenDatabaseService(ctx, config)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Invalid DATABASE_URL: %v", err)
 	}
 	defer db.Close()
 
-	redisService, err := tools.NewRedisService(config.RedisURL)
+	keyProvider, err := tools.NewKeyProvider(config)
+	if err != nil {
+		log.Fatalf("Failed to configure PII key provider: %v", err)
+	}
+
+	redisService, err := tools.OpenRedisService(config.RedisURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Invalid REDIS_URL: %v", err)
 	}
 	defer redisService.Close()
+	redisService.Namespace = config.RedisNamespace
+	redisService.LocalBalanceTTL = config.LocalBalanceTTL
+	if redisService.LocalBalanceTTL > 0 {
+		go redisService.ListenBalanceChanges(ctx)
+	}
 
 	processor := processors.NewPaymentProcessor(db, redisService, config.WorkerCount)
-	processor.Start(ctx)
+	processor.Suspense = db
+	processor.Requeue = redisService
+	processor.Attempts = db
+	processor.Instance = config.InstanceID
+	processor.Batch = redisService
+	processor.BatchSize = config.DequeueBatchSize
+	if config.QueueHandoff {
+		redisService.Instance = config.InstanceID
+		processor.Ack = redisService
+	}
+
+	alertDestinations, err := alerting.ParseDestinations(config.AlertDestinations)
+	if err != nil {
+		log.Fatalf("Invalid ALERT_DESTINATIONS: %v", err)
+	}
+	if config.AlertWebhookURL != "" {
+		alertDestinations["webhook"] = tools.NewWebhookAlerter(config.AlertWebhookURL)
+	}
+	alertRoutes, err := alerting.ParseRoutes(config.AlertRoutes)
+	if err != nil {
+		log.Fatalf("Invalid ALERT_ROUTES: %v", err)
+	}
+	var alerter tools.Alerter
+	if len(alertDestinations) > 0 {
+		alertRouter, err := alerting.NewRouter(alertDestinations, alertRoutes)
+		if err != nil {
+			log.Fatalf("Invalid ALERT_ROUTES: %v", err)
+		}
+		alertRouter.RateLimit = config.AlertRateLimit
+		alerter = alertRouter
+	}
+	var pager alerting.Pager
+	switch config.Pager {
+	case "":
+	case "pagerduty":
+		if config.PagerDutyRoutingKey == "" {
+			log.Fatalf("PAGER=pagerduty requires PAGERDUTY_ROUTING_KEY")
+		}
+		pager = alerting.NewPagerDutyPager(config.PagerDutyRoutingKey)
+	case "opsgenie":
+		if config.OpsgenieAPIKey == "" {
+			log.Fatalf("PAGER=opsgenie requires OPSGENIE_API_KEY")
+		}
+		pager = alerting.NewOpsgeniePager(config.OpsgenieAPIKey, config.OpsgenieAPIURL)
+	default:
+		log.Fatalf("Unknown PAGER %q", config.Pager)
+	}
+	processor.SLA = processors.NewSLATracker(config.SLATarget, config.SLABreachLimit, config.SLAAlertWindow, alerter)
+	businessZone, err := time.LoadLocation(config.BusinessTimezone)
+	if err != nil {
+		log.Fatalf("Invalid BUSINESS_TIMEZONE: %v", err)
+	}
+	weekend, err := calendar.ParseWeekend(config.BusinessWeekend)
+	if err != nil {
+		log.Fatalf("Invalid BUSINESS_WEEKEND: %v", err)
+	}
+	cutOff, err := calendar.ParseCutOff(config.BusinessCutOff)
+	if err != nil {
+		log.Fatalf("Invalid BUSINESS_CUTOFF: %v", err)
+	}
+	businessCalendar := calendar.New(businessZone, weekend, cutOff)
+	if err := businessCalendar.ParseHolidays(config.BusinessHolidays); err != nil {
+		log.Fatalf("Invalid BUSINESS_HOLIDAYS: %v", err)
+	}
+	processor.Calendar = businessCalendar
+
+	defaultFlags, err := flags.ParseFlags(config.FeatureFlags)
+	if err != nil {
+		log.Fatalf("Invalid FEATURE_FLAGS: %v", err)
+	}
+	featureFlags := flags.NewProvider(defaultFlags, redisService, config.FeatureFlagRefresh)
+	processor.Flags = featureFlags
+
+	screener := screening.NewScreener(db)
+	processor.Use(screener)
+
+	intentMatcher := intents.NewMatcher(db)
+	processor.Use(intentMatcher)
+
+	rulesEngine := rules.NewEngine(db, config.PaymentRulesRefresh)
+	rulesEngine.Alerter = alerter
+	processor.Use(rulesEngine)
+
+	amlMonitor := aml.NewMonitor(db, aml.Rules{
+		SinglePayment: config.AMLSinglePaymentThreshold,
+		Aggregate:     config.AMLAggregateThreshold,
+		Window:        config.AMLAggregateWindow,
+	})
+	amlMonitor.Alerter = alerter
+	processor.Use(amlMonitor)
+
+	var paymentExporter *sinks.Exporter
+	switch config.PaymentSink {
+	case "":
+	case "webhook":
+		paymentExporter = sinks.NewExporter(sinks.NewWebhookSink(config.PaymentSinkURL))
+	case "sheets":
+		credentials, err := os.ReadFile(config.SheetsCredentialsFile)
+		if err != nil {
+			log.Fatalf("Failed to read SHEETS_CREDENTIALS_FILE: %v", err)
+		}
+		sheets, err := sinks.NewSheetsSink(config.SheetsSpreadsheetID, config.SheetsRange, credentials)
+		if err != nil {
+			log.Fatalf("Invalid SHEETS_CREDENTIALS_FILE: %v", err)
+		}
+		paymentExporter = sinks.NewExporter(sheets)
+	default:
+		log.Fatalf("Unknown PAYMENT_SINK %q", config.PaymentSink)
+	}
+	if paymentExporter != nil {
+		paymentExporter.BatchSize = config.PaymentSinkBatchSize
+		processor.Use(paymentExporter)
+	}
+
+	storage, err := tools.NewBlobStore(config)
+	if err != nil {
+		log.Fatalf("Failed to configure blob storage: %v", err)
+	}
+
+	payoutProviders := map[string]payouts.Provider{
+		"bank_transfer": payouts.SandboxProvider{},
+		"mobile_money":  payouts.SandboxProvider{},
+	}
+	if config.BankTransferURL != "" {
+		payoutProviders["bank_transfer"] = payouts.NewHTTPProvider("bank_transfer", config.BankTransferURL, config.BankTransferAPIKey, config.BankTransferWebhookSecret)
+	}
+	if config.MobileMoneyPayoutURL != "" {
+		payoutProviders["mobile_money"] = payouts.NewHTTPProvider("mobile_money", config.MobileMoneyPayoutURL, config.MobileMoneyPayoutKey, config.MobileMoneyWebhookSecret)
+	}
+
+	var payoutWebhookURL tools.Alerter
+	if config.PayoutWebhookURL != "" {
+		payoutWebhookURL = tools.NewWebhookAlerter(config.PayoutWebhookURL)
+	}
+	payoutWebhook := webhooks.NewDispatcher(db, payoutWebhookURL)
+	payoutProcessor := processors.NewPayoutProcessor(db, redisService, payoutProviders, payoutWebhook, config.PayoutWorkerCount)
+
+	notifier := notifications.NewNotifier()
+	if config.SMSGatewayURL != "" {
+		notifier.Register(notifications.ChannelSMS, notifications.NewHTTPSMSProvider(config.SMSGatewayURL, config.SMSAPIKey, config.SMSSender))
+	} else {
+		notifier.Register(notifications.ChannelSMS, notifications.LogProvider{})
+	}
+	notifier.Register(notifications.ChannelEmail, notifications.LogProvider{})
+	var voiceProvider notifications.VoiceProvider
+	switch config.VoiceProvider {
+	case "":
+	case "twilio":
+		if config.TwilioAccountSID == "" || config.TwilioAuthToken == "" || config.VoiceCallbackURL == "" {
+			log.Fatalf("VOICE_PROVIDER=twilio requires TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and VOICE_CALLBACK_URL")
+		}
+		voiceProvider = notifications.NewTwilioVoiceProvider(config.TwilioAccountSID, config.TwilioAuthToken, config.VoiceFrom, config.VoiceCallbackURL, db)
+	case "africastalking":
+		if config.AfricasTalkingUsername == "" || config.AfricasTalkingAPIKey == "" || config.VoiceCallbackToken == "" {
+			log.Fatalf("VOICE_PROVIDER=africastalking requires AFRICASTALKING_USERNAME, AFRICASTALKING_API_KEY and VOICE_CALLBACK_TOKEN")
+		}
+		voiceProvider = notifications.NewAfricasTalkingVoiceProvider(config.AfricasTalkingUsername, config.AfricasTalkingAPIKey, config.VoiceFrom, config.VoiceCallbackToken, db)
+	default:
+		log.Fatalf("Unknown VOICE_PROVIDER %q", config.VoiceProvider)
+	}
+	var voiceReminders *processors.VoiceReminders
+	if voiceProvider != nil {
+		notifier.Register(notifications.ChannelVoice, voiceProvider)
+		voiceReminders = processors.NewVoiceReminders(db, notifier, processors.VoiceReminderOptions{
+			Template:       config.VoiceReminderTemplate,
+			MinDaysPastDue: config.VoiceReminderMinDays,
+			RecallDays:     config.VoiceRecallDays,
+			BatchSize:      config.VoiceReminderBatch,
+		})
+	}
+	var whatsApp *notifications.WhatsAppProvider
+	if config.WhatsAppPhoneNumberID != "" {
+		if config.WhatsAppAccessToken == "" || config.WhatsAppAppSecret == "" {
+			log.Fatalf("WHATSAPP_PHONE_NUMBER_ID requires WHATSAPP_ACCESS_TOKEN and WHATSAPP_APP_SECRET")
+		}
+		whatsAppTemplates, err := notifications.ParseWhatsAppTemplates(config.WhatsAppTemplates)
+		if err != nil {
+			log.Fatalf("Invalid WHATSAPP_TEMPLATES: %v", err)
+		}
+		whatsApp = notifications.NewWhatsAppProvider(config.WhatsAppPhoneNumberID, config.WhatsAppAccessToken, config.WhatsAppAppSecret, config.WhatsAppVerifyToken, whatsAppTemplates)
+		notifier.Register(notifications.ChannelWhatsApp, whatsApp)
+	}
+	notifier.Consent = db
+	notifier.Preferences = db
+	notifier.Deferred = redisService
+	notifier.Timezone = businessZone
+	templateRenderer := templates.NewRenderer(db)
+	notifier.Templates = templateRenderer
+	notifier.Log = db
+
+	var settlementSources []settlements.Source
+	if config.SettlementSFTPAddr != "" {
+		source, err := settlements.NewSFTPSource(settlements.SFTPConfig{
+			Name:               config.SettlementSourceName,
+			Addr:               config.SettlementSFTPAddr,
+			User:               config.SettlementSFTPUser,
+			Password:           config.SettlementSFTPPassword,
+			PrivateKey:         config.SettlementSFTPPrivateKey,
+			HostKeyFingerprint: config.SettlementSFTPHostKey,
+			Dir:                config.SettlementSFTPDir,
+			Pattern:            config.SettlementFilePattern,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure settlement SFTP source: %v", err)
+		}
+		settlementSources = append(settlementSources, source)
+	}
+	if config.SettlementDropDir != "" {
+		settlementSources = append(settlementSources, settlements.NewDirSource("drop", config.SettlementDropDir, config.SettlementFilePattern))
+	}
+
+	var settlementPoller *settlements.Poller
+	if len(settlementSources) > 0 {
+		pipeline := imports.NewPipeline(db, redisService)
+		pipeline.Instance = config.InstanceID
+		settlementPoller = settlements.NewPoller(db, pipeline, settlementSources...)
+		settlementPoller.Notifier = notifier
+		settlementPoller.NotifyEmail = config.SettlementNotifyEmail
+		var settlementWebhookURL tools.Alerter
+		if config.SettlementWebhookURL != "" {
+			settlementWebhookURL = tools.NewWebhookAlerter(config.SettlementWebhookURL)
+		}
+		settlementPoller.Alerter = webhooks.NewDispatcher(db, settlementWebhookURL)
+	}
+
+	glAccounts, err := tools.ParseGLAccounts(config.GLAccountMap)
+	if err != nil {
+		log.Fatalf("Invalid GL_ACCOUNT_MAP: %v", err)
+	}
+	if !tools.ValidGLFormat(config.GLExportFormat) {
+		log.Fatalf("Invalid GL_EXPORT_FORMAT %q", config.GLExportFormat)
+	}
+	glExporter := processors.NewGLExporter(db, glAccounts, config.GLExportFormat, nil)
+	if config.GLSFTPAddr != "" {
+		target, err := settlements.NewSFTPTarget(settlements.SFTPConfig{
+			Name:               "gl",
+			Addr:               config.GLSFTPAddr,
+			User:               config.GLSFTPUser,
+			Password:           config.GLSFTPPassword,
+			PrivateKey:         config.GLSFTPPrivateKey,
+			HostKeyFingerprint: config.GLSFTPHostKey,
+			Dir:                config.GLSFTPDir,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure GL export SFTP target: %v", err)
+		}
+		glExporter.Target = target
+	}
+
+	reportDeliverer := processors.NewReportDeliverer(storage, notifier)
+
+	var virtualAccounts *virtualaccounts.Service
+	switch {
+	case config.VirtualAccountProvider == "":
+	case config.VirtualAccountURL != "":
+		virtualAccounts = virtualaccounts.NewService(db, virtualaccounts.NewHTTPProvider(config.VirtualAccountProvider, config.VirtualAccountURL, config.VirtualAccountAPIKey))
+	case config.VirtualAccountProvider == "sandbox":
+		virtualAccounts = virtualaccounts.NewService(db, virtualaccounts.SandboxProvider{})
+	default:
+		log.Fatalf("VIRTUAL_ACCOUNT_URL is required for virtual account provider %q", config.VirtualAccountProvider)
+	}
+	if virtualAccounts != nil {
+		virtualAccounts.NamePrefix = config.VirtualAccountNamePrefix
+		virtualAccounts.BatchSize = config.VirtualAccountBatchSize
+	}
+
+	scheduler := processors.NewScheduler()
+	scheduler.Register("portfolio_snapshot", config.SnapshotInterval, processors.NewSnapshotJob(db, storage))
+	scheduler.Register("deferred_notifications", config.DeferredNotificationInterval, notifier.DeliverDeferred)
+	scheduler.Register("promise_expiry", config.PromiseExpiryInterval, processors.NewPromiseExpiryJob(db))
+	scheduler.Register("risk_scoring", config.RiskScoringInterval, processors.NewRiskScoringJob(db))
+	scheduler.Register("commission_run", config.CommissionRunInterval, processors.NewCommissionRunJob(db))
+	scheduler.Register("payout_retries", config.PayoutRetryInterval, payoutProcessor.RunRetries)
+	cashPolicy := tools.CashVariancePolicy{MaxOutstanding: config.CashMaxUndeposited, MaxDays: config.CashMaxDepositDays}
+	scheduler.Register("cash_variance", config.CashVarianceInterval,
+		processors.NewCashVarianceJob(db, alerter, config.CashTrackingStart, cashPolicy))
+	if voiceReminders != nil {
+		scheduler.Register("voice_reminders", config.VoiceReminderInterval, voiceReminders.Run)
+	}
+	scheduler.Register("saved_reports", config.SavedReportInterval, processors.NewSavedReportJob(db, reportDeliverer))
+	scheduler.Register("ledger_check", config.LedgerCheckInterval, processors.NewLedgerCheckJob(db, redisService, processors.LedgerCheckOptions{
+		Repair: config.LedgerCheckRepair,
+		Settle: config.LedgerCheckSettle,
+	}))
+	scheduler.Register("partition_maintenance", config.PartitionMaintenanceInterval,
+		processors.NewPartitionMaintenanceJob(db, config.PartitionMonthsAhead, config.PartitionDetachAfterMonths))
+	scheduler.Register("transaction_archive", config.TransactionArchiveInterval,
+		processors.NewTransactionArchiveJob(db, storage, config.TransactionArchiveAfterMonths))
+
+	retentionPolicies := tools.RetentionPolicies(config)
+	if len(retentionPolicies) > 0 {
+		scheduler.Register("data_retention", config.RetentionInterval, processors.NewRetentionJob(db, storage, retentionPolicies, config.RetentionDryRun))
+	}
+	anonymizeOptions := tools.AnonymizeOptions{
+		Salt:         config.AnonExportSalt,
+		MaxDateShift: config.AnonExportMaxDateShift,
+		AmountNoise:  config.AnonExportAmountNoise,
+	}
+	scheduler.Register("anonymized_export", config.AnonExportInterval, processors.NewAnonymizedExportJob(db, storage, anonymizeOptions))
+	if settlementPoller != nil {
+		scheduler.Register("settlement_poll", config.SettlementPollInterval, settlementPoller.Run)
+	}
+	if glExporter.Target != nil {
+		scheduler.Register("gl_export", config.GLExportInterval, glExporter.Run)
+	}
+	if paymentExporter != nil {
+		scheduler.Register("payment_export", config.PaymentSinkInterval, paymentExporter.Flush)
+	}
+	if alerter != nil || pager != nil {
+		watchdog := alerting.NewWatchdog(alerter)
+		watchdog.Watch("postgres", func(ctx context.Context) error {
+			_, err := db.Ping(ctx)
+			return err
+		})
+		watchdog.Watch("redis", func(ctx context.Context) error {
+			return redisService.Client.Ping(ctx).Err()
+		})
+		watchdog.WatchQueue(redisService.QueueDepth, int64(config.AlertQueueThreshold))
+		watchdog.WatchProcessor(processor, config.AlertProcessorStall)
+		watchdog.QueueHardLimit = int64(config.AlertQueueHardLimit)
+		watchdog.Pager = pager
+		watchdog.Instance = config.InstanceID
+		scheduler.Register("alert_watchdog", config.AlertWatchInterval, watchdog.Run)
+	}
+	if config.QueueHandoff {
+		scheduler.Register("queue_handoff", queueHandoffInterval, func(ctx context.Context) error {
+			if err := redisService.KeepAlive(ctx, 3*queueHandoffInterval); err != nil {
+				return err
+			}
+			_, err := redisService.ReclaimPayments(ctx)
+			return err
+		})
+	}
+	dunningService := dunning.NewService(db)
+	dunningService.Notifier = notifier
+	switch config.ReminderChannel {
+	case "sms":
+	case "whatsapp":
+		if whatsApp == nil {
+			log.Fatalf("REMINDER_CHANNEL=whatsapp requires WHATSAPP_PHONE_NUMBER_ID")
+		}
+		dunningService.PhoneChannel = notifications.ChannelWhatsApp
+	default:
+		log.Fatalf("Unknown REMINDER_CHANNEL %q", config.ReminderChannel)
+	}
+
+	var cardCharger *cards.Charger
+	switch {
+	case config.CardGateway == "":
+	case config.CardGatewayURL != "":
+		cardCharger = cards.NewCharger(db, redisService, cards.NewHTTPGateway(config.CardGateway, config.CardGatewayURL, config.CardGatewayAPIKey, config.CardWebhookSecret, config.CardCallbackURL), dunningService)
+	case config.CardGateway == "sandbox":
+		cardCharger = cards.NewCharger(db, redisService, cards.SandboxGateway{Secret: config.CardWebhookSecret}, dunningService)
+	default:
+		log.Fatalf("CARD_GATEWAY_URL is required for card gateway %q", config.CardGateway)
+	}
+	if cardCharger != nil {
+		cardCharger.Currency = config.CardCurrency
+		cardCharger.BatchSize = config.CardChargeBatchSize
+		scheduler.Register("card_charges", config.CardChargeInterval, cardCharger.Run)
+		scheduler.Register("dunning", config.DunningInterval, dunningService.Run)
+	}
+	if virtualAccounts != nil {
+		scheduler.Register("virtual_accounts", config.VirtualAccountInterval, virtualAccounts.Run)
+	}
+
+	var feedAdapters []bankfeeds.Adapter
+	if config.BankFeedMonoSecret != "" {
+		feedAdapters = append(feedAdapters, bankfeeds.NewMonoAdapter(config.BankFeedMonoSecret))
+	}
+	if config.BankFeedOkraSecret != "" {
+		feedAdapters = append(feedAdapters, bankfeeds.NewOkraAdapter(config.BankFeedOkraSecret))
+	}
+	if config.BankFeedOpenBankingSecret != "" {
+		feedAdapters = append(feedAdapters, bankfeeds.NewOpenBankingAdapter(config.BankFeedOpenBankingSecret))
+	}
+	if virtualAccounts != nil && config.VirtualAccountWebhookSecret != "" {
+		feedAdapters = append(feedAdapters, bankfeeds.NewVirtualAccountAdapter(virtualAccounts.Provider(), config.VirtualAccountWebhookSecret))
+	}
+	feedMatcher, err := bankfeeds.NewMatcher(db, bankfeeds.ParsePatterns(config.BankFeedPatterns))
+	if err != nil {
+		log.Fatalf("Failed to configure bank feeds: %v", err)
+	}
+
+	routeTimeouts, err := server.ParseRouteTimeouts(config.HTTPRouteTimeouts)
+	if err != nil {
+		log.Fatalf("Invalid HTTP_ROUTE_TIMEOUTS: %v", err)
+	}
 
 	server := server.NewAPIServer(db, redisService, processor)
+	server.Notifier = notifier
+	server.Templates = templateRenderer
+	server.Alerter = payoutWebhook
+	server.Webhooks = payoutWebhook
+	server.WebhookKeyGracePeriod = config.WebhookKeyGracePeriod
+	server.WebhookSignatureTolerance = config.WebhookSignatureTolerance
+	server.OpsAlerter = alerter
+	server.LargeReversalAmount = config.AlertLargeReversalAmount
+	server.SettlementPoller = settlementPoller
+	server.Flags = featureFlags
+	server.Rules = rulesEngine
+	server.PayoutProcessor = payoutProcessor
+	server.ReportDeliverer = reportDeliverer
+	server.GLExporter = glExporter
+	server.CashTrackingStart = config.CashTrackingStart
+	server.CashVariancePolicy = cashPolicy
+	server.VoiceProvider = voiceProvider
+	server.VoiceReminders = voiceReminders
+	server.WhatsApp = whatsApp
+	server.Screener = screener
+	server.VirtualAccounts = virtualAccounts
+	if cardCharger != nil {
+		server.CardGateway = cardCharger.Gateway()
+	}
+	server.Intents = intentMatcher
+	server.PaymentIntentTTL = config.PaymentIntentTTL
+	if config.QRMerchantID != "" {
+		server.QRMerchant = &intents.QRMerchant{
+			GUID:            config.QRMerchantGUID,
+			ID:              config.QRMerchantID,
+			Name:            config.QRMerchantName,
+			City:            config.QRMerchantCity,
+			Country:         config.QRCountryCode,
+			Category:        config.QRMerchantCategory,
+			DefaultCurrency: config.QRCurrency,
+		}
+	}
+	server.DuplicateMetadataKeys = strings.Split(config.DuplicateMetadataKeys, ",")
+	if len(feedAdapters) > 0 {
+		server.BankFeeds = bankfeeds.NewService(db, redisService, feedMatcher, feedAdapters...)
+	}
+	server.RetentionPolicies = retentionPolicies
+	server.Storage = storage
+	server.AnonymizeOptions = anonymizeOptions
+	server.AnonymizedImport = config.AnonImportEnabled
+	server.PresignExpiry = config.BlobPresignExpiry
+	server.V1Sunset = config.APIV1Sunset
+	server.MaxBodyBytes = int64(config.MaxRequestBodyBytes)
+	server.Compression = config.HTTPCompression
+	server.AdminUI = config.AdminUIEnabled
+	server.DegradedWrites = config.DegradedWrites
+	server.RequestTimeout = config.HTTPRequestTimeout
+	for route, timeout := range routeTimeouts {
+		server.RouteTimeouts[route] = timeout
+	}
+	server.LoadShedding.DBLatency = config.LoadShedDBLatency
+	server.LoadShedding.QueueDepth = int64(config.LoadShedQueueDepth)
+	server.LoadShedding.Interval = config.LoadShedInterval
+	server.MaintenanceRefresh = config.MaintenanceRefresh
+	server.ResponseCacheTTL = config.ResponseCacheTTL
+	server.QueueRateWindow = config.QueueRateWindow
+	server.Instance.ID = config.InstanceID
+	server.Instance.Namespace = config.PodNamespace
+	server.Instance.Node = config.NodeName
+	server.PreStopDelay = config.ShutdownDrainDelay
+	server.PreStopTimeout = config.ShutdownTimeout
+	server.PreStopToken = config.PreStopToken
+	ussdVerifier, err := ussd.NewVerifier(config.USSDCallbackToken, config.USSDAllowedIPs)
+	if err != nil {
+		log.Fatalf("Failed to configure USSD callbacks: %v", err)
+	}
+	if ussdVerifier == nil {
+		log.Warn("USSD_CALLBACK_TOKEN and USSD_ALLOWED_IPS are not set; USSD callbacks will be refused")
+	}
+	server.USSD = ussdVerifier
+	processor.Use(server.ResponseCacheHook())
+	if config.CacheControlBalance != "" {
+		server.CacheControl["balance"] = config.CacheControlBalance
+	}
+	if config.CacheControlCustomers != "" {
+		server.CacheControl["customers"] = config.CacheControlCustomers
+	}
 
+	// Payments, payouts and scheduled jobs need Postgres and Redis. Without
+	// them the instance either exits or, with STARTUP_DEGRADED, serves
+	// health and reads until they come up and then starts the workers.
+	startWorkers := func() {
+		if keyProvider != nil {
+			if err := db.EnablePII(ctx, keyProvider, config.PIIIndexKey); err != nil {
+				log.Fatalf("Failed to enable PII encryption: %v", err)
+			}
+			log.Printf("PII encryption enabled with master key %s", keyProvider.KeyID())
+		}
+		if config.QueueHandoff {
+			// Mark this instance alive before it takes payments, and take
+			// back any a previous run under the same name left unfinished.
+			if err := redisService.KeepAlive(ctx, 3*queueHandoffInterval); err != nil {
+				log.Warnf("Failed to register instance %s: %v", config.InstanceID, err)
+			}
+			if _, err := redisService.HandOffPayments(ctx, config.InstanceID); err != nil {
+				log.Warnf("Failed to hand back payments left by %s: %v", config.InstanceID, err)
+			}
+		}
+		processor.Start(ctx)
+		payoutProcessor.Start(ctx)
+		scheduler.Start(ctx)
+	}
+	startupBackoff := tools.Backoff{
+		Initial: config.StartupRetryInitial,
+		Max:     config.StartupRetryMax,
+		MaxWait: config.StartupMaxWait,
+	}
+	if err := waitForDependencies(ctx, startupBackoff, db, redisService); err == nil {
+		startWorkers()
+	} else if config.StartupDegraded {
+		log.Warnf("Starting degraded, dependencies unavailable: %v", err)
+		server.SetDegraded(true)
+		go func() {
+			startupBackoff.MaxWait = 0
+			if err := waitForDependencies(ctx, startupBackoff, db, redisService); err != nil {
+				return
+			}
+			startWorkers()
+			server.SetDegraded(false)
+			log.Println("Dependencies available, leaving degraded mode")
+		}()
+	} else {
+		log.Fatalf("Dependencies unavailable after %s: %v", config.StartupMaxWait, err)
+	}
+
+	httpServer := &http.Server{
+		Addr:    ":" + config.Port,
+		Handler: server.Handler(),
+	}
+
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
 
-		log.Println("Shutting down...")
-		processor.Stop()
-		os.Exit(0)
+		// Fail readiness first and give load balancers time to notice
+		// before connections are refused.
+		log.Println("Shutting down: draining...")
+		if !server.Draining() {
+			server.StartDraining()
+			time.Sleep(config.ShutdownDrainDelay)
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(ctx, config.ShutdownTimeout)
+		defer cancel()
+		if err := server.Drain(shutdownCtx); err != nil {
+			log.Warnf("Drain incomplete: %v", err)
+		}
+		if paymentExporter != nil {
+			if err := paymentExporter.Flush(shutdownCtx); err != nil {
+				log.Warnf("Failed to export %d payments: %v", paymentExporter.Pending(), err)
+			}
+		}
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("HTTP shutdown incomplete: %v", err)
+		}
+		scheduler.Stop()
 	}()
 
 	log.Printf("Server starting on port %s", config.Port)
-	if err := server.Run(":" + config.Port); err != nil {
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	<-shutdownDone
+	log.Println("Shutdown complete")
+}
+
+// waitForDependencies retries Postgres and then Redis until both answer or
+// backoff gives up.
+func waitForDependencies(ctx context.Context, backoff tools.Backoff, db *tools.DatabaseService, redisService *tools.RedisService) error {
+	err := backoff.Retry(ctx, "Postgres", func(ctx context.Context) error {
+		_, err := db.Ping(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	err = backoff.Retry(ctx, "Redis", func(ctx context.Context) error {
+		return redisService.Client.Ping(ctx).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	log.Println("Database and Redis connected successfully")
+	return nil
 }
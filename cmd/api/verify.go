@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/verify"
+	log "github.com/sirupsen/logrus"
+)
+
+// runVerify implements `verify`: replay the payment archive into a shadow
+// database and report accounts whose balances differ from production. It
+// exits 0 when everything matches, 1 on divergences and 2 on errors.
+func runVerify(ctx context.Context, config *tools.Config, args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	shadowURL := flags.String("shadow-db", config.ShadowDatabaseURL, "database to replay into (SHADOW_DATABASE_URL); its accounts are overwritten")
+	customerID := flags.String("customer", "", "only replay this customer's payments")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	if *shadowURL == "" {
+		fmt.Fprintln(os.Stderr, "verify: -shadow-db or SHADOW_DATABASE_URL is required")
+		return 2
+	}
+	if *shadowURL == config.DatabaseURL {
+		fmt.Fprintln(os.Stderr, "verify: refusing to replay into the production database")
+		return 2
+	}
+
+	logger := log.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(log.WarnLevel)
+
+	production, err := tools.NewDatabaseService(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: failed to connect to database: %v\n", err)
+		return 2
+	}
+	defer production.Close()
+
+	shadowConfig := *config
+	shadowConfig.DatabaseURL = *shadowURL
+	shadowConfig.DBApplicationName = config.DBApplicationName + "-verify"
+	shadow, err := tools.NewDatabaseService(ctx, &shadowConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: failed to connect to shadow database: %v\n", err)
+		return 2
+	}
+	defer shadow.Close()
+
+	report, err := verify.Replay(ctx, production, shadow, *customerID, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printReport(report)
+	}
+
+	if !report.Clean() {
+		return 1
+	}
+	return 0
+}
+
+func printReport(report *verify.Report) {
+	fmt.Printf("Replayed %d payments across %d accounts: %d divergences, %d failures\n",
+		report.Payments, report.Customers, len(report.Divergences), len(report.Failures))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(report.Divergences) > 0 {
+		fmt.Fprintln(w, "\nCUSTOMER\tFIELD\tPRODUCTION\tREPLAYED")
+		for _, d := range report.Divergences {
+			fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\n", d.CustomerID, d.Field, d.Production, d.Replayed)
+		}
+	}
+	if len(report.Failures) > 0 {
+		fmt.Fprintln(w, "\nREFERENCE\tCUSTOMER\tERROR")
+		for _, f := range report.Failures {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.TransactionReference, f.CustomerID, f.Error)
+		}
+	}
+	w.Flush()
+}
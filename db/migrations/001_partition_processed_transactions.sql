@@ -0,0 +1,60 @@
+-- Converts an existing processed_transactions table into the monthly
+-- partitioned layout of db/init.sql. New databases get that layout from
+-- init.sql and don't need this.
+--
+-- The existing table becomes the default partition, so no rows are copied;
+-- the partition maintenance job (PARTITION_MAINTENANCE_INTERVAL) then
+-- creates the monthly partitions from the current month on, and new rows
+-- land there. Run it in a quiet period: it takes an exclusive lock on the
+-- table while the primary key and indexes are rebuilt.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/001_partition_processed_transactions.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS processed_references (
+    transaction_reference VARCHAR(100) PRIMARY KEY,
+    processed_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO processed_references (transaction_reference, processed_at)
+SELECT transaction_reference, processed_at FROM processed_transactions
+ON CONFLICT (transaction_reference) DO NOTHING;
+
+ALTER TABLE processed_transactions RENAME TO processed_transactions_default;
+ALTER TABLE processed_transactions_default DROP CONSTRAINT processed_transactions_pkey;
+ALTER TABLE processed_transactions_default ADD PRIMARY KEY (transaction_reference, processed_at);
+
+DROP INDEX IF EXISTS idx_txn_ref;
+DROP INDEX IF EXISTS idx_txn_customer;
+DROP INDEX IF EXISTS idx_txn_agent;
+DROP INDEX IF EXISTS idx_txn_recovery;
+DROP INDEX IF EXISTS idx_txn_customer_processed;
+
+CREATE TABLE processed_transactions (
+    transaction_reference VARCHAR(100) NOT NULL,
+    customer_id VARCHAR(50) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    metadata JSONB,
+    agent_id VARCHAR(50),
+    recovery BOOLEAN NOT NULL DEFAULT FALSE,
+    value_date DATE,
+    PRIMARY KEY (transaction_reference, processed_at),
+    FOREIGN KEY (customer_id) REFERENCES customer_accounts(customer_id)
+) PARTITION BY RANGE (processed_at);
+
+ALTER TABLE processed_transactions ATTACH PARTITION processed_transactions_default DEFAULT;
+
+CREATE INDEX IF NOT EXISTS idx_txn_ref ON processed_transactions(transaction_reference);
+CREATE INDEX IF NOT EXISTS idx_txn_customer ON processed_transactions(customer_id);
+CREATE INDEX IF NOT EXISTS idx_txn_agent ON processed_transactions(agent_id, processed_at) WHERE agent_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_txn_recovery ON processed_transactions(processed_at) WHERE recovery;
+CREATE INDEX IF NOT EXISTS idx_txn_customer_processed ON processed_transactions(customer_id, processed_at);
+
+GRANT ALL PRIVILEGES ON processed_transactions, processed_references TO payment_user;
+
+COMMENT ON TABLE processed_transactions IS 'Tracks processed transactions for idempotency; partitioned by month of processed_at';
+COMMENT ON TABLE processed_references IS 'One row per processed transaction reference, keeping references unique across processed_transactions partitions';
+
+COMMIT;
@@ -0,0 +1,19 @@
+-- Tags processed transactions with the import batch they came from, so a
+-- bad batch can be reversed (POST /api/v1/admin/imports/:id/reverse). New
+-- databases get the column from init.sql; re-running init.sql creates the
+-- reversal tables. Payments imported before this migration are tagged from
+-- their import_batch_id metadata.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/002_tag_import_batches.sql
+
+BEGIN;
+
+ALTER TABLE processed_transactions ADD COLUMN IF NOT EXISTS import_batch_id BIGINT;
+
+UPDATE processed_transactions
+SET import_batch_id = (metadata->>'import_batch_id')::BIGINT
+WHERE import_batch_id IS NULL AND metadata->>'import_batch_id' ~ '^[0-9]+$';
+
+CREATE INDEX IF NOT EXISTS idx_txn_import_batch ON processed_transactions(import_batch_id) WHERE import_batch_id IS NOT NULL;
+
+COMMIT;
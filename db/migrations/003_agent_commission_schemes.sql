@@ -0,0 +1,11 @@
+-- Lets agents be paid under a commission scheme instead of their flat
+-- commission_rate. Run init.sql again first: it creates commission_schemes,
+-- which the new column references, and the commission run tables.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/003_agent_commission_schemes.sql
+
+BEGIN;
+
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS scheme_id VARCHAR(50) REFERENCES commission_schemes(scheme_id);
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Allows voice call scripts to be saved as message templates. New databases
+-- get the constraint from init.sql; re-running init.sql creates the
+-- voice_calls table.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/004_voice_templates.sql
+
+BEGIN;
+
+ALTER TABLE message_templates DROP CONSTRAINT IF EXISTS message_templates_channel_check;
+ALTER TABLE message_templates ADD CONSTRAINT message_templates_channel_check
+    CHECK (channel IN ('sms', 'email', 'receipt', 'voice'));
+
+COMMIT;
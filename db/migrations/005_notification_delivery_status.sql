@@ -0,0 +1,17 @@
+-- Records delivery receipts, such as WhatsApp's sent/delivered/read
+-- webhooks, against the notification log. New databases get the columns
+-- from init.sql.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/005_notification_delivery_status.sql
+
+BEGIN;
+
+ALTER TABLE notification_log ADD COLUMN IF NOT EXISTS provider_message_id VARCHAR(200);
+ALTER TABLE notification_log ADD COLUMN IF NOT EXISTS delivery_status VARCHAR(20)
+    CHECK (delivery_status IN ('SENT', 'DELIVERED', 'READ', 'FAILED'));
+ALTER TABLE notification_log ADD COLUMN IF NOT EXISTS delivery_error TEXT;
+ALTER TABLE notification_log ADD COLUMN IF NOT EXISTS delivery_updated_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_notification_log_provider_message ON notification_log(provider_message_id) WHERE provider_message_id IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,33 @@
+-- Moves webhook subscription secrets into versioned signing keys, so a
+-- secret can be rotated while the previous one stays valid for a grace
+-- period. Existing secrets become key v1. New databases get the table from
+-- init.sql.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/006_webhook_signing_keys.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS webhook_signing_keys (
+    subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP,
+    PRIMARY KEY (subscription_id, version)
+);
+
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'webhook_subscriptions' AND column_name = 'secret'
+    ) THEN
+        INSERT INTO webhook_signing_keys (subscription_id, version, secret, created_at)
+        SELECT id, 1, secret, created_at FROM webhook_subscriptions
+        ON CONFLICT DO NOTHING;
+
+        ALTER TABLE webhook_subscriptions DROP COLUMN secret;
+    END IF;
+END $$;
+
+COMMIT;
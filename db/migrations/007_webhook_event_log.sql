@@ -0,0 +1,23 @@
+-- Keeps every outbound webhook event in a log consumers can page through
+-- by cursor and have re-delivered, and ties deliveries to their event. New
+-- databases get these from init.sql.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/007_webhook_event_log.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_events_created ON webhook_events(created_at);
+
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS event_id BIGINT REFERENCES webhook_events(id) ON DELETE SET NULL;
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS replay BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_event ON webhook_deliveries(event_id) WHERE event_id IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,25 @@
+-- Records each processing attempt of a payment, so slow or failing ones can
+-- be explained without the logs. New databases get this from init.sql.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/008_payment_attempts.sql
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS payment_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_reference VARCHAR(100) NOT NULL,
+    customer_id VARCHAR(50) NOT NULL,
+    instance VARCHAR(100),
+    worker_id INTEGER,
+    started_at TIMESTAMP NOT NULL,
+    queued_ms BIGINT,
+    duration_ms INTEGER NOT NULL,
+    version_conflicts INTEGER NOT NULL DEFAULT 0,
+    outcome VARCHAR(20) NOT NULL,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_attempts_reference ON payment_attempts(transaction_reference, started_at);
+CREATE INDEX IF NOT EXISTS idx_payment_attempts_started ON payment_attempts(started_at);
+
+COMMIT;
@@ -0,0 +1,25 @@
+-- Checkpoints import batches as they run, so GET /api/v1/admin/imports/:id
+-- can report progress and an import whose instance crashed resumes after
+-- its last checkpoint instead of staying RUNNING for good. Adds the
+-- CANCELLED status for POST /api/v1/admin/imports/:id/cancel.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/009_import_progress.sql
+
+BEGIN;
+
+ALTER TABLE import_batches
+    ADD COLUMN IF NOT EXISTS owner VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS file_bytes BIGINT,
+    ADD COLUMN IF NOT EXISTS checkpoint_row INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS checkpoint_offset BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS checkpoint_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS resumed_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS resumed_rows INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS cancel_requested_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS cancelled_by VARCHAR(100);
+
+ALTER TABLE import_batches DROP CONSTRAINT IF EXISTS import_batches_status_check;
+ALTER TABLE import_batches ADD CONSTRAINT import_batches_status_check
+    CHECK (status IN ('RUNNING', 'COMPLETED', 'FAILED', 'CANCELLED'));
+
+COMMIT;
@@ -0,0 +1,15 @@
+-- Keys screening holds on what placed them rather than on the payment
+-- alone, so a payment held by a screening entry can still be held by each
+-- HOLD payment rule it matches, and releasing one hold doesn't release the
+-- others.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/010_rule_holds.sql
+
+BEGIN;
+
+ALTER TABLE screening_holds DROP CONSTRAINT IF EXISTS screening_holds_transaction_reference_key;
+ALTER TABLE screening_holds DROP CONSTRAINT IF EXISTS screening_holds_reference_match_key;
+ALTER TABLE screening_holds
+    ADD CONSTRAINT screening_holds_reference_match_key UNIQUE (transaction_reference, entry_type, matched_value);
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- Customer payouts are taken off the account they pay out of, and the
+-- ledger check takes them into account; this index serves it. New
+-- databases get this from init.sql.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/011_customer_payouts.sql
+
+BEGIN;
+
+CREATE INDEX IF NOT EXISTS idx_payout_customer ON payouts(customer_id) WHERE customer_id IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Keeps when a failed payout is next tried on the row, so the retry sweep
+-- picks it up after a restart instead of it staying PENDING. New databases
+-- get this from init.sql.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/012_payout_retries.sql
+
+BEGIN;
+
+ALTER TABLE payouts ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_payout_due ON payouts(next_attempt_at) WHERE status = 'PENDING';
+
+COMMIT;
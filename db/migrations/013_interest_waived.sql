@@ -0,0 +1,13 @@
+-- Records the unaccrued interest waived when a payment covers the payoff
+-- quote, taken off the outstanding balance with the payment. New databases
+-- get this from init.sql.
+--
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f db/migrations/013_interest_waived.sql
+
+BEGIN;
+
+ALTER TABLE customer_accounts ADD COLUMN IF NOT EXISTS interest_waived DECIMAL(15, 2) NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN customer_accounts.interest_waived IS 'Unaccrued interest taken off the outstanding balance when a payment settled the loan early';
+
+COMMIT;
@@ -1,3 +1,43 @@
+CREATE TABLE IF NOT EXISTS loan_products (
+    product_id VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    interest_rate DECIMAL(7, 4) NOT NULL DEFAULT 0,
+    interest_method VARCHAR(20) NOT NULL DEFAULT 'FLAT' CHECK (interest_method IN ('FLAT', 'REDUCING_BALANCE')),
+    grace_weeks INTEGER NOT NULL DEFAULT 0,
+    dunning_retry_days INTEGER[] NOT NULL DEFAULT '{1,3,5}',
+    dunning_delinquent_days INTEGER NOT NULL DEFAULT 14 CHECK (dunning_delinquent_days >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE TABLE IF NOT EXISTS assets (
+    asset_type VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    price DECIMAL(15, 2) NOT NULL CHECK (price > 0),
+    default_term_weeks INTEGER NOT NULL CHECK (default_term_weeks > 0),
+    max_term_weeks INTEGER CHECK (max_term_weeks >= default_term_weeks),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE TABLE IF NOT EXISTS holiday_calendars (
+    calendar_code VARCHAR(32) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    weekend_days VARCHAR(3)[] NOT NULL DEFAULT '{SAT,SUN}',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE UNIQUE INDEX IF NOT EXISTS idx_holiday_calendar_default ON holiday_calendars(is_default) WHERE is_default;
+ 
+CREATE TABLE IF NOT EXISTS calendar_holidays (
+    calendar_code VARCHAR(32) NOT NULL REFERENCES holiday_calendars(calendar_code) ON DELETE CASCADE,
+    holiday_date DATE NOT NULL,
+    name VARCHAR(100),
+    PRIMARY KEY (calendar_code, holiday_date)
+);
+ 
 
 CREATE TABLE IF NOT EXISTS customer_accounts (
     customer_id VARCHAR(50) PRIMARY KEY,
@@ -9,23 +49,94 @@ CREATE TABLE IF NOT EXISTS customer_accounts (
     last_payment_date TIMESTAMP,
     payment_count INTEGER NOT NULL DEFAULT 0,
     version INTEGER NOT NULL DEFAULT 0, 
+    metadata JSONB NOT NULL DEFAULT '{}',
+    region VARCHAR(50),
+    branch VARCHAR(50),
+    product_id VARCHAR(50) REFERENCES loan_products(product_id),
+    asset_type VARCHAR(50) REFERENCES assets(asset_type),
+    holder_id VARCHAR(50),
+    interest_rate DECIMAL(7, 4) NOT NULL DEFAULT 0,
+    interest_method VARCHAR(20) NOT NULL DEFAULT 'FLAT',
+    grace_weeks INTEGER NOT NULL DEFAULT 0,
+    installment_amount DECIMAL(15, 2),
+    restructured_at TIMESTAMP,
+    schedule_baseline DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    written_off_at TIMESTAMP,
+    written_off_amount DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    write_off_reason TEXT,
+    recovered_amount DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    interest_waived DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    calendar_code VARCHAR(32) REFERENCES holiday_calendars(calendar_code) ON DELETE SET NULL,
+    projected_payoff_date DATE,
+    risk_score SMALLINT CHECK (risk_score BETWEEN 0 AND 100),
+    risk_scored_at TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
  
 CREATE INDEX IF NOT EXISTS idx_customer_id ON customer_accounts(customer_id);
 CREATE INDEX IF NOT EXISTS idx_outstanding_balance ON customer_accounts(outstanding_balance);
+CREATE INDEX IF NOT EXISTS idx_customer_region ON customer_accounts(region, branch);
+CREATE INDEX IF NOT EXISTS idx_customer_holder ON customer_accounts(holder_id) WHERE holder_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_customer_asset_type ON customer_accounts(asset_type);
+CREATE INDEX IF NOT EXISTS idx_customer_risk ON customer_accounts(risk_score DESC) WHERE risk_score IS NOT NULL;
  
+-- processed_transactions is partitioned by month of processed_at; the
+-- partition maintenance job creates the monthly partitions ahead of time and
+-- rows outside them land in the default partition. A partition's primary key
+-- must include processed_at, so processed_references keeps each transaction
+-- reference unique across partitions.
 CREATE TABLE IF NOT EXISTS processed_transactions (
-    transaction_reference VARCHAR(100) PRIMARY KEY,
+    transaction_reference VARCHAR(100) NOT NULL,
     customer_id VARCHAR(50) NOT NULL,
     amount DECIMAL(15, 2) NOT NULL,
     processed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    metadata JSONB,
+    agent_id VARCHAR(50),
+    recovery BOOLEAN NOT NULL DEFAULT FALSE,
+    value_date DATE,
+    import_batch_id BIGINT,
+    PRIMARY KEY (transaction_reference, processed_at),
     FOREIGN KEY (customer_id) REFERENCES customer_accounts(customer_id)
+) PARTITION BY RANGE (processed_at);
+
+CREATE TABLE IF NOT EXISTS processed_transactions_default PARTITION OF processed_transactions DEFAULT;
+
+CREATE TABLE IF NOT EXISTS processed_references (
+    transaction_reference VARCHAR(100) PRIMARY KEY,
+    processed_at TIMESTAMP NOT NULL
 );
  
 CREATE INDEX IF NOT EXISTS idx_txn_ref ON processed_transactions(transaction_reference);
 CREATE INDEX IF NOT EXISTS idx_txn_customer ON processed_transactions(customer_id);
+CREATE INDEX IF NOT EXISTS idx_txn_agent ON processed_transactions(agent_id, processed_at) WHERE agent_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_txn_recovery ON processed_transactions(processed_at) WHERE recovery;
+CREATE INDEX IF NOT EXISTS idx_txn_import_batch ON processed_transactions(import_batch_id) WHERE import_batch_id IS NOT NULL;
+ 
+-- tiers is a list of {"from": collected, "rate": fraction}, ascending from
+-- 0; each rate applies to the collections within its tier.
+CREATE TABLE IF NOT EXISTS commission_schemes (
+    scheme_id VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    tiers JSONB NOT NULL,
+    bonus_on_time_rate DECIMAL(5, 4) NOT NULL DEFAULT 0 CHECK (bonus_on_time_rate BETWEEN 0 AND 1),
+    bonus_min_customers INTEGER NOT NULL DEFAULT 0 CHECK (bonus_min_customers >= 0),
+    bonus_amount DECIMAL(15, 2) NOT NULL DEFAULT 0 CHECK (bonus_amount >= 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS agents (
+    agent_id VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    phone VARCHAR(20),
+    commission_rate DECIMAL(6, 4) NOT NULL DEFAULT 0,
+    scheme_id VARCHAR(50) REFERENCES commission_schemes(scheme_id),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
  
 CREATE TABLE IF NOT EXISTS payment_history (
     id BIGSERIAL PRIMARY KEY,
@@ -43,6 +154,213 @@ CREATE TABLE IF NOT EXISTS payment_history (
 CREATE INDEX IF NOT EXISTS idx_payment_customer ON payment_history(customer_id);
 CREATE INDEX IF NOT EXISTS idx_payment_date ON payment_history(transaction_date);
  
+CREATE TABLE IF NOT EXISTS customer_identifiers (
+    identifier_type VARCHAR(20) NOT NULL,
+    identifier_value VARCHAR(100) NOT NULL,
+    identifier_encrypted TEXT,
+    customer_id VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (identifier_type, identifier_value),
+    FOREIGN KEY (customer_id) REFERENCES customer_accounts(customer_id)
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_identifier_customer ON customer_identifiers(customer_id);
+ 
+CREATE TABLE IF NOT EXISTS payouts (
+    id BIGSERIAL PRIMARY KEY,
+    reference VARCHAR(100) NOT NULL UNIQUE,
+    payout_type VARCHAR(20) NOT NULL,
+    customer_id VARCHAR(50),
+    agent_id VARCHAR(50),
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL DEFAULT 'NGN',
+    method VARCHAR(20) NOT NULL,
+    destination JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+    provider VARCHAR(50),
+    provider_reference VARCHAR(100),
+    failure_reason TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_payout_status ON payouts(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_payout_due ON payouts(next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS idx_payout_customer ON payouts(customer_id) WHERE customer_id IS NOT NULL;
+ 
+CREATE TABLE IF NOT EXISTS account_restructurings (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'APPROVED', 'REJECTED')),
+    old_term_weeks INTEGER NOT NULL,
+    new_term_weeks INTEGER NOT NULL,
+    capitalize_arrears BOOLEAN NOT NULL DEFAULT FALSE,
+    capitalized_amount DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    old_installment DECIMAL(15, 2) NOT NULL,
+    new_installment DECIMAL(15, 2) NOT NULL,
+    reason TEXT NOT NULL,
+    requested_by VARCHAR(100) NOT NULL,
+    decided_by VARCHAR(100),
+    decision_note TEXT,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    decided_at TIMESTAMP
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_restructuring_customer ON account_restructurings(customer_id, requested_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_restructuring_one_pending ON account_restructurings(customer_id) WHERE status = 'PENDING';
+ 
+CREATE TABLE IF NOT EXISTS promises_to_pay (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    agent_id VARCHAR(50) NOT NULL REFERENCES agents(agent_id),
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    promised_date DATE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'KEPT', 'BROKEN')),
+    paid_amount DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_promise_customer ON promises_to_pay(customer_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_promise_pending ON promises_to_pay(promised_date) WHERE status = 'PENDING';
+ 
+CREATE TABLE IF NOT EXISTS collection_assignments (
+    customer_id VARCHAR(50) PRIMARY KEY REFERENCES customer_accounts(customer_id),
+    agent_id VARCHAR(50) REFERENCES agents(agent_id),
+    snoozed_until TIMESTAMP,
+    resolved_at TIMESTAMP,
+    resolution TEXT,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_collection_agent ON collection_assignments(agent_id);
+ 
+CREATE TABLE IF NOT EXISTS customer_contacts (
+    customer_id VARCHAR(50) PRIMARY KEY REFERENCES customer_accounts(customer_id),
+    phone TEXT,
+    phone_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    email TEXT,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    address_line1 VARCHAR(200),
+    address_line2 VARCHAR(200),
+    city VARCHAR(100),
+    postal_code VARCHAR(20),
+    country VARCHAR(2),
+    consent_sms BOOLEAN NOT NULL DEFAULT FALSE,
+    consent_email BOOLEAN NOT NULL DEFAULT FALSE,
+    consent_source VARCHAR(100),
+    consent_updated_at TIMESTAMP,
+    language VARCHAR(5),
+    quiet_hours_start VARCHAR(5),
+    quiet_hours_end VARCHAR(5),
+    timezone VARCHAR(64),
+    preferences_updated_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE TABLE IF NOT EXISTS pii_data_keys (
+    id BIGSERIAL PRIMARY KEY,
+    master_key_id VARCHAR(256) NOT NULL,
+    wrapped_key BYTEA NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    rewrapped_at TIMESTAMP
+);
+ 
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pii_active_key ON pii_data_keys(active) WHERE active;
+ 
+CREATE TABLE IF NOT EXISTS bank_feed_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    external_id VARCHAR(100) NOT NULL,
+    account_id VARCHAR(100),
+    amount DECIMAL(15, 2) NOT NULL,
+    currency VARCHAR(3) NOT NULL DEFAULT 'NGN',
+    narration TEXT,
+    reference VARCHAR(200),
+    transaction_date TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('MATCHED', 'UNMATCHED', 'ASSIGNED', 'IGNORED')),
+    customer_id VARCHAR(50),
+    payment_reference VARCHAR(100),
+    reviewed_by VARCHAR(100),
+    review_note TEXT,
+    reviewed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, external_id)
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_bank_feed_status ON bank_feed_transactions(status, transaction_date);
+ 
+CREATE TABLE IF NOT EXISTS import_batches (
+    id BIGSERIAL PRIMARY KEY,
+    source VARCHAR(100) NOT NULL,
+    file_name VARCHAR(300) NOT NULL,
+    file_key VARCHAR(500) UNIQUE,
+    status VARCHAR(20) NOT NULL DEFAULT 'RUNNING' CHECK (status IN ('RUNNING', 'COMPLETED', 'FAILED', 'CANCELLED')),
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    queued_rows INTEGER NOT NULL DEFAULT 0,
+    duplicate_rows INTEGER NOT NULL DEFAULT 0,
+    failed_rows INTEGER NOT NULL DEFAULT 0,
+    errors JSONB NOT NULL DEFAULT '[]',
+    error TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    -- The counts above cover the file up to checkpoint_row; a crashed
+    -- import resumes after it.
+    owner VARCHAR(100),
+    file_bytes BIGINT,
+    checkpoint_row INTEGER NOT NULL DEFAULT 0,
+    checkpoint_offset BIGINT NOT NULL DEFAULT 0,
+    checkpoint_at TIMESTAMP,
+    resumed_at TIMESTAMP,
+    resumed_rows INTEGER NOT NULL DEFAULT 0,
+    cancel_requested_at TIMESTAMP,
+    cancelled_by VARCHAR(100)
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_import_batches_started ON import_batches(started_at DESC);
+ 
+CREATE TABLE IF NOT EXISTS payment_splits (
+    split_reference VARCHAR(100) PRIMARY KEY,
+    original_reference VARCHAR(100) NOT NULL,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    amount DECIMAL(15, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_payment_splits_original ON payment_splits(original_reference);
+ 
+CREATE TABLE IF NOT EXISTS customer_groups (
+    group_id VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    allocation_rule VARCHAR(20) NOT NULL DEFAULT 'ARREARS_FIRST' CHECK (allocation_rule IN ('ARREARS_FIRST', 'PRO_RATA', 'EQUAL')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE TABLE IF NOT EXISTS customer_group_members (
+    group_id VARCHAR(50) NOT NULL REFERENCES customer_groups(group_id) ON DELETE CASCADE,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    role VARCHAR(20) NOT NULL DEFAULT 'MEMBER' CHECK (role IN ('MEMBER', 'GUARANTOR')),
+    joined_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, customer_id)
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_group_members_customer ON customer_group_members(customer_id);
+ 
+CREATE TABLE IF NOT EXISTS payment_archive (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_reference VARCHAR(100) NOT NULL,
+    customer_id VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    accepted_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ 
+CREATE INDEX IF NOT EXISTS idx_archive_accepted_at ON payment_archive(accepted_at);
+CREATE INDEX IF NOT EXISTS idx_archive_customer ON payment_archive(customer_id, accepted_at);
+ 
 CREATE OR REPLACE FUNCTION update_outstanding_balance()
 RETURNS TRIGGER AS $$
 BEGIN
@@ -57,6 +375,53 @@ CREATE TRIGGER trigger_update_balance
     FOR EACH ROW
     EXECUTE FUNCTION update_outstanding_balance();
  
+-- next_business_day returns from_date, or the first day after it, that is
+-- neither a weekend day nor a holiday in the calendar (the default calendar
+-- when code is NULL). Without a calendar every day is a business day.
+CREATE OR REPLACE FUNCTION next_business_day(from_date DATE, code VARCHAR)
+RETURNS DATE AS $$
+DECLARE
+    cal holiday_calendars%ROWTYPE;
+BEGIN
+    SELECT * INTO cal FROM holiday_calendars
+    WHERE calendar_code = code OR (code IS NULL AND is_default);
+    IF NOT FOUND THEN
+        RETURN from_date;
+    END IF;
+
+    FOR i IN 1..366 LOOP
+        IF NOT (TO_CHAR(from_date, 'DY') = ANY(cal.weekend_days))
+           AND NOT EXISTS (SELECT 1 FROM calendar_holidays
+                           WHERE calendar_code = cal.calendar_code AND holiday_date = from_date) THEN
+            RETURN from_date;
+        END IF;
+        from_date := from_date + 1;
+    END LOOP;
+    RETURN from_date;
+END;
+$$ LANGUAGE plpgsql STABLE;
+ 
+-- weeks_due counts the whole weeks since deployment whose installment has
+-- fallen due, holding back the latest one while its due date is shifted
+-- past a weekend or holiday.
+CREATE OR REPLACE FUNCTION weeks_due(deployed TIMESTAMP, code VARCHAR)
+RETURNS INTEGER AS $$
+DECLARE
+    weeks INTEGER := FLOOR(EXTRACT(EPOCH FROM NOW() - deployed) / 604800);
+    due TIMESTAMP;
+BEGIN
+    IF weeks <= 0 THEN
+        RETURN weeks;
+    END IF;
+
+    due := deployed + weeks * INTERVAL '1 week';
+    IF due + (next_business_day(due::DATE, code) - due::DATE) * INTERVAL '1 day' > NOW() THEN
+        RETURN weeks - 1;
+    END IF;
+    RETURN weeks;
+END;
+$$ LANGUAGE plpgsql STABLE;
+ 
 INSERT INTO customer_accounts (customer_id, deployment_date)
 SELECT 
     'GIG' || LPAD(generate_series::TEXT, 5, '0'),
@@ -82,6 +447,664 @@ SELECT
     EXTRACT(WEEK FROM AGE(NOW(), deployment_date)) as weeks_since_deployment
 FROM customer_accounts;
  
+CREATE TABLE IF NOT EXISTS portfolio_snapshots (
+    snapshot_date DATE NOT NULL,
+    customer_id VARCHAR(50) NOT NULL,
+    asset_value DECIMAL(15, 2) NOT NULL,
+    total_paid DECIMAL(15, 2) NOT NULL,
+    outstanding_balance DECIMAL(15, 2) NOT NULL,
+    payment_count INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    last_payment_date TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (snapshot_date, customer_id)
+);
+ 
+CREATE OR REPLACE FUNCTION prevent_snapshot_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'portfolio_snapshots rows are immutable';
+END;
+$$ LANGUAGE plpgsql;
+ 
+CREATE TRIGGER trigger_immutable_snapshots
+    BEFORE UPDATE OR DELETE ON portfolio_snapshots
+    FOR EACH ROW
+    EXECUTE FUNCTION prevent_snapshot_changes();
+ 
+CREATE TABLE IF NOT EXISTS saved_reports (
+    report_id VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    dimensions TEXT[] NOT NULL DEFAULT '{}',
+    measures TEXT[] NOT NULL,
+    filters JSONB NOT NULL DEFAULT '{}',
+    schedule VARCHAR(10) CHECK (schedule IN ('HOURLY', 'DAILY', 'WEEKLY')),
+    deliver_email VARCHAR(255),
+    deliver_webhook_url VARCHAR(500),
+    deliver_storage BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by VARCHAR(100) NOT NULL,
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    last_run_status TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_reports_due ON saved_reports(next_run_at) WHERE schedule IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS aml_alerts (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    rule VARCHAR(20) NOT NULL CHECK (rule IN ('SINGLE_PAYMENT', 'AGGREGATE')),
+    transaction_reference VARCHAR(100) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    threshold DECIMAL(15, 2) NOT NULL,
+    window_start TIMESTAMP,
+    status VARCHAR(20) NOT NULL DEFAULT 'OPEN' CHECK (status IN ('OPEN', 'UNDER_REVIEW', 'CLOSED')),
+    disposition VARCHAR(20) CHECK (disposition IN ('REPORTED', 'FALSE_POSITIVE')),
+    reviewed_by VARCHAR(100),
+    notes TEXT,
+    triggered_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP,
+    closed_at TIMESTAMP,
+    UNIQUE (rule, transaction_reference)
+);
+
+CREATE INDEX IF NOT EXISTS idx_aml_alerts_status ON aml_alerts(status, triggered_at);
+CREATE INDEX IF NOT EXISTS idx_aml_alerts_customer ON aml_alerts(customer_id, rule, triggered_at);
+CREATE INDEX IF NOT EXISTS idx_txn_customer_processed ON processed_transactions(customer_id, processed_at);
+
+CREATE TABLE IF NOT EXISTS screening_entries (
+    id BIGSERIAL PRIMARY KEY,
+    entry_type VARCHAR(20) NOT NULL CHECK (entry_type IN ('CUSTOMER_ID', 'PHONE', 'REFERENCE_PATTERN')),
+    value VARCHAR(100) NOT NULL,
+    display_value VARCHAR(100) NOT NULL,
+    reason TEXT NOT NULL,
+    added_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (entry_type, value)
+);
+
+CREATE TABLE IF NOT EXISTS screening_holds (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_reference VARCHAR(100) NOT NULL,
+    customer_id VARCHAR(50) NOT NULL,
+    entry_id BIGINT REFERENCES screening_entries(id) ON DELETE SET NULL,
+    entry_type VARCHAR(20) NOT NULL,
+    matched_value VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'HELD' CHECK (status IN ('HELD', 'RELEASED', 'REJECTED')),
+    reviewed_by VARCHAR(100),
+    notes TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP,
+    CONSTRAINT screening_holds_reference_match_key UNIQUE (transaction_reference, entry_type, matched_value)
+);
+
+CREATE INDEX IF NOT EXISTS idx_screening_holds_status ON screening_holds(status, created_at);
+
+CREATE TABLE IF NOT EXISTS customer_merges (
+    id BIGSERIAL PRIMARY KEY,
+    survivor_id VARCHAR(50) NOT NULL,
+    duplicate_id VARCHAR(50) NOT NULL UNIQUE,
+    duplicate_snapshot JSONB NOT NULL,
+    moved_rows JSONB NOT NULL DEFAULT '{}',
+    discarded_rows JSONB NOT NULL DEFAULT '{}',
+    merged_by VARCHAR(100) NOT NULL,
+    reason TEXT NOT NULL,
+    merged_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_merges_survivor ON customer_merges(survivor_id);
+
+CREATE TABLE IF NOT EXISTS payment_intents (
+    reference VARCHAR(40) PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3),
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'MATCHED', 'CANCELLED')),
+    expires_at TIMESTAMP NOT NULL,
+    transaction_reference VARCHAR(100) UNIQUE,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    matched_at TIMESTAMP,
+    cancelled_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_intent_pending ON payment_intents(customer_id, amount, created_at) WHERE status = 'PENDING';
+
+CREATE TABLE IF NOT EXISTS virtual_accounts (
+    account_number VARCHAR(20) PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    provider VARCHAR(50) NOT NULL,
+    bank_name VARCHAR(100) NOT NULL,
+    account_name VARCHAR(200) NOT NULL,
+    provider_reference VARCHAR(100),
+    status VARCHAR(20) NOT NULL DEFAULT 'ACTIVE' CHECK (status IN ('ACTIVE', 'CLOSED')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    closed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_virtual_accounts_customer ON virtual_accounts(customer_id, provider);
+
+CREATE TABLE IF NOT EXISTS card_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    gateway VARCHAR(50) NOT NULL,
+    token VARCHAR(200) NOT NULL,
+    brand VARCHAR(20),
+    last4 VARCHAR(4) NOT NULL,
+    exp_month SMALLINT NOT NULL CHECK (exp_month BETWEEN 1 AND 12),
+    exp_year SMALLINT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'ACTIVE' CHECK (status IN ('ACTIVE', 'REVOKED')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP,
+    UNIQUE (gateway, token)
+);
+
+CREATE INDEX IF NOT EXISTS idx_card_tokens_customer ON card_tokens(customer_id) WHERE status = 'ACTIVE';
+
+CREATE TABLE IF NOT EXISTS card_charges (
+    id BIGSERIAL PRIMARY KEY,
+    reference VARCHAR(100) NOT NULL UNIQUE,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    card_id BIGINT NOT NULL REFERENCES card_tokens(id),
+    installment INT NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'SUCCEEDED', 'FAILED', 'CANCELLED')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    gateway_reference VARCHAR(100),
+    failure_reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (customer_id, installment)
+);
+
+CREATE INDEX IF NOT EXISTS idx_card_charges_due ON card_charges(next_attempt_at) WHERE status = 'PENDING';
+
+CREATE TABLE IF NOT EXISTS dunning_cases (
+    customer_id VARCHAR(50) PRIMARY KEY REFERENCES customer_accounts(customer_id),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('RETRYING', 'FINAL_NOTICE', 'DELINQUENT', 'RESOLVED')),
+    failed_attempts INT NOT NULL DEFAULT 0,
+    charge_reference VARCHAR(100),
+    last_failure_reason TEXT,
+    next_retry_at TIMESTAMP,
+    delinquent_after TIMESTAMP,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_failure_at TIMESTAMP,
+    delinquent_at TIMESTAMP,
+    resolved_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_dunning_open ON dunning_cases(customer_id) WHERE status <> 'RESOLVED';
+
+CREATE TABLE IF NOT EXISTS message_templates (
+    template_id VARCHAR(50) NOT NULL,
+    locale VARCHAR(5) NOT NULL DEFAULT 'en',
+    version INT NOT NULL,
+    channel VARCHAR(10) NOT NULL CHECK (channel IN ('sms', 'email', 'receipt', 'voice')),
+    subject TEXT,
+    body TEXT NOT NULL,
+    variables TEXT[] NOT NULL DEFAULT '{}',
+    updated_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (template_id, locale, version)
+);
+
+CREATE TABLE IF NOT EXISTS payment_rules (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT,
+    position INT NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    conditions JSONB NOT NULL,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('HOLD', 'PRIORITY', 'ALLOCATE', 'NOTIFY')),
+    priority VARCHAR(10),
+    target_customer_id VARCHAR(50),
+    message TEXT,
+    updated_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS suspense_payments (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_reference VARCHAR(100) NOT NULL UNIQUE,
+    customer_id VARCHAR(50) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    payment JSONB NOT NULL,
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'OPEN' CHECK (status IN ('OPEN', 'ASSIGNED', 'REJECTED')),
+    assigned_customer_id VARCHAR(50),
+    reviewed_by VARCHAR(100),
+    review_note TEXT,
+    reviewed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_suspense_payments_status ON suspense_payments(status, created_at);
+
+CREATE TABLE IF NOT EXISTS ledger_drift (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL,
+    recorded_total_paid DECIMAL(15, 2) NOT NULL,
+    ledger_total_paid DECIMAL(15, 2) NOT NULL,
+    recorded_payment_count INTEGER NOT NULL,
+    ledger_payment_count INTEGER NOT NULL,
+    repaired BOOLEAN NOT NULL DEFAULT FALSE,
+    detected_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_drift_customer ON ledger_drift(customer_id, detected_at);
+CREATE INDEX IF NOT EXISTS idx_ledger_drift_detected ON ledger_drift(detected_at);
+
+-- transaction_archives records each Parquet file the archive job wrote to
+-- blob storage; transaction_archive_customers keeps per-account totals of
+-- the archived rows so balances and the ledger check still add up.
+CREATE TABLE IF NOT EXISTS transaction_archives (
+    id BIGSERIAL PRIMARY KEY,
+    month DATE NOT NULL,
+    object_key TEXT NOT NULL UNIQUE,
+    row_count INTEGER NOT NULL,
+    total_amount DECIMAL(15, 2) NOT NULL,
+    first_processed_at TIMESTAMP NOT NULL,
+    last_processed_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_archives_month ON transaction_archives(month);
+
+CREATE TABLE IF NOT EXISTS transaction_archive_customers (
+    archive_id BIGINT NOT NULL REFERENCES transaction_archives(id),
+    customer_id VARCHAR(50) NOT NULL,
+    payments INTEGER NOT NULL,
+    total_paid DECIMAL(15, 2) NOT NULL,
+    first_effective_at TIMESTAMP NOT NULL,
+    last_effective_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_archive_customers_customer ON transaction_archive_customers(customer_id);
+CREATE INDEX IF NOT EXISTS idx_transaction_archive_customers_archive ON transaction_archive_customers(archive_id);
+
+-- A dispute is a customer's challenge to one of their payments. Resolving
+-- it in the customer's favour applies its resolution action: a reversal
+-- takes the payment off the account, an adjustment credits an amount to it.
+CREATE TABLE IF NOT EXISTS disputes (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    transaction_reference VARCHAR(100) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    reason TEXT NOT NULL,
+    opened_by VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'OPEN'
+        CHECK (status IN ('OPEN', 'INVESTIGATING', 'RESOLVED_IN_FAVOR', 'RESOLVED_AGAINST')),
+    assigned_to VARCHAR(100),
+    resolution_action VARCHAR(20) CHECK (resolution_action IN ('REVERSAL', 'ADJUSTMENT', 'NONE')),
+    adjustment_amount DECIMAL(15, 2),
+    adjustment_reference VARCHAR(100),
+    resolved_by VARCHAR(100),
+    resolution_note TEXT,
+    opened_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    investigating_at TIMESTAMP,
+    resolved_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_disputes_open ON disputes(transaction_reference) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_disputes_customer ON disputes(customer_id, opened_at DESC);
+CREATE INDEX IF NOT EXISTS idx_disputes_status ON disputes(status, opened_at);
+
+-- An import reversal takes every payment of an import batch back off its
+-- accounts once someone other than the requester approves it. The reversed
+-- processed_transactions rows move to reversed_transactions.
+CREATE TABLE IF NOT EXISTS import_reversals (
+    id BIGSERIAL PRIMARY KEY,
+    import_batch_id BIGINT NOT NULL REFERENCES import_batches(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'COMPLETED', 'REJECTED')),
+    reason TEXT NOT NULL,
+    requested_by VARCHAR(100) NOT NULL,
+    decided_by VARCHAR(100),
+    decision_note TEXT,
+    payments INTEGER NOT NULL DEFAULT 0,
+    total_amount DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    decided_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_import_reversals_open ON import_reversals(import_batch_id) WHERE status <> 'REJECTED';
+
+CREATE TABLE IF NOT EXISTS reversed_transactions (
+    reversal_id BIGINT REFERENCES import_reversals(id),
+    dispute_id BIGINT REFERENCES disputes(id),
+    transaction_reference VARCHAR(100) NOT NULL,
+    customer_id VARCHAR(50) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    processed_at TIMESTAMP NOT NULL,
+    metadata JSONB,
+    agent_id VARCHAR(50),
+    recovery BOOLEAN NOT NULL,
+    value_date DATE,
+    reversed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ((reversal_id IS NULL) <> (dispute_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_reversed_transactions_reversal ON reversed_transactions(reversal_id);
+CREATE INDEX IF NOT EXISTS idx_reversed_transactions_customer ON reversed_transactions(customer_id);
+
+CREATE TABLE IF NOT EXISTS customer_notes (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    transaction_reference VARCHAR(100),
+    author VARCHAR(100) NOT NULL,
+    body TEXT NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_notes_customer ON customer_notes(customer_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_customer_notes_transaction ON customer_notes(transaction_reference) WHERE transaction_reference IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_customer_notes_tags ON customer_notes USING GIN (tags);
+
+-- notification_log records what was sent to each customer, without the
+-- recipient or message body.
+CREATE TABLE IF NOT EXISTS notification_log (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id VARCHAR(50) NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    template VARCHAR(100),
+    subject TEXT,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('SENT', 'DEFERRED', 'FAILED')),
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    provider_message_id VARCHAR(200),
+    delivery_status VARCHAR(20) CHECK (delivery_status IN ('SENT', 'DELIVERED', 'READ', 'FAILED')),
+    delivery_error TEXT,
+    delivery_updated_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_log_customer ON notification_log(customer_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_notification_log_provider_message ON notification_log(provider_message_id) WHERE provider_message_id IS NOT NULL;
+
+-- A settlement batch is one closed business day (by processed_at). Its
+-- totals are fixed when it closes, and its processed_transactions can't be
+-- added, changed or removed until it is reopened; corrections are posted
+-- as dated adjustments on an open day instead.
+CREATE TABLE IF NOT EXISTS settlement_batches (
+    business_date DATE PRIMARY KEY,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('CLOSED', 'REOPENED')),
+    payments INTEGER NOT NULL,
+    total_amount DECIMAL(15, 2) NOT NULL,
+    customers INTEGER NOT NULL,
+    adjustments INTEGER NOT NULL,
+    adjustment_total DECIMAL(15, 2) NOT NULL,
+    fees INTEGER NOT NULL DEFAULT 0,
+    fee_total DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    fee_tax DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    closed_by VARCHAR(100) NOT NULL,
+    closed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reopened_by VARCHAR(100),
+    reopen_reason TEXT,
+    reopened_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS settlement_adjustments (
+    id BIGSERIAL PRIMARY KEY,
+    reference VARCHAR(100) NOT NULL UNIQUE,
+    business_date DATE NOT NULL,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount <> 0),
+    reason TEXT NOT NULL,
+    corrects_reference VARCHAR(100),
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_settlement_adjustments_date ON settlement_adjustments(business_date);
+CREATE INDEX IF NOT EXISTS idx_settlement_adjustments_customer ON settlement_adjustments(customer_id);
+
+-- Archiving, retention and merges move or re-key transactions of closed
+-- days without changing them, and set app.settlement_override to do so.
+CREATE OR REPLACE FUNCTION prevent_settled_transaction_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF current_setting('app.settlement_override', TRUE) IS DISTINCT FROM 'on' THEN
+        IF TG_OP <> 'INSERT' AND EXISTS (
+            SELECT 1 FROM settlement_batches WHERE business_date = OLD.processed_at::DATE AND status = 'CLOSED'
+        ) THEN
+            RAISE EXCEPTION 'settlement day % is closed', OLD.processed_at::DATE;
+        END IF;
+        IF TG_OP <> 'DELETE' AND EXISTS (
+            SELECT 1 FROM settlement_batches WHERE business_date = NEW.processed_at::DATE AND status = 'CLOSED'
+        ) THEN
+            RAISE EXCEPTION 'settlement day % is closed', NEW.processed_at::DATE;
+        END IF;
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_settled_transactions
+    BEFORE INSERT OR UPDATE OR DELETE ON processed_transactions
+    FOR EACH ROW
+    EXECUTE FUNCTION prevent_settled_transaction_changes();
+
+-- One row per GL journal export pushed to the accounting system; the
+-- scheduled export resumes the day after the last period pushed.
+CREATE TABLE IF NOT EXISTS gl_exports (
+    id BIGSERIAL PRIMARY KEY,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL CHECK (period_end >= period_start),
+    format VARCHAR(20) NOT NULL,
+    journal_file VARCHAR(200) NOT NULL,
+    trial_balance_file VARCHAR(200) NOT NULL,
+    lines INTEGER NOT NULL,
+    total_debits DECIMAL(15, 2) NOT NULL,
+    exported_by VARCHAR(100) NOT NULL,
+    exported_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_gl_exports_period ON gl_exports(period_end DESC);
+
+CREATE TABLE IF NOT EXISTS fee_types (
+    fee_type VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    tax_code VARCHAR(20) NOT NULL DEFAULT 'VAT',
+    tax_rate DECIMAL(5, 4) NOT NULL DEFAULT 0 CHECK (tax_rate >= 0 AND tax_rate < 1),
+    tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Fees charged to an account, with the tax worked out under the fee type's
+-- configuration at the time; gross_amount is added to the balance.
+CREATE TABLE IF NOT EXISTS fee_charges (
+    id BIGSERIAL PRIMARY KEY,
+    reference VARCHAR(100) NOT NULL UNIQUE,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    fee_type VARCHAR(50) NOT NULL REFERENCES fee_types(fee_type),
+    net_amount DECIMAL(15, 2) NOT NULL CHECK (net_amount > 0),
+    tax_code VARCHAR(20) NOT NULL,
+    tax_rate DECIMAL(5, 4) NOT NULL,
+    tax_amount DECIMAL(15, 2) NOT NULL CHECK (tax_amount >= 0),
+    gross_amount DECIMAL(15, 2) NOT NULL,
+    description TEXT,
+    charged_by VARCHAR(100) NOT NULL,
+    charged_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (gross_amount = net_amount + tax_amount)
+);
+
+CREATE INDEX IF NOT EXISTS idx_fee_charges_customer ON fee_charges(customer_id, charged_at);
+CREATE INDEX IF NOT EXISTS idx_fee_charges_charged_at ON fee_charges(charged_at);
+
+CREATE TABLE IF NOT EXISTS commission_runs (
+    id BIGSERIAL PRIMARY KEY,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL CHECK (period_end >= period_start),
+    status VARCHAR(20) NOT NULL DEFAULT 'CALCULATED' CHECK (status IN ('CALCULATED', 'APPROVED')),
+    agents INTEGER NOT NULL DEFAULT 0,
+    total DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    calculated_by VARCHAR(100) NOT NULL,
+    calculated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    approved_by VARCHAR(100),
+    approved_at TIMESTAMP,
+    UNIQUE (period_start, period_end)
+);
+
+-- One agent's commission in a run. scheme_id is NULL for agents paid their
+-- flat commission_rate.
+CREATE TABLE IF NOT EXISTS commission_lines (
+    run_id BIGINT NOT NULL REFERENCES commission_runs(id) ON DELETE CASCADE,
+    agent_id VARCHAR(50) NOT NULL REFERENCES agents(agent_id),
+    scheme_id VARCHAR(50),
+    payments INTEGER NOT NULL,
+    customers INTEGER NOT NULL,
+    collected DECIMAL(15, 2) NOT NULL,
+    commission DECIMAL(15, 2) NOT NULL,
+    on_time_customers INTEGER NOT NULL,
+    bonus DECIMAL(15, 2) NOT NULL,
+    total DECIMAL(15, 2) NOT NULL,
+    payout_reference VARCHAR(100),
+    PRIMARY KEY (run_id, agent_id)
+);
+
+-- Cash deposits agents declare against what they collected in the field.
+-- A declaration counts towards the agent's cash position until it is
+-- rejected; confirming it marks it as seen on the bank statement.
+CREATE TABLE IF NOT EXISTS agent_deposits (
+    id BIGSERIAL PRIMARY KEY,
+    reference VARCHAR(100) NOT NULL UNIQUE,
+    agent_id VARCHAR(50) NOT NULL REFERENCES agents(agent_id),
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    bank VARCHAR(100),
+    deposited_on DATE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'DECLARED' CHECK (status IN ('DECLARED', 'CONFIRMED', 'REJECTED')),
+    declared_by VARCHAR(100) NOT NULL,
+    declared_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_by VARCHAR(100),
+    reviewed_at TIMESTAMP,
+    reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_deposits_agent ON agent_deposits(agent_id, deposited_on);
+CREATE INDEX IF NOT EXISTS idx_agent_deposits_declared ON agent_deposits(declared_at) WHERE status = 'DECLARED';
+
+-- Reminder calls placed through the voice provider. The phone number isn't
+-- kept; provider_call_id matches outcome callbacks that don't echo the
+-- reference.
+CREATE TABLE IF NOT EXISTS voice_calls (
+    id BIGSERIAL PRIMARY KEY,
+    reference VARCHAR(40) NOT NULL UNIQUE,
+    customer_id VARCHAR(50) NOT NULL REFERENCES customer_accounts(customer_id),
+    template_id VARCHAR(50) NOT NULL,
+    provider VARCHAR(30),
+    provider_call_id VARCHAR(100),
+    script TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'QUEUED'
+        CHECK (status IN ('QUEUED', 'PLACED', 'ANSWERED', 'NO_ANSWER', 'BUSY', 'FAILED')),
+    duration_seconds INTEGER,
+    detail TEXT,
+    requested_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    placed_at TIMESTAMP,
+    ended_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_voice_calls_customer ON voice_calls(customer_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_voice_calls_provider ON voice_calls(provider_call_id) WHERE provider_call_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id BIGSERIAL PRIMARY KEY,
+    client_id VARCHAR(100) NOT NULL,
+    url VARCHAR(500) NOT NULL,
+    event_types TEXT[] NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_client ON webhook_subscriptions(client_id);
+
+CREATE TABLE IF NOT EXISTS webhook_signing_keys (
+    subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP,
+    PRIMARY KEY (subscription_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_events_created ON webhook_events(created_at);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    event_id BIGINT REFERENCES webhook_events(id) ON DELETE SET NULL,
+    event_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    test BOOLEAN NOT NULL DEFAULT FALSE,
+    replay BOOLEAN NOT NULL DEFAULT FALSE,
+    success BOOLEAN NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    duration_ms INTEGER NOT NULL,
+    delivered_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries(subscription_id, delivered_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_event ON webhook_deliveries(event_id) WHERE event_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS payment_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_reference VARCHAR(100) NOT NULL,
+    customer_id VARCHAR(50) NOT NULL,
+    instance VARCHAR(100),
+    worker_id INTEGER,
+    started_at TIMESTAMP NOT NULL,
+    queued_ms BIGINT,
+    duration_ms INTEGER NOT NULL,
+    version_conflicts INTEGER NOT NULL DEFAULT 0,
+    outcome VARCHAR(20) NOT NULL,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_attempts_reference ON payment_attempts(transaction_reference, started_at);
+CREATE INDEX IF NOT EXISTS idx_payment_attempts_started ON payment_attempts(started_at);
+
+-- This schema already includes every db/migrations file; list each new
+-- migration here too so bootstrap doesn't apply it again.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version VARCHAR(100) PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO schema_migrations (version) VALUES
+    ('001_partition_processed_transactions'),
+    ('002_tag_import_batches'),
+    ('003_agent_commission_schemes'),
+    ('004_voice_templates'),
+    ('005_notification_delivery_status'),
+    ('006_webhook_signing_keys'),
+    ('007_webhook_event_log'),
+    ('008_payment_attempts'),
+    ('009_import_progress'),
+    ('010_rule_holds'),
+    ('011_customer_payouts'),
+    ('012_payout_retries'),
+    ('013_interest_waived')
+ON CONFLICT (version) DO NOTHING;
+
 GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO payment_user;
 GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public TO payment_user;
  
@@ -112,7 +1135,72 @@ $$ LANGUAGE plpgsql;
 CREATE INDEX IF NOT EXISTS idx_customer_status ON customer_accounts(outstanding_balance) WHERE outstanding_balance > 0;
 CREATE INDEX IF NOT EXISTS idx_recent_payments ON payment_history(processed_at DESC);
  
-COMMENT ON TABLE customer_accounts IS 'Stores customer account information and balances';
-COMMENT ON TABLE processed_transactions IS 'Tracks processed transactions for idempotency';
+COMMENT ON TABLE customer_accounts IS 'Stores customer account information and balances; one row per financed asset, keyed by customer_id, with holder_id naming the owning customer on additional accounts';
+COMMENT ON TABLE processed_transactions IS 'Tracks processed transactions for idempotency; partitioned by month of processed_at';
+COMMENT ON TABLE processed_references IS 'One row per processed transaction reference, keeping references unique across processed_transactions partitions';
 COMMENT ON TABLE payment_history IS 'Audit trail of all payments';
-COMMENT ON COLUMN customer_accounts.version IS 'Used for optimistic locking to prevent race conditions';
\ No newline at end of file
+COMMENT ON TABLE loan_products IS 'Loan pricing terms (interest rate, method, grace period)';
+COMMENT ON TABLE assets IS 'Catalog of financed asset types (motorcycle, tricycle, phone, ...) with price and term limits';
+COMMENT ON TABLE agents IS 'Field agents/collectors credited with collections';
+COMMENT ON TABLE customer_identifiers IS 'Alternative identifiers (phone, national ID, partner refs) mapped to customer accounts';
+COMMENT ON TABLE payouts IS 'Outbound payout instructions (refunds, withdrawals, commissions)';
+COMMENT ON TABLE account_restructurings IS 'Term extensions and re-amortisations with maker-checker approval';
+COMMENT ON TABLE promises_to_pay IS 'Collections promises; resolved to KEPT by payments or BROKEN after the promised date';
+COMMENT ON TABLE collection_assignments IS 'Collections worklist state per account: assigned agent, snooze and resolution';
+COMMENT ON TABLE customer_contacts IS 'Contact details, verification status, per-channel communication consent and notification preferences';
+COMMENT ON TABLE pii_data_keys IS 'Data keys for PII envelope encryption, wrapped by the KMS master key';
+COMMENT ON TABLE bank_feed_transactions IS 'Inbound bank statement lines from feed webhooks and their customer match/review state';
+COMMENT ON TABLE import_batches IS 'Payment file imports (e.g. partner settlement CSVs) and their row outcomes';
+COMMENT ON TABLE holiday_calendars IS 'Business calendars per country or tenant; installments due on their weekends and holidays shift to the next business day';
+COMMENT ON TABLE calendar_holidays IS 'Public holidays in each holiday calendar';
+COMMENT ON TABLE payment_splits IS 'Per-account splits of one incoming transfer, linked to the original transaction reference';
+COMMENT ON TABLE customer_groups IS 'Customer groups (e.g. cooperatives) and how group-level payments are allocated to members';
+COMMENT ON TABLE customer_group_members IS 'Group membership; guarantors are rolled up with the group but receive no allocations';
+COMMENT ON TABLE payment_archive IS 'Raw accepted payment payloads kept for replay';
+COMMENT ON TABLE portfolio_snapshots IS 'Immutable daily snapshot of every account for regulatory reporting';
+COMMENT ON TABLE saved_reports IS 'Admin-defined portfolio reports built from whitelisted dimensions, measures and filters, with optional schedule and delivery';
+COMMENT ON TABLE aml_alerts IS 'Anti-money-laundering threshold alerts and their compliance review and disposition';
+COMMENT ON TABLE screening_entries IS 'Sanctions/blacklist entries payments are screened against at ingest';
+COMMENT ON TABLE screening_holds IS 'Payments held unapplied after matching a screening entry, pending compliance review';
+COMMENT ON TABLE payment_intents IS 'Expected payments reserved ahead of time; gateways echo the reference and incoming payments are matched to them';
+COMMENT ON TABLE virtual_accounts IS 'Dedicated bank account numbers provisioned per account; transfers into them resolve to the account';
+COMMENT ON TABLE card_tokens IS 'Gateway card tokens for recurring charges; card numbers are never stored, only the token, brand, last four digits and expiry';
+COMMENT ON TABLE card_charges IS 'Automatic installment charges against a saved card, one per account and installment, with retry state';
+COMMENT ON TABLE dunning_cases IS 'Dunning state per account after failed automatic debits: retrying, final notice, delinquent or resolved';
+COMMENT ON TABLE message_templates IS 'SMS, email, receipt and voice script copy editable at runtime; every edit is a new version and the latest per locale is live';
+COMMENT ON TABLE payment_rules IS 'Conditions on payments and their accounts with the action the processor takes on a match, evaluated in position order before a payment is applied';
+COMMENT ON TABLE suspense_payments IS 'Payments accepted while the database was unreachable whose customer turned out not to exist, parked for assignment to an account or rejection';
+COMMENT ON TABLE ledger_drift IS 'Accounts whose totals disagreed with processed_transactions, and whether the ledger check repaired them';
+COMMENT ON TABLE transaction_archives IS 'Parquet files of processed_transactions rows moved to blob storage by the archive job';
+COMMENT ON TABLE transaction_archive_customers IS 'Per-account payment count and total of the rows in each transaction archive';
+COMMENT ON TABLE import_reversals IS 'Requests to reverse every payment of an import batch, approved by a second person before they apply';
+COMMENT ON TABLE disputes IS 'Customer challenges to a payment, from opening through investigation to resolution';
+COMMENT ON TABLE reversed_transactions IS 'processed_transactions rows taken back off their accounts by an import reversal or a dispute';
+COMMENT ON TABLE customer_notes IS 'Free-text notes support agents keep on an account or one of its payments';
+COMMENT ON TABLE notification_log IS 'Notifications sent, deferred or failed per customer, by channel and template';
+COMMENT ON TABLE settlement_batches IS 'Closed business days with their totals as closed; their processed transactions are immutable while closed';
+COMMENT ON TABLE settlement_adjustments IS 'Dated corrections posted as transactions on an open day, in place of changes to a closed one';
+COMMENT ON TABLE gl_exports IS 'GL journal and trial balance files pushed to the accounting system over SFTP, by period';
+COMMENT ON TABLE fee_types IS 'Fees we charge, with the tax code, rate and whether configured amounts include tax';
+COMMENT ON TABLE fee_charges IS 'Fee ledger: each fee charged to an account with its net, tax and gross amounts';
+COMMENT ON TABLE commission_schemes IS 'Agent commission schemes: marginal percentage tiers on collections and a bonus for on-time cohorts';
+COMMENT ON TABLE commission_runs IS 'Commission calculations per period; an approved run is paid out as commission payouts';
+COMMENT ON TABLE commission_lines IS 'Each agent''s collections, commission and bonus in a commission run';
+COMMENT ON TABLE agent_deposits IS 'Cash deposits declared by agents, reconciled against their collections and the bank statement';
+COMMENT ON TABLE voice_calls IS 'Voice reminder calls with their script and outcome, which feed the collections worklist';
+COMMENT ON TABLE webhook_subscriptions IS 'Outbound webhook endpoints registered by API clients, with the event types they receive';
+COMMENT ON TABLE webhook_signing_keys IS 'Versioned webhook signing secrets; deliveries are signed with every key that has not expired, so partners can rotate without downtime';
+COMMENT ON TABLE webhook_events IS 'Durable log of outbound webhook events; the id is the cursor consumers read and replay from';
+COMMENT ON TABLE webhook_deliveries IS 'Log of every outbound webhook delivery attempt and its response';
+COMMENT ON TABLE payment_attempts IS 'Each time a worker took a payment: when, for how long, after how long queued, and the outcome';
+COMMENT ON TABLE schema_migrations IS 'db/migrations files applied to this database, maintained by the bootstrap command';
+COMMENT ON TABLE customer_merges IS 'Audit of duplicate accounts merged into a survivor, with the duplicate as it stood and the rows moved';
+COMMENT ON COLUMN notification_log.delivery_status IS 'Latest delivery receipt from the provider, e.g. WhatsApp; NULL for channels that report none';
+COMMENT ON COLUMN customer_notes.transaction_reference IS 'Payment the note is about; NULL for notes on the account as a whole';
+COMMENT ON COLUMN screening_entries.value IS 'Matched value: customer ID, LIKE pattern, or the phone identifier key (blind index when PII encryption is on)';
+COMMENT ON COLUMN customer_accounts.version IS 'Used for optimistic locking to prevent race conditions';
+COMMENT ON COLUMN customer_identifiers.identifier_value IS 'Normalized value, or an HMAC blind index for encrypted phone/national ID identifiers';
+COMMENT ON COLUMN customer_accounts.written_off_amount IS 'Balance moved off the book at write-off; recoveries are tracked in recovered_amount';
+COMMENT ON COLUMN customer_accounts.interest_waived IS 'Unaccrued interest taken off the outstanding balance when a payment settled the loan early';
+COMMENT ON COLUMN customer_accounts.calendar_code IS 'Holiday calendar for due-date shifting; NULL uses the default calendar';
+COMMENT ON COLUMN customer_accounts.installment_amount IS 'Weekly installment fixed when a loan product is assigned; NULL means asset_value / term_weeks';
\ No newline at end of file
@@ -0,0 +1,89 @@
+// Package alerting delivers operational alerts to chat tools, routes them
+// by type and keeps repeats from flooding the channel.
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+)
+
+// SlackAlerter posts alerts to a Slack incoming webhook.
+type SlackAlerter struct {
+	url    string
+	client *http.Client
+}
+
+func NewSlackAlerter(url string) *SlackAlerter {
+	return &SlackAlerter{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (a *SlackAlerter) Send(ctx context.Context, alert tools.Alert) error {
+	var text strings.Builder
+	fmt.Fprintf(&text, "*[%s] %s*\n%s", strings.ToUpper(alert.Severity), alert.Type, alert.Message)
+	for _, key := range detailKeys(alert) {
+		fmt.Fprintf(&text, "\n• %s: %v", key, alert.Details[key])
+	}
+	return post(ctx, a.client, a.url, map[string]string{"text": text.String()})
+}
+
+// TeamsAlerter posts alerts to a Microsoft Teams incoming webhook as a
+// message card.
+type TeamsAlerter struct {
+	url    string
+	client *http.Client
+}
+
+func NewTeamsAlerter(url string) *TeamsAlerter {
+	return &TeamsAlerter{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (a *TeamsAlerter) Send(ctx context.Context, alert tools.Alert) error {
+	facts := []map[string]string{}
+	for _, key := range detailKeys(alert) {
+		facts = append(facts, map[string]string{"name": key, "value": fmt.Sprint(alert.Details[key])})
+	}
+
+	color := "FFA500"
+	switch alert.Severity {
+	case "critical":
+		color = "D70000"
+	case "info":
+		color = "0078D7"
+	}
+
+	return post(ctx, a.client, a.url, map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"themeColor": color,
+		"summary":    alert.Message,
+		"title":      fmt.Sprintf("[%s] %s", strings.ToUpper(alert.Severity), alert.Type),
+		"text":       alert.Message,
+		"sections":   []map[string]interface{}{{"facts": facts}},
+	})
+}
+
+func detailKeys(alert tools.Alert) []string {
+	keys := make([]string, 0, len(alert.Details))
+	for key := range alert.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func post(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	status, err := postJSON(ctx, client, url, nil, payload)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("alert webhook returned status %d", status)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Incident is a critical condition that pages on-call. Key identifies the
+// condition: triggering it again while open updates the same incident, and
+// resolving the key closes it.
+type Incident struct {
+	Key     string
+	Summary string
+	Source  string
+	Details map[string]interface{}
+}
+
+// Pager opens and resolves incidents in an on-call tool.
+type Pager interface {
+	Trigger(ctx context.Context, incident Incident) error
+	Resolve(ctx context.Context, key string) error
+}
+
+// PagerDutyPager sends incidents to a PagerDuty service through the Events
+// API v2, with the incident key as the dedup key.
+type PagerDutyPager struct {
+	routingKey string
+	url        string
+	client     *http.Client
+}
+
+func NewPagerDutyPager(routingKey string) *PagerDutyPager {
+	return &PagerDutyPager{
+		routingKey: routingKey,
+		url:        "https://events.pagerduty.com/v2/enqueue",
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *PagerDutyPager) Trigger(ctx context.Context, incident Incident) error {
+	return p.send(ctx, map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    incident.Key,
+		"payload": map[string]interface{}{
+			"summary":        incident.Summary,
+			"source":         incident.Source,
+			"severity":       "critical",
+			"custom_details": incident.Details,
+		},
+	})
+}
+
+func (p *PagerDutyPager) Resolve(ctx context.Context, key string) error {
+	return p.send(ctx, map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "resolve",
+		"dedup_key":    key,
+	})
+}
+
+func (p *PagerDutyPager) send(ctx context.Context, event map[string]interface{}) error {
+	status, err := postJSON(ctx, p.client, p.url, nil, event)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("pagerduty returned status %d", status)
+	}
+	return nil
+}
+
+// OpsgeniePager sends incidents to Opsgenie as P1 alerts, with the incident
+// key as the alert alias.
+type OpsgeniePager struct {
+	apiKey string
+	url    string
+	client *http.Client
+}
+
+// NewOpsgeniePager talks to apiURL, https://api.opsgenie.com or
+// https://api.eu.opsgenie.com.
+func NewOpsgeniePager(apiKey, apiURL string) *OpsgeniePager {
+	return &OpsgeniePager{
+		apiKey: apiKey,
+		url:    strings.TrimSuffix(apiURL, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OpsgeniePager) Trigger(ctx context.Context, incident Incident) error {
+	details := make(map[string]string, len(incident.Details))
+	for key, value := range incident.Details {
+		details[key] = fmt.Sprint(value)
+	}
+	return p.send(ctx, "/v2/alerts", map[string]interface{}{
+		"message":  incident.Summary,
+		"alias":    incident.Key,
+		"source":   incident.Source,
+		"priority": "P1",
+		"details":  details,
+	})
+}
+
+func (p *OpsgeniePager) Resolve(ctx context.Context, key string) error {
+	return p.send(ctx, "/v2/alerts/"+url.PathEscape(key)+"/close?identifierType=alias", map[string]interface{}{
+		"source": "go_payment",
+	})
+}
+
+func (p *OpsgeniePager) send(ctx context.Context, path string, body map[string]interface{}) error {
+	status, err := postJSON(ctx, p.client, p.url+path, map[string]string{"Authorization": "GenieKey " + p.apiKey}, body)
+	if err != nil {
+		return err
+	}
+	// Closing an alert that no longer exists is fine.
+	if status >= 300 && !(status == http.StatusNotFound && strings.HasSuffix(path, "identifierType=alias")) {
+		return fmt.Errorf("opsgenie returned status %d", status)
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, payload interface{}) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
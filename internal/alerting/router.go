@@ -0,0 +1,166 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+)
+
+// ParseDestinations reads alert destinations from a comma-separated list
+// such as "ops=slack:https://hooks.slack.com/...,compliance=teams:https://...".
+// Kinds are slack, teams and webhook (the raw alert JSON).
+func ParseDestinations(spec string) (map[string]tools.Alerter, error) {
+	destinations := map[string]tools.Alerter{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, target, ok := strings.Cut(entry, "=")
+		kind, url, ok2 := strings.Cut(target, ":")
+		if !ok || !ok2 || name == "" || url == "" {
+			return nil, fmt.Errorf("alert destination %q must be name=kind:url", entry)
+		}
+		switch kind {
+		case "slack":
+			destinations[name] = NewSlackAlerter(url)
+		case "teams":
+			destinations[name] = NewTeamsAlerter(url)
+		case "webhook":
+			destinations[name] = tools.NewWebhookAlerter(url)
+		default:
+			return nil, fmt.Errorf("alert destination %q: unknown kind %q", name, kind)
+		}
+	}
+	return destinations, nil
+}
+
+// ParseRoutes reads routes from a comma-separated list such as
+// "sla_breach=ops,aml_threshold=compliance+ops,*=ops". Each maps an alert
+// type, or a prefix of one ending in "." such as "payout.", to destinations
+// joined with "+"; "*" catches alert types no other route matches.
+func ParseRoutes(spec string) (map[string][]string, error) {
+	routes := map[string][]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		alertType, names, ok := strings.Cut(entry, "=")
+		if !ok || alertType == "" || names == "" {
+			return nil, fmt.Errorf("alert route %q must be type=destination", entry)
+		}
+		routes[alertType] = strings.Split(names, "+")
+	}
+	return routes, nil
+}
+
+// Router sends each alert to the destinations routed for its type, or to
+// every destination when there are no routes. Alerts of a type already
+// sent within RateLimit are dropped and counted; the count goes with the
+// next one that gets through.
+type Router struct {
+	destinations map[string]tools.Alerter
+	routes       map[string][]string
+
+	RateLimit time.Duration
+	Clock     tools.Clock
+
+	mu         sync.Mutex
+	lastSent   map[string]time.Time
+	suppressed map[string]int
+}
+
+func NewRouter(destinations map[string]tools.Alerter, routes map[string][]string) (*Router, error) {
+	for alertType, names := range routes {
+		for _, name := range names {
+			if _, ok := destinations[name]; !ok {
+				return nil, fmt.Errorf("alert route %s: unknown destination %q", alertType, name)
+			}
+		}
+	}
+
+	return &Router{
+		destinations: destinations,
+		routes:       routes,
+		RateLimit:    5 * time.Minute,
+		Clock:        tools.SystemClock{},
+		lastSent:     map[string]time.Time{},
+		suppressed:   map[string]int{},
+	}, nil
+}
+
+func (r *Router) Send(ctx context.Context, alert tools.Alert) error {
+	suppressed, ok := r.admit(alert.Type)
+	if !ok {
+		tools.DefaultMetrics.Inc("alerts_suppressed_total", 1, "type", alert.Type)
+		return nil
+	}
+	if suppressed > 0 {
+		details := make(map[string]interface{}, len(alert.Details)+1)
+		for key, value := range alert.Details {
+			details[key] = value
+		}
+		details["suppressed_since_last"] = suppressed
+		alert.Details = details
+	}
+
+	var errs []error
+	for _, name := range r.route(alert.Type) {
+		if err := r.destinations[name].Send(ctx, alert); err != nil {
+			tools.DefaultMetrics.Inc("alert_failures_total", 1, "destination", name)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// admit reports whether an alert of the type may be sent now, and how many
+// were dropped since the last one.
+func (r *Router) admit(alertType string) (int, bool) {
+	now := r.Clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if last, ok := r.lastSent[alertType]; ok && now.Sub(last) < r.RateLimit {
+		r.suppressed[alertType]++
+		return 0, false
+	}
+	suppressed := r.suppressed[alertType]
+	r.lastSent[alertType] = now
+	delete(r.suppressed, alertType)
+	return suppressed, true
+}
+
+// route returns the destinations for the alert type: its own route, else
+// the longest matching prefix route, else "*", else all of them.
+func (r *Router) route(alertType string) []string {
+	if len(r.routes) == 0 {
+		names := make([]string, 0, len(r.destinations))
+		for name := range r.destinations {
+			names = append(names, name)
+		}
+		return names
+	}
+	if names, ok := r.routes[alertType]; ok {
+		return names
+	}
+
+	best := ""
+	for prefix := range r.routes {
+		if strings.HasSuffix(prefix, ".") && strings.HasPrefix(alertType, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best != "" {
+		return r.routes[best]
+	}
+	return r.routes["*"]
+}
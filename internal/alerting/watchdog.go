@@ -0,0 +1,224 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Check probes a dependency; an error means it is down.
+type Check func(ctx context.Context) error
+
+type watched struct {
+	name  string
+	check Check
+	down  bool
+	since time.Time
+}
+
+// Processor is what the watchdog needs to tell a stalled payment processor.
+// *processors.PaymentProcessor implements it.
+type Processor interface {
+	LastActivity() time.Time
+	Stopped() bool
+}
+
+// Watchdog alerts when a dependency stops answering and when it recovers,
+// and when the payment queue is past its threshold and still growing.
+// Register Run with the scheduler.
+//
+// With a Pager it also pages on-call for the critical conditions (a
+// dependency down, the queue past its hard limit, the processor stopped with
+// payments waiting) and resolves each incident once its condition clears.
+type Watchdog struct {
+	alerter tools.Alerter
+	checks  []*watched
+
+	queueDepth     func(ctx context.Context) (int64, error)
+	queueThreshold int64
+	lastDepth      int64
+
+	// QueueHardLimit pages while the queue is deeper than it. Zero disables
+	// it.
+	QueueHardLimit int64
+
+	processor  Processor
+	stallAfter time.Duration
+
+	// Pager, when set, receives the incidents.
+	Pager Pager
+	// Instance names this instance in incidents, e.g. the hostname.
+	Instance string
+	// open is the incidents paged and not yet resolved, by key.
+	open map[string]bool
+}
+
+// NewWatchdog returns a watchdog sending alerts to alerter, which may be nil
+// when only a Pager is used.
+func NewWatchdog(alerter tools.Alerter) *Watchdog {
+	return &Watchdog{alerter: alerter, open: map[string]bool{}}
+}
+
+// Watch adds a dependency check.
+func (w *Watchdog) Watch(name string, check Check) {
+	w.checks = append(w.checks, &watched{name: name, check: check})
+}
+
+// WatchQueue alerts while depth is above threshold and higher than at the
+// previous run. A zero threshold disables it.
+func (w *Watchdog) WatchQueue(depth func(ctx context.Context) (int64, error), threshold int64) {
+	w.queueDepth = depth
+	w.queueThreshold = threshold
+}
+
+// WatchProcessor pages when payments are waiting but the processor is
+// stopped or hasn't taken one for stallAfter.
+func (w *Watchdog) WatchProcessor(processor Processor, stallAfter time.Duration) {
+	w.processor = processor
+	w.stallAfter = stallAfter
+}
+
+func (w *Watchdog) Run(ctx context.Context) error {
+	now := time.Now()
+	for _, dependency := range w.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := dependency.check(checkCtx)
+		cancel()
+
+		switch {
+		case err != nil && !dependency.down:
+			dependency.down = true
+			dependency.since = now
+			log.Errorf("Dependency %s is down: %v", dependency.name, err)
+			w.send(ctx, tools.Alert{
+				Type:      "dependency_down." + dependency.name,
+				Severity:  "critical",
+				Message:   fmt.Sprintf("%s is not responding: %v", dependency.name, err),
+				Details:   map[string]interface{}{"dependency": dependency.name},
+				Timestamp: now,
+			})
+		case err == nil && dependency.down:
+			dependency.down = false
+			log.Printf("Dependency %s recovered", dependency.name)
+			w.send(ctx, tools.Alert{
+				Type:     "dependency_recovered." + dependency.name,
+				Severity: "info",
+				Message:  fmt.Sprintf("%s is responding again after %s", dependency.name, now.Sub(dependency.since).Round(time.Second)),
+				Details: map[string]interface{}{
+					"dependency":       dependency.name,
+					"downtime_seconds": now.Sub(dependency.since).Seconds(),
+				},
+				Timestamp: now,
+			})
+		}
+
+		summary := ""
+		if err != nil {
+			summary = fmt.Sprintf("%s is not responding: %v", dependency.name, err)
+		}
+		w.incident(ctx, "dependency_down."+dependency.name, summary, map[string]interface{}{
+			"dependency": dependency.name,
+			"since":      dependency.since,
+		})
+	}
+
+	if w.queueDepth == nil {
+		return nil
+	}
+	depth, err := w.queueDepth(ctx)
+	if err != nil {
+		// The redis check reports the outage.
+		return nil
+	}
+	previous := w.lastDepth
+	w.lastDepth = depth
+
+	if w.QueueHardLimit > 0 {
+		summary := ""
+		if depth > w.QueueHardLimit {
+			summary = fmt.Sprintf("Payment queue at %d, past its hard limit of %d", depth, w.QueueHardLimit)
+		}
+		w.incident(ctx, "queue_hard_limit", summary, map[string]interface{}{
+			"depth":      depth,
+			"hard_limit": w.QueueHardLimit,
+		})
+	}
+
+	if w.processor != nil {
+		summary := ""
+		idle := now.Sub(w.processor.LastActivity())
+		switch {
+		case depth == 0:
+		case w.processor.Stopped():
+			summary = fmt.Sprintf("Payment processor on %s is stopped with %d payments queued", w.Instance, depth)
+		case idle > w.stallAfter:
+			summary = fmt.Sprintf("Payment processor on %s has taken no payment for %s with %d queued", w.Instance, idle.Round(time.Second), depth)
+		}
+		w.incident(ctx, "processor_stalled."+w.Instance, summary, map[string]interface{}{
+			"instance":     w.Instance,
+			"queue_depth":  depth,
+			"idle_seconds": idle.Seconds(),
+		})
+	}
+
+	if w.queueThreshold > 0 && depth > w.queueThreshold && depth > previous {
+		w.send(ctx, tools.Alert{
+			Type:     "queue_growth",
+			Severity: "warning",
+			Message:  fmt.Sprintf("Payment queue at %d and growing (threshold %d)", depth, w.queueThreshold),
+			Details: map[string]interface{}{
+				"depth":     depth,
+				"previous":  previous,
+				"threshold": w.queueThreshold,
+			},
+			Timestamp: now,
+		})
+	}
+	return nil
+}
+
+func (w *Watchdog) send(ctx context.Context, alert tools.Alert) {
+	if w.alerter == nil {
+		return
+	}
+	if err := w.alerter.Send(ctx, alert); err != nil {
+		log.Warnf("Failed to send %s alert: %v", alert.Type, err)
+	}
+}
+
+// incident pages for the condition key while summary is set and resolves it
+// once summary is empty. Only changes reach the pager; a failed call is
+// retried on the next run.
+func (w *Watchdog) incident(ctx context.Context, key, summary string, details map[string]interface{}) {
+	if w.Pager == nil || (summary != "") == w.open[key] {
+		return
+	}
+
+	if summary == "" {
+		if err := w.Pager.Resolve(ctx, key); err != nil {
+			log.Warnf("Failed to resolve incident %s: %v", key, err)
+			return
+		}
+		delete(w.open, key)
+		log.Printf("Resolved incident %s", key)
+		tools.DefaultMetrics.Inc("incidents_total", 1, "action", "resolve")
+		return
+	}
+
+	err := w.Pager.Trigger(ctx, Incident{
+		Key:     key,
+		Summary: summary,
+		Source:  w.Instance,
+		Details: details,
+	})
+	if err != nil {
+		log.Errorf("Failed to page incident %s: %v", key, err)
+		return
+	}
+	w.open[key] = true
+	log.Printf("Paged incident %s: %s", key, summary)
+	tools.DefaultMetrics.Inc("incidents_total", 1, "action", "trigger")
+}
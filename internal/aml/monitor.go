@@ -0,0 +1,139 @@
+package aml
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Rules are the reporting thresholds. A zero threshold disables its rule.
+type Rules struct {
+	// SinglePayment alerts on any one payment above it.
+	SinglePayment float64
+	// Aggregate alerts when a customer's payments within Window add up to
+	// more than it.
+	Aggregate float64
+	Window    time.Duration
+}
+
+// Store is the persistence the monitor needs. *tools.DatabaseService
+// implements it.
+type Store interface {
+	Now() time.Time
+	PaymentsTotalSince(ctx context.Context, customerID string, since time.Time) (float64, error)
+	HasAMLAlertSince(ctx context.Context, customerID, rule string, since time.Time) (bool, error)
+	CreateAMLAlert(ctx context.Context, alert *api.AMLAlert) (bool, error)
+}
+
+// Monitor checks every applied payment against the rules and raises
+// compliance alerts. It is a payment processor hook; add it with
+// PaymentProcessor.Use.
+type Monitor struct {
+	processors.HookFuncs
+	store Store
+	rules Rules
+	// Alerter, when set, is also told about every new alert.
+	Alerter tools.Alerter
+}
+
+func NewMonitor(store Store, rules Rules) *Monitor {
+	m := &Monitor{store: store, rules: rules}
+	m.After = m.check
+	return m
+}
+
+// check runs after the payment is applied, so the aggregate includes it.
+// Failures are logged rather than failing a payment that has already been
+// applied.
+func (m *Monitor) check(ctx context.Context, payment *api.PaymentPayload, result processors.ApplyResult) {
+	now := m.store.Now()
+
+	if m.rules.SinglePayment > 0 && result.Amount > m.rules.SinglePayment {
+		m.raise(ctx, &api.AMLAlert{
+			CustomerID:           payment.CustomerID,
+			Rule:                 api.AMLRuleSinglePayment,
+			TransactionReference: payment.TransactionReference,
+			Amount:               result.Amount,
+			Threshold:            m.rules.SinglePayment,
+			TriggeredAt:          now,
+		})
+	}
+
+	if m.rules.Aggregate <= 0 || m.rules.Window <= 0 {
+		return
+	}
+
+	since := now.Add(-m.rules.Window)
+	total, err := m.store.PaymentsTotalSince(ctx, payment.CustomerID, since)
+	if err != nil {
+		log.Warnf("AML aggregate check failed for %s: %v", payment.CustomerID, err)
+		return
+	}
+	if total <= m.rules.Aggregate {
+		return
+	}
+
+	// One aggregate alert per window: further payments in the same burst
+	// are already covered by the open alert.
+	alerted, err := m.store.HasAMLAlertSince(ctx, payment.CustomerID, api.AMLRuleAggregate, since)
+	if err != nil {
+		log.Warnf("AML aggregate check failed for %s: %v", payment.CustomerID, err)
+		return
+	}
+	if alerted {
+		return
+	}
+
+	m.raise(ctx, &api.AMLAlert{
+		CustomerID:           payment.CustomerID,
+		Rule:                 api.AMLRuleAggregate,
+		TransactionReference: payment.TransactionReference,
+		Amount:               total,
+		Threshold:            m.rules.Aggregate,
+		WindowStart:          &since,
+		TriggeredAt:          now,
+	})
+}
+
+func (m *Monitor) raise(ctx context.Context, alert *api.AMLAlert) {
+	created, err := m.store.CreateAMLAlert(ctx, alert)
+	if err != nil {
+		log.Errorf("Failed to record AML %s alert for %s (%s): %v", alert.Rule, alert.CustomerID, alert.TransactionReference, err)
+		return
+	}
+	if !created {
+		return
+	}
+
+	tools.DefaultMetrics.Inc("aml_alerts_total", 1, "rule", alert.Rule)
+	log.Printf("AML %s alert %d for %s: %.2f above %.2f", alert.Rule, alert.ID, alert.CustomerID, alert.Amount, alert.Threshold)
+
+	if m.Alerter != nil {
+		// The webhook can be slow; don't hold up the payment worker.
+		go m.notify(context.WithoutCancel(ctx), alert)
+	}
+}
+
+func (m *Monitor) notify(ctx context.Context, alert *api.AMLAlert) {
+	err := m.Alerter.Send(ctx, tools.Alert{
+		Type:     "aml_threshold",
+		Severity: "warning",
+		Message:  fmt.Sprintf("AML %s threshold crossed by %s", alert.Rule, alert.CustomerID),
+		Details: map[string]interface{}{
+			"alert_id":              alert.ID,
+			"customer_id":           alert.CustomerID,
+			"transaction_reference": alert.TransactionReference,
+			"amount":                alert.Amount,
+			"threshold":             alert.Threshold,
+		},
+		Timestamp: alert.TriggeredAt,
+	})
+	if err != nil {
+		log.Warnf("Failed to send AML alert %d: %v", alert.ID, err)
+	}
+}
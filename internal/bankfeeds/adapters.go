@@ -0,0 +1,298 @@
+package bankfeeds
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transaction is a statement line normalised from a provider webhook.
+type Transaction struct {
+	ExternalID string
+	AccountID  string
+	Amount     float64
+	Currency   string
+	Credit     bool
+	Narration  string
+	Reference  string
+	Date       time.Time
+}
+
+// Adapter understands one provider's webhook: how it authenticates and how
+// its transaction payload is shaped.
+type Adapter interface {
+	Name() string
+	Verify(header http.Header, body []byte) bool
+	Parse(body []byte) ([]Transaction, error)
+}
+
+// MonoAdapter handles Mono account webhooks. Mono authenticates by echoing the
+// webhook secret in the mono-webhook-secret header and reports amounts in
+// kobo.
+type MonoAdapter struct {
+	secret string
+}
+
+func NewMonoAdapter(secret string) *MonoAdapter {
+	return &MonoAdapter{secret: secret}
+}
+
+func (a *MonoAdapter) Name() string { return "mono" }
+
+func (a *MonoAdapter) Verify(header http.Header, body []byte) bool {
+	return a.secret != "" &&
+		subtle.ConstantTimeCompare([]byte(header.Get("mono-webhook-secret")), []byte(a.secret)) == 1
+}
+
+func (a *MonoAdapter) Parse(body []byte) ([]Transaction, error) {
+	var payload struct {
+		Data struct {
+			Account struct {
+				ID string `json:"_id"`
+			} `json:"account"`
+			Transactions []struct {
+				ID        string  `json:"_id"`
+				Amount    float64 `json:"amount"`
+				Currency  string  `json:"currency"`
+				Type      string  `json:"type"`
+				Narration string  `json:"narration"`
+				Date      string  `json:"date"`
+			} `json:"transactions"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	transactions := make([]Transaction, 0, len(payload.Data.Transactions))
+	for _, line := range payload.Data.Transactions {
+		date, err := parseFeedDate(line.Date)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %v", line.ID, err)
+		}
+		transactions = append(transactions, Transaction{
+			ExternalID: line.ID,
+			AccountID:  payload.Data.Account.ID,
+			Amount:     line.Amount / 100,
+			Currency:   line.Currency,
+			Credit:     strings.EqualFold(line.Type, "credit"),
+			Narration:  line.Narration,
+			Date:       date,
+		})
+	}
+	return transactions, nil
+}
+
+// OkraAdapter handles Okra transaction callbacks, signed with an HMAC-SHA256
+// of the body in X-Okra-Signature. Credits and debits arrive as separate
+// amount fields.
+type OkraAdapter struct {
+	secret string
+}
+
+func NewOkraAdapter(secret string) *OkraAdapter {
+	return &OkraAdapter{secret: secret}
+}
+
+func (a *OkraAdapter) Name() string { return "okra" }
+
+func (a *OkraAdapter) Verify(header http.Header, body []byte) bool {
+	return verifyHMAC(a.secret, header.Get("X-Okra-Signature"), body)
+}
+
+func (a *OkraAdapter) Parse(body []byte) ([]Transaction, error) {
+	var payload struct {
+		Data struct {
+			Transactions []struct {
+				ID       string   `json:"_id"`
+				Account  string   `json:"account"`
+				Credit   *float64 `json:"credit"`
+				Debit    *float64 `json:"debit"`
+				Currency string   `json:"currency"`
+				Ref      string   `json:"ref"`
+				Date     string   `json:"trans_date"`
+				Notes    struct {
+					Desc string `json:"desc"`
+				} `json:"notes"`
+			} `json:"transactions"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	transactions := make([]Transaction, 0, len(payload.Data.Transactions))
+	for _, line := range payload.Data.Transactions {
+		date, err := parseFeedDate(line.Date)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %v", line.ID, err)
+		}
+		txn := Transaction{
+			ExternalID: line.ID,
+			AccountID:  line.Account,
+			Currency:   line.Currency,
+			Narration:  line.Notes.Desc,
+			Reference:  line.Ref,
+			Date:       date,
+		}
+		if line.Credit != nil && *line.Credit > 0 {
+			txn.Amount, txn.Credit = *line.Credit, true
+		} else if line.Debit != nil {
+			txn.Amount = *line.Debit
+		}
+		transactions = append(transactions, txn)
+	}
+	return transactions, nil
+}
+
+// OpenBankingAdapter handles feeds in the Open Banking (OBIE) account
+// transactions shape, signed with an HMAC-SHA256 of the body in X-Signature.
+type OpenBankingAdapter struct {
+	secret string
+}
+
+func NewOpenBankingAdapter(secret string) *OpenBankingAdapter {
+	return &OpenBankingAdapter{secret: secret}
+}
+
+func (a *OpenBankingAdapter) Name() string { return "openbanking" }
+
+func (a *OpenBankingAdapter) Verify(header http.Header, body []byte) bool {
+	return verifyHMAC(a.secret, header.Get("X-Signature"), body)
+}
+
+func (a *OpenBankingAdapter) Parse(body []byte) ([]Transaction, error) {
+	var payload struct {
+		Data struct {
+			Transaction []struct {
+				TransactionID          string `json:"TransactionId"`
+				AccountID              string `json:"AccountId"`
+				TransactionReference   string `json:"TransactionReference"`
+				TransactionInformation string `json:"TransactionInformation"`
+				CreditDebitIndicator   string `json:"CreditDebitIndicator"`
+				BookingDateTime        string `json:"BookingDateTime"`
+				Amount                 struct {
+					Amount   string `json:"Amount"`
+					Currency string `json:"Currency"`
+				} `json:"Amount"`
+			} `json:"Transaction"`
+		} `json:"Data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	transactions := make([]Transaction, 0, len(payload.Data.Transaction))
+	for _, line := range payload.Data.Transaction {
+		amount, err := strconv.ParseFloat(line.Amount.Amount, 64)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: invalid amount %q", line.TransactionID, line.Amount.Amount)
+		}
+		date, err := parseFeedDate(line.BookingDateTime)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %v", line.TransactionID, err)
+		}
+		transactions = append(transactions, Transaction{
+			ExternalID: line.TransactionID,
+			AccountID:  line.AccountID,
+			Amount:     amount,
+			Currency:   line.Amount.Currency,
+			Credit:     strings.EqualFold(line.CreditDebitIndicator, "Credit"),
+			Narration:  line.TransactionInformation,
+			Reference:  line.TransactionReference,
+			Date:       date,
+		})
+	}
+	return transactions, nil
+}
+
+// VirtualAccountAdapter handles inbound transfer notifications from a
+// virtual-account provider, signed with an HMAC-SHA256 of the body in
+// X-Signature. AccountID is the virtual account number paid into, which
+// identifies the customer on its own.
+type VirtualAccountAdapter struct {
+	name   string
+	secret string
+}
+
+func NewVirtualAccountAdapter(name, secret string) *VirtualAccountAdapter {
+	return &VirtualAccountAdapter{name: name, secret: secret}
+}
+
+func (a *VirtualAccountAdapter) Name() string { return a.name }
+
+func (a *VirtualAccountAdapter) Verify(header http.Header, body []byte) bool {
+	return verifyHMAC(a.secret, header.Get("X-Signature"), body)
+}
+
+func (a *VirtualAccountAdapter) Parse(body []byte) ([]Transaction, error) {
+	var payload struct {
+		Event string `json:"event"`
+		Data  struct {
+			ID            string  `json:"id"`
+			AccountNumber string  `json:"account_number"`
+			Amount        float64 `json:"amount"`
+			Currency      string  `json:"currency"`
+			Narration     string  `json:"narration"`
+			Reference     string  `json:"reference"`
+			SenderName    string  `json:"sender_name"`
+			PaidAt        string  `json:"paid_at"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	// Other events, e.g. account updates, carry no transfer.
+	if payload.Event != "transfer.received" {
+		return nil, nil
+	}
+
+	line := payload.Data
+	date, err := parseFeedDate(line.PaidAt)
+	if err != nil {
+		return nil, fmt.Errorf("transfer %s: %v", line.ID, err)
+	}
+	narration := line.Narration
+	if line.SenderName != "" {
+		narration = strings.TrimSpace(line.SenderName + " " + narration)
+	}
+	return []Transaction{{
+		ExternalID: line.ID,
+		AccountID:  line.AccountNumber,
+		Amount:     line.Amount,
+		Currency:   line.Currency,
+		Credit:     true,
+		Narration:  narration,
+		Reference:  line.Reference,
+		Date:       date,
+	}}, nil
+}
+
+func verifyHMAC(secret, signature string, body []byte) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func parseFeedDate(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05.000Z", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", value)
+}
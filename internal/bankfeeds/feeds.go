@@ -0,0 +1,273 @@
+package bankfeeds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrAlreadyReviewed = errors.New("bank feed transaction has already been reviewed")
+
+// DefaultPatterns finds a customer ID anywhere in the narration or reference,
+// which is how customers are told to label their transfers.
+var DefaultPatterns = []string{`(?i)\b(?P<customer_id>GIG\d{3,})\b`}
+
+// Matcher extracts customer references from narrations. Each pattern names
+// its capture group after what it captured: customer_id, or an identifier
+// type (phone, national_id, partner_ref) resolved through the identifier
+// mappings.
+type Matcher struct {
+	db       *tools.DatabaseService
+	patterns []*regexp.Regexp
+}
+
+func NewMatcher(db *tools.DatabaseService, patterns []string) (*Matcher, error) {
+	matcher := &Matcher{db: db}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bank feed pattern %q: %v", pattern, err)
+		}
+		named := false
+		for _, name := range re.SubexpNames() {
+			named = named || name != ""
+		}
+		if !named {
+			return nil, fmt.Errorf("bank feed pattern %q has no named capture group", pattern)
+		}
+		matcher.patterns = append(matcher.patterns, re)
+	}
+	return matcher, nil
+}
+
+// Match returns the customer the transfer is for, or "" when nothing in the
+// narration or reference resolves to a known account. Transfers into a
+// virtual account belong to its customer whatever the narration says.
+func (m *Matcher) Match(ctx context.Context, txn Transaction) (string, error) {
+	if txn.AccountID != "" {
+		customerID, err := m.db.ResolveVirtualAccount(ctx, txn.AccountID)
+		if err == nil {
+			return customerID, nil
+		}
+		if !errors.Is(err, tools.ErrNotFound) {
+			return "", err
+		}
+	}
+
+	text := txn.Reference + " " + txn.Narration
+	for _, re := range m.patterns {
+		for _, match := range re.FindAllStringSubmatch(text, -1) {
+			for i, name := range re.SubexpNames() {
+				if name == "" || match[i] == "" {
+					continue
+				}
+				customerID, err := m.resolve(ctx, name, match[i])
+				if err != nil {
+					return "", err
+				}
+				if customerID != "" {
+					return customerID, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+func (m *Matcher) resolve(ctx context.Context, kind, value string) (string, error) {
+	if kind == "customer_id" {
+		customerID := strings.ToUpper(value)
+		if _, err := m.db.GetCustomerVersion(ctx, customerID); err != nil {
+			return "", nil
+		}
+		return customerID, nil
+	}
+
+	customerID, err := m.db.ResolveCustomerID(ctx, api.IdentifierType(kind), value)
+	if err != nil {
+		return "", nil
+	}
+	return customerID, nil
+}
+
+// IngestResult summarises one webhook delivery.
+type IngestResult struct {
+	Received   int `json:"received"`
+	Matched    int `json:"matched"`
+	Unmatched  int `json:"unmatched"`
+	Duplicates int `json:"duplicates"`
+	Skipped    int `json:"skipped"`
+}
+
+// Service turns bank feed lines into queued payments. Lines that can't be
+// matched to a customer wait in the review queue for manual assignment.
+type Service struct {
+	db       *tools.DatabaseService
+	redis    *tools.RedisService
+	matcher  *Matcher
+	adapters map[string]Adapter
+}
+
+func NewService(db *tools.DatabaseService, redis *tools.RedisService, matcher *Matcher, adapters ...Adapter) *Service {
+	service := &Service{
+		db:       db,
+		redis:    redis,
+		matcher:  matcher,
+		adapters: make(map[string]Adapter),
+	}
+	for _, adapter := range adapters {
+		service.adapters[adapter.Name()] = adapter
+	}
+	return service
+}
+
+func (s *Service) Adapter(provider string) (Adapter, bool) {
+	adapter, ok := s.adapters[provider]
+	return adapter, ok
+}
+
+func (s *Service) Ingest(ctx context.Context, provider string, transactions []Transaction) (*IngestResult, error) {
+	result := &IngestResult{Received: len(transactions)}
+
+	for _, txn := range transactions {
+		// Only inbound transfers can be repayments.
+		if !txn.Credit || txn.Amount <= 0 || txn.ExternalID == "" {
+			result.Skipped++
+			continue
+		}
+
+		customerID, err := s.matcher.Match(ctx, txn)
+		if err != nil {
+			return result, err
+		}
+
+		record := &api.BankFeedTransaction{
+			Provider:        provider,
+			ExternalID:      txn.ExternalID,
+			AccountID:       txn.AccountID,
+			Amount:          txn.Amount,
+			Currency:        txn.Currency,
+			Narration:       txn.Narration,
+			Reference:       txn.Reference,
+			TransactionDate: txn.Date,
+			Status:          api.BankFeedUnmatched,
+		}
+		if record.Currency == "" {
+			record.Currency = "NGN"
+		}
+		if customerID != "" {
+			record.Status = api.BankFeedMatched
+			record.CustomerID = customerID
+			record.PaymentReference = paymentReference(provider, txn.ExternalID)
+		}
+
+		recorded, created, err := s.db.RecordBankFeedTransaction(ctx, record)
+		if err != nil {
+			return result, err
+		}
+		if !created {
+			result.Duplicates++
+			continue
+		}
+
+		if recorded.Status == api.BankFeedUnmatched {
+			result.Unmatched++
+			tools.DefaultMetrics.Inc("bank_feed_transactions_total", 1, "provider", provider, "status", "unmatched")
+			continue
+		}
+
+		if err := s.enqueue(ctx, recorded, customerID); err != nil {
+			// Redeliveries are dropped as duplicates, so park the line for
+			// review rather than lose it.
+			log.Printf("Bank feed %s/%s: %v", provider, txn.ExternalID, err)
+			if err := s.db.ReturnBankFeedTransactionToReview(ctx, recorded.ID, "enqueue failed: "+err.Error()); err != nil {
+				return result, err
+			}
+			result.Unmatched++
+			continue
+		}
+		result.Matched++
+		tools.DefaultMetrics.Inc("bank_feed_transactions_total", 1, "provider", provider, "status", "matched")
+	}
+
+	return result, nil
+}
+
+// Assign resolves an unmatched line to a customer and queues the payment.
+func (s *Service) Assign(ctx context.Context, id int64, customerID, reviewer, note string) (*api.BankFeedTransaction, error) {
+	txn, err := s.db.GetBankFeedTransaction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if txn.Status != api.BankFeedUnmatched {
+		return nil, ErrAlreadyReviewed
+	}
+
+	// The payment reference is derived from the feed line, so enqueueing
+	// before the status update is safe: a concurrent assign would produce the
+	// same reference and be dropped as a duplicate by the processor.
+	if err := s.enqueue(ctx, txn, customerID); err != nil {
+		return nil, err
+	}
+
+	return s.db.ReviewBankFeedTransaction(ctx, id, api.BankFeedAssigned, customerID,
+		paymentReference(txn.Provider, txn.ExternalID), reviewer, note)
+}
+
+func (s *Service) Ignore(ctx context.Context, id int64, reviewer, note string) (*api.BankFeedTransaction, error) {
+	return s.db.ReviewBankFeedTransaction(ctx, id, api.BankFeedIgnored, "", "", reviewer, note)
+}
+
+func (s *Service) enqueue(ctx context.Context, txn *api.BankFeedTransaction, customerID string) error {
+	payment := &api.PaymentPayload{
+		CustomerID:           customerID,
+		PaymentStatus:        api.StatusComplete,
+		TransactionAmount:    fmt.Sprintf("%.2f", txn.Amount),
+		TransactionDate:      txn.TransactionDate.Format("2006-01-02 15:04:05"),
+		TransactionReference: paymentReference(txn.Provider, txn.ExternalID),
+		Currency:             txn.Currency,
+		Channel:              "bank_transfer",
+		Metadata: api.Metadata{
+			"bank_feed":            txn.Provider,
+			"bank_feed_narration":  txn.Narration,
+			"bank_feed_account_id": txn.AccountID,
+		},
+	}
+
+	if isDup, err := s.redis.IsDuplicate(ctx, payment.TransactionReference); err == nil && isDup {
+		return nil
+	}
+
+	if err := s.redis.EnqueuePayment(ctx, payment); err != nil {
+		return fmt.Errorf("enqueue %s: %v", payment.TransactionReference, err)
+	}
+	if err := s.db.ArchivePayment(ctx, payment); err != nil {
+		log.Printf("Warning: failed to archive payment %s: %v", payment.TransactionReference, err)
+	}
+	return nil
+}
+
+func paymentReference(provider, externalID string) string {
+	return "BANKFEED-" + strings.ToUpper(provider) + "-" + externalID
+}
+
+// ParsePatterns splits the BANK_FEED_PATTERNS setting; patterns are separated
+// by newlines or ";;" since regexes commonly contain commas.
+func ParsePatterns(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return DefaultPatterns
+	}
+	var patterns []string
+	for _, line := range strings.FieldsFunc(strings.ReplaceAll(value, ";;", "\n"), func(r rune) bool { return r == '\n' }) {
+		if line = strings.TrimSpace(line); line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,171 @@
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // business time zones must resolve in minimal containers
+)
+
+const dateLayout = "2006-01-02"
+
+// Calendar knows which days are business days and the daily cut-off after
+// which a payment counts as received on the next business day.
+type Calendar struct {
+	Location *time.Location
+	// CutOff is the time of day, as an offset from midnight, from which
+	// payments are value-dated to the next business day. Zero disables it.
+	CutOff   time.Duration
+	weekend  map[time.Weekday]bool
+	holidays map[string]string
+}
+
+func New(location *time.Location, weekend []time.Weekday, cutOff time.Duration) *Calendar {
+	if location == nil {
+		location = time.UTC
+	}
+	cal := &Calendar{
+		Location: location,
+		CutOff:   cutOff,
+		weekend:  make(map[time.Weekday]bool),
+		holidays: make(map[string]string),
+	}
+	for _, day := range weekend {
+		cal.weekend[day] = true
+	}
+	return cal
+}
+
+// AddHoliday marks a date (in the calendar's location) as a non-business day.
+func (c *Calendar) AddHoliday(date time.Time, name string) {
+	c.holidays[date.In(c.Location).Format(dateLayout)] = name
+}
+
+// Holiday returns the holiday's name if the day is a public holiday.
+func (c *Calendar) Holiday(t time.Time) (string, bool) {
+	name, ok := c.holidays[t.In(c.Location).Format(dateLayout)]
+	return name, ok
+}
+
+func (c *Calendar) IsBusinessDay(t time.Time) bool {
+	t = t.In(c.Location)
+	if c.weekend[t.Weekday()] {
+		return false
+	}
+	_, holiday := c.Holiday(t)
+	return !holiday
+}
+
+// NextBusinessDay returns midnight of the first business day after t's date.
+func (c *Calendar) NextBusinessDay(t time.Time) time.Time {
+	day := c.startOfDay(t)
+	// A year of consecutive non-business days means a misconfigured
+	// calendar; stop rather than loop forever.
+	for i := 0; i < 366; i++ {
+		day = day.AddDate(0, 0, 1)
+		if c.IsBusinessDay(day) {
+			return day
+		}
+	}
+	return day
+}
+
+// ValueDate is the business day a payment received at t counts towards:
+// the same day if it is a business day and t is before the cut-off, otherwise
+// the next business day.
+func (c *Calendar) ValueDate(t time.Time) time.Time {
+	day := c.startOfDay(t)
+	if !c.IsBusinessDay(day) {
+		return c.NextBusinessDay(day)
+	}
+	if c.CutOff > 0 && t.In(c.Location).Sub(day) >= c.CutOff {
+		return c.NextBusinessDay(day)
+	}
+	return day
+}
+
+// Shift moves t forward whole days until it falls on a business day, keeping
+// its time of day. The date is read from t's own wall clock so naive database
+// timestamps shift the same way as they do in SQL.
+func (c *Calendar) Shift(t time.Time) time.Time {
+	for i := 0; i < 366; i++ {
+		if c.IsBusinessDay(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.Location)) {
+			return t
+		}
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// ParseTime reads a transaction timestamp, interpreting zone-less values in
+// the calendar's location.
+func (c *Calendar) ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", dateLayout} {
+		if t, err := time.ParseInLocation(layout, value, c.Location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", value)
+}
+
+func (c *Calendar) startOfDay(t time.Time) time.Time {
+	t = t.In(c.Location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.Location)
+}
+
+var weekdays = map[string]time.Weekday{
+	"SUN": time.Sunday, "MON": time.Monday, "TUE": time.Tuesday, "WED": time.Wednesday,
+	"THU": time.Thursday, "FRI": time.Friday, "SAT": time.Saturday,
+}
+
+// ParseWeekend reads a comma-separated list of day names, e.g. "SAT,SUN" or
+// "FRI,SAT".
+func ParseWeekend(value string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		day, ok := weekdays[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", name)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// ParseCutOff reads an "HH:MM" time of day; "" disables the cut-off.
+func ParseCutOff(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("cut-off must be HH:MM, got %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseHolidays reads comma-separated YYYY-MM-DD dates into the calendar.
+func (c *Calendar) ParseHolidays(value string) error {
+	for _, date := range strings.Split(value, ",") {
+		date = strings.TrimSpace(date)
+		if date == "" {
+			continue
+		}
+		day, err := time.ParseInLocation(dateLayout, date, c.Location)
+		if err != nil {
+			return fmt.Errorf("invalid holiday %q", date)
+		}
+		c.AddHoliday(day, "")
+	}
+	return nil
+}
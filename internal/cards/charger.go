@@ -0,0 +1,225 @@
+package cards
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/dunning"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Charger charges saved cards for installments that have fallen due.
+// Approved charges are queued as card payments like any other; declined
+// ones go through dunning, which retries them on the account's policy and
+// tells the customer.
+type Charger struct {
+	db      *tools.DatabaseService
+	redis   *tools.RedisService
+	gateway Gateway
+	dunning *dunning.Service
+
+	Currency  string
+	BatchSize int
+	// Lease is how long a claimed charge stays with the instance attempting
+	// it.
+	Lease time.Duration
+}
+
+func NewCharger(db *tools.DatabaseService, redis *tools.RedisService, gateway Gateway, dunning *dunning.Service) *Charger {
+	return &Charger{
+		db:        db,
+		redis:     redis,
+		gateway:   gateway,
+		dunning:   dunning,
+		Currency:  "NGN",
+		BatchSize: 100,
+		Lease:     10 * time.Minute,
+	}
+}
+
+func (c *Charger) Gateway() Gateway { return c.gateway }
+
+// Run schedules charges for newly due installments, then attempts the
+// charges that are due. Register it with the scheduler.
+func (c *Charger) Run(ctx context.Context) error {
+	if err := c.scheduleCharges(ctx); err != nil {
+		return err
+	}
+	return c.attemptCharges(ctx)
+}
+
+// amountDue is what the card should be charged now: the account's arrears,
+// never more than it owes.
+func (c *Charger) amountDue(ctx context.Context, account *api.CustomerAccount, now time.Time) float64 {
+	cal, err := c.db.CustomerCalendar(ctx, account)
+	if err != nil {
+		log.Warnf("Failed to load holiday calendar for %s: %v", account.CustomerID, err)
+	}
+	arrears := schedule.Arrears(account, now, cal)
+	return math.Min(math.Round(arrears*100)/100, account.OutstandingBalance)
+}
+
+func (c *Charger) scheduleCharges(ctx context.Context) error {
+	candidates, err := c.db.CardChargeCandidates(ctx, c.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	now := c.db.Now()
+	for _, candidate := range candidates {
+		account, err := c.db.GetCustomer(ctx, candidate.CustomerID)
+		if err != nil {
+			return err
+		}
+		amount := c.amountDue(ctx, account, now)
+		if amount <= 0 {
+			continue
+		}
+
+		installment := schedule.WeeksElapsed(account, now)
+		charge := &api.CardCharge{
+			Reference:     fmt.Sprintf("CARD-%s-%d", account.CustomerID, installment),
+			CustomerID:    account.CustomerID,
+			CardID:        candidate.CardID,
+			Installment:   installment,
+			Amount:        amount,
+			NextAttemptAt: now,
+		}
+		if _, err := c.db.CreateCardCharge(ctx, charge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Charger) attemptCharges(ctx context.Context) error {
+	charges, err := c.db.ClaimDueCardCharges(ctx, c.BatchSize, c.Lease)
+	if err != nil {
+		return err
+	}
+
+	for _, charge := range charges {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.attempt(ctx, charge); err != nil {
+			// The lease brings the charge back for another try.
+			log.Errorf("Card charge %s: %v", charge.Reference, err)
+		}
+	}
+	return nil
+}
+
+func (c *Charger) attempt(ctx context.Context, charge *api.CardCharge) error {
+	now := c.db.Now()
+
+	account, err := c.db.GetCustomer(ctx, charge.CustomerID)
+	if err != nil {
+		return err
+	}
+	card, err := c.db.GetCardToken(ctx, charge.CardID)
+	if err != nil {
+		return err
+	}
+
+	// The customer may have paid some other way since the charge was
+	// scheduled.
+	amount := c.amountDue(ctx, account, now)
+	switch {
+	case card.Status != api.CardActive:
+		return c.finish(ctx, charge, api.CardChargeCancelled, "card revoked")
+	case amount <= 0:
+		c.resolveDunning(ctx, charge)
+		return c.finish(ctx, charge, api.CardChargeCancelled, "installment paid")
+	}
+	charge.Amount = amount
+
+	// The reference stays the same across attempts so a retry after a
+	// timeout is deduplicated by the gateway instead of charging twice.
+	result, err := c.gateway.Charge(ctx, ChargeRequest{
+		Reference:  charge.Reference,
+		CustomerID: charge.CustomerID,
+		Token:      card.Token,
+		Amount:     amount,
+		Currency:   c.Currency,
+	})
+	if err != nil {
+		return c.retry(ctx, charge, true, err.Error())
+	}
+	if !result.Approved {
+		return c.retry(ctx, charge, result.Retryable, result.Message)
+	}
+
+	charge.GatewayReference = result.GatewayReference
+	if err := c.enqueue(ctx, charge, card, now); err != nil {
+		// The money has been taken, so never charge again; the archived
+		// payment can be replayed.
+		log.Errorf("Card charge %s approved but not queued: %v", charge.Reference, err)
+		tools.DefaultMetrics.Inc("card_charges_total", 1, "status", "unqueued")
+		return c.finish(ctx, charge, api.CardChargeSucceeded, "approved but not queued: "+err.Error())
+	}
+	tools.DefaultMetrics.Inc("card_charges_total", 1, "status", "succeeded")
+	c.resolveDunning(ctx, charge)
+	return c.finish(ctx, charge, api.CardChargeSucceeded, "")
+}
+
+func (c *Charger) resolveDunning(ctx context.Context, charge *api.CardCharge) {
+	if err := c.dunning.Resolve(ctx, charge.CustomerID); err != nil {
+		log.Warnf("Failed to resolve dunning for %s: %v", charge.CustomerID, err)
+	}
+}
+
+func (c *Charger) finish(ctx context.Context, charge *api.CardCharge, status, reason string) error {
+	charge.Status = status
+	charge.FailureReason = reason
+	return c.db.UpdateCardCharge(ctx, charge)
+}
+
+// retry hands the failed attempt to dunning, which decides whether and
+// when to try again under the account's policy.
+func (c *Charger) retry(ctx context.Context, charge *api.CardCharge, retryable bool, reason string) error {
+	charge.FailureReason = reason
+	next, retry, err := c.dunning.Failed(ctx, charge, retryable)
+	if err != nil {
+		return err
+	}
+	if retry {
+		charge.NextAttemptAt = next
+		tools.DefaultMetrics.Inc("card_charges_total", 1, "status", "retrying")
+	} else {
+		charge.Status = api.CardChargeFailed
+		tools.DefaultMetrics.Inc("card_charges_total", 1, "status", "failed")
+	}
+	log.Printf("Card charge %s attempt %d declined: %s", charge.Reference, charge.Attempts, reason)
+	return c.db.UpdateCardCharge(ctx, charge)
+}
+
+func (c *Charger) enqueue(ctx context.Context, charge *api.CardCharge, card *api.CardToken, now time.Time) error {
+	payment := &api.PaymentPayload{
+		CustomerID:           charge.CustomerID,
+		PaymentStatus:        api.StatusComplete,
+		TransactionAmount:    fmt.Sprintf("%.2f", charge.Amount),
+		TransactionDate:      now.Format("2006-01-02 15:04:05"),
+		TransactionReference: charge.Reference,
+		Currency:             c.Currency,
+		Channel:              "card",
+		Metadata: api.Metadata{
+			"card_charge_id":    charge.ID,
+			"card_gateway":      card.Gateway,
+			"card_last4":        card.Last4,
+			"gateway_reference": charge.GatewayReference,
+		},
+	}
+
+	// Archive first so an approved charge is on record even if queueing
+	// fails.
+	if err := c.db.ArchivePayment(ctx, payment); err != nil {
+		log.Printf("Warning: failed to archive payment %s: %v", payment.TransactionReference, err)
+	}
+	return c.redis.EnqueuePayment(ctx, payment)
+}
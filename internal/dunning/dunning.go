@@ -0,0 +1,258 @@
+package dunning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPolicy applies to accounts without a loan product. It matches the
+// loan_products column defaults.
+var DefaultPolicy = api.DunningPolicy{RetryDays: []int{1, 3, 5}, DelinquentDays: 14}
+
+// Service moves accounts through dunning as their automatic debits fail
+// and tells the customer at each step, more insistently as it goes: an SMS
+// reminder on the first failure, an SMS warning on later ones, and SMS and
+// email for the final notice and for delinquency. The messages are the
+// dunning_* templates.
+type Service struct {
+	db *tools.DatabaseService
+	// Notifier, when set, sends the dunning messages.
+	Notifier *notifications.Notifier
+	// PhoneChannel is the channel for the messages sent to the customer's
+	// phone, SMS unless set to WhatsApp.
+	PhoneChannel notifications.Channel
+	// BatchSize is how many open cases Run loads at a time.
+	BatchSize int
+}
+
+func NewService(db *tools.DatabaseService) *Service {
+	return &Service{db: db, PhoneChannel: notifications.ChannelSMS, BatchSize: 100}
+}
+
+// Policy returns the dunning policy of the account's loan product, or
+// DefaultPolicy.
+func (s *Service) Policy(ctx context.Context, customerID string) (api.DunningPolicy, error) {
+	policy, err := s.db.DunningPolicy(ctx, customerID)
+	if errors.Is(err, tools.ErrNotFound) {
+		return DefaultPolicy, nil
+	}
+	if err != nil {
+		return api.DunningPolicy{}, err
+	}
+	return *policy, nil
+}
+
+// Failed records a failed attempt at the charge, whose Attempts and
+// FailureReason are already set, and returns when to retry it. retry is
+// false once the policy's retries have run out or the failure isn't worth
+// retrying; the account then gets its final notice.
+func (s *Service) Failed(ctx context.Context, charge *api.CardCharge, retryable bool) (next time.Time, retry bool, err error) {
+	policy, err := s.Policy(ctx, charge.CustomerID)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	dunning, err := s.openCase(ctx, charge.CustomerID)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	now := s.db.Now()
+	dunning.FailedAttempts++
+	dunning.ChargeReference = charge.Reference
+	dunning.LastFailureReason = charge.FailureReason
+	dunning.LastFailureAt = &now
+	dunning.NextRetryAt = nil
+
+	retry = retryable && charge.Attempts <= len(policy.RetryDays)
+	if retry {
+		next = now.AddDate(0, 0, policy.RetryDays[charge.Attempts-1])
+		dunning.NextRetryAt = &next
+	}
+
+	// A delinquent account stays delinquent until it is brought up to date.
+	switch {
+	case dunning.Status == api.DunningDelinquent:
+	case retry:
+		dunning.Status = api.DunningRetrying
+	case policy.DelinquentDays == 0:
+		s.markDelinquent(dunning, now)
+	default:
+		deadline := now.AddDate(0, 0, policy.DelinquentDays)
+		dunning.Status = api.DunningFinalNotice
+		dunning.DelinquentAfter = &deadline
+	}
+
+	if err := s.db.SaveDunningCase(ctx, dunning); err != nil {
+		return time.Time{}, false, err
+	}
+	tools.DefaultMetrics.Inc("dunning_transitions_total", 1, "status", dunning.Status)
+	s.notify(ctx, dunning, charge.Amount)
+	return next, retry, nil
+}
+
+// Resolve closes the account's open case, if any, e.g. after a successful
+// debit.
+func (s *Service) Resolve(ctx context.Context, customerID string) error {
+	dunning, err := s.db.GetDunningCase(ctx, customerID)
+	if errors.Is(err, tools.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dunning.Status == api.DunningResolved {
+		return nil
+	}
+	return s.resolve(ctx, dunning)
+}
+
+// Run resolves open cases whose arrears have been paid and marks accounts
+// delinquent once their final notice has run out. Register it with the
+// scheduler.
+func (s *Service) Run(ctx context.Context) error {
+	now := s.db.Now()
+	after := ""
+	for {
+		cases, err := s.db.OpenDunningCases(ctx, after, s.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, dunning := range cases {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := s.review(ctx, dunning, now); err != nil {
+				log.Errorf("Failed to review dunning case for %s: %v", dunning.CustomerID, err)
+			}
+		}
+		if len(cases) < s.BatchSize {
+			return nil
+		}
+		after = cases[len(cases)-1].CustomerID
+	}
+}
+
+func (s *Service) review(ctx context.Context, dunning *api.DunningCase, now time.Time) error {
+	account, err := s.db.GetCustomer(ctx, dunning.CustomerID)
+	if err != nil {
+		return err
+	}
+	cal, err := s.db.CustomerCalendar(ctx, account)
+	if err != nil {
+		log.Warnf("Failed to load holiday calendar for %s: %v", account.CustomerID, err)
+	}
+
+	arrears := schedule.Arrears(account, now, cal)
+	switch {
+	case arrears <= 0 || account.OutstandingBalance <= 0:
+		return s.resolve(ctx, dunning)
+	case dunning.Status == api.DunningFinalNotice && dunning.DelinquentAfter != nil && !now.Before(*dunning.DelinquentAfter):
+		s.markDelinquent(dunning, now)
+		if err := s.db.SaveDunningCase(ctx, dunning); err != nil {
+			return err
+		}
+		tools.DefaultMetrics.Inc("dunning_transitions_total", 1, "status", dunning.Status)
+		log.Printf("Account %s marked delinquent after dunning", dunning.CustomerID)
+		s.notify(ctx, dunning, arrears)
+	}
+	return nil
+}
+
+// openCase returns the account's open case, or a new one if it has none.
+func (s *Service) openCase(ctx context.Context, customerID string) (*api.DunningCase, error) {
+	dunning, err := s.db.GetDunningCase(ctx, customerID)
+	if err != nil && !errors.Is(err, tools.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil && dunning.Status != api.DunningResolved {
+		return dunning, nil
+	}
+	return &api.DunningCase{CustomerID: customerID, StartedAt: s.db.Now()}, nil
+}
+
+func (s *Service) markDelinquent(dunning *api.DunningCase, now time.Time) {
+	dunning.Status = api.DunningDelinquent
+	dunning.DelinquentAt = &now
+	dunning.NextRetryAt = nil
+}
+
+func (s *Service) resolve(ctx context.Context, dunning *api.DunningCase) error {
+	now := s.db.Now()
+	dunning.Status = api.DunningResolved
+	dunning.NextRetryAt = nil
+	dunning.ResolvedAt = &now
+	if err := s.db.SaveDunningCase(ctx, dunning); err != nil {
+		return err
+	}
+	tools.DefaultMetrics.Inc("dunning_transitions_total", 1, "status", dunning.Status)
+	return nil
+}
+
+// notify sends the message for the case's new status. It is best effort:
+// a missing contact or consent doesn't hold up dunning.
+func (s *Service) notify(ctx context.Context, dunning *api.DunningCase, amount float64) {
+	if s.Notifier == nil {
+		return
+	}
+	contact, err := s.db.GetContactProfile(ctx, dunning.CustomerID)
+	if err != nil {
+		return
+	}
+
+	variables := map[string]string{
+		"customer_id":     dunning.CustomerID,
+		"amount":          fmt.Sprintf("%.2f", amount),
+		"failed_attempts": fmt.Sprintf("%d", dunning.FailedAttempts),
+	}
+	var template string
+	escalate := false
+	switch dunning.Status {
+	case api.DunningRetrying:
+		template = "dunning_retrying"
+		if dunning.FailedAttempts > 1 {
+			template = "dunning_retrying_repeat"
+		}
+		variables["retry_date"] = dunning.NextRetryAt.Format("02 Jan")
+	case api.DunningFinalNotice:
+		template = "dunning_final_notice"
+		variables["deadline"] = dunning.DelinquentAfter.Format("02 Jan")
+		escalate = true
+	case api.DunningDelinquent:
+		template = "dunning_delinquent"
+		escalate = true
+	default:
+		return
+	}
+
+	channels := []notifications.Channel{s.PhoneChannel}
+	if escalate {
+		channels = append(channels, notifications.ChannelEmail)
+	}
+	for _, channel := range channels {
+		recipient := contact.Phone
+		if channel == notifications.ChannelEmail {
+			recipient = contact.Email
+		}
+		if recipient == "" {
+			continue
+		}
+		err := s.Notifier.Send(ctx, notifications.Notification{
+			Channel:    channel,
+			Recipient:  recipient,
+			CustomerID: dunning.CustomerID,
+			Template:   template,
+			Variables:  variables,
+		})
+		if err != nil {
+			log.Warnf("Failed to send dunning %s to %s: %v", channel, dunning.CustomerID, err)
+		}
+	}
+}
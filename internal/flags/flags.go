@@ -0,0 +1,206 @@
+// Package flags evaluates feature flags for rolling out processing changes
+// to some customers or channels, or a percentage of traffic, before
+// everyone.
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Flags the payment processor evaluates.
+const (
+	// BusinessDayValueDating value-dates payments with the business
+	// calendar. Off, payments count on their transaction date.
+	BusinessDayValueDating = "business_day_value_dating"
+)
+
+// Source supplies flag overrides, e.g. *tools.RedisService or an adapter
+// for an external flag service.
+type Source interface {
+	LoadFlags(ctx context.Context) ([]api.FeatureFlag, error)
+}
+
+// Provider evaluates flags from configured defaults overlaid with a Source,
+// which it reloads at most every refresh interval. A nil Provider has no
+// flags.
+type Provider struct {
+	defaults map[string]api.FeatureFlag
+	source   Source
+	refresh  time.Duration
+
+	mu       sync.Mutex
+	flags    map[string]api.FeatureFlag
+	loadedAt time.Time
+}
+
+func NewProvider(defaults []api.FeatureFlag, source Source, refresh time.Duration) *Provider {
+	p := &Provider{
+		defaults: make(map[string]api.FeatureFlag),
+		source:   source,
+		refresh:  refresh,
+	}
+	for _, flag := range defaults {
+		p.defaults[flag.Name] = flag
+	}
+	p.flags = p.defaults
+	return p
+}
+
+// ParseFlags reads default flags from a comma-separated list such as
+// "new_allocation,strict_duplicates:25,value_dating:off": a bare name is on
+// for everyone, name:N for N percent of customers.
+func ParseFlags(spec string) ([]api.FeatureFlag, error) {
+	var flags []api.FeatureFlag
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, setting, _ := strings.Cut(entry, ":")
+		flag := api.FeatureFlag{Name: name, Enabled: true, Percentage: 100}
+		switch setting {
+		case "", "on":
+		case "off":
+			flag.Enabled = false
+		default:
+			percentage, err := strconv.Atoi(strings.TrimSuffix(setting, "%"))
+			if err != nil || percentage < 0 || percentage > 100 {
+				return nil, fmt.Errorf("invalid flag %q", entry)
+			}
+			flag.Percentage = percentage
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// Enabled reports whether name is on for the customer identified by key.
+// attrs, such as the payment channel, are also matched against the flag's
+// targets. Unknown flags are off.
+func (p *Provider) Enabled(ctx context.Context, name, key string, attrs ...string) bool {
+	enabled, _ := p.Evaluate(ctx, name, key, attrs...)
+	return enabled
+}
+
+// Evaluate is Enabled, also reporting whether the flag exists so callers can
+// choose their own default.
+func (p *Provider) Evaluate(ctx context.Context, name, key string, attrs ...string) (enabled, ok bool) {
+	if p == nil {
+		return false, false
+	}
+
+	flag, ok := p.load(ctx)[name]
+	if !ok {
+		return false, false
+	}
+	if !flag.Enabled {
+		return false, true
+	}
+	if slices.Contains(flag.Targets, key) {
+		return true, true
+	}
+	for _, attr := range attrs {
+		if slices.Contains(flag.Targets, attr) {
+			return true, true
+		}
+	}
+	return bucket(name, key) < flag.Percentage, true
+}
+
+// Flags lists every flag with its current setting.
+func (p *Provider) Flags(ctx context.Context) []api.FeatureFlag {
+	if p == nil {
+		return []api.FeatureFlag{}
+	}
+
+	current := p.load(ctx)
+	list := make([]api.FeatureFlag, 0, len(current))
+	for _, flag := range current {
+		list = append(list, flag)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Invalidate makes the next evaluation reload the source.
+func (p *Provider) Invalidate() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.loadedAt = time.Time{}
+	p.mu.Unlock()
+}
+
+func (p *Provider) load(ctx context.Context) map[string]api.FeatureFlag {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.source == nil || time.Since(p.loadedAt) < p.refresh {
+		return p.flags
+	}
+
+	// Keep serving the last flags if the source is down; retry after the
+	// next refresh interval.
+	p.loadedAt = time.Now()
+	overrides, err := p.source.LoadFlags(ctx)
+	if err != nil {
+		log.Printf("Warning: failed to load feature flags: %v", err)
+		return p.flags
+	}
+
+	flags := make(map[string]api.FeatureFlag, len(p.defaults)+len(overrides))
+	for name, flag := range p.defaults {
+		flags[name] = flag
+	}
+	for _, flag := range overrides {
+		flags[flag.Name] = flag
+	}
+	p.flags = flags
+	return flags
+}
+
+// bucket places key in 0-99, independently for each flag so the same
+// customers are not always first in every rollout.
+func bucket(name, key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
+
+type contextKey struct{}
+
+type subject struct {
+	provider *Provider
+	key      string
+	attrs    []string
+}
+
+// NewContext attaches p and the customer being processed to ctx, so code
+// further down, such as processor hooks, can check flags with IsEnabled.
+func NewContext(ctx context.Context, p *Provider, key string, attrs ...string) context.Context {
+	return context.WithValue(ctx, contextKey{}, subject{provider: p, key: key, attrs: attrs})
+}
+
+// IsEnabled evaluates name for the customer attached to ctx by NewContext.
+func IsEnabled(ctx context.Context, name string) bool {
+	s, ok := ctx.Value(contextKey{}).(subject)
+	if !ok {
+		return false
+	}
+	return s.provider.Enabled(ctx, name, s.key, s.attrs...)
+}
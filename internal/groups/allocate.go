@@ -0,0 +1,108 @@
+package groups
+
+import (
+	"errors"
+	"math"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// ErrExceedsOutstanding is returned when a group payment is larger than what
+// its members owe between them.
+var ErrExceedsOutstanding = errors.New("payment exceeds the members' outstanding balances")
+
+// Allocate splits amountCents across the group's members by rule. Guarantors
+// and settled accounts receive nothing, and no member is allocated more than
+// they owe. The result maps customer IDs to cents; members allocated nothing
+// are left out.
+func Allocate(amountCents int64, members []api.GroupMember, rule string) (map[string]int64, error) {
+	var eligible []api.GroupMember
+	var owed int64
+	for _, member := range members {
+		if member.Role == api.GroupRoleGuarantor || member.OutstandingBalance <= 0 {
+			continue
+		}
+		eligible = append(eligible, member)
+		owed += cents(member.OutstandingBalance)
+	}
+	if amountCents > owed {
+		return nil, ErrExceedsOutstanding
+	}
+
+	caps := make([]int64, len(eligible))
+	for i, member := range eligible {
+		caps[i] = cents(member.OutstandingBalance)
+	}
+	allocated := make([]int64, len(eligible))
+	remaining := amountCents
+
+	if rule == api.AllocateArrearsFirst {
+		arrears := make([]int64, len(eligible))
+		for i, member := range eligible {
+			arrears[i] = min(cents(member.Arrears), caps[i])
+		}
+		remaining = distribute(remaining, arrears, arrears, allocated)
+	}
+
+	weights := make([]int64, len(eligible))
+	for i := range eligible {
+		weights[i] = caps[i]
+		if rule == api.AllocateEqual {
+			weights[i] = 1
+		}
+	}
+	distribute(remaining, weights, caps, allocated)
+
+	allocations := make(map[string]int64)
+	for i, member := range eligible {
+		if allocated[i] > 0 {
+			allocations[member.CustomerID] = allocated[i]
+		}
+	}
+	return allocations, nil
+}
+
+// distribute adds amount to allocated in proportion to weights without
+// taking anyone past their cap, passing capped shares on to the others. It
+// returns what could not be placed.
+func distribute(amount int64, weights, caps, allocated []int64) int64 {
+	for amount > 0 {
+		var total int64
+		for i := range weights {
+			if allocated[i] < caps[i] {
+				total += weights[i]
+			}
+		}
+		if total == 0 {
+			return amount
+		}
+
+		var given int64
+		for i := range weights {
+			if allocated[i] >= caps[i] || weights[i] == 0 {
+				continue
+			}
+			share := int64(float64(amount) * float64(weights[i]) / float64(total))
+			share = min(share, caps[i]-allocated[i])
+			allocated[i] += share
+			given += share
+		}
+
+		// Shares rounded down to nothing: hand out the leftover cents one
+		// at a time in member order.
+		if given == 0 {
+			for i := range weights {
+				if given < amount && allocated[i] < caps[i] && weights[i] > 0 {
+					allocated[i]++
+					given++
+				}
+			}
+		}
+		amount -= given
+	}
+	return 0
+}
+
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
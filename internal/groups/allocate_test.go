@@ -0,0 +1,64 @@
+package groups
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// TestAllocateInvariants checks random groups and amounts: allocations add
+// up to the payment, never exceed what a member owes and skip guarantors.
+func TestAllocateInvariants(t *testing.T) {
+	rules := []string{api.AllocateArrearsFirst, api.AllocateProRata, api.AllocateEqual}
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		members := make([]api.GroupMember, 1+rng.Intn(8))
+		var owed int64
+		for j := range members {
+			outstanding := int64(rng.Intn(100000))
+			members[j] = api.GroupMember{
+				CustomerID:         fmt.Sprintf("GIG%05d", j),
+				Role:               api.GroupRoleMember,
+				OutstandingBalance: float64(outstanding) / 100,
+				Arrears:            float64(rng.Int63n(outstanding+1)) / 100,
+			}
+			if rng.Intn(5) == 0 {
+				members[j].Role = api.GroupRoleGuarantor
+				continue
+			}
+			owed += outstanding
+		}
+		amount := 1 + rng.Int63n(owed+owed/10+1)
+		rule := rules[rng.Intn(len(rules))]
+
+		allocations, err := Allocate(amount, members, rule)
+		if amount > owed {
+			if !errors.Is(err, ErrExceedsOutstanding) {
+				t.Fatalf("case %d: amount %d over owed %d: err = %v", i, amount, owed, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+
+		var total int64
+		for _, member := range members {
+			share := allocations[member.CustomerID]
+			if share < 0 || share > cents(member.OutstandingBalance) {
+				t.Fatalf("case %d (%s): %s allocated %d of %.2f owed", i, rule, member.CustomerID, share, member.OutstandingBalance)
+			}
+			if member.Role == api.GroupRoleGuarantor && share != 0 {
+				t.Fatalf("case %d (%s): guarantor %s allocated %d", i, rule, member.CustomerID, share)
+			}
+			total += share
+		}
+		if total != amount {
+			t.Fatalf("case %d (%s): allocated %d of %d", i, rule, total, amount)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package i18n
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultLocale = "en"
+
+const (
+	MsgPaymentAccepted     = "payment_accepted"
+	MsgPaymentDuplicate    = "payment_duplicate"
+	MsgPaymentHeld         = "payment_held"
+	MsgPaymentQueued       = "payment_queued"
+	MsgOnlyCompleteAllowed = "only_complete_allowed"
+	MsgCustomerNotFound    = "customer_not_found"
+	MsgAccountNotFound     = "account_not_found"
+	MsgQueueFailed         = "queue_failed"
+	MsgCustomersSeeded     = "customers_seeded"
+	MsgValidationFailed    = "validation_failed"
+	MsgInvalidJSON         = "invalid_json"
+	MsgVerificationCode    = "verification_code"
+)
+
+// ValidationPrefix prefixes the message key for each validation rule, e.g.
+// "validation.required". Length and count rules have ".length" and ".items"
+// variants. Messages take the field path and the rule's parameter.
+const ValidationPrefix = "validation."
+
+var catalogs = map[string]map[string]string{
+	"en": {
+		MsgPaymentAccepted:     "Payment accepted for processing",
+		MsgPaymentDuplicate:    "Transaction already processed",
+		MsgPaymentHeld:         "Payment held for compliance review",
+		MsgPaymentQueued:       "Payment received and queued; it will be applied once the account is confirmed",
+		MsgOnlyCompleteAllowed: "Only COMPLETE payments accepted. Received: %s",
+		MsgCustomerNotFound:    "Customer not found",
+		MsgAccountNotFound:     "Account not found for this customer",
+		MsgQueueFailed:         "Failed to queue payment",
+		MsgCustomersSeeded:     "Customers seeded successfully",
+		MsgValidationFailed:    "Request validation failed",
+		MsgInvalidJSON:         "Request body is not valid JSON",
+		MsgVerificationCode:    "Your verification code is %s. It expires in 5 minutes.",
+
+		"validation.required":         "%s is required",
+		"validation.required_without": "%s is required when %s is not given",
+		"validation.max":              "%s must be at most %s",
+		"validation.max.length":       "%s must be at most %s characters",
+		"validation.max.items":        "%s must have at most %s items",
+		"validation.min":              "%s must be at least %s",
+		"validation.min.length":       "%s must be at least %s characters",
+		"validation.min.items":        "%s must have at least %s items",
+		"validation.len":              "%s must be %s",
+		"validation.len.length":       "%s must be exactly %s characters",
+		"validation.len.items":        "%s must have exactly %s items",
+		"validation.gt":               "%s must be greater than %s",
+		"validation.gte":              "%s must be at least %s",
+		"validation.lt":               "%s must be less than %s",
+		"validation.lte":              "%s must be at most %s",
+		"validation.oneof":            "%s must be one of: %s",
+		"validation.startswith":       "%s must start with %s",
+		"validation.uppercase":        "%s must be upper-case",
+		"validation.numeric":          "%s must contain only digits",
+		"validation.email":            "%s must be a valid email address",
+		"validation.url":              "%s must be a valid URL",
+		"validation.datetime":         "%s must be a date in the format %s",
+		"validation.invalid_type":     "%s must be a %s",
+		"validation.invalid":          "%s is invalid",
+	},
+	"fr": {
+		MsgPaymentAccepted:     "Paiement accepté pour traitement",
+		MsgPaymentDuplicate:    "Transaction déjà traitée",
+		MsgPaymentHeld:         "Paiement retenu pour examen de conformité",
+		MsgPaymentQueued:       "Paiement reçu et mis en file ; il sera appliqué une fois le compte confirmé",
+		MsgOnlyCompleteAllowed: "Seuls les paiements COMPLETE sont acceptés. Reçu : %s",
+		MsgCustomerNotFound:    "Client introuvable",
+		MsgAccountNotFound:     "Compte introuvable pour ce client",
+		MsgQueueFailed:         "Échec de la mise en file du paiement",
+		MsgCustomersSeeded:     "Clients créés avec succès",
+		MsgValidationFailed:    "La validation de la requête a échoué",
+		MsgInvalidJSON:         "Le corps de la requête n'est pas un JSON valide",
+		MsgVerificationCode:    "Votre code de vérification est %s. Il expire dans 5 minutes.",
+
+		"validation.required":         "%s est obligatoire",
+		"validation.required_without": "%s est obligatoire si %s est absent",
+		"validation.max":              "%s doit être au plus %s",
+		"validation.max.length":       "%s doit comporter au plus %s caractères",
+		"validation.max.items":        "%s doit contenir au plus %s éléments",
+		"validation.min":              "%s doit être au moins %s",
+		"validation.min.length":       "%s doit comporter au moins %s caractères",
+		"validation.min.items":        "%s doit contenir au moins %s éléments",
+		"validation.len":              "%s doit valoir %s",
+		"validation.len.length":       "%s doit comporter exactement %s caractères",
+		"validation.len.items":        "%s doit contenir exactement %s éléments",
+		"validation.gt":               "%s doit être supérieur à %s",
+		"validation.gte":              "%s doit être supérieur ou égal à %s",
+		"validation.lt":               "%s doit être inférieur à %s",
+		"validation.lte":              "%s doit être inférieur ou égal à %s",
+		"validation.oneof":            "%s doit valoir l'une des valeurs : %s",
+		"validation.startswith":       "%s doit commencer par %s",
+		"validation.uppercase":        "%s doit être en majuscules",
+		"validation.numeric":          "%s ne doit contenir que des chiffres",
+		"validation.email":            "%s doit être une adresse e-mail valide",
+		"validation.url":              "%s doit être une URL valide",
+		"validation.datetime":         "%s doit être une date au format %s",
+		"validation.invalid_type":     "%s doit être de type %s",
+		"validation.invalid":          "%s est invalide",
+	},
+	"sw": {
+		MsgPaymentAccepted:     "Malipo yamepokelewa kwa ajili ya kushughulikiwa",
+		MsgPaymentDuplicate:    "Muamala tayari umeshughulikiwa",
+		MsgPaymentHeld:         "Malipo yamezuiliwa kwa ukaguzi wa utiifu",
+		MsgPaymentQueued:       "Malipo yamepokelewa na kuwekwa kwenye foleni; yatatumika baada ya akaunti kuthibitishwa",
+		MsgOnlyCompleteAllowed: "Malipo ya COMPLETE pekee yanakubaliwa. Yaliyopokelewa: %s",
+		MsgCustomerNotFound:    "Mteja hajapatikana",
+		MsgAccountNotFound:     "Akaunti haipatikani kwa mteja huyu",
+		MsgQueueFailed:         "Imeshindwa kuweka malipo kwenye foleni",
+		MsgCustomersSeeded:     "Wateja wameongezwa kwa mafanikio",
+		MsgValidationFailed:    "Uthibitishaji wa ombi umeshindwa",
+		MsgInvalidJSON:         "Maudhui ya ombi si JSON sahihi",
+		MsgVerificationCode:    "Nambari yako ya uthibitisho ni %s. Itaisha baada ya dakika 5.",
+
+		"validation.required":         "%s inahitajika",
+		"validation.required_without": "%s inahitajika ikiwa %s haijatolewa",
+		"validation.max":              "%s lazima isizidi %s",
+		"validation.max.length":       "%s lazima isizidi herufi %s",
+		"validation.max.items":        "%s lazima isizidi vipengee %s",
+		"validation.min":              "%s lazima iwe angalau %s",
+		"validation.min.length":       "%s lazima iwe na angalau herufi %s",
+		"validation.min.items":        "%s lazima iwe na angalau vipengee %s",
+		"validation.len":              "%s lazima iwe %s",
+		"validation.len.length":       "%s lazima iwe na herufi %s kamili",
+		"validation.len.items":        "%s lazima iwe na vipengee %s kamili",
+		"validation.gt":               "%s lazima iwe kubwa kuliko %s",
+		"validation.gte":              "%s lazima iwe angalau %s",
+		"validation.lt":               "%s lazima iwe ndogo kuliko %s",
+		"validation.lte":              "%s lazima isizidi %s",
+		"validation.oneof":            "%s lazima iwe mojawapo ya: %s",
+		"validation.startswith":       "%s lazima ianze na %s",
+		"validation.uppercase":        "%s lazima iwe kwa herufi kubwa",
+		"validation.numeric":          "%s lazima iwe na tarakimu pekee",
+		"validation.email":            "%s lazima iwe anwani sahihi ya barua pepe",
+		"validation.url":              "%s lazima iwe URL sahihi",
+		"validation.datetime":         "%s lazima iwe tarehe katika muundo %s",
+		"validation.invalid_type":     "%s lazima iwe ya aina %s",
+		"validation.invalid":          "%s si sahihi",
+	},
+}
+
+var monthNames = map[string][12]string{
+	"fr": {"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+	"sw": {"Jan", "Feb", "Mac", "Apr", "Mei", "Jun", "Jul", "Ago", "Sep", "Okt", "Nov", "Des"},
+}
+
+func Translate(locale, key string, args ...interface{}) string {
+	catalog, ok := catalogs[locale]
+	if !ok {
+		catalog = catalogs[DefaultLocale]
+	}
+
+	msg, ok := catalog[key]
+	if !ok {
+		msg, ok = catalogs[DefaultLocale][key]
+		if !ok {
+			msg = key
+		}
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Has reports whether the default catalog defines key.
+func Has(key string) bool {
+	_, ok := catalogs[DefaultLocale][key]
+	return ok
+}
+
+func Supported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// ParseAcceptLanguage picks the highest weighted supported language from an
+// Accept-Language header, e.g. "fr-CM,fr;q=0.9,en;q=0.8". Languages
+// weighted q=0 are ones the client refuses, and weights above 1 count as 1.
+func ParseAcceptLanguage(header string) string {
+	best := DefaultLocale
+	bestWeight := -1.0
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+
+		weight := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		if !(weight > 0) {
+			continue
+		}
+		weight = math.Min(weight, 1)
+
+		base := strings.SplitN(tag, "-", 2)[0]
+		if Supported(base) && weight > bestWeight {
+			best = base
+			bestWeight = weight
+		}
+	}
+
+	return best
+}
+
+func FormatAmount(locale string, amount float64) string {
+	raw := strconv.FormatFloat(amount, 'f', 2, 64)
+	negative := strings.HasPrefix(raw, "-")
+	raw = strings.TrimPrefix(raw, "-")
+
+	intPart, fracPart := raw[:len(raw)-3], raw[len(raw)-2:]
+
+	groupSep, decimalSep := ",", "."
+	if locale == "fr" {
+		groupSep, decimalSep = " ", ","
+	}
+
+	var grouped strings.Builder
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			grouped.WriteString(groupSep)
+		}
+		grouped.WriteRune(digit)
+	}
+
+	result := grouped.String() + decimalSep + fracPart
+	if negative {
+		result = "-" + result
+	}
+	return result
+}
+
+func FormatDate(locale string, t time.Time) string {
+	months, ok := monthNames[locale]
+	if !ok {
+		return t.Format("Jan 2, 2006")
+	}
+	return fmt.Sprintf("%d %s %d", t.Day(), months[t.Month()-1], t.Year())
+}
@@ -0,0 +1,328 @@
+package imports
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRowErrors caps the per-row errors kept on a batch; the failed count is
+// always exact.
+const maxRowErrors = 100
+
+// A running import checkpoints every checkpointRows rows, or sooner once
+// checkpointInterval has passed, well inside tools.ImportStaleAfter.
+const (
+	checkpointRows     = 1000
+	checkpointInterval = 10 * time.Second
+)
+
+var (
+	errImportCancelled = errors.New("import cancelled")
+	errImportTakenOver = errors.New("import taken over or closed by another instance")
+)
+
+// columnAliases maps the header names partners use onto our fields. Headers
+// are matched case-insensitively with spaces and dashes treated as "_".
+var columnAliases = map[string]string{
+	"customer_id":           "customer_id",
+	"customer":              "customer_id",
+	"account_id":            "customer_id",
+	"phone":                 "phone",
+	"msisdn":                "phone",
+	"amount":                "amount",
+	"transaction_amount":    "amount",
+	"date":                  "date",
+	"transaction_date":      "date",
+	"value_date":            "date",
+	"reference":             "reference",
+	"transaction_reference": "reference",
+	"ref":                   "reference",
+	"currency":              "currency",
+	"channel":               "channel",
+	"agent_id":              "agent_id",
+	"status":                "status",
+}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02", "02/01/2006"}
+
+// Pipeline validates payment files row by row and queues the good rows,
+// recording the outcome as an import batch.
+type Pipeline struct {
+	db    *tools.DatabaseService
+	redis *tools.RedisService
+	// Instance owns the batches this pipeline runs; another instance
+	// resumes them if it stops checkpointing.
+	Instance string
+}
+
+func NewPipeline(db *tools.DatabaseService, redis *tools.RedisService) *Pipeline {
+	return &Pipeline{db: db, redis: redis}
+}
+
+// ImportCSV runs a CSV with a header row through the pipeline. fileKey
+// identifies the file version at its source; it returns nil, nil when that
+// version has already been imported or another instance is importing it.
+// An import left running by an instance that crashed resumes after its last
+// checkpoint; the rows after it are run again, which the duplicate checks
+// make safe.
+func (p *Pipeline) ImportCSV(ctx context.Context, source, fileName, fileKey string, body io.Reader) (*api.ImportBatch, error) {
+	var fileBytes int64
+	if sized, ok := body.(interface{ Size() int64 }); ok {
+		fileBytes = sized.Size()
+	}
+
+	batch, started, err := p.db.StartImportBatch(ctx, source, fileName, fileKey, p.Instance, fileBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, nil
+	}
+	if batch.ResumedAt != nil {
+		log.Printf("Resuming import batch %d (%s) after row %d", batch.ID, fileName, batch.CheckpointRow)
+	}
+
+	err = p.importRows(ctx, batch, body)
+	switch {
+	case errors.Is(err, errImportTakenOver):
+		log.Printf("Import batch %d was taken over or closed by another instance", batch.ID)
+		return nil, nil
+	case ctx.Err() != nil:
+		// Shutting down: leave the batch running for another instance to
+		// resume from here.
+		checkpointCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if _, cpErr := p.db.CheckpointImportBatch(checkpointCtx, batch); cpErr != nil {
+			log.Printf("Warning: failed to checkpoint import batch %d: %v", batch.ID, cpErr)
+		}
+		return nil, err
+	case errors.Is(err, errImportCancelled):
+		batch.Status = api.ImportCancelled
+	case err != nil:
+		batch.Status = api.ImportFailed
+		batch.Error = err.Error()
+	default:
+		batch.Status = api.ImportCompleted
+	}
+
+	if err := p.db.FinishImportBatch(ctx, batch); err != nil {
+		return batch, fmt.Errorf("failed to record import batch %d: %v", batch.ID, err)
+	}
+
+	tools.DefaultMetrics.Inc("import_rows_total", float64(batch.QueuedRows), "source", source, "result", "queued")
+	tools.DefaultMetrics.Inc("import_rows_total", float64(batch.DuplicateRows), "source", source, "result", "duplicate")
+	tools.DefaultMetrics.Inc("import_rows_total", float64(batch.FailedRows), "source", source, "result", "failed")
+	return batch, nil
+}
+
+// importRows imports the rows after the batch's checkpoint, checkpointing
+// as it goes.
+func (p *Pipeline) importRows(ctx context.Context, batch *api.ImportBatch, body io.Reader) error {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %v", err)
+	}
+	columns := mapColumns(header)
+	if _, ok := columns["amount"]; !ok {
+		return errors.New("missing amount column")
+	}
+	if _, ok := columns["reference"]; !ok {
+		return errors.New("missing reference column")
+	}
+	_, hasCustomer := columns["customer_id"]
+	_, hasPhone := columns["phone"]
+	if !hasCustomer && !hasPhone {
+		return errors.New("missing customer_id or phone column")
+	}
+
+	lastCheckpoint := time.Now()
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			batch.CheckpointOffset = reader.InputOffset()
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("row %d: %v", row, err)
+		}
+		if row <= batch.CheckpointRow || blank(record) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		queued, err := p.importRow(ctx, batch, field)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// The row may not have been queued; leave it to the resume.
+			return ctxErr
+		}
+		batch.TotalRows++
+		switch {
+		case err != nil:
+			batch.FailedRows++
+			if len(batch.Errors) < maxRowErrors {
+				batch.Errors = append(batch.Errors, api.ImportRowError{Row: row, Reference: field("reference"), Error: err.Error()})
+			}
+		case queued:
+			batch.QueuedRows++
+		default:
+			batch.DuplicateRows++
+		}
+
+		batch.CheckpointRow, batch.CheckpointOffset = row, reader.InputOffset()
+		if batch.TotalRows%checkpointRows == 0 || time.Since(lastCheckpoint) >= checkpointInterval {
+			if err := p.checkpoint(ctx, batch); err != nil {
+				return err
+			}
+			lastCheckpoint = time.Now()
+		}
+	}
+}
+
+// checkpoint saves the batch's progress and picks up a cancel request. A
+// checkpoint that fails is only logged; the next one catches up.
+func (p *Pipeline) checkpoint(ctx context.Context, batch *api.ImportBatch) error {
+	cancelled, err := p.db.CheckpointImportBatch(ctx, batch)
+	if errors.Is(err, tools.ErrNotFound) {
+		return errImportTakenOver
+	}
+	if err != nil {
+		log.Printf("Warning: failed to checkpoint import batch %d: %v", batch.ID, err)
+		return nil
+	}
+	if cancelled {
+		log.Printf("Import batch %d cancelled by %s after row %d", batch.ID, batch.CancelledBy, batch.CheckpointRow)
+		return errImportCancelled
+	}
+	return nil
+}
+
+// importRow queues one row, returning false for a payment already seen.
+func (p *Pipeline) importRow(ctx context.Context, batch *api.ImportBatch, field func(string) string) (bool, error) {
+	if status := strings.ToUpper(field("status")); status != "" && status != "COMPLETE" && status != "SUCCESS" && status != "SUCCESSFUL" {
+		return false, fmt.Errorf("status %s is not a completed payment", status)
+	}
+
+	reference := field("reference")
+	if reference == "" {
+		return false, errors.New("reference is required")
+	}
+
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(field("amount"), ",", ""), 64)
+	if err != nil || amount <= 0 {
+		return false, fmt.Errorf("invalid amount %q", field("amount"))
+	}
+
+	date := p.db.Now()
+	if value := field("date"); value != "" {
+		if date, err = parseDate(value); err != nil {
+			return false, err
+		}
+	}
+
+	customerID, err := p.resolveCustomer(ctx, field("customer_id"), field("phone"))
+	if err != nil {
+		return false, err
+	}
+
+	if duplicate, _ := p.redis.IsDuplicate(ctx, reference); duplicate {
+		return false, nil
+	}
+	if processed, err := p.db.IsTransactionProcessed(ctx, reference); err == nil && processed {
+		return false, nil
+	}
+
+	payment := &api.PaymentPayload{
+		CustomerID:           customerID,
+		PaymentStatus:        api.StatusComplete,
+		TransactionAmount:    fmt.Sprintf("%.2f", amount),
+		TransactionDate:      date.Format("2006-01-02 15:04:05"),
+		TransactionReference: reference,
+		Currency:             strings.ToUpper(field("currency")),
+		Channel:              field("channel"),
+		AgentID:              field("agent_id"),
+		Metadata: api.Metadata{
+			api.MetadataImportBatchID: batch.ID,
+			"import_source":           batch.Source,
+		},
+	}
+
+	if err := p.redis.EnqueuePayment(ctx, payment); err != nil {
+		return false, fmt.Errorf("failed to queue payment: %v", err)
+	}
+	if err := p.db.ArchivePayment(ctx, payment); err != nil {
+		log.Printf("Warning: failed to archive payment %s: %v", reference, err)
+	}
+	return true, nil
+}
+
+func (p *Pipeline) resolveCustomer(ctx context.Context, customerID, phone string) (string, error) {
+	if customerID != "" {
+		if _, err := p.db.GetCustomerVersion(ctx, customerID); err != nil {
+			return "", fmt.Errorf("unknown customer %s", customerID)
+		}
+		return customerID, nil
+	}
+	if phone != "" {
+		resolved, err := p.db.ResolveCustomerID(ctx, api.IdentifierPhone, phone)
+		if err != nil {
+			return "", errors.New("no customer registered for phone")
+		}
+		return resolved, nil
+	}
+	return "", errors.New("customer_id or phone is required")
+}
+
+func mapColumns(header []string) map[string]int {
+	columns := make(map[string]int)
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		key = strings.NewReplacer(" ", "_", "-", "_").Replace(key)
+		if field, ok := columnAliases[key]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	return columns
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+func blank(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package intents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrIntentMismatch marks a payment whose intent_reference doesn't fit the
+// intent it names; the wrapped message says why.
+var ErrIntentMismatch = errors.New("payment does not match its intent")
+
+// Store is the persistence the matcher needs. *tools.DatabaseService
+// implements it.
+type Store interface {
+	GetPaymentIntent(ctx context.Context, reference string) (*api.PaymentIntent, error)
+	MatchPaymentIntent(ctx context.Context, reference, transactionReference string) (bool, error)
+	LinkPaymentToIntent(ctx context.Context, customerID string, amount float64, transactionReference string) (string, error)
+}
+
+// Matcher links incoming payments to the payment intents they settle. The
+// API checks referenced payments as they are accepted; as a payment
+// processor hook it also checks payments ingested any other way and marks
+// intents matched once their payment is applied.
+type Matcher struct {
+	processors.HookFuncs
+	store Store
+}
+
+func NewMatcher(store Store) *Matcher {
+	m := &Matcher{store: store}
+	m.Before = m.Check
+	m.After = m.match
+	return m
+}
+
+// Check verifies a payment against the intent it references: the intent
+// must belong to the payment's account, still be pending and unexpired, and
+// be for the same amount and currency. Payments without a reference, and
+// replays of the payment that already matched the intent, pass.
+func (m *Matcher) Check(ctx context.Context, payment *api.PaymentPayload) error {
+	if payment.IntentReference == "" {
+		return nil
+	}
+
+	intent, err := m.store.GetPaymentIntent(ctx, payment.IntentReference)
+	if errors.Is(err, tools.ErrNotFound) {
+		return fmt.Errorf("%w: unknown intent %s", ErrIntentMismatch, payment.IntentReference)
+	}
+	if err != nil {
+		return err
+	}
+
+	if intent.TransactionReference != "" && intent.TransactionReference == payment.TransactionReference {
+		return nil
+	}
+	if intent.CustomerID != payment.CustomerID {
+		return fmt.Errorf("%w: intent %s is for another account", ErrIntentMismatch, intent.Reference)
+	}
+	if intent.Status != api.IntentPending {
+		return fmt.Errorf("%w: intent %s is %s", ErrIntentMismatch, intent.Reference, intent.Status)
+	}
+
+	cents, err := tools.ParseCents(payment.TransactionAmount)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrIntentMismatch, err)
+	}
+	if cents != int64(math.Round(intent.Amount*100)) {
+		return fmt.Errorf("%w: intent %s is for %.2f, not %s", ErrIntentMismatch, intent.Reference, intent.Amount, payment.TransactionAmount)
+	}
+	if intent.Currency != "" && payment.Currency != "" && intent.Currency != payment.Currency {
+		return fmt.Errorf("%w: intent %s is in %s, not %s", ErrIntentMismatch, intent.Reference, intent.Currency, payment.Currency)
+	}
+	return nil
+}
+
+// match runs after the payment is applied. Referenced payments match their
+// intent; others are linked to a pending intent for the same amount, if the
+// customer has one. Failures are logged rather than failing a payment that
+// has already been applied.
+func (m *Matcher) match(ctx context.Context, payment *api.PaymentPayload, result processors.ApplyResult) {
+	if payment.IntentReference != "" {
+		matched, err := m.store.MatchPaymentIntent(ctx, payment.IntentReference, payment.TransactionReference)
+		if err != nil {
+			log.Errorf("Failed to match payment %s to intent %s: %v", payment.TransactionReference, payment.IntentReference, err)
+			return
+		}
+		if !matched {
+			log.Warnf("Payment %s applied but intent %s was no longer pending", payment.TransactionReference, payment.IntentReference)
+			return
+		}
+		tools.DefaultMetrics.Inc("payment_intents_matched_total", 1, "how", "reference")
+		return
+	}
+
+	reference, err := m.store.LinkPaymentToIntent(ctx, payment.CustomerID, result.Amount, payment.TransactionReference)
+	if err != nil {
+		log.Errorf("Failed to link payment %s to an intent: %v", payment.TransactionReference, err)
+		return
+	}
+	if reference == "" {
+		return
+	}
+	tools.DefaultMetrics.Inc("payment_intents_matched_total", 1, "how", "amount")
+	log.Printf("Payment %s for %s linked to intent %s by amount", payment.TransactionReference, payment.CustomerID, reference)
+}
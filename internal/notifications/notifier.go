@@ -0,0 +1,370 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+type Channel string
+
+const (
+	ChannelSMS      Channel = "sms"
+	ChannelEmail    Channel = "email"
+	ChannelVoice    Channel = "voice"
+	ChannelWhatsApp Channel = "whatsapp"
+)
+
+var ErrNoConsent = errors.New("customer has not consented to this channel")
+
+// ErrQuietHours is returned for non-essential messages during the
+// customer's quiet hours when there is nowhere to hold them.
+var ErrQuietHours = errors.New("customer is in quiet hours")
+
+type Notification struct {
+	Channel    Channel `json:"channel"`
+	Recipient  string  `json:"recipient"`
+	CustomerID string  `json:"customer_id,omitempty"`
+	Subject    string  `json:"subject,omitempty"`
+	Message    string  `json:"message"`
+	// Template, when set, names the message template rendered with
+	// Variables in the customer's preferred language, replacing Subject
+	// and Message.
+	Template  string            `json:"template,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	// MessageKey, when set, is an i18n catalog key rendered with
+	// MessageArgs in the customer's preferred language, replacing Message.
+	MessageKey  string   `json:"message_key,omitempty"`
+	MessageArgs []string `json:"message_args,omitempty"`
+	// Essential marks messages the customer asked for, such as a one-time
+	// code, which are sent without checking marketing/reminder consent or
+	// quiet hours.
+	Essential bool `json:"essential,omitempty"`
+	// Reference identifies the message to providers that report its
+	// outcome later, such as the voice call it places.
+	Reference string `json:"reference,omitempty"`
+	// Locale is the language the message was rendered in, set by the
+	// notifier for providers that send pre-approved templates.
+	Locale string `json:"-"`
+}
+
+type ConsentChecker interface {
+	HasConsent(ctx context.Context, customerID, channel string) (bool, error)
+}
+
+// PreferenceStore looks up the customer's language and quiet hours.
+type PreferenceStore interface {
+	GetNotificationPreferences(ctx context.Context, customerID string) (*api.NotificationPreferences, error)
+}
+
+// TemplateRenderer renders message templates.
+type TemplateRenderer interface {
+	Render(ctx context.Context, templateID, locale string, variables map[string]string) (*api.RenderedMessage, error)
+}
+
+// DeferredStore holds encoded notifications until a given time.
+type DeferredStore interface {
+	DeferNotification(ctx context.Context, data []byte, at time.Time) error
+	DueNotifications(ctx context.Context, now time.Time, limit int64) ([][]byte, error)
+}
+
+// NotificationLog records the notifications sent to customers.
+type NotificationLog interface {
+	RecordNotification(ctx context.Context, record api.NotificationRecord) error
+}
+
+type Provider interface {
+	Send(ctx context.Context, notification Notification) error
+}
+
+// TrackedProvider is a Provider that identifies each message it sends, so
+// the delivery receipts it reports later can be matched to the
+// notification log.
+type TrackedProvider interface {
+	Provider
+	SendTracked(ctx context.Context, notification Notification) (messageID string, err error)
+}
+
+type Notifier struct {
+	providers map[Channel]Provider
+	Consent   ConsentChecker
+	// Preferences, when set, picks the message language and holds
+	// non-essential messages during the customer's quiet hours.
+	Preferences PreferenceStore
+	// Deferred keeps messages held for quiet hours; without it they are
+	// dropped with ErrQuietHours.
+	Deferred DeferredStore
+	// Timezone applies to quiet hours of customers who haven't set one.
+	Timezone *time.Location
+	// Templates renders notifications that name a Template.
+	Templates TemplateRenderer
+	// Log, when set, records every notification to a customer that was
+	// sent, held for quiet hours or failed at the provider.
+	Log NotificationLog
+	// Clock decides quiet hours and which deferred messages are due.
+	Clock tools.Clock
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{providers: make(map[Channel]Provider), Clock: tools.SystemClock{}}
+}
+
+func (n *Notifier) Register(channel Channel, provider Provider) {
+	n.providers[channel] = provider
+}
+
+func (n *Notifier) Send(ctx context.Context, notification Notification) error {
+	provider, ok := n.providers[notification.Channel]
+	if !ok {
+		return fmt.Errorf("no provider registered for channel %s", notification.Channel)
+	}
+
+	var preferences *api.NotificationPreferences
+	if n.Preferences != nil && notification.CustomerID != "" {
+		var err error
+		if preferences, err = n.Preferences.GetNotificationPreferences(ctx, notification.CustomerID); err != nil {
+			// Essential messages go out in the default language rather
+			// than not at all.
+			if !notification.Essential {
+				return fmt.Errorf("preference lookup failed: %v", err)
+			}
+			preferences = nil
+		}
+	}
+
+	if !notification.Essential && n.Consent != nil {
+		if notification.CustomerID == "" {
+			return fmt.Errorf("non-essential notification without customer_id: %w", ErrNoConsent)
+		}
+		allowed, err := n.Consent.HasConsent(ctx, notification.CustomerID, string(notification.Channel))
+		if err != nil {
+			return fmt.Errorf("consent check failed: %v", err)
+		}
+		if !allowed {
+			return ErrNoConsent
+		}
+	}
+
+	if !notification.Essential && preferences != nil {
+		if quiet, until := QuietUntil(preferences, n.Timezone, n.Clock.Now()); quiet {
+			return n.deferUntil(ctx, notification, until)
+		}
+	}
+
+	locale := i18n.DefaultLocale
+	if preferences != nil && preferences.Language != "" {
+		locale = preferences.Language
+	}
+	switch {
+	case notification.Template != "":
+		if n.Templates == nil {
+			return fmt.Errorf("no template renderer for template %s", notification.Template)
+		}
+		rendered, err := n.Templates.Render(ctx, notification.Template, locale, notification.Variables)
+		if err != nil {
+			return err
+		}
+		notification.Subject = rendered.Subject
+		notification.Message = rendered.Body
+	case notification.MessageKey != "":
+		args := make([]interface{}, len(notification.MessageArgs))
+		for i, arg := range notification.MessageArgs {
+			args[i] = arg
+		}
+		notification.Message = i18n.Translate(locale, notification.MessageKey, args...)
+	}
+
+	notification.Locale = locale
+
+	var messageID string
+	var err error
+	if tracked, ok := provider.(TrackedProvider); ok {
+		messageID, err = tracked.SendTracked(ctx, notification)
+	} else {
+		err = provider.Send(ctx, notification)
+	}
+	status := api.NotificationSent
+	if err != nil {
+		status = api.NotificationFailed
+	}
+	n.record(ctx, notification, status, messageID, err)
+	return err
+}
+
+// record logs the notification; failing to log it doesn't fail the
+// notification.
+func (n *Notifier) record(ctx context.Context, notification Notification, status, messageID string, sendErr error) {
+	if n.Log == nil || notification.CustomerID == "" {
+		return
+	}
+	record := api.NotificationRecord{
+		CustomerID: notification.CustomerID,
+		Channel:    string(notification.Channel),
+		Template:   notification.Template,
+		Subject:    notification.Subject,
+		Status:     status,
+	}
+	if record.Template == "" {
+		record.Template = notification.MessageKey
+	}
+	record.ProviderMessageID = messageID
+	if sendErr != nil {
+		record.Error = sendErr.Error()
+	}
+	if err := n.Log.RecordNotification(ctx, record); err != nil {
+		log.Warnf("Failed to log notification for %s: %v", notification.CustomerID, err)
+	}
+}
+
+func (n *Notifier) deferUntil(ctx context.Context, notification Notification, until time.Time) error {
+	if n.Deferred == nil {
+		return ErrQuietHours
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	if err := n.Deferred.DeferNotification(ctx, data, until); err != nil {
+		return fmt.Errorf("failed to defer notification: %v", err)
+	}
+	log.Printf("Notification [%s] for %s deferred until %s (quiet hours)", notification.Channel, notification.CustomerID, until.Format(time.RFC3339))
+	n.record(ctx, notification, api.NotificationDeferred, "", nil)
+	return nil
+}
+
+// DeliverDeferred sends the messages held for quiet hours that have ended.
+// Register it with the scheduler.
+func (n *Notifier) DeliverDeferred(ctx context.Context) error {
+	if n.Deferred == nil {
+		return nil
+	}
+	due, err := n.Deferred.DueNotifications(ctx, n.Clock.Now(), 100)
+	if err != nil {
+		return err
+	}
+	for _, data := range due {
+		var notification Notification
+		if err := json.Unmarshal(data, &notification); err != nil {
+			log.Errorf("Dropping undecodable deferred notification: %v", err)
+			continue
+		}
+		// Consent and quiet hours are checked again: either may have
+		// changed while the message waited.
+		if err := n.Send(ctx, notification); err != nil {
+			log.Warnf("Failed to send deferred notification to %s: %v", notification.CustomerID, err)
+		}
+	}
+	return nil
+}
+
+// QuietUntil reports whether t falls in the customer's quiet hours and, if
+// so, when they end. Quiet hours are read in the customer's timezone, or
+// fallback if they haven't set one, and wrap past midnight when the start is
+// after the end.
+func QuietUntil(preferences *api.NotificationPreferences, fallback *time.Location, t time.Time) (bool, time.Time) {
+	if preferences.QuietHoursStart == "" || preferences.QuietHoursEnd == "" {
+		return false, time.Time{}
+	}
+	start, err := time.Parse("15:04", preferences.QuietHoursStart)
+	if err != nil {
+		return false, time.Time{}
+	}
+	end, err := time.Parse("15:04", preferences.QuietHoursEnd)
+	if err != nil {
+		return false, time.Time{}
+	}
+
+	location := fallback
+	if preferences.Timezone != "" {
+		if loc, err := time.LoadLocation(preferences.Timezone); err == nil {
+			location = loc
+		}
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	local := t.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	var quiet bool
+	if startMinute <= endMinute {
+		quiet = minute >= startMinute && minute < endMinute
+	} else {
+		quiet = minute >= startMinute || minute < endMinute
+	}
+	if !quiet {
+		return false, time.Time{}
+	}
+
+	until := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, location)
+	if !until.After(local) {
+		until = until.AddDate(0, 0, 1)
+	}
+	return true, until
+}
+
+type LogProvider struct{}
+
+func (LogProvider) Send(ctx context.Context, notification Notification) error {
+	log.Printf("Notification [%s] to %s: %s", notification.Channel, notification.Recipient, notification.Message)
+	return nil
+}
+
+type HTTPSMSProvider struct {
+	URL    string
+	APIKey string
+	Sender string
+	client *http.Client
+}
+
+func NewHTTPSMSProvider(url, apiKey, sender string) *HTTPSMSProvider {
+	return &HTTPSMSProvider{
+		URL:    url,
+		APIKey: apiKey,
+		Sender: sender,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *HTTPSMSProvider) Send(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(map[string]string{
+		"to":      notification.Recipient,
+		"from":    p.Sender,
+		"message": notification.Message,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway returned status %d", resp.StatusCode)
+	}
+	return nil
+}
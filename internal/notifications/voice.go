@@ -0,0 +1,292 @@
+package notifications
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// ErrInvalidCallback is returned for voice callbacks that fail
+// authentication.
+var ErrInvalidCallback = errors.New("invalid voice callback")
+
+// VoiceCallStore records calls as the provider accepts them, so outcome
+// callbacks can be matched to them and the script served to providers that
+// fetch it once the call connects.
+type VoiceCallStore interface {
+	VoiceCallPlaced(ctx context.Context, reference, provider, providerCallID, script string) error
+}
+
+// VoiceCallEvent is a provider's callback about a call. Active events ask
+// for the script of a call that has just connected; the rest report how it
+// ended.
+type VoiceCallEvent struct {
+	Reference       string
+	ProviderCallID  string
+	Active          bool
+	Status          api.VoiceCallStatus
+	DurationSeconds int
+	Detail          string
+}
+
+// VoiceProvider places calls that read the notification's message out to
+// the recipient, and reports on them to /voice/callback/{name}.
+type VoiceProvider interface {
+	Provider
+	Name() string
+	// ParseCallback authenticates and decodes a callback.
+	ParseCallback(r *http.Request) (*VoiceCallEvent, error)
+}
+
+// VoiceScriptXML is the <Say> document both Twilio and Africa's Talking
+// read a script from.
+func VoiceScriptXML(script string) []byte {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(script))
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?><Response><Say>` + escaped.String() + `</Say></Response>`)
+}
+
+// TwilioVoiceProvider places calls with the Twilio Voice API, passing the
+// script inline. Call status callbacks go to CallbackURL with the
+// reference in the query string and are signed with the auth token.
+type TwilioVoiceProvider struct {
+	AccountSID  string
+	AuthToken   string
+	From        string
+	CallbackURL string
+	APIURL      string
+	Calls       VoiceCallStore
+	client      *http.Client
+}
+
+func NewTwilioVoiceProvider(accountSID, authToken, from, callbackURL string, calls VoiceCallStore) *TwilioVoiceProvider {
+	return &TwilioVoiceProvider{
+		AccountSID:  accountSID,
+		AuthToken:   authToken,
+		From:        from,
+		CallbackURL: callbackURL,
+		APIURL:      "https://api.twilio.com",
+		Calls:       calls,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *TwilioVoiceProvider) Name() string { return "twilio" }
+
+func (p *TwilioVoiceProvider) Send(ctx context.Context, notification Notification) error {
+	form := url.Values{
+		"To":    {notification.Recipient},
+		"From":  {p.From},
+		"Twiml": {string(VoiceScriptXML(notification.Message))},
+	}
+	if p.CallbackURL != "" && notification.Reference != "" {
+		form.Set("StatusCallback", p.CallbackURL+"?reference="+url.QueryEscape(notification.Reference))
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Calls.json", p.APIURL, p.AccountSID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.AccountSID, p.AuthToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		SID     string `json:"sid"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("twilio returned status %d: %s", resp.StatusCode, result.Message)
+	}
+
+	if p.Calls != nil && notification.Reference != "" {
+		return p.Calls.VoiceCallPlaced(ctx, notification.Reference, p.Name(), result.SID, notification.Message)
+	}
+	return nil
+}
+
+func (p *TwilioVoiceProvider) ParseCallback(r *http.Request) (*VoiceCallEvent, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	signed := p.CallbackURL
+	if r.URL.RawQuery != "" {
+		signed += "?" + r.URL.RawQuery
+	}
+	if !VerifyTwilioSignature(p.AuthToken, signed, r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+		return nil, ErrInvalidCallback
+	}
+
+	event := &VoiceCallEvent{
+		Reference:      r.URL.Query().Get("reference"),
+		ProviderCallID: r.PostForm.Get("CallSid"),
+		Detail:         r.PostForm.Get("CallStatus"),
+	}
+	event.DurationSeconds, _ = strconv.Atoi(r.PostForm.Get("CallDuration"))
+	switch r.PostForm.Get("CallStatus") {
+	case "completed":
+		event.Status = api.VoiceCallAnswered
+	case "no-answer":
+		event.Status = api.VoiceCallNoAnswer
+	case "busy":
+		event.Status = api.VoiceCallBusy
+	case "failed", "canceled":
+		event.Status = api.VoiceCallFailed
+	default:
+		// queued, ringing and in-progress are only sent if subscribed to.
+		event.Active = true
+	}
+	return event, nil
+}
+
+// VerifyTwilioSignature checks X-Twilio-Signature: the base64 HMAC-SHA1,
+// keyed with the auth token, of the URL followed by each POST parameter's
+// name and value in name order.
+func VerifyTwilioSignature(authToken, callbackURL string, form url.Values, signature string) bool {
+	if authToken == "" || signature == "" {
+		return false
+	}
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data := callbackURL
+	for _, key := range keys {
+		for _, value := range form[key] {
+			data += key + value
+		}
+	}
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// AfricasTalkingVoiceProvider places calls with the Africa's Talking Voice
+// API. Africa's Talking asks the number's callback URL, set in its
+// dashboard, what to do when the call connects, so the script is served
+// from the stored call; the same URL receives the outcome once the call
+// ends. Callbacks aren't signed, so the URL carries CallbackToken as
+// ?token=.
+type AfricasTalkingVoiceProvider struct {
+	Username      string
+	APIKey        string
+	From          string
+	CallbackToken string
+	APIURL        string
+	Calls         VoiceCallStore
+	client        *http.Client
+}
+
+func NewAfricasTalkingVoiceProvider(username, apiKey, from, callbackToken string, calls VoiceCallStore) *AfricasTalkingVoiceProvider {
+	return &AfricasTalkingVoiceProvider{
+		Username:      username,
+		APIKey:        apiKey,
+		From:          from,
+		CallbackToken: callbackToken,
+		APIURL:        "https://voice.africastalking.com",
+		Calls:         calls,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *AfricasTalkingVoiceProvider) Name() string { return "africastalking" }
+
+func (p *AfricasTalkingVoiceProvider) Send(ctx context.Context, notification Notification) error {
+	form := url.Values{
+		"username": {p.Username},
+		"from":     {p.From},
+		"to":       {notification.Recipient},
+	}
+	if notification.Reference != "" {
+		form.Set("clientRequestId", notification.Reference)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.APIURL+"/call", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", p.APIKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Entries []struct {
+			Status    string `json:"status"`
+			SessionID string `json:"sessionId"`
+		} `json:"entries"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("africa's talking returned status %d: %s", resp.StatusCode, result.ErrorMessage)
+	}
+	if len(result.Entries) == 0 || result.Entries[0].Status != "Queued" {
+		return fmt.Errorf("africa's talking didn't queue the call: %s", result.ErrorMessage)
+	}
+
+	if p.Calls != nil && notification.Reference != "" {
+		return p.Calls.VoiceCallPlaced(ctx, notification.Reference, p.Name(), result.Entries[0].SessionID, notification.Message)
+	}
+	return nil
+}
+
+func (p *AfricasTalkingVoiceProvider) ParseCallback(r *http.Request) (*VoiceCallEvent, error) {
+	if p.CallbackToken == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(p.CallbackToken)) != 1 {
+		return nil, ErrInvalidCallback
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	event := &VoiceCallEvent{
+		Reference:      r.PostForm.Get("clientRequestId"),
+		ProviderCallID: r.PostForm.Get("sessionId"),
+		Active:         r.PostForm.Get("isActive") == "1",
+		Detail:         r.PostForm.Get("hangupCause"),
+	}
+	if event.Active {
+		return event, nil
+	}
+	event.DurationSeconds, _ = strconv.Atoi(r.PostForm.Get("durationInSeconds"))
+	switch {
+	case event.Detail == "NO_ANSWER" || event.Detail == "NO_USER_RESPONSE":
+		event.Status = api.VoiceCallNoAnswer
+	case event.Detail == "USER_BUSY" || event.Detail == "CALL_REJECTED":
+		event.Status = api.VoiceCallBusy
+	case r.PostForm.Get("status") == "Success" && event.DurationSeconds > 0:
+		event.Status = api.VoiceCallAnswered
+	default:
+		event.Status = api.VoiceCallFailed
+	}
+	return event, nil
+}
@@ -0,0 +1,222 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// WhatsAppTemplate is a message template approved in WhatsApp Business
+// Manager. Parameters are the variables of the local template filled into
+// its {{1}}, {{2}}, ... placeholders, in order.
+type WhatsAppTemplate struct {
+	Name       string
+	Parameters []string
+}
+
+// ParseWhatsAppTemplates reads template=approved_name:var1,var2 pairs
+// separated by semicolons, mapping local message templates to approved
+// WhatsApp templates.
+func ParseWhatsAppTemplates(spec string) (map[string]WhatsAppTemplate, error) {
+	templates := map[string]WhatsAppTemplate{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		local, approved, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(local) == "" || strings.TrimSpace(approved) == "" {
+			return nil, fmt.Errorf("invalid WhatsApp template mapping %q", entry)
+		}
+		name, parameters, _ := strings.Cut(approved, ":")
+		template := WhatsAppTemplate{Name: strings.TrimSpace(name)}
+		for _, parameter := range strings.Split(parameters, ",") {
+			if parameter = strings.TrimSpace(parameter); parameter != "" {
+				template.Parameters = append(template.Parameters, parameter)
+			}
+		}
+		templates[strings.TrimSpace(local)] = template
+	}
+	return templates, nil
+}
+
+// WhatsAppProvider sends messages through the WhatsApp Business Cloud API.
+// Notifications rendered from a mapped template go out as that approved
+// template in the customer's language, as WhatsApp requires for messages
+// the customer didn't start; anything else is sent as text, which WhatsApp
+// only delivers within 24 hours of the customer's last message.
+type WhatsAppProvider struct {
+	PhoneNumberID string
+	AccessToken   string
+	// AppSecret signs the status webhooks; VerifyToken answers the
+	// webhook subscription check.
+	AppSecret   string
+	VerifyToken string
+	Templates   map[string]WhatsAppTemplate
+	APIURL      string
+	client      *http.Client
+}
+
+func NewWhatsAppProvider(phoneNumberID, accessToken, appSecret, verifyToken string, templates map[string]WhatsAppTemplate) *WhatsAppProvider {
+	return &WhatsAppProvider{
+		PhoneNumberID: phoneNumberID,
+		AccessToken:   accessToken,
+		AppSecret:     appSecret,
+		VerifyToken:   verifyToken,
+		Templates:     templates,
+		APIURL:        "https://graph.facebook.com/v19.0",
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *WhatsAppProvider) Send(ctx context.Context, notification Notification) error {
+	_, err := p.SendTracked(ctx, notification)
+	return err
+}
+
+// SendTracked sends the message and returns WhatsApp's message ID, which
+// its status webhooks refer to.
+func (p *WhatsAppProvider) SendTracked(ctx context.Context, notification Notification) (string, error) {
+	message := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"to":                strings.TrimPrefix(notification.Recipient, "+"),
+	}
+	if template, ok := p.Templates[notification.Template]; ok {
+		parameters := make([]map[string]string, 0, len(template.Parameters))
+		for _, name := range template.Parameters {
+			parameters = append(parameters, map[string]string{"type": "text", "text": notification.Variables[name]})
+		}
+		body := map[string]interface{}{
+			"name":     template.Name,
+			"language": map[string]string{"code": notification.Locale},
+		}
+		if len(parameters) > 0 {
+			body["components"] = []map[string]interface{}{{"type": "body", "parameters": parameters}}
+		}
+		message["type"] = "template"
+		message["template"] = body
+	} else {
+		message["type"] = "text"
+		message["text"] = map[string]string{"body": notification.Message}
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.APIURL+"/"+p.PhoneNumberID+"/messages", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.AccessToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("whatsapp returned status %d: %s", resp.StatusCode, result.Error.Message)
+	}
+	if len(result.Messages) == 0 {
+		return "", fmt.Errorf("whatsapp returned no message ID")
+	}
+	return result.Messages[0].ID, nil
+}
+
+// VerifySignature checks X-Hub-Signature-256, the hex HMAC-SHA256 of the
+// webhook body keyed with the app secret.
+func (p *WhatsAppProvider) VerifySignature(signature string, body []byte) bool {
+	if p.AppSecret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(p.AppSecret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(strings.TrimPrefix(signature, "sha256=")))
+}
+
+// DeliveryReceipt is a provider's report on a message it sent.
+type DeliveryReceipt struct {
+	MessageID string
+	Status    string
+	Error     string
+	At        time.Time
+}
+
+// ParseWhatsAppStatuses reads the message statuses out of a webhook body.
+// Other changes, such as incoming messages, are ignored.
+func ParseWhatsAppStatuses(body []byte) ([]DeliveryReceipt, error) {
+	var payload struct {
+		Entry []struct {
+			Changes []struct {
+				Value struct {
+					Statuses []struct {
+						ID        string `json:"id"`
+						Status    string `json:"status"`
+						Timestamp string `json:"timestamp"`
+						Errors    []struct {
+							Code  int    `json:"code"`
+							Title string `json:"title"`
+						} `json:"errors"`
+					} `json:"statuses"`
+				} `json:"value"`
+			} `json:"changes"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	var receipts []DeliveryReceipt
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			for _, status := range change.Value.Statuses {
+				receipt := DeliveryReceipt{MessageID: status.ID}
+				switch status.Status {
+				case "sent":
+					receipt.Status = api.DeliverySent
+				case "delivered":
+					receipt.Status = api.DeliveryDelivered
+				case "read":
+					receipt.Status = api.DeliveryRead
+				case "failed":
+					receipt.Status = api.DeliveryFailed
+				default:
+					continue
+				}
+				if seconds, err := strconv.ParseInt(status.Timestamp, 10, 64); err == nil {
+					receipt.At = time.Unix(seconds, 0).UTC()
+				} else {
+					receipt.At = time.Now().UTC()
+				}
+				if len(status.Errors) > 0 {
+					receipt.Error = fmt.Sprintf("%d: %s", status.Errors[0].Code, status.Errors[0].Title)
+				}
+				receipts = append(receipts, receipt)
+			}
+		}
+	}
+	return receipts, nil
+}
@@ -0,0 +1,133 @@
+package payouts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	log "github.com/sirupsen/logrus"
+)
+
+type Result struct {
+	ProviderReference string
+	// Pending is true when the provider accepted the transfer but will
+	// confirm the final status later through a callback.
+	Pending bool
+}
+
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, payout *api.Payout) (*Result, error)
+	// VerifyCallback reports whether a status callback really came from
+	// the provider. Callbacks are refused without a secret to check them
+	// against.
+	VerifyCallback(header http.Header, body []byte) bool
+}
+
+// SandboxProvider completes every payout at once. Its callbacks are signed
+// with Secret, for testing the callback route.
+type SandboxProvider struct {
+	Secret string
+}
+
+func (SandboxProvider) Name() string { return "sandbox" }
+
+func (p SandboxProvider) VerifyCallback(header http.Header, body []byte) bool {
+	return verifySignature(p.Secret, header.Get("X-Signature"), body)
+}
+
+func (SandboxProvider) Send(ctx context.Context, payout *api.Payout) (*Result, error) {
+	log.Printf("Sandbox payout %s: %.2f %s via %s to %v", payout.Reference, payout.Amount, payout.Currency, payout.Method, payout.Destination)
+	return &Result{ProviderReference: "SANDBOX-" + payout.Reference}, nil
+}
+
+// HTTPProvider posts transfers to a JSON API shaped like most bank-transfer
+// and mobile-money aggregators: {reference, amount, currency, destination}.
+// Its status callbacks carry an HMAC-SHA256 of the body, keyed with
+// webhookSecret, in X-Signature.
+type HTTPProvider struct {
+	name          string
+	url           string
+	apiKey        string
+	webhookSecret string
+	client        *http.Client
+}
+
+func NewHTTPProvider(name, url, apiKey, webhookSecret string) *HTTPProvider {
+	return &HTTPProvider{
+		name:          name,
+		url:           url,
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *HTTPProvider) Name() string { return p.name }
+
+func (p *HTTPProvider) VerifyCallback(header http.Header, body []byte) bool {
+	return verifySignature(p.webhookSecret, header.Get("X-Signature"), body)
+}
+
+func (p *HTTPProvider) Send(ctx context.Context, payout *api.Payout) (*Result, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"reference":   payout.Reference,
+		"amount":      payout.Amount,
+		"currency":    payout.Currency,
+		"destination": payout.Destination,
+		"narration":   fmt.Sprintf("%s %s", payout.PayoutType, payout.Reference),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Status    string `json:"status"`
+		Reference string `json:"reference"`
+		Message   string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&response)
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned status %d: %s", p.name, resp.StatusCode, response.Message)
+	}
+
+	return &Result{
+		ProviderReference: response.Reference,
+		Pending:           response.Status == "pending" || response.Status == "processing",
+	}, nil
+}
+
+func verifySignature(secret, signature string, body []byte) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,61 @@
+package processors
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewCashVarianceJob checks every agent's cash position since the given day
+// and raises a cash_variance alert for each whose deposits lag their
+// collections under policy. alerter may be nil, in which case variances are
+// only logged.
+func NewCashVarianceJob(db *tools.DatabaseService, alerter tools.Alerter, since time.Time, policy tools.CashVariancePolicy) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		positions, err := db.GetAgentCashPositions(ctx, since, "")
+		if err != nil {
+			return err
+		}
+
+		lagging := 0
+		for _, position := range positions {
+			if !policy.Check(position) {
+				continue
+			}
+			lagging++
+			log.Warnf("Agent %s cash variance: %v", position.AgentID, position.Variances)
+			if alerter == nil {
+				continue
+			}
+
+			alert := tools.Alert{
+				Type:     "cash_variance",
+				Severity: "warning",
+				Message:  fmt.Sprintf("Agent %s (%s) has %.2f in collections undeposited", position.AgentID, position.Name, position.Outstanding),
+				Details: map[string]interface{}{
+					"agent_id":           position.AgentID,
+					"collected":          position.Collected,
+					"deposited":          position.Deposited,
+					"unconfirmed":        position.Unconfirmed,
+					"outstanding":        position.Outstanding,
+					"oldest_undeposited": position.OldestUndeposited,
+					"days_outstanding":   position.DaysOutstanding,
+					"variances":          position.Variances,
+				},
+				Timestamp: db.Now(),
+			}
+			if err := alerter.Send(ctx, alert); err != nil {
+				log.Warnf("Failed to send cash variance alert for agent %s: %v", position.AgentID, err)
+			}
+		}
+
+		tools.DefaultMetrics.Set("agent_cash_variances", float64(lagging))
+		if lagging > 0 {
+			log.Printf("Cash variance check: %d of %d agents lagging on deposits", lagging, len(positions))
+		}
+		return nil
+	}
+}
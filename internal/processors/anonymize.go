@@ -0,0 +1,35 @@
+package processors
+
+import (
+	"context"
+	"errors"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// RunAnonymizedExport writes an anonymized copy of the dataset for loading
+// into staging.
+func RunAnonymizedExport(ctx context.Context, db *tools.DatabaseService, storage tools.BlobStore, opts tools.AnonymizeOptions) (*tools.AnonymizedExport, error) {
+	if storage == nil {
+		return nil, errors.New("anonymized exports require blob storage")
+	}
+
+	export, err := db.ExportAnonymized(ctx, storage, tools.AnonymizedExportID(db.Now()), opts)
+	if err != nil {
+		return export, err
+	}
+
+	for _, table := range export.Tables {
+		tools.DefaultMetrics.Inc("anonymized_export_rows_total", float64(table.Rows), "table", table.Table)
+	}
+	log.Printf("Anonymized export %s written to %s", export.ExportID, tools.AnonymizedManifestKey(export.ExportID))
+	return export, nil
+}
+
+func NewAnonymizedExportJob(db *tools.DatabaseService, storage tools.BlobStore, opts tools.AnonymizeOptions) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := RunAnonymizedExport(ctx, db, storage, opts)
+		return err
+	}
+}
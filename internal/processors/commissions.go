@@ -0,0 +1,33 @@
+package processors
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewCommissionRunJob calculates agents' commission for the previous
+// calendar month once it has ended. Runs are left for approval; a month
+// already calculated, by hand or by an earlier tick, is not recalculated.
+func NewCommissionRunJob(db *tools.DatabaseService) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := db.Now()
+		to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+		from := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		exists, err := db.CommissionRunExists(ctx, from, to)
+		if err != nil || exists {
+			return err
+		}
+
+		run, err := db.CalculateCommissionRun(ctx, from, to, "scheduler")
+		if err != nil {
+			return err
+		}
+		log.Printf("Calculated commission run %d for %s to %s: %d agents, %.2f payable",
+			run.ID, run.PeriodStart, run.PeriodEnd, run.Agents, run.Total)
+		return nil
+	}
+}
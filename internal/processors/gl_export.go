@@ -0,0 +1,111 @@
+package processors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// FileUploader pushes files to a remote drop, such as an SFTP directory.
+type FileUploader interface {
+	Upload(ctx context.Context, name string, data []byte) error
+	Close() error
+}
+
+// GLExporter pushes the GL journal and trial balance of a period to the
+// accounting system. Target may be nil, in which case only downloads work.
+type GLExporter struct {
+	db       *tools.DatabaseService
+	Accounts tools.GLAccounts
+	Format   string
+	Target   FileUploader
+
+	mu sync.Mutex
+}
+
+func NewGLExporter(db *tools.DatabaseService, accounts tools.GLAccounts, format string, target FileUploader) *GLExporter {
+	return &GLExporter{db: db, Accounts: accounts, Format: format, Target: target}
+}
+
+// Push uploads the journal of the days from through to in format, with the
+// trial balance of the same period, and records the export.
+func (e *GLExporter) Push(ctx context.Context, from, to time.Time, format, exportedBy string) (*api.GLExport, error) {
+	if e.Target == nil {
+		return nil, errors.New("no GL export target is configured")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.Target.Close()
+
+	lines, err := e.db.GLJournal(ctx, from, to, e.Accounts)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := e.db.TrialBalance(ctx, from, to, e.Accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	var journal, trial bytes.Buffer
+	if err := tools.WriteGLJournal(&journal, format, lines); err != nil {
+		return nil, err
+	}
+	if err := tools.WriteTrialBalanceCSV(&trial, balance); err != nil {
+		return nil, err
+	}
+
+	journalFile, trialFile := tools.GLExportFileNames(from, to, format)
+	if err := e.Target.Upload(ctx, journalFile, journal.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := e.Target.Upload(ctx, trialFile, trial.Bytes()); err != nil {
+		return nil, err
+	}
+
+	export, err := e.db.RecordGLExport(ctx, &api.GLExport{
+		PeriodStart:      from.Format("2006-01-02"),
+		PeriodEnd:        to.Format("2006-01-02"),
+		Format:           format,
+		JournalFile:      journalFile,
+		TrialBalanceFile: trialFile,
+		Lines:            len(lines),
+		TotalDebits:      balance.TotalDebits,
+		ExportedBy:       exportedBy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tools.DefaultMetrics.Inc("gl_exports_total", 1, "format", format)
+	log.Printf("GL export %s to %s pushed: %d journal lines", export.PeriodStart, export.PeriodEnd, export.Lines)
+	return export, nil
+}
+
+// Run pushes the days since the last export through yesterday, starting
+// with yesterday alone if nothing has been exported yet.
+func (e *GLExporter) Run(ctx context.Context) error {
+	now := e.db.Now()
+	yesterday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+
+	last, err := e.db.LastGLExportEnd(ctx)
+	if err != nil {
+		return err
+	}
+	from := yesterday
+	if !last.IsZero() {
+		from = last.AddDate(0, 0, 1)
+	}
+	if from.After(yesterday) {
+		return nil
+	}
+
+	_, err = e.Push(ctx, from, yesterday, e.Format, "scheduler")
+	return err
+}
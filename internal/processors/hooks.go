@@ -0,0 +1,107 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// ErrRejectedByHook marks payments a BeforeApply hook refused. They are not
+// retried.
+var ErrRejectedByHook = errors.New("payment rejected by hook")
+
+// Hook lets a deployment run its own logic around payment processing, e.g.
+// custom fraud rules, external notifications or extra ledger postings.
+// Hooks run in the order they were added, on the worker goroutine, so slow
+// work should be handed off.
+type Hook interface {
+	// BeforeApply runs before the balance is touched. An error rejects the
+	// payment.
+	BeforeApply(ctx context.Context, payment *api.PaymentPayload) error
+	// AfterApply runs once the payment has been applied to the account.
+	AfterApply(ctx context.Context, payment *api.PaymentPayload, result ApplyResult)
+	// OnFailure runs when the payment could not be applied, including when
+	// a BeforeApply hook rejected it.
+	OnFailure(ctx context.Context, payment *api.PaymentPayload, err error)
+}
+
+// ApplyResult describes an applied payment.
+type ApplyResult struct {
+	Amount     float64
+	NewBalance float64
+	ValueDate  time.Time
+}
+
+// HookFuncs builds a Hook from plain functions; nil functions are skipped.
+type HookFuncs struct {
+	Before  func(ctx context.Context, payment *api.PaymentPayload) error
+	After   func(ctx context.Context, payment *api.PaymentPayload, result ApplyResult)
+	Failure func(ctx context.Context, payment *api.PaymentPayload, err error)
+}
+
+func (h HookFuncs) BeforeApply(ctx context.Context, payment *api.PaymentPayload) error {
+	if h.Before == nil {
+		return nil
+	}
+	return h.Before(ctx, payment)
+}
+
+func (h HookFuncs) AfterApply(ctx context.Context, payment *api.PaymentPayload, result ApplyResult) {
+	if h.After != nil {
+		h.After(ctx, payment, result)
+	}
+}
+
+func (h HookFuncs) OnFailure(ctx context.Context, payment *api.PaymentPayload, err error) {
+	if h.Failure != nil {
+		h.Failure(ctx, payment, err)
+	}
+}
+
+// Use adds hooks to the processor. Call it before Start, or pass WithHooks
+// to NewPaymentProcessor.
+func (p *PaymentProcessor) Use(hooks ...Hook) {
+	p.hooks = append(p.hooks, hooks...)
+}
+
+func (p *PaymentProcessor) beforeApply(ctx context.Context, payment *api.PaymentPayload) error {
+	for _, hook := range p.hooks {
+		if err := p.callHook(payment, func() error { return hook.BeforeApply(ctx, payment) }); err != nil {
+			return fmt.Errorf("%w: %v", ErrRejectedByHook, err)
+		}
+	}
+	return nil
+}
+
+func (p *PaymentProcessor) afterApply(ctx context.Context, payment *api.PaymentPayload, result ApplyResult) {
+	for _, hook := range p.hooks {
+		p.callHook(payment, func() error {
+			hook.AfterApply(ctx, payment, result)
+			return nil
+		})
+	}
+}
+
+func (p *PaymentProcessor) onFailure(ctx context.Context, payment *api.PaymentPayload, err error) {
+	for _, hook := range p.hooks {
+		p.callHook(payment, func() error {
+			hook.OnFailure(ctx, payment, err)
+			return nil
+		})
+	}
+}
+
+// callHook keeps a panicking hook from taking the worker down; the panic
+// counts as an error.
+func (p *PaymentProcessor) callHook(payment *api.PaymentPayload, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Printf("Payment hook panicked on %s: %v", payment.TransactionReference, r)
+			err = fmt.Errorf("hook panicked: %v", r)
+		}
+	}()
+	return fn()
+}
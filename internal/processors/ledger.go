@@ -0,0 +1,75 @@
+package processors
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+const ledgerCheckBatchSize = 500
+
+// LedgerCheckOptions configures NewLedgerCheckJob.
+type LedgerCheckOptions struct {
+	// Repair resets drifted accounts to the ledger's totals. Leave it off
+	// until the drift found has been looked at: an account ahead of its
+	// ledger can also mean a payment applied without being recorded.
+	Repair bool
+	// Settle is how long an account must be left alone before it is
+	// checked, so payments being applied aren't reported as drift.
+	Settle time.Duration
+}
+
+// NewLedgerCheckJob recomputes every account's total_paid and payment_count
+// from processed_transactions and records the accounts that disagree,
+// repairing them if opts.Repair is set. It is the check that each payment
+// was counted exactly once.
+func NewLedgerCheckJob(db *tools.DatabaseService, redis *tools.RedisService, opts LedgerCheckOptions) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		settledBefore := db.Now().Add(-opts.Settle)
+		checked, drifted, repaired := 0, 0, 0
+		afterID := ""
+
+		for {
+			batch, err := db.CheckLedgerBatch(ctx, afterID, ledgerCheckBatchSize, settledBefore)
+			if err != nil {
+				return err
+			}
+			checked += batch.Scanned
+
+			for _, drift := range batch.Drift {
+				if err := db.RecordLedgerDrift(ctx, drift, opts.Repair); err != nil {
+					log.Errorf("Failed to record ledger drift for %s: %v", drift.CustomerID, err)
+					continue
+				}
+				drifted++
+				log.Warnf("Ledger drift on %s: total_paid %.2f vs ledger %.2f, payment_count %d vs ledger %d (repaired: %t)",
+					drift.CustomerID, drift.RecordedTotalPaid, drift.LedgerTotalPaid,
+					drift.RecordedPaymentCount, drift.LedgerPaymentCount, drift.Repaired)
+				if !drift.Repaired {
+					continue
+				}
+				repaired++
+				if err := redis.InvalidateBalance(ctx, drift.CustomerID); err != nil {
+					log.Warnf("Failed to invalidate cached balance for %s: %v", drift.CustomerID, err)
+				}
+			}
+
+			if batch.Scanned < ledgerCheckBatchSize {
+				break
+			}
+			afterID = batch.Last
+		}
+
+		if repaired > 0 {
+			if err := redis.InvalidateResponseCache(ctx); err != nil {
+				log.Warnf("Failed to invalidate response cache: %v", err)
+			}
+		}
+		tools.DefaultMetrics.Set("ledger_drift_accounts", float64(drifted))
+		tools.DefaultMetrics.Inc("ledger_drift_repairs_total", float64(repaired))
+		log.Printf("Ledger check compared %d accounts: %d drifted, %d repaired", checked, drifted, repaired)
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package processors
+
+import (
+	"github.com/abjerry97/go_payment/internal/flags"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Option customises a PaymentProcessor at construction.
+type Option func(*PaymentProcessor)
+
+// WithLogger sends the processor's logs to logger instead of the standard
+// logrus logger.
+func WithLogger(logger log.FieldLogger) Option {
+	return func(p *PaymentProcessor) { p.logger = logger }
+}
+
+// WithMetrics records the processor's metrics in metrics instead of
+// tools.DefaultMetrics.
+func WithMetrics(metrics *tools.Metrics) Option {
+	return func(p *PaymentProcessor) { p.metrics = metrics }
+}
+
+// WithQueue replaces the queue the processor reads payments from.
+func WithQueue(queue PaymentQueue) Option {
+	return func(p *PaymentProcessor) { p.redis = queue }
+}
+
+// WithClock replaces the clock used for latency, value dates and the
+// workers' back-off after errors. Workers on a ManualClock wait out their
+// back-off, empty queue polls included, until it is advanced.
+func WithClock(clock tools.Clock) Option {
+	return func(p *PaymentProcessor) { p.clock = clock }
+}
+
+func WithFlags(provider *flags.Provider) Option {
+	return func(p *PaymentProcessor) { p.Flags = provider }
+}
+
+func WithHooks(hooks ...Hook) Option {
+	return func(p *PaymentProcessor) { p.Use(hooks...) }
+}
@@ -0,0 +1,47 @@
+package processors
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewPartitionMaintenanceJob keeps processed_transactions' monthly
+// partitions ahead of time: it creates partitions for the current month and
+// monthsAhead after it, and detaches attached partitions that ended more
+// than detachAfterMonths ago (0 keeps them all). It does nothing until the
+// table has been partitioned.
+func NewPartitionMaintenanceJob(db *tools.DatabaseService, monthsAhead, detachAfterMonths int) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		partitioned, err := db.IsTransactionsPartitioned(ctx)
+		if err != nil {
+			return err
+		}
+		if !partitioned {
+			log.Debug("processed_transactions is not partitioned; skipping partition maintenance")
+			return nil
+		}
+
+		now := db.Now()
+		created, err := db.EnsureTransactionPartitions(ctx, now, monthsAhead)
+		for _, name := range created {
+			log.Printf("Created partition %s", name)
+		}
+		if err != nil {
+			return err
+		}
+		tools.DefaultMetrics.Inc("transaction_partitions_created_total", float64(len(created)))
+
+		if detachAfterMonths <= 0 {
+			return nil
+		}
+		cutoff := now.AddDate(0, -detachAfterMonths, 0)
+		detached, err := db.DetachTransactionPartitions(ctx, cutoff)
+		for _, name := range detached {
+			log.Printf("Detached partition %s", name)
+		}
+		tools.DefaultMetrics.Inc("transaction_partitions_detached_total", float64(len(detached)))
+		return err
+	}
+}
@@ -2,69 +2,359 @@ package processors
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/calendar"
+	"github.com/abjerry97/go_payment/internal/flags"
+	"github.com/abjerry97/go_payment/internal/schedule"
 	"github.com/abjerry97/go_payment/internal/tools"
-	"github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
 )
 
+// PaymentStore is the account storage the payment processor applies
+// payments to. *tools.DatabaseService implements it.
+type PaymentStore interface {
+	IsTransactionProcessed(ctx context.Context, txnRef string) (bool, error)
+	GetCustomer(ctx context.Context, customerID string) (*api.CustomerAccount, error)
+	UpdateCustomerBalance(ctx context.Context, customerID string, amount, waived float64, txnDate string, version int) error
+	MarkTransactionProcessed(ctx context.Context, payment *api.PaymentPayload, amount float64, valueDate time.Time) error
+	ApplyPaymentToPromises(ctx context.Context, customerID string, amount float64, valueDate time.Time) (int64, error)
+}
+
+// PaymentQueue feeds the processor and holds its duplicate and balance
+// caches. *tools.RedisService implements it.
+type PaymentQueue interface {
+	DequeuePayment(ctx context.Context, timeout time.Duration) (*api.PaymentPayload, error)
+	MarkDuplicate(ctx context.Context, txnRef string, ttl time.Duration) error
+	CacheBalance(ctx context.Context, customerID string, balance float64, ttl time.Duration) error
+}
+
+// SuspenseStore is what the processor needs to check the customer of an
+// unverified payment (see api.MetadataCustomerUnverified).
+// *tools.DatabaseService implements it.
+type SuspenseStore interface {
+	MergedInto(ctx context.Context, customerID string) (string, error)
+	SuspendPayment(ctx context.Context, payment *api.PaymentPayload, amount float64, reason string) error
+}
+
+// Requeuer puts a payment back on the queue. *tools.RedisService
+// implements it.
+type Requeuer interface {
+	EnqueuePayment(ctx context.Context, payment *api.PaymentPayload) error
+}
+
+// Acknowledger is told when the processor is done with a dequeued payment,
+// so the queue can hand back the ones it never finishes.
+// *tools.RedisService implements it.
+type Acknowledger interface {
+	AckPayment(ctx context.Context, payment *api.PaymentPayload) error
+}
+
+// BatchDequeuer takes several payments off the queue in one round trip.
+// *tools.RedisService implements it.
+type BatchDequeuer interface {
+	DequeuePayments(ctx context.Context, max int, timeout time.Duration) ([]*api.PaymentPayload, error)
+}
+
+// AttemptRecorder keeps the processing history of payments.
+// *tools.DatabaseService implements it.
+type AttemptRecorder interface {
+	RecordPaymentAttempt(ctx context.Context, attempt *api.PaymentAttempt) error
+}
+
+// ErrSuspended marks an unverified payment sent to suspense because its
+// customer doesn't exist. It is not retried.
+var ErrSuspended = errors.New("payment sent to suspense")
+
+// ErrDatabaseUnavailable marks an unverified payment whose customer couldn't
+// be checked because the database is still unreachable.
+var ErrDatabaseUnavailable = errors.New("database unavailable")
+
 type PaymentProcessor struct {
-	db          *tools.DatabaseService
-	redis       *tools.RedisService
+	db          PaymentStore
+	redis       PaymentQueue
 	WorkerCount int
-	wg          sync.WaitGroup
-	stopChan    chan struct{}
+	SLA         *SLATracker
+	// Calendar value-dates payments; without one the value date is the
+	// transaction date.
+	Calendar *calendar.Calendar
+	// Flags gates processing changes being rolled out; nil leaves every
+	// change at its default.
+	Flags *flags.Provider
+	// Suspense, when set, receives unverified payments whose customer
+	// doesn't exist; without it they fail like any other payment.
+	Suspense SuspenseStore
+	// Requeue, when set, takes back unverified payments that hit a database
+	// outage so they are applied once it is over.
+	Requeue Requeuer
+	// Ack, when set, is told when each dequeued payment is finished with.
+	Ack Acknowledger
+	// Attempts, when set, keeps a record of every processing attempt,
+	// labelled with Instance.
+	Attempts AttemptRecorder
+	Instance string
+	// Batch, when set and BatchSize is above 1, replaces each worker
+	// polling the queue: one dispatcher takes up to BatchSize payments at a
+	// time and hands each to the worker for its customer, so a customer's
+	// payments are applied one at a time in queue order.
+	Batch     BatchDequeuer
+	BatchSize int
+
+	hooks    []Hook
+	logger   log.FieldLogger
+	metrics  *tools.Metrics
+	clock    tools.Clock
+	wg       sync.WaitGroup
+	stopChan chan struct{}
+	stopOnce sync.Once
+	inFlight atomic.Int64
+	// lastActivity is when a worker last took a payment, or started, in
+	// Unix nanoseconds.
+	lastActivity atomic.Int64
+	// startedAt is when Start was called, in Unix nanoseconds, and
+	// processed counts the payments each worker has taken since.
+	startedAt   atomic.Int64
+	processedMu sync.Mutex
+	processed   []*atomic.Int64
+	workers     []*workerState
+}
+
+// WorkerThroughput is one worker's share of the payments this instance has
+// processed since it started.
+type WorkerThroughput struct {
+	Worker    int     `json:"worker"`
+	Processed int64   `json:"processed"`
+	PerSecond float64 `json:"per_second"`
 }
 
-func NewPaymentProcessor(db *tools.DatabaseService, redis *tools.RedisService, WorkerCount int) *PaymentProcessor {
-	return &PaymentProcessor{
+func NewPaymentProcessor(db PaymentStore, redis PaymentQueue, WorkerCount int, opts ...Option) *PaymentProcessor {
+	processor := &PaymentProcessor{
 		db:          db,
 		redis:       redis,
 		WorkerCount: WorkerCount,
+		logger:      log.StandardLogger(),
+		metrics:     tools.DefaultMetrics,
+		clock:       tools.SystemClock{},
 		stopChan:    make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(processor)
+	}
+	return processor
 }
 
 func (p *PaymentProcessor) Start(ctx context.Context) {
-	log.Printf("Starting %d payment processors", p.WorkerCount)
+	p.logger.Printf("Starting %d payment processors", p.WorkerCount)
+	p.lastActivity.Store(p.clock.Now().UnixNano())
+	p.startedAt.Store(p.clock.Now().UnixNano())
 
-	for i := 0; i < p.WorkerCount; i++ {
+	processed := make([]*atomic.Int64, p.WorkerCount)
+	workers := make([]*workerState, p.WorkerCount)
+	for i := range processed {
+		processed[i] = new(atomic.Int64)
+		workers[i] = &workerState{status: WorkerStatus{
+			Worker: tools.WorkerName(p.Instance, i),
+			Index:  i,
+			State:  WorkerIdle,
+			Since:  p.clock.Now(),
+		}}
+	}
+	p.processedMu.Lock()
+	p.processed = processed
+	p.workers = workers
+	p.processedMu.Unlock()
+
+	if p.Batch == nil || p.BatchSize <= 1 {
+		for i := 0; i < p.WorkerCount; i++ {
+			p.wg.Add(1)
+			go p.worker(ctx, i, nil)
+		}
+		return
+	}
+
+	queues := make([]chan *api.PaymentPayload, p.WorkerCount)
+	for i := range queues {
+		queues[i] = make(chan *api.PaymentPayload, p.BatchSize)
 		p.wg.Add(1)
-		go p.worker(ctx, i)
+		go p.worker(ctx, i, queues[i])
 	}
+	p.wg.Add(1)
+	go p.dispatch(ctx, queues)
 }
 
 func (p *PaymentProcessor) Stop() {
-	log.Println("Stopping payment processors...")
-	close(p.stopChan)
+	p.logger.Println("Stopping payment processors...")
+	p.stopOnce.Do(func() { close(p.stopChan) })
 	p.wg.Wait()
-	log.Println("All processors stopped")
+	p.logger.Println("All processors stopped")
+}
+
+// Drain stops the workers taking new payments off the queue and waits for
+// the ones they hold to be applied. It returns ctx's error if that takes
+// longer than ctx allows; the workers keep finishing in the background.
+func (p *PaymentProcessor) Drain(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stopChan) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.logger.Println("Payment processors drained")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d payments still in flight: %w", p.InFlight(), ctx.Err())
+	}
 }
 
-func (p *PaymentProcessor) worker(ctx context.Context, workerID int) {
+// InFlight is the number of dequeued payments not yet finished.
+func (p *PaymentProcessor) InFlight() int64 {
+	return p.inFlight.Load()
+}
+
+// LastActivity is when a worker last took a payment off the queue, or when
+// the processor started if none has yet.
+func (p *PaymentProcessor) LastActivity() time.Time {
+	return time.Unix(0, p.lastActivity.Load())
+}
+
+// Throughput returns how many payments each worker has taken off the queue
+// since Start, and the rate. It is empty before Start.
+func (p *PaymentProcessor) Throughput() []WorkerThroughput {
+	p.processedMu.Lock()
+	processed := p.processed
+	p.processedMu.Unlock()
+
+	elapsed := p.clock.Now().Sub(time.Unix(0, p.startedAt.Load())).Seconds()
+	throughput := make([]WorkerThroughput, len(processed))
+	for i, count := range processed {
+		throughput[i] = WorkerThroughput{Worker: i, Processed: count.Load()}
+		if elapsed > 0 {
+			throughput[i].PerSecond = float64(throughput[i].Processed) / elapsed
+		}
+	}
+	return throughput
+}
+
+// Stopped reports whether Stop or Drain has been called.
+func (p *PaymentProcessor) Stopped() bool {
+	select {
+	case <-p.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// worker processes payments until the processor stops: ones it dequeues
+// itself, or with batching the ones the dispatcher sends it until it closes
+// payments.
+func (p *PaymentProcessor) worker(ctx context.Context, workerID int, payments <-chan *api.PaymentPayload) {
 	defer p.wg.Done()
-	log.Printf("Worker %d started", workerID)
+	logger := p.workerLogger(workerID)
+	ctx = context.WithValue(ctx, loggerKey{}, logger)
+	logger.Printf("Worker %d started", workerID)
+	if state := p.workerState(workerID); state != nil {
+		defer func() { state.set(WorkerStopped, nil, p.clock.Now()) }()
+	}
+
+	if payments != nil {
+		for payment := range payments {
+			err := p.processDequeued(ctx, workerID, payment)
+			p.inFlight.Add(-1)
+			if err != nil {
+				logger.Printf("Worker %d error: %v", workerID, err)
+				p.pause(err)
+			}
+		}
+		return
+	}
 
 	for {
 		select {
 		case <-p.stopChan:
 			return
 		default:
-			if err := p.processNextPayment(ctx); err != nil {
-				if err != redis.Nil {
-					log.Printf("Worker %d error: %v", workerID, err)
+			if err := p.processNextPayment(ctx, workerID); err != nil {
+				if !errors.Is(err, tools.ErrNotFound) {
+					logger.Printf("Worker %d error: %v", workerID, err)
 				}
-				time.Sleep(10 * time.Millisecond)
+				p.pause(err)
 			}
 		}
 	}
 }
 
-func (p *PaymentProcessor) processNextPayment(ctx context.Context) error {
+// pause backs a worker or the dispatcher off after an error, on the
+// processor's clock. It returns early once the processor stops.
+func (p *PaymentProcessor) pause(err error) {
+	wait := 10 * time.Millisecond
+	if errors.Is(err, tools.ErrUnsupportedSchemaVersion) || errors.Is(err, ErrDatabaseUnavailable) {
+		// Leave the message for an upgraded worker, or the database to
+		// come back, instead of spinning on it.
+		wait = 5 * time.Second
+	}
+	select {
+	case <-tools.After(p.clock, wait):
+	case <-p.stopChan:
+	}
+}
+
+// dispatch feeds the workers batches from the queue until the processor
+// stops, then closes their queues. A full worker queue holds it up rather
+// than let payments pile up in memory.
+func (p *PaymentProcessor) dispatch(ctx context.Context, queues []chan *api.PaymentPayload) {
+	defer p.wg.Done()
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+	}()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		default:
+		}
+
+		payments, err := p.Batch.DequeuePayments(ctx, p.BatchSize, 1*time.Second)
+		if len(payments) > 0 {
+			p.lastActivity.Store(p.clock.Now().UnixNano())
+			p.inFlight.Add(int64(len(payments)))
+			p.metrics.Observe("payment_dequeue_batch_size", float64(len(payments)), "instance", p.Instance)
+			for _, payment := range payments {
+				queues[workerFor(payment.CustomerID, len(queues))] <- payment
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, tools.ErrNotFound) {
+				p.logger.Printf("Dispatcher error: %v", err)
+			}
+			p.pause(err)
+		}
+	}
+}
+
+// workerFor picks the worker that processes a customer's payments.
+func workerFor(customerID string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(customerID))
+	return int(h.Sum32() % uint32(workers))
+}
+
+func (p *PaymentProcessor) processNextPayment(ctx context.Context, workerID int) error {
 
 	payment, err := p.redis.DequeuePayment(ctx, 1*time.Second)
 	if err != nil {
@@ -75,10 +365,118 @@ func (p *PaymentProcessor) processNextPayment(ctx context.Context) error {
 		return nil
 	}
 
+	p.lastActivity.Store(p.clock.Now().UnixNano())
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	return p.processDequeued(ctx, workerID, payment)
+}
+
+// processDequeued processes a payment taken off the queue on the worker
+// and acks it.
+func (p *PaymentProcessor) processDequeued(ctx context.Context, workerID int, payment *api.PaymentPayload) error {
+	p.countProcessed(workerID)
+	if state := p.workerState(workerID); state != nil {
+		state.set(WorkerProcessing, payment, p.clock.Now())
+		defer func() { state.set(WorkerIdle, nil, p.clock.Now()) }()
+	}
+	busy := []string{"instance", p.Instance, "worker", strconv.Itoa(workerID)}
+	p.metrics.Set("payment_worker_busy", 1, busy...)
+	defer p.metrics.Set("payment_worker_busy", 0, busy...)
+
+	err := p.attemptPayment(ctx, payment, &workerID)
+	if p.Ack != nil {
+		if ackErr := p.Ack.AckPayment(ctx, payment); ackErr != nil {
+			p.log(ctx).Printf("Warning: failed to ack payment %s: %v", payment.TransactionReference, ackErr)
+		}
+	}
+	return err
+}
+
+func (p *PaymentProcessor) countProcessed(workerID int) {
+	p.metrics.Inc("payment_worker_processed_total", 1, "instance", p.Instance, "worker", strconv.Itoa(workerID))
+	p.processedMu.Lock()
+	processed := p.processed
+	p.processedMu.Unlock()
+	if workerID < len(processed) {
+		processed[workerID].Add(1)
+	}
+}
+
+// Process applies one payment synchronously, bypassing the queue, with the
+// same duplicate checks, retries and hooks as the workers.
+func (p *PaymentProcessor) Process(ctx context.Context, payment *api.PaymentPayload) error {
 	return p.processPayment(ctx, payment)
 }
 
 func (p *PaymentProcessor) processPayment(ctx context.Context, payment *api.PaymentPayload) error {
+	return p.attemptPayment(ctx, payment, nil)
+}
+
+// attemptPayment processes the payment on the worker, or synchronously
+// when workerID is nil, and records the attempt.
+func (p *PaymentProcessor) attemptPayment(ctx context.Context, payment *api.PaymentPayload, workerID *int) error {
+	ctx = flags.NewContext(ctx, p.Flags, payment.CustomerID, payment.Channel)
+	attempt := &api.PaymentAttempt{
+		TransactionReference: payment.TransactionReference,
+		Instance:             p.Instance,
+		WorkerID:             workerID,
+		StartedAt:            p.clock.Now(),
+	}
+	if payment.EnqueuedAt != nil {
+		queued := attempt.StartedAt.Sub(*payment.EnqueuedAt).Milliseconds()
+		attempt.QueuedMs = &queued
+	}
+
+	err := p.applyPayment(ctx, payment, attempt)
+	if err != nil && unverified(payment) && p.Requeue != nil && tools.IsUnavailable(err) {
+		// Accepted while the database was down and it still is: keep the
+		// payment until it comes back.
+		if requeueErr := p.Requeue.EnqueuePayment(ctx, payment); requeueErr == nil {
+			p.metrics.Inc("payments_requeued_total", 1)
+			err = fmt.Errorf("%w: %v", ErrDatabaseUnavailable, err)
+			p.recordAttempt(ctx, payment, attempt, err)
+			return err
+		}
+	}
+	if err != nil {
+		p.metrics.Inc("payment_failures_total", 1)
+		p.onFailure(ctx, payment, err)
+	}
+	p.recordAttempt(ctx, payment, attempt, err)
+	return err
+}
+
+// recordAttempt finishes the attempt with how err says it ended and stores
+// it. Failing to store it doesn't fail the payment.
+func (p *PaymentProcessor) recordAttempt(ctx context.Context, payment *api.PaymentPayload, attempt *api.PaymentAttempt, err error) {
+	if p.Attempts == nil {
+		return
+	}
+	// The customer may have been resolved to a merge survivor.
+	attempt.CustomerID = payment.CustomerID
+	attempt.DurationMs = int(p.clock.Now().Sub(attempt.StartedAt).Milliseconds())
+	switch {
+	case err == nil && attempt.Outcome == "":
+		attempt.Outcome = api.AttemptApplied
+	case errors.Is(err, ErrDatabaseUnavailable):
+		attempt.Outcome = api.AttemptRequeued
+	case errors.Is(err, ErrSuspended):
+		attempt.Outcome = api.AttemptSuspended
+	case errors.Is(err, ErrRejectedByHook):
+		attempt.Outcome = api.AttemptRejected
+	case err != nil:
+		attempt.Outcome = api.AttemptFailed
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	if err := p.Attempts.RecordPaymentAttempt(ctx, attempt); err != nil {
+		p.log(ctx).Printf("Warning: failed to record attempt at payment %s: %v", payment.TransactionReference, err)
+	}
+}
+
+func (p *PaymentProcessor) applyPayment(ctx context.Context, payment *api.PaymentPayload, attempt *api.PaymentAttempt) error {
 
 	processed, err := p.db.IsTransactionProcessed(ctx, payment.TransactionReference)
 	if err != nil {
@@ -86,62 +484,154 @@ func (p *PaymentProcessor) processPayment(ctx context.Context, payment *api.Paym
 	}
 
 	if processed {
-		log.Printf("Transaction already processed: %s", payment.TransactionReference)
+		p.log(ctx).Printf("Transaction already processed: %s", payment.TransactionReference)
+		attempt.Outcome = api.AttemptDuplicate
 		return nil
 	}
 
-	var amount float64
-	if _, err := fmt.Sscanf(payment.TransactionAmount, "%f", &amount); err != nil {
-		return fmt.Errorf("invalid amount: %v", err)
+	amount, err := tools.ParseAmount(payment.TransactionAmount)
+	if err != nil {
+		return err
+	}
+
+	if unverified(payment) {
+		if err := p.verifyCustomer(ctx, payment, amount); err != nil {
+			return err
+		}
+	}
+
+	valueDate := p.valueDate(ctx, payment)
+
+	if err := p.beforeApply(ctx, payment); err != nil {
+		return err
 	}
 
 	maxRetries := 3
-	for attempt := 0; attempt < maxRetries; attempt++ {
+	for retry := 0; retry < maxRetries; retry++ {
 
 		customer, err := p.db.GetCustomer(ctx, payment.CustomerID)
 		if err != nil {
 			return fmt.Errorf("failed to get customer: %v", err)
 		}
 
-		success, err := p.db.UpdateCustomerBalance(
+		waived := waivedInterest(customer, amount, valueDate)
+		err = p.db.UpdateCustomerBalance(
 			ctx,
 			payment.CustomerID,
 			amount,
+			waived,
 			payment.TransactionDate,
 			customer.Version,
 		)
 
-		if err != nil {
+		if err != nil && !errors.Is(err, tools.ErrVersionConflict) {
 			return fmt.Errorf("failed to update balance: %v", err)
 		}
 
-		if success {
+		if err == nil {
 
-			if err := p.db.MarkTransactionProcessed(ctx, payment.TransactionReference, payment.CustomerID, amount); err != nil {
-				log.Printf("Warning: failed to mark transaction as processed: %v", err)
+			if err := p.db.MarkTransactionProcessed(ctx, payment, amount, valueDate); err != nil {
+				p.log(ctx).Printf("Warning: failed to mark transaction as processed: %v", err)
+			}
+
+			if _, err := p.db.ApplyPaymentToPromises(ctx, payment.CustomerID, amount, valueDate); err != nil {
+				p.log(ctx).Printf("Warning: failed to apply payment to promises: %v", err)
 			}
 
 			if err := p.redis.MarkDuplicate(ctx, payment.TransactionReference, 24*time.Hour); err != nil {
-				log.Printf("Warning: failed to cache duplicate: %v", err)
+				p.log(ctx).Printf("Warning: failed to cache duplicate: %v", err)
 			}
 
-			newBalance := customer.OutstandingBalance - amount
+			newBalance := customer.OutstandingBalance - amount - waived
 			if newBalance < 0 {
 				newBalance = 0
 			}
 
 			if err := p.redis.CacheBalance(ctx, payment.CustomerID, newBalance, 5*time.Minute); err != nil {
-				log.Printf("Warning: failed to cache balance: %v", err)
+				p.log(ctx).Printf("Warning: failed to cache balance: %v", err)
+			}
+
+			if p.SLA != nil && payment.EnqueuedAt != nil {
+				p.SLA.Record(payment.TransactionReference, p.clock.Now().Sub(*payment.EnqueuedAt))
 			}
 
-			log.Printf("Processed payment: %s - Amount: %.2f - Balance: %.2f",
+			p.metrics.Inc("payments_applied_total", 1)
+			p.afterApply(ctx, payment, ApplyResult{Amount: amount, NewBalance: newBalance, ValueDate: valueDate})
+
+			p.log(ctx).Printf("Processed payment: %s - Amount: %.2f - Balance: %.2f",
 				payment.CustomerID, amount, newBalance)
 			return nil
 		}
 
-		log.Printf("Version conflict for %s, retry %d", payment.CustomerID, attempt+1)
-		time.Sleep(time.Duration(attempt+1) * 10 * time.Millisecond)
+		p.log(ctx).Printf("Version conflict for %s, retry %d", payment.CustomerID, retry+1)
+		attempt.VersionConflicts++
+		<-tools.After(p.clock, time.Duration(retry+1)*10*time.Millisecond)
 	}
 
 	return fmt.Errorf("failed after %d retries", maxRetries)
 }
+
+// waivedInterest is the interest forgiven by a payment that covers the
+// payoff quote as of valueDate: the rest of the outstanding balance. A
+// smaller payment, or one on a written-off account, waives nothing.
+func waivedInterest(customer *api.CustomerAccount, amount float64, valueDate time.Time) float64 {
+	if customer.WrittenOffAt != nil {
+		return 0
+	}
+	quote := schedule.Payoff(customer, valueDate)
+	if amount < quote.PayoffAmount-0.005 {
+		return 0
+	}
+	return math.Max(0, customer.OutstandingBalance-amount)
+}
+
+// unverified reports whether the payment was accepted without checking its
+// customer.
+func unverified(payment *api.PaymentPayload) bool {
+	flagged, _ := payment.Metadata[api.MetadataCustomerUnverified].(bool)
+	return flagged
+}
+
+// verifyCustomer does the customer check skipped at acceptance: a customer
+// merged away is replaced by the survivor, and an unknown one sends the
+// payment to suspense.
+func (p *PaymentProcessor) verifyCustomer(ctx context.Context, payment *api.PaymentPayload, amount float64) error {
+	_, err := p.db.GetCustomer(ctx, payment.CustomerID)
+	if err == nil || p.Suspense == nil || !errors.Is(err, tools.ErrNotFound) {
+		return err
+	}
+
+	survivorID, err := p.Suspense.MergedInto(ctx, payment.CustomerID)
+	if err == nil {
+		payment.CustomerID = survivorID
+		return nil
+	}
+	if !errors.Is(err, tools.ErrNotFound) {
+		return err
+	}
+
+	if err := p.Suspense.SuspendPayment(ctx, payment, amount, "customer not found"); err != nil {
+		return err
+	}
+	p.metrics.Inc("payments_suspended_total", 1)
+	p.log(ctx).Printf("Payment %s sent to suspense: customer %s not found", payment.TransactionReference, payment.CustomerID)
+	return ErrSuspended
+}
+
+// valueDate is the business day the payment counts towards: payments after
+// the cut-off or on a non-business day move to the next business day.
+func (p *PaymentProcessor) valueDate(ctx context.Context, payment *api.PaymentPayload) time.Time {
+	cal := p.Calendar
+	if enabled, ok := p.Flags.Evaluate(ctx, flags.BusinessDayValueDating, payment.CustomerID, payment.Channel); ok && !enabled {
+		cal = nil
+	}
+	if cal == nil {
+		cal = calendar.New(time.UTC, nil, 0)
+	}
+
+	received, err := cal.ParseTime(payment.TransactionDate)
+	if err != nil {
+		received = p.clock.Now()
+	}
+	return cal.ValueDate(received)
+}
@@ -0,0 +1,435 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// memoryStore mirrors the balance arithmetic of DatabaseService in memory.
+// conflictRate is the chance an update loses an optimistic-lock race.
+type memoryStore struct {
+	customers    map[string]*api.CustomerAccount
+	processed    map[string]float64
+	rng          *rand.Rand
+	conflictRate float64
+}
+
+func (s *memoryStore) IsTransactionProcessed(ctx context.Context, txnRef string) (bool, error) {
+	_, ok := s.processed[txnRef]
+	return ok, nil
+}
+
+func (s *memoryStore) GetCustomer(ctx context.Context, customerID string) (*api.CustomerAccount, error) {
+	customer, ok := s.customers[customerID]
+	if !ok {
+		return nil, fmt.Errorf("customer %s: %w", customerID, tools.ErrNotFound)
+	}
+	copied := *customer
+	return &copied, nil
+}
+
+func (s *memoryStore) UpdateCustomerBalance(ctx context.Context, customerID string, amount, waived float64, txnDate string, version int) error {
+	customer := s.customers[customerID]
+	if customer.Version != version {
+		return tools.ErrVersionConflict
+	}
+	if s.rng.Float64() < s.conflictRate {
+		customer.Version++
+		return tools.ErrVersionConflict
+	}
+	customer.TotalPaid += amount
+	customer.OutstandingBalance = math.Max(0, customer.OutstandingBalance-amount-waived)
+	customer.PaymentCount++
+	customer.Version++
+	return nil
+}
+
+func (s *memoryStore) MarkTransactionProcessed(ctx context.Context, payment *api.PaymentPayload, amount float64, valueDate time.Time) error {
+	s.processed[payment.TransactionReference] = amount
+	return nil
+}
+
+func (s *memoryStore) ApplyPaymentToPromises(ctx context.Context, customerID string, amount float64, valueDate time.Time) (int64, error) {
+	return 0, nil
+}
+
+type memoryQueue struct{}
+
+func (memoryQueue) DequeuePayment(ctx context.Context, timeout time.Duration) (*api.PaymentPayload, error) {
+	return nil, nil
+}
+
+func (memoryQueue) MarkDuplicate(ctx context.Context, txnRef string, ttl time.Duration) error {
+	return nil
+}
+
+func (memoryQueue) CacheBalance(ctx context.Context, customerID string, balance float64, ttl time.Duration) error {
+	return nil
+}
+
+func quietLogger() *log.Logger {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// TestPaymentSequenceInvariants applies random payment sequences, with
+// replays, malformed amounts and lost version races, and checks the account
+// arithmetic after every step.
+func TestPaymentSequenceInvariants(t *testing.T) {
+	malformed := []string{"-50.00", "0", "abc", "NaN", "Inf", "1e400", ""}
+
+	for seed := int64(1); seed <= 20; seed++ {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(seed))
+			store := &memoryStore{
+				customers:    map[string]*api.CustomerAccount{},
+				processed:    map[string]float64{},
+				rng:          rng,
+				conflictRate: 0.2,
+			}
+			assetValues := map[string]float64{}
+			for i := 0; i < 3; i++ {
+				id := fmt.Sprintf("GIG%05d", i)
+				value := float64(50000+rng.Intn(500000)) / 100
+				assetValues[id] = value
+				store.customers[id] = &api.CustomerAccount{CustomerID: id, AssetValue: value, OutstandingBalance: value}
+			}
+
+			var results []ApplyResult
+			processor := NewPaymentProcessor(store, memoryQueue{}, 1,
+				WithLogger(quietLogger()),
+				WithMetrics(tools.NewMetrics()),
+				WithHooks(HookFuncs{After: func(ctx context.Context, payment *api.PaymentPayload, result ApplyResult) {
+					results = append(results, result)
+				}}),
+			)
+
+			var references []string
+			for step := 0; step < 60; step++ {
+				customerID := fmt.Sprintf("GIG%05d", rng.Intn(3))
+				payment := &api.PaymentPayload{
+					CustomerID:           customerID,
+					PaymentStatus:        api.StatusComplete,
+					TransactionAmount:    fmt.Sprintf("%d.%02d", rng.Intn(2000), rng.Intn(100)),
+					TransactionDate:      "2025-03-04 10:00:00",
+					TransactionReference: fmt.Sprintf("TXN-%d-%d", seed, step),
+				}
+				switch roll := rng.Intn(10); {
+				case roll == 0 && len(references) > 0:
+					payment.TransactionReference = references[rng.Intn(len(references))]
+				case roll == 1:
+					payment.TransactionAmount = malformed[rng.Intn(len(malformed))]
+				}
+
+				before := *store.customers[customerID]
+				_, wasProcessed := store.processed[payment.TransactionReference]
+				applied := len(results)
+
+				err := processor.processPayment(context.Background(), payment)
+				after := store.customers[customerID]
+
+				if len(results) > applied {
+					if err != nil || wasProcessed {
+						t.Fatalf("step %d: payment applied despite err=%v processed=%v", step, err, wasProcessed)
+					}
+					references = append(references, payment.TransactionReference)
+					if got := results[len(results)-1].NewBalance; math.Abs(got-after.OutstandingBalance) > 1e-6 {
+						t.Fatalf("step %d: reported balance %.2f, stored %.2f", step, got, after.OutstandingBalance)
+					}
+				} else if after.TotalPaid != before.TotalPaid || after.OutstandingBalance != before.OutstandingBalance {
+					t.Fatalf("step %d: unapplied payment (err=%v) changed the account", step, err)
+				}
+
+				for id, customer := range store.customers {
+					if customer.OutstandingBalance < 0 {
+						t.Fatalf("step %d: %s has negative balance %.2f", step, id, customer.OutstandingBalance)
+					}
+					credit := math.Max(0, customer.TotalPaid-assetValues[id])
+					if diff := customer.TotalPaid + customer.OutstandingBalance - (assetValues[id] + credit); math.Abs(diff) > 1e-6 {
+						t.Fatalf("step %d: %s total_paid %.2f + outstanding %.2f != asset %.2f + credit %.2f",
+							step, id, customer.TotalPaid, customer.OutstandingBalance, assetValues[id], credit)
+					}
+				}
+			}
+
+			var recorded float64
+			for _, amount := range store.processed {
+				recorded += amount
+			}
+			var paid float64
+			for _, customer := range store.customers {
+				paid += customer.TotalPaid
+			}
+			if math.Abs(recorded-paid) > 1e-6 {
+				t.Fatalf("processed transactions sum to %.2f, accounts were paid %.2f", recorded, paid)
+			}
+		})
+	}
+}
+
+func TestPayoffPaymentWaivesUnaccruedInterest(t *testing.T) {
+	paidAt := time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC)
+	customer := &api.CustomerAccount{
+		CustomerID:         "GIG00001",
+		AssetValue:         1000,
+		TermWeeks:          10,
+		InterestRate:       0.52,
+		InterestMethod:     "FLAT",
+		DeploymentDate:     paidAt.AddDate(0, 0, -14),
+		OutstandingBalance: 1100,
+	}
+	quote := schedule.Payoff(customer, paidAt)
+	if quote.InterestSaved <= 0 {
+		t.Fatalf("expected the quote to waive interest, got %+v", quote)
+	}
+
+	store := &memoryStore{
+		customers: map[string]*api.CustomerAccount{customer.CustomerID: customer},
+		processed: map[string]float64{},
+		rng:       rand.New(rand.NewSource(1)),
+	}
+	processor := NewPaymentProcessor(store, memoryQueue{}, 1, WithLogger(quietLogger()), WithMetrics(tools.NewMetrics()))
+
+	for i, amount := range []float64{quote.PayoffAmount - 1, 1} {
+		err := processor.processPayment(context.Background(), &api.PaymentPayload{
+			CustomerID:           customer.CustomerID,
+			PaymentStatus:        api.StatusComplete,
+			TransactionAmount:    fmt.Sprintf("%.2f", amount),
+			TransactionDate:      paidAt.Format("2006-01-02 15:04:05"),
+			TransactionReference: fmt.Sprintf("TXN-PAYOFF-%d", i),
+		})
+		if err != nil {
+			t.Fatalf("payment %d: %v", i, err)
+		}
+	}
+
+	if got := store.customers[customer.CustomerID].OutstandingBalance; got != 0 {
+		t.Fatalf("paying the quote left %.2f outstanding", got)
+	}
+}
+
+// lockedStore serializes a memoryStore for concurrent workers.
+type lockedStore struct {
+	mu sync.Mutex
+	*memoryStore
+}
+
+func (s *lockedStore) IsTransactionProcessed(ctx context.Context, txnRef string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memoryStore.IsTransactionProcessed(ctx, txnRef)
+}
+
+func (s *lockedStore) GetCustomer(ctx context.Context, customerID string) (*api.CustomerAccount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memoryStore.GetCustomer(ctx, customerID)
+}
+
+func (s *lockedStore) UpdateCustomerBalance(ctx context.Context, customerID string, amount, waived float64, txnDate string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memoryStore.UpdateCustomerBalance(ctx, customerID, amount, waived, txnDate, version)
+}
+
+func (s *lockedStore) MarkTransactionProcessed(ctx context.Context, payment *api.PaymentPayload, amount float64, valueDate time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memoryStore.MarkTransactionProcessed(ctx, payment, amount, valueDate)
+}
+
+// batchQueue hands out fixed batches, then reports an empty queue.
+type batchQueue struct {
+	mu      sync.Mutex
+	batches [][]*api.PaymentPayload
+}
+
+func (q *batchQueue) DequeuePayments(ctx context.Context, max int, timeout time.Duration) ([]*api.PaymentPayload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.batches) == 0 {
+		return nil, tools.ErrNotFound
+	}
+	batch := q.batches[0]
+	q.batches = q.batches[1:]
+	return batch, nil
+}
+
+// TestBatchDispatchKeepsCustomerOrder checks that payments dequeued in
+// batches are applied to each customer in queue order, however the workers
+// interleave.
+func TestBatchDispatchKeepsCustomerOrder(t *testing.T) {
+	store := &lockedStore{memoryStore: &memoryStore{
+		customers: map[string]*api.CustomerAccount{},
+		processed: map[string]float64{},
+		rng:       rand.New(rand.NewSource(1)),
+	}}
+	queue := &batchQueue{}
+	want := map[string][]string{}
+	var batch []*api.PaymentPayload
+	for i := 0; i < 60; i++ {
+		customerID := fmt.Sprintf("GIG%05d", i%7)
+		store.customers[customerID] = &api.CustomerAccount{CustomerID: customerID, AssetValue: 100000, OutstandingBalance: 100000}
+		payment := &api.PaymentPayload{
+			CustomerID:           customerID,
+			PaymentStatus:        api.StatusComplete,
+			TransactionAmount:    "10.00",
+			TransactionDate:      "2025-03-04 10:00:00",
+			TransactionReference: fmt.Sprintf("TXN-%d", i),
+		}
+		want[customerID] = append(want[customerID], payment.TransactionReference)
+		if batch = append(batch, payment); len(batch) == 8 {
+			queue.batches = append(queue.batches, batch)
+			batch = nil
+		}
+	}
+	queue.batches = append(queue.batches, batch)
+
+	var mu sync.Mutex
+	got := map[string][]string{}
+	processor := NewPaymentProcessor(store, memoryQueue{}, 4,
+		WithLogger(quietLogger()),
+		WithMetrics(tools.NewMetrics()),
+		WithHooks(HookFuncs{After: func(ctx context.Context, payment *api.PaymentPayload, result ApplyResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			got[payment.CustomerID] = append(got[payment.CustomerID], payment.TransactionReference)
+		}}),
+	)
+	processor.Batch = queue
+	processor.BatchSize = 8
+	processor.Start(context.Background())
+
+	applied := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := 0
+		for _, references := range got {
+			n += len(references)
+		}
+		return n
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for applied() < 60 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 60 payments applied in time", applied())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	processor.Stop()
+
+	for customerID, references := range want {
+		if fmt.Sprint(got[customerID]) != fmt.Sprint(references) {
+			t.Errorf("%s: applied %v, queued %v", customerID, got[customerID], references)
+		}
+	}
+}
+
+// outageStore can't reach the database.
+type outageStore struct {
+	memoryStore
+}
+
+func (*outageStore) IsTransactionProcessed(ctx context.Context, txnRef string) (bool, error) {
+	return false, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+}
+
+// outageQueue hands out one payment, then reports an empty queue, and
+// takes requeued payments back.
+type outageQueue struct {
+	memoryQueue
+	mu       sync.Mutex
+	payment  *api.PaymentPayload
+	polls    int
+	requeued []string
+}
+
+func (q *outageQueue) DequeuePayment(ctx context.Context, timeout time.Duration) (*api.PaymentPayload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.polls++
+	payment := q.payment
+	q.payment = nil
+	if payment == nil {
+		return nil, tools.ErrNotFound
+	}
+	return payment, nil
+}
+
+func (q *outageQueue) EnqueuePayment(ctx context.Context, payment *api.PaymentPayload) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.requeued = append(q.requeued, payment.TransactionReference)
+	return nil
+}
+
+func (q *outageQueue) state() (int, []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.polls, append([]string(nil), q.requeued...)
+}
+
+// TestDatabaseUnavailableBacksOff checks that an unverified payment that
+// hits a database outage is requeued, and that its worker then waits five
+// seconds on the processor's clock before polling again.
+func TestDatabaseUnavailableBacksOff(t *testing.T) {
+	clock := tools.NewManualClock(time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC))
+	queue := &outageQueue{payment: &api.PaymentPayload{
+		CustomerID:           "GIG00001",
+		PaymentStatus:        api.StatusComplete,
+		TransactionAmount:    "10.00",
+		TransactionDate:      "2025-03-04 10:00:00",
+		TransactionReference: "TXN-OUTAGE",
+		Metadata:             map[string]interface{}{api.MetadataCustomerUnverified: true},
+	}}
+	processor := NewPaymentProcessor(&outageStore{}, queue, 1,
+		WithLogger(quietLogger()),
+		WithMetrics(tools.NewMetrics()),
+		WithClock(clock),
+	)
+	processor.Requeue = queue
+	processor.Start(context.Background())
+	defer processor.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, requeued := queue.state(); len(requeued) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("payment was not requeued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(4 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if polls, requeued := queue.state(); polls != 1 || fmt.Sprint(requeued) != "[TXN-OUTAGE]" {
+		t.Fatalf("before the back-off ran out: %d polls, requeued %v; want 1 poll, requeued [TXN-OUTAGE]", polls, requeued)
+	}
+
+	for {
+		clock.Advance(time.Second)
+		if polls, _ := queue.state(); polls > 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("worker didn't poll again after the back-off")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,201 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/payouts"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+const maxPayoutAttempts = 3
+
+// payoutRetryBatch caps the payouts one RunRetries sends.
+const payoutRetryBatch = 100
+
+type PayoutProcessor struct {
+	db          *tools.DatabaseService
+	redis       *tools.RedisService
+	providers   map[string]payouts.Provider
+	alerter     tools.Alerter
+	WorkerCount int
+	wg          sync.WaitGroup
+	stopChan    chan struct{}
+	stopOnce    sync.Once
+}
+
+func NewPayoutProcessor(db *tools.DatabaseService, redis *tools.RedisService, providers map[string]payouts.Provider, alerter tools.Alerter, WorkerCount int) *PayoutProcessor {
+	return &PayoutProcessor{
+		db:          db,
+		redis:       redis,
+		providers:   providers,
+		alerter:     alerter,
+		WorkerCount: WorkerCount,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Provider returns the provider with the given name, or nil.
+func (p *PayoutProcessor) Provider(name string) payouts.Provider {
+	for _, provider := range p.providers {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+func (p *PayoutProcessor) Start(ctx context.Context) {
+	log.Printf("Starting %d payout processors", p.WorkerCount)
+
+	for i := 0; i < p.WorkerCount; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx, i)
+	}
+}
+
+func (p *PayoutProcessor) Stop() {
+	log.Println("Stopping payout processors...")
+	p.stopOnce.Do(func() { close(p.stopChan) })
+	p.wg.Wait()
+	log.Println("All payout processors stopped")
+}
+
+// Drain stops the workers claiming new payouts and waits for the ones in
+// progress, up to ctx's deadline.
+func (p *PayoutProcessor) Drain(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stopChan) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Payout processors drained")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("payouts still in flight: %w", ctx.Err())
+	}
+}
+
+func (p *PayoutProcessor) worker(ctx context.Context, workerID int) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		default:
+			reference, err := p.redis.DequeuePayout(ctx, 1*time.Second)
+			if err != nil {
+				if !errors.Is(err, tools.ErrNotFound) {
+					log.Printf("Payout worker %d error: %v", workerID, err)
+				}
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			if reference == "" {
+				continue
+			}
+
+			if err := p.processPayout(ctx, reference); err != nil {
+				log.Printf("Payout worker %d failed %s: %v", workerID, reference, err)
+			}
+		}
+	}
+}
+
+// RunRetries sends the PENDING payouts that are due: failed attempts whose
+// retry delay is over, and payouts that never made it onto the queue.
+// Register it with the scheduler.
+func (p *PayoutProcessor) RunRetries(ctx context.Context) error {
+	references, err := p.db.DuePayouts(ctx, payoutRetryBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, reference := range references {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.processPayout(ctx, reference); err != nil {
+			log.Printf("Payout retry %s failed: %v", reference, err)
+		}
+	}
+	return nil
+}
+
+func (p *PayoutProcessor) processPayout(ctx context.Context, reference string) error {
+	payout, err := p.db.ClaimPayout(ctx, reference)
+	if err != nil {
+		// Already claimed, completed, or unknown.
+		return nil
+	}
+
+	provider, ok := p.providers[payout.Method]
+	if !ok {
+		return p.finish(ctx, payout, api.PayoutFailed, "", "", fmt.Sprintf("no provider for method %s", payout.Method))
+	}
+
+	result, err := provider.Send(ctx, payout)
+	if err != nil {
+		if payout.Attempts < maxPayoutAttempts {
+			// RunRetries sends it again once it is due.
+			delay := time.Duration(payout.Attempts) * 30 * time.Second
+			if retryErr := p.db.RetryPayout(ctx, reference, provider.Name(), err.Error(), delay); retryErr != nil {
+				log.Printf("Failed to schedule retry of payout %s: %v", reference, retryErr)
+			}
+			return err
+		}
+		return p.finish(ctx, payout, api.PayoutFailed, provider.Name(), "", err.Error())
+	}
+
+	if result.Pending {
+		_, err := p.db.UpdatePayoutStatus(ctx, reference, api.PayoutProcessing, provider.Name(), result.ProviderReference, "")
+		return err
+	}
+	return p.finish(ctx, payout, api.PayoutSucceeded, provider.Name(), result.ProviderReference, "")
+}
+
+func (p *PayoutProcessor) finish(ctx context.Context, payout *api.Payout, status api.PayoutStatus, provider, providerReference, reason string) error {
+	updated, err := p.db.UpdatePayoutStatus(ctx, payout.Reference, status, provider, providerReference, reason)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Payout %s %s (%.2f %s)", updated.Reference, updated.Status, updated.Amount, updated.Currency)
+	NotifyPayoutStatus(ctx, p.alerter, updated)
+	return nil
+}
+
+func NotifyPayoutStatus(ctx context.Context, alerter tools.Alerter, payout *api.Payout) {
+	if alerter == nil {
+		return
+	}
+
+	severity := "info"
+	if payout.Status == api.PayoutFailed {
+		severity = "warning"
+	}
+
+	err := alerter.Send(ctx, tools.Alert{
+		Type:     "payout." + string(payout.Status),
+		Severity: severity,
+		Message:  fmt.Sprintf("Payout %s is %s", payout.Reference, payout.Status),
+		Details: map[string]interface{}{
+			"payout": payout,
+		},
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Warning: failed to send payout webhook for %s: %v", payout.Reference, err)
+	}
+}
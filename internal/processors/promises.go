@@ -0,0 +1,21 @@
+package processors
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+func NewPromiseExpiryJob(db *tools.DatabaseService) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		broken, err := db.ExpirePromises(ctx)
+		if err != nil {
+			return err
+		}
+		if broken > 0 {
+			log.Printf("Marked %d promises to pay as broken", broken)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package processors
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// RunRetention applies every policy and records purged (or, in dry-run
+// mode, eligible) row counts as metrics.
+func RunRetention(ctx context.Context, db *tools.DatabaseService, storage tools.BlobStore, policies []tools.RetentionPolicy, dryRun bool) ([]*tools.RetentionResult, error) {
+	results := []*tools.RetentionResult{}
+	for _, policy := range policies {
+		result, err := db.ApplyRetention(ctx, policy, storage, dryRun)
+		if result != nil {
+			results = append(results, result)
+			if dryRun {
+				tools.DefaultMetrics.Set("retention_rows_eligible", float64(result.Rows), "table", policy.Table)
+			} else {
+				tools.DefaultMetrics.Inc("retention_rows_purged_total", float64(result.Rows), "table", policy.Table)
+			}
+		}
+		if err != nil {
+			return results, err
+		}
+
+		if result.Rows > 0 {
+			action := "Purged"
+			if dryRun {
+				action = "Dry run: would purge"
+			}
+			log.Printf("%s %d rows from %s older than %s", action, result.Rows, policy.Table, result.Cutoff.Format("2006-01-02"))
+		}
+	}
+	return results, nil
+}
+
+func NewRetentionJob(db *tools.DatabaseService, storage tools.BlobStore, policies []tools.RetentionPolicy, dryRun bool) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := RunRetention(ctx, db, storage, policies, dryRun)
+		return err
+	}
+}
@@ -0,0 +1,61 @@
+package processors
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+const riskScoringBatchSize = 500
+
+// NewRiskScoringJob re-scores every open account and stores its risk score
+// and projected payoff date. A failure on one account is logged and skipped
+// so it doesn't hold up the rest of the portfolio.
+func NewRiskScoringJob(db *tools.DatabaseService) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := db.Now()
+		scored, failed := 0, 0
+		afterID := ""
+
+		for {
+			accounts, err := db.ListOpenAccounts(ctx, afterID, riskScoringBatchSize)
+			if err != nil {
+				return err
+			}
+			for _, customer := range accounts {
+				if err := scoreAccount(ctx, db, customer, now); err != nil {
+					log.Warnf("Risk scoring failed for %s: %v", customer.CustomerID, err)
+					failed++
+					continue
+				}
+				scored++
+			}
+			if len(accounts) < riskScoringBatchSize {
+				break
+			}
+			afterID = accounts[len(accounts)-1].CustomerID
+		}
+
+		log.Printf("Risk scored %d accounts (%d failed)", scored, failed)
+		return nil
+	}
+}
+
+func scoreAccount(ctx context.Context, db *tools.DatabaseService, customer *api.CustomerAccount, now time.Time) error {
+	payments, err := db.GetPaymentEvents(ctx, customer.CustomerID)
+	if err != nil {
+		return err
+	}
+	cal, err := db.CustomerCalendar(ctx, customer)
+	if err != nil {
+		return err
+	}
+
+	assessment := schedule.AssessRisk(customer, payments, now, cal)
+	return db.SaveRiskScore(ctx, customer.CustomerID, assessment.Score, assessment.ProjectedPayoffDate, now)
+}
@@ -0,0 +1,181 @@
+package processors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// reportLinkExpiry is how long emailed download links for stored reports
+// stay valid.
+const reportLinkExpiry = 7 * 24 * time.Hour
+
+// ReportDeliverer sends saved report results to the report's delivery
+// targets. Storage and Notifier may be nil, in which case reports that need
+// them fail delivery.
+type ReportDeliverer struct {
+	Storage  tools.BlobStore
+	Notifier *notifications.Notifier
+	client   *http.Client
+}
+
+func NewReportDeliverer(storage tools.BlobStore, notifier *notifications.Notifier) *ReportDeliverer {
+	return &ReportDeliverer{
+		Storage:  storage,
+		Notifier: notifier,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Deliver sends the result to every target. It tries them all and returns
+// the errors joined, so one broken webhook doesn't stop the email.
+func (d *ReportDeliverer) Deliver(ctx context.Context, report *api.SavedReport, result *api.ReportResult) error {
+	var errs []error
+
+	var downloadURL string
+	if report.Delivery.Storage {
+		url, err := d.store(ctx, result)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("storage: %w", err))
+		}
+		downloadURL = url
+	}
+
+	if report.Delivery.WebhookURL != "" {
+		if err := d.postWebhook(ctx, report.Delivery.WebhookURL, result); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+
+	if report.Delivery.Email != "" {
+		if err := d.email(ctx, report, result, downloadURL); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d *ReportDeliverer) store(ctx context.Context, result *api.ReportResult) (string, error) {
+	if d.Storage == nil {
+		return "", errors.New("blob storage is not configured")
+	}
+
+	var buf bytes.Buffer
+	if err := tools.WriteReportCSV(&buf, result); err != nil {
+		return "", err
+	}
+
+	key := tools.SavedReportBlobKey(result.ReportID, result.GeneratedAt)
+	if err := d.Storage.Put(ctx, key, &buf, "text/csv"); err != nil {
+		return "", err
+	}
+	return d.Storage.PresignGet(key, reportLinkExpiry)
+}
+
+func (d *ReportDeliverer) postWebhook(ctx context.Context, url string, result *api.ReportResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("report webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// email sends a download link when the report was stored, otherwise the
+// CSV itself in the message body. Report emails go to staff, not
+// customers, so they are sent as essential and skip the consent check.
+func (d *ReportDeliverer) email(ctx context.Context, report *api.SavedReport, result *api.ReportResult, downloadURL string) error {
+	if d.Notifier == nil {
+		return errors.New("notifier is not configured")
+	}
+
+	message := fmt.Sprintf("Report %s (%s) generated %s: %d rows.", report.Name, report.ReportID, result.GeneratedAt.UTC().Format(time.RFC3339), len(result.Rows))
+	if downloadURL != "" {
+		message += "\nDownload: " + downloadURL
+	} else {
+		var buf bytes.Buffer
+		if err := tools.WriteReportCSV(&buf, result); err != nil {
+			return err
+		}
+		message += "\n\n" + buf.String()
+	}
+
+	return d.Notifier.Send(ctx, notifications.Notification{
+		Channel:   notifications.ChannelEmail,
+		Recipient: report.Delivery.Email,
+		Message:   message,
+		Essential: true,
+	})
+}
+
+// RunSavedReport runs the report, delivers it when deliver is set, and
+// records the outcome. Scheduled reports are moved on to their next run
+// even when delivery fails, so a broken target doesn't retry every tick.
+func RunSavedReport(ctx context.Context, db *tools.DatabaseService, deliverer *ReportDeliverer, report *api.SavedReport, deliver bool) (*api.ReportResult, error) {
+	now := db.Now()
+
+	result, err := db.RunSavedReport(ctx, report)
+	if err == nil && deliver {
+		err = deliverer.Deliver(ctx, report, result)
+	}
+
+	status := "OK"
+	if err != nil {
+		status = "FAILED: " + err.Error()
+		tools.DefaultMetrics.Inc("saved_report_failures_total", 1, "report", report.ReportID)
+	}
+
+	var nextRun *time.Time
+	if deliver {
+		nextRun = tools.NextReportRun(report.Schedule, now)
+	}
+	if recordErr := db.RecordReportRun(ctx, report.ReportID, status, now, nextRun); recordErr != nil {
+		log.Warnf("Failed to record run of report %s: %v", report.ReportID, recordErr)
+	}
+	return result, err
+}
+
+// NewSavedReportJob runs and delivers every scheduled report that is due.
+func NewSavedReportJob(db *tools.DatabaseService, deliverer *ReportDeliverer) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		due, err := db.ListDueReports(ctx, db.Now())
+		if err != nil {
+			return err
+		}
+
+		for _, report := range due {
+			result, err := RunSavedReport(ctx, db, deliverer, report, true)
+			if err != nil {
+				log.Warnf("Saved report %s failed: %v", report.ReportID, err)
+				continue
+			}
+			log.Printf("Saved report %s delivered with %d rows", report.ReportID, len(result.Rows))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package processors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+type Scheduler struct {
+	jobs     []Job
+	wg       sync.WaitGroup
+	stopChan chan struct{}
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (s *Scheduler) Register(name string, interval time.Duration, run func(ctx context.Context) error) {
+	if interval <= 0 {
+		log.Printf("Job %s disabled (interval %s)", name, interval)
+		return
+	}
+	s.jobs = append(s.jobs, Job{Name: name, Interval: interval, Run: run})
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	log.Printf("Starting scheduler with %d jobs", len(s.jobs))
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.runJob(ctx, job)
+	}
+}
+
+func (s *Scheduler) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+	log.Println("Scheduler stopped")
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job Job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if err := job.Run(ctx); err != nil {
+			log.Printf("Job %s failed: %v", job.Name, err)
+		} else {
+			log.Printf("Job %s completed in %s", job.Name, time.Since(start))
+		}
+
+		select {
+		case <-s.stopChan:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package processors
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+const slaLatencyMetric = "payment_processing_latency_seconds"
+
+type SLATracker struct {
+	Target        time.Duration
+	BreachLimit   int
+	Window        time.Duration
+	Clock         tools.Clock
+	alerter       tools.Alerter
+	mu            sync.Mutex
+	breaches      []time.Time
+	totalBreaches int64
+	lastAlertAt   time.Time
+}
+
+type SLAStats struct {
+	TargetSeconds  float64 `json:"target_seconds"`
+	P50Seconds     float64 `json:"p50_seconds"`
+	P95Seconds     float64 `json:"p95_seconds"`
+	P99Seconds     float64 `json:"p99_seconds"`
+	RecentBreaches int     `json:"recent_breaches"`
+	TotalBreaches  int64   `json:"total_breaches"`
+}
+
+func NewSLATracker(target time.Duration, breachLimit int, window time.Duration, alerter tools.Alerter) *SLATracker {
+	return &SLATracker{
+		Target:      target,
+		BreachLimit: breachLimit,
+		Window:      window,
+		Clock:       tools.SystemClock{},
+		alerter:     alerter,
+	}
+}
+
+func (t *SLATracker) Record(reference string, latency time.Duration) {
+	tools.DefaultMetrics.Observe(slaLatencyMetric, latency.Seconds())
+
+	if t.Target <= 0 || latency <= t.Target {
+		return
+	}
+
+	tools.DefaultMetrics.Inc("payment_sla_breaches_total", 1)
+	log.Printf("SLA breach: %s took %s (target %s)", reference, latency, t.Target)
+
+	now := t.Clock.Now()
+	t.mu.Lock()
+	t.totalBreaches++
+	t.breaches = append(t.pruneBreaches(now), now)
+	recent := len(t.breaches)
+	shouldAlert := t.alerter != nil && recent >= t.BreachLimit && now.Sub(t.lastAlertAt) >= t.Window
+	if shouldAlert {
+		t.lastAlertAt = now
+	}
+	t.mu.Unlock()
+
+	if shouldAlert {
+		go t.sendAlert(recent)
+	}
+}
+
+func (t *SLATracker) Stats() SLAStats {
+	q := tools.DefaultMetrics.Quantiles(slaLatencyMetric, 0.5, 0.95, 0.99)
+
+	t.mu.Lock()
+	t.breaches = t.pruneBreaches(t.Clock.Now())
+	stats := SLAStats{
+		TargetSeconds:  t.Target.Seconds(),
+		P50Seconds:     q[0],
+		P95Seconds:     q[1],
+		P99Seconds:     q[2],
+		RecentBreaches: len(t.breaches),
+		TotalBreaches:  t.totalBreaches,
+	}
+	t.mu.Unlock()
+
+	return stats
+}
+
+func (t *SLATracker) pruneBreaches(now time.Time) []time.Time {
+	cutoff := now.Add(-t.Window)
+	kept := t.breaches[:0]
+	for _, at := range t.breaches {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	return kept
+}
+
+func (t *SLATracker) sendAlert(breaches int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alert := tools.Alert{
+		Type:     "sla_breach",
+		Severity: "warning",
+		Message:  fmt.Sprintf("%d payments exceeded the %s processing SLA in the last %s", breaches, t.Target, t.Window),
+		Details: map[string]interface{}{
+			"breaches":       breaches,
+			"target_seconds": t.Target.Seconds(),
+			"window_seconds": t.Window.Seconds(),
+		},
+		Timestamp: t.Clock.Now(),
+	}
+
+	if err := t.alerter.Send(ctx, alert); err != nil {
+		log.Printf("Warning: failed to send SLA alert: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package processors
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+func NewSnapshotJob(db *tools.DatabaseService, storage tools.BlobStore) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		today := db.Now().UTC().Truncate(24 * time.Hour)
+
+		created, err := db.CreatePortfolioSnapshot(ctx, today)
+		if err != nil {
+			return err
+		}
+
+		if created == 0 {
+			return nil
+		}
+		log.Printf("Portfolio snapshot for %s captured %d accounts", today.Format("2006-01-02"), created)
+
+		if storage == nil {
+			return nil
+		}
+
+		snapshots, err := db.GetPortfolioSnapshot(ctx, today)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := tools.WriteSnapshotsCSV(&buf, snapshots); err != nil {
+			return err
+		}
+		return storage.Put(ctx, tools.SnapshotBlobKey(today), &buf, "text/csv")
+	}
+}
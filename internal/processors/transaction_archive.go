@@ -0,0 +1,26 @@
+package processors
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewTransactionArchiveJob moves processed transactions from months that
+// ended more than afterMonths ago to the blob store as Parquet and deletes
+// them from processed_transactions.
+func NewTransactionArchiveJob(db *tools.DatabaseService, storage tools.BlobStore, afterMonths int) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := db.Now()
+		cutoff := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -afterMonths, 0)
+
+		archives, err := db.ArchiveTransactions(ctx, storage, cutoff)
+		for _, archive := range archives {
+			log.Printf("Archived %d transactions from %s to %s", archive.Rows, archive.Month, archive.ObjectKey)
+			tools.DefaultMetrics.Inc("transactions_archived_total", float64(archive.Rows))
+		}
+		return err
+	}
+}
@@ -0,0 +1,111 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNoPhone is returned when calling a customer without a phone number.
+var ErrNoPhone = errors.New("customer has no phone number")
+
+type VoiceReminderOptions struct {
+	// Template is the voice script, a message template rendered with
+	// customer_id, arrears, days_past_due and outstanding_balance.
+	Template string
+	// MinDaysPastDue is how far behind an account must be to be called.
+	MinDaysPastDue int
+	// RecallDays is how long to wait before calling an account again.
+	RecallDays int
+	// BatchSize caps the calls placed per run.
+	BatchSize int
+}
+
+// VoiceReminders calls customers about overdue payments through the
+// notifier's voice provider, so calls get the same consent and quiet-hours
+// checks as SMS. Calls are recorded in voice_calls, where provider
+// callbacks fill in the outcome the collections worklist ranks by.
+type VoiceReminders struct {
+	db       *tools.DatabaseService
+	notifier *notifications.Notifier
+	Options  VoiceReminderOptions
+}
+
+func NewVoiceReminders(db *tools.DatabaseService, notifier *notifications.Notifier, opts VoiceReminderOptions) *VoiceReminders {
+	return &VoiceReminders{db: db, notifier: notifier, Options: opts}
+}
+
+// Call places a reminder call about the account. A call that can't be
+// placed, e.g. for want of a phone number or consent, is recorded as FAILED
+// and returned with the error, so the account isn't retried before
+// RecallDays.
+func (v *VoiceReminders) Call(ctx context.Context, target tools.VoiceReminderTarget, requestedBy string) (*api.VoiceCall, error) {
+	call, err := v.db.CreateVoiceCall(ctx, target.CustomerID, v.Options.Template, requestedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	contact, err := v.db.GetContactProfile(ctx, target.CustomerID)
+	if err == nil && contact.Phone == "" {
+		err = ErrNoPhone
+	}
+	if err == nil {
+		err = v.notifier.Send(ctx, notifications.Notification{
+			Channel:    notifications.ChannelVoice,
+			Recipient:  contact.Phone,
+			CustomerID: target.CustomerID,
+			Template:   v.Options.Template,
+			Variables: map[string]string{
+				"customer_id":         target.CustomerID,
+				"arrears":             fmt.Sprintf("%.2f", target.Arrears),
+				"days_past_due":       fmt.Sprintf("%d", target.DaysPastDue),
+				"outstanding_balance": fmt.Sprintf("%.2f", target.OutstandingBalance),
+			},
+			Reference: call.Reference,
+		})
+	}
+	if err != nil {
+		if ended, endErr := v.db.EndVoiceCall(ctx, call.Reference, "", api.VoiceCallFailed, 0, err.Error()); endErr == nil {
+			call = ended
+		}
+		tools.DefaultMetrics.Inc("voice_calls_total", 1, "status", string(api.VoiceCallFailed))
+		return call, err
+	}
+
+	tools.DefaultMetrics.Inc("voice_calls_total", 1, "status", string(api.VoiceCallPlaced))
+	return v.db.FindVoiceCall(ctx, call.Reference, "")
+}
+
+// Run calls the most overdue accounts that haven't been called recently.
+// Register it with the scheduler.
+func (v *VoiceReminders) Run(ctx context.Context) error {
+	targets, err := v.db.VoiceReminderTargets(ctx, "", v.Options.MinDaysPastDue, v.Options.RecallDays, v.Options.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	placed, failed := 0, 0
+	for _, target := range targets {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := v.Call(ctx, target, "scheduler"); err != nil {
+			if !errors.Is(err, ErrNoPhone) && !errors.Is(err, notifications.ErrNoConsent) {
+				log.Warnf("Failed to place reminder call to %s: %v", target.CustomerID, err)
+			}
+			failed++
+			continue
+		}
+		placed++
+	}
+
+	if len(targets) > 0 {
+		log.Printf("Voice reminders: %d calls placed, %d not placed", placed, failed)
+	}
+	return nil
+}
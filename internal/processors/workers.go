@@ -0,0 +1,91 @@
+package processors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Worker states reported by Workers.
+const (
+	WorkerIdle       = "idle"
+	WorkerProcessing = "processing"
+	WorkerStopped    = "stopped"
+)
+
+// WorkerStatus is what one worker is doing: idle, processing a payment, or
+// stopped, since Since. Worker names it across instances; see
+// tools.WorkerName.
+type WorkerStatus struct {
+	Worker               string    `json:"worker"`
+	Index                int       `json:"index"`
+	State                string    `json:"state"`
+	TransactionReference string    `json:"transaction_reference,omitempty"`
+	CustomerID           string    `json:"customer_id,omitempty"`
+	Since                time.Time `json:"since"`
+	Processed            int64     `json:"processed"`
+}
+
+// workerState tracks one worker for Workers.
+type workerState struct {
+	mu     sync.Mutex
+	status WorkerStatus
+}
+
+func (w *workerState) set(state string, payment *api.PaymentPayload, at time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.State, w.status.Since = state, at
+	w.status.TransactionReference, w.status.CustomerID = "", ""
+	if payment != nil {
+		w.status.TransactionReference, w.status.CustomerID = payment.TransactionReference, payment.CustomerID
+	}
+}
+
+// Workers reports what each worker is doing. It is empty before Start.
+func (p *PaymentProcessor) Workers() []WorkerStatus {
+	p.processedMu.Lock()
+	workers, processed := p.workers, p.processed
+	p.processedMu.Unlock()
+
+	statuses := make([]WorkerStatus, len(workers))
+	for i, worker := range workers {
+		worker.mu.Lock()
+		statuses[i] = worker.status
+		worker.mu.Unlock()
+		statuses[i].Processed = processed[i].Load()
+	}
+	return statuses
+}
+
+func (p *PaymentProcessor) workerState(workerID int) *workerState {
+	p.processedMu.Lock()
+	defer p.processedMu.Unlock()
+	if workerID < len(p.workers) {
+		return p.workers[workerID]
+	}
+	return nil
+}
+
+type loggerKey struct{}
+
+// workerLogger is the processor's logger with the worker's identity.
+func (p *PaymentProcessor) workerLogger(workerID int) log.FieldLogger {
+	return p.logger.WithFields(log.Fields{
+		"instance": p.Instance,
+		"worker":   tools.WorkerName(p.Instance, workerID),
+	})
+}
+
+// log is the logger for work done under ctx: a worker's own while it
+// processes a payment, else the processor's.
+func (p *PaymentProcessor) log(ctx context.Context) log.FieldLogger {
+	if logger, ok := ctx.Value(loggerKey{}).(log.FieldLogger); ok {
+		return logger
+	}
+	return p.logger
+}
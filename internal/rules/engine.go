@@ -0,0 +1,383 @@
+// Package rules evaluates the admin-configured payment rules: conditions on
+// a payment's amount, channel and account that hold the payment, tag its
+// priority, apply it to another account or alert operations.
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrHeld marks a payment held for review by a HOLD rule.
+var ErrHeld = errors.New("payment held by rule")
+
+// Store is the persistence the engine needs. *tools.DatabaseService
+// implements it.
+type Store interface {
+	LoadPaymentRules(ctx context.Context) ([]*api.PaymentRule, error)
+	GetCustomer(ctx context.Context, customerID string) (*api.CustomerAccount, error)
+	HoldPaymentForRule(ctx context.Context, payment *api.PaymentPayload, rule *api.PaymentRule) (*api.ScreeningHold, error)
+	GetRuleHold(ctx context.Context, reference, rule string) (*api.ScreeningHold, error)
+}
+
+var numericFields = map[string]bool{
+	"amount":              true,
+	"outstanding_balance": true,
+	"risk_score":          true,
+}
+
+var accountFields = map[string]bool{
+	"region":              true,
+	"branch":              true,
+	"product_id":          true,
+	"asset_type":          true,
+	"outstanding_balance": true,
+	"risk_score":          true,
+}
+
+// Engine applies the rules to every payment before it is applied. It is a
+// payment processor hook; add it with PaymentProcessor.Use. Rules are
+// reloaded at most every refresh interval, or on the next payment after
+// Invalidate.
+type Engine struct {
+	processors.HookFuncs
+	store   Store
+	refresh time.Duration
+	// Alerter receives the alerts of NOTIFY rules; without one they are
+	// only logged.
+	Alerter tools.Alerter
+
+	mu       sync.Mutex
+	rules    []*api.PaymentRule
+	loadedAt time.Time
+}
+
+func NewEngine(store Store, refresh time.Duration) *Engine {
+	e := &Engine{store: store, refresh: refresh}
+	e.Before = e.beforeApply
+	return e
+}
+
+// Invalidate makes the next evaluation reload the rules.
+func (e *Engine) Invalidate() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.loadedAt = time.Time{}
+	e.mu.Unlock()
+}
+
+// Validate checks what the request binding can't: that numeric operators
+// are used on numeric fields and their values are numbers.
+func Validate(rule *api.PaymentRule) error {
+	for i, condition := range rule.Conditions {
+		switch condition.Operator {
+		case "gt", "gte", "lt", "lte":
+			if !numericFields[condition.Field] {
+				return fmt.Errorf("conditions[%d]: %s only applies to amount, outstanding_balance and risk_score", i, condition.Operator)
+			}
+		}
+		if !numericFields[condition.Field] {
+			continue
+		}
+		for _, value := range values(condition) {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("conditions[%d]: %s must be compared with a number", i, condition.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the loaded rules the payment matches, in evaluation
+// order.
+func (e *Engine) Evaluate(ctx context.Context, payment *api.PaymentPayload) ([]*api.PaymentRule, error) {
+	rules := e.load(ctx)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	var account *api.CustomerAccount
+	if needsAccount(rules) {
+		var err error
+		account, err = e.store.GetCustomer(ctx, payment.CustomerID)
+		if err != nil && !errors.Is(err, tools.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return Match(rules, payment, account), nil
+}
+
+// Match returns the rules the payment matches. account may be nil, in which
+// case conditions on account fields don't hold.
+func Match(rules []*api.PaymentRule, payment *api.PaymentPayload, account *api.CustomerAccount) []*api.PaymentRule {
+	matched := []*api.PaymentRule{}
+	for _, rule := range rules {
+		if matches(rule, payment, account) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+func (e *Engine) beforeApply(ctx context.Context, payment *api.PaymentPayload) error {
+	matched, err := e.Evaluate(ctx, payment)
+	if err != nil {
+		return fmt.Errorf("rules evaluation failed: %w", err)
+	}
+
+	allocated := false
+	for _, rule := range matched {
+		tools.DefaultMetrics.Inc("payment_rule_matches_total", 1, "action", rule.Action)
+
+		switch rule.Action {
+		case api.RuleHold:
+			hold, err := e.hold(ctx, payment, rule)
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+			if hold != nil {
+				return fmt.Errorf("%w %s: hold %d is %s", ErrHeld, rule.Name, hold.ID, hold.Status)
+			}
+		case api.RulePriority:
+			setMetadata(payment, "priority", rule.Priority)
+			setMetadata(payment, "priority_rule", rule.Name)
+		case api.RuleAllocate:
+			// The first matching allocation wins.
+			if allocated || rule.TargetCustomerID == payment.CustomerID {
+				continue
+			}
+			allocated = true
+			setMetadata(payment, "allocated_from", payment.CustomerID)
+			setMetadata(payment, "allocation_rule", rule.Name)
+			log.Printf("Payment %s allocated from %s to %s by rule %s", payment.TransactionReference, payment.CustomerID, rule.TargetCustomerID, rule.Name)
+			payment.CustomerID = rule.TargetCustomerID
+		case api.RuleNotify:
+			log.Printf("Payment %s for %s matched rule %s", payment.TransactionReference, payment.CustomerID, rule.Name)
+			if e.Alerter != nil {
+				// The webhook can be slow; don't hold up the payment worker.
+				go e.notify(context.WithoutCancel(ctx), payment, rule)
+			}
+		}
+	}
+	return nil
+}
+
+// hold holds the payment under the rule and returns the hold, or nil if the
+// payment was already held by this rule and released and may go through.
+// Releasing a screening hold or another rule's hold doesn't release it.
+func (e *Engine) hold(ctx context.Context, payment *api.PaymentPayload, rule *api.PaymentRule) (*api.ScreeningHold, error) {
+	hold, err := e.store.GetRuleHold(ctx, payment.TransactionReference, rule.Name)
+	switch {
+	case err == nil && hold.Status == api.HoldStatusReleased:
+		return nil, nil
+	case err == nil:
+		return hold, nil
+	case !errors.Is(err, tools.ErrNotFound):
+		return nil, err
+	}
+
+	hold, err = e.store.HoldPaymentForRule(ctx, payment, rule)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Payment %s for %s held by rule %s", payment.TransactionReference, payment.CustomerID, rule.Name)
+	return hold, nil
+}
+
+func (e *Engine) notify(ctx context.Context, payment *api.PaymentPayload, rule *api.PaymentRule) {
+	message := rule.Message
+	if message == "" {
+		message = fmt.Sprintf("Payment %s matched rule %s", payment.TransactionReference, rule.Name)
+	}
+	err := e.Alerter.Send(ctx, tools.Alert{
+		Type:     "payment_rule",
+		Severity: "info",
+		Message:  message,
+		Details: map[string]interface{}{
+			"rule":                  rule.Name,
+			"customer_id":           payment.CustomerID,
+			"transaction_reference": payment.TransactionReference,
+			"amount":                payment.TransactionAmount,
+			"channel":               payment.Channel,
+		},
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		log.Warnf("Failed to send alert for rule %s: %v", rule.Name, err)
+	}
+}
+
+func (e *Engine) load(ctx context.Context) []*api.PaymentRule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if time.Since(e.loadedAt) < e.refresh {
+		return e.rules
+	}
+
+	// Keep applying the last rules if the database is unavailable; retry
+	// after the next refresh interval.
+	e.loadedAt = time.Now()
+	rules, err := e.store.LoadPaymentRules(ctx)
+	if err != nil {
+		log.Printf("Warning: failed to load payment rules: %v", err)
+		return e.rules
+	}
+	e.rules = rules
+	return rules
+}
+
+func needsAccount(rules []*api.PaymentRule) bool {
+	for _, rule := range rules {
+		for _, condition := range rule.Conditions {
+			if accountFields[condition.Field] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matches(rule *api.PaymentRule, payment *api.PaymentPayload, account *api.CustomerAccount) bool {
+	for _, condition := range rule.Conditions {
+		actual, ok := field(condition.Field, payment, account)
+		if !ok || !compare(condition, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// field returns the payment's or account's value for a condition field, or
+// false if it has none.
+func field(name string, payment *api.PaymentPayload, account *api.CustomerAccount) (string, bool) {
+	switch name {
+	case "amount":
+		return payment.TransactionAmount, true
+	case "channel":
+		return payment.Channel, true
+	case "currency":
+		return payment.Currency, true
+	case "customer_id":
+		return payment.CustomerID, true
+	case "reference":
+		return payment.TransactionReference, true
+	case "agent_id":
+		return payment.AgentID, true
+	}
+
+	if account == nil {
+		return "", false
+	}
+	switch name {
+	case "region":
+		return account.Region, true
+	case "branch":
+		return account.Branch, true
+	case "product_id":
+		return account.ProductID, true
+	case "asset_type":
+		return account.AssetType, true
+	case "outstanding_balance":
+		return strconv.FormatFloat(account.OutstandingBalance, 'f', -1, 64), true
+	case "risk_score":
+		if account.RiskScore == nil {
+			return "", false
+		}
+		return strconv.Itoa(*account.RiskScore), true
+	}
+	return "", false
+}
+
+func compare(condition api.RuleCondition, actual string) bool {
+	if numericFields[condition.Field] {
+		return compareNumbers(condition, actual)
+	}
+
+	switch condition.Operator {
+	case "eq":
+		return strings.EqualFold(actual, condition.Value)
+	case "ne":
+		return !strings.EqualFold(actual, condition.Value)
+	case "in", "not_in":
+		found := false
+		for _, value := range values(condition) {
+			if strings.EqualFold(actual, value) {
+				found = true
+				break
+			}
+		}
+		return found == (condition.Operator == "in")
+	}
+	return false
+}
+
+func compareNumbers(condition api.RuleCondition, actual string) bool {
+	number, err := strconv.ParseFloat(actual, 64)
+	if err != nil {
+		return false
+	}
+
+	if condition.Operator == "in" || condition.Operator == "not_in" {
+		found := false
+		for _, value := range values(condition) {
+			if v, err := strconv.ParseFloat(value, 64); err == nil && v == number {
+				found = true
+				break
+			}
+		}
+		return found == (condition.Operator == "in")
+	}
+
+	value, err := strconv.ParseFloat(condition.Value, 64)
+	if err != nil {
+		return false
+	}
+	switch condition.Operator {
+	case "eq":
+		return number == value
+	case "ne":
+		return number != value
+	case "gt":
+		return number > value
+	case "gte":
+		return number >= value
+	case "lt":
+		return number < value
+	case "lte":
+		return number <= value
+	}
+	return false
+}
+
+func values(condition api.RuleCondition) []string {
+	if condition.Operator != "in" && condition.Operator != "not_in" {
+		return []string{condition.Value}
+	}
+	var list []string
+	for _, value := range strings.Split(condition.Value, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func setMetadata(payment *api.PaymentPayload, key string, value interface{}) {
+	if payment.Metadata == nil {
+		payment.Metadata = api.Metadata{}
+	}
+	payment.Metadata[key] = value
+}
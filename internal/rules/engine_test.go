@@ -0,0 +1,88 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+)
+
+// holdStore keeps holds keyed like screening_holds: by reference, entry
+// type and matched value.
+type holdStore struct {
+	rules []*api.PaymentRule
+	holds map[[3]string]*api.ScreeningHold
+}
+
+func (s *holdStore) LoadPaymentRules(ctx context.Context) ([]*api.PaymentRule, error) {
+	return s.rules, nil
+}
+
+func (s *holdStore) GetCustomer(ctx context.Context, customerID string) (*api.CustomerAccount, error) {
+	return nil, tools.ErrNotFound
+}
+
+func (s *holdStore) HoldPaymentForRule(ctx context.Context, payment *api.PaymentPayload, rule *api.PaymentRule) (*api.ScreeningHold, error) {
+	key := [3]string{payment.TransactionReference, api.ScreeningRule, rule.Name}
+	if hold, ok := s.holds[key]; ok {
+		return hold, nil
+	}
+	hold := &api.ScreeningHold{
+		ID:                   int64(len(s.holds) + 1),
+		TransactionReference: payment.TransactionReference,
+		EntryType:            api.ScreeningRule,
+		MatchedValue:         rule.Name,
+		Status:               api.HoldStatusHeld,
+	}
+	s.holds[key] = hold
+	return hold, nil
+}
+
+func (s *holdStore) GetRuleHold(ctx context.Context, reference, rule string) (*api.ScreeningHold, error) {
+	if hold, ok := s.holds[[3]string{reference, api.ScreeningRule, rule}]; ok {
+		return hold, nil
+	}
+	return nil, tools.ErrNotFound
+}
+
+// TestHoldReleasedOnlyByItsOwnHold checks that releasing a screening hold,
+// or one rule's hold, doesn't let the payment past other HOLD rules.
+func TestHoldReleasedOnlyByItsOwnHold(t *testing.T) {
+	ctx := context.Background()
+	rule := func(name string) *api.PaymentRule {
+		return &api.PaymentRule{
+			Name:       name,
+			Enabled:    true,
+			Action:     api.RuleHold,
+			Conditions: []api.RuleCondition{{Field: "channel", Operator: "eq", Value: "ussd"}},
+		}
+	}
+	store := &holdStore{
+		rules: []*api.PaymentRule{rule("large-ussd"), rule("ussd-review")},
+		holds: map[[3]string]*api.ScreeningHold{
+			{"TX1", api.ScreeningPhone, "08030000000"}: {ID: 99, Status: api.HoldStatusReleased},
+		},
+	}
+	engine := NewEngine(store, time.Minute)
+	payment := &api.PaymentPayload{TransactionReference: "TX1", CustomerID: "GIG00001", Channel: "ussd"}
+
+	if err := engine.Before(ctx, payment); !errors.Is(err, ErrHeld) {
+		t.Fatalf("with only the screening hold released: err = %v, want ErrHeld", err)
+	}
+	store.holds[[3]string{"TX1", api.ScreeningRule, "large-ussd"}].Status = api.HoldStatusReleased
+
+	if err := engine.Before(ctx, payment); !errors.Is(err, ErrHeld) {
+		t.Fatalf("with one rule hold released: err = %v, want ErrHeld", err)
+	}
+	if len(store.holds) != 3 {
+		t.Fatalf("got %d holds, want the screening hold and one per rule", len(store.holds))
+	}
+	store.holds[[3]string{"TX1", api.ScreeningRule, "ussd-review"}].Status = api.HoldStatusReleased
+
+	if err := engine.Before(ctx, payment); err != nil {
+		t.Fatalf("with every hold released: %v", err)
+	}
+}
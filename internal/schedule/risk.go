@@ -0,0 +1,81 @@
+package schedule
+
+import (
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/calendar"
+)
+
+// riskTrendWeeks is how far back the arrears trend looks.
+const riskTrendWeeks = 4
+
+// Risk bands for a 0-100 risk score.
+const (
+	RiskLow    = "LOW"
+	RiskMedium = "MEDIUM"
+	RiskHigh   = "HIGH"
+)
+
+// RiskAssessment scores how likely an account is to fall further behind,
+// from 0 (paying like clockwork) to 100.
+type RiskAssessment struct {
+	CustomerID          string     `json:"customer_id"`
+	AsOf                time.Time  `json:"as_of"`
+	Score               int        `json:"risk_score"`
+	Band                string     `json:"risk_band"`
+	OnTimePercentage    *float64   `json:"on_time_percentage"`
+	WeeksBehind         float64    `json:"weeks_behind"`
+	ArrearsTrendWeeks   float64    `json:"arrears_trend_weeks"`
+	ProjectedPayoffDate *time.Time `json:"projected_payoff_date"`
+}
+
+// AssessRisk scores an account on payment regularity (half the score), how
+// many installments it is behind (capped at eight weeks, 30%) and whether
+// arrears grew over the last four weeks (20%). payments are oldest first.
+func AssessRisk(customer *api.CustomerAccount, payments []api.PaymentEvent, now time.Time, cal *calendar.Calendar) *RiskAssessment {
+	velocity := PaymentVelocity(customer, payments, now, cal)
+	assessment := &RiskAssessment{
+		CustomerID:          customer.CustomerID,
+		AsOf:                now,
+		OnTimePercentage:    velocity.OnTimePercentage,
+		ProjectedPayoffDate: velocity.ProjectedCompletionDate,
+	}
+
+	if weekly := WeeklyAmount(customer); weekly > 0 {
+		then := now.Add(-riskTrendWeeks * week)
+		paidThen := customer.TotalPaid
+		for _, payment := range payments {
+			if payment.Date.After(then) {
+				paidThen -= payment.Amount
+			}
+		}
+		arrearsThen := math.Max(0, ExpectedPaid(customer, weeksDue(customer, then, cal))-paidThen)
+
+		assessment.WeeksBehind = roundCents(Arrears(customer, now, cal) / weekly)
+		assessment.ArrearsTrendWeeks = roundCents(assessment.WeeksBehind - arrearsThen/weekly)
+	}
+
+	irregularity := 0.0
+	if velocity.OnTimePercentage != nil {
+		irregularity = 1 - *velocity.OnTimePercentage/100
+	}
+	behind := math.Min(assessment.WeeksBehind/8, 1)
+	worsening := math.Min(math.Max(assessment.ArrearsTrendWeeks/riskTrendWeeks, 0), 1)
+
+	assessment.Score = int(math.Round(50*irregularity + 30*behind + 20*worsening))
+	assessment.Band = RiskBand(assessment.Score)
+	return assessment
+}
+
+func RiskBand(score int) string {
+	switch {
+	case score >= 67:
+		return RiskHigh
+	case score >= 34:
+		return RiskMedium
+	default:
+		return RiskLow
+	}
+}
@@ -0,0 +1,304 @@
+package schedule
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/calendar"
+)
+
+const (
+	week         = 7 * 24 * time.Hour
+	weeksPerYear = 52
+)
+
+type Installment struct {
+	Number    int       `json:"number"`
+	DueDate   time.Time `json:"due_date"`
+	Amount    float64   `json:"amount"`
+	AmountDue float64   `json:"amount_due"`
+}
+
+type PayoffQuote struct {
+	CustomerID         string    `json:"customer_id"`
+	AsOf               time.Time `json:"as_of"`
+	PrincipalBalance   float64   `json:"principal_balance"`
+	AccruedInterest    float64   `json:"accrued_interest"`
+	TotalPaid          float64   `json:"total_paid"`
+	PayoffAmount       float64   `json:"payoff_amount"`
+	OutstandingBalance float64   `json:"outstanding_balance"`
+	InterestSaved      float64   `json:"interest_saved"`
+}
+
+func weeklyRate(customer *api.CustomerAccount) float64 {
+	return customer.InterestRate / weeksPerYear
+}
+
+func restructured(customer *api.CustomerAccount) bool {
+	return customer.RestructuredAt != nil
+}
+
+// scheduleStart is the week installments start counting from: the end of
+// the grace period, or the restructuring date for rescheduled accounts.
+func scheduleStart(customer *api.CustomerAccount) int {
+	if restructured(customer) {
+		return WeeksElapsed(customer, *customer.RestructuredAt)
+	}
+	return customer.GraceWeeks
+}
+
+// installmentCount is the number of installments left from scheduleStart.
+func installmentCount(customer *api.CustomerAccount) int {
+	return max(0, customer.GraceWeeks+customer.TermWeeks-scheduleStart(customer))
+}
+
+func reducing(customer *api.CustomerAccount) bool {
+	return customer.InterestMethod == api.InterestReducingBalance && customer.InterestRate > 0
+}
+
+// financedPrincipal is the amount being amortised once repayments start.
+// Reducing-balance interest accrued during the grace period is capitalised.
+func financedPrincipal(customer *api.CustomerAccount) float64 {
+	if reducing(customer) {
+		return customer.AssetValue * math.Pow(1+weeklyRate(customer), float64(customer.GraceWeeks))
+	}
+	return customer.AssetValue
+}
+
+// TotalInterest is the interest charged over the full life of the loan when
+// every installment is paid on schedule.
+func TotalInterest(customer *api.CustomerAccount) float64 {
+	if restructured(customer) {
+		return roundCents(math.Max(0, TotalRepayable(customer)-customer.AssetValue))
+	}
+	if customer.InterestRate <= 0 {
+		return 0
+	}
+	if reducing(customer) {
+		return roundCents(WeeklyAmount(customer)*float64(customer.TermWeeks) - customer.AssetValue)
+	}
+	weeks := float64(customer.GraceWeeks + customer.TermWeeks)
+	return roundCents(customer.AssetValue * customer.InterestRate * weeks / weeksPerYear)
+}
+
+// TotalRepayable for a restructured account is fixed at restructuring time:
+// accrued interest was capitalised and the remaining balance carries none.
+func TotalRepayable(customer *api.CustomerAccount) float64 {
+	if restructured(customer) {
+		return roundCents(customer.TotalPaid + customer.OutstandingBalance)
+	}
+	return roundCents(customer.AssetValue + TotalInterest(customer))
+}
+
+func WeeklyAmount(customer *api.CustomerAccount) float64 {
+	if restructured(customer) {
+		return customer.InstallmentAmount
+	}
+	if customer.TermWeeks <= 0 {
+		return customer.AssetValue
+	}
+	if reducing(customer) {
+		r := weeklyRate(customer)
+		n := float64(customer.TermWeeks)
+		return roundCents(financedPrincipal(customer) * r / (1 - math.Pow(1+r, -n)))
+	}
+	if customer.InterestRate > 0 {
+		return roundCents(TotalRepayable(customer) / float64(customer.TermWeeks))
+	}
+	return customer.AssetValue / float64(customer.TermWeeks)
+}
+
+func WeeksElapsed(customer *api.CustomerAccount, now time.Time) int {
+	if now.Before(customer.DeploymentDate) {
+		return 0
+	}
+	return int(now.Sub(customer.DeploymentDate) / week)
+}
+
+// weeksDue is WeeksElapsed less the latest week while that week's due date is
+// shifted past now by a weekend or holiday in cal. It mirrors weeks_due in
+// the database schema; a nil calendar shifts nothing.
+func weeksDue(customer *api.CustomerAccount, now time.Time, cal *calendar.Calendar) int {
+	weeks := WeeksElapsed(customer, now)
+	if cal == nil || weeks <= 0 {
+		return weeks
+	}
+	due := customer.DeploymentDate.Add(time.Duration(weeks) * week)
+	if cal.Shift(due).After(now) {
+		return weeks - 1
+	}
+	return weeks
+}
+
+// repaymentWeeks converts weeks since deployment into installments due,
+// skipping the grace period.
+func repaymentWeeks(customer *api.CustomerAccount, weeks int) int {
+	return min(max(0, weeks-scheduleStart(customer)), installmentCount(customer))
+}
+
+func ExpectedPaid(customer *api.CustomerAccount, weeks int) float64 {
+	expected := customer.ScheduleBaseline + WeeklyAmount(customer)*float64(repaymentWeeks(customer, weeks))
+	return math.Min(TotalRepayable(customer), expected)
+}
+
+// Arrears is how far the account is behind its schedule. Installments due on
+// a non-business day in cal are not late until the next business day.
+func Arrears(customer *api.CustomerAccount, now time.Time, cal *calendar.Calendar) float64 {
+	if customer.OutstandingBalance <= 0 {
+		return 0
+	}
+	return roundCents(math.Max(0, ExpectedPaid(customer, weeksDue(customer, now, cal))-customer.TotalPaid))
+}
+
+// ScheduledBalance is what it would take to settle the loan after the given
+// number of weeks had every installment been paid on time: remaining
+// principal plus interest accrued so far.
+func ScheduledBalance(customer *api.CustomerAccount, weeks int) float64 {
+	if weeks > customer.GraceWeeks+customer.TermWeeks {
+		weeks = customer.GraceWeeks + customer.TermWeeks
+	}
+
+	if restructured(customer) {
+		return math.Max(0, TotalRepayable(customer)-ExpectedPaid(customer, weeks))
+	}
+
+	if reducing(customer) {
+		r := weeklyRate(customer)
+		if weeks <= customer.GraceWeeks {
+			return customer.AssetValue * math.Pow(1+r, float64(weeks))
+		}
+		j := float64(weeks - customer.GraceWeeks)
+		growth := math.Pow(1+r, j)
+		balance := financedPrincipal(customer)*growth - WeeklyAmount(customer)*(growth-1)/r
+		return math.Max(0, balance)
+	}
+
+	accrued := 0.0
+	if total := customer.GraceWeeks + customer.TermWeeks; total > 0 {
+		accrued = TotalInterest(customer) * float64(weeks) / float64(total)
+	}
+	return math.Max(0, customer.AssetValue+accrued-ExpectedPaid(customer, weeks))
+}
+
+// AccruedInterest is the interest earned to date on the schedule.
+func AccruedInterest(customer *api.CustomerAccount, now time.Time) float64 {
+	now = accrualCutoff(customer, now)
+	weeks := WeeksElapsed(customer, now)
+	return roundCents(math.Max(0, ScheduledBalance(customer, weeks)+ExpectedPaid(customer, weeks)-customer.AssetValue))
+}
+
+// Payoff quotes the amount that settles the loan today. Customers who are
+// behind pay their arrears on top of the scheduled balance; customers who
+// prepaid get the credit. Interest not yet accrued is waived.
+func Payoff(customer *api.CustomerAccount, now time.Time) *PayoffQuote {
+	if customer.WrittenOffAt != nil {
+		remaining := math.Max(0, customer.WrittenOffAmount-customer.RecoveredAmount)
+		return &PayoffQuote{
+			CustomerID:      customer.CustomerID,
+			AsOf:            now,
+			AccruedInterest: AccruedInterest(customer, now),
+			TotalPaid:       customer.TotalPaid,
+			PayoffAmount:    roundCents(remaining),
+		}
+	}
+
+	weeks := WeeksElapsed(customer, now)
+	scheduled := ScheduledBalance(customer, weeks)
+	expected := ExpectedPaid(customer, weeks)
+	accrued := AccruedInterest(customer, now)
+
+	payoff := math.Min(math.Max(0, scheduled+expected-customer.TotalPaid), customer.OutstandingBalance)
+	principal := math.Max(0, customer.AssetValue-math.Max(0, customer.TotalPaid-accrued))
+
+	return &PayoffQuote{
+		CustomerID:         customer.CustomerID,
+		AsOf:               now,
+		PrincipalBalance:   roundCents(principal),
+		AccruedInterest:    accrued,
+		TotalPaid:          customer.TotalPaid,
+		PayoffAmount:       roundCents(payoff),
+		OutstandingBalance: customer.OutstandingBalance,
+		InterestSaved:      roundCents(math.Max(0, customer.OutstandingBalance-payoff)),
+	}
+}
+
+// accrualCutoff stops interest accruing once an account is written off.
+func accrualCutoff(customer *api.CustomerAccount, now time.Time) time.Time {
+	if customer.WrittenOffAt != nil && now.After(*customer.WrittenOffAt) {
+		return *customer.WrittenOffAt
+	}
+	return now
+}
+
+// NextInstallment is the next installment falling due, with its due date
+// moved to the next business day in cal when it lands on a weekend or
+// holiday.
+func NextInstallment(customer *api.CustomerAccount, now time.Time, cal *calendar.Calendar) *Installment {
+	if customer.OutstandingBalance <= 0 {
+		return nil
+	}
+
+	start := scheduleStart(customer)
+	number := min(repaymentWeeks(customer, weeksDue(customer, now, cal))+1, installmentCount(customer))
+
+	dueDate := customer.DeploymentDate.Add(time.Duration(start+number) * week)
+	if cal != nil {
+		dueDate = cal.Shift(dueDate)
+	}
+	if dueDate.Before(now) {
+		dueDate = now
+	}
+
+	amountDue := ExpectedPaid(customer, start+number) - customer.TotalPaid
+	amountDue = math.Max(0, math.Min(amountDue, customer.OutstandingBalance))
+
+	return &Installment{
+		Number:    number,
+		DueDate:   dueDate,
+		Amount:    WeeklyAmount(customer),
+		AmountDue: roundCents(amountDue),
+	}
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Reschedule re-amortises the unpaid balance over the weeks left under
+// newTermWeeks, starting now. Capitalised arrears are folded into the new
+// installments; otherwise they stay due immediately. It returns a copy of
+// the account with the new terms and the amount capitalised.
+func Reschedule(customer *api.CustomerAccount, newTermWeeks int, capitalizeArrears bool, now time.Time) (*api.CustomerAccount, float64, error) {
+	weeks := WeeksElapsed(customer, now)
+	remaining := customer.GraceWeeks + newTermWeeks - weeks
+	if remaining < 1 {
+		return nil, 0, fmt.Errorf("new term of %d weeks must run past week %d", newTermWeeks, weeks)
+	}
+	if customer.WrittenOffAt != nil {
+		return nil, 0, fmt.Errorf("account was written off")
+	}
+	if customer.OutstandingBalance <= 0 {
+		return nil, 0, fmt.Errorf("account is already paid off")
+	}
+
+	totalRepayable := customer.TotalPaid + customer.OutstandingBalance
+	expected := ExpectedPaid(customer, weeks)
+	arrears := math.Max(0, expected-customer.TotalPaid)
+
+	baseline := math.Max(expected, customer.TotalPaid)
+	capitalized := 0.0
+	if capitalizeArrears {
+		baseline = customer.TotalPaid
+		capitalized = roundCents(arrears)
+	}
+
+	rescheduled := *customer
+	rescheduled.TermWeeks = newTermWeeks
+	rescheduled.RestructuredAt = &now
+	rescheduled.ScheduleBaseline = roundCents(baseline)
+	rescheduled.InstallmentAmount = math.Ceil((totalRepayable-baseline)/float64(remaining)*100) / 100
+
+	return &rescheduled, capitalized, nil
+}
@@ -0,0 +1,148 @@
+package schedule
+
+import (
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/calendar"
+)
+
+const day = 24 * time.Hour
+
+// Velocity summarises how a customer has been paying, for repeat-financing
+// decisions.
+type Velocity struct {
+	CustomerID       string     `json:"customer_id"`
+	AsOf             time.Time  `json:"as_of"`
+	PaymentCount     int        `json:"payment_count"`
+	TotalPaid        float64    `json:"total_paid"`
+	AveragePayment   float64    `json:"average_payment"`
+	PaymentsPerMonth float64    `json:"payments_per_month"`
+	AverageGapDays   float64    `json:"average_gap_days"`
+	LongestGapDays   float64    `json:"longest_gap_days"`
+	FirstPaymentDate *time.Time `json:"first_payment_date"`
+	LastPaymentDate  *time.Time `json:"last_payment_date"`
+
+	// InstallmentsDue counts installments that have fallen due;
+	// InstallmentsOnTime those covered by payments by their due date.
+	// OnTimePercentage is nil until the first installment falls due.
+	InstallmentsDue    int      `json:"installments_due"`
+	InstallmentsOnTime int      `json:"installments_on_time"`
+	OnTimePercentage   *float64 `json:"on_time_percentage"`
+
+	// ProjectedCompletionDate extrapolates the customer's average daily
+	// repayment since deployment over the outstanding balance. It is nil
+	// when nothing has been paid, and the last payment date once paid off.
+	ProjectedCompletionDate *time.Time `json:"projected_completion_date"`
+}
+
+// PaymentVelocity computes payment statistics from payments, oldest first.
+// The longest gap includes the time since the last payment while a balance
+// is outstanding, so a customer who stopped paying shows it.
+func PaymentVelocity(customer *api.CustomerAccount, payments []api.PaymentEvent, now time.Time, cal *calendar.Calendar) *Velocity {
+	v := &Velocity{
+		CustomerID:   customer.CustomerID,
+		AsOf:         now,
+		PaymentCount: len(payments),
+	}
+
+	for _, payment := range payments {
+		v.TotalPaid += payment.Amount
+	}
+	v.TotalPaid = roundCents(v.TotalPaid)
+
+	if len(payments) > 0 {
+		first, last := payments[0].Date, payments[len(payments)-1].Date
+		v.FirstPaymentDate, v.LastPaymentDate = &first, &last
+		v.AveragePayment = roundCents(v.TotalPaid / float64(len(payments)))
+
+		if months := now.Sub(customer.DeploymentDate).Hours() / 24 / 30; months > 0 {
+			v.PaymentsPerMonth = roundCents(float64(len(payments)) / math.Max(months, 1))
+		}
+
+		var gaps float64
+		for i := 1; i < len(payments); i++ {
+			gap := payments[i].Date.Sub(payments[i-1].Date).Hours() / 24
+			gaps += gap
+			v.LongestGapDays = math.Max(v.LongestGapDays, gap)
+		}
+		if len(payments) > 1 {
+			v.AverageGapDays = roundCents(gaps / float64(len(payments)-1))
+		}
+	}
+
+	if customer.OutstandingBalance > 0 {
+		since := customer.DeploymentDate
+		if v.LastPaymentDate != nil {
+			since = *v.LastPaymentDate
+		}
+		if now.After(since) {
+			v.LongestGapDays = math.Max(v.LongestGapDays, now.Sub(since).Hours()/24)
+		}
+	}
+	v.LongestGapDays = roundCents(v.LongestGapDays)
+
+	v.InstallmentsDue, v.InstallmentsOnTime = onTimeInstallments(customer, payments, now, cal)
+	if v.InstallmentsDue > 0 {
+		pct := roundCents(float64(v.InstallmentsOnTime) / float64(v.InstallmentsDue) * 100)
+		v.OnTimePercentage = &pct
+	}
+
+	v.ProjectedCompletionDate = ProjectedCompletion(customer, v.TotalPaid, v.LastPaymentDate, now)
+	return v
+}
+
+// ProjectedCompletion extrapolates the average daily repayment since
+// deployment over the outstanding balance.
+func ProjectedCompletion(customer *api.CustomerAccount, totalPaid float64, lastPayment *time.Time, now time.Time) *time.Time {
+	if customer.OutstandingBalance <= 0 {
+		return lastPayment
+	}
+	elapsed := now.Sub(customer.DeploymentDate)
+	if totalPaid <= 0 || elapsed <= 0 {
+		return nil
+	}
+
+	perDay := totalPaid / (elapsed.Hours() / 24)
+	days := math.Ceil(customer.OutstandingBalance / perDay)
+	// Beyond a century the projection says nothing useful and would
+	// overflow time.Duration.
+	if days > 36500 {
+		return nil
+	}
+	projected := now.Add(time.Duration(days) * day)
+	return &projected
+}
+
+// onTimeInstallments counts installments due by now and those whose
+// expected cumulative total had been paid by their (business-day shifted)
+// due date.
+func onTimeInstallments(customer *api.CustomerAccount, payments []api.PaymentEvent, now time.Time, cal *calendar.Calendar) (due, onTime int) {
+	start := scheduleStart(customer)
+	due = repaymentWeeks(customer, weeksDue(customer, now, cal))
+
+	paid := customer.ScheduleBaseline
+	if restructured(customer) {
+		// The baseline already covers payments made before restructuring.
+		for len(payments) > 0 && !payments[0].Date.After(*customer.RestructuredAt) {
+			payments = payments[1:]
+		}
+	}
+
+	next := 0
+	for n := 1; n <= due; n++ {
+		dueDate := customer.DeploymentDate.Add(time.Duration(start+n) * week)
+		if cal != nil {
+			dueDate = cal.Shift(dueDate)
+		}
+		for next < len(payments) && !payments[next].Date.After(dueDate) {
+			paid += payments[next].Amount
+			next++
+		}
+		if paid+0.005 >= ExpectedPaid(customer, start+n) {
+			onTime++
+		}
+	}
+	return due, onTime
+}
@@ -0,0 +1,79 @@
+package screening
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrHeld marks a payment held for screening review instead of being
+// applied.
+var ErrHeld = errors.New("payment held for screening review")
+
+// Store is the persistence the screener needs. *tools.DatabaseService
+// implements it.
+type Store interface {
+	MatchScreeningEntry(ctx context.Context, payment *api.PaymentPayload) (*api.ScreeningEntry, error)
+	HoldPayment(ctx context.Context, payment *api.PaymentPayload, entry *api.ScreeningEntry) (*api.ScreeningHold, error)
+	GetScreeningHoldByReference(ctx context.Context, reference string) (*api.ScreeningHold, error)
+}
+
+// Screener checks payments against the sanctions/blacklist entries. The API
+// screens payments as they are accepted; as a payment processor hook it
+// also covers payments ingested any other way (imports, bank feeds,
+// replays).
+type Screener struct {
+	processors.HookFuncs
+	store Store
+}
+
+func NewScreener(store Store) *Screener {
+	s := &Screener{store: store}
+	s.Before = s.beforeApply
+	return s
+}
+
+// Screen returns the hold the payment is under, holding it first if it
+// matches an entry, or nil if it may be processed. Payments whose hold was
+// released are let through.
+func (s *Screener) Screen(ctx context.Context, payment *api.PaymentPayload) (*api.ScreeningHold, error) {
+	hold, err := s.store.GetScreeningHoldByReference(ctx, payment.TransactionReference)
+	switch {
+	case err == nil && hold.Status == api.HoldStatusReleased:
+		return nil, nil
+	case err == nil:
+		return hold, nil
+	case !errors.Is(err, tools.ErrNotFound):
+		return nil, err
+	}
+
+	entry, err := s.store.MatchScreeningEntry(ctx, payment)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	hold, err = s.store.HoldPayment(ctx, payment, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	tools.DefaultMetrics.Inc("screening_holds_total", 1, "entry_type", entry.EntryType)
+	log.Printf("Payment %s for %s held: matched %s entry %d (%s)", payment.TransactionReference, payment.CustomerID, entry.EntryType, entry.ID, entry.Value)
+	return hold, nil
+}
+
+func (s *Screener) beforeApply(ctx context.Context, payment *api.PaymentPayload) error {
+	hold, err := s.Screen(ctx, payment)
+	if err != nil {
+		return fmt.Errorf("screening failed: %w", err)
+	}
+	if hold != nil {
+		return fmt.Errorf("%w: hold %d is %s", ErrHeld, hold.ID, hold.Status)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Admin dashboard. Everything it shows comes from the JSON API on the same
+// origin; it keeps no state of its own beyond the current page.
+(function () {
+  "use strict";
+
+  var API = "/api/v1";
+  var pageSize = 50;
+  var customersOffset = 0;
+
+  function $(id) { return document.getElementById(id); }
+
+  function showError(message) {
+    var el = $("error");
+    el.textContent = message;
+    el.className = message ? "shown" : "";
+  }
+
+  function request(method, path, params) {
+    var query = new URLSearchParams();
+    Object.keys(params || {}).forEach(function (key) {
+      if (params[key] !== "" && params[key] !== undefined) query.set(key, params[key]);
+    });
+    var url = API + path + (query.toString() ? "?" + query : "");
+    return fetch(url, { method: method, headers: { Accept: "application/json" } })
+      .then(function (response) {
+        return response.json().catch(function () { return {}; }).then(function (body) {
+          if (!response.ok) throw new Error(body.error || method + " " + path + ": " + response.status);
+          showError("");
+          return body;
+        });
+      })
+      .catch(function (err) {
+        showError(err.message);
+        throw err;
+      });
+  }
+
+  function formValues(form) {
+    var values = {};
+    new FormData(form).forEach(function (value, key) { values[key] = value; });
+    return values;
+  }
+
+  function format(value) {
+    if (value === null || value === undefined) return "";
+    if (typeof value === "number") return value.toLocaleString(undefined, { maximumFractionDigits: 2 });
+    if (typeof value === "object") return JSON.stringify(value);
+    return String(value);
+  }
+
+  // table renders rows of objects with a column per key of the first row.
+  function table(rows) {
+    if (!rows || rows.length === 0) {
+      var empty = document.createElement("p");
+      empty.className = "hint";
+      empty.textContent = "Nothing to show.";
+      return empty;
+    }
+    var columns = Object.keys(rows[0]);
+    var el = document.createElement("table");
+    var head = el.createTHead().insertRow();
+    columns.forEach(function (column) {
+      var th = document.createElement("th");
+      th.textContent = column.replace(/_/g, " ");
+      head.appendChild(th);
+    });
+    var body = el.createTBody();
+    rows.forEach(function (row) {
+      var tr = body.insertRow();
+      columns.forEach(function (column) { tr.insertCell().textContent = format(row[column]); });
+    });
+    return el;
+  }
+
+  // firstList finds the list in a report response, whatever it is called.
+  function firstList(body) {
+    if (Array.isArray(body)) return body;
+    for (var key in body) {
+      if (Array.isArray(body[key])) return body[key];
+    }
+    return [body];
+  }
+
+  function render(id, node) {
+    var el = $(id);
+    el.innerHTML = "";
+    el.appendChild(node);
+  }
+
+  function card(label, value) {
+    var el = document.createElement("div");
+    el.className = "card";
+    el.innerHTML = '<div class="label"></div><div class="value"></div>';
+    el.firstChild.textContent = label;
+    el.lastChild.textContent = format(value);
+    return el;
+  }
+
+  var loaders = {
+    overview: function () {
+      return request("GET", "/admin/stats").then(function (stats) {
+        var cards = document.createDocumentFragment();
+        Object.keys(stats.database || {}).forEach(function (key) {
+          cards.appendChild(card(key.replace(/_/g, " "), stats.database[key]));
+        });
+        cards.appendChild(card("queue depth", stats.queue ? stats.queue.size : ""));
+        cards.appendChild(card("workers", stats.workers ? stats.workers.count : ""));
+        if (stats.sla) {
+          Object.keys(stats.sla).forEach(function (key) {
+            cards.appendChild(card("sla " + key.replace(/_/g, " "), stats.sla[key]));
+          });
+        }
+        var container = document.createElement("div");
+        container.className = "cards";
+        container.appendChild(cards);
+        render("overview-cards", container);
+        render("overview-regions", table(stats.regions));
+      });
+    },
+
+    customers: function () {
+      var params = formValues($("customers-filter"));
+      params.limit = pageSize;
+      params.offset = customersOffset;
+      return request("GET", "/customers", params).then(function (body) {
+        render("customers-table", table(body.customers));
+        var last = Math.min(body.offset + body.customers.length, body.total);
+        $("customers-page").textContent = (body.total ? body.offset + 1 : 0) + "–" + last + " of " + body.total;
+        $("customers-prev").disabled = body.offset === 0;
+        $("customers-next").disabled = last >= body.total;
+      });
+    },
+
+    reports: function () {
+      var params = formValues($("reports-filter"));
+      var report = params.report;
+      delete params.report;
+      return request("GET", "/admin/reports/" + report, params).then(function (body) {
+        render("reports-table", table(firstList(body)));
+      });
+    },
+
+    holds: function () {
+      return request("GET", "/admin/screening/holds", { status: "HELD", limit: 200 }).then(function (body) {
+        render("holds-table", table(body.holds.map(function (hold) {
+          return {
+            id: hold.id,
+            transaction_reference: hold.transaction_reference,
+            customer_id: hold.customer_id,
+            held_by: hold.entry_type,
+            matched: hold.matched_value,
+            amount: hold.payment.transaction_amount,
+            channel: hold.payment.channel,
+            created_at: hold.created_at
+          };
+        })));
+      });
+    },
+
+    replay: function () { return Promise.resolve(); }
+  };
+
+  function show() {
+    var view = location.hash.slice(1) || "overview";
+    if (!loaders[view]) view = "overview";
+    document.querySelectorAll(".view").forEach(function (el) {
+      el.classList.toggle("active", el.id === view);
+    });
+    document.querySelectorAll("nav a").forEach(function (el) {
+      el.classList.toggle("active", el.getAttribute("href") === "#" + view);
+    });
+    loaders[view]().catch(function () {});
+  }
+
+  document.querySelectorAll("[data-refresh]").forEach(function (button) {
+    button.addEventListener("click", function () {
+      loaders[button.getAttribute("data-refresh")]().catch(function () {});
+    });
+  });
+
+  $("customers-filter").addEventListener("submit", function (event) {
+    event.preventDefault();
+    customersOffset = 0;
+    loaders.customers().catch(function () {});
+  });
+  $("customers-prev").addEventListener("click", function () {
+    customersOffset = Math.max(0, customersOffset - pageSize);
+    loaders.customers().catch(function () {});
+  });
+  $("customers-next").addEventListener("click", function () {
+    customersOffset += pageSize;
+    loaders.customers().catch(function () {});
+  });
+
+  $("reports-filter").addEventListener("submit", function (event) {
+    event.preventDefault();
+    loaders.reports().catch(function () {});
+  });
+
+  $("replay-form").addEventListener("submit", function (event) {
+    event.preventDefault();
+    var params = formValues(event.target);
+    if (!confirm("Queue archived payments from " + params.from + " to " + params.to + " for replay?")) return;
+    request("POST", "/admin/replay", params).then(function (body) {
+      $("replay-result").textContent = JSON.stringify(body, null, 2);
+    }).catch(function () {});
+  });
+
+  window.addEventListener("hashchange", show);
+  show();
+})();
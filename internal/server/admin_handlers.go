@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func parseTimeParam(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339 or YYYY-MM-DD", value)
+}
+
+func (s *APIServer) handleReplay(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	from, err := parseTimeParam(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from: " + err.Error()})
+		return
+	}
+	to, err := parseTimeParam(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to: " + err.Error()})
+		return
+	}
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from"})
+		return
+	}
+
+	customerID := c.Query("customer_id")
+
+	payments, err := s.db.GetArchivedPayments(ctx, from, to, customerID)
+	if err != nil {
+		log.Printf("Failed to load archived payments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load archived payments"})
+		return
+	}
+
+	replayed := 0
+	for i := range payments {
+		if err := s.redis.EnqueuePayment(ctx, &payments[i]); err != nil {
+			log.Printf("Replay stopped after %d payments: %v", replayed, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":    "Failed to queue payment for replay",
+				"replayed": replayed,
+				"found":    len(payments),
+			})
+			return
+		}
+		replayed++
+	}
+
+	log.Printf("Replayed %d archived payments from %s to %s", replayed, from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Payments queued for replay",
+		"from":        from,
+		"to":          to,
+		"customer_id": customerID,
+		"replayed":    replayed,
+	})
+}
+
+func (s *APIServer) handleGetSnapshot(c *gin.Context) {
+	date, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+
+	snapshots, err := s.db.GetPortfolioSnapshot(c.Request.Context(), date)
+	if err != nil {
+		log.Printf("Failed to load snapshot for %s: %v", c.Param("date"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load snapshot"})
+		return
+	}
+
+	if len(snapshots) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No snapshot for this date"})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=snapshot-%s.csv", date.Format("2006-01-02")))
+		c.Status(http.StatusOK)
+		tools.WriteSnapshotsCSV(c.Writer, snapshots)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"snapshot_date": date.Format("2006-01-02"),
+		"accounts":      snapshots,
+		"total":         len(snapshots),
+	})
+}
+
+func (s *APIServer) handleExportSnapshot(c *gin.Context) {
+	if s.Storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Blob storage is not configured"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	date, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+
+	snapshots, err := s.db.GetPortfolioSnapshot(ctx, date)
+	if err != nil {
+		log.Printf("Failed to load snapshot for %s: %v", c.Param("date"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load snapshot"})
+		return
+	}
+
+	if len(snapshots) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No snapshot for this date"})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tools.WriteSnapshotsCSV(&buf, snapshots); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render snapshot"})
+		return
+	}
+
+	key := tools.SnapshotBlobKey(date)
+	if err := s.Storage.Put(ctx, key, &buf, "text/csv"); err != nil {
+		log.Printf("Failed to upload snapshot %s: %v", key, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to upload snapshot"})
+		return
+	}
+
+	url, err := s.Storage.PresignGet(key, s.PresignExpiry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download URL"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"snapshot_date": date.Format("2006-01-02"),
+		"key":           key,
+		"download_url":  url,
+		"expires_in":    int(s.PresignExpiry.Seconds()),
+	})
+}
+
+func (s *APIServer) handleRotatePIIKey(c *gin.Context) {
+	id, err := s.db.RotatePIIDataKey(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to rotate PII data key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"active_data_key": id})
+}
+
+func (s *APIServer) handleRewrapPIIKeys(c *gin.Context) {
+	rewrapped, err := s.db.RewrapPIIDataKeys(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to rewrap PII data keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "rewrapped": rewrapped})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rewrapped": rewrapped})
+}
+
+func (s *APIServer) handleReencryptPII(c *gin.Context) {
+	updated, err := s.db.ReencryptPII(c.Request.Context())
+	if err != nil {
+		log.Printf("PII re-encryption stopped after %d rows: %v", updated, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "updated": updated})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// handleAnonymizedExport writes an anonymized copy of the dataset to blob
+// storage for loading into staging.
+func (s *APIServer) handleAnonymizedExport(c *gin.Context) {
+	if s.Storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Blob storage is not configured"})
+		return
+	}
+
+	export, err := processors.RunAnonymizedExport(c.Request.Context(), s.db, s.Storage, s.AnonymizeOptions)
+	if err != nil {
+		log.Printf("Anonymized export failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"export":   export,
+		"manifest": tools.AnonymizedManifestKey(export.ExportID),
+	})
+}
+
+// handleImportAnonymizedExport loads an export from the shared blob store.
+// It is disabled unless the deployment is configured as a staging target.
+func (s *APIServer) handleImportAnonymizedExport(c *gin.Context) {
+	if !s.AnonymizedImport {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Anonymized imports are disabled"})
+		return
+	}
+	if s.Storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Blob storage is not configured"})
+		return
+	}
+
+	exportID := c.Param("export_id")
+	export, err := s.db.ImportAnonymized(c.Request.Context(), s.Storage, exportID)
+	if err != nil {
+		log.Printf("Anonymized import %s failed: %v", exportID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Anonymized export %s imported", exportID)
+	c.JSON(http.StatusOK, gin.H{"imported": export})
+}
+
+func (s *APIServer) handleRunRetention(c *gin.Context) {
+	dryRun := c.DefaultQuery("dry_run", "true") != "false"
+
+	results, err := processors.RunRetention(c.Request.Context(), s.db, s.Storage, s.RetentionPolicies, dryRun)
+	if err != nil {
+		log.Printf("Retention run failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "results": results})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dry_run": dryRun, "results": results})
+}
+
+// handleInvalidateBalanceCache drops cached balances on every instance:
+// the given customer's, or all of them without customer_id.
+func (s *APIServer) handleInvalidateBalanceCache(c *gin.Context) {
+	var request struct {
+		CustomerID string `json:"customer_id" binding:"max=50"`
+	}
+	if c.Request.ContentLength != 0 && !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if request.CustomerID != "" {
+		if err := s.redis.InvalidateBalance(ctx, request.CustomerID); err != nil {
+			log.Printf("Failed to invalidate cached balance for %s: %v", request.CustomerID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invalidate cached balance"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"customer_id": request.CustomerID, "invalidated": true})
+		return
+	}
+
+	removed, err := s.redis.InvalidateBalances(ctx)
+	if err != nil {
+		log.Printf("Failed to invalidate cached balances after %d: %v", removed, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invalidate cached balances", "removed": removed})
+		return
+	}
+	log.Printf("Invalidated %d cached balances", removed)
+	c.JSON(http.StatusOK, gin.H{"removed": removed})
+}
+
+// handleListLedgerDrift lists the accounts the ledger check found out of
+// step with their processed transactions, newest first.
+func (s *APIServer) handleListLedgerDrift(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	drifts, err := s.db.ListLedgerDrift(c.Request.Context(), c.Query("customer_id"), limit)
+	if err != nil {
+		log.Printf("Failed to list ledger drift: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ledger drift"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"drift": drifts, "count": len(drifts)})
+}
+
+func (s *APIServer) handleDBConnection(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+	c.JSON(http.StatusOK, s.db.ConnectionState(ctx))
+}
+
+// handleDBReconnect drops every pooled database connection, e.g. after a
+// failover the service didn't notice, so the next queries find the current
+// primary.
+func (s *APIServer) handleDBReconnect(c *gin.Context) {
+	s.db.Reconnect("requested by admin")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	if _, err := s.db.Ping(ctx); err != nil {
+		log.Printf("Database unreachable after reconnect: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unreachable after reconnect", "connection": s.db.ConnectionState(ctx)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "reconnected", "connection": s.db.ConnectionState(ctx)})
+}
+
+func (s *APIServer) handleListPartitions(c *gin.Context) {
+	ctx := c.Request.Context()
+	partitioned, err := s.db.IsTransactionsPartitioned(ctx)
+	if err != nil {
+		log.Printf("Failed to check partitioning: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch partitions"})
+		return
+	}
+	partitions, err := s.db.ListTransactionPartitions(ctx)
+	if err != nil {
+		log.Printf("Failed to list partitions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch partitions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"table": "processed_transactions", "partitioned": partitioned, "partitions": partitions})
+}
+
+// handleListTransactionArchives lists the Parquet files of archived
+// transactions, optionally of one ?month=YYYY-MM.
+func (s *APIServer) handleListTransactionArchives(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	archives, err := s.db.ListTransactionArchives(c.Request.Context(), c.Query("month"), limit)
+	if err != nil {
+		log.Printf("Failed to list transaction archives: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transaction archives"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"archives": archives, "count": len(archives)})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The admin dashboard is a static single-page app over the JSON API,
+// embedded so small deployments don't need to host a separate frontend.
+//
+//go:embed admin
+var adminAssets embed.FS
+
+// handleAdminUI serves the dashboard at /admin when s.AdminUI is set. Paths
+// that aren't an asset get index.html so links into the dashboard work.
+func (s *APIServer) handleAdminUI(c *gin.Context) {
+	if !s.AdminUI {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Admin dashboard is disabled"})
+		return
+	}
+
+	assets, _ := fs.Sub(adminAssets, "admin")
+	name := strings.TrimPrefix(c.Param("filepath"), "/")
+	if name != "" && name != "index.html" {
+		if _, err := fs.Stat(assets, name); err == nil {
+			c.FileFromFS(name, http.FS(assets))
+			return
+		}
+	}
+
+	// Served directly: http.FileServer redirects requests for index.html.
+	index, err := fs.ReadFile(assets, "index.html")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Admin dashboard is missing"})
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleDeclareAgentDeposit records cash an agent paid in at the bank. It
+// counts towards their cash position straight away and is confirmed or
+// rejected once checked against the bank statement.
+func (s *APIServer) handleDeclareAgentDeposit(c *gin.Context) {
+	var request api.AgentDepositRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	depositedOn, err := time.Parse("2006-01-02", request.DepositedOn)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deposited_on must be YYYY-MM-DD"})
+		return
+	}
+	if depositedOn.After(s.clock.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deposited_on must not be in the future"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	agentID := c.Param("agent_id")
+	if _, err := s.db.GetAgent(ctx, agentID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return
+	}
+
+	deposit, created, err := s.db.DeclareAgentDeposit(ctx, agentID, request, depositedOn)
+	if err != nil {
+		log.Printf("Failed to declare deposit %s for agent %s: %v", request.Reference, agentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to declare deposit"})
+		return
+	}
+	if !created {
+		if deposit.AgentID != agentID {
+			c.JSON(http.StatusConflict, gin.H{"error": "Deposit reference is already declared by another agent"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate", "deposit": deposit})
+		return
+	}
+
+	tools.DefaultMetrics.Inc("agent_deposits_declared_total", 1)
+	log.Printf("Agent %s declared deposit %s of %.2f on %s", agentID, deposit.Reference, deposit.Amount, deposit.DepositedOn)
+	c.JSON(http.StatusCreated, deposit)
+}
+
+func (s *APIServer) handleListAgentDeposits(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	status := api.AgentDepositStatus(c.Query("status"))
+	switch status {
+	case "", api.AgentDepositDeclared, api.AgentDepositConfirmed, api.AgentDepositRejected:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be DECLARED, CONFIRMED or REJECTED"})
+		return
+	}
+
+	agentID := c.Param("agent_id")
+	if agentID == "" {
+		agentID = c.Query("agent_id")
+	}
+
+	deposits, err := s.db.ListAgentDeposits(c.Request.Context(), agentID, status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deposits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deposits": deposits})
+}
+
+func (s *APIServer) handleConfirmAgentDeposit(c *gin.Context) {
+	s.reviewAgentDeposit(c, api.AgentDepositConfirmed)
+}
+
+func (s *APIServer) handleRejectAgentDeposit(c *gin.Context) {
+	s.reviewAgentDeposit(c, api.AgentDepositRejected)
+}
+
+// reviewAgentDeposit confirms or rejects a declared deposit. Rejecting one,
+// e.g. because it isn't on the bank statement, needs a reason and puts the
+// amount back in the agent's outstanding cash.
+func (s *APIServer) reviewAgentDeposit(c *gin.Context, status api.AgentDepositStatus) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deposit ID"})
+		return
+	}
+
+	var request struct {
+		ReviewedBy string `json:"reviewed_by" binding:"required,max=100"`
+		Reason     string `json:"reason" binding:"max=500"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if status == api.AgentDepositRejected && request.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required to reject a deposit"})
+		return
+	}
+
+	deposit, err := s.db.ReviewAgentDeposit(c.Request.Context(), id, status, request.ReviewedBy, request.Reason)
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deposit not found"})
+		return
+	case errors.Is(err, tools.ErrDepositReviewed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Deposit has already been reviewed"})
+		return
+	case err != nil:
+		log.Printf("Failed to review deposit %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review deposit"})
+		return
+	}
+
+	log.Printf("Deposit %s of agent %s %s by %s", deposit.Reference, deposit.AgentID, deposit.Status, deposit.ReviewedBy)
+	c.JSON(http.StatusOK, deposit)
+}
+
+func (s *APIServer) handleAgentCashPosition(c *gin.Context) {
+	ctx := c.Request.Context()
+	agentID := c.Param("agent_id")
+	if _, err := s.db.GetAgent(ctx, agentID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return
+	}
+
+	positions, err := s.db.GetAgentCashPositions(ctx, s.CashTrackingStart, agentID)
+	if err != nil || len(positions) == 0 {
+		log.Printf("Failed to build cash position of agent %s: %v", agentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cash position"})
+		return
+	}
+
+	position := positions[0]
+	s.CashVariancePolicy.Check(position)
+	c.JSON(http.StatusOK, position)
+}
+
+// handleAgentCashReport lists every agent's collections against their
+// deposits, or with ?lagging=true only those whose deposits lag.
+func (s *APIServer) handleAgentCashReport(c *gin.Context) {
+	positions, err := s.db.GetAgentCashPositions(c.Request.Context(), s.CashTrackingStart, "")
+	if err != nil {
+		log.Printf("Failed to build agent cash report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	laggingOnly := c.Query("lagging") == "true"
+	report := positions[:0]
+	var collected, deposited, unconfirmed, outstanding float64
+	lagging := 0
+	for _, position := range positions {
+		if s.CashVariancePolicy.Check(position) {
+			lagging++
+		} else if laggingOnly {
+			continue
+		}
+		collected += position.Collected
+		deposited += position.Deposited
+		unconfirmed += position.Unconfirmed
+		outstanding += position.Outstanding
+		report = append(report, position)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agents":            report,
+		"lagging":           lagging,
+		"total_collected":   collected,
+		"total_deposited":   deposited,
+		"total_unconfirmed": unconfirmed,
+		"total_outstanding": outstanding,
+	})
+}
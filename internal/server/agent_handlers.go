@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleCreateAgent(c *gin.Context) {
+	agent := api.Agent{Active: true}
+	if !validation.BindJSON(c, &agent) {
+		return
+	}
+
+	if err := s.db.CreateAgent(c.Request.Context(), &agent); err != nil {
+		log.Printf("Failed to create agent %s: %v", agent.AgentID, err)
+		c.JSON(errorStatus(err), gin.H{"error": "Failed to create agent"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, agent)
+}
+
+func (s *APIServer) handleListAgents(c *gin.Context) {
+	agents, err := s.db.ListAgents(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"agents": agents})
+}
+
+func (s *APIServer) handleGetAgent(c *gin.Context) {
+	agent, err := s.db.GetAgent(c.Request.Context(), c.Param("agent_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, agent)
+}
+
+func (s *APIServer) handleAgentCollections(c *gin.Context) {
+	from, to, ok := s.reportPeriod(c)
+	if !ok {
+		return
+	}
+
+	report, err := s.db.GetAgentCollections(c.Request.Context(), from, to, c.Query("agent_id"))
+	if err != nil {
+		log.Printf("Failed to build agent collections report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	var totalCollected, totalCommission float64
+	for _, row := range report {
+		totalCollected += row.TotalCollected
+		totalCommission += row.CommissionEarned
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":             from,
+		"to":               to,
+		"agents":           report,
+		"total_collected":  totalCollected,
+		"total_commission": totalCommission,
+	})
+}
+
+// reportPeriod reads from/to query params, defaulting to the current month.
+func (s *APIServer) reportPeriod(c *gin.Context) (time.Time, time.Time, bool) {
+	now := s.clock.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	if value := c.Query("from"); value != "" {
+		parsed, err := parseTimeParam(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from: " + err.Error()})
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+	if value := c.Query("to"); value != "" {
+		parsed, err := parseTimeParam(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to: " + err.Error()})
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+	}
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from"})
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, to, true
+}
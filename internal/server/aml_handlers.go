@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleListAMLAlerts(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	alerts, err := s.db.ListAMLAlerts(c.Request.Context(), c.Query("status"), c.Query("customer_id"), limit)
+	if err != nil {
+		log.Printf("Failed to list AML alerts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AML alerts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+func (s *APIServer) handleGetAMLAlert(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert id"})
+		return
+	}
+
+	alert, err := s.db.GetAMLAlert(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "AML alert not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+// handleReviewAMLAlert records that a compliance officer has picked up the
+// alert.
+func (s *APIServer) handleReviewAMLAlert(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert id"})
+		return
+	}
+
+	var request struct {
+		ReviewedBy string `json:"reviewed_by" binding:"required,max=100"`
+		Notes      string `json:"notes" binding:"max=2000"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	alert, err := s.db.ReviewAMLAlert(c.Request.Context(), id, request.ReviewedBy, request.Notes)
+	if !s.amlAlertUpdated(c, id, err) {
+		return
+	}
+
+	log.Printf("AML alert %d under review by %s", id, request.ReviewedBy)
+	c.JSON(http.StatusOK, alert)
+}
+
+// handleDisposeAMLAlert closes the alert with the compliance decision:
+// REPORTED once a report has been filed with the regulator, otherwise
+// FALSE_POSITIVE.
+func (s *APIServer) handleDisposeAMLAlert(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert id"})
+		return
+	}
+
+	var request struct {
+		Disposition string `json:"disposition" binding:"required,oneof=REPORTED FALSE_POSITIVE"`
+		ReviewedBy  string `json:"reviewed_by" binding:"required,max=100"`
+		Notes       string `json:"notes" binding:"required,max=2000"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	alert, err := s.db.DisposeAMLAlert(c.Request.Context(), id, request.Disposition, request.ReviewedBy, request.Notes)
+	if !s.amlAlertUpdated(c, id, err) {
+		return
+	}
+
+	log.Printf("AML alert %d closed as %s by %s", id, request.Disposition, request.ReviewedBy)
+	c.JSON(http.StatusOK, alert)
+}
+
+// amlAlertUpdated writes the error response for a failed alert update and
+// reports whether the update succeeded.
+func (s *APIServer) amlAlertUpdated(c *gin.Context, id int64, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "AML alert not found"})
+	case errors.Is(err, tools.ErrAMLAlertClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "AML alert is already closed"})
+	default:
+		log.Printf("Failed to update AML alert %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update AML alert"})
+	}
+	return false
+}
@@ -1,13 +1,31 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/bankfeeds"
+	"github.com/abjerry97/go_payment/internal/cards"
+	"github.com/abjerry97/go_payment/internal/flags"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/intents"
+	"github.com/abjerry97/go_payment/internal/notifications"
 	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/rules"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/screening"
+	"github.com/abjerry97/go_payment/internal/settlements"
+	"github.com/abjerry97/go_payment/internal/templates"
 	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/ussd"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/abjerry97/go_payment/internal/virtualaccounts"
+	"github.com/abjerry97/go_payment/internal/webhooks"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -16,42 +34,494 @@ type APIServer struct {
 	db        *tools.DatabaseService
 	redis     *tools.RedisService
 	Processor *processors.PaymentProcessor
-	router    *gin.Engine
+	// PayoutProcessor, when set, is drained along with Processor.
+	PayoutProcessor *processors.PayoutProcessor
+	Storage         tools.BlobStore
+	PresignExpiry   time.Duration
+	V1Sunset        time.Time
+	Notifier        *notifications.Notifier
+	Alerter         tools.Alerter
+	// Webhooks delivers events to webhook subscriptions, and test events
+	// on request. Rotated signing keys keep signing for
+	// WebhookKeyGracePeriod; subscribers are told to reject signatures
+	// older than WebhookSignatureTolerance.
+	Webhooks                  *webhooks.Dispatcher
+	WebhookKeyGracePeriod     time.Duration
+	WebhookSignatureTolerance time.Duration
+	// OpsAlerter, when set, is told about refunds of at least
+	// LargeReversalAmount.
+	OpsAlerter          tools.Alerter
+	LargeReversalAmount float64
+	BankFeeds           *bankfeeds.Service
+	// VirtualAccounts, when set, provisions dedicated account numbers.
+	VirtualAccounts *virtualaccounts.Service
+	// CardGateway, when set, lets customers save cards for automatic
+	// installment charges.
+	CardGateway cards.Gateway
+
+	SettlementPoller *settlements.Poller
+	Flags            *flags.Provider
+	// Screener, when set, holds payments matching the sanctions/blacklist
+	// entries for review instead of queueing them.
+	Screener *screening.Screener
+	// Rules is the processor's payment rules engine, told when rules change
+	// so they apply without waiting for the refresh interval.
+	Rules *rules.Engine
+	// Intents, when set, rejects payments that don't fit the payment intent
+	// they reference.
+	Intents *intents.Matcher
+	// QRMerchant, when set, lets payment references be issued as QR
+	// payloads.
+	QRMerchant *intents.QRMerchant
+	// PaymentIntentTTL is how long payment intents stay open when the
+	// request doesn't say.
+	PaymentIntentTTL time.Duration
+	// DuplicateMetadataKeys are the metadata fields compared when looking
+	// for duplicate customers.
+	DuplicateMetadataKeys []string
+	// Templates renders message and receipt templates.
+	Templates *templates.Renderer
+	// ReportDeliverer sends saved reports run with ?deliver=true.
+	ReportDeliverer *processors.ReportDeliverer
+	// GLExporter builds GL journals and trial balances and pushes them to
+	// the accounting system.
+	GLExporter *processors.GLExporter
+	// CashTrackingStart is the day agent cash positions count from, and
+	// CashVariancePolicy when they are reported as lagging.
+	CashTrackingStart  time.Time
+	CashVariancePolicy tools.CashVariancePolicy
+	// VoiceProvider and VoiceReminders, when set, place reminder calls and
+	// take the provider's callbacks about them.
+	VoiceProvider  notifications.VoiceProvider
+	VoiceReminders *processors.VoiceReminders
+	// WhatsApp, when set, sends WhatsApp messages and takes their delivery
+	// status webhooks.
+	WhatsApp *notifications.WhatsAppProvider
+
+	RetentionPolicies []tools.RetentionPolicy
+	AnonymizeOptions  tools.AnonymizeOptions
+	// AnonymizedImport allows loading anonymized exports; enable it on
+	// staging only.
+	AnonymizedImport bool
+	// AdminUI serves the embedded admin dashboard at /admin.
+	AdminUI bool
+	// DegradedWrites queues payments without checking the customer when
+	// the database is unreachable; the processor checks it later.
+	DegradedWrites bool
+
+	// MaxBodyBytes caps request bodies; zero disables the limit.
+	MaxBodyBytes int64
+	Compression  bool
+	// CacheControl maps ETag-enabled routes to their Cache-Control header.
+	CacheControl map[string]string
+	// RequestTimeout is the deadline for routes without an entry in
+	// RouteTimeouts; zero disables it.
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+	// LoadShedding rejects low-priority reads while the database or queue
+	// is overloaded; the zero value never sheds.
+	LoadShedding LoadShedding
+	// MaintenanceRefresh is how often the maintenance switch is re-read from
+	// Redis.
+	MaintenanceRefresh time.Duration
+	// ResponseCacheTTL is how long customer lists, stats and reports are
+	// served from the shared response cache; zero disables it.
+	ResponseCacheTTL time.Duration
+	// QueueRateWindow is how far back the enqueue and dequeue rates in
+	// /admin/stats and /metrics are averaged.
+	QueueRateWindow time.Duration
+	// Instance identifies this instance in /health.
+	Instance InstanceInfo
+	// PreStopDelay is how long /quitquitquit keeps serving after failing
+	// readiness, so endpoints drop the pod first, and PreStopTimeout how
+	// long it then waits for in-flight payments. PreStopToken, when set,
+	// lets callers other than the pod itself use it.
+	PreStopDelay   time.Duration
+	PreStopTimeout time.Duration
+	PreStopToken   string
+	// USSD verifies the aggregator's callbacks; without it they are all
+	// refused.
+	USSD *ussd.Verifier
+
+	shedder          loadShedder
+	maintenanceCache maintenanceCache
+	draining         atomic.Bool
+	degraded         atomic.Bool
+	logger           *log.Logger
+	metrics          *tools.Metrics
+	clock            tools.Clock
+	middleware       []gin.HandlerFunc
+	router           *gin.Engine
 }
 
-func NewAPIServer(db *tools.DatabaseService, redis *tools.RedisService, processor *processors.PaymentProcessor) *APIServer {
+func NewAPIServer(db *tools.DatabaseService, redis *tools.RedisService, processor *processors.PaymentProcessor, opts ...Option) *APIServer {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Recovery())
-	router.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return fmt.Sprintf("[%s] %s %s %d %s\n",
-			param.TimeStamp.Format("2006-01-02 15:04:05"),
-			param.Method,
-			param.Path,
-			param.StatusCode,
-			param.Latency,
-		)
-	}))
 
 	server := &APIServer{
-		db:        db,
-		redis:     redis,
-		Processor: processor,
-		router:    router,
+		db:                    db,
+		redis:                 redis,
+		Processor:             processor,
+		PresignExpiry:         15 * time.Minute,
+		MaxBodyBytes:          10 << 20,
+		Compression:           true,
+		CacheControl:          defaultCacheControl(),
+		RouteTimeouts:         defaultRouteTimeouts(),
+		DuplicateMetadataKeys: []string{"phone", "email", "national_id", "bvn"},
+		PaymentIntentTTL:      30 * time.Minute,
+		MaintenanceRefresh:    5 * time.Second,
+		ResponseCacheTTL:      5 * time.Second,
+		QueueRateWindow:       5 * time.Minute,
+		metrics:               tools.DefaultMetrics,
+		clock:                 tools.SystemClock{},
+		router:                router,
+	}
+	for _, opt := range opts {
+		opt(server)
 	}
 
+	accessLog := gin.DefaultWriter
+	if server.logger != nil {
+		accessLog = server.logger.WriterLevel(log.InfoLevel)
+	}
+
+	router.Use(gin.Recovery())
+	router.Use(traceMiddleware())
+	router.Use(localeMiddleware())
+	router.Use(server.drainMiddleware())
+	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{
+		Output: accessLog,
+		Formatter: func(param gin.LogFormatterParams) string {
+			return fmt.Sprintf("[%s] %s %s %d %s\n",
+				param.TimeStamp.Format("2006-01-02 15:04:05"),
+				param.Method,
+				param.Path,
+				param.StatusCode,
+				param.Latency,
+			)
+		},
+	}))
+	router.Use(server.maintenanceMiddleware())
+	router.Use(server.invalidateResponseCacheMiddleware())
+	router.Use(server.bodyLimitMiddleware())
+	router.Use(server.compressionMiddleware())
+	router.Use(server.timeoutMiddleware())
+	router.Use(server.middleware...)
+
 	server.setupRoutes()
 	return server
 }
 
+func traceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		traceID := c.GetHeader("X-Request-ID")
+		if !tools.ValidTraceID(traceID) {
+			traceID = tools.NewTraceID()
+		}
+		c.Header("X-Request-ID", traceID)
+		c.Request = c.Request.WithContext(tools.WithTraceID(c.Request.Context(), traceID))
+		c.Next()
+	}
+}
+
+func localeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locale := i18n.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+		c.Set("locale", locale)
+		c.Header("Content-Language", locale)
+		c.Next()
+	}
+}
+
+func msg(c *gin.Context, key string, args ...interface{}) string {
+	return i18n.Translate(c.GetString("locale"), key, args...)
+}
+
 func (s *APIServer) setupRoutes() {
+	// Lists, reports and exports give way to payment submission under load.
+	lowPriority := s.shedWhenOverloaded()
+	// Dashboards poll these; repeats within the TTL come from Redis.
+	cached := s.cacheResponse()
+
 	s.router.GET("/", s.handleRoot)
-	s.router.GET("/api/v1/health", s.handleHealth)
-	s.router.POST("/api/v1/payments", s.handlePayment)
-	s.router.GET("/api/v1/customers/:customer_id/balance", s.handleGetBalance)
-	s.router.GET("/api/v1/customers", s.handleListCustomers)
-	s.router.POST("/api/v1/admin/seed-customers", s.handleSeedCustomers)
-	s.router.GET("/api/v1/admin/stats", s.handleStats)
+	s.router.GET("/metrics", s.handleMetrics)
+	s.router.GET("/quitquitquit", s.handlePreStop)
+	s.router.POST("/quitquitquit", s.handlePreStop)
+	s.router.GET("/admin", s.handleAdminUI)
+	s.router.GET("/admin/*filepath", s.handleAdminUI)
+	s.router.GET(tools.LocalBlobPath+"/*key", s.handleGetBlob)
+
+	v1 := s.router.Group("/api/v1")
+	v1.GET("/health", s.handleHealth)
+	v1.GET("/ready", s.handleReady)
+
+	deprecated := v1.Group("", s.deprecationMiddleware())
+	deprecated.POST("/payments", s.handlePayment)
+	s.setupCustomerRoutes(deprecated)
+
+	v1.POST("/self-service/balance", s.handleSelfServiceBalance)
+	v1.POST("/self-service/preferences", s.handleSelfServicePreferences)
+
+	v1.POST("/payouts", s.handleCreatePayout)
+	v1.GET("/payouts", lowPriority, s.handleListPayouts)
+	v1.GET("/payouts/:reference", s.handleGetPayout)
+	v1.POST("/payouts/callback/:provider", s.handlePayoutCallback)
+
+	v1.POST("/payment-intents", s.handleCreatePaymentIntent)
+	v1.GET("/payment-intents/:reference", s.handleGetPaymentIntent)
+	v1.POST("/payment-intents/:reference/cancel", s.handleCancelPaymentIntent)
+
+	v1.POST("/bank-feeds/:provider/webhook", s.handleBankFeedWebhook)
+	v1.POST("/cards/callback/:gateway", s.handleCardCallback)
+	v1.POST("/voice/callback/:provider", s.handleVoiceCallback)
+	v1.GET("/whatsapp/webhook", s.handleWhatsAppSubscribe)
+	v1.POST("/whatsapp/webhook", s.handleWhatsAppWebhook)
+
+	v1.GET("/webhook-subscriptions", s.handleListWebhookSubscriptions)
+	v1.POST("/webhook-subscriptions", s.handleCreateWebhookSubscription)
+	v1.GET("/webhook-subscriptions/:id", s.handleGetWebhookSubscription)
+	v1.PUT("/webhook-subscriptions/:id", s.handleUpdateWebhookSubscription)
+	v1.DELETE("/webhook-subscriptions/:id", s.handleDeleteWebhookSubscription)
+	v1.POST("/webhook-subscriptions/:id/test", s.handleTestWebhookSubscription)
+	v1.GET("/webhook-subscriptions/:id/deliveries", lowPriority, s.handleListWebhookDeliveries)
+	v1.GET("/webhook-subscriptions/:id/keys", s.handleListWebhookKeys)
+	v1.POST("/webhook-subscriptions/:id/keys", s.handleRotateWebhookKey)
+	v1.POST("/webhook-subscriptions/:id/keys/:key_id/expire", s.handleExpireWebhookKey)
+	v1.POST("/webhook-subscriptions/:id/replay", s.handleReplayWebhookSubscription)
+	v1.GET("/events", lowPriority, s.handleListEvents)
+
+	v1.GET("/collections/worklist", lowPriority, s.handleWorklist)
+	v1.POST("/collections/worklist/:customer_id/assign", s.handleAssignWorklist)
+	v1.POST("/collections/worklist/:customer_id/snooze", s.handleSnoozeWorklist)
+	v1.POST("/collections/worklist/:customer_id/resolve", s.handleResolveWorklist)
+
+	menu := ussd.NewMenu(s.db, s.redis)
+	v1.POST("/ussd/africastalking", s.verifyUSSD(), ussd.AfricasTalkingHandler(menu))
+	v1.POST("/ussd/callback", s.verifyUSSD(), ussd.JSONHandler(menu))
+
+	admin := v1.Group("/admin")
+	admin.POST("/seed-customers", s.handleSeedCustomers)
+	admin.POST("/drain", s.handleDrain)
+	admin.GET("/maintenance", s.handleGetMaintenance)
+	admin.POST("/maintenance", s.handleSetMaintenance)
+	admin.GET("/stats", lowPriority, cached, s.handleStats)
+	admin.POST("/replay", s.handleReplay)
+	admin.GET("/payments/:reference/attempts", s.handleListPaymentAttempts)
+	admin.GET("/workers", s.handleListWorkers)
+	admin.POST("/balance-cache/invalidate", s.handleInvalidateBalanceCache)
+	admin.GET("/db/connection", s.handleDBConnection)
+	admin.POST("/db/reconnect", s.handleDBReconnect)
+	admin.GET("/snapshots/:date", lowPriority, s.handleGetSnapshot)
+	admin.POST("/snapshots/:date/export", lowPriority, s.handleExportSnapshot)
+	admin.GET("/agents", s.handleListAgents)
+	admin.POST("/agents", s.handleCreateAgent)
+	admin.GET("/agents/:agent_id", s.handleGetAgent)
+	admin.PUT("/agents/:agent_id/scheme", s.handleSetAgentScheme)
+	admin.GET("/agents/:agent_id/cash-position", s.handleAgentCashPosition)
+	admin.POST("/agents/:agent_id/deposits", s.handleDeclareAgentDeposit)
+	admin.GET("/agents/:agent_id/deposits", s.handleListAgentDeposits)
+	admin.GET("/agent-deposits", lowPriority, s.handleListAgentDeposits)
+	admin.POST("/agent-deposits/:id/confirm", s.handleConfirmAgentDeposit)
+	admin.POST("/agent-deposits/:id/reject", s.handleRejectAgentDeposit)
+	admin.GET("/commission-schemes", s.handleListCommissionSchemes)
+	admin.POST("/commission-schemes", s.handleSaveCommissionScheme)
+	admin.GET("/commission-schemes/:scheme_id", s.handleGetCommissionScheme)
+	admin.PUT("/commission-schemes/:scheme_id", s.handleSaveCommissionScheme)
+	admin.GET("/commission-runs", lowPriority, s.handleListCommissionRuns)
+	admin.POST("/commission-runs", s.handleCalculateCommissionRun)
+	admin.GET("/commission-runs/:id", s.handleGetCommissionRun)
+	admin.GET("/commission-runs/:id/export", lowPriority, s.handleExportCommissionRun)
+	admin.POST("/commission-runs/:id/approve", s.handleApproveCommissionRun)
+	admin.GET("/products", s.handleListLoanProducts)
+	admin.POST("/products", s.handleCreateLoanProduct)
+	admin.PUT("/customers/:customer_id/product", s.handleAssignLoanProduct)
+	admin.PUT("/customers/:customer_id/calendar", s.handleAssignCalendar)
+	admin.PUT("/customers/:customer_id/asset", s.handleAssignAsset)
+	admin.POST("/customers/:customer_id/accounts", s.handleOpenAccount)
+	admin.POST("/customers/:customer_id/virtual-account", s.handleProvisionVirtualAccount)
+	admin.POST("/virtual-accounts/:account_number/close", s.handleCloseVirtualAccount)
+	admin.GET("/card-charges", lowPriority, s.handleListCardCharges)
+	admin.GET("/dunning", lowPriority, s.handleListDunningCases)
+	admin.GET("/assets", s.handleListAssets)
+	admin.POST("/assets", s.handleSaveAsset)
+	admin.GET("/assets/:asset_type", s.handleGetAsset)
+	admin.PUT("/assets/:asset_type", s.handleSaveAsset)
+	admin.GET("/fee-types", s.handleListFeeTypes)
+	admin.POST("/fee-types", s.handleSaveFeeType)
+	admin.PUT("/fee-types/:fee_type", s.handleSaveFeeType)
+	admin.GET("/calendars", s.handleListHolidayCalendars)
+	admin.POST("/calendars", s.handleSaveHolidayCalendar)
+	admin.GET("/calendars/:code", s.handleGetHolidayCalendar)
+	admin.PUT("/calendars/:code", s.handleSaveHolidayCalendar)
+	admin.DELETE("/calendars/:code", s.handleDeleteHolidayCalendar)
+	admin.PUT("/calendars/:code/holidays/:date", s.handleSaveHoliday)
+	admin.DELETE("/calendars/:code/holidays/:date", s.handleDeleteHoliday)
+	admin.GET("/groups", s.handleListGroups)
+	admin.POST("/groups", s.handleCreateGroup)
+	admin.GET("/groups/:group_id", s.handleGetGroup)
+	admin.PUT("/groups/:group_id/members/:customer_id", s.handleAddGroupMember)
+	admin.DELETE("/groups/:group_id/members/:customer_id", s.handleRemoveGroupMember)
+	admin.POST("/customers/:customer_id/restructurings", s.handleRequestRestructuring)
+	admin.POST("/restructurings/:id/approve", s.handleApproveRestructuring)
+	admin.POST("/restructurings/:id/reject", s.handleRejectRestructuring)
+	admin.POST("/customers/:customer_id/write-off", s.handleWriteOff)
+	admin.POST("/customers/:customer_id/voice-calls", s.handlePlaceVoiceCall)
+	admin.GET("/customers/:customer_id/voice-calls", s.handleListVoiceCalls)
+	admin.POST("/customers/:customer_id/payments/:reference/receipt/whatsapp", s.handleSendWhatsAppReceipt)
+	admin.POST("/customers/:customer_id/fees", s.handleChargeFee)
+	admin.GET("/customers/:customer_id/fees", s.handleListCustomerFees)
+	admin.POST("/customers/:customer_id/merge", s.handleMergeCustomer)
+	admin.GET("/customers/:customer_id/merges", s.handleListCustomerMerges)
+	admin.POST("/pii/rotate-data-key", s.handleRotatePIIKey)
+	admin.POST("/pii/rewrap", s.handleRewrapPIIKeys)
+	admin.POST("/pii/reencrypt", s.handleReencryptPII)
+	admin.POST("/retention/run", s.handleRunRetention)
+	admin.POST("/anonymized-exports", s.handleAnonymizedExport)
+	admin.POST("/anonymized-exports/:export_id/import", s.handleImportAnonymizedExport)
+	admin.GET("/imports", lowPriority, s.handleListImports)
+	admin.GET("/imports/:id", s.handleGetImport)
+	admin.POST("/imports/:id/cancel", s.handleCancelImport)
+	admin.POST("/imports/:id/reverse", s.handleRequestImportReversal)
+	admin.GET("/imports/:id/reversals", s.handleListImportReversals)
+	admin.POST("/import-reversals/:id/approve", s.handleApproveImportReversal)
+	admin.POST("/import-reversals/:id/reject", s.handleRejectImportReversal)
+	admin.GET("/disputes", lowPriority, s.handleListDisputes)
+	admin.GET("/disputes/:id", s.handleGetDispute)
+	admin.POST("/disputes/:id/investigate", s.handleInvestigateDispute)
+	admin.POST("/disputes/:id/resolve", s.handleResolveDispute)
+	admin.GET("/settlement-batches", lowPriority, s.handleListSettlementBatches)
+	admin.GET("/settlement-batches/:date", s.handleGetSettlementBatch)
+	admin.POST("/settlement-batches/:date/close", s.handleCloseSettlementBatch)
+	admin.POST("/settlement-batches/:date/reopen", s.handleReopenSettlementBatch)
+	admin.GET("/settlement-adjustments", lowPriority, s.handleListSettlementAdjustments)
+	admin.POST("/settlement-adjustments", s.handlePostSettlementAdjustment)
+	admin.GET("/gl/journal", lowPriority, s.handleGLJournal)
+	admin.GET("/gl/trial-balance", lowPriority, cached, s.handleTrialBalance)
+	admin.GET("/gl/exports", s.handleListGLExports)
+	admin.POST("/gl/exports", s.handlePushGLExport)
+	admin.POST("/settlements/poll", s.handlePollSettlements)
+	admin.GET("/bank-feeds/transactions", lowPriority, s.handleListBankFeedTransactions)
+	admin.POST("/bank-feeds/transactions/:id/assign", s.handleAssignBankFeedTransaction)
+	admin.POST("/bank-feeds/transactions/:id/ignore", s.handleIgnoreBankFeedTransaction)
+	admin.GET("/reports/agent-collections", lowPriority, cached, s.handleAgentCollections)
+	admin.GET("/reports/delinquency", lowPriority, cached, s.handleDelinquencyReport)
+	admin.GET("/reports/write-offs", lowPriority, cached, s.handleWriteOffReport)
+	admin.GET("/reports/promises", lowPriority, cached, s.handlePromiseReport)
+	admin.GET("/reports/risk", lowPriority, cached, s.handleRiskReport)
+	admin.GET("/reports/duplicate-customers", lowPriority, cached, s.handleDuplicateCustomers)
+	admin.GET("/reports/dispute-aging", lowPriority, cached, s.handleDisputeAgingReport)
+	admin.GET("/reports/fee-tax", lowPriority, cached, s.handleFeeTaxReport)
+	admin.GET("/reports/agent-cash", lowPriority, cached, s.handleAgentCashReport)
+	admin.GET("/screening/entries", s.handleListScreeningEntries)
+	admin.POST("/screening/entries", s.handleAddScreeningEntry)
+	admin.DELETE("/screening/entries/:id", s.handleDeleteScreeningEntry)
+	admin.GET("/screening/holds", lowPriority, s.handleListScreeningHolds)
+	admin.GET("/screening/holds/:id", s.handleGetScreeningHold)
+	admin.POST("/screening/holds/:id/release", s.handleReleaseScreeningHold)
+	admin.POST("/screening/holds/:id/reject", s.handleRejectScreeningHold)
+	admin.GET("/suspense", lowPriority, s.handleListSuspensePayments)
+	admin.GET("/suspense/:id", s.handleGetSuspensePayment)
+	admin.POST("/suspense/:id/assign", s.handleAssignSuspensePayment)
+	admin.POST("/suspense/:id/reject", s.handleRejectSuspensePayment)
+	admin.GET("/ledger-drift", lowPriority, s.handleListLedgerDrift)
+	admin.GET("/partitions", s.handleListPartitions)
+	admin.GET("/transaction-archives", lowPriority, s.handleListTransactionArchives)
+	admin.GET("/aml/alerts", lowPriority, s.handleListAMLAlerts)
+	admin.GET("/aml/alerts/:id", s.handleGetAMLAlert)
+	admin.POST("/aml/alerts/:id/review", s.handleReviewAMLAlert)
+	admin.POST("/aml/alerts/:id/disposition", s.handleDisposeAMLAlert)
+	admin.GET("/saved-reports", s.handleListSavedReports)
+	admin.PUT("/saved-reports/:report_id", s.handleSaveReport)
+	admin.GET("/saved-reports/:report_id", s.handleGetSavedReport)
+	admin.DELETE("/saved-reports/:report_id", s.handleDeleteSavedReport)
+	admin.POST("/saved-reports/:report_id/run", lowPriority, s.handleRunSavedReport)
+	admin.GET("/templates", s.handleListTemplates)
+	admin.GET("/templates/:template_id", s.handleGetTemplate)
+	admin.PUT("/templates/:template_id", s.handleSaveTemplate)
+	admin.POST("/templates/:template_id/preview", s.handlePreviewTemplate)
+	admin.POST("/templates/:template_id/test-send", s.handleTestSendTemplate)
+	admin.GET("/rules", s.handleListPaymentRules)
+	admin.POST("/rules", s.handleCreatePaymentRule)
+	admin.POST("/rules/test", s.handleTestPaymentRules)
+	admin.PUT("/rules/:rule_id", s.handleUpdatePaymentRule)
+	admin.DELETE("/rules/:rule_id", s.handleDeletePaymentRule)
+	admin.GET("/flags", s.handleListFlags)
+	admin.PUT("/flags/:name", s.handleSaveFlag)
+	admin.DELETE("/flags/:name", s.handleDeleteFlag)
+
+	v2 := s.router.Group("/api/v2")
+	v2.GET("/health", s.handleHealth)
+	v2.GET("/ready", s.handleReady)
+	v2.POST("/payments", s.handlePaymentV2)
+	v2.POST("/payments/split", s.handleSplitPayment)
+	v2.GET("/payments/:reference/splits", s.handleGetPaymentSplits)
+	v2.POST("/groups/:group_id/payments", s.handleGroupPayment)
+	s.setupCustomerRoutes(v2)
+}
+
+func (s *APIServer) setupCustomerRoutes(group *gin.RouterGroup) {
+	group.GET("/customers", s.shedWhenOverloaded(), s.cacheResponse(), s.handleListCustomers)
+	group.GET("/customers/resolve", s.handleResolveIdentifier)
+	group.GET("/customers/:customer_id/balance", s.handleGetBalance)
+	group.GET("/customers/:customer_id/accounts", s.handleCustomerAccounts)
+	group.GET("/customers/:customer_id/virtual-accounts", s.handleListVirtualAccounts)
+	group.GET("/customers/:customer_id/cards", s.handleListCards)
+	group.POST("/customers/:customer_id/cards/setup", s.handleStartCardSetup)
+	group.DELETE("/customers/:customer_id/cards/:card_id", s.handleRevokeCard)
+	group.GET("/customers/:customer_id/dunning", s.handleGetDunning)
+	group.GET("/customers/:customer_id/payments/:reference/receipt", s.handlePaymentReceipt)
+	group.GET("/customers/:customer_id/payoff", s.handlePayoffQuote)
+	group.GET("/customers/:customer_id/stats", s.handleCustomerStats)
+	group.GET("/customers/:customer_id/restructurings", s.handleListRestructurings)
+	group.GET("/customers/:customer_id/promises", s.handleListPromises)
+	group.POST("/customers/:customer_id/promises", s.handleCreatePromise)
+	group.POST("/customers/:customer_id/payment-reference", s.handleCreatePaymentReference)
+	group.GET("/customers/:customer_id/contact", s.handleGetContact)
+	group.PATCH("/customers/:customer_id/contact", s.handleUpdateContact)
+	group.PUT("/customers/:customer_id/consent", s.handleUpdateConsent)
+	group.GET("/customers/:customer_id/notification-preferences", s.handleGetNotificationPreferences)
+	group.PATCH("/customers/:customer_id/notification-preferences", s.handleUpdateNotificationPreferences)
+	group.POST("/customers/:customer_id/contact/verify/start", s.handleStartContactVerification)
+	group.POST("/customers/:customer_id/contact/verify", s.handleVerifyContact)
+	group.PATCH("/customers/:customer_id", s.handleUpdateCustomer)
+	group.GET("/customers/:customer_id/identifiers", s.handleListIdentifiers)
+	group.POST("/customers/:customer_id/identifiers", s.handleAddIdentifier)
+	group.DELETE("/customers/:customer_id/identifiers/:type/:value", s.handleDeleteIdentifier)
+	group.GET("/customers/:customer_id/timeline", s.handleCustomerTimeline)
+	group.GET("/customers/:customer_id/notes", s.handleListCustomerNotes)
+	group.POST("/customers/:customer_id/notes", s.handleCreateCustomerNote)
+	group.GET("/customers/:customer_id/notes/:note_id", s.handleGetCustomerNote)
+	group.PATCH("/customers/:customer_id/notes/:note_id", s.handleUpdateCustomerNote)
+	group.DELETE("/customers/:customer_id/notes/:note_id", s.handleDeleteCustomerNote)
+	group.GET("/customers/:customer_id/payments/:reference/notes", s.handleListCustomerNotes)
+	group.POST("/customers/:customer_id/payments/:reference/notes", s.handleCreateCustomerNote)
+	group.GET("/customers/:customer_id/disputes", s.handleListCustomerDisputes)
+	group.POST("/customers/:customer_id/payments/:reference/disputes", s.handleOpenDispute)
+}
+
+// verifyUSSD refuses USSD callbacks the aggregator can't be shown to have
+// sent, and all of them when no verification is configured: the menu
+// answers with account details for whatever phone number it is given.
+func (s *APIServer) verifyUSSD() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.USSD.Verify(c.Request) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Unverified USSD callback"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *APIServer) deprecationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if !s.V1Sunset.IsZero() {
+			c.Header("Sunset", s.V1Sunset.UTC().Format(http.TimeFormat))
+		}
+		successor := strings.Replace(c.Request.URL.Path, "/api/v1/", "/api/v2/", 1)
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		c.Next()
+	}
 }
 
 func (s *APIServer) handleRoot(c *gin.Context) {
@@ -62,23 +532,90 @@ func (s *APIServer) handleRoot(c *gin.Context) {
 	})
 }
 
+// acceptUnverified reports whether a payment should be queued without its
+// customer check after err: DegradedWrites is on and the database is
+// unreachable rather than the customer missing.
+func (s *APIServer) acceptUnverified(err error) bool {
+	return s.DegradedWrites && tools.IsUnavailable(err)
+}
+
+// queueUnverifiedPayment queues a payment whose customer couldn't be looked
+// up. The processor checks the customer once the database is back and
+// sends the payment to suspense if there is no such customer; intent and
+// screening checks run there too.
+func (s *APIServer) queueUnverifiedPayment(c *gin.Context, payment api.PaymentPayload, lookupErr error) {
+	ctx := c.Request.Context()
+	if payment.Metadata == nil {
+		payment.Metadata = api.Metadata{}
+	}
+	payment.Metadata[api.MetadataCustomerUnverified] = true
+
+	if err := s.redis.EnqueuePayment(ctx, &payment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg(c, i18n.MsgQueueFailed)})
+		return
+	}
+	s.metrics.Inc("payments_accepted_unverified_total", 1)
+	log.Warnf("Payment %s for %s queued unverified, database unavailable: %v", payment.TransactionReference, payment.CustomerID, lookupErr)
+
+	c.JSON(http.StatusAccepted, api.PaymentResponse{
+		Status:               "queued",
+		Message:              msg(c, i18n.MsgPaymentQueued),
+		TransactionReference: payment.TransactionReference,
+		CustomerID:           payment.CustomerID,
+		Metadata:             payment.Metadata,
+	})
+}
+
 func (s *APIServer) handleHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"status":    "healthy",
-		"timestamp": time.Now().Format(time.RFC3339),
-	})
+		"timestamp": s.clock.Now().Format(time.RFC3339),
+	}
+	if s.Instance.ID != "" {
+		response["instance"] = s.Instance
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (s *APIServer) handlePayment(c *gin.Context) {
 	var payment api.PaymentPayload
-	if err := c.ShouldBindJSON(&payment); err != nil {
+	if !validation.BindJSON(c, &payment) {
+		return
+	}
+
+	s.acceptPayment(c, payment)
+}
+
+func (s *APIServer) handlePaymentV2(c *gin.Context) {
+	var request api.PaymentPayloadV2
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	s.acceptPayment(c, request.ToPayload())
+}
+
+func (s *APIServer) acceptPayment(c *gin.Context, payment api.PaymentPayload) {
+	if err := payment.Metadata.Validate(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if !s.resolvePaymentCustomer(c, &payment) {
+		return
+	}
+
+	if payment.AgentID != "" {
+		agent, err := s.db.GetAgent(c.Request.Context(), payment.AgentID)
+		if (err != nil && !s.acceptUnverified(err)) || (err == nil && !agent.Active) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or inactive agent_id"})
+			return
+		}
+	}
+
 	if payment.PaymentStatus != api.StatusComplete {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Only COMPLETE payments accepted. Received: %s", payment.PaymentStatus),
+			"error": msg(c, i18n.MsgOnlyCompleteAllowed, payment.PaymentStatus),
 		})
 		return
 	}
@@ -95,9 +632,10 @@ func (s *APIServer) handlePayment(c *gin.Context) {
 		customer, _ := s.db.GetCustomer(ctx, payment.CustomerID)
 		response := api.PaymentResponse{
 			Status:               "duplicate",
-			Message:              "Transaction already processed",
+			Message:              msg(c, i18n.MsgPaymentDuplicate),
 			TransactionReference: payment.TransactionReference,
 			CustomerID:           payment.CustomerID,
+			Metadata:             payment.Metadata,
 		}
 		if customer != nil {
 			response.RemainingBalance = &customer.OutstandingBalance
@@ -107,16 +645,64 @@ func (s *APIServer) handlePayment(c *gin.Context) {
 	}
 
 	customer, err := s.db.GetCustomer(ctx, payment.CustomerID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+	if err != nil && s.acceptUnverified(err) {
+		s.queueUnverifiedPayment(c, payment, err)
 		return
 	}
+	if err != nil {
+		// Partners may still send the ID of a duplicate merged away.
+		survivorID, mergeErr := s.db.MergedInto(ctx, payment.CustomerID)
+		if mergeErr == nil {
+			customer, err = s.db.GetCustomer(ctx, survivorID)
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+			return
+		}
+		payment.CustomerID = survivorID
+	}
+
+	if s.Intents != nil {
+		err := s.Intents.Check(ctx, &payment)
+		if errors.Is(err, intents.ErrIntentMismatch) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Intent check failed for %s: %v", payment.TransactionReference, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to check payment intent, please retry"})
+			return
+		}
+	}
+
+	if s.Screener != nil {
+		hold, err := s.Screener.Screen(ctx, &payment)
+		if err != nil {
+			log.Printf("Screening failed for %s: %v", payment.TransactionReference, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to screen payment, please retry"})
+			return
+		}
+		if hold != nil {
+			c.JSON(http.StatusAccepted, api.PaymentResponse{
+				Status:               "held",
+				Message:              msg(c, i18n.MsgPaymentHeld),
+				TransactionReference: payment.TransactionReference,
+				CustomerID:           payment.CustomerID,
+				Metadata:             payment.Metadata,
+			})
+			return
+		}
+	}
 
 	if err := s.redis.EnqueuePayment(ctx, &payment); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue payment"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg(c, i18n.MsgQueueFailed)})
 		return
 	}
 
+	if err := s.db.ArchivePayment(ctx, &payment); err != nil {
+		log.Printf("Warning: failed to archive payment %s: %v", payment.TransactionReference, err)
+	}
+
 	cachedBalance, _ := s.redis.GetCachedBalance(ctx, payment.CustomerID)
 	currentBalance := customer.OutstandingBalance
 	if cachedBalance != nil {
@@ -125,40 +711,208 @@ func (s *APIServer) handlePayment(c *gin.Context) {
 
 	c.JSON(http.StatusOK, api.PaymentResponse{
 		Status:               "accepted",
-		Message:              "Payment accepted for processing",
+		Message:              msg(c, i18n.MsgPaymentAccepted),
 		TransactionReference: payment.TransactionReference,
 		CustomerID:           payment.CustomerID,
 		RemainingBalance:     &currentBalance,
+		Metadata:             payment.Metadata,
 	})
 }
 
 func (s *APIServer) handleGetBalance(c *gin.Context) {
 	customerID := c.Param("customer_id")
 	ctx := c.Request.Context()
+	asOfParam := c.Query("as_of")
+	locale := c.GetString("locale")
+	now := s.clock.Now()
+
+	// Revalidation from polling clients only needs the version, so skip the
+	// full account read when it still matches.
+	if asOfParam == "" && c.GetHeader("If-None-Match") != "" {
+		if version, err := s.db.GetCustomerVersion(ctx, customerID); err == nil {
+			if s.checkNotModified(c, cacheRouteBalance, balanceETag(customerID, version, locale, now)) {
+				return
+			}
+		}
+	}
 
 	customer, err := s.db.GetCustomer(ctx, customerID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
 		return
 	}
 
-	completionPct := (customer.TotalPaid / customer.AssetValue) * 100
+	if asOfParam != "" {
+		s.handleGetBalanceAsOf(c, customer, asOfParam)
+		return
+	}
+
+	if s.checkNotModified(c, cacheRouteBalance, balanceETag(customer.CustomerID, customer.Version, locale, now)) {
+		return
+	}
+
+	completionPct := (customer.TotalPaid / schedule.TotalRepayable(customer)) * 100
+
+	display := gin.H{
+		"outstanding_balance": i18n.FormatAmount(locale, customer.OutstandingBalance),
+		"total_paid":          i18n.FormatAmount(locale, customer.TotalPaid),
+	}
+	if customer.LastPaymentDate != nil {
+		display["last_payment_date"] = i18n.FormatDate(locale, *customer.LastPaymentDate)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"customer_id":           customer.CustomerID,
+		"holder_id":             customer.HolderID,
 		"asset_value":           customer.AssetValue,
 		"total_paid":            customer.TotalPaid,
 		"outstanding_balance":   customer.OutstandingBalance,
 		"payment_count":         customer.PaymentCount,
 		"completion_percentage": fmt.Sprintf("%.2f", completionPct),
 		"last_payment_date":     customer.LastPaymentDate,
+		"metadata":              customer.Metadata,
+		"product_id":            customer.ProductID,
+		"asset_type":            customer.AssetType,
+		"version":               customer.Version,
+		"total_repayable":       schedule.TotalRepayable(customer),
+		"accrued_interest":      schedule.AccruedInterest(customer, now),
+		"weekly_amount":         schedule.WeeklyAmount(customer),
+		"restructured_at":       customer.RestructuredAt,
+		"written_off_at":        customer.WrittenOffAt,
+		"written_off_amount":    customer.WrittenOffAmount,
+		"recovered_amount":      customer.RecoveredAmount,
+		"risk_score":            customer.RiskScore,
+		"projected_payoff_date": customer.ProjectedPayoffDate,
+		"risk_scored_at":        customer.RiskScoredAt,
+		"display":               display,
 	})
 }
 
+func (s *APIServer) handleGetBalanceAsOf(c *gin.Context, customer *api.CustomerAccount, asOfParam string) {
+	asOf, err := parseTimeParam(asOfParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of: " + err.Error()})
+		return
+	}
+
+	if asOf.Before(customer.DeploymentDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of is before the asset deployment date"})
+		return
+	}
+
+	paid, err := s.db.GetPaidAsOf(c.Request.Context(), customer.CustomerID, asOf)
+	if err != nil {
+		log.Printf("Failed to reconstruct balance for %s: %v", customer.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconstruct balance"})
+		return
+	}
+
+	totalRepayable := schedule.TotalRepayable(customer)
+	outstanding := totalRepayable - paid.TotalPaid
+	if outstanding < 0 {
+		outstanding = 0
+	}
+	completionPct := (paid.TotalPaid / totalRepayable) * 100
+
+	response := gin.H{
+		"customer_id":           customer.CustomerID,
+		"as_of":                 asOf,
+		"asset_value":           customer.AssetValue,
+		"total_paid":            paid.TotalPaid,
+		"outstanding_balance":   outstanding,
+		"payment_count":         paid.PaymentCount,
+		"completion_percentage": fmt.Sprintf("%.2f", completionPct),
+		"last_payment_date":     paid.LastPaymentDate,
+	}
+	if paid.ArchivedPayments > 0 || paid.Incomplete {
+		response["archived"] = true
+		response["archived_payments"] = paid.ArchivedPayments
+		// Payments archived around as_of can't be reconstructed to the
+		// second; they are left out rather than guessed.
+		response["incomplete"] = paid.Incomplete
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *APIServer) handleUpdateCustomer(c *gin.Context) {
+	var request struct {
+		Metadata api.Metadata `json:"metadata"`
+		Region   *string      `json:"region" binding:"omitempty,max=50"`
+		Branch   *string      `json:"branch" binding:"omitempty,max=50"`
+	}
+
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	customer, err := s.db.GetCustomer(ctx, c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	if expectedVersion != nil && *expectedVersion != customer.Version {
+		preconditionFailed(c, customer.Version)
+		return
+	}
+
+	update := tools.CustomerUpdate{Region: request.Region, Branch: request.Branch, Version: expectedVersion}
+
+	if request.Metadata != nil {
+		merged := api.Metadata{}
+		for key, value := range customer.Metadata {
+			merged[key] = value
+		}
+		for key, value := range request.Metadata {
+			if value == nil {
+				delete(merged, key)
+				continue
+			}
+			merged[key] = value
+		}
+
+		if err := merged.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		update.Metadata = merged
+	}
+
+	updated, err := s.db.UpdateCustomer(ctx, customer.CustomerID, update)
+	if errors.Is(err, tools.ErrVersionConflict) {
+		// Lost a race with another write between the read and the update.
+		current, _ := s.db.GetCustomerVersion(ctx, customer.CustomerID)
+		preconditionFailed(c, current)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update customer %s: %v", customer.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer"})
+		return
+	}
+
+	c.Header("ETag", versionETag(updated.Version))
+	c.JSON(http.StatusOK, updated)
+}
+
 func (s *APIServer) Run(addr string) error {
 	return s.router.Run(addr)
 }
 
+// Handler exposes the routes for serving from an embedding program's own
+// http.Server.
+func (s *APIServer) Handler() http.Handler {
+	return s.router
+}
+
 func (s *APIServer) handleListCustomers(c *gin.Context) {
 	ctx := c.Request.Context()
 
@@ -176,55 +930,42 @@ func (s *APIServer) handleListCustomers(c *gin.Context) {
 		limit = 100
 	}
 
-	query := `
-		SELECT customer_id, asset_value, term_weeks, total_paid, 
-		       outstanding_balance, deployment_date, last_payment_date, 
-		       payment_count, version
-		FROM customer_accounts
-		ORDER BY customer_id
-		LIMIT $1 OFFSET $2
-	`
+	filter := tools.CustomerFilter{
+		Region:    c.Query("region"),
+		Branch:    c.Query("branch"),
+		AssetType: c.Query("asset_type"),
+	}
 
-	rows, err := s.db.Pool.Query(ctx, query, limit, offset)
-	log.Error(err)
+	accounts, total, err := s.db.ListCustomers(ctx, filter, limit, offset)
 	if err != nil {
+		log.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch customers"})
 		return
 	}
-	defer rows.Close()
 
-	customers := []gin.H{}
-	for rows.Next() {
-		var customer api.CustomerAccount
-		err := rows.Scan(
-			&customer.CustomerID,
-			&customer.AssetValue,
-			&customer.TermWeeks,
-			&customer.TotalPaid,
-			&customer.OutstandingBalance,
-			&customer.DeploymentDate,
-			&customer.LastPaymentDate,
-			&customer.PaymentCount,
-			&customer.Version,
-		)
-		if err != nil {
-			continue
-		}
+	if s.checkNotModified(c, cacheRouteCustomers, customerListETag(accounts, total)) {
+		return
+	}
 
-		completionPct := (customer.TotalPaid / customer.AssetValue) * 100
+	customers := []gin.H{}
+	for _, customer := range accounts {
+		completionPct := (customer.TotalPaid / schedule.TotalRepayable(customer)) * 100
 		customers = append(customers, gin.H{
 			"customer_id":           customer.CustomerID,
+			"holder_id":             customer.HolderID,
 			"asset_value":           customer.AssetValue,
 			"total_paid":            customer.TotalPaid,
 			"outstanding_balance":   customer.OutstandingBalance,
 			"payment_count":         customer.PaymentCount,
 			"completion_percentage": fmt.Sprintf("%.2f", completionPct),
+			"metadata":              customer.Metadata,
+			"region":                customer.Region,
+			"branch":                customer.Branch,
+			"product_id":            customer.ProductID,
+			"asset_type":            customer.AssetType,
 		})
 	}
 
-	var total int
-	s.db.Pool.QueryRow(ctx, "SELECT COUNT(*) FROM customer_accounts").Scan(&total)
-
 	c.JSON(http.StatusOK, gin.H{
 		"customers": customers,
 		"total":     total,
@@ -237,15 +978,41 @@ func (s *APIServer) handleSeedCustomers(c *gin.Context) {
 	ctx := c.Request.Context()
 
 	var request struct {
-		Count int `json:"count" binding:"required,min=1,max=10000"`
+		Count       int       `json:"count" binding:"required,min=1,max=10000"`
+		AssetValues []float64 `json:"asset_values" binding:"max=50,dive,gt=0"`
+		TermWeeks   []int     `json:"term_weeks" binding:"max=50,dive,min=1,max=520"`
+		AssetTypes  []string  `json:"asset_types" binding:"max=50,dive,required,max=50"`
+		MaxAgeDays  int       `json:"max_age_days" binding:"omitempty,min=1,max=3650"`
+		PaidPercent float64   `json:"paid_percent" binding:"min=0,max=100"`
+		OnTimeRate  *float64  `json:"on_time_rate" binding:"omitempty,min=0,max=1"`
 	}
 
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !validation.BindJSON(c, &request) {
 		return
 	}
 
-	if err := s.db.SeedCustomers(ctx, request.Count); err != nil {
+	for _, assetType := range request.AssetTypes {
+		if _, err := s.db.GetAsset(ctx, assetType); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown asset type %q", assetType)})
+			return
+		}
+	}
+
+	opts := tools.SeedOptions{
+		Count:       request.Count,
+		AssetValues: request.AssetValues,
+		TermWeeks:   request.TermWeeks,
+		AssetTypes:  request.AssetTypes,
+		MaxAgeDays:  request.MaxAgeDays,
+		PaidPercent: request.PaidPercent,
+		OnTimeRate:  0.85,
+	}
+	if request.OnTimeRate != nil {
+		opts.OnTimeRate = *request.OnTimeRate
+	}
+
+	result, err := s.db.SeedCustomers(ctx, opts)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -253,8 +1020,9 @@ func (s *APIServer) handleSeedCustomers(c *gin.Context) {
 	count, _ := s.db.GetCustomerCount(ctx)
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":         "Customers seeded successfully",
+		"message":         msg(c, i18n.MsgCustomersSeeded),
 		"requested":       request.Count,
+		"seeded":          result,
 		"total_customers": count,
 	})
 }
@@ -266,7 +1034,7 @@ func (s *APIServer) handleStats(c *gin.Context) {
 		SELECT 
 			COUNT(*) as total_customers,
 			COUNT(*) FILTER (WHERE total_paid > 0) as active_customers,
-			COUNT(*) FILTER (WHERE outstanding_balance = 0) as completed_customers,
+			COUNT(*) FILTER (WHERE outstanding_balance = 0 AND written_off_at IS NULL) as completed_customers,
 			COALESCE(SUM(asset_value), 0) as total_deployed_value,
 			COALESCE(SUM(total_paid), 0) as total_paid_amount,
 			COALESCE(SUM(outstanding_balance), 0) as total_outstanding,
@@ -284,7 +1052,7 @@ func (s *APIServer) handleStats(c *gin.Context) {
 		AvgCompletionRate  float64 `json:"avg_completion_rate"`
 	}
 
-	err := s.db.Pool.QueryRow(ctx, query).Scan(
+	err := s.db.QueryRow(ctx, query).Scan(
 		&stats.TotalCustomers,
 		&stats.ActiveCustomers,
 		&stats.CompletedCustomers,
@@ -299,15 +1067,53 @@ func (s *APIServer) handleStats(c *gin.Context) {
 		return
 	}
 
-	queueSize, _ := s.redis.Client.LLen(ctx, "payment_queue").Result()
+	queue := gin.H{}
+	if queueStats, err := s.redis.QueueStats(ctx, s.QueueRateWindow); err == nil {
+		queue["size"] = queueStats.Depth
+		queue["oldest_age_seconds"] = queueStats.OldestAgeSeconds
+		queue["enqueue_rate"] = queueStats.EnqueueRate
+		queue["dequeue_rate"] = queueStats.DequeueRate
+		queue["rate_window"] = queueStats.Window
+	} else {
+		log.Printf("Failed to read queue stats: %v", err)
+		queue["size"], _ = s.redis.QueueDepth(ctx)
+	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"database": stats,
-		"queue": gin.H{
-			"size": queueSize,
-		},
+		"queue":    queue,
 		"workers": gin.H{
-			"count": s.Processor.WorkerCount,
+			"count":      s.Processor.WorkerCount,
+			"throughput": s.Processor.Throughput(),
 		},
-	})
+	}
+	if s.Processor.SLA != nil {
+		response["sla"] = s.Processor.SLA.Stats()
+	}
+
+	if regions, err := s.db.GetRegionalRollup(ctx, c.Query("region")); err == nil {
+		response["regions"] = regions
+	} else {
+		log.Printf("Failed to build regional rollup: %v", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *APIServer) handleMetrics(c *gin.Context) {
+	if queueStats, err := s.redis.QueueStats(c.Request.Context(), s.QueueRateWindow); err == nil {
+		s.metrics.Set("payment_queue_depth", float64(queueStats.Depth))
+		s.metrics.Set("payment_queue_oldest_age_seconds", queueStats.OldestAgeSeconds)
+		s.metrics.Set("payment_queue_enqueue_rate", queueStats.EnqueueRate)
+		s.metrics.Set("payment_queue_dequeue_rate", queueStats.DequeueRate)
+	} else {
+		queueSize, _ := s.redis.QueueDepth(c.Request.Context())
+		s.metrics.Set("payment_queue_depth", float64(queueSize))
+	}
+
+	s.db.RecordPoolMetrics(s.metrics)
+
+	c.Header("Content-Type", "text/plain; version=0.0.4")
+	c.Status(http.StatusOK)
+	s.metrics.WriteTo(c.Writer)
 }
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleListAssets(c *gin.Context) {
+	assets, err := s.db.ListAssets(c.Request.Context(), c.Query("active") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch assets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"assets": assets})
+}
+
+func (s *APIServer) handleGetAsset(c *gin.Context) {
+	asset, err := s.db.GetAsset(c.Request.Context(), c.Param("asset_type"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, asset)
+}
+
+func (s *APIServer) handleSaveAsset(c *gin.Context) {
+	var request api.Asset
+	// On PUT the path names the asset: set it before binding so the
+	// required check passes, and again after so the body cannot rename it.
+	if assetType := c.Param("asset_type"); assetType != "" {
+		request.AssetType = assetType
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if assetType := c.Param("asset_type"); assetType != "" {
+		request.AssetType = assetType
+	}
+
+	asset, err := s.db.SaveAsset(c.Request.Context(), &request)
+	if err != nil {
+		log.Printf("Failed to save asset %s: %v", request.AssetType, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save asset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, asset)
+}
+
+func (s *APIServer) handleAssignAsset(c *gin.Context) {
+	var request struct {
+		AssetType string `json:"asset_type" binding:"max=50"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if request.AssetType != "" {
+		if _, err := s.db.GetAsset(ctx, request.AssetType); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+			return
+		}
+	}
+
+	updated, err := s.db.AssignCustomerAsset(ctx, c.Param("customer_id"), request.AssetType)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to assign asset %s to %s: %v", request.AssetType, c.Param("customer_id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign asset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer": updated})
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/bankfeeds"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type bankFeedReview struct {
+	ReviewedBy string `json:"reviewed_by" binding:"required,max=100"`
+	Note       string `json:"note" binding:"max=500"`
+}
+
+// handleBankFeedWebhook receives transaction notifications from bank feed
+// providers. Matched credits are queued as payments; the rest go to review.
+func (s *APIServer) handleBankFeedWebhook(c *gin.Context) {
+	provider := c.Param("provider")
+	if s.BankFeeds == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bank feeds are not configured"})
+		return
+	}
+	adapter, ok := s.BankFeeds.Adapter(provider)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown bank feed provider"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	if !adapter.Verify(c.Request.Header, body) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	transactions, err := adapter.Parse(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := s.BankFeeds.Ingest(c.Request.Context(), provider, transactions)
+	if err != nil {
+		// A 5xx makes the provider redeliver; lines already recorded are
+		// skipped as duplicates on the retry.
+		log.Printf("Failed to ingest %s bank feed: %v", provider, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ingest transactions", "result": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *APIServer) handleListBankFeedTransactions(c *gin.Context) {
+	limit := 50
+	offset := 0
+
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if o := c.Query("offset"); o != "" {
+		fmt.Sscanf(o, "%d", &offset)
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	status := api.BankFeedStatus(c.DefaultQuery("status", string(api.BankFeedUnmatched)))
+	if status == "all" {
+		status = ""
+	}
+
+	transactions, err := s.db.ListBankFeedTransactions(c.Request.Context(), status, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bank feed transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transactions": transactions, "limit": limit, "offset": offset})
+}
+
+func (s *APIServer) handleAssignBankFeedTransaction(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction id"})
+		return
+	}
+
+	var request struct {
+		bankFeedReview
+		CustomerID string `json:"customer_id" binding:"required,max=50"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	if s.BankFeeds == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bank feeds are not configured"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := s.db.GetCustomer(ctx, request.CustomerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	txn, err := s.BankFeeds.Assign(ctx, id, request.CustomerID, request.ReviewedBy, request.Note)
+	if err != nil {
+		s.bankFeedReviewError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, txn)
+}
+
+func (s *APIServer) handleIgnoreBankFeedTransaction(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction id"})
+		return
+	}
+
+	var request bankFeedReview
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	if s.BankFeeds == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bank feeds are not configured"})
+		return
+	}
+
+	txn, err := s.BankFeeds.Ignore(c.Request.Context(), id, request.ReviewedBy, request.Note)
+	if err != nil {
+		s.bankFeedReviewError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, txn)
+}
+
+func (s *APIServer) bankFeedReviewError(c *gin.Context, id int64, err error) {
+	if errors.Is(err, bankfeeds.ErrAlreadyReviewed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transaction has already been reviewed"})
+		return
+	}
+	if errors.Is(err, tools.ErrNotFound) {
+		if _, getErr := s.db.GetBankFeedTransaction(c.Request.Context(), id); getErr == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Transaction has already been reviewed"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+	log.Printf("Failed to review bank feed transaction %d: %v", id, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review transaction"})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleGetBlob serves a local blob to a link LocalBlobStore.PresignGet
+// signed. The link is the credential, so the route sits outside the admin
+// group; S3 and GCS links go to the bucket instead.
+func (s *APIServer) handleGetBlob(c *gin.Context) {
+	store, ok := s.Storage.(*tools.LocalBlobStore)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blobs are not served by this API"})
+		return
+	}
+
+	key := c.Param("key")
+	if err := store.VerifyGet(key, c.Query("expires"), c.Query("signature"), s.clock.Now()); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Link is invalid or has expired"})
+		return
+	}
+
+	body, err := store.Get(c.Request.Context(), key)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blob not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read blob %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read blob"})
+		return
+	}
+	defer body.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", "attachment; filename="+path.Base(key))
+	c.DataFromReader(http.StatusOK, -1, contentType, body, nil)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/calendar"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleListHolidayCalendars(c *gin.Context) {
+	calendars, err := s.db.ListHolidayCalendars(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch holiday calendars"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"calendars": calendars})
+}
+
+func (s *APIServer) handleGetHolidayCalendar(c *gin.Context) {
+	cal, err := s.db.GetHolidayCalendar(c.Request.Context(), c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Holiday calendar not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cal)
+}
+
+// handleSaveHolidayCalendar creates a calendar (POST) or replaces its
+// settings (PUT /calendars/:code). Holidays are managed separately.
+func (s *APIServer) handleSaveHolidayCalendar(c *gin.Context) {
+	var request api.HolidayCalendar
+	// On PUT the path names the calendar: set it before binding so the
+	// required check passes, and again after so the body cannot rename it.
+	if code := c.Param("code"); code != "" {
+		request.Code = code
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if code := c.Param("code"); code != "" {
+		request.Code = code
+	}
+
+	if request.Weekend == nil {
+		request.Weekend = []string{"SAT", "SUN"}
+	}
+	weekend, err := calendar.ParseWeekend(strings.Join(request.Weekend, ","))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "weekend: " + err.Error()})
+		return
+	}
+	request.Weekend = request.Weekend[:0]
+	for _, day := range weekend {
+		request.Weekend = append(request.Weekend, strings.ToUpper(day.String()[:3]))
+	}
+
+	saved, err := s.db.SaveHolidayCalendar(c.Request.Context(), &request)
+	if err != nil {
+		log.Printf("Failed to save holiday calendar %s: %v", request.Code, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save holiday calendar"})
+		return
+	}
+
+	status := http.StatusOK
+	if c.Request.Method == http.MethodPost {
+		status = http.StatusCreated
+	}
+	c.JSON(status, saved)
+}
+
+func (s *APIServer) handleDeleteHolidayCalendar(c *gin.Context) {
+	err := s.db.DeleteHolidayCalendar(c.Request.Context(), c.Param("code"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Holiday calendar not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete holiday calendar %s: %v", c.Param("code"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete holiday calendar"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *APIServer) handleSaveHoliday(c *gin.Context) {
+	var request struct {
+		Name string `json:"name" binding:"max=100"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	date := c.Param("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	code := c.Param("code")
+	if _, err := s.db.GetHolidayCalendar(ctx, code); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Holiday calendar not found"})
+		return
+	}
+
+	holiday := api.Holiday{Date: date, Name: request.Name}
+	if err := s.db.SaveHoliday(ctx, code, holiday); err != nil {
+		log.Printf("Failed to save holiday %s in calendar %s: %v", date, code, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save holiday"})
+		return
+	}
+
+	c.JSON(http.StatusOK, holiday)
+}
+
+func (s *APIServer) handleDeleteHoliday(c *gin.Context) {
+	err := s.db.DeleteHoliday(c.Request.Context(), c.Param("code"), c.Param("date"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Holiday not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete holiday %s from calendar %s: %v", c.Param("date"), c.Param("code"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete holiday"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// handleAssignCalendar puts a customer on a holiday calendar; an empty code
+// returns them to the default calendar.
+func (s *APIServer) handleAssignCalendar(c *gin.Context) {
+	var request struct {
+		CalendarCode string `json:"calendar_code" binding:"max=32"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	code := request.CalendarCode
+	if code != "" {
+		if _, err := s.db.GetHolidayCalendar(ctx, code); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Holiday calendar not found"})
+			return
+		}
+	}
+
+	updated, err := s.db.AssignCustomerCalendar(ctx, c.Param("customer_id"), code)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to assign calendar %s to %s: %v", code, c.Param("customer_id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign holiday calendar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer":         updated,
+		"next_installment": schedule.NextInstallment(updated, s.clock.Now(), s.customerCalendar(ctx, updated)),
+	})
+}
+
+// customerCalendar is the calendar for shifting the customer's due dates. A
+// failure to load it is logged and due dates are left unshifted.
+func (s *APIServer) customerCalendar(ctx context.Context, customer *api.CustomerAccount) *calendar.Calendar {
+	cal, err := s.db.CustomerCalendar(ctx, customer)
+	if err != nil {
+		log.Printf("Warning: failed to load holiday calendar for %s: %v", customer.CustomerID, err)
+		return nil
+	}
+	return cal
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/cards"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleStartCardSetup returns the gateway page where the customer enters
+// their card. The gateway reports the resulting token to the card callback.
+func (s *APIServer) handleStartCardSetup(c *gin.Context) {
+	if s.CardGateway == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card payments are not configured"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	reference := fmt.Sprintf("CARD-SETUP-%s-%d", customerID, s.db.Now().Unix())
+	url, err := s.CardGateway.StartSetup(ctx, customerID, reference)
+	if err != nil {
+		log.Printf("Failed to start card setup for %s: %v", customerID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start card setup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"setup_url": url, "reference": reference, "gateway": s.CardGateway.Name()})
+}
+
+// handleCardCallback saves the token the gateway issued for a customer's
+// card. Anything that looks like a card number is refused.
+func (s *APIServer) handleCardCallback(c *gin.Context) {
+	if s.CardGateway == nil || c.Param("gateway") != s.CardGateway.Name() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card payments are not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if !s.CardGateway.VerifyCallback(c.Request.Header, body) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid callback signature"})
+		return
+	}
+
+	var request struct {
+		api.CardTokenRequest
+		CustomerID string `json:"customer_id" binding:"required,max=50"`
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if cards.LooksLikePAN(request.Token) {
+		log.Warnf("Refused card callback for %s: token looks like a card number", request.CustomerID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token must be a gateway token, not a card number"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := s.db.GetCustomer(ctx, request.CustomerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	card := &api.CardToken{
+		CustomerID: request.CustomerID,
+		Gateway:    s.CardGateway.Name(),
+		Token:      request.Token,
+		Brand:      request.Brand,
+		Last4:      request.Last4,
+		ExpMonth:   request.ExpMonth,
+		ExpYear:    request.ExpYear,
+	}
+	err = s.db.SaveCardToken(ctx, card)
+	if errors.Is(err, tools.ErrCardTokenExists) {
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save card for %s: %v", request.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save card"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, card)
+}
+
+func (s *APIServer) handleListCards(c *gin.Context) {
+	tokens, err := s.db.ListCardTokens(c.Request.Context(), c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cards"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cards": tokens})
+}
+
+// handleRevokeCard stops automatic charging on the card and cancels its
+// pending charges.
+func (s *APIServer) handleRevokeCard(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("card_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid card id"})
+		return
+	}
+
+	card, err := s.db.RevokeCardToken(c.Request.Context(), c.Param("customer_id"), id)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to revoke card %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke card"})
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
+
+func (s *APIServer) handleListCardCharges(c *gin.Context) {
+	limit := 50
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	charges, err := s.db.ListCardCharges(c.Request.Context(), c.Query("customer_id"), c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch card charges"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"charges": charges, "limit": limit})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleWorklist(c *gin.Context) {
+	filter := tools.WorklistFilter{
+		AgentID:    c.Query("agent_id"),
+		Region:     c.Query("region"),
+		Branch:     c.Query("branch"),
+		Unassigned: c.Query("unassigned") == "true",
+	}
+
+	limit := 200
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit > 2000 {
+		limit = 2000
+	}
+
+	items, err := s.db.GetCollectionsWorklist(c.Request.Context(), filter, limit)
+	if err != nil {
+		log.Printf("Failed to build collections worklist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build worklist"})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=worklist-%s.csv", s.clock.Now().Format("2006-01-02")))
+		c.Status(http.StatusOK)
+		tools.WriteWorklistCSV(c.Writer, items)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"generated_at": s.clock.Now(),
+		"accounts":     items,
+		"total":        len(items),
+	})
+}
+
+func (s *APIServer) handleAssignWorklist(c *gin.Context) {
+	var request struct {
+		AgentID string `json:"agent_id"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if request.AgentID != "" {
+		agent, err := s.db.GetAgent(ctx, request.AgentID)
+		if err != nil || !agent.Active {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or inactive agent_id"})
+			return
+		}
+	}
+
+	if err := s.db.AssignCollection(ctx, customerID, request.AgentID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": customerID, "agent_id": request.AgentID})
+}
+
+func (s *APIServer) handleSnoozeWorklist(c *gin.Context) {
+	var request struct {
+		Until string `json:"until"`
+		Days  int    `json:"days" binding:"omitempty,gt=0,lte=90"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	until := s.clock.Now().AddDate(0, 0, request.Days)
+	if request.Until != "" {
+		parsed, err := parseTimeParam(request.Until)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until: " + err.Error()})
+			return
+		}
+		until = parsed
+	}
+	if !until.After(s.clock.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "until or days is required and must be in the future"})
+		return
+	}
+
+	customerID := c.Param("customer_id")
+	if err := s.db.SnoozeCollection(c.Request.Context(), customerID, until); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": customerID, "snoozed_until": until})
+}
+
+func (s *APIServer) handleResolveWorklist(c *gin.Context) {
+	var request struct {
+		Resolution string `json:"resolution" binding:"required,max=500"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	customerID := c.Param("customer_id")
+	if err := s.db.ResolveCollection(c.Request.Context(), customerID, request.Resolution); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": customerID, "resolution": request.Resolution})
+}
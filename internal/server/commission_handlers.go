@@ -0,0 +1,270 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleListCommissionSchemes(c *gin.Context) {
+	schemes, err := s.db.ListCommissionSchemes(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch commission schemes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schemes": schemes})
+}
+
+func (s *APIServer) handleGetCommissionScheme(c *gin.Context) {
+	scheme, err := s.db.GetCommissionScheme(c.Request.Context(), c.Param("scheme_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Commission scheme not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, scheme)
+}
+
+// handleSaveCommissionScheme creates or updates a commission scheme. Runs
+// already calculated keep the commission worked out under the old terms.
+func (s *APIServer) handleSaveCommissionScheme(c *gin.Context) {
+	var request api.CommissionScheme
+	// As with assets, the path names the scheme on PUT.
+	if schemeID := c.Param("scheme_id"); schemeID != "" {
+		request.SchemeID = schemeID
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if schemeID := c.Param("scheme_id"); schemeID != "" {
+		request.SchemeID = schemeID
+	}
+	if err := tools.ValidateCommissionTiers(request.Tiers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scheme, err := s.db.SaveCommissionScheme(c.Request.Context(), &request)
+	if err != nil {
+		log.Printf("Failed to save commission scheme %s: %v", request.SchemeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save commission scheme"})
+		return
+	}
+
+	c.JSON(http.StatusOK, scheme)
+}
+
+// handleSetAgentScheme puts an agent on a commission scheme, or back on
+// their flat commission rate with an empty scheme_id.
+func (s *APIServer) handleSetAgentScheme(c *gin.Context) {
+	var request struct {
+		SchemeID string `json:"scheme_id" binding:"max=50"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if request.SchemeID != "" {
+		if _, err := s.db.GetCommissionScheme(ctx, request.SchemeID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown commission scheme"})
+			return
+		}
+	}
+
+	agent, err := s.db.SetAgentScheme(ctx, c.Param("agent_id"), request.SchemeID)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to set commission scheme of agent %s: %v", c.Param("agent_id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set commission scheme"})
+		return
+	}
+
+	c.JSON(http.StatusOK, agent)
+}
+
+// handleCalculateCommissionRun calculates agents' commission over a period,
+// replacing an earlier calculation of the same period until it is approved.
+func (s *APIServer) handleCalculateCommissionRun(c *gin.Context) {
+	var request struct {
+		PeriodStart  string `json:"period_start" binding:"required"`
+		PeriodEnd    string `json:"period_end" binding:"required"`
+		CalculatedBy string `json:"calculated_by" binding:"required,max=100"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	from, err := time.Parse("2006-01-02", request.PeriodStart)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period_start must be YYYY-MM-DD"})
+		return
+	}
+	to, err := time.Parse("2006-01-02", request.PeriodEnd)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period_end must be YYYY-MM-DD"})
+		return
+	}
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period_start must not be after period_end"})
+		return
+	}
+
+	run, err := s.db.CalculateCommissionRun(c.Request.Context(), from, to, request.CalculatedBy)
+	if errors.Is(err, tools.ErrCommissionRunApproved) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Commission run for this period is already approved"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to calculate commission run %s to %s: %v", request.PeriodStart, request.PeriodEnd, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate commission run"})
+		return
+	}
+
+	log.Printf("Commission run %d for %s to %s calculated by %s: %d agents, %.2f payable",
+		run.ID, run.PeriodStart, run.PeriodEnd, run.CalculatedBy, run.Agents, run.Total)
+	c.JSON(http.StatusCreated, run)
+}
+
+func (s *APIServer) handleListCommissionRuns(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	runs, err := s.db.ListCommissionRuns(c.Request.Context(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch commission runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+func (s *APIServer) commissionRun(c *gin.Context) (*api.CommissionRun, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid commission run ID"})
+		return nil, false
+	}
+
+	run, err := s.db.GetCommissionRun(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Commission run not found"})
+		return nil, false
+	}
+	return run, true
+}
+
+func (s *APIServer) handleGetCommissionRun(c *gin.Context) {
+	run, ok := s.commissionRun(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// handleExportCommissionRun downloads a run's lines as CSV.
+func (s *APIServer) handleExportCommissionRun(c *gin.Context) {
+	run, ok := s.commissionRun(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=commissions_%s_%s.csv", run.PeriodStart, run.PeriodEnd))
+	c.Status(http.StatusOK)
+	tools.WriteCommissionRunCSV(c.Writer, run)
+}
+
+// handleApproveCommissionRun approves a run and pays each agent's total out
+// to their phone as a commission payout. Approving an approved run again
+// retries the payouts that could not be created or queued; payout
+// references are fixed per run and agent, so none is paid twice.
+func (s *APIServer) handleApproveCommissionRun(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid commission run ID"})
+		return
+	}
+
+	var request struct {
+		ApprovedBy string `json:"approved_by" binding:"required,max=100"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	run, err := s.db.ApproveCommissionRun(ctx, id, request.ApprovedBy)
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Commission run not found"})
+		return
+	case errors.Is(err, tools.ErrCommissionRunApproved):
+		// Already approved: retry the outstanding payouts.
+	case err != nil:
+		log.Printf("Failed to approve commission run %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve commission run"})
+		return
+	default:
+		log.Printf("Commission run %d approved by %s: %.2f payable", run.ID, request.ApprovedBy, run.Total)
+	}
+
+	queued, unpaid := 0, []string{}
+	for _, line := range run.Lines {
+		if line.Total <= 0 || line.PayoutReference != "" {
+			continue
+		}
+		agent, err := s.db.GetAgent(ctx, line.AgentID)
+		if err != nil || agent.Phone == "" {
+			unpaid = append(unpaid, line.AgentID)
+			continue
+		}
+
+		payout, created, err := s.db.CreatePayout(ctx, &api.PayoutRequest{
+			Reference:   tools.CommissionPayoutReference(run.ID, line.AgentID),
+			PayoutType:  "commission",
+			AgentID:     line.AgentID,
+			Amount:      line.Total,
+			Method:      "mobile_money",
+			Destination: map[string]string{"phone": agent.Phone},
+		})
+		if err != nil {
+			log.Printf("Failed to create commission payout for agent %s in run %d: %v", line.AgentID, run.ID, err)
+			unpaid = append(unpaid, line.AgentID)
+			continue
+		}
+		if created || payout.Status == api.PayoutPending {
+			if err := s.redis.EnqueuePayout(ctx, payout.Reference); err != nil {
+				log.Printf("Failed to queue payout %s: %v", payout.Reference, err)
+				unpaid = append(unpaid, line.AgentID)
+				continue
+			}
+		}
+		if err := s.db.SetCommissionPayout(ctx, run.ID, line.AgentID, payout.Reference); err != nil {
+			log.Printf("Failed to record payout %s on commission run %d: %v", payout.Reference, run.ID, err)
+		}
+		line.PayoutReference = payout.Reference
+		queued++
+	}
+
+	tools.DefaultMetrics.Inc("commission_payouts_total", float64(queued))
+	c.JSON(http.StatusOK, gin.H{"run": run, "payouts_queued": queued, "unpaid_agents": unpaid})
+}
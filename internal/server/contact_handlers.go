@@ -0,0 +1,242 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleGetContact(c *gin.Context) {
+	contact, err := s.db.GetContactProfile(c.Request.Context(), c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+func (s *APIServer) handleUpdateContact(c *gin.Context) {
+	var update api.ContactUpdate
+	if !validation.BindJSON(c, &update) {
+		return
+	}
+
+	if update.Phone != nil {
+		normalized := tools.NormalizeIdentifier(api.IdentifierPhone, *update.Phone)
+		update.Phone = &normalized
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	contact, err := s.db.UpdateContactProfile(ctx, customerID, update)
+	if err != nil {
+		log.Printf("Failed to update contact for %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update contact"})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+func (s *APIServer) handleUpdateConsent(c *gin.Context) {
+	var update api.ConsentUpdate
+	if !validation.BindJSON(c, &update) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	contact, err := s.db.UpdateConsent(ctx, customerID, update)
+	if err != nil {
+		log.Printf("Failed to update consent for %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update consent"})
+		return
+	}
+
+	log.Printf("Consent updated for %s via %s: sms=%t email=%t", customerID, update.Source, contact.ConsentSMS, contact.ConsentEmail)
+	c.JSON(http.StatusOK, contact)
+}
+
+// handleStartContactVerification sends a one-time code to the phone or email
+// on file; confirming it with handleVerifyContact marks the address verified.
+func (s *APIServer) handleStartContactVerification(c *gin.Context) {
+	var request struct {
+		Channel string `json:"channel" binding:"required,oneof=sms email"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	if s.Notifier == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Notifications are not configured"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	contact, err := s.db.GetContactProfile(ctx, customerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	recipient := contact.Phone
+	if request.Channel == "email" {
+		recipient = contact.Email
+	}
+	if recipient == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No " + request.Channel + " contact on file"})
+		return
+	}
+
+	code, err := tools.GenerateOTP()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate code"})
+		return
+	}
+
+	stored, err := s.redis.StoreOTP(ctx, contactOTPSubject(customerID, request.Channel, recipient), code, otpTTL, otpCooldown)
+	if err != nil {
+		log.Printf("Failed to store OTP: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate code"})
+		return
+	}
+	if !stored {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "A code was sent recently, please wait before retrying"})
+		return
+	}
+
+	err = s.Notifier.Send(ctx, notifications.Notification{
+		Channel:     notifications.Channel(request.Channel),
+		Recipient:   recipient,
+		CustomerID:  customerID,
+		MessageKey:  i18n.MsgVerificationCode,
+		MessageArgs: []string{code},
+		Essential:   true,
+	})
+	if err != nil {
+		log.Printf("Failed to send verification code: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "otp_sent", "expires_in": int(otpTTL.Seconds())})
+}
+
+func (s *APIServer) handleVerifyContact(c *gin.Context) {
+	var request struct {
+		Channel string `json:"channel" binding:"required,oneof=sms email"`
+		OTP     string `json:"otp" binding:"required,len=6,numeric"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	contact, err := s.db.GetContactProfile(ctx, customerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	recipient := contact.Phone
+	if request.Channel == "email" {
+		recipient = contact.Email
+	}
+
+	// The subject includes the address so a code sent before a change of
+	// number can't verify the new one.
+	valid, err := s.redis.VerifyOTP(ctx, contactOTPSubject(customerID, request.Channel, recipient), request.OTP)
+	if err != nil {
+		log.Printf("OTP verification failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+
+	if err := s.db.MarkContactVerified(ctx, customerID, request.Channel); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark contact verified"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": customerID, "channel": request.Channel, "verified": true})
+}
+
+func contactOTPSubject(customerID, channel, recipient string) string {
+	return "contact:" + customerID + ":" + channel + ":" + recipient
+}
+
+func (s *APIServer) handleGetNotificationPreferences(c *gin.Context) {
+	preferences, err := s.db.GetNotificationPreferences(c.Request.Context(), c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+func (s *APIServer) handleUpdateNotificationPreferences(c *gin.Context) {
+	var request struct {
+		api.PreferencesUpdate
+		Source string `json:"source" binding:"required,max=100"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if !validQuietHours(c, request.PreferencesUpdate) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	preferences, err := s.db.UpdateNotificationPreferences(ctx, customerID, request.PreferencesUpdate, request.Source)
+	if err != nil {
+		log.Printf("Failed to update notification preferences for %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// validQuietHours requires quiet hours to be set or cleared as a pair.
+func validQuietHours(c *gin.Context, update api.PreferencesUpdate) bool {
+	if (update.QuietHoursStart == nil) != (update.QuietHoursEnd == nil) ||
+		(update.QuietHoursStart != nil && (*update.QuietHoursStart == "") != (*update.QuietHoursEnd == "")) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quiet_hours_start and quiet_hours_end must be given together"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/gin-gonic/gin"
+)
+
+var updateContracts = flag.Bool("update", false, "rewrite partner contract fixtures from current behaviour")
+
+// A contract is one request a partner sends us, with either the payment it
+// must bind to or the response it must get back. Fixtures live under
+// testdata/contracts/<partner>/. They are what partners code against: when
+// a test fails, the change breaks an integration. Only regenerate them with
+// -update once the partner has agreed to the new behaviour.
+type contract struct {
+	Description string           `json:"description"`
+	Request     contractRequest  `json:"request"`
+	Payment     json.RawMessage  `json:"payment,omitempty"`
+	Response    *contractOutcome `json:"response,omitempty"`
+}
+
+type contractRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	// RawBody is sent instead of Body for requests that are not valid JSON.
+	RawBody string `json:"raw_body,omitempty"`
+}
+
+type contractOutcome struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// contractBinders decode accepted payments the way each endpoint's handler
+// does, up to the point where the handler needs the database and queue.
+var contractBinders = map[string]func(c *gin.Context) (*api.PaymentPayload, error){
+	"/api/v1/payments": func(c *gin.Context) (*api.PaymentPayload, error) {
+		var payment api.PaymentPayload
+		if err := c.ShouldBindJSON(&payment); err != nil {
+			return nil, err
+		}
+		return &payment, payment.Metadata.Validate()
+	},
+	"/api/v2/payments": func(c *gin.Context) (*api.PaymentPayload, error) {
+		var request api.PaymentPayloadV2
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return nil, err
+		}
+		payment := request.ToPayload()
+		return &payment, payment.Metadata.Validate()
+	},
+}
+
+func TestPartnerContracts(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "contracts", "*", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no contract fixtures found")
+	}
+
+	server := NewAPIServer(nil, nil, nil)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(path, filepath.Join("testdata", "contracts")+string(filepath.Separator)), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tc contract
+			if err := json.Unmarshal(data, &tc); err != nil {
+				t.Fatalf("parse fixture: %v", err)
+			}
+
+			if tc.Response != nil {
+				checkResponseContract(t, server, &tc)
+			} else {
+				checkPaymentContract(t, &tc)
+			}
+
+			if *updateContracts {
+				out, err := json.MarshalIndent(tc, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, append(out, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func newContractRequest(t *testing.T, tc *contract) *http.Request {
+	t.Helper()
+	body := []byte(tc.Request.RawBody)
+	if tc.Request.RawBody == "" {
+		body = tc.Request.Body
+	}
+	req := httptest.NewRequest(tc.Request.Method, tc.Request.Path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range tc.Request.Headers {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+// checkPaymentContract checks an accepted request binds to the recorded
+// payment.
+func checkPaymentContract(t *testing.T, tc *contract) {
+	bind, ok := contractBinders[tc.Request.Path]
+	if !ok {
+		t.Fatalf("no binder for %s", tc.Request.Path)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = newContractRequest(t, tc)
+	payment, err := bind(c)
+	if err != nil {
+		t.Fatalf("partner request no longer accepted: %v", err)
+	}
+
+	got, err := json.Marshal(payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateContracts {
+		tc.Payment = got
+		return
+	}
+	assertJSONEqual(t, "payment", tc.Payment, got)
+}
+
+// checkResponseContract checks a rejected request gets the recorded status,
+// headers and body. Rejections happen before the handler touches the
+// database or queue, so the server runs without them.
+func checkResponseContract(t *testing.T, server *APIServer, tc *contract) {
+	rec := httptest.NewRecorder()
+	server.router.ServeHTTP(rec, newContractRequest(t, tc))
+
+	if *updateContracts {
+		tc.Response.Status = rec.Code
+		for key := range tc.Response.Headers {
+			tc.Response.Headers[key] = rec.Header().Get(key)
+		}
+		tc.Response.Body = rec.Body.Bytes()
+		return
+	}
+
+	if rec.Code != tc.Response.Status {
+		t.Errorf("status = %d, want %d", rec.Code, tc.Response.Status)
+	}
+	for key, want := range tc.Response.Headers {
+		if got := rec.Header().Get(key); got != want {
+			t.Errorf("header %s = %q, want %q", key, got, want)
+		}
+	}
+	assertJSONEqual(t, "response body", tc.Response.Body, rec.Body.Bytes())
+}
+
+func assertJSONEqual(t *testing.T, what string, want, got []byte) {
+	t.Helper()
+	var wantValue, gotValue interface{}
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatalf("fixture %s is not JSON: %v", what, err)
+	}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("%s is not JSON: %v\n%s", what, err, got)
+	}
+	if !reflect.DeepEqual(wantValue, gotValue) {
+		t.Errorf("%s changed:\n got: %s\nwant: %s", what, got, want)
+	}
+}
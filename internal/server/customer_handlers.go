@@ -0,0 +1,277 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleAddIdentifier(c *gin.Context) {
+	var request api.CustomerIdentifier
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	if existing, err := s.db.ResolveCustomerID(ctx, request.Type, request.Value); err == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       "Identifier already mapped to a customer",
+			"customer_id": existing,
+		})
+		return
+	}
+
+	mapping, err := s.db.AddCustomerIdentifier(ctx, customerID, request.Type, request.Value)
+	if err != nil {
+		log.Printf("Failed to add identifier for %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add identifier"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, mapping)
+}
+
+func (s *APIServer) handleListIdentifiers(c *gin.Context) {
+	mappings, err := s.db.ListCustomerIdentifiers(c.Request.Context(), c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identifiers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"identifiers": mappings})
+}
+
+func (s *APIServer) handleDeleteIdentifier(c *gin.Context) {
+	identifierType := api.IdentifierType(c.Param("type"))
+
+	deleted, err := s.db.DeleteCustomerIdentifier(c.Request.Context(), c.Param("customer_id"), identifierType, c.Param("value"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete identifier"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identifier not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *APIServer) handleResolveIdentifier(c *gin.Context) {
+	identifierType := api.IdentifierType(c.Query("type"))
+	value := c.Query("value")
+	if identifierType == "" || value == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type and value are required"})
+		return
+	}
+
+	customerID, err := s.db.ResolveCustomerID(c.Request.Context(), identifierType, value)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"type":        identifierType,
+		"value":       tools.NormalizeIdentifier(identifierType, value),
+		"customer_id": customerID,
+	})
+}
+
+func (s *APIServer) resolvePaymentCustomer(c *gin.Context, payment *api.PaymentPayload) bool {
+	if payment.CustomerID == "" && payment.CustomerIdentifier != nil {
+		customerID, err := s.db.ResolveCustomerID(c.Request.Context(), payment.CustomerIdentifier.Type, payment.CustomerIdentifier.Value)
+		if err != nil {
+			if !errors.Is(err, tools.ErrNotFound) {
+				log.Printf("Identifier resolution failed: %v", err)
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+			return false
+		}
+		payment.CustomerID = customerID
+
+		// A dynamic payment reference settles the intent it was issued
+		// for.
+		if identifier := payment.CustomerIdentifier; identifier.Type == api.IdentifierPartnerRef &&
+			payment.IntentReference == "" && tools.IsPaymentReference(identifier.Value) {
+			intent, err := s.db.GetPaymentIntent(c.Request.Context(), strings.ToUpper(strings.TrimSpace(identifier.Value)))
+			if err == nil && intent.CustomerID == customerID {
+				payment.IntentReference = intent.Reference
+			}
+		}
+	}
+
+	// From here on the payment is applied to the targeted account, which
+	// must belong to the customer.
+	if payment.AccountID != "" && payment.AccountID != payment.CustomerID {
+		account, err := s.db.GetCustomer(c.Request.Context(), payment.AccountID)
+		if err != nil || account.HolderID != payment.CustomerID {
+			c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgAccountNotFound)})
+			return false
+		}
+		payment.CustomerID = account.CustomerID
+	}
+	return true
+}
+
+// handleCustomerAccounts lists every account the customer holds with a
+// rollup of their combined position.
+func (s *APIServer) handleCustomerAccounts(c *gin.Context) {
+	ctx := c.Request.Context()
+	now := s.clock.Now()
+
+	accounts, err := s.db.ListHolderAccounts(ctx, c.Param("customer_id"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list accounts for %s: %v", c.Param("customer_id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounts"})
+		return
+	}
+
+	var assetValue, totalPaid, outstanding, arrears, weekly float64
+	open := 0
+	items := make([]gin.H, 0, len(accounts))
+	for _, account := range accounts {
+		accountArrears := schedule.Arrears(account, now, s.customerCalendar(ctx, account))
+		assetValue += account.AssetValue
+		totalPaid += account.TotalPaid
+		outstanding += account.OutstandingBalance
+		arrears += accountArrears
+		if account.OutstandingBalance > 0 && account.WrittenOffAt == nil {
+			open++
+			weekly += schedule.WeeklyAmount(account)
+		}
+		items = append(items, gin.H{
+			"account":       account,
+			"weekly_amount": schedule.WeeklyAmount(account),
+			"arrears":       accountArrears,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": c.Param("customer_id"),
+		"accounts":    items,
+		"rollup": gin.H{
+			"accounts":            len(accounts),
+			"open_accounts":       open,
+			"asset_value":         assetValue,
+			"total_paid":          totalPaid,
+			"outstanding_balance": outstanding,
+			"arrears":             arrears,
+			"weekly_amount":       weekly,
+		},
+	})
+}
+
+// handleOpenAccount finances another asset for an existing customer. The
+// asset type, if given, supplies the value and term not set in the request.
+func (s *APIServer) handleOpenAccount(c *gin.Context) {
+	var request struct {
+		AssetType      string  `json:"asset_type" binding:"max=50"`
+		AssetValue     float64 `json:"asset_value" binding:"omitempty,gt=0"`
+		TermWeeks      int     `json:"term_weeks" binding:"omitempty,min=1,max=520"`
+		DeploymentDate string  `json:"deployment_date"`
+		Region         string  `json:"region" binding:"max=50"`
+		Branch         string  `json:"branch" binding:"max=50"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	account := tools.NewAccount{
+		AssetType:      request.AssetType,
+		AssetValue:     request.AssetValue,
+		TermWeeks:      request.TermWeeks,
+		DeploymentDate: s.clock.Now(),
+		Region:         request.Region,
+		Branch:         request.Branch,
+	}
+
+	if request.DeploymentDate != "" {
+		deployed, err := parseTimeParam(request.DeploymentDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "deployment_date: " + err.Error()})
+			return
+		}
+		account.DeploymentDate = deployed
+	}
+
+	if request.AssetType != "" {
+		asset, err := s.db.GetAsset(ctx, request.AssetType)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+			return
+		}
+		if account.AssetValue == 0 {
+			account.AssetValue = asset.Price
+		}
+		if account.TermWeeks == 0 {
+			account.TermWeeks = asset.DefaultTermWeeks
+		}
+	}
+	if account.AssetValue == 0 || account.TermWeeks == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "asset_value and term_weeks are required without an asset_type"})
+		return
+	}
+
+	opened, err := s.db.OpenAccount(ctx, c.Param("customer_id"), account)
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	case errors.Is(err, tools.ErrNotPrimaryAccount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Failed to open account for %s: %v", c.Param("customer_id"), err)
+		c.JSON(errorStatus(err), gin.H{"error": "Failed to open account"})
+		return
+	}
+
+	log.Printf("Opened account %s for %s", opened.CustomerID, opened.HolderID)
+	c.JSON(http.StatusCreated, gin.H{
+		"account":          opened,
+		"weekly_amount":    schedule.WeeklyAmount(opened),
+		"next_installment": schedule.NextInstallment(opened, s.clock.Now(), s.customerCalendar(ctx, opened)),
+	})
+}
+
+// handleCustomerStats reports payment velocity for credit decisions on
+// repeat financing.
+func (s *APIServer) handleCustomerStats(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	customer, err := s.db.GetCustomer(ctx, c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	payments, err := s.db.GetPaymentEvents(ctx, customer.CustomerID)
+	if err != nil {
+		log.Printf("Failed to load payments for %s: %v", customer.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute payment statistics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule.PaymentVelocity(customer, payments, s.clock.Now(), s.customerCalendar(ctx, customer)))
+}
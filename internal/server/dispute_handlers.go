@@ -0,0 +1,222 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleOpenDispute opens a dispute on one of the customer's payments.
+// Archived payments can be disputed but only resolved by adjustment.
+func (s *APIServer) handleOpenDispute(c *gin.Context) {
+	var request api.DisputeRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	txn, err := s.db.GetTransaction(ctx, customerID, c.Param("reference"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	if err != nil && !errors.Is(err, tools.ErrTransactionArchived) {
+		log.Printf("Failed to look up payment %s: %v", c.Param("reference"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open dispute"})
+		return
+	}
+
+	dispute, err := s.db.OpenDispute(ctx, customerID, txn.TransactionReference, txn.Amount, request)
+	if errors.Is(err, tools.ErrDisputeExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment already has an unresolved dispute"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open dispute on %s: %v", txn.TransactionReference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open dispute"})
+		return
+	}
+
+	tools.DefaultMetrics.Inc("disputes_opened_total", 1)
+	c.JSON(http.StatusCreated, dispute)
+}
+
+func (s *APIServer) handleListCustomerDisputes(c *gin.Context) {
+	disputes, err := s.db.ListDisputes(c.Request.Context(), c.Query("status"), c.Param("customer_id"), 1000)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch disputes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": c.Param("customer_id"), "disputes": disputes})
+}
+
+func (s *APIServer) handleListDisputes(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	disputes, err := s.db.ListDisputes(c.Request.Context(), c.Query("status"), c.Query("customer_id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch disputes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"disputes": disputes})
+}
+
+func (s *APIServer) handleGetDispute(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dispute id"})
+		return
+	}
+
+	dispute, err := s.db.GetDispute(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+func (s *APIServer) handleInvestigateDispute(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dispute id"})
+		return
+	}
+
+	var request struct {
+		AssignedTo string `json:"assigned_to" binding:"required,max=100"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	dispute, err := s.db.InvestigateDispute(c.Request.Context(), id, request.AssignedTo)
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found"})
+		return
+	case errors.Is(err, tools.ErrDisputeResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": "Dispute is already resolved"})
+		return
+	case err != nil:
+		log.Printf("Failed to update dispute %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update dispute"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// handleResolveDispute closes the dispute and carries out its resolution:
+// a reversal takes the payment off the account at once, an adjustment is
+// queued as a payment. If queueing fails, resolving again with the same
+// adjustment queues it again.
+func (s *APIServer) handleResolveDispute(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dispute id"})
+		return
+	}
+
+	var resolution api.DisputeResolution
+	if !validation.BindJSON(c, &resolution) {
+		return
+	}
+
+	if resolution.Status == api.DisputeResolvedAgainst {
+		if resolution.Action != "" && resolution.Action != api.DisputeActionNone {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A dispute resolved against the customer takes no action"})
+			return
+		}
+		resolution.Action = api.DisputeActionNone
+	} else if resolution.Action != api.DisputeActionReversal && resolution.Action != api.DisputeActionAdjustment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be REVERSAL or ADJUSTMENT for a dispute resolved in the customer's favor"})
+		return
+	}
+	if (resolution.Action == api.DisputeActionAdjustment) != (resolution.AdjustmentAmount > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "adjustment_amount is required for, and only allowed with, an ADJUSTMENT"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	dispute, _, err := s.db.ResolveDispute(ctx, id, resolution)
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found"})
+		return
+	case errors.Is(err, tools.ErrDisputeResolved):
+		dispute, err = s.db.GetDispute(ctx, id)
+		if err != nil || dispute.ResolutionAction != api.DisputeActionAdjustment || resolution.Action != api.DisputeActionAdjustment {
+			c.JSON(http.StatusConflict, gin.H{"error": "Dispute is already resolved"})
+			return
+		}
+	case errors.Is(err, tools.ErrDisputedPaymentUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": "The payment is archived or already reversed; resolve with an ADJUSTMENT instead"})
+		return
+	case err != nil:
+		log.Printf("Failed to resolve dispute %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve dispute"})
+		return
+	}
+
+	if dispute.ResolutionAction == api.DisputeActionReversal {
+		if err := s.redis.InvalidateBalance(ctx, dispute.CustomerID); err != nil {
+			log.Warnf("Failed to invalidate cached balance of %s: %v", dispute.CustomerID, err)
+		}
+		tools.DefaultMetrics.Inc("dispute_payments_reversed_total", 1)
+	}
+
+	if dispute.ResolutionAction == api.DisputeActionAdjustment {
+		payment := tools.AdjustmentPayment(dispute, s.clock.Now())
+		if err := s.redis.EnqueuePayment(ctx, payment); err != nil {
+			log.Printf("Failed to queue adjustment %s: %v", dispute.AdjustmentReference, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Dispute resolved but the adjustment could not be queued; resolve it again to retry"})
+			return
+		}
+		if err := s.db.ArchivePayment(ctx, payment); err != nil {
+			log.Printf("Warning: failed to archive payment %s: %v", payment.TransactionReference, err)
+		}
+	}
+
+	log.Printf("Dispute %d on %s resolved %s by %s", id, dispute.TransactionReference, dispute.Status, dispute.ResolvedBy)
+	c.JSON(http.StatusOK, dispute)
+}
+
+// handleDisputeAgingReport buckets unresolved disputes by how long they
+// have been open.
+func (s *APIServer) handleDisputeAgingReport(c *gin.Context) {
+	asOf := s.clock.Now()
+	buckets, err := s.db.DisputeAging(c.Request.Context(), asOf)
+	if err != nil {
+		log.Printf("Failed to build dispute aging report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"as_of": asOf, "buckets": buckets})
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultDrainTimeout bounds POST /admin/drain when no timeout is given.
+const defaultDrainTimeout = 30 * time.Second
+
+// StartDraining marks the instance not ready so load balancers stop sending
+// it traffic. Requests still arriving are served, with Connection: close
+// so keep-alive clients reconnect to another instance.
+func (s *APIServer) StartDraining() {
+	if s.draining.CompareAndSwap(false, true) {
+		log.Println("Draining: instance marked not ready")
+		s.metrics.Set("instance_draining", 1)
+	}
+}
+
+// Draining reports whether StartDraining has been called.
+func (s *APIServer) Draining() bool {
+	return s.draining.Load()
+}
+
+// Drain marks the instance not ready and waits for the payments and payouts
+// this instance has taken off the queue to finish, up to ctx's deadline.
+// Payments not yet dequeued stay on the shared queue for other instances.
+func (s *APIServer) Drain(ctx context.Context) error {
+	s.StartDraining()
+
+	if s.Processor != nil {
+		if err := s.Processor.Drain(ctx); err != nil {
+			return err
+		}
+	}
+	if s.PayoutProcessor != nil {
+		if err := s.PayoutProcessor.Drain(ctx); err != nil {
+			return err
+		}
+	}
+	// The workers have stopped, so payments they dequeued but never acked
+	// can't still be applied here. Until then they stay put, for another
+	// instance to reclaim once this one is gone.
+	if s.redis != nil && s.redis.Instance != "" {
+		if _, err := s.redis.HandOffPayments(ctx, s.redis.Instance); err != nil {
+			return fmt.Errorf("hand off payments: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *APIServer) drainMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.Draining() {
+			c.Header("Connection", "close")
+		}
+		c.Next()
+	}
+}
+
+// handleReady is the readiness probe: 503 once the instance is draining or
+// while it is degraded, while /health keeps answering so the orchestrator
+// doesn't kill it early.
+func (s *APIServer) handleReady(c *gin.Context) {
+	if s.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	if s.Degraded() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "degraded"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+func (s *APIServer) handleDrain(c *gin.Context) {
+	timeout := defaultDrainTimeout
+	if param := c.Query("timeout"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a positive duration, e.g. 30s"})
+			return
+		}
+		timeout = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), timeout)
+	defer cancel()
+
+	if err := s.Drain(ctx); err != nil {
+		c.JSON(http.StatusAccepted, gin.H{
+			"status": "draining",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "drained"})
+}
+
+// InstanceInfo is where this instance runs, as the Kubernetes downward API
+// reports it.
+type InstanceInfo struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace,omitempty"`
+	Node      string `json:"node,omitempty"`
+}
+
+// handlePreStop is for the pod's preStop hook: it fails readiness, keeps
+// serving for PreStopDelay while endpoints drop the pod, then drains and
+// hands back unfinished payments before answering, so SIGTERM finds
+// nothing left to do. The pod itself may call it (an exec hook on
+// localhost); anyone else needs PreStopToken.
+func (s *APIServer) handlePreStop(c *gin.Context) {
+	if !s.preStopAllowed(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	log.Println("preStop: draining before termination")
+	s.StartDraining()
+	time.Sleep(s.PreStopDelay)
+
+	timeout := s.PreStopTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), timeout)
+	defer cancel()
+
+	if err := s.Drain(ctx); err != nil {
+		log.Warnf("preStop: drain incomplete: %v", err)
+		c.JSON(http.StatusAccepted, gin.H{
+			"status": "draining",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "drained"})
+}
+
+func (s *APIServer) preStopAllowed(c *gin.Context) bool {
+	if s.PreStopToken != "" {
+		token := c.GetHeader("X-PreStop-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.PreStopToken)) == 1 {
+			return true
+		}
+	}
+	// The peer address, not ClientIP: forwarded headers are not to be
+	// trusted here.
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetDunning returns the account's dunning case, or CURRENT if its
+// automatic debits have never failed.
+func (s *APIServer) handleGetDunning(c *gin.Context) {
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	dunning, err := s.db.GetDunningCase(ctx, customerID)
+	if errors.Is(err, tools.ErrNotFound) {
+		if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"customer_id": customerID, "status": api.DunningCurrent})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dunning status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dunning)
+}
+
+func (s *APIServer) handleListDunningCases(c *gin.Context) {
+	limit := 50
+	offset := 0
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if o := c.Query("offset"); o != "" {
+		fmt.Sscanf(o, "%d", &offset)
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	cases, err := s.db.ListDunningCases(c.Request.Context(), c.Query("status"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dunning cases"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cases": cases, "limit": limit, "offset": offset})
+}
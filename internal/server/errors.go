@@ -0,0 +1,21 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+)
+
+// errorStatus is the HTTP status for an error from tools: 404 for
+// tools.ErrNotFound, 409 for tools.ErrDuplicate and tools.ErrVersionConflict,
+// and 500 for anything else.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, tools.ErrDuplicate), errors.Is(err, tools.ErrVersionConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/gin-gonic/gin"
+)
+
+// Cache-Control keys for routes that serve ETags. Values are overridable via
+// APIServer.CacheControl.
+const (
+	cacheRouteBalance   = "balance"
+	cacheRouteCustomers = "customers"
+)
+
+func defaultCacheControl() map[string]string {
+	return map[string]string{
+		cacheRouteBalance:   "private, no-cache",
+		cacheRouteCustomers: "private, no-cache",
+	}
+}
+
+// balanceETag identifies a balance representation. The version covers every
+// account mutation; the date covers interest accrual and the locale covers the
+// localized display block.
+func balanceETag(customerID string, version int, locale string, now time.Time) string {
+	return fmt.Sprintf(`W/"%s-%d-%s-%s"`, customerID, version, now.UTC().Format("20060102"), locale)
+}
+
+func customerListETag(customers []*api.CustomerAccount, total int) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d", total)
+	for _, customer := range customers {
+		fmt.Fprintf(hash, "|%s:%d", customer.CustomerID, customer.Version)
+	}
+	return `W/"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// checkNotModified sets the ETag and the route's Cache-Control header and
+// answers 304 when the client already holds the current representation.
+func (s *APIServer) checkNotModified(c *gin.Context, route, etag string) bool {
+	c.Header("ETag", etag)
+	if cacheControl := s.CacheControl[route]; cacheControl != "" {
+		c.Header("Cache-Control", cacheControl)
+	}
+
+	if !etagMatches(c.GetHeader("If-None-Match"), etag) {
+		return false
+	}
+	c.AbortWithStatus(http.StatusNotModified)
+	return true
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// versionETag is the strong validator for an account version, accepted back
+// in If-Match on customer mutations.
+func versionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatchVersion parses the account version a client expects from If-Match.
+// It returns nil when the header is absent or "*", leaving the write
+// unconditional.
+func ifMatchVersion(c *gin.Context) (*int, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	if strings.HasPrefix(header, "W/") {
+		return nil, fmt.Errorf("If-Match requires a strong validator, got %s", header)
+	}
+
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil {
+		return nil, fmt.Errorf("If-Match must carry the account version, got %s", header)
+	}
+	return &version, nil
+}
+
+func preconditionFailed(c *gin.Context, currentVersion int) {
+	c.Header("ETag", versionETag(currentVersion))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":           "Account was modified since the supplied version",
+		"current_version": currentVersion,
+	})
+}
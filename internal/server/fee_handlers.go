@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleListFeeTypes(c *gin.Context) {
+	feeTypes, err := s.db.ListFeeTypes(c.Request.Context(), c.Query("active") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fee types"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"fee_types": feeTypes})
+}
+
+// handleSaveFeeType creates or updates a fee type and its tax
+// configuration. Fees already charged keep the tax they were charged with.
+func (s *APIServer) handleSaveFeeType(c *gin.Context) {
+	var request api.FeeType
+	// As with assets, the path names the fee type on PUT.
+	if feeType := c.Param("fee_type"); feeType != "" {
+		request.FeeType = feeType
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if feeType := c.Param("fee_type"); feeType != "" {
+		request.FeeType = feeType
+	}
+
+	feeType, err := s.db.SaveFeeType(c.Request.Context(), &request)
+	if err != nil {
+		log.Printf("Failed to save fee type %s: %v", request.FeeType, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save fee type"})
+		return
+	}
+
+	c.JSON(http.StatusOK, feeType)
+}
+
+// handleChargeFee charges a fee to the account, adding it with its tax to
+// the outstanding balance.
+func (s *APIServer) handleChargeFee(c *gin.Context) {
+	var request api.FeeChargeRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	charge, err := s.db.ChargeFee(ctx, customerID, request)
+	switch {
+	case errors.Is(err, tools.ErrFeeTypeInactive):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or inactive fee type"})
+		return
+	case errors.Is(err, tools.ErrFeeNotChargeable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Fees can't be charged to a written-off account"})
+		return
+	case err != nil:
+		log.Printf("Failed to charge fee to %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to charge fee"})
+		return
+	}
+
+	if err := s.redis.InvalidateBalance(ctx, customerID); err != nil {
+		log.Warnf("Failed to invalidate cached balance of %s: %v", customerID, err)
+	}
+
+	tools.DefaultMetrics.Inc("fees_charged_total", 1, "fee_type", charge.FeeType)
+	log.Printf("Fee %s charged to %s by %s: %.2f + %.2f %s", charge.Reference, customerID, charge.ChargedBy,
+		charge.NetAmount, charge.TaxAmount, charge.TaxCode)
+	c.JSON(http.StatusCreated, charge)
+}
+
+func (s *APIServer) handleListCustomerFees(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	charges, err := s.db.ListFeeCharges(c.Request.Context(), c.Param("customer_id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fees"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": c.Param("customer_id"), "fees": charges})
+}
+
+// handleFeeTaxReport totals fees and their tax by day, fee type and tax
+// code over the period.
+func (s *APIServer) handleFeeTaxReport(c *gin.Context) {
+	from, to, ok := s.reportPeriod(c)
+	if !ok {
+		return
+	}
+
+	days, err := s.db.GetFeeTaxReport(c.Request.Context(), from, to)
+	if err != nil {
+		log.Printf("Failed to build fee tax report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	var net, tax float64
+	taxByCode := map[string]float64{}
+	for _, day := range days {
+		net += day.NetAmount
+		tax += day.TaxAmount
+		taxByCode[day.TaxCode] += day.TaxAmount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":        from,
+		"to":          to,
+		"days":        days,
+		"total_net":   net,
+		"total_tax":   tax,
+		"tax_by_code": taxByCode,
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleListFlags shows every flag as the processor currently sees it:
+// configured defaults overlaid with admin overrides.
+func (s *APIServer) handleListFlags(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"flags": s.Flags.Flags(c.Request.Context())})
+}
+
+// handleSaveFlag sets an override. Processors pick it up within
+// FEATURE_FLAG_REFRESH.
+func (s *APIServer) handleSaveFlag(c *gin.Context) {
+	var flag api.FeatureFlag
+	if !validation.BindJSON(c, &flag) {
+		return
+	}
+	flag.Name = c.Param("name")
+	flag.UpdatedAt = s.clock.Now()
+
+	if err := s.redis.SaveFlag(c.Request.Context(), &flag); err != nil {
+		log.Printf("Failed to save feature flag %s: %v", flag.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save feature flag"})
+		return
+	}
+	s.Flags.Invalidate()
+
+	log.Printf("Feature flag %s set: enabled=%t percentage=%d targets=%v", flag.Name, flag.Enabled, flag.Percentage, flag.Targets)
+	c.JSON(http.StatusOK, flag)
+}
+
+func (s *APIServer) handleDeleteFlag(c *gin.Context) {
+	removed, err := s.redis.DeleteFlag(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		log.Printf("Failed to delete feature flag %s: %v", c.Param("name"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete feature flag"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feature flag override not found"})
+		return
+	}
+	s.Flags.Invalidate()
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxGLPeriodDays bounds the period of one journal.
+const maxGLPeriodDays = 366
+
+// parseGLPeriod reads from and to as YYYY-MM-DD, both inclusive, defaulting
+// to the current month so far.
+func (s *APIServer) parseGLPeriod(from, to string) (time.Time, time.Time, error) {
+	now := s.clock.Now()
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be YYYY-MM-DD")
+		}
+		end = t
+	}
+	start := time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be YYYY-MM-DD")
+		}
+		start = t
+	}
+	if start.After(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	if end.Sub(start) >= maxGLPeriodDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("period must be at most %d days", maxGLPeriodDays)
+	}
+	return start, end, nil
+}
+
+// handleGLJournal downloads the GL journal of a period, as CSV in the
+// requested format (csv, quickbooks or sage) or as JSON.
+func (s *APIServer) handleGLJournal(c *gin.Context) {
+	if s.GLExporter == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "GL export is not configured"})
+		return
+	}
+
+	from, to, err := s.parseGLPeriod(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", s.GLExporter.Format)
+	if format != "json" && !tools.ValidGLFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, csv, quickbooks or sage"})
+		return
+	}
+
+	lines, err := s.db.GLJournal(c.Request.Context(), from, to, s.GLExporter.Accounts)
+	if err != nil {
+		log.Printf("Failed to build GL journal: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build GL journal"})
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{"from": from.Format("2006-01-02"), "to": to.Format("2006-01-02"), "lines": lines})
+		return
+	}
+
+	journalFile, _ := tools.GLExportFileNames(from, to, format)
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename="+journalFile)
+	c.Status(http.StatusOK)
+	tools.WriteGLJournal(c.Writer, format, lines)
+}
+
+// handleTrialBalance returns each GL account's opening balance, movements
+// and closing balance over a period, as JSON or with ?format=csv.
+func (s *APIServer) handleTrialBalance(c *gin.Context) {
+	if s.GLExporter == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "GL export is not configured"})
+		return
+	}
+
+	from, to, err := s.parseGLPeriod(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	balance, err := s.db.TrialBalance(c.Request.Context(), from, to, s.GLExporter.Accounts)
+	if err != nil {
+		log.Printf("Failed to build trial balance: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build trial balance"})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		_, trialFile := tools.GLExportFileNames(from, to, "")
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment; filename="+trialFile)
+		c.Status(http.StatusOK)
+		tools.WriteTrialBalanceCSV(c.Writer, balance)
+		return
+	}
+
+	c.JSON(http.StatusOK, balance)
+}
+
+func (s *APIServer) handleListGLExports(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	exports, err := s.db.ListGLExports(c.Request.Context(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch GL exports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+// handlePushGLExport pushes a period's journal and trial balance to the
+// accounting system's SFTP drop, e.g. to resend one after a failed import.
+func (s *APIServer) handlePushGLExport(c *gin.Context) {
+	if s.GLExporter == nil || s.GLExporter.Target == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "GL export SFTP target is not configured"})
+		return
+	}
+
+	var request struct {
+		From       string `json:"from" binding:"required"`
+		To         string `json:"to" binding:"required"`
+		Format     string `json:"format" binding:"omitempty,oneof=csv quickbooks sage"`
+		ExportedBy string `json:"exported_by" binding:"required,max=100"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	from, to, err := s.parseGLPeriod(request.From, request.To)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Format == "" {
+		request.Format = s.GLExporter.Format
+	}
+
+	export, err := s.GLExporter.Push(c.Request.Context(), from, to, request.Format, request.ExportedBy)
+	if err != nil {
+		log.Printf("Failed to push GL export %s to %s: %v", request.From, request.To, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to push GL export"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, export)
+}
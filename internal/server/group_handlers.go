@@ -0,0 +1,194 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/groups"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleCreateGroup(c *gin.Context) {
+	var group api.CustomerGroup
+	if !validation.BindJSON(c, &group) {
+		return
+	}
+
+	if err := s.db.CreateCustomerGroup(c.Request.Context(), &group); err != nil {
+		log.Printf("Failed to create group %s: %v", group.GroupID, err)
+		c.JSON(errorStatus(err), gin.H{"error": "Failed to create group"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, group)
+}
+
+func (s *APIServer) handleListGroups(c *gin.Context) {
+	list, err := s.db.ListCustomerGroups(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch groups"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groups": list})
+}
+
+// handleGetGroup is the group view: its members with their balances and a
+// rollup of balances, arrears and recent collections across all of them.
+func (s *APIServer) handleGetGroup(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	group, err := s.db.GetCustomerGroup(ctx, c.Param("group_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+
+	days := 30
+	if d := c.Query("days"); d != "" {
+		fmt.Sscanf(d, "%d", &days)
+	}
+	if days < 1 || days > 366 {
+		days = 30
+	}
+
+	if group.Members, err = s.db.ListGroupMembers(ctx, group.GroupID); err != nil {
+		log.Printf("Failed to fetch members of group %s: %v", group.GroupID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
+	}
+
+	summary, err := s.db.GetGroupSummary(ctx, group.GroupID, s.clock.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.Printf("Failed to summarise group %s: %v", group.GroupID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"group":   group,
+		"summary": summary,
+	})
+}
+
+func (s *APIServer) handleAddGroupMember(c *gin.Context) {
+	var request struct {
+		Role string `json:"role" binding:"omitempty,oneof=MEMBER GUARANTOR"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if request.Role == "" {
+		request.Role = api.GroupRoleMember
+	}
+
+	ctx := c.Request.Context()
+	groupID := c.Param("group_id")
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomerGroup(ctx, groupID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+	if _, err := s.db.GetCustomerVersion(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	if err := s.db.AddGroupMember(ctx, groupID, customerID, request.Role); err != nil {
+		log.Printf("Failed to add %s to group %s: %v", customerID, groupID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add group member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"group_id": groupID, "customer_id": customerID, "role": request.Role})
+}
+
+func (s *APIServer) handleRemoveGroupMember(c *gin.Context) {
+	err := s.db.RemoveGroupMember(c.Request.Context(), c.Param("group_id"), c.Param("customer_id"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group member not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to remove %s from group %s: %v", c.Param("customer_id"), c.Param("group_id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove group member"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// handleGroupPayment allocates one payment across the group's members and
+// queues it as a split payment.
+func (s *APIServer) handleGroupPayment(c *gin.Context) {
+	var request api.GroupPaymentRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	group, err := s.db.GetCustomerGroup(ctx, c.Param("group_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+	rule := group.AllocationRule
+	if request.AllocationRule != "" {
+		rule = request.AllocationRule
+	}
+
+	amount, err := tools.ParseCents(request.TransactionAmount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "transaction_amount: " + err.Error()})
+		return
+	}
+
+	members, err := s.db.ListGroupMembers(ctx, group.GroupID)
+	if err != nil {
+		log.Printf("Failed to fetch members of group %s: %v", group.GroupID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to allocate group payment"})
+		return
+	}
+
+	allocations, err := groups.Allocate(amount, members, rule)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	split := &api.SplitPaymentRequest{
+		PaymentStatus:        request.PaymentStatus,
+		TransactionAmount:    request.TransactionAmount,
+		TransactionDate:      request.TransactionDate,
+		TransactionReference: request.TransactionReference,
+		Currency:             request.Currency,
+		Channel:              request.Channel,
+		Metadata:             api.Metadata{},
+		AgentID:              request.AgentID,
+	}
+	for key, value := range request.Metadata {
+		split.Metadata[key] = value
+	}
+	split.Metadata["group_id"] = group.GroupID
+	split.Metadata["allocation_rule"] = rule
+
+	// Members keep their listing order so split numbering is stable.
+	for _, member := range members {
+		if share, ok := allocations[member.CustomerID]; ok {
+			split.Splits = append(split.Splits, api.PaymentSplit{
+				CustomerID: member.CustomerID,
+				Amount:     fmt.Sprintf("%.2f", float64(share)/100),
+			})
+		}
+	}
+
+	s.acceptSplitPayment(c, split)
+}
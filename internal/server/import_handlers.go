@@ -0,0 +1,259 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleListImports(c *gin.Context) {
+	limit := 50
+	offset := 0
+
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if o := c.Query("offset"); o != "" {
+		fmt.Sscanf(o, "%d", &offset)
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	batches, err := s.db.ListImportBatches(c.Request.Context(), c.Query("source"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch imports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imports": batches, "limit": limit, "offset": offset})
+}
+
+func (s *APIServer) handleGetImport(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import id"})
+		return
+	}
+
+	batch, err := s.db.GetImportBatch(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	batch.Progress = tools.ImportBatchProgress(batch, s.db.Now())
+
+	c.JSON(http.StatusOK, batch)
+}
+
+// handleCancelImport stops a running import at its next checkpoint. Rows
+// already queued stay queued; reverse the import to take them back.
+func (s *APIServer) handleCancelImport(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import id"})
+		return
+	}
+
+	var request api.ImportCancelRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	batch, err := s.db.GetImportBatch(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+
+	batch, err = s.db.CancelImportBatch(ctx, id, request.CancelledBy)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import is no longer running"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to cancel import %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel import"})
+		return
+	}
+
+	log.Printf("Import %d cancelled by %s after %d rows", id, batch.CancelledBy, batch.TotalRows)
+	if batch.Status == api.ImportRunning {
+		// The importer stops at its next checkpoint.
+		c.JSON(http.StatusAccepted, batch)
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// handleRequestImportReversal asks for every payment of an import batch to
+// be reversed. Nothing changes until someone other than the requester
+// approves it.
+func (s *APIServer) handleRequestImportReversal(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import id"})
+		return
+	}
+
+	var request api.ImportReversalRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	batch, err := s.db.GetImportBatch(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	if batch.Status == api.ImportRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import is still running"})
+		return
+	}
+
+	reversal, err := s.db.RequestImportReversal(ctx, id, request.Reason, request.RequestedBy)
+	if errors.Is(err, tools.ErrImportReversalExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to request reversal of import %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request reversal"})
+		return
+	}
+
+	log.Printf("Reversal %d of import %d requested by %s: %d payments, %.2f", reversal.ID, id, reversal.RequestedBy, reversal.Payments, reversal.TotalAmount)
+	c.JSON(http.StatusCreated, gin.H{"reversal": reversal, "import": batch})
+}
+
+func (s *APIServer) handleListImportReversals(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import id"})
+		return
+	}
+
+	reversals, err := s.db.ListImportReversals(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reversals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"import_id": id, "reversals": reversals})
+}
+
+// handleApproveImportReversal reverses the batch's payments. Payments of
+// the batch still in the queue are applied as usual afterwards.
+func (s *APIServer) handleApproveImportReversal(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reversal id"})
+		return
+	}
+
+	var decision restructuringDecision
+	if !validation.BindJSON(c, &decision) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	reversal, err := s.db.GetImportReversal(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reversal not found"})
+		return
+	}
+	if reversal.Status != api.ImportReversalPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reversal is already " + string(reversal.Status)})
+		return
+	}
+	if decision.DecidedBy == reversal.RequestedBy {
+		c.JSON(http.StatusForbidden, gin.H{"error": "A reversal must be approved by someone other than the requester"})
+		return
+	}
+
+	reversal, reversed, err := s.db.ApproveImportReversal(ctx, id, decision.DecidedBy, decision.Note)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reversal is no longer pending"})
+		return
+	}
+	if errors.Is(err, tools.ErrSettlementClosed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Some payments fall on a closed settlement day; reopen it or correct them with dated adjustments"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to reverse import for reversal %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reverse import"})
+		return
+	}
+
+	references := make([]string, 0, len(reversed))
+	customers := map[string]bool{}
+	for _, txn := range reversed {
+		references = append(references, txn.TransactionReference)
+		customers[txn.CustomerID] = true
+	}
+	if err := s.redis.ClearDuplicates(ctx, references...); err != nil {
+		log.Warnf("Failed to clear duplicate markers for reversal %d: %v", id, err)
+	}
+	for customerID := range customers {
+		if err := s.redis.InvalidateBalance(ctx, customerID); err != nil {
+			log.Warnf("Failed to invalidate cached balance of %s: %v", customerID, err)
+		}
+	}
+	tools.DefaultMetrics.Inc("import_payments_reversed_total", float64(reversal.Payments))
+
+	log.Printf("Reversal %d of import %d approved by %s: %d payments, %.2f across %d accounts",
+		id, reversal.ImportBatchID, reversal.DecidedBy, reversal.Payments, reversal.TotalAmount, len(customers))
+	c.JSON(http.StatusOK, gin.H{"reversal": reversal, "accounts": len(customers)})
+}
+
+func (s *APIServer) handleRejectImportReversal(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reversal id"})
+		return
+	}
+
+	var decision restructuringDecision
+	if !validation.BindJSON(c, &decision) {
+		return
+	}
+
+	rejected, err := s.db.RejectImportReversal(c.Request.Context(), id, decision.DecidedBy, decision.Note)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reversal not found or no longer pending"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reversal": rejected})
+}
+
+// handlePollSettlements runs the settlement poller now instead of waiting for
+// its schedule, e.g. after a partner confirms a late upload.
+func (s *APIServer) handlePollSettlements(c *gin.Context) {
+	if s.SettlementPoller == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No settlement sources are configured"})
+		return
+	}
+
+	batches, err := s.SettlementPoller.Poll(c.Request.Context())
+	if err != nil {
+		log.Printf("Settlement poll failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "imports": batches})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imports": batches})
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleCreatePaymentIntent reserves an expected payment. The gateway
+// echoes the returned reference as the payment's intent_reference.
+func (s *APIServer) handleCreatePaymentIntent(c *gin.Context) {
+	var request api.PaymentIntentRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if err := request.Metadata.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	amount, err := tools.ParseAmount(request.Amount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, ok := s.intentAccount(c, request.CustomerID, request.AccountID)
+	if !ok {
+		return
+	}
+
+	intent := &api.PaymentIntent{
+		CustomerID: account.CustomerID,
+		Amount:     amount,
+		Currency:   request.Currency,
+		Metadata:   request.Metadata,
+	}
+	if !s.createPaymentIntent(c, intent, request.ExpiresIn) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, intent)
+}
+
+// handleCreatePaymentReference issues a dynamic payment reference for the
+// customer's next installments, optionally as a QR payload. Payments quoting
+// it as a partner_ref customer identifier resolve to the account and settle
+// the reference.
+func (s *APIServer) handleCreatePaymentReference(c *gin.Context) {
+	var request api.PaymentReferenceRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	currency := request.Currency
+	if request.QR {
+		if s.QRMerchant == nil {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "QR payments are not configured"})
+			return
+		}
+		var err error
+		if currency, err = s.QRMerchant.Currency(currency); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	account, ok := s.intentAccount(c, c.Param("customer_id"), request.AccountID)
+	if !ok {
+		return
+	}
+
+	installments := request.Installments
+	if installments == 0 {
+		installments = 1
+	}
+	amount := schedule.WeeklyAmount(account) * float64(installments)
+	if request.IncludeArrears {
+		amount += schedule.Arrears(account, s.clock.Now(), s.customerCalendar(c.Request.Context(), account))
+	}
+	amount = math.Min(math.Round(amount*100)/100, account.OutstandingBalance)
+	if amount <= 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account has nothing outstanding"})
+		return
+	}
+
+	intent := &api.PaymentIntent{
+		CustomerID: account.CustomerID,
+		Amount:     amount,
+		Currency:   currency,
+		Metadata:   api.Metadata{"installments": installments, "include_arrears": request.IncludeArrears},
+	}
+	if !s.createPaymentIntent(c, intent, request.ExpiresIn) {
+		return
+	}
+
+	response := gin.H{"reference": intent.Reference, "intent": intent}
+	if request.QR {
+		response["qr_payload"], _ = s.QRMerchant.Payload(intent.Reference, intent.Amount, intent.Currency)
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// intentAccount returns the account an intent is for: the customer's own,
+// or accountID if it is one of theirs.
+func (s *APIServer) intentAccount(c *gin.Context, customerID, accountID string) (*api.CustomerAccount, bool) {
+	ctx := c.Request.Context()
+
+	account, err := s.db.GetCustomer(ctx, customerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return nil, false
+	}
+	if accountID != "" && accountID != customerID {
+		account, err = s.db.GetCustomer(ctx, accountID)
+		if err != nil || account.HolderID != customerID {
+			c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgAccountNotFound)})
+			return nil, false
+		}
+	}
+	return account, true
+}
+
+// createPaymentIntent stores the intent, expiring after expiresIn seconds
+// or PaymentIntentTTL.
+func (s *APIServer) createPaymentIntent(c *gin.Context, intent *api.PaymentIntent, expiresIn int) bool {
+	ttl := s.PaymentIntentTTL
+	if expiresIn > 0 {
+		ttl = time.Duration(expiresIn) * time.Second
+	}
+	intent.ExpiresAt = s.clock.Now().Add(ttl)
+
+	if err := s.db.CreatePaymentIntent(c.Request.Context(), intent); err != nil {
+		log.Printf("Failed to create payment intent for %s: %v", intent.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment intent"})
+		return false
+	}
+	return true
+}
+
+func (s *APIServer) handleGetPaymentIntent(c *gin.Context) {
+	intent, err := s.db.GetPaymentIntent(c.Request.Context(), c.Param("reference"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment intent not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment intent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, intent)
+}
+
+func (s *APIServer) handleCancelPaymentIntent(c *gin.Context) {
+	intent, err := s.db.CancelPaymentIntent(c.Request.Context(), c.Param("reference"))
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment intent not found"})
+		return
+	case errors.Is(err, tools.ErrIntentClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Failed to cancel payment intent %s: %v", c.Param("reference"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel payment intent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, intent)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// LoadShedding sets when low-priority reads (lists, reports, exports) are
+// turned away with 503 so payment submission keeps the database and queue
+// to itself. A zero threshold disables that signal.
+type LoadShedding struct {
+	// DBLatency is the Postgres round-trip above which the instance sheds.
+	DBLatency time.Duration
+	// QueueDepth is the payment queue length above which the instance sheds.
+	QueueDepth int64
+	// Interval is how long a health probe is trusted before the next one.
+	Interval time.Duration
+}
+
+func (l LoadShedding) enabled() bool {
+	return l.DBLatency > 0 || l.QueueDepth > 0
+}
+
+// loadShedder caches the last health probe. Probes run on the request path
+// at most once per interval; requests arriving mid-probe use the previous
+// verdict rather than waiting.
+type loadShedder struct {
+	mu        sync.Mutex
+	probing   bool
+	checkedAt time.Time
+	reason    string
+}
+
+// shedWhenOverloaded guards a low-priority route.
+func (s *APIServer) shedWhenOverloaded() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.LoadShedding.enabled() {
+			c.Next()
+			return
+		}
+
+		reason := s.overloadReason(c.Request.Context())
+		if reason == "" {
+			c.Next()
+			return
+		}
+
+		s.metrics.Inc("http_requests_shed_total", 1, "path", c.FullPath())
+		retryAfter := s.LoadShedding.Interval
+		if retryAfter < time.Second {
+			retryAfter = time.Second
+		}
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":  "Service is under load; low-priority requests are temporarily rejected",
+			"reason": reason,
+		})
+	}
+}
+
+// overloadReason returns why the instance is overloaded, or "" when it is
+// healthy, re-probing when the last verdict is older than the interval.
+func (s *APIServer) overloadReason(ctx context.Context) string {
+	shedder := &s.shedder
+	now := s.clock.Now()
+
+	shedder.mu.Lock()
+	if shedder.probing || now.Sub(shedder.checkedAt) < s.LoadShedding.Interval {
+		reason := shedder.reason
+		shedder.mu.Unlock()
+		return reason
+	}
+	shedder.probing = true
+	shedder.mu.Unlock()
+
+	reason := s.probeLoad(ctx)
+
+	shedder.mu.Lock()
+	if reason != shedder.reason {
+		if reason != "" {
+			log.Warnf("Load shedding started: %s", reason)
+		} else {
+			log.Println("Load shedding stopped")
+		}
+	}
+	shedder.reason = reason
+	shedder.checkedAt = now
+	shedder.probing = false
+	shedder.mu.Unlock()
+
+	active := 0.0
+	if reason != "" {
+		active = 1
+	}
+	s.metrics.Set("http_load_shedding_active", active)
+	return reason
+}
+
+func (s *APIServer) probeLoad(ctx context.Context) string {
+	limits := s.LoadShedding
+
+	if limits.DBLatency > 0 && s.db != nil {
+		// A ping that doesn't come back within twice the threshold is as
+		// good as a slow one.
+		pingCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*limits.DBLatency)
+		latency, err := s.db.Ping(pingCtx)
+		cancel()
+		s.metrics.Set("db_ping_seconds", latency.Seconds())
+		if err != nil {
+			return "database ping failed"
+		}
+		if latency > limits.DBLatency {
+			return fmt.Sprintf("database latency %s exceeds %s", latency.Round(time.Millisecond), limits.DBLatency)
+		}
+	}
+
+	if limits.QueueDepth > 0 && s.redis != nil {
+		depth, err := s.redis.QueueDepth(ctx)
+		if err == nil && depth > limits.QueueDepth {
+			return fmt.Sprintf("payment queue depth %d exceeds %d", depth, limits.QueueDepth)
+		}
+	}
+
+	return ""
+}
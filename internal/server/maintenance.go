@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaintenanceMessage = "The service is undergoing maintenance; please try again later"
+	degradedMessage           = "The service is waiting for its database or queue; please try again later"
+)
+
+// maintenanceCache holds the last maintenance switch read from Redis. It is
+// re-read at most once per MaintenanceRefresh, so a switch made on another
+// replica takes effect here within that interval.
+type maintenanceCache struct {
+	mu       sync.Mutex
+	mode     api.MaintenanceMode
+	loadedAt time.Time
+}
+
+// maintenanceExempt are the mutating routes that keep working in
+// maintenance: the switch itself, draining and reconnecting to the database.
+var maintenanceExempt = map[string]bool{
+	"/api/v1/admin/maintenance":  true,
+	"/api/v1/admin/drain":        true,
+	"/api/v1/admin/db/reconnect": true,
+}
+
+// SetDegraded marks the instance as started without its dependencies:
+// readiness fails and mutating requests get 503 until it is cleared.
+func (s *APIServer) SetDegraded(degraded bool) {
+	s.degraded.Store(degraded)
+	value := 0.0
+	if degraded {
+		value = 1
+	}
+	s.metrics.Set("instance_degraded", value)
+}
+
+// Degraded reports whether the instance is waiting for its dependencies.
+func (s *APIServer) Degraded() bool {
+	return s.degraded.Load()
+}
+
+var paymentRoutes = map[string]bool{
+	"/api/v1/payments": true,
+	"/api/v2/payments": true,
+}
+
+// maintenanceMiddleware turns away mutating requests with 503 while
+// maintenance mode is on or the instance is degraded. Reads go through.
+func (s *APIServer) maintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if maintenanceExempt[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		// With DegradedWrites payments are still taken while degraded.
+		if s.Degraded() && !(s.DegradedWrites && paymentRoutes[c.FullPath()]) {
+			s.metrics.Inc("http_requests_maintenance_total", 1, "path", c.FullPath())
+			c.Header("Retry-After", "30")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":    degradedMessage,
+				"degraded": true,
+			})
+			return
+		}
+
+		mode := s.maintenance(c.Request.Context())
+		if !mode.Enabled {
+			c.Next()
+			return
+		}
+
+		s.metrics.Inc("http_requests_maintenance_total", 1, "path", c.FullPath())
+		if mode.Until != nil {
+			if wait := mode.Until.Sub(s.clock.Now()); wait > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			}
+		}
+		message := mode.Message
+		if message == "" {
+			message = defaultMaintenanceMessage
+		}
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":       message,
+			"maintenance": true,
+			"until":       mode.Until,
+		})
+	}
+}
+
+// maintenance returns the current switch. If Redis can't be read it keeps
+// the last known state rather than blocking or opening every request.
+func (s *APIServer) maintenance(ctx context.Context) api.MaintenanceMode {
+	cache := &s.maintenanceCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := s.clock.Now()
+	if s.redis == nil || now.Sub(cache.loadedAt) < s.MaintenanceRefresh {
+		return cache.mode
+	}
+	cache.loadedAt = now
+
+	mode, err := s.redis.GetMaintenance(ctx)
+	if err != nil {
+		log.Warnf("Failed to read maintenance mode: %v", err)
+		return cache.mode
+	}
+	cache.mode = *mode
+	return cache.mode
+}
+
+func (s *APIServer) handleGetMaintenance(c *gin.Context) {
+	mode, err := s.redis.GetMaintenance(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to read maintenance mode: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read maintenance mode"})
+		return
+	}
+	c.JSON(http.StatusOK, mode)
+}
+
+// handleSetMaintenance turns maintenance mode on or off for every replica.
+func (s *APIServer) handleSetMaintenance(c *gin.Context) {
+	var mode api.MaintenanceMode
+	if !validation.BindJSON(c, &mode) {
+		return
+	}
+	mode.Message = strings.TrimSpace(mode.Message)
+	if !mode.Enabled {
+		mode.Message = ""
+		mode.Until = nil
+	}
+	mode.UpdatedAt = s.clock.Now()
+
+	if err := s.redis.SaveMaintenance(c.Request.Context(), &mode); err != nil {
+		log.Printf("Failed to save maintenance mode: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save maintenance mode"})
+		return
+	}
+
+	cache := &s.maintenanceCache
+	cache.mu.Lock()
+	cache.mode = mode
+	cache.loadedAt = mode.UpdatedAt
+	cache.mu.Unlock()
+
+	enabled := 0.0
+	if mode.Enabled {
+		enabled = 1
+		log.Warnf("Maintenance mode enabled by %s: %s", mode.UpdatedBy, mode.Message)
+	} else {
+		log.Printf("Maintenance mode disabled by %s", mode.UpdatedBy)
+	}
+	s.metrics.Set("maintenance_mode", enabled)
+	c.JSON(http.StatusOK, mode)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleDuplicateCustomers lists account pairs that look like the same
+// customer. ?keys=phone,email overrides the metadata fields compared.
+func (s *APIServer) handleDuplicateCustomers(c *gin.Context) {
+	keys := s.DuplicateMetadataKeys
+	if k := c.Query("keys"); k != "" {
+		keys = strings.Split(k, ",")
+	}
+
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	candidates, err := s.db.FindDuplicateCustomers(c.Request.Context(), keys, limit)
+	if err != nil {
+		log.Printf("Failed to find duplicate customers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"metadata_keys": keys,
+		"candidates":    candidates,
+	})
+}
+
+// handleMergeCustomer folds duplicate_id into the account in the path.
+func (s *APIServer) handleMergeCustomer(c *gin.Context) {
+	var request struct {
+		DuplicateID string `json:"duplicate_id" binding:"required,max=50"`
+		MergedBy    string `json:"merged_by" binding:"required,max=100"`
+		Reason      string `json:"reason" binding:"required,max=500"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	survivorID := c.Param("customer_id")
+	if request.DuplicateID == survivorID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An account cannot be merged into itself"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	merge, err := s.db.MergeCustomers(ctx, survivorID, request.DuplicateID, request.MergedBy, request.Reason)
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	case errors.Is(err, tools.ErrMergeConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Failed to merge %s into %s: %v", request.DuplicateID, survivorID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge customers"})
+		return
+	}
+
+	survivor, err := s.db.GetCustomer(ctx, survivorID)
+	if err == nil {
+		s.redis.CacheBalance(ctx, survivorID, survivor.OutstandingBalance, 5*time.Minute)
+	}
+
+	log.Printf("Customer %s merged into %s by %s: moved %v", request.DuplicateID, survivorID, request.MergedBy, merge.Moved)
+	c.JSON(http.StatusOK, gin.H{
+		"merge":    merge,
+		"customer": survivor,
+	})
+}
+
+func (s *APIServer) handleListCustomerMerges(c *gin.Context) {
+	merges, err := s.db.ListCustomerMerges(c.Request.Context(), c.Param("customer_id"), 100)
+	if err != nil {
+		log.Printf("Failed to list merges into %s: %v", c.Param("customer_id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch merges"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"merges": merges})
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleCreateCustomerNote adds a note to the account, or to one of its
+// payments when the route names one.
+func (s *APIServer) handleCreateCustomerNote(c *gin.Context) {
+	var note api.CustomerNote
+	if !validation.BindJSON(c, &note) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	customer, err := s.db.GetCustomer(ctx, c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	note.CustomerID = customer.CustomerID
+	note.TransactionReference = c.Param("reference")
+	if note.TransactionReference != "" {
+		// Archived payments can still be annotated.
+		_, err := s.db.GetTransaction(ctx, customer.CustomerID, note.TransactionReference)
+		if errors.Is(err, tools.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		if err != nil && !errors.Is(err, tools.ErrTransactionArchived) {
+			log.Printf("Failed to look up payment %s: %v", note.TransactionReference, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add note"})
+			return
+		}
+	}
+
+	created, err := s.db.CreateCustomerNote(ctx, &note)
+	if err != nil {
+		log.Printf("Failed to add note for %s: %v", customer.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add note"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// handleListCustomerNotes lists the account's notes, optionally only those
+// with ?tag=, or those on one payment via ?transaction_reference= or the
+// payment's own notes route.
+func (s *APIServer) handleListCustomerNotes(c *gin.Context) {
+	reference := c.Param("reference")
+	if reference == "" {
+		reference = c.Query("transaction_reference")
+	}
+
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	notes, err := s.db.ListCustomerNotes(c.Request.Context(), c.Param("customer_id"), reference, c.Query("tag"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": c.Param("customer_id"), "notes": notes})
+}
+
+func (s *APIServer) handleGetCustomerNote(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("note_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	note, err := s.db.GetCustomerNote(c.Request.Context(), c.Param("customer_id"), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, note)
+}
+
+func (s *APIServer) handleUpdateCustomerNote(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("note_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	var update api.CustomerNoteUpdate
+	if !validation.BindJSON(c, &update) {
+		return
+	}
+	if update.Body == nil && update.Tags == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body or tags is required"})
+		return
+	}
+
+	note, err := s.db.UpdateCustomerNote(c.Request.Context(), c.Param("customer_id"), id, update)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update note %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
+		return
+	}
+
+	c.JSON(http.StatusOK, note)
+}
+
+func (s *APIServer) handleDeleteCustomerNote(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("note_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	deleted, err := s.db.DeleteCustomerNote(c.Request.Context(), c.Param("customer_id"), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Option customises an APIServer at construction.
+type Option func(*APIServer)
+
+// WithLogger writes the request log to logger instead of stdout.
+func WithLogger(logger *log.Logger) Option {
+	return func(s *APIServer) { s.logger = logger }
+}
+
+// WithMetrics serves metrics from metrics on /metrics instead of
+// tools.DefaultMetrics.
+func WithMetrics(metrics *tools.Metrics) Option {
+	return func(s *APIServer) { s.metrics = metrics }
+}
+
+// WithQueue replaces the Redis service payments are queued on.
+func WithQueue(queue *tools.RedisService) Option {
+	return func(s *APIServer) { s.redis = queue }
+}
+
+// WithMiddleware runs middleware on every route, after the built-in
+// middleware.
+func WithMiddleware(middleware ...gin.HandlerFunc) Option {
+	return func(s *APIServer) { s.middleware = append(s.middleware, middleware...) }
+}
+
+// WithClock replaces the clock behind health timestamps and balance
+// calculations.
+func WithClock(clock tools.Clock) Option {
+	return func(s *APIServer) { s.clock = clock }
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleListPaymentAttempts returns every time the processor took the
+// payment, oldest first: how long it waited on the queue, how long it took
+// and how it ended, to explain a payment that was slow or never applied.
+func (s *APIServer) handleListPaymentAttempts(c *gin.Context) {
+	reference := c.Param("reference")
+
+	attempts, err := s.db.ListPaymentAttempts(c.Request.Context(), reference)
+	if err != nil {
+		log.Printf("Failed to list attempts at payment %s: %v", reference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment attempts"})
+		return
+	}
+	if len(attempts) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No processing attempts recorded for this payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transaction_reference": reference, "attempts": attempts})
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/payouts"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleCreatePayout(c *gin.Context) {
+	var request api.PayoutRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	if request.CustomerID == "" && request.AgentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id or agent_id is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	payout, created, err := s.db.CreatePayout(ctx, &request)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+	if errors.Is(err, tools.ErrPayoutExceedsPaid) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "amount exceeds what the customer has paid"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create payout %s: %v", request.Reference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payout"})
+		return
+	}
+
+	if !created {
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate", "payout": payout})
+		return
+	}
+	s.alertLargeReversal(ctx, payout)
+
+	if err := s.redis.EnqueuePayout(ctx, payout.Reference); err != nil {
+		log.Printf("Failed to queue payout %s: %v", payout.Reference, err)
+		c.JSON(http.StatusAccepted, gin.H{"status": "created", "payout": payout, "warning": "Payout saved but not queued"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "queued", "payout": payout})
+}
+
+func (s *APIServer) handleGetPayout(c *gin.Context) {
+	payout, err := s.db.GetPayout(c.Request.Context(), c.Param("reference"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, payout)
+}
+
+func (s *APIServer) handleListPayouts(c *gin.Context) {
+	limit := 50
+	offset := 0
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if o := c.Query("offset"); o != "" {
+		fmt.Sscanf(o, "%d", &offset)
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	payouts, err := s.db.ListPayouts(c.Request.Context(), c.Query("status"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payouts": payouts, "limit": limit, "offset": offset})
+}
+
+// handlePayoutCallback receives final status updates from providers that
+// confirm transfers asynchronously. The callback must be signed by the
+// provider the payout was sent through, and only moves payouts still in
+// flight.
+func (s *APIServer) handlePayoutCallback(c *gin.Context) {
+	var provider payouts.Provider
+	if s.PayoutProcessor != nil {
+		provider = s.PayoutProcessor.Provider(c.Param("provider"))
+	}
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payout provider"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if !provider.VerifyCallback(c.Request.Header, body) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid callback signature"})
+		return
+	}
+
+	var request struct {
+		Reference         string `json:"reference" binding:"required"`
+		Status            string `json:"status" binding:"required,oneof=success failed"`
+		ProviderReference string `json:"provider_reference"`
+		Reason            string `json:"reason"`
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	status := api.PayoutSucceeded
+	if request.Status == "failed" {
+		status = api.PayoutFailed
+	}
+
+	ctx := c.Request.Context()
+	payout, err := s.db.GetPayout(ctx, request.Reference)
+	if err != nil || payout.Provider != provider.Name() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout not found"})
+		return
+	}
+
+	payout, err = s.db.UpdatePayoutStatus(ctx, request.Reference, status, provider.Name(), request.ProviderReference, request.Reason)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payout has already completed"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update payout %s: %v", request.Reference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payout"})
+		return
+	}
+
+	processors.NotifyPayoutStatus(ctx, s.Alerter, payout)
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// alertLargeReversal tells operations about refunds of at least
+// s.LargeReversalAmount as they are requested.
+func (s *APIServer) alertLargeReversal(ctx context.Context, payout *api.Payout) {
+	if s.OpsAlerter == nil || s.LargeReversalAmount <= 0 || payout.PayoutType != "refund" || payout.Amount < s.LargeReversalAmount {
+		return
+	}
+
+	alert := tools.Alert{
+		Type:     "large_reversal",
+		Severity: "warning",
+		Message:  fmt.Sprintf("Refund %s of %.2f %s requested for %s", payout.Reference, payout.Amount, payout.Currency, payout.CustomerID),
+		Details: map[string]interface{}{
+			"reference":   payout.Reference,
+			"customer_id": payout.CustomerID,
+			"amount":      payout.Amount,
+			"threshold":   s.LargeReversalAmount,
+			"method":      payout.Method,
+		},
+		Timestamp: s.clock.Now(),
+	}
+	// The webhook can be slow; don't hold up the request.
+	go func() {
+		if err := s.OpsAlerter.Send(context.WithoutCancel(ctx), alert); err != nil {
+			log.Warnf("Failed to send large reversal alert for %s: %v", payout.Reference, err)
+		}
+	}()
+}
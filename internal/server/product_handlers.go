@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleCreateLoanProduct(c *gin.Context) {
+	var product api.LoanProduct
+	if !validation.BindJSON(c, &product) {
+		return
+	}
+
+	if err := s.db.CreateLoanProduct(c.Request.Context(), &product); err != nil {
+		log.Printf("Failed to create loan product %s: %v", product.ProductID, err)
+		c.JSON(errorStatus(err), gin.H{"error": "Failed to create loan product"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, product)
+}
+
+func (s *APIServer) handleListLoanProducts(c *gin.Context) {
+	products, err := s.db.ListLoanProducts(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loan products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"products": products})
+}
+
+func (s *APIServer) handleAssignLoanProduct(c *gin.Context) {
+	var request struct {
+		ProductID string `json:"product_id" binding:"required"`
+	}
+
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	product, err := s.db.GetLoanProduct(ctx, request.ProductID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan product not found"})
+		return
+	}
+
+	customer, err := s.db.GetCustomer(ctx, c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	customer.InterestRate = product.InterestRate
+	customer.InterestMethod = product.InterestMethod
+	customer.GraceWeeks = product.GraceWeeks
+
+	updated, err := s.db.AssignLoanProduct(ctx, customer.CustomerID, product, schedule.WeeklyAmount(customer), schedule.TotalRepayable(customer))
+	if err != nil {
+		log.Printf("Failed to assign product %s to %s: %v", product.ProductID, customer.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign loan product"})
+		return
+	}
+	if err := s.redis.CacheBalance(ctx, updated.CustomerID, updated.OutstandingBalance, 5*time.Minute); err != nil {
+		log.Printf("Warning: failed to cache balance: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer":         updated,
+		"weekly_amount":    schedule.WeeklyAmount(updated),
+		"total_interest":   schedule.TotalInterest(updated),
+		"total_repayable":  schedule.TotalRepayable(updated),
+		"next_installment": schedule.NextInstallment(updated, s.clock.Now(), s.customerCalendar(ctx, updated)),
+	})
+}
+
+func (s *APIServer) handlePayoffQuote(c *gin.Context) {
+	customer, err := s.db.GetCustomer(c.Request.Context(), c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	asOf := s.clock.Now()
+	if asOfParam := c.Query("as_of"); asOfParam != "" {
+		if asOf, err = parseTimeParam(asOfParam); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "as_of: " + err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, schedule.Payoff(customer, asOf))
+}
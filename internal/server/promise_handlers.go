@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleCreatePromise(c *gin.Context) {
+	var promise api.PromiseToPay
+	if !validation.BindJSON(c, &promise) {
+		return
+	}
+
+	promised, _ := time.Parse("2006-01-02", promise.PromisedDate)
+	if promised.Before(s.clock.Now().UTC().Truncate(24 * time.Hour)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "promised_date cannot be in the past"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	customer, err := s.db.GetCustomer(ctx, c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	if schedule.Arrears(customer, s.clock.Now(), s.customerCalendar(ctx, customer)) <= 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Promises can only be recorded against accounts in arrears"})
+		return
+	}
+
+	agent, err := s.db.GetAgent(ctx, promise.AgentID)
+	if err != nil || !agent.Active {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or inactive agent_id"})
+		return
+	}
+
+	promise.CustomerID = customer.CustomerID
+	created, err := s.db.CreatePromise(ctx, &promise)
+	if err != nil {
+		log.Printf("Failed to record promise for %s: %v", customer.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record promise"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+func (s *APIServer) handleListPromises(c *gin.Context) {
+	promises, err := s.db.ListPromises(c.Request.Context(), c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promises"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": c.Param("customer_id"), "promises": promises})
+}
+
+func (s *APIServer) handlePromiseReport(c *gin.Context) {
+	from, to, ok := s.reportPeriod(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	agents, err := s.db.GetPromiseKeptRates(ctx, from, to, "agent")
+	if err != nil {
+		log.Printf("Failed to build promise report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	regions, err := s.db.GetPromiseKeptRates(ctx, from, to, "region")
+	if err != nil {
+		log.Printf("Failed to build promise report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"agents":  agents,
+		"regions": regions,
+	})
+}
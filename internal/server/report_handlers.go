@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleDelinquencyReport(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	filter := tools.CustomerFilter{
+		Region:    c.Query("region"),
+		Branch:    c.Query("branch"),
+		AssetType: c.Query("asset_type"),
+	}
+
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	regions, err := s.db.GetRegionalRollup(ctx, filter.Region)
+	if err != nil {
+		log.Printf("Failed to build regional rollup: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	accounts, err := s.db.GetDelinquentAccounts(ctx, filter, limit)
+	if err != nil {
+		log.Printf("Failed to fetch delinquent accounts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"region":     filter.Region,
+		"branch":     filter.Branch,
+		"asset_type": filter.AssetType,
+		"regions":    regions,
+		"accounts":   accounts,
+	})
+}
+
+// handleRiskReport breaks scored open accounts down by region and risk band
+// and lists the riskiest accounts, highest score first.
+func (s *APIServer) handleRiskReport(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	filter := tools.CustomerFilter{
+		Region:    c.Query("region"),
+		Branch:    c.Query("branch"),
+		AssetType: c.Query("asset_type"),
+	}
+
+	minScore := 67
+	if m := c.Query("min_score"); m != "" {
+		fmt.Sscanf(m, "%d", &minScore)
+	}
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	bands, err := s.db.GetRiskReport(ctx, filter.Region)
+	if err != nil {
+		log.Printf("Failed to build risk report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	accounts, err := s.db.GetHighestRiskAccounts(ctx, filter, minScore, limit)
+	if err != nil {
+		log.Printf("Failed to fetch high risk accounts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"region":    filter.Region,
+		"branch":    filter.Branch,
+		"min_score": minScore,
+		"bands":     bands,
+		"accounts":  accounts,
+	})
+}
+
+func (s *APIServer) handleWriteOffReport(c *gin.Context) {
+	from, to, ok := s.reportPeriod(c)
+	if !ok {
+		return
+	}
+
+	summaries, err := s.db.GetWriteOffReport(c.Request.Context(), from, to, c.Query("region"))
+	if err != nil {
+		log.Printf("Failed to build write-off report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	var writtenOff, recovered float64
+	for _, summary := range summaries {
+		writtenOff += summary.AmountWrittenOff
+		recovered += summary.AmountRecovered
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":              from,
+		"to":                to,
+		"regions":           summaries,
+		"total_written_off": writtenOff,
+		"total_recovered":   recovered,
+	})
+}
+
+func (s *APIServer) handleWriteOff(c *gin.Context) {
+	var request struct {
+		Reason       string `json:"reason" binding:"required,max=500"`
+		WrittenOffBy string `json:"written_off_by" binding:"required,max=100"`
+	}
+
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	customerID := c.Param("customer_id")
+	customer, err := s.db.WriteOffCustomer(c.Request.Context(), customerID, request.Reason)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account not found, already written off, or fully paid"})
+		return
+	}
+
+	s.redis.CacheBalance(c.Request.Context(), customerID, 0, 5*time.Minute)
+	log.Printf("Customer %s written off by %s: %.2f (%s)", customerID, request.WrittenOffBy, customer.WrittenOffAmount, request.Reason)
+
+	c.JSON(http.StatusOK, customer)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// cachedHeaders are the response headers stored with a cached body.
+var cachedHeaders = []string{"Content-Type", "Content-Language", "ETag", "Cache-Control"}
+
+type cachedResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    []byte            `json:"body"`
+}
+
+// bodyRecorder keeps a copy of the response body as the handler writes it.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// cacheResponse serves a hot read from the shared Redis response cache,
+// keyed by URL and locale, and caches 200 responses for ResponseCacheTTL.
+// Writes and applied payments invalidate the whole cache, so the TTL only
+// bounds staleness from changes made outside the API.
+func (s *APIServer) cacheResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.ResponseCacheTTL <= 0 || s.redis == nil {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		generation, err := s.redis.ResponseCacheGeneration(ctx)
+		if err != nil {
+			log.Warnf("Response cache unavailable: %v", err)
+			c.Next()
+			return
+		}
+
+		hash := sha256.Sum256([]byte(c.GetString("locale") + " " + c.Request.URL.RequestURI()))
+		key := hex.EncodeToString(hash[:16])
+
+		if data, err := s.redis.GetCachedResponse(ctx, generation, key); err == nil && data != nil {
+			var cached cachedResponse
+			if err := json.Unmarshal(data, &cached); err == nil {
+				s.metrics.Inc("http_response_cache_total", 1, "path", c.FullPath(), "result", "hit")
+				for name, value := range cached.Headers {
+					c.Header(name, value)
+				}
+				c.Header("X-Cache", "HIT")
+				if etag := cached.Headers["ETag"]; etag != "" && etagMatches(c.GetHeader("If-None-Match"), etag) {
+					c.AbortWithStatus(http.StatusNotModified)
+					return
+				}
+				c.Data(cached.Status, cached.Headers["Content-Type"], cached.Body)
+				c.Abort()
+				return
+			}
+		}
+
+		s.metrics.Inc("http_response_cache_total", 1, "path", c.FullPath(), "result", "miss")
+		c.Header("X-Cache", "MISS")
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if c.Writer.Status() != http.StatusOK || recorder.body.Len() == 0 {
+			return
+		}
+		cached := cachedResponse{Status: http.StatusOK, Headers: map[string]string{}, Body: recorder.body.Bytes()}
+		for _, name := range cachedHeaders {
+			if value := c.Writer.Header().Get(name); value != "" {
+				cached.Headers[name] = value
+			}
+		}
+		data, err := json.Marshal(cached)
+		if err != nil {
+			return
+		}
+		if err := s.redis.CacheResponse(ctx, generation, key, data, s.ResponseCacheTTL); err != nil {
+			log.Warnf("Failed to cache response for %s: %v", c.Request.URL.Path, err)
+		}
+	}
+}
+
+// invalidateResponseCacheMiddleware drops cached responses after a
+// successful write. Payment submissions only queue the payment; the
+// processor hook from ResponseCacheHook invalidates once it is applied.
+func (s *APIServer) invalidateResponseCacheMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if s.ResponseCacheTTL <= 0 || s.redis == nil || paymentRoutes[c.FullPath()] || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		s.invalidateResponseCache(c.Request.Context())
+	}
+}
+
+// ResponseCacheHook is a payment processor hook that invalidates the
+// response cache whenever a payment is applied.
+func (s *APIServer) ResponseCacheHook() processors.Hook {
+	return processors.HookFuncs{
+		After: func(ctx context.Context, payment *api.PaymentPayload, result processors.ApplyResult) {
+			if s.ResponseCacheTTL > 0 {
+				s.invalidateResponseCache(ctx)
+			}
+		},
+	}
+}
+
+func (s *APIServer) invalidateResponseCache(ctx context.Context) {
+	if err := s.redis.InvalidateResponseCache(ctx); err != nil {
+		log.Warnf("Failed to invalidate response cache: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type restructuringDecision struct {
+	DecidedBy string `json:"decided_by" binding:"required,max=100"`
+	Note      string `json:"note" binding:"max=500"`
+}
+
+func (s *APIServer) handleRequestRestructuring(c *gin.Context) {
+	var request api.RestructuringRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	customer, err := s.db.GetCustomer(ctx, c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	if customer.AssetType != "" {
+		asset, err := s.db.GetAsset(ctx, customer.AssetType)
+		if err != nil {
+			log.Printf("Failed to load asset %s for %s: %v", customer.AssetType, customer.CustomerID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load asset"})
+			return
+		}
+		if asset.MaxTermWeeks > 0 && request.NewTermWeeks > asset.MaxTermWeeks {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("new term of %d weeks exceeds the %d-week maximum for %s", request.NewTermWeeks, asset.MaxTermWeeks, asset.Name)})
+			return
+		}
+	}
+
+	rescheduled, capitalized, err := schedule.Reschedule(customer, request.NewTermWeeks, request.CapitalizeArrears, s.clock.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	restructuring, err := s.db.CreateRestructuring(ctx, &api.Restructuring{
+		CustomerID:        customer.CustomerID,
+		OldTermWeeks:      customer.TermWeeks,
+		NewTermWeeks:      request.NewTermWeeks,
+		CapitalizeArrears: request.CapitalizeArrears,
+		CapitalizedAmount: capitalized,
+		OldInstallment:    schedule.WeeklyAmount(customer),
+		NewInstallment:    rescheduled.InstallmentAmount,
+		Reason:            request.Reason,
+		RequestedBy:       request.RequestedBy,
+	})
+	if err != nil {
+		log.Printf("Failed to create restructuring for %s: %v", customer.CustomerID, err)
+		c.JSON(http.StatusConflict, gin.H{"error": "Account already has a pending restructuring"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"restructuring":    restructuring,
+		"next_installment": schedule.NextInstallment(rescheduled, s.clock.Now(), s.customerCalendar(ctx, rescheduled)),
+	})
+}
+
+func (s *APIServer) handleListRestructurings(c *gin.Context) {
+	restructurings, err := s.db.ListRestructurings(c.Request.Context(), c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch restructurings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": c.Param("customer_id"), "restructurings": restructurings})
+}
+
+func (s *APIServer) handleApproveRestructuring(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid restructuring id"})
+		return
+	}
+
+	var decision restructuringDecision
+	if !validation.BindJSON(c, &decision) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	restructuring, err := s.db.GetRestructuring(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Restructuring not found"})
+		return
+	}
+	if restructuring.Status != api.RestructuringPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Restructuring is already " + string(restructuring.Status)})
+		return
+	}
+	if decision.DecidedBy == restructuring.RequestedBy {
+		c.JSON(http.StatusForbidden, gin.H{"error": "A restructuring must be approved by someone other than the requester"})
+		return
+	}
+
+	customer, err := s.db.GetCustomer(ctx, restructuring.CustomerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	// Re-amortise against the balance as it stands now, not at request time.
+	rescheduled, capitalized, err := schedule.Reschedule(customer, restructuring.NewTermWeeks, restructuring.CapitalizeArrears, s.clock.Now())
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	approved, err := s.db.ApproveRestructuring(ctx, id, decision.DecidedBy, decision.Note, rescheduled, capitalized)
+	if err != nil {
+		log.Printf("Failed to approve restructuring %d: %v", id, err)
+		c.JSON(http.StatusConflict, gin.H{"error": "Account changed while approving, please retry"})
+		return
+	}
+
+	log.Printf("Restructuring %d approved for %s: %d -> %d weeks", id, approved.CustomerID, approved.OldTermWeeks, approved.NewTermWeeks)
+
+	c.JSON(http.StatusOK, gin.H{
+		"restructuring":    approved,
+		"next_installment": schedule.NextInstallment(rescheduled, s.clock.Now(), s.customerCalendar(ctx, rescheduled)),
+	})
+}
+
+func (s *APIServer) handleRejectRestructuring(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid restructuring id"})
+		return
+	}
+
+	var decision restructuringDecision
+	if !validation.BindJSON(c, &decision) {
+		return
+	}
+
+	rejected, err := s.db.RejectRestructuring(c.Request.Context(), id, decision.DecidedBy, decision.Note)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Restructuring not found or no longer pending"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"restructuring": rejected})
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/rules"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleListPaymentRules(c *gin.Context) {
+	list, err := s.db.ListPaymentRules(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": list})
+}
+
+// handleCreatePaymentRule adds a rule. Processors pick it up within
+// PAYMENT_RULES_REFRESH.
+func (s *APIServer) handleCreatePaymentRule(c *gin.Context) {
+	rule, ok := s.bindPaymentRule(c)
+	if !ok {
+		return
+	}
+
+	err := s.db.CreatePaymentRule(c.Request.Context(), rule)
+	if errors.Is(err, tools.ErrRuleNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create payment rule %s: %v", rule.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment rule"})
+		return
+	}
+	s.Rules.Invalidate()
+
+	log.Printf("Payment rule %d (%s, %s) created by %s", rule.ID, rule.Name, rule.Action, rule.UpdatedBy)
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (s *APIServer) handleUpdatePaymentRule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("rule_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule id"})
+		return
+	}
+	rule, ok := s.bindPaymentRule(c)
+	if !ok {
+		return
+	}
+	rule.ID = id
+
+	err = s.db.UpdatePaymentRule(c.Request.Context(), rule)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment rule not found"})
+		return
+	}
+	if errors.Is(err, tools.ErrRuleNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update payment rule %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment rule"})
+		return
+	}
+	s.Rules.Invalidate()
+
+	log.Printf("Payment rule %d (%s, %s) updated by %s: enabled=%t", rule.ID, rule.Name, rule.Action, rule.UpdatedBy, rule.Enabled)
+	c.JSON(http.StatusOK, rule)
+}
+
+func (s *APIServer) handleDeletePaymentRule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("rule_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule id"})
+		return
+	}
+
+	removed, err := s.db.DeletePaymentRule(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Failed to delete payment rule %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment rule"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment rule not found"})
+		return
+	}
+	s.Rules.Invalidate()
+
+	c.Status(http.StatusNoContent)
+}
+
+// handleTestPaymentRules shows which enabled rules a payment would match,
+// in the order the processor acts on them, without applying any.
+func (s *APIServer) handleTestPaymentRules(c *gin.Context) {
+	var payment api.PaymentPayload
+	if !validation.BindJSON(c, &payment) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	enabled, err := s.db.LoadPaymentRules(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment rules"})
+		return
+	}
+	account, err := s.db.GetCustomer(ctx, payment.CustomerID)
+	if err != nil && !errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch customer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"matched": rules.Match(enabled, &payment, account)})
+}
+
+func (s *APIServer) bindPaymentRule(c *gin.Context) (*api.PaymentRule, bool) {
+	var rule api.PaymentRule
+	if !validation.BindJSON(c, &rule) {
+		return nil, false
+	}
+	if err := rules.Validate(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if rule.Action == api.RuleAllocate {
+		if _, err := s.db.GetCustomer(c.Request.Context(), rule.TargetCustomerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+			return nil, false
+		}
+	}
+	return &rule, true
+}
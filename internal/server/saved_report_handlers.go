@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleListSavedReports lists the saved reports along with the dimensions,
+// measures and filters new ones can use.
+func (s *APIServer) handleListSavedReports(c *gin.Context) {
+	reports, err := s.db.ListSavedReports(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved reports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+		"fields":  tools.ReportFields(),
+	})
+}
+
+func (s *APIServer) handleSaveReport(c *gin.Context) {
+	var report api.SavedReport
+	if !validation.BindJSON(c, &report) {
+		return
+	}
+	report.ReportID = c.Param("report_id")
+	if len(report.ReportID) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "report_id must be at most 50 characters"})
+		return
+	}
+
+	if err := tools.ValidateSavedReport(&report); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "fields": tools.ReportFields()})
+		return
+	}
+
+	saved, err := s.db.SaveReport(c.Request.Context(), &report)
+	if err != nil {
+		log.Printf("Failed to save report %s: %v", report.ReportID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save report"})
+		return
+	}
+
+	log.Printf("Saved report %s updated by %s: dimensions=%v measures=%v schedule=%q", saved.ReportID, saved.CreatedBy, saved.Dimensions, saved.Measures, saved.Schedule)
+	c.JSON(http.StatusOK, saved)
+}
+
+func (s *APIServer) handleGetSavedReport(c *gin.Context) {
+	report, err := s.db.GetSavedReport(c.Request.Context(), c.Param("report_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved report not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (s *APIServer) handleDeleteSavedReport(c *gin.Context) {
+	err := s.db.DeleteSavedReport(c.Request.Context(), c.Param("report_id"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved report not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete report %s: %v", c.Param("report_id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete report"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// handleRunSavedReport runs a report now and returns its rows. With
+// ?deliver=true the result is also sent to the report's delivery targets.
+func (s *APIServer) handleRunSavedReport(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	report, err := s.db.GetSavedReport(ctx, c.Param("report_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved report not found"})
+		return
+	}
+
+	deliver := c.Query("deliver") == "true"
+	if deliver && s.ReportDeliverer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Report delivery is not configured"})
+		return
+	}
+
+	result, err := processors.RunSavedReport(ctx, s.db, s.ReportDeliverer, report, deliver)
+	if err != nil {
+		log.Printf("Saved report %s failed: %v", report.ReportID, err)
+		if result != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "result": result})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type holdDecision struct {
+	ReviewedBy string `json:"reviewed_by" binding:"required,max=100"`
+	Notes      string `json:"notes" binding:"required,max=2000"`
+}
+
+func (s *APIServer) handleListScreeningEntries(c *gin.Context) {
+	entries, err := s.db.ListScreeningEntries(c.Request.Context(), c.Query("entry_type"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch screening entries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+func (s *APIServer) handleAddScreeningEntry(c *gin.Context) {
+	var entry api.ScreeningEntry
+	if !validation.BindJSON(c, &entry) {
+		return
+	}
+
+	if err := s.db.AddScreeningEntry(c.Request.Context(), &entry); err != nil {
+		log.Printf("Failed to add %s screening entry: %v", entry.EntryType, err)
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to add screening entry"})
+		return
+	}
+
+	log.Printf("Screening entry %d (%s %s) added by %s", entry.ID, entry.EntryType, entry.Value, entry.AddedBy)
+	c.JSON(http.StatusCreated, entry)
+}
+
+func (s *APIServer) handleDeleteScreeningEntry(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry id"})
+		return
+	}
+
+	removed, err := s.db.DeleteScreeningEntry(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Failed to delete screening entry %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete screening entry"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screening entry not found"})
+		return
+	}
+
+	log.Printf("Screening entry %d removed", id)
+	c.Status(http.StatusNoContent)
+}
+
+func (s *APIServer) handleListScreeningHolds(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	holds, err := s.db.ListScreeningHolds(c.Request.Context(), c.DefaultQuery("status", api.HoldStatusHeld), limit)
+	if err != nil {
+		log.Printf("Failed to list screening holds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch screening holds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"holds": holds})
+}
+
+func (s *APIServer) handleGetScreeningHold(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hold id"})
+		return
+	}
+
+	hold, err := s.db.GetScreeningHold(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screening hold not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, hold)
+}
+
+// handleReleaseScreeningHold clears a held payment and queues it. The
+// processor lets payments with a released hold through screening.
+func (s *APIServer) handleReleaseScreeningHold(c *gin.Context) {
+	hold, ok := s.reviewScreeningHold(c, api.HoldStatusReleased)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := s.redis.EnqueuePayment(ctx, &hold.Payment); err != nil {
+		log.Printf("Failed to queue released payment %s: %v", hold.TransactionReference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Hold released but the payment could not be queued; release it again to retry"})
+		return
+	}
+	if err := s.db.ArchivePayment(ctx, &hold.Payment); err != nil {
+		log.Printf("Warning: failed to archive payment %s: %v", hold.TransactionReference, err)
+	}
+
+	c.JSON(http.StatusOK, hold)
+}
+
+func (s *APIServer) handleRejectScreeningHold(c *gin.Context) {
+	hold, ok := s.reviewScreeningHold(c, api.HoldStatusRejected)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, hold)
+}
+
+func (s *APIServer) reviewScreeningHold(c *gin.Context, status string) (*api.ScreeningHold, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hold id"})
+		return nil, false
+	}
+
+	var decision holdDecision
+	if !validation.BindJSON(c, &decision) {
+		return nil, false
+	}
+
+	hold, err := s.db.ReviewScreeningHold(c.Request.Context(), id, status, decision.ReviewedBy, decision.Notes)
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screening hold not found"})
+		return nil, false
+	case errors.Is(err, tools.ErrHoldReviewed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Screening hold has already been reviewed"})
+		return nil, false
+	case err != nil:
+		log.Printf("Failed to review screening hold %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review screening hold"})
+		return nil, false
+	}
+
+	log.Printf("Screening hold %d (%s) %s by %s", id, hold.TransactionReference, status, decision.ReviewedBy)
+	return hold, true
+}
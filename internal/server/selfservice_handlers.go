@@ -0,0 +1,177 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	otpTTL      = 5 * time.Minute
+	otpCooldown = time.Minute
+)
+
+func (s *APIServer) handleSelfServiceBalance(c *gin.Context) {
+	var request struct {
+		Phone string `json:"phone" binding:"required,min=7,max=20"`
+		OTP   string `json:"otp" binding:"omitempty,len=6,numeric"`
+	}
+
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	phone := tools.NormalizeIdentifier(api.IdentifierPhone, request.Phone)
+
+	if request.OTP == "" {
+		s.sendSelfServiceOTP(c, phone)
+		return
+	}
+
+	customerID, ok := s.verifySelfServiceOTP(c, phone, request.OTP)
+	if !ok {
+		return
+	}
+
+	customer, err := s.db.GetCustomer(ctx, customerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id":         customer.CustomerID,
+		"outstanding_balance": customer.OutstandingBalance,
+		"total_paid":          customer.TotalPaid,
+		"last_payment_date":   customer.LastPaymentDate,
+		"next_installment":    schedule.NextInstallment(customer, s.clock.Now(), s.customerCalendar(ctx, customer)),
+	})
+}
+
+// handleSelfServicePreferences lets customers see and change their
+// notification preferences from a phone verified by one-time code, as the
+// balance lookup does. Without preference fields it returns them unchanged.
+func (s *APIServer) handleSelfServicePreferences(c *gin.Context) {
+	var request struct {
+		api.PreferencesUpdate
+		Phone string `json:"phone" binding:"required,min=7,max=20"`
+		OTP   string `json:"otp" binding:"omitempty,len=6,numeric"`
+	}
+
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	if !validQuietHours(c, request.PreferencesUpdate) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	phone := tools.NormalizeIdentifier(api.IdentifierPhone, request.Phone)
+
+	if request.OTP == "" {
+		s.sendSelfServiceOTP(c, phone)
+		return
+	}
+
+	customerID, ok := s.verifySelfServiceOTP(c, phone, request.OTP)
+	if !ok {
+		return
+	}
+
+	preferences, err := s.db.UpdateNotificationPreferences(ctx, customerID, request.PreferencesUpdate, "self-service")
+	if err != nil {
+		log.Printf("Failed to update notification preferences for %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// verifySelfServiceOTP checks the code sent to phone and resolves the
+// customer it belongs to, responding with the error if either fails.
+func (s *APIServer) verifySelfServiceOTP(c *gin.Context, phone, otp string) (string, bool) {
+	ctx := c.Request.Context()
+
+	valid, err := s.redis.VerifyOTP(ctx, "phone:"+phone, otp)
+	if err != nil {
+		log.Printf("OTP verification failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return "", false
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+		return "", false
+	}
+
+	customerID, err := s.db.ResolveCustomerID(ctx, api.IdentifierPhone, phone)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return "", false
+	}
+	return customerID, true
+}
+
+func (s *APIServer) sendSelfServiceOTP(c *gin.Context, phone string) {
+	ctx := c.Request.Context()
+	response := gin.H{
+		"status":     "otp_sent",
+		"expires_in": int(otpTTL.Seconds()),
+	}
+
+	// Respond identically whether or not the phone is registered so the
+	// endpoint can't be used to enumerate customers: the cooldown applies
+	// to every phone, and once past it the response is the same whatever
+	// happens to the code.
+	allowed, err := s.redis.StartOTPCooldown(ctx, "phone:"+phone, otpCooldown)
+	if err != nil {
+		log.Printf("Failed to check OTP cooldown: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate code"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "A code was sent recently, please wait before retrying"})
+		return
+	}
+
+	customerID, err := s.db.ResolveCustomerID(ctx, api.IdentifierPhone, phone)
+	if err != nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	code, err := tools.GenerateOTP()
+	if err == nil {
+		err = s.redis.SaveOTP(ctx, "phone:"+phone, code, otpTTL)
+	}
+	if err != nil {
+		log.Printf("Failed to store OTP: %v", err)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if s.Notifier != nil {
+		err := s.Notifier.Send(ctx, notifications.Notification{
+			Channel:     notifications.ChannelSMS,
+			Recipient:   phone,
+			CustomerID:  customerID,
+			MessageKey:  i18n.MsgVerificationCode,
+			MessageArgs: []string{code},
+			Essential:   true,
+		})
+		if err != nil {
+			log.Printf("Failed to send OTP SMS: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
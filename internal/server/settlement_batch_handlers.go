@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleListSettlementBatches lists the batches closed or reopened between
+// from and to, the last 30 days by default.
+func (s *APIServer) handleListSettlementBatches(c *gin.Context) {
+	to := s.clock.Now()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be YYYY-MM-DD"})
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be YYYY-MM-DD"})
+			return
+		}
+		from = t
+	}
+
+	batches, err := s.db.ListSettlementBatches(c.Request.Context(), from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settlement batches"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"batches": batches,
+	})
+}
+
+// handleGetSettlementBatch returns a day's summary: the fixed totals of a
+// closed day, the live ones otherwise.
+func (s *APIServer) handleGetSettlementBatch(c *gin.Context) {
+	date, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+
+	batch, err := s.db.GetSettlementBatch(c.Request.Context(), date)
+	if err != nil {
+		log.Printf("Failed to load settlement batch for %s: %v", c.Param("date"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load settlement batch"})
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
+
+// handleCloseSettlementBatch closes a past business day. From then on its
+// transactions can't change; corrections are posted as adjustments dated
+// the day they are made.
+func (s *APIServer) handleCloseSettlementBatch(c *gin.Context) {
+	date, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	if date.Format("2006-01-02") >= s.clock.Now().Format("2006-01-02") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only past business days can be closed"})
+		return
+	}
+
+	var request struct {
+		ClosedBy string `json:"closed_by" binding:"required,max=100"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	batch, err := s.db.CloseSettlementBatch(c.Request.Context(), date, request.ClosedBy)
+	if errors.Is(err, tools.ErrSettlementClosed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Settlement day is already closed"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to close settlement day %s: %v", c.Param("date"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close settlement day"})
+		return
+	}
+
+	log.Printf("Settlement day %s closed by %s: %d payments, %.2f", batch.BusinessDate, batch.ClosedBy, batch.Payments, batch.TotalAmount)
+	tools.DefaultMetrics.Inc("settlement_batches_closed_total", 1)
+	c.JSON(http.StatusOK, batch)
+}
+
+func (s *APIServer) handleReopenSettlementBatch(c *gin.Context) {
+	date, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+
+	var request struct {
+		ReopenedBy string `json:"reopened_by" binding:"required,max=100"`
+		Reason     string `json:"reason" binding:"required,max=500"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	batch, err := s.db.ReopenSettlementBatch(c.Request.Context(), date, request.ReopenedBy, request.Reason)
+	if errors.Is(err, tools.ErrSettlementNotClosed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Settlement day is not closed"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to reopen settlement day %s: %v", c.Param("date"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reopen settlement day"})
+		return
+	}
+
+	log.Printf("Settlement day %s reopened by %s: %s", batch.BusinessDate, batch.ReopenedBy, batch.ReopenReason)
+	c.JSON(http.StatusOK, batch)
+}
+
+// handlePostSettlementAdjustment applies a correction to the account as a
+// transaction of today, leaving closed days as they were settled.
+func (s *APIServer) handlePostSettlementAdjustment(c *gin.Context) {
+	var adjustment api.SettlementAdjustment
+	if !validation.BindJSON(c, &adjustment) {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if _, err := s.db.GetCustomer(ctx, adjustment.CustomerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	posted, err := s.db.PostSettlementAdjustment(ctx, &adjustment)
+	if err != nil {
+		log.Printf("Failed to post adjustment for %s: %v", adjustment.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post adjustment"})
+		return
+	}
+
+	if err := s.redis.InvalidateBalance(ctx, posted.CustomerID); err != nil {
+		log.Warnf("Failed to invalidate cached balance of %s: %v", posted.CustomerID, err)
+	}
+
+	tools.DefaultMetrics.Inc("settlement_adjustments_total", 1)
+	c.JSON(http.StatusCreated, posted)
+}
+
+// handleListSettlementAdjustments lists the adjustments posted on a day,
+// today by default.
+func (s *APIServer) handleListSettlementAdjustments(c *gin.Context) {
+	date := s.clock.Now()
+	if v := c.Query("date"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		date = t
+	}
+
+	adjustments, err := s.db.ListSettlementAdjustments(c.Request.Context(), date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch adjustments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"business_date": date.Format("2006-01-02"), "adjustments": adjustments})
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleSplitPayment(c *gin.Context) {
+	var request api.SplitPaymentRequest
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	s.acceptSplitPayment(c, &request)
+}
+
+// acceptSplitPayment takes one transfer that covers several accounts and
+// queues a payment per split. Retrying with the same reference returns the
+// splits already recorded.
+func (s *APIServer) acceptSplitPayment(c *gin.Context, request *api.SplitPaymentRequest) {
+	if err := request.Metadata.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.PaymentStatus != api.StatusComplete {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": msg(c, i18n.MsgOnlyCompleteAllowed, request.PaymentStatus),
+		})
+		return
+	}
+
+	total, err := tools.ParseCents(request.TransactionAmount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "transaction_amount: " + err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	seen := make(map[string]bool)
+	var allocated int64
+	for i := range request.Splits {
+		split := &request.Splits[i]
+		if seen[split.CustomerID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("customer %s appears in more than one split", split.CustomerID)})
+			return
+		}
+		seen[split.CustomerID] = true
+
+		cents, err := tools.ParseCents(split.Amount)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("splits[%d].amount: %v", i, err)})
+			return
+		}
+		allocated += cents
+
+		if _, err := s.db.GetCustomerVersion(ctx, split.CustomerID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound), "customer_id": split.CustomerID})
+			return
+		}
+
+		split.Amount = fmt.Sprintf("%.2f", float64(cents)/100)
+		split.SplitReference = fmt.Sprintf("%s-SPLIT-%d", request.TransactionReference, i+1)
+	}
+	if allocated != total {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "Splits must add up to the transaction amount",
+			"allocated": float64(allocated) / 100,
+		})
+		return
+	}
+
+	if request.AgentID != "" {
+		agent, err := s.db.GetAgent(ctx, request.AgentID)
+		if err != nil || !agent.Active {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or inactive agent_id"})
+			return
+		}
+	}
+
+	existing, err := s.db.GetPaymentSplits(ctx, request.TransactionReference)
+	if err != nil {
+		log.Printf("Failed to fetch splits for %s: %v", request.TransactionReference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to split payment"})
+		return
+	}
+	if len(existing) > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"status":                "duplicate",
+			"message":               msg(c, i18n.MsgPaymentDuplicate),
+			"transaction_reference": request.TransactionReference,
+			"splits":                existing,
+		})
+		return
+	}
+	if processed, err := s.db.IsTransactionProcessed(ctx, request.TransactionReference); err == nil && processed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transaction reference was already processed as a single payment"})
+		return
+	}
+
+	// Queue before recording the link: a failed request can then be retried,
+	// and the processor ignores split references it has already applied.
+	for _, split := range request.Splits {
+		payment := api.PaymentPayload{
+			CustomerID:           split.CustomerID,
+			PaymentStatus:        request.PaymentStatus,
+			TransactionAmount:    split.Amount,
+			TransactionDate:      request.TransactionDate,
+			TransactionReference: split.SplitReference,
+			Currency:             request.Currency,
+			Channel:              request.Channel,
+			AgentID:              request.AgentID,
+			Metadata:             api.Metadata{},
+		}
+		for key, value := range request.Metadata {
+			payment.Metadata[key] = value
+		}
+		payment.Metadata["split_of"] = request.TransactionReference
+
+		if err := s.redis.EnqueuePayment(ctx, &payment); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg(c, i18n.MsgQueueFailed)})
+			return
+		}
+		if err := s.db.ArchivePayment(ctx, &payment); err != nil {
+			log.Printf("Warning: failed to archive payment %s: %v", payment.TransactionReference, err)
+		}
+	}
+
+	if err := s.db.RecordPaymentSplits(ctx, request.TransactionReference, request.Splits); err != nil {
+		log.Printf("Warning: failed to record splits for %s: %v", request.TransactionReference, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":                "accepted",
+		"message":               msg(c, i18n.MsgPaymentAccepted),
+		"transaction_reference": request.TransactionReference,
+		"splits":                request.Splits,
+	})
+}
+
+func (s *APIServer) handleGetPaymentSplits(c *gin.Context) {
+	splits, err := s.db.GetPaymentSplits(c.Request.Context(), c.Param("reference"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment splits"})
+		return
+	}
+	if len(splits) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No splits recorded for this reference"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transaction_reference": c.Param("reference"),
+		"splits":                splits,
+	})
+}
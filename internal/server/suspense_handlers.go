@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type suspenseReview struct {
+	ReviewedBy string `json:"reviewed_by" binding:"required,max=100"`
+	Note       string `json:"note" binding:"max=500"`
+}
+
+func (s *APIServer) handleListSuspensePayments(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	status := c.DefaultQuery("status", api.SuspenseOpen)
+	if status == "all" {
+		status = ""
+	}
+
+	payments, err := s.db.ListSuspensePayments(c.Request.Context(), status, limit)
+	if err != nil {
+		log.Printf("Failed to list suspense payments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suspense payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payments": payments})
+}
+
+func (s *APIServer) handleGetSuspensePayment(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid suspense payment id"})
+		return
+	}
+
+	suspense, err := s.db.GetSuspensePayment(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Suspense payment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, suspense)
+}
+
+// handleAssignSuspensePayment applies a suspense payment to the right
+// account by queueing it again for that customer.
+func (s *APIServer) handleAssignSuspensePayment(c *gin.Context) {
+	var request struct {
+		suspenseReview
+		CustomerID string `json:"customer_id" binding:"required,max=50"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := s.db.GetCustomer(ctx, request.CustomerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	suspense, ok := s.reviewSuspensePayment(c, api.SuspenseAssigned, request.CustomerID, request.suspenseReview)
+	if !ok {
+		return
+	}
+
+	payment := suspense.Payment
+	payment.CustomerID = request.CustomerID
+	delete(payment.Metadata, api.MetadataCustomerUnverified)
+	if payment.Metadata == nil {
+		payment.Metadata = api.Metadata{}
+	}
+	payment.Metadata["suspense_id"] = suspense.ID
+	payment.Metadata["suspense_customer_id"] = suspense.CustomerID
+
+	if err := s.redis.EnqueuePayment(ctx, &payment); err != nil {
+		log.Printf("Failed to queue suspense payment %s: %v", suspense.TransactionReference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment assigned but could not be queued; assign it again to retry"})
+		return
+	}
+	if err := s.db.ArchivePayment(ctx, &payment); err != nil {
+		log.Printf("Warning: failed to archive payment %s: %v", suspense.TransactionReference, err)
+	}
+
+	c.JSON(http.StatusOK, suspense)
+}
+
+func (s *APIServer) handleRejectSuspensePayment(c *gin.Context) {
+	var request suspenseReview
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	suspense, ok := s.reviewSuspensePayment(c, api.SuspenseRejected, "", request)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, suspense)
+}
+
+func (s *APIServer) reviewSuspensePayment(c *gin.Context, status, customerID string, review suspenseReview) (*api.SuspensePayment, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid suspense payment id"})
+		return nil, false
+	}
+
+	suspense, err := s.db.ReviewSuspensePayment(c.Request.Context(), id, status, customerID, review.ReviewedBy, review.Note)
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Suspense payment not found"})
+		return nil, false
+	case errors.Is(err, tools.ErrSuspenseReviewed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Suspense payment has already been reviewed"})
+		return nil, false
+	case err != nil:
+		log.Printf("Failed to review suspense payment %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review suspense payment"})
+		return nil, false
+	}
+
+	log.Printf("Suspense payment %d (%s) %s by %s", id, suspense.TransactionReference, status, review.ReviewedBy)
+	return suspense, true
+}
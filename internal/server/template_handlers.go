@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/templates"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleListTemplates lists the live version of every saved template along
+// with the built-in ones that haven't been overridden.
+func (s *APIServer) handleListTemplates(c *gin.Context) {
+	saved, err := s.db.ListMessageTemplates(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch templates"})
+		return
+	}
+
+	overridden := map[string]bool{}
+	for _, template := range saved {
+		overridden[template.TemplateID] = true
+	}
+	builtin := []api.MessageTemplate{}
+	for templateID, template := range templates.Defaults {
+		if !overridden[templateID] {
+			template.TemplateID = templateID
+			template.Locale = i18n.DefaultLocale
+			builtin = append(builtin, template)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"templates": saved, "builtin": builtin})
+}
+
+// handleSaveTemplate stores a new version of the template, which takes
+// effect immediately.
+func (s *APIServer) handleSaveTemplate(c *gin.Context) {
+	var template api.MessageTemplate
+	if !validation.BindJSON(c, &template) {
+		return
+	}
+	template.TemplateID = c.Param("template_id")
+	if template.Locale == "" {
+		template.Locale = i18n.DefaultLocale
+	}
+
+	if err := templates.Validate(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.db.SaveMessageTemplate(c.Request.Context(), &template); err != nil {
+		log.Printf("Failed to save template %s: %v", template.TemplateID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save template"})
+		return
+	}
+
+	log.Printf("Template %s (%s) updated to version %d by %s", template.TemplateID, template.Locale, template.Version, template.UpdatedBy)
+	c.JSON(http.StatusOK, template)
+}
+
+// handleGetTemplate returns the live template for ?locale= (default "en")
+// and its earlier versions.
+func (s *APIServer) handleGetTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	templateID := c.Param("template_id")
+	locale := c.DefaultQuery("locale", i18n.DefaultLocale)
+
+	template, err := s.Templates.Template(ctx, templateID, locale, 0)
+	if errors.Is(err, templates.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch template"})
+		return
+	}
+
+	versions, err := s.db.ListMessageTemplateVersions(ctx, templateID, template.Locale)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"template": template, "versions": versions})
+}
+
+// handlePreviewTemplate renders a template version with sample values.
+// Variables that aren't given are shown as their {{name}} placeholder.
+func (s *APIServer) handlePreviewTemplate(c *gin.Context) {
+	rendered, _, ok := s.renderTemplatePreview(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, rendered)
+}
+
+// handleTestSendTemplate renders a template like a preview and sends it to
+// the given recipient over the template's channel.
+func (s *APIServer) handleTestSendTemplate(c *gin.Context) {
+	rendered, recipient, ok := s.renderTemplatePreview(c)
+	if !ok {
+		return
+	}
+
+	if recipient == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "recipient is required"})
+		return
+	}
+	if s.Notifier == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Notifications are not configured"})
+		return
+	}
+	channel := notifications.Channel(rendered.Channel)
+	if rendered.Channel == api.TemplateReceipt {
+		channel = notifications.ChannelEmail
+	}
+
+	err := s.Notifier.Send(c.Request.Context(), notifications.Notification{
+		Channel:   channel,
+		Recipient: recipient,
+		Subject:   rendered.Subject,
+		Message:   rendered.Body,
+		Essential: true,
+	})
+	if err != nil {
+		log.Printf("Failed to test-send template %s: %v", rendered.TemplateID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send test message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "sent", "channel": channel, "message": rendered})
+}
+
+func (s *APIServer) renderTemplatePreview(c *gin.Context) (*api.RenderedMessage, string, bool) {
+	var request api.TemplatePreview
+	if !validation.BindJSON(c, &request) {
+		return nil, "", false
+	}
+	if request.Locale == "" {
+		request.Locale = i18n.DefaultLocale
+	}
+
+	template, err := s.Templates.Template(c.Request.Context(), c.Param("template_id"), request.Locale, request.Version)
+	if errors.Is(err, templates.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return nil, "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch template"})
+		return nil, "", false
+	}
+
+	variables := map[string]string{}
+	for _, name := range template.Variables {
+		variables[name] = "{{" + name + "}}"
+	}
+	for name, value := range request.Variables {
+		variables[name] = value
+	}
+
+	rendered, err := templates.Execute(template, variables)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	return rendered, request.Recipient, true
+}
+
+// handlePaymentReceipt renders the payment_receipt template for one of the
+// customer's payments, in ?locale= or the customer's preferred language.
+func (s *APIServer) handlePaymentReceipt(c *gin.Context) {
+	locale, variables, ok := s.receiptVariables(c)
+	if !ok {
+		return
+	}
+
+	receipt, err := s.Templates.Render(c.Request.Context(), "payment_receipt", locale, variables)
+	if err != nil {
+		log.Printf("Failed to render receipt for %s: %v", variables["reference"], err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render receipt"})
+		return
+	}
+
+	c.JSON(http.StatusOK, receipt)
+}
+
+// receiptVariables loads the payment a receipt is for and formats its
+// variables, writing the error response when it can't.
+func (s *APIServer) receiptVariables(c *gin.Context) (string, map[string]string, bool) {
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	txn, err := s.db.GetTransaction(ctx, customerID, c.Param("reference"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return "", nil, false
+	}
+	if errors.Is(err, tools.ErrTransactionArchived) {
+		c.JSON(http.StatusGone, gin.H{
+			"error":         "Payment has been archived",
+			"archived":      true,
+			"archive_month": txn.Archive.Month,
+			"processed_at":  txn.ProcessedAt,
+		})
+		return "", nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return "", nil, false
+	}
+
+	locale := c.Query("locale")
+	if locale == "" {
+		locale = i18n.DefaultLocale
+		if preferences, err := s.db.GetNotificationPreferences(ctx, customerID); err == nil && preferences.Language != "" {
+			locale = preferences.Language
+		}
+	}
+
+	variables := map[string]string{
+		"customer_id": txn.CustomerID,
+		"reference":   txn.TransactionReference,
+		"amount":      i18n.FormatAmount(locale, txn.Amount),
+		"date":        i18n.FormatDate(locale, txn.ProcessedAt),
+	}
+	if txn.BalanceAfter != nil {
+		variables["balance_after"] = i18n.FormatAmount(locale, *txn.BalanceAfter)
+	}
+	return locale, variables, true
+}
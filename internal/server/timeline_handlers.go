@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleCustomerTimeline returns the account's activity newest first. The
+// next page is fetched with before set to the last event's occurred_at.
+func (s *APIServer) handleCustomerTimeline(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	before := s.clock.Now().Add(time.Second)
+	if value := c.Query("before"); value != "" {
+		var err error
+		if before, err = parseTimeParam(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before: " + err.Error()})
+			return
+		}
+	}
+
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	customer, err := s.db.GetCustomer(ctx, c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	events, err := s.db.CustomerTimeline(ctx, customer.CustomerID, before, limit)
+	if err != nil {
+		log.Printf("Failed to build timeline for %s: %v", customer.CustomerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch timeline"})
+		return
+	}
+
+	response := gin.H{"customer_id": customer.CustomerID, "events": events}
+	if len(events) == limit {
+		response["next_before"] = events[len(events)-1].OccurredAt
+	}
+	c.JSON(http.StatusOK, response)
+}
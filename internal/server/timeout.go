@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRouteTimeouts bounds how long a request may take, keyed by route
+// pattern. Zero leaves a route without a deadline; routes not listed get
+// APIServer.RequestTimeout. Values are overridable via
+// APIServer.RouteTimeouts.
+func defaultRouteTimeouts() map[string]time.Duration {
+	return map[string]time.Duration{
+		"/api/v1/customers/:customer_id/balance":             2 * time.Second,
+		"/api/v2/customers/:customer_id/balance":             2 * time.Second,
+		"/api/v1/self-service/balance":                       2 * time.Second,
+		"/api/v1/admin/snapshots/:date":                      30 * time.Second,
+		"/api/v1/admin/snapshots/:date/export":               30 * time.Second,
+		"/api/v1/collections/worklist":                       30 * time.Second,
+		"/api/v1/admin/reports/agent-collections":            30 * time.Second,
+		"/api/v1/admin/reports/delinquency":                  30 * time.Second,
+		"/api/v1/admin/reports/write-offs":                   30 * time.Second,
+		"/api/v1/admin/reports/promises":                     30 * time.Second,
+		"/api/v1/admin/reports/duplicate-customers":          60 * time.Second,
+		"/api/v1/admin/reports/risk":                         30 * time.Second,
+		"/api/v1/admin/saved-reports/:report_id/run":         60 * time.Second,
+		"/api/v1/admin/replay":                               0,
+		"/api/v1/admin/pii/reencrypt":                        0,
+		"/api/v1/admin/retention/run":                        0,
+		"/api/v1/admin/anonymized-exports":                   0,
+		"/api/v1/admin/anonymized-exports/:export_id/import": 0,
+		"/api/v1/admin/settlements/poll":                     0,
+		"/api/v1/admin/drain":                                0,
+	}
+}
+
+// ParseRouteTimeouts reads per-route deadlines from a comma-separated list
+// such as "/api/v1/customers/:customer_id/balance=2s,/api/v1/admin/replay=0".
+func ParseRouteTimeouts(spec string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route timeout %q", entry)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid route timeout %q", entry)
+		}
+		timeouts[strings.TrimSpace(route)] = timeout
+	}
+	return timeouts, nil
+}
+
+// timeoutMiddleware puts the route's deadline on the request context, which
+// the database and Redis services pass down to every call. When the deadline
+// passes, a handler's error response is replaced with a 504 so clients can
+// tell a slow dependency from a failure.
+func (s *APIServer) timeoutMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout, ok := s.RouteTimeouts[c.FullPath()]
+		if !ok {
+			timeout = s.RequestTimeout
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		writer := &deadlineResponseWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if !writer.suppressed && (c.Writer.Written() || !errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+			return
+		}
+
+		s.metrics.Inc("http_request_timeouts_total", 1, "path", c.FullPath())
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{
+			"error":      "Request did not complete within its deadline",
+			"code":       "deadline_exceeded",
+			"timeout_ms": timeout.Milliseconds(),
+		})
+	}
+}
+
+// deadlineResponseWriter drops server-error responses written after the
+// request deadline has passed; they are the handler reporting the timeout
+// in its own words.
+type deadlineResponseWriter struct {
+	gin.ResponseWriter
+	ctx        context.Context
+	suppressed bool
+}
+
+func (w *deadlineResponseWriter) WriteHeader(code int) {
+	if code >= http.StatusInternalServerError && !w.ResponseWriter.Written() &&
+		errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.suppressed = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *deadlineResponseWriter) WriteHeaderNow() {
+	if !w.suppressed {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *deadlineResponseWriter) Write(data []byte) (int, error) {
+	if w.suppressed {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *deadlineResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
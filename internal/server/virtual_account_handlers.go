@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *APIServer) handleListVirtualAccounts(c *gin.Context) {
+	accounts, err := s.db.ListVirtualAccounts(c.Request.Context(), c.Param("customer_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch virtual accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"virtual_accounts": accounts})
+}
+
+// handleProvisionVirtualAccount gives the customer account a dedicated
+// account number, or returns the one it already has.
+func (s *APIServer) handleProvisionVirtualAccount(c *gin.Context) {
+	if s.VirtualAccounts == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Virtual accounts are not configured"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	account, created, err := s.VirtualAccounts.Provision(ctx, customerID)
+	if err != nil {
+		log.Printf("Failed to provision virtual account for %s: %v", customerID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to provision virtual account"})
+		return
+	}
+
+	if !created {
+		c.JSON(http.StatusOK, account)
+		return
+	}
+	c.JSON(http.StatusCreated, account)
+}
+
+// handleCloseVirtualAccount stops the number being handed out. Transfers
+// that still arrive for it are applied to the account as before.
+func (s *APIServer) handleCloseVirtualAccount(c *gin.Context) {
+	account, err := s.db.CloseVirtualAccount(c.Request.Context(), c.Param("account_number"))
+	switch {
+	case errors.Is(err, tools.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Virtual account not found"})
+		return
+	case errors.Is(err, tools.ErrVirtualAccountClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Failed to close virtual account %s: %v", c.Param("account_number"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close virtual account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handlePlaceVoiceCall calls the customer now with the overdue reminder
+// script, whenever they were last called.
+func (s *APIServer) handlePlaceVoiceCall(c *gin.Context) {
+	if s.VoiceReminders == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Voice calls are not configured"})
+		return
+	}
+
+	var request struct {
+		RequestedBy string `json:"requested_by" binding:"required,max=100"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+	if _, err := s.db.GetCustomer(ctx, customerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": msg(c, i18n.MsgCustomerNotFound)})
+		return
+	}
+
+	targets, err := s.db.VoiceReminderTargets(ctx, customerID, 0, 0, 1)
+	if err != nil {
+		log.Printf("Failed to look up arrears of %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to place call"})
+		return
+	}
+	if len(targets) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account is not in arrears"})
+		return
+	}
+
+	call, err := s.VoiceReminders.Call(ctx, targets[0], request.RequestedBy)
+	switch {
+	case errors.Is(err, processors.ErrNoPhone):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Customer has no phone number", "call": call})
+		return
+	case errors.Is(err, notifications.ErrNoConsent):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Customer has not consented to calls on a verified phone", "call": call})
+		return
+	case call == nil && err != nil:
+		log.Printf("Failed to place call to %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to place call"})
+		return
+	case err != nil:
+		log.Printf("Voice provider refused call %s to %s: %v", call.Reference, customerID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Voice provider refused the call", "call": call})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, call)
+}
+
+func (s *APIServer) handleListVoiceCalls(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	calls, err := s.db.ListVoiceCalls(c.Request.Context(), c.Param("customer_id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch calls"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": c.Param("customer_id"), "calls": calls})
+}
+
+// handleVoiceCallback takes the voice provider's callbacks: it answers a
+// call that has just connected with its script and records how calls
+// ended.
+func (s *APIServer) handleVoiceCallback(c *gin.Context) {
+	if s.VoiceProvider == nil || c.Param("provider") != s.VoiceProvider.Name() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Voice calls are not configured"})
+		return
+	}
+
+	event, err := s.VoiceProvider.ParseCallback(c.Request)
+	if errors.Is(err, notifications.ErrInvalidCallback) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid callback signature"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if event.Active {
+		script := ""
+		call, err := s.db.FindVoiceCall(ctx, event.Reference, event.ProviderCallID)
+		if err == nil {
+			script = call.Script
+		} else if !errors.Is(err, tools.ErrNotFound) {
+			log.Printf("Failed to look up script of call %s: %v", event.Reference, err)
+		}
+		c.Data(http.StatusOK, "application/xml", notifications.VoiceScriptXML(script))
+		return
+	}
+
+	call, err := s.db.EndVoiceCall(ctx, event.Reference, event.ProviderCallID, event.Status, event.DurationSeconds, event.Detail)
+	if errors.Is(err, tools.ErrNotFound) {
+		// Test sends from the template editor have no call to update.
+		c.Status(http.StatusOK)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to record outcome of call %s: %v", event.Reference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record call outcome"})
+		return
+	}
+
+	tools.DefaultMetrics.Inc("voice_call_outcomes_total", 1, "status", string(call.Status))
+	log.Printf("Call %s to %s ended %s after %ds", call.Reference, call.CustomerID, call.Status, call.DurationSeconds)
+	c.Status(http.StatusOK)
+}
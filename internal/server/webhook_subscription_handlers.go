@@ -0,0 +1,451 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/abjerry97/go_payment/internal/webhooks"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func webhookSubscriptionID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook subscription id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func bindWebhookSubscription(c *gin.Context) (*api.WebhookSubscriptionRequest, bool) {
+	var request api.WebhookSubscriptionRequest
+	if !validation.BindJSON(c, &request) {
+		return nil, false
+	}
+	if err := tools.ValidateWebhookEventTypes(request.EventTypes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &request, true
+}
+
+func (s *APIServer) handleListWebhookSubscriptions(c *gin.Context) {
+	subscriptions, err := s.db.ListWebhookSubscriptions(c.Request.Context(), c.Query("client_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subscriptions, "event_families": tools.WebhookEventFamilies})
+}
+
+// handleCreateWebhookSubscription registers an endpoint for events. The
+// response carries the signing secret; it isn't shown again.
+func (s *APIServer) handleCreateWebhookSubscription(c *gin.Context) {
+	request, ok := bindWebhookSubscription(c)
+	if !ok {
+		return
+	}
+
+	subscription, err := s.db.CreateWebhookSubscription(c.Request.Context(), request)
+	if err != nil {
+		log.Printf("Failed to create webhook subscription for %s: %v", request.ClientID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook subscription"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, subscription)
+}
+
+func (s *APIServer) handleGetWebhookSubscription(c *gin.Context) {
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+
+	subscription, err := s.db.GetWebhookSubscription(c.Request.Context(), id)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// handleUpdateWebhookSubscription replaces the subscription's URL and event
+// types; active pauses or resumes delivery. Secrets are rotated through
+// the keys endpoint instead.
+func (s *APIServer) handleUpdateWebhookSubscription(c *gin.Context) {
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+	request, ok := bindWebhookSubscription(c)
+	if !ok {
+		return
+	}
+	if request.Secret != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rotate the secret with POST /webhook-subscriptions/:id/keys"})
+		return
+	}
+
+	subscription, err := s.db.UpdateWebhookSubscription(c.Request.Context(), id, request)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update webhook subscription %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (s *APIServer) handleDeleteWebhookSubscription(c *gin.Context) {
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+
+	deleted, err := s.db.DeleteWebhookSubscription(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook subscription"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// handleTestWebhookSubscription sends a webhook.test event to the
+// subscription, active or not, and returns the logged delivery.
+func (s *APIServer) handleTestWebhookSubscription(c *gin.Context) {
+	if s.Webhooks == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Webhooks are not configured"})
+		return
+	}
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	subscription, err := s.db.GetWebhookSubscription(ctx, id)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook subscription"})
+		return
+	}
+
+	event, err := webhooks.NewEvent(tools.Alert{
+		Type:     "webhook.test",
+		Severity: "info",
+		Message:  fmt.Sprintf("Test delivery to webhook subscription %d", id),
+		Details: map[string]interface{}{
+			"subscription_id": id,
+			"client_id":       subscription.ClientID,
+		},
+		Timestamp: s.clock.Now(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build test event"})
+		return
+	}
+
+	delivery, err := s.Webhooks.Deliver(ctx, subscription, event, webhooks.TestDelivery)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record test delivery"})
+		return
+	}
+
+	status := http.StatusOK
+	if !delivery.Success {
+		status = http.StatusBadGateway
+	}
+	c.JSON(status, delivery)
+}
+
+// handleListWebhookDeliveries returns the subscription's delivery log,
+// filtered by ?event_type= and ?success=true|false.
+func (s *APIServer) handleListWebhookDeliveries(c *gin.Context) {
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+
+	filter := tools.WebhookDeliveryFilter{EventType: c.Query("event_type"), Limit: 100}
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &filter.Limit)
+	}
+	if filter.Limit < 1 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+	if success := c.Query("success"); success != "" {
+		value, err := strconv.ParseBool(success)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "success must be true or false"})
+			return
+		}
+		filter.Success = &value
+	}
+
+	ctx := c.Request.Context()
+	if _, err := s.db.GetWebhookSubscription(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+
+	deliveries, err := s.db.ListWebhookDeliveries(ctx, id, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscription_id": id, "deliveries": deliveries})
+}
+
+// handleListWebhookKeys tells subscribers which signing keys are current
+// and when the older ones expire, with the signature tolerance deliveries
+// are checked against. Secrets are never returned here.
+func (s *APIServer) handleListWebhookKeys(c *gin.Context) {
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := s.db.GetWebhookSubscription(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+
+	keys, err := s.db.ListWebhookKeys(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch signing keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscription_id":             id,
+		"keys":                        keys,
+		"signature_header":            "X-Webhook-Signature",
+		"signature_tolerance_seconds": int(s.WebhookSignatureTolerance.Seconds()),
+	})
+}
+
+// handleRotateWebhookKey adds a signing key and returns its secret, once.
+// The previous keys keep signing for grace_hours (WEBHOOK_KEY_GRACE_PERIOD
+// by default) while the partner switches over.
+func (s *APIServer) handleRotateWebhookKey(c *gin.Context) {
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Secret     string `json:"secret" binding:"omitempty,min=16,max=200"`
+		GraceHours *int   `json:"grace_hours" binding:"omitempty,min=0,max=720"`
+	}
+	if !validation.BindJSON(c, &request) {
+		return
+	}
+	grace := s.WebhookKeyGracePeriod
+	if request.GraceHours != nil {
+		grace = time.Duration(*request.GraceHours) * time.Hour
+	}
+
+	key, err := s.db.RotateWebhookKey(c.Request.Context(), id, request.Secret, grace)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to rotate signing key of webhook subscription %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate signing key"})
+		return
+	}
+
+	log.Printf("Webhook subscription %d rotated to signing key %s; previous keys expire in %s", id, key.KeyID, grace)
+	c.JSON(http.StatusCreated, key)
+}
+
+// handleExpireWebhookKey stops signing with a key straight away, for a
+// leaked secret.
+func (s *APIServer) handleExpireWebhookKey(c *gin.Context) {
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+
+	key, err := s.db.ExpireWebhookKey(c.Request.Context(), id, c.Param("key_id"))
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Signing key not found"})
+		return
+	}
+	if errors.Is(err, tools.ErrLastWebhookKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Rotate to a new key before expiring the only current one"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to expire signing key %s of webhook subscription %d: %v", c.Param("key_id"), id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to expire signing key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// parseEventCursor reads an event log cursor; empty is the start of the
+// log.
+func parseEventCursor(c *gin.Context, name string) (int64, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	cursor, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || cursor < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an event cursor"})
+		return 0, false
+	}
+	return cursor, true
+}
+
+// handleListEvents pages through the event log for consumers catching up:
+// events after ?since_cursor=, optionally only ?event_type= or those
+// ?subscription_id= receives. Pass next_cursor back to continue.
+func (s *APIServer) handleListEvents(c *gin.Context) {
+	since, ok := parseEventCursor(c, "since_cursor")
+	if !ok {
+		return
+	}
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	ctx := c.Request.Context()
+	filter := tools.WebhookEventFilter{AfterID: since, Limit: limit + 1}
+	if eventType := c.Query("event_type"); eventType != "" {
+		filter.EventTypes = []string{eventType}
+	}
+	if subscriptionID := c.Query("subscription_id"); subscriptionID != "" {
+		id, err := strconv.ParseInt(subscriptionID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook subscription id"})
+			return
+		}
+		subscription, err := s.db.GetWebhookSubscription(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+			return
+		}
+		if filter.EventTypes != nil && !tools.WebhookEventMatches(subscription.EventTypes, filter.EventTypes[0]) {
+			c.JSON(http.StatusOK, gin.H{"events": []*api.WebhookEvent{}, "next_cursor": since, "has_more": false})
+			return
+		}
+		if filter.EventTypes == nil {
+			filter.EventTypes = subscription.EventTypes
+		}
+	}
+
+	events, err := s.db.ListWebhookEvents(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to list webhook events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch events"})
+		return
+	}
+
+	hasMore := len(events) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+	next := since
+	if len(events) > 0 {
+		next = events[len(events)-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "next_cursor": next, "has_more": hasMore})
+}
+
+// handleReplayWebhookSubscription re-delivers the subscription's events
+// after ?from= (a cursor), up to ?to= when given, marked X-Webhook-Replay.
+// It stops at the first failure; call again from next_cursor to resume.
+func (s *APIServer) handleReplayWebhookSubscription(c *gin.Context) {
+	if s.Webhooks == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Webhooks are not configured"})
+		return
+	}
+	id, ok := webhookSubscriptionID(c)
+	if !ok {
+		return
+	}
+	if c.Query("from") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from is required"})
+		return
+	}
+	from, ok := parseEventCursor(c, "from")
+	if !ok {
+		return
+	}
+	to, ok := parseEventCursor(c, "to")
+	if !ok {
+		return
+	}
+	if to != 0 && to <= from {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from"})
+		return
+	}
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	ctx := c.Request.Context()
+	subscription, err := s.db.GetWebhookSubscription(ctx, id)
+	if errors.Is(err, tools.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook subscription"})
+		return
+	}
+
+	result, err := s.Webhooks.Replay(ctx, subscription, from, to, limit)
+	if err != nil {
+		log.Printf("Replay to webhook subscription %d failed: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay events", "result": result})
+		return
+	}
+
+	log.Printf("Replayed %d events to webhook subscription %d from cursor %d", result.Replayed, id, from)
+	status := http.StatusOK
+	if result.Failed != nil {
+		status = http.StatusBadGateway
+	}
+	c.JSON(status, result)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleWhatsAppSubscribe answers the check WhatsApp makes when the webhook
+// is subscribed by echoing its challenge.
+func (s *APIServer) handleWhatsAppSubscribe(c *gin.Context) {
+	if s.WhatsApp == nil || s.WhatsApp.VerifyToken == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "WhatsApp is not configured"})
+		return
+	}
+	if c.Query("hub.mode") != "subscribe" || c.Query("hub.verify_token") != s.WhatsApp.VerifyToken {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid verify token"})
+		return
+	}
+	c.String(http.StatusOK, c.Query("hub.challenge"))
+}
+
+// handleWhatsAppWebhook records the delivery statuses WhatsApp reports
+// against the notification log entries of the messages.
+func (s *APIServer) handleWhatsAppWebhook(c *gin.Context) {
+	if s.WhatsApp == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "WhatsApp is not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if !s.WhatsApp.VerifySignature(c.GetHeader("X-Hub-Signature-256"), body) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	receipts, err := notifications.ParseWhatsAppStatuses(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	for _, receipt := range receipts {
+		found, err := s.db.RecordDelivery(ctx, receipt.MessageID, receipt.Status, receipt.Error, receipt.At)
+		if err != nil {
+			// WhatsApp retries webhooks that fail.
+			log.Printf("Failed to record WhatsApp status of %s: %v", receipt.MessageID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record delivery status"})
+			return
+		}
+		if !found {
+			log.Printf("WhatsApp status %s for unknown message %s", receipt.Status, receipt.MessageID)
+			continue
+		}
+		tools.DefaultMetrics.Inc("notification_deliveries_total", 1, "channel", string(notifications.ChannelWhatsApp), "status", receipt.Status)
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleSendWhatsAppReceipt sends the receipt for one of the customer's
+// payments to their verified phone over WhatsApp.
+func (s *APIServer) handleSendWhatsAppReceipt(c *gin.Context) {
+	if s.WhatsApp == nil || s.Notifier == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WhatsApp is not configured"})
+		return
+	}
+
+	_, variables, ok := s.receiptVariables(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	customerID := c.Param("customer_id")
+	contact, err := s.db.GetContactProfile(ctx, customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch contact details"})
+		return
+	}
+	if contact.Phone == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Customer has no phone number"})
+		return
+	}
+
+	err = s.Notifier.Send(ctx, notifications.Notification{
+		Channel:    notifications.ChannelWhatsApp,
+		Recipient:  contact.Phone,
+		CustomerID: customerID,
+		Template:   "payment_receipt",
+		Variables:  variables,
+	})
+	if errors.Is(err, notifications.ErrNoConsent) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Customer has not consented to messages on a verified phone"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to send WhatsApp receipt %s: %v", variables["reference"], err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send receipt"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "sent", "channel": notifications.ChannelWhatsApp, "reference": variables["reference"]})
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/gin-gonic/gin"
+)
+
+// handleListWorkers reports what each of this instance's payment workers is
+// doing: idle, or processing which payment since when. Other instances
+// answer for their own workers.
+func (s *APIServer) handleListWorkers(c *gin.Context) {
+	workers := []processors.WorkerStatus{}
+	if s.Processor != nil {
+		workers = append(workers, s.Processor.Workers()...)
+	}
+	c.JSON(http.StatusOK, gin.H{"instance": s.Instance.ID, "workers": workers})
+}
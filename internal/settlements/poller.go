@@ -0,0 +1,143 @@
+package settlements
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/imports"
+	"github.com/abjerry97/go_payment/internal/notifications"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Poller fetches new settlement files from partner sources, runs them through
+// the import pipeline and reports each batch.
+type Poller struct {
+	db       *tools.DatabaseService
+	pipeline *imports.Pipeline
+	sources  []Source
+
+	Alerter     tools.Alerter
+	Notifier    *notifications.Notifier
+	NotifyEmail string
+
+	mu sync.Mutex
+}
+
+func NewPoller(db *tools.DatabaseService, pipeline *imports.Pipeline, sources ...Source) *Poller {
+	return &Poller{db: db, pipeline: pipeline, sources: sources}
+}
+
+// Run polls every source once. It is safe to call from the scheduler and the
+// admin trigger at the same time; overlapping polls wait for each other.
+func (p *Poller) Run(ctx context.Context) error {
+	_, err := p.Poll(ctx)
+	return err
+}
+
+func (p *Poller) Poll(ctx context.Context) ([]*api.ImportBatch, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var batches []*api.ImportBatch
+	var errs []error
+	for _, source := range p.sources {
+		imported, err := p.pollSource(ctx, source)
+		batches = append(batches, imported...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", source.Name(), err))
+		}
+	}
+	return batches, errors.Join(errs...)
+}
+
+func (p *Poller) pollSource(ctx context.Context, source Source) ([]*api.ImportBatch, error) {
+	defer source.Close()
+
+	files, err := source.List(ctx)
+	if err != nil {
+		tools.DefaultMetrics.Inc("settlement_poll_errors_total", 1, "source", source.Name())
+		return nil, fmt.Errorf("list: %v", err)
+	}
+
+	var batches []*api.ImportBatch
+	for _, file := range files {
+		key := file.Key(source.Name())
+		known, err := p.db.ImportFileKnown(ctx, key)
+		if err != nil {
+			return batches, err
+		}
+		if known {
+			continue
+		}
+
+		body, err := source.Fetch(ctx, file.Name)
+		if err != nil {
+			// Leave it for the next poll; the file may still be uploading.
+			log.Printf("Settlement %s/%s: fetch failed: %v", source.Name(), file.Name, err)
+			tools.DefaultMetrics.Inc("settlement_poll_errors_total", 1, "source", source.Name())
+			continue
+		}
+
+		batch, err := p.pipeline.ImportCSV(ctx, source.Name(), file.Name, key, bytes.NewReader(body))
+		if err != nil {
+			return batches, err
+		}
+		if batch == nil {
+			continue
+		}
+
+		log.Printf("Settlement %s/%s: batch %d %s, %d queued, %d duplicate, %d failed",
+			source.Name(), file.Name, batch.ID, batch.Status, batch.QueuedRows, batch.DuplicateRows, batch.FailedRows)
+		p.report(ctx, batch)
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+func (p *Poller) report(ctx context.Context, batch *api.ImportBatch) {
+	severity := "info"
+	if batch.Status != api.ImportCompleted || batch.FailedRows > 0 {
+		severity = "warning"
+	}
+
+	message := fmt.Sprintf("Settlement file %s from %s: %d rows, %d queued, %d duplicate, %d failed",
+		batch.FileName, batch.Source, batch.TotalRows, batch.QueuedRows, batch.DuplicateRows, batch.FailedRows)
+	switch batch.Status {
+	case api.ImportFailed:
+		message = fmt.Sprintf("Settlement file %s from %s failed: %s", batch.FileName, batch.Source, batch.Error)
+	case api.ImportCancelled:
+		message = fmt.Sprintf("Settlement file %s from %s cancelled by %s after %d rows: %d queued, %d duplicate, %d failed",
+			batch.FileName, batch.Source, batch.CancelledBy, batch.TotalRows, batch.QueuedRows, batch.DuplicateRows, batch.FailedRows)
+	}
+
+	if p.Alerter != nil {
+		err := p.Alerter.Send(ctx, tools.Alert{
+			Type:      "settlement_import." + string(batch.Status),
+			Severity:  severity,
+			Message:   message,
+			Details:   map[string]interface{}{"batch": batch},
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			log.Printf("Warning: failed to send settlement webhook for batch %d: %v", batch.ID, err)
+		}
+	}
+
+	if p.Notifier != nil && p.NotifyEmail != "" {
+		err := p.Notifier.Send(ctx, notifications.Notification{
+			Channel:   notifications.ChannelEmail,
+			Recipient: p.NotifyEmail,
+			Message:   message,
+			Essential: true,
+		})
+		if err != nil {
+			log.Printf("Warning: failed to email settlement report for batch %d: %v", batch.ID, err)
+		}
+	}
+}
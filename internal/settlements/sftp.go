@@ -0,0 +1,561 @@
+package settlements
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPConfig describes a partner's SFTP drop. HostKeyFingerprint is the
+// server's SHA256 fingerprint ("SHA256:..."), as printed by ssh-keygen -l; it
+// is required so credentials are never sent to an impostor.
+type SFTPConfig struct {
+	Name               string
+	Addr               string
+	User               string
+	Password           string
+	PrivateKey         string
+	HostKeyFingerprint string
+	Dir                string
+	Pattern            string
+	Timeout            time.Duration
+}
+
+// SFTPSource fetches settlement files over SFTP. The connection is opened on
+// the first List or Fetch and reused until Close.
+type SFTPSource struct {
+	config SFTPConfig
+	conn   *ssh.Client
+	client *sftpClient
+}
+
+func NewSFTPSource(config SFTPConfig) (*SFTPSource, error) {
+	if config.Addr == "" || config.User == "" {
+		return nil, errors.New("sftp source requires an address and user")
+	}
+	if config.Password == "" && config.PrivateKey == "" {
+		return nil, errors.New("sftp source requires a password or private key")
+	}
+	if !strings.HasPrefix(config.HostKeyFingerprint, "SHA256:") {
+		return nil, errors.New("sftp source requires the server's SHA256 host key fingerprint")
+	}
+	if !strings.Contains(config.Addr, ":") {
+		config.Addr += ":22"
+	}
+	if config.Dir == "" {
+		config.Dir = "."
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	return &SFTPSource{config: config}, nil
+}
+
+func (s *SFTPSource) Name() string { return s.config.Name }
+
+func (s *SFTPSource) connect(ctx context.Context) (*sftpClient, error) {
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	var auth []ssh.AuthMethod
+	if s.config.PrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(s.config.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.config.Password != "" {
+		auth = append(auth, ssh.Password(s.config.Password))
+	}
+
+	expected := s.config.HostKeyFingerprint
+	sshConfig := &ssh.ClientConfig{
+		User: s.config.User,
+		Auth: auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if got := ssh.FingerprintSHA256(key); got != expected {
+				return fmt.Errorf("host key mismatch for %s: got %s", hostname, got)
+			}
+			return nil
+		},
+		Timeout: s.config.Timeout,
+	}
+
+	dialer := net.Dialer{Timeout: s.config.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, s.config.Addr, sshConfig)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	s.conn = ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := newSFTPClient(s.conn)
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+func (s *SFTPSource) List(ctx context.Context) ([]RemoteFile, error) {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := client.readDir(s.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []RemoteFile
+	for _, entry := range entries {
+		if !entry.regular() || !matchPattern(s.config.Pattern, entry.name) {
+			continue
+		}
+		files = append(files, RemoteFile{Name: entry.name, Size: int64(entry.size), ModTime: time.Unix(int64(entry.mtime), 0)})
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+func (s *SFTPSource) Fetch(ctx context.Context, name string) ([]byte, error) {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.readFile(path.Join(s.config.Dir, path.Base(name)), maxFileBytes)
+}
+
+func (s *SFTPSource) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	s.client.close()
+	err := s.conn.Close()
+	s.conn, s.client = nil, nil
+	return err
+}
+
+// SFTPTarget uploads files to an SFTP drop, such as an accounting system's
+// import directory. Pattern is unused.
+type SFTPTarget struct {
+	source *SFTPSource
+}
+
+func NewSFTPTarget(config SFTPConfig) (*SFTPTarget, error) {
+	source, err := NewSFTPSource(config)
+	if err != nil {
+		return nil, err
+	}
+	return &SFTPTarget{source: source}, nil
+}
+
+// Upload writes data to name in the configured directory, replacing any
+// file of that name.
+func (t *SFTPTarget) Upload(ctx context.Context, name string, data []byte) error {
+	client, err := t.source.connect(ctx)
+	if err != nil {
+		return err
+	}
+	return client.writeFile(path.Join(t.source.config.Dir, path.Base(name)), data)
+}
+
+func (t *SFTPTarget) Close() error { return t.source.Close() }
+
+// SFTP protocol version 3 (draft-ietf-secsh-filexfer-02), the version every
+// server supports. Only the subset needed to list, download and upload
+// files is implemented, one request at a time.
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxStatusOK  = 0
+	fxStatusEOF = 1
+
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+
+	openRead      = 0x00000001
+	openWrite     = 0x00000002
+	openCreate    = 0x00000008
+	openTruncate  = 0x00000010
+	readChunk     = 32 << 10
+	maxPacketSize = 256 << 10
+)
+
+type sftpClient struct {
+	session *ssh.Session
+	in      io.WriteCloser
+	out     io.Reader
+	nextID  uint32
+}
+
+type sftpEntry struct {
+	name        string
+	size        uint64
+	permissions uint32
+	mtime       uint32
+}
+
+func (e sftpEntry) regular() bool {
+	return e.permissions&0170000 == 0100000
+}
+
+func newSFTPClient(conn *ssh.Client) (*sftpClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	in, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	out, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("sftp subsystem: %v", err)
+	}
+
+	client := &sftpClient{session: session, in: in, out: out}
+
+	var init packetWriter
+	init.uint32(3)
+	if err := client.send(fxpInit, init.Bytes()); err != nil {
+		session.Close()
+		return nil, err
+	}
+	typ, _, err := client.recv()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if typ != fxpVersion {
+		session.Close()
+		return nil, fmt.Errorf("sftp: unexpected packet %d during init", typ)
+	}
+	return client, nil
+}
+
+func (c *sftpClient) close() {
+	c.in.Close()
+	c.session.Close()
+}
+
+func (c *sftpClient) send(typ byte, payload []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)+1))
+	header[4] = typ
+	if _, err := c.in.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *sftpClient) recv() (byte, []byte, error) {
+	var length uint32
+	if err := binary.Read(c.out, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	if length == 0 || length > maxPacketSize {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(c.out, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// request sends one request and returns the response body after its id.
+func (c *sftpClient) request(typ byte, build func(w *packetWriter)) (byte, *packetReader, error) {
+	c.nextID++
+	id := c.nextID
+
+	var w packetWriter
+	w.uint32(id)
+	build(&w)
+	if err := c.send(typ, w.Bytes()); err != nil {
+		return 0, nil, err
+	}
+
+	respType, body, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	r := &packetReader{data: body}
+	if respID := r.uint32(); respID != id {
+		return 0, nil, fmt.Errorf("sftp: response id %d for request %d", respID, id)
+	}
+	return respType, r, r.err
+}
+
+func (c *sftpClient) handle(typ byte, build func(w *packetWriter)) (string, error) {
+	respType, r, err := c.request(typ, build)
+	if err != nil {
+		return "", err
+	}
+	switch respType {
+	case fxpHandle:
+		return r.string(), r.err
+	case fxpStatus:
+		return "", statusError(r.uint32(), r)
+	default:
+		return "", fmt.Errorf("sftp: unexpected packet %d", respType)
+	}
+}
+
+func (c *sftpClient) closeHandle(handle string) {
+	c.request(fxpClose, func(w *packetWriter) { w.string(handle) })
+}
+
+func (c *sftpClient) readDir(dir string) ([]sftpEntry, error) {
+	handle, err := c.handle(fxpOpendir, func(w *packetWriter) { w.string(dir) })
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", dir, err)
+	}
+	defer c.closeHandle(handle)
+
+	var entries []sftpEntry
+	for {
+		respType, r, err := c.request(fxpReaddir, func(w *packetWriter) { w.string(handle) })
+		if err != nil {
+			return nil, err
+		}
+		if respType == fxpStatus {
+			code := r.uint32()
+			if code == fxStatusEOF {
+				return entries, nil
+			}
+			return nil, statusError(code, r)
+		}
+		if respType != fxpName {
+			return nil, fmt.Errorf("sftp: unexpected packet %d", respType)
+		}
+
+		count := r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			entry := sftpEntry{name: r.string()}
+			r.string() // longname
+			entry.size, entry.permissions, entry.mtime = r.attrs()
+			if entry.name != "." && entry.name != ".." {
+				entries = append(entries, entry)
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+}
+
+func (c *sftpClient) readFile(name string, limit int) ([]byte, error) {
+	handle, err := c.handle(fxpOpen, func(w *packetWriter) {
+		w.string(name)
+		w.uint32(openRead)
+		w.uint32(0) // no attributes
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", name, err)
+	}
+	defer c.closeHandle(handle)
+
+	var data bytes.Buffer
+	for {
+		offset := uint64(data.Len())
+		respType, r, err := c.request(fxpRead, func(w *packetWriter) {
+			w.string(handle)
+			w.uint64(offset)
+			w.uint32(readChunk)
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch respType {
+		case fxpData:
+			chunk := r.string()
+			if r.err != nil {
+				return nil, r.err
+			}
+			data.WriteString(chunk)
+			if data.Len() > limit {
+				return nil, fmt.Errorf("%s exceeds the %d byte limit", name, limit)
+			}
+		case fxpStatus:
+			code := r.uint32()
+			if code == fxStatusEOF {
+				return data.Bytes(), nil
+			}
+			return nil, statusError(code, r)
+		default:
+			return nil, fmt.Errorf("sftp: unexpected packet %d", respType)
+		}
+	}
+}
+
+func (c *sftpClient) writeFile(name string, data []byte) error {
+	handle, err := c.handle(fxpOpen, func(w *packetWriter) {
+		w.string(name)
+		w.uint32(openWrite | openCreate | openTruncate)
+		w.uint32(0) // no attributes
+	})
+	if err != nil {
+		return fmt.Errorf("open %s: %v", name, err)
+	}
+
+	for offset := 0; offset < len(data); offset += readChunk {
+		chunk := data[offset:min(offset+readChunk, len(data))]
+		if err := c.status(fxpWrite, func(w *packetWriter) {
+			w.string(handle)
+			w.uint64(uint64(offset))
+			w.string(string(chunk))
+		}); err != nil {
+			c.closeHandle(handle)
+			return fmt.Errorf("write %s: %v", name, err)
+		}
+	}
+	// Servers may only report a failed write when the file is closed.
+	if err := c.status(fxpClose, func(w *packetWriter) { w.string(handle) }); err != nil {
+		return fmt.Errorf("close %s: %v", name, err)
+	}
+	return nil
+}
+
+// status sends a request answered with a status and returns it as an error
+// unless it is OK.
+func (c *sftpClient) status(typ byte, build func(w *packetWriter)) error {
+	respType, r, err := c.request(typ, build)
+	if err != nil {
+		return err
+	}
+	if respType != fxpStatus {
+		return fmt.Errorf("sftp: unexpected packet %d", respType)
+	}
+	if code := r.uint32(); code != fxStatusOK {
+		return statusError(code, r)
+	}
+	return nil
+}
+
+func statusError(code uint32, r *packetReader) error {
+	message := r.string()
+	if message == "" {
+		message = "request failed"
+	}
+	return fmt.Errorf("sftp status %d: %s", code, message)
+}
+
+type packetWriter struct {
+	bytes.Buffer
+}
+
+func (w *packetWriter) uint32(v uint32) {
+	binary.Write(&w.Buffer, binary.BigEndian, v)
+}
+
+func (w *packetWriter) uint64(v uint64) {
+	binary.Write(&w.Buffer, binary.BigEndian, v)
+}
+
+func (w *packetWriter) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.WriteString(s)
+}
+
+type packetReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *packetReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off+n > len(r.data) {
+		r.err = errors.New("sftp: truncated packet")
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *packetReader) uint32() uint32 {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *packetReader) uint64() uint64 {
+	b := r.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *packetReader) string() string {
+	return string(r.take(int(r.uint32())))
+}
+
+func (r *packetReader) attrs() (size uint64, permissions uint32, mtime uint32) {
+	flags := r.uint32()
+	if flags&attrSize != 0 {
+		size = r.uint64()
+	}
+	if flags&attrUIDGID != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&attrPermissions != 0 {
+		permissions = r.uint32()
+	}
+	if flags&attrACModTime != 0 {
+		r.uint32()
+		mtime = r.uint32()
+	}
+	if flags&attrExtended != 0 {
+		count := r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			r.string()
+			r.string()
+		}
+	}
+	return size, permissions, mtime
+}
@@ -0,0 +1,102 @@
+package settlements
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxFileBytes bounds a single settlement file; anything larger is almost
+// certainly not a settlement report and would be held in memory.
+const maxFileBytes = 256 << 20
+
+type RemoteFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Key identifies this version of the file so a re-uploaded file with new
+// contents is imported again while an unchanged one is skipped.
+func (f RemoteFile) Key(source string) string {
+	return fmt.Sprintf("%s:%s:%d:%d", source, f.Name, f.Size, f.ModTime.Unix())
+}
+
+// Source is somewhere partners drop settlement files. A poll lists, fetches
+// the new files and then closes the source.
+type Source interface {
+	Name() string
+	List(ctx context.Context) ([]RemoteFile, error)
+	Fetch(ctx context.Context, name string) ([]byte, error)
+	Close() error
+}
+
+// DirSource reads files from a local or mounted directory.
+type DirSource struct {
+	name    string
+	dir     string
+	pattern string
+}
+
+func NewDirSource(name, dir, pattern string) *DirSource {
+	return &DirSource{name: name, dir: dir, pattern: pattern}
+}
+
+func (s *DirSource) Name() string { return s.name }
+
+func (s *DirSource) List(ctx context.Context) ([]RemoteFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []RemoteFile
+	for _, entry := range entries {
+		if entry.IsDir() || !matchPattern(s.pattern, entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, RemoteFile{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+func (s *DirSource) Fetch(ctx context.Context, name string) ([]byte, error) {
+	path := filepath.Join(s.dir, filepath.Base(name))
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxFileBytes {
+		return nil, fmt.Errorf("%s is %d bytes, over the %d byte limit", name, info.Size(), maxFileBytes)
+	}
+	return os.ReadFile(path)
+}
+
+func (s *DirSource) Close() error { return nil }
+
+func matchPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := filepath.Match(pattern, name)
+	return err == nil && matched
+}
+
+// sortFiles orders files oldest first so settlements are applied in the
+// order the partner produced them.
+func sortFiles(files []RemoteFile) {
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].ModTime.Equal(files[j].ModTime) {
+			return files[i].ModTime.Before(files[j].ModTime)
+		}
+		return files[i].Name < files[j].Name
+	})
+}
@@ -0,0 +1,217 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sheetsAPI   = "https://sheets.googleapis.com/v4/spreadsheets/"
+	sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+// SheetsSink appends rows to a Google Sheet as a service account, which
+// must be shared on the spreadsheet as an editor. Columns are applied at,
+// value date, reference, customer, amount, balance after, currency,
+// channel and agent. The Sheets append call
+// isn't idempotent, so a batch whose response is lost can appear twice.
+type SheetsSink struct {
+	spreadsheetID string
+	sheetRange    string
+	email         string
+	key           *rsa.PrivateKey
+	tokenURL      string
+	client        *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewSheetsSink reads a service account key file as downloaded from the
+// Google Cloud console. sheetRange names the sheet to append to, e.g.
+// "Payments!A:I".
+func NewSheetsSink(spreadsheetID, sheetRange string, credentials []byte) (*SheetsSink, error) {
+	var account struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(credentials, &account); err != nil {
+		return nil, fmt.Errorf("service account key: %w", err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, errors.New("service account key has no client_email or private_key")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	key, err := parsePrivateKey(account.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("service account key: %w", err)
+	}
+
+	return &SheetsSink{
+		spreadsheetID: spreadsheetID,
+		sheetRange:    sheetRange,
+		email:         account.ClientEmail,
+		key:           key,
+		tokenURL:      account.TokenURI,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("private_key is not PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private_key is not an RSA key")
+	}
+	return key, nil
+}
+
+func (s *SheetsSink) Name() string { return "sheets" }
+
+func (s *SheetsSink) Append(ctx context.Context, rows []Row) error {
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = []interface{}{
+			row.AppliedAt.UTC().Format("2006-01-02 15:04:05"),
+			row.ValueDate.Format("2006-01-02"),
+			row.TransactionReference,
+			row.CustomerID,
+			row.Amount,
+			row.BalanceAfter,
+			row.Currency,
+			row.Channel,
+			row.AgentID,
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"values": values})
+	if err != nil {
+		return err
+	}
+
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("sheets token: %w", err)
+	}
+
+	endpoint := sheetsAPI + url.PathEscape(s.spreadsheetID) + "/values/" + url.PathEscape(s.sheetRange) +
+		":append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sheets append returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// accessToken returns a cached OAuth token, exchanging a signed JWT for a
+// new one when it is about to expire.
+func (s *SheetsSink) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != "" && now.Before(s.expires.Add(-time.Minute)) {
+		return s.token, nil
+	}
+
+	assertion, err := s.signJWT(now)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	json.NewDecoder(resp.Body).Decode(&response)
+	if resp.StatusCode >= 300 || response.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, response.Error)
+	}
+
+	s.token = response.AccessToken
+	s.expires = now.Add(time.Duration(response.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+func (s *SheetsSink) signJWT(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   s.email,
+		"scope": sheetsScope,
+		"aud":   s.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,137 @@
+// Package sinks exports applied payments to outside tools, such as the
+// operations team's Google Sheet, in batches.
+package sinks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Row is one applied payment as exported.
+type Row struct {
+	AppliedAt            time.Time `json:"applied_at"`
+	ValueDate            time.Time `json:"value_date"`
+	TransactionReference string    `json:"transaction_reference"`
+	CustomerID           string    `json:"customer_id"`
+	Amount               float64   `json:"amount"`
+	BalanceAfter         float64   `json:"balance_after"`
+	Currency             string    `json:"currency,omitempty"`
+	Channel              string    `json:"channel,omitempty"`
+	AgentID              string    `json:"agent_id,omitempty"`
+}
+
+// Sink receives batches of applied payments. Append either takes the whole
+// batch or returns an error, in which case it is tried again later.
+type Sink interface {
+	Name() string
+	Append(ctx context.Context, rows []Row) error
+}
+
+// Exporter buffers applied payments and hands them to a sink in batches:
+// as soon as BatchSize are waiting, and on every Flush. It is a payment
+// processor hook; add it with PaymentProcessor.Use, and register Flush
+// with the scheduler.
+//
+// The buffer is in memory, so payments applied just before a crash may not
+// be exported; the sink is a convenience copy, not a ledger.
+type Exporter struct {
+	processors.HookFuncs
+	sink Sink
+
+	BatchSize int
+	// MaxBuffered bounds the buffer while the sink is down; the oldest
+	// rows are dropped beyond it.
+	MaxBuffered int
+
+	mu       sync.Mutex
+	pending  []Row
+	flushing sync.Mutex
+}
+
+func NewExporter(sink Sink) *Exporter {
+	e := &Exporter{sink: sink, BatchSize: 100, MaxBuffered: 10000}
+	e.After = e.add
+	return e
+}
+
+func (e *Exporter) add(ctx context.Context, payment *api.PaymentPayload, result processors.ApplyResult) {
+	row := Row{
+		AppliedAt:            time.Now(),
+		ValueDate:            result.ValueDate,
+		TransactionReference: payment.TransactionReference,
+		CustomerID:           payment.CustomerID,
+		Amount:               result.Amount,
+		BalanceAfter:         result.NewBalance,
+		Currency:             payment.Currency,
+		Channel:              payment.Channel,
+		AgentID:              payment.AgentID,
+	}
+
+	e.mu.Lock()
+	e.pending = append(e.pending, row)
+	e.trim()
+	full := len(e.pending) >= e.BatchSize
+	e.mu.Unlock()
+
+	if full {
+		// Don't hold up the payment worker on the sink.
+		go func() {
+			if err := e.Flush(context.WithoutCancel(ctx)); err != nil {
+				log.Warnf("Failed to export payments to %s: %v", e.sink.Name(), err)
+			}
+		}()
+	}
+}
+
+// Flush sends everything buffered, a batch at a time. Rows of a failed
+// batch stay buffered for the next flush.
+func (e *Exporter) Flush(ctx context.Context) error {
+	e.flushing.Lock()
+	defer e.flushing.Unlock()
+
+	for {
+		e.mu.Lock()
+		n := min(len(e.pending), e.BatchSize)
+		batch := e.pending[:n:n]
+		e.pending = e.pending[n:]
+		e.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		if err := e.sink.Append(ctx, batch); err != nil {
+			e.mu.Lock()
+			e.pending = append(batch, e.pending...)
+			e.trim()
+			e.mu.Unlock()
+			tools.DefaultMetrics.Inc("payment_sink_failures_total", 1, "sink", e.sink.Name())
+			return err
+		}
+		tools.DefaultMetrics.Inc("payment_sink_rows_total", float64(n), "sink", e.sink.Name())
+	}
+}
+
+// Pending is the number of rows waiting to be exported.
+func (e *Exporter) Pending() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.pending)
+}
+
+// trim drops the oldest rows beyond MaxBuffered. e.mu must be held.
+func (e *Exporter) trim() {
+	if e.MaxBuffered <= 0 || len(e.pending) <= e.MaxBuffered {
+		return
+	}
+	dropped := len(e.pending) - e.MaxBuffered
+	e.pending = e.pending[dropped:]
+	tools.DefaultMetrics.Inc("payment_sink_dropped_total", float64(dropped), "sink", e.sink.Name())
+	log.Warnf("Payment export to %s is behind; dropped %d oldest rows", e.sink.Name(), dropped)
+}
@@ -0,0 +1,170 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/tools"
+)
+
+var ErrTemplateNotFound = errors.New("template not found")
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Defaults is the built-in copy, used until a template of the same ID is
+// saved.
+var Defaults = map[string]api.MessageTemplate{
+	"payment_receipt": {
+		Channel: api.TemplateReceipt,
+		Subject: "Payment receipt {{.reference}}",
+		Body: "Payment received\n" +
+			"Account: {{.customer_id}}\n" +
+			"Reference: {{.reference}}\n" +
+			"Amount: {{.amount}}\n" +
+			"Date: {{.date}}\n" +
+			"Balance after payment: {{.balance_after}}\n" +
+			"Thank you.",
+		Variables: []string{"customer_id", "reference", "amount", "date", "balance_after"},
+	},
+	"dunning_retrying": {
+		Channel:   api.TemplateSMS,
+		Body:      "Your automatic payment of {{.amount}} didn't go through. We'll try again on {{.retry_date}}.",
+		Variables: []string{"customer_id", "amount", "retry_date", "failed_attempts"},
+	},
+	"dunning_retrying_repeat": {
+		Channel:   api.TemplateSMS,
+		Body:      "Your automatic payment of {{.amount}} has failed {{.failed_attempts}} times. Please make sure funds are available before we try again on {{.retry_date}}.",
+		Variables: []string{"customer_id", "amount", "retry_date", "failed_attempts"},
+	},
+	"dunning_final_notice": {
+		Channel:   api.TemplateEmail,
+		Subject:   "Final notice for account {{.customer_id}}",
+		Body:      "Final notice: we couldn't collect your payment of {{.amount}}. Please pay by {{.deadline}} to keep your account in good standing.",
+		Variables: []string{"customer_id", "amount", "deadline"},
+	},
+	"overdue_reminder_voice": {
+		Channel: api.TemplateVoice,
+		Body: "Hello. This is a reminder about your account {{.customer_id}}. " +
+			"Your payments are {{.days_past_due}} days overdue, with {{.arrears}} to pay. " +
+			"Please make a payment as soon as you can. Thank you.",
+		Variables: []string{"customer_id", "arrears", "days_past_due", "outstanding_balance"},
+	},
+	"dunning_delinquent": {
+		Channel:   api.TemplateEmail,
+		Subject:   "Account {{.customer_id}} is delinquent",
+		Body:      "Your account {{.customer_id}} is now delinquent with {{.amount}} overdue. Please pay as soon as possible or contact us.",
+		Variables: []string{"customer_id", "amount"},
+	},
+}
+
+// Renderer looks up templates, falling back from the requested locale to
+// the default locale and then to Defaults.
+type Renderer struct {
+	db *tools.DatabaseService
+}
+
+func NewRenderer(db *tools.DatabaseService) *Renderer {
+	return &Renderer{db: db}
+}
+
+// Template returns the given version of the template; version 0 is the
+// latest, which may be the built-in default.
+func (r *Renderer) Template(ctx context.Context, templateID, locale string, version int) (*api.MessageTemplate, error) {
+	locales := []string{locale}
+	if locale != i18n.DefaultLocale {
+		locales = append(locales, i18n.DefaultLocale)
+	}
+	for _, locale := range locales {
+		template, err := r.db.GetMessageTemplate(ctx, templateID, locale, version)
+		if err == nil {
+			return template, nil
+		}
+		if !errors.Is(err, tools.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	if builtin, ok := Defaults[templateID]; ok && version == 0 {
+		builtin.TemplateID = templateID
+		builtin.Locale = i18n.DefaultLocale
+		return &builtin, nil
+	}
+	return nil, ErrTemplateNotFound
+}
+
+// Render fills in the latest template for locale with variables.
+func (r *Renderer) Render(ctx context.Context, templateID, locale string, variables map[string]string) (*api.RenderedMessage, error) {
+	template, err := r.Template(ctx, templateID, locale, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", templateID, err)
+	}
+	return Execute(template, variables)
+}
+
+// Execute fills in the template. Variables that aren't given render empty.
+func Execute(t *api.MessageTemplate, variables map[string]string) (*api.RenderedMessage, error) {
+	rendered := &api.RenderedMessage{
+		TemplateID: t.TemplateID,
+		Locale:     t.Locale,
+		Version:    t.Version,
+		Channel:    t.Channel,
+	}
+
+	var err error
+	if rendered.Subject, err = execute("subject", t.Subject, variables, "missingkey=zero"); err != nil {
+		return nil, err
+	}
+	if rendered.Body, err = execute("body", t.Body, variables, "missingkey=zero"); err != nil {
+		return nil, err
+	}
+	return rendered, nil
+}
+
+// Validate checks the template ID and variable names and that the subject
+// and body parse and use only the declared variables.
+func Validate(t *api.MessageTemplate) error {
+	if len(t.TemplateID) > 50 || !namePattern.MatchString(t.TemplateID) {
+		return fmt.Errorf("template_id must be lower-case letters, digits and underscores, at most 50 characters")
+	}
+
+	declared := map[string]string{}
+	for _, name := range t.Variables {
+		if !namePattern.MatchString(name) {
+			return fmt.Errorf("variable %q must be lower-case letters, digits and underscores", name)
+		}
+		declared[name] = name
+	}
+
+	if _, err := execute("subject", t.Subject, declared, "missingkey=error"); err != nil {
+		return err
+	}
+	if _, err := execute("body", t.Body, declared, "missingkey=error"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func execute(name, text string, variables map[string]string, option string) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	parsed, err := template.New(name).Option(option).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+
+	if variables == nil {
+		variables = map[string]string{}
+	}
+	var out strings.Builder
+	if err := parsed.Execute(&out, variables); err != nil {
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return out.String(), nil
+}
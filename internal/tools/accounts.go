@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// ErrNotPrimaryAccount is returned when opening an account for an ID that is
+// itself an additional account rather than a customer.
+var ErrNotPrimaryAccount = errors.New("accounts can only be opened for a customer's primary account")
+
+// NewAccount describes an additional account to open for a customer.
+type NewAccount struct {
+	AssetType      string
+	AssetValue     float64
+	TermWeeks      int
+	DeploymentDate time.Time
+	Region         string
+	Branch         string
+}
+
+// OpenAccount opens another account for the holder, numbered after their
+// existing ones, e.g. GIG00001-2. The holder's own (primary) account must
+// exist. Region, branch and calendar default to the primary account's.
+func (db *DatabaseService) OpenAccount(ctx context.Context, holderID string, account NewAccount) (*api.CustomerAccount, error) {
+	holder, err := db.GetCustomer(ctx, holderID)
+	if err != nil {
+		return nil, err
+	}
+	if holder.HolderID != holder.CustomerID {
+		return nil, ErrNotPrimaryAccount
+	}
+
+	query := `
+		INSERT INTO customer_accounts (
+			customer_id, holder_id, asset_type, asset_value, outstanding_balance, term_weeks,
+			deployment_date, region, branch, calendar_code
+		)
+		SELECT $1 || '-' || (COUNT(a.customer_id) + 2), $1, NULLIF($2, ''), $3, $3, $4, $5,
+		       COALESCE(NULLIF($6, ''), p.region), COALESCE(NULLIF($7, ''), p.branch), p.calendar_code
+		FROM customer_accounts p
+		LEFT JOIN customer_accounts a ON a.holder_id = p.customer_id
+		WHERE p.customer_id = $1
+		GROUP BY p.customer_id
+		RETURNING ` + customerColumns
+
+	return scanCustomer(db.QueryRow(ctx, query, holderID, account.AssetType, account.AssetValue, account.TermWeeks,
+		account.DeploymentDate, account.Region, account.Branch))
+}
+
+// ListHolderAccounts returns every account the customer holds, primary
+// first.
+func (db *DatabaseService) ListHolderAccounts(ctx context.Context, holderID string) ([]*api.CustomerAccount, error) {
+	query := `
+		SELECT ` + customerColumns + ` FROM customer_accounts
+		WHERE customer_id = $1 OR holder_id = $1
+		ORDER BY holder_id NULLS FIRST, created_at, customer_id
+	`
+
+	rows, err := db.Query(ctx, query, holderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*api.CustomerAccount{}
+	for rows.Next() {
+		account, err := scanCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, errNoRows
+	}
+	return accounts, nil
+}
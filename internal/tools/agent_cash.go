@@ -0,0 +1,188 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrDepositReviewed is returned when confirming or rejecting a deposit that
+// is no longer awaiting review.
+var ErrDepositReviewed = errors.New("deposit has already been reviewed")
+
+const agentDepositColumns = `
+	id, reference, agent_id, amount, COALESCE(bank, ''), to_char(deposited_on, 'YYYY-MM-DD'), status,
+	declared_by, declared_at, COALESCE(reviewed_by, ''), reviewed_at, COALESCE(reason, '')
+`
+
+func scanAgentDeposit(row pgx.Row) (*api.AgentDeposit, error) {
+	var d api.AgentDeposit
+	err := row.Scan(&d.ID, &d.Reference, &d.AgentID, &d.Amount, &d.Bank, &d.DepositedOn, &d.Status,
+		&d.DeclaredBy, &d.DeclaredAt, &d.ReviewedBy, &d.ReviewedAt, &d.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DeclareAgentDeposit records a deposit for the agent. The bank reference is
+// unique, so a slip declared twice is returned with created false.
+func (db *DatabaseService) DeclareAgentDeposit(ctx context.Context, agentID string, request api.AgentDepositRequest, depositedOn time.Time) (*api.AgentDeposit, bool, error) {
+	deposit, err := scanAgentDeposit(db.QueryRow(ctx, `
+		INSERT INTO agent_deposits (reference, agent_id, amount, bank, deposited_on, declared_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		ON CONFLICT (reference) DO NOTHING
+		RETURNING `+agentDepositColumns,
+		request.Reference, agentID, request.Amount, request.Bank, depositedOn, request.DeclaredBy))
+	if errors.Is(err, ErrNotFound) {
+		deposit, err = scanAgentDeposit(db.QueryRow(ctx,
+			`SELECT `+agentDepositColumns+` FROM agent_deposits WHERE reference = $1`, request.Reference))
+		return deposit, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return deposit, true, nil
+}
+
+// ListAgentDeposits returns deposits, latest first, optionally for one agent
+// or in one status.
+func (db *DatabaseService) ListAgentDeposits(ctx context.Context, agentID string, status api.AgentDepositStatus, limit int) ([]*api.AgentDeposit, error) {
+	query := `
+		SELECT ` + agentDepositColumns + `
+		FROM agent_deposits
+		WHERE ($1 = '' OR agent_id = $1) AND ($2 = '' OR status = $2)
+		ORDER BY deposited_on DESC, id DESC
+		LIMIT $3
+	`
+
+	rows, err := db.Query(ctx, query, agentID, string(status), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deposits := []*api.AgentDeposit{}
+	for rows.Next() {
+		deposit, err := scanAgentDeposit(rows)
+		if err != nil {
+			return nil, err
+		}
+		deposits = append(deposits, deposit)
+	}
+	return deposits, rows.Err()
+}
+
+// ReviewAgentDeposit confirms a declared deposit against the bank statement
+// or rejects it, taking it out of the agent's cash position.
+func (db *DatabaseService) ReviewAgentDeposit(ctx context.Context, id int64, status api.AgentDepositStatus, reviewedBy, reason string) (*api.AgentDeposit, error) {
+	deposit, err := scanAgentDeposit(db.QueryRow(ctx, `
+		UPDATE agent_deposits
+		SET status = $2, reviewed_by = $3, reviewed_at = NOW(), reason = NULLIF($4, '')
+		WHERE id = $1 AND status = 'DECLARED'
+		RETURNING `+agentDepositColumns, id, status, reviewedBy, reason))
+	if !errors.Is(err, ErrNotFound) {
+		return deposit, err
+	}
+
+	var exists bool
+	if err := db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM agent_deposits WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrDepositReviewed
+	}
+	return nil, errNoRows
+}
+
+// GetAgentCashPositions compares what each agent, or just agentID, has
+// collected with what they have deposited since the given day. Payments
+// attributed to an agent are the cash they collected; rejected deposits
+// don't count.
+func (db *DatabaseService) GetAgentCashPositions(ctx context.Context, since time.Time, agentID string) ([]*api.AgentCashPosition, error) {
+	query := `
+		WITH daily AS (
+			SELECT agent_id, processed_at::DATE AS day, SUM(amount) AS amount
+			FROM processed_transactions
+			WHERE agent_id IS NOT NULL AND processed_at >= $1 AND ($2 = '' OR agent_id = $2)
+			GROUP BY agent_id, processed_at::DATE
+		),
+		running AS (
+			SELECT agent_id, day, SUM(amount) OVER (PARTITION BY agent_id ORDER BY day) AS cumulative
+			FROM daily
+		),
+		collected AS (
+			SELECT agent_id, SUM(amount) AS amount FROM daily GROUP BY agent_id
+		),
+		deposited AS (
+			SELECT agent_id,
+			       COALESCE(SUM(amount) FILTER (WHERE status = 'CONFIRMED'), 0) AS confirmed,
+			       COALESCE(SUM(amount) FILTER (WHERE status = 'DECLARED'), 0) AS declared,
+			       MAX(deposited_on) AS last_deposit_on
+			FROM agent_deposits
+			WHERE status <> 'REJECTED' AND deposited_on >= $1 AND ($2 = '' OR agent_id = $2)
+			GROUP BY agent_id
+		)
+		SELECT a.agent_id, a.name, COALESCE(c.amount, 0), COALESCE(d.confirmed, 0), COALESCE(d.declared, 0),
+		       COALESCE((SELECT to_char(MIN(r.day), 'YYYY-MM-DD') FROM running r
+		                 WHERE r.agent_id = a.agent_id
+		                   AND r.cumulative > COALESCE(d.confirmed, 0) + COALESCE(d.declared, 0)), ''),
+		       COALESCE(to_char(d.last_deposit_on, 'YYYY-MM-DD'), '')
+		FROM agents a
+		LEFT JOIN collected c ON c.agent_id = a.agent_id
+		LEFT JOIN deposited d ON d.agent_id = a.agent_id
+		WHERE ($2 = '' OR a.agent_id = $2) AND (a.active OR c.agent_id IS NOT NULL OR d.agent_id IS NOT NULL)
+		ORDER BY COALESCE(c.amount, 0) - COALESCE(d.confirmed, 0) - COALESCE(d.declared, 0) DESC, a.agent_id
+	`
+
+	rows, err := db.Query(ctx, query, since, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	today := db.Now().UTC().Truncate(24 * time.Hour)
+	positions := []*api.AgentCashPosition{}
+	for rows.Next() {
+		var p api.AgentCashPosition
+		if err := rows.Scan(&p.AgentID, &p.Name, &p.Collected, &p.Deposited, &p.Unconfirmed,
+			&p.OldestUndeposited, &p.LastDepositOn); err != nil {
+			return nil, err
+		}
+		p.Outstanding = roundCents(p.Collected - p.Deposited - p.Unconfirmed)
+		if oldest, err := time.Parse("2006-01-02", p.OldestUndeposited); err == nil {
+			p.DaysOutstanding = int(today.Sub(oldest).Hours() / 24)
+		}
+		positions = append(positions, &p)
+	}
+	return positions, rows.Err()
+}
+
+// CashVariancePolicy is when an agent's deposits are lagging their
+// collections. A zero field disables its check.
+type CashVariancePolicy struct {
+	// MaxOutstanding is the most cash an agent may hold undeposited.
+	MaxOutstanding float64
+	// MaxDays is how many days old the oldest undeposited collection may be.
+	MaxDays int
+}
+
+// Check sets the position's variances under the policy and reports whether
+// there were any.
+func (p CashVariancePolicy) Check(position *api.AgentCashPosition) bool {
+	position.Variances = nil
+	if p.MaxOutstanding > 0 && position.Outstanding > p.MaxOutstanding {
+		position.Variances = append(position.Variances,
+			fmt.Sprintf("%.2f undeposited, over the %.2f limit", position.Outstanding, p.MaxOutstanding))
+	}
+	if p.MaxDays > 0 && position.Outstanding > 0 && position.DaysOutstanding > p.MaxDays {
+		position.Variances = append(position.Variances,
+			fmt.Sprintf("collections from %s undeposited for %d days, over the %d day limit",
+				position.OldestUndeposited, position.DaysOutstanding, p.MaxDays))
+	}
+	return len(position.Variances) > 0
+}
@@ -0,0 +1,36 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+func TestCashVariancePolicyCheck(t *testing.T) {
+	policy := CashVariancePolicy{MaxOutstanding: 50000, MaxDays: 2}
+
+	tests := []struct {
+		outstanding float64
+		days        int
+		variances   int
+	}{
+		{0, 0, 0},
+		{50000, 2, 0},
+		{50000.01, 0, 1},
+		{100, 3, 1},
+		{0, 5, 0},
+		{75000, 4, 2},
+	}
+
+	for _, tt := range tests {
+		position := &api.AgentCashPosition{Outstanding: tt.outstanding, DaysOutstanding: tt.days}
+		lagging := policy.Check(position)
+		if len(position.Variances) != tt.variances || lagging != (tt.variances > 0) {
+			t.Errorf("Check(outstanding %v, %d days) = %v, %v; want %d variances", tt.outstanding, tt.days, lagging, position.Variances, tt.variances)
+		}
+	}
+
+	if (CashVariancePolicy{}).Check(&api.AgentCashPosition{Outstanding: 1e9, DaysOutstanding: 365}) {
+		t.Error("zero policy reported a variance")
+	}
+}
@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+type AgentCollections struct {
+	AgentID          string  `json:"agent_id"`
+	Name             string  `json:"name"`
+	PaymentCount     int     `json:"payment_count"`
+	CustomerCount    int     `json:"customer_count"`
+	TotalCollected   float64 `json:"total_collected"`
+	CommissionRate   float64 `json:"commission_rate"`
+	CommissionEarned float64 `json:"commission_earned"`
+}
+
+func (db *DatabaseService) CreateAgent(ctx context.Context, agent *api.Agent) error {
+	query := `
+		INSERT INTO agents (agent_id, name, phone, commission_rate, scheme_id, active)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), $6)
+		RETURNING created_at
+	`
+
+	return db.QueryRow(ctx, query, agent.AgentID, agent.Name, agent.Phone, agent.CommissionRate, agent.SchemeID, agent.Active).Scan(&agent.CreatedAt)
+}
+
+func (db *DatabaseService) GetAgent(ctx context.Context, agentID string) (*api.Agent, error) {
+	query := `
+		SELECT agent_id, name, COALESCE(phone, ''), commission_rate, COALESCE(scheme_id, ''), active, created_at
+		FROM agents
+		WHERE agent_id = $1
+	`
+
+	var agent api.Agent
+	err := db.QueryRow(ctx, query, agentID).Scan(
+		&agent.AgentID,
+		&agent.Name,
+		&agent.Phone,
+		&agent.CommissionRate,
+		&agent.SchemeID,
+		&agent.Active,
+		&agent.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &agent, nil
+}
+
+func (db *DatabaseService) ListAgents(ctx context.Context) ([]api.Agent, error) {
+	query := `
+		SELECT agent_id, name, COALESCE(phone, ''), commission_rate, COALESCE(scheme_id, ''), active, created_at
+		FROM agents
+		ORDER BY agent_id
+	`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []api.Agent{}
+	for rows.Next() {
+		var agent api.Agent
+		if err := rows.Scan(&agent.AgentID, &agent.Name, &agent.Phone, &agent.CommissionRate, &agent.SchemeID, &agent.Active, &agent.CreatedAt); err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+	return agents, rows.Err()
+}
+
+// SetAgentScheme puts the agent on a commission scheme, or back on their
+// flat commission rate when schemeID is empty.
+func (db *DatabaseService) SetAgentScheme(ctx context.Context, agentID, schemeID string) (*api.Agent, error) {
+	tag, err := db.Exec(ctx, `
+		UPDATE agents SET scheme_id = NULLIF($2, ''), updated_at = NOW() WHERE agent_id = $1
+	`, agentID, schemeID)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, errNoRows
+	}
+	return db.GetAgent(ctx, agentID)
+}
+
+func (db *DatabaseService) GetAgentCollections(ctx context.Context, from, to time.Time, agentID string) ([]AgentCollections, error) {
+	query := `
+		SELECT a.agent_id, a.name,
+		       COUNT(t.transaction_reference),
+		       COUNT(DISTINCT t.customer_id),
+		       COALESCE(SUM(t.amount), 0),
+		       a.commission_rate
+		FROM agents a
+		LEFT JOIN processed_transactions t
+		       ON t.agent_id = a.agent_id
+		      AND t.processed_at >= $1 AND t.processed_at < $2
+		WHERE ($3 = '' OR a.agent_id = $3)
+		GROUP BY a.agent_id, a.name, a.commission_rate
+		ORDER BY COALESCE(SUM(t.amount), 0) DESC
+	`
+
+	rows, err := db.Query(ctx, query, from, to, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []AgentCollections{}
+	for rows.Next() {
+		var row AgentCollections
+		if err := rows.Scan(&row.AgentID, &row.Name, &row.PaymentCount, &row.CustomerCount, &row.TotalCollected, &row.CommissionRate); err != nil {
+			return nil, err
+		}
+		row.CommissionEarned = roundCents(row.TotalCollected * row.CommissionRate)
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+func roundCents(amount float64) float64 {
+	return float64(int64(amount*100+0.5)) / 100
+}
@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrAMLAlertClosed is returned when reviewing or disposing of an alert that
+// has already been closed.
+var ErrAMLAlertClosed = errors.New("alert is already closed")
+
+const amlAlertColumns = `id, customer_id, rule, transaction_reference, amount, threshold, window_start,
+	status, COALESCE(disposition, ''), COALESCE(reviewed_by, ''), COALESCE(notes, ''),
+	triggered_at, reviewed_at, closed_at`
+
+func scanAMLAlert(row pgx.Row) (*api.AMLAlert, error) {
+	var alert api.AMLAlert
+	err := row.Scan(
+		&alert.ID,
+		&alert.CustomerID,
+		&alert.Rule,
+		&alert.TransactionReference,
+		&alert.Amount,
+		&alert.Threshold,
+		&alert.WindowStart,
+		&alert.Status,
+		&alert.Disposition,
+		&alert.ReviewedBy,
+		&alert.Notes,
+		&alert.TriggeredAt,
+		&alert.ReviewedAt,
+		&alert.ClosedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// PaymentsTotalSince sums the customer's payments processed after since.
+func (db *DatabaseService) PaymentsTotalSince(ctx context.Context, customerID string, since time.Time) (float64, error) {
+	query := `
+		SELECT COALESCE(SUM(amount), 0)
+		FROM processed_transactions
+		WHERE customer_id = $1 AND processed_at > $2
+	`
+
+	var total float64
+	err := db.QueryRow(ctx, query, customerID, since).Scan(&total)
+	return total, err
+}
+
+// HasAMLAlertSince reports whether the rule already raised an alert for the
+// customer after since.
+func (db *DatabaseService) HasAMLAlertSince(ctx context.Context, customerID, rule string, since time.Time) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM aml_alerts WHERE customer_id = $1 AND rule = $2 AND triggered_at > $3)`
+
+	var exists bool
+	err := db.QueryRow(ctx, query, customerID, rule, since).Scan(&exists)
+	return exists, err
+}
+
+// CreateAMLAlert stores a new alert. It returns false when the rule already
+// alerted on the same transaction, e.g. for a replayed payment.
+func (db *DatabaseService) CreateAMLAlert(ctx context.Context, alert *api.AMLAlert) (bool, error) {
+	query := `
+		INSERT INTO aml_alerts (customer_id, rule, transaction_reference, amount, threshold, window_start, triggered_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (rule, transaction_reference) DO NOTHING
+		RETURNING id, status
+	`
+
+	err := db.QueryRow(ctx, query, alert.CustomerID, alert.Rule, alert.TransactionReference, alert.Amount, alert.Threshold, alert.WindowStart, alert.TriggeredAt).Scan(&alert.ID, &alert.Status)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (db *DatabaseService) GetAMLAlert(ctx context.Context, id int64) (*api.AMLAlert, error) {
+	query := `SELECT ` + amlAlertColumns + ` FROM aml_alerts WHERE id = $1`
+	return scanAMLAlert(db.QueryRow(ctx, query, id))
+}
+
+// ListAMLAlerts lists alerts, oldest first, optionally narrowed to a status
+// and customer.
+func (db *DatabaseService) ListAMLAlerts(ctx context.Context, status, customerID string, limit int) ([]*api.AMLAlert, error) {
+	query := `
+		SELECT ` + amlAlertColumns + ` FROM aml_alerts
+		WHERE ($1 = '' OR status = $1)
+		  AND ($2 = '' OR customer_id = $2)
+		ORDER BY triggered_at, id
+		LIMIT $3
+	`
+
+	rows, err := db.Query(ctx, query, status, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []*api.AMLAlert{}
+	for rows.Next() {
+		alert, err := scanAMLAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// ReviewAMLAlert marks an open alert as under review by reviewer.
+func (db *DatabaseService) ReviewAMLAlert(ctx context.Context, id int64, reviewer, notes string) (*api.AMLAlert, error) {
+	query := `
+		UPDATE aml_alerts
+		SET status = 'UNDER_REVIEW', reviewed_by = $2, notes = COALESCE(NULLIF($3, ''), notes), reviewed_at = NOW()
+		WHERE id = $1 AND status <> 'CLOSED'
+		RETURNING ` + amlAlertColumns
+
+	return db.updateAMLAlert(ctx, id, query, id, reviewer, notes)
+}
+
+// DisposeAMLAlert closes an alert with the compliance team's decision.
+func (db *DatabaseService) DisposeAMLAlert(ctx context.Context, id int64, disposition, reviewer, notes string) (*api.AMLAlert, error) {
+	query := `
+		UPDATE aml_alerts
+		SET status = 'CLOSED', disposition = $2, reviewed_by = $3, notes = $4,
+		    reviewed_at = COALESCE(reviewed_at, NOW()), closed_at = NOW()
+		WHERE id = $1 AND status <> 'CLOSED'
+		RETURNING ` + amlAlertColumns
+
+	return db.updateAMLAlert(ctx, id, query, id, disposition, reviewer, notes)
+}
+
+// updateAMLAlert runs an update guarded by status <> 'CLOSED' and tells a
+// missing alert (ErrNotFound) from a closed one (ErrAMLAlertClosed).
+func (db *DatabaseService) updateAMLAlert(ctx context.Context, id int64, query string, args ...any) (*api.AMLAlert, error) {
+	alert, err := scanAMLAlert(db.QueryRow(ctx, query, args...))
+	if !errors.Is(err, ErrNotFound) {
+		return alert, err
+	}
+	if _, getErr := db.GetAMLAlert(ctx, id); getErr != nil {
+		return nil, getErr
+	}
+	return nil, ErrAMLAlertClosed
+}
@@ -0,0 +1,33 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ParseAmount reads a payment amount, which must be a positive, finite
+// decimal number.
+func ParseAmount(amount string) (float64, error) {
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil || value <= 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	return value, nil
+}
+
+// ParseCents reads a positive amount with at most two decimal places.
+func ParseCents(amount string) (int64, error) {
+	value, err := ParseAmount(amount)
+	if err != nil {
+		return 0, err
+	}
+	cents := math.Round(value * 100)
+	if cents >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	if math.Abs(cents-value*100) > 1e-6 {
+		return 0, fmt.Errorf("amount %q has more than two decimal places", amount)
+	}
+	return int64(cents), nil
+}
@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		amount string
+		cents  int64
+		ok     bool
+	}{
+		{"1000.00", 100000, true},
+		{"0.01", 1, true},
+		{"12.5", 1250, true},
+		{"1e3", 100000, true},
+		{"0", 0, false},
+		{"-5.00", 0, false},
+		{"1.005", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"1e300", 0, false},
+		{"12abc", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		cents, err := ParseCents(tt.amount)
+		if (err == nil) != tt.ok || cents != tt.cents {
+			t.Errorf("ParseCents(%q) = %d, %v; want %d, ok=%v", tt.amount, cents, err, tt.cents, tt.ok)
+		}
+	}
+}
+
+func FuzzParseAmount(f *testing.F) {
+	for _, seed := range []string{"1000.00", "0.01", "-1", "NaN", "+Inf", "1e308", "0x1p-2", "1_000", " 5"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, amount string) {
+		value, err := ParseAmount(amount)
+		if err != nil {
+			return
+		}
+		if value <= 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			t.Fatalf("ParseAmount(%q) accepted %v", amount, value)
+		}
+	})
+}
+
+func FuzzParseCents(f *testing.F) {
+	for _, seed := range []string{"1000.00", "0.01", "12.5", "1.005", "-0.00", "9223372036854775807", "1e-2"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, amount string) {
+		cents, err := ParseCents(amount)
+		if err != nil {
+			return
+		}
+		if cents <= 0 {
+			t.Fatalf("ParseCents(%q) = %d, want a positive amount", amount, cents)
+		}
+		// Cents must survive formatting as a two-decimal amount, which is
+		// how split and group payments pass them on.
+		formatted := fmt.Sprintf("%d.%02d", cents/100, cents%100)
+		again, err := ParseCents(formatted)
+		if err != nil || again != cents {
+			t.Fatalf("ParseCents(%q) = %d, but %q parses to %d, %v", amount, cents, formatted, again, err)
+		}
+	})
+}
+
+func FuzzDecodePaymentMessage(f *testing.F) {
+	for _, name := range []string{"v0_bare.json", "v1_envelope.json", "v2_additive.json", "v3_breaking.json", "wrong_type.json"} {
+		f.Add(readQueueFixture(f, name))
+	}
+	f.Add([]byte(`{"schema_version":2,"payload":null}`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		payment, err := DecodePaymentMessage(data)
+		if err != nil {
+			return
+		}
+		if payment == nil {
+			t.Fatal("nil payment without an error")
+		}
+
+		// Anything we accept must survive a round trip through the queue.
+		encoded, err := EncodePaymentMessage(payment)
+		if err != nil {
+			t.Fatalf("re-encode: %v", err)
+		}
+		again, err := DecodePaymentMessage(encoded)
+		if err != nil {
+			t.Fatalf("decode re-encoded message: %v", err)
+		}
+		if !samePayment(payment, again) {
+			t.Fatalf("round trip changed the payment:\n%+v\n%+v", payment, again)
+		}
+	})
+}
+
+func samePayment(a, b *api.PaymentPayload) bool {
+	return a.CustomerID == b.CustomerID &&
+		a.TransactionReference == b.TransactionReference &&
+		a.TransactionAmount == b.TransactionAmount &&
+		a.TransactionDate == b.TransactionDate &&
+		a.PaymentStatus == b.PaymentStatus &&
+		a.Currency == b.Currency
+}
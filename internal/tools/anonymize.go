@@ -0,0 +1,456 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	anonymizedPartRows   = 50000
+	anonymizedImportRows = 1000
+)
+
+// AnonymizeOptions control how an anonymized export disguises production
+// data.
+type AnonymizeOptions struct {
+	// Salt keys the ID scrambling, date shift and amount noise, so the same
+	// salt gives the same IDs across exports. Empty picks a random salt per
+	// export. It is never written to the export.
+	Salt string
+	// MaxDateShift bounds how far every date moves. The whole dataset moves
+	// by the same number of whole weeks, so intervals and weekdays hold.
+	MaxDateShift time.Duration
+	// AmountNoise is the largest relative change to amounts, e.g. 0.05 for
+	// ±5%. Each customer gets one factor, so their balances still add up.
+	AmountNoise float64
+}
+
+// anonymizedTable describes how one table is disguised. Tables are exported
+// and imported in list order, which respects their foreign keys.
+type anonymizedTable struct {
+	Table   string
+	OrderBy string
+	// IDs are scrambled with the same mapping in every table, so joins
+	// survive.
+	IDs     []string
+	Dates   []string
+	Amounts []string
+	// Replace overwrites free-text and personal columns outright.
+	Replace map[string]any
+	// Omit drops generated keys; staging assigns its own.
+	Omit []string
+}
+
+var anonymizedTables = []anonymizedTable{
+	{Table: "loan_products", OrderBy: "product_id"},
+	{Table: "assets", OrderBy: "asset_type"},
+	{Table: "holiday_calendars", OrderBy: "calendar_code"},
+	{Table: "calendar_holidays", OrderBy: "calendar_code, holiday_date"},
+	{
+		Table:   "agents",
+		OrderBy: "agent_id",
+		IDs:     []string{"agent_id"},
+		Dates:   []string{"created_at", "updated_at"},
+		Replace: map[string]any{"phone": nil},
+	},
+	{
+		Table:   "customer_accounts",
+		OrderBy: "customer_id",
+		IDs:     []string{"customer_id", "holder_id"},
+		Dates: []string{"deployment_date", "last_payment_date", "restructured_at", "written_off_at",
+			"projected_payoff_date", "risk_scored_at", "created_at", "updated_at"},
+		Amounts: []string{"asset_value", "total_paid", "outstanding_balance", "installment_amount",
+			"schedule_baseline", "written_off_amount", "recovered_amount"},
+		Replace: map[string]any{"metadata": map[string]any{}, "write_off_reason": nil},
+	},
+	{
+		Table:   "processed_transactions",
+		OrderBy: "transaction_reference",
+		IDs:     []string{"transaction_reference", "customer_id", "agent_id"},
+		Dates:   []string{"processed_at", "value_date"},
+		Amounts: []string{"amount"},
+		Replace: map[string]any{"metadata": nil},
+	},
+	{
+		Table:   "payment_history",
+		OrderBy: "id",
+		IDs:     []string{"transaction_reference", "customer_id"},
+		Dates:   []string{"transaction_date", "processed_at"},
+		Amounts: []string{"amount", "balance_before", "balance_after"},
+		Omit:    []string{"id"},
+	},
+	{
+		Table:   "promises_to_pay",
+		OrderBy: "id",
+		IDs:     []string{"customer_id", "agent_id"},
+		Dates:   []string{"promised_date", "created_at", "resolved_at"},
+		Amounts: []string{"amount", "paid_amount"},
+		Replace: map[string]any{"note": nil},
+		Omit:    []string{"id"},
+	},
+	{
+		Table:   "collection_assignments",
+		OrderBy: "customer_id",
+		IDs:     []string{"customer_id", "agent_id"},
+		Dates:   []string{"snoozed_until", "resolved_at", "updated_at"},
+		Replace: map[string]any{"resolution": nil},
+	},
+}
+
+func findAnonymizedTable(name string) (anonymizedTable, bool) {
+	for _, table := range anonymizedTables {
+		if table.Table == name {
+			return table, true
+		}
+	}
+	return anonymizedTable{}, false
+}
+
+// AnonymizedExport is the manifest written alongside an export's data files.
+type AnonymizedExport struct {
+	ExportID  string                  `json:"export_id"`
+	CreatedAt time.Time               `json:"created_at"`
+	Tables    []AnonymizedTableExport `json:"tables"`
+}
+
+type AnonymizedTableExport struct {
+	Table string   `json:"table"`
+	Rows  int64    `json:"rows"`
+	Parts []string `json:"parts"`
+}
+
+func AnonymizedExportID(now time.Time) string {
+	return now.UTC().Format("20060102T150405")
+}
+
+func AnonymizedManifestKey(exportID string) string {
+	return fmt.Sprintf("anonymized/%s/manifest.json", exportID)
+}
+
+// anonymizer applies one export's scrambling. All of its choices derive
+// from the salt.
+type anonymizer struct {
+	salt  []byte
+	shift time.Duration
+	noise float64
+}
+
+func newAnonymizer(opts AnonymizeOptions) (*anonymizer, error) {
+	salt := []byte(opts.Salt)
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+	a := &anonymizer{salt: salt, noise: opts.AmountNoise}
+
+	// Shift by ±1..maxWeeks; never zero, which would leave dates as they were.
+	if maxWeeks := int64(opts.MaxDateShift / (7 * 24 * time.Hour)); maxWeeks > 0 {
+		weeks := int64(a.hash("date-shift")%uint64(2*maxWeeks)) - maxWeeks
+		if weeks >= 0 {
+			weeks++
+		}
+		a.shift = time.Duration(weeks) * 7 * 24 * time.Hour
+	}
+	return a, nil
+}
+
+func (a *anonymizer) mac(value string) []byte {
+	h := hmac.New(sha256.New, a.salt)
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
+
+func (a *anonymizer) hash(value string) uint64 {
+	return binary.BigEndian.Uint64(a.mac(value))
+}
+
+func (a *anonymizer) scramble(id string) string {
+	return "ANON-" + hex.EncodeToString(a.mac("id:" + id))[:16]
+}
+
+// amountFactor is the customer's noise multiplier, in [1-noise, 1+noise].
+func (a *anonymizer) amountFactor(customerID string) float64 {
+	if a.noise <= 0 || customerID == "" {
+		return 1
+	}
+	u := float64(a.hash("amount:"+customerID)) / math.MaxUint64
+	return 1 + a.noise*(2*u-1)
+}
+
+// anonymizedDateLayouts are the forms row_to_json writes TIMESTAMP, DATE
+// and TIMESTAMPTZ columns in.
+var anonymizedDateLayouts = []string{"2006-01-02T15:04:05.999999999", "2006-01-02", time.RFC3339Nano}
+
+func (a *anonymizer) shiftDate(value string) (string, error) {
+	for _, layout := range anonymizedDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Add(a.shift).Format(layout), nil
+		}
+	}
+	return "", fmt.Errorf("unrecognised date %q", value)
+}
+
+func (a *anonymizer) apply(table anonymizedTable, row map[string]any) error {
+	// Noise is keyed on the real customer ID, before it is scrambled.
+	customerID, _ := row["customer_id"].(string)
+	factor := a.amountFactor(customerID)
+
+	for _, column := range table.Amounts {
+		number, ok := row[column].(json.Number)
+		if !ok {
+			continue
+		}
+		amount, err := number.Float64()
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", table.Table, column, err)
+		}
+		row[column] = json.Number(strconv.FormatFloat(math.Round(amount*factor*100)/100, 'f', 2, 64))
+	}
+	for _, column := range table.Dates {
+		value, ok := row[column].(string)
+		if !ok {
+			continue
+		}
+		shifted, err := a.shiftDate(value)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", table.Table, column, err)
+		}
+		row[column] = shifted
+	}
+	for _, column := range table.IDs {
+		if value, ok := row[column].(string); ok {
+			row[column] = a.scramble(value)
+		}
+	}
+	if table.Table == "agents" {
+		row["name"] = "Agent " + strings.TrimPrefix(row["agent_id"].(string), "ANON-")
+	}
+	for column, value := range table.Replace {
+		row[column] = value
+	}
+	for _, column := range table.Omit {
+		delete(row, column)
+	}
+	return nil
+}
+
+// ExportAnonymized writes a production-shaped copy of the customer data to
+// the blob store under anonymized/<exportID>/ as JSON lines, with IDs
+// scrambled, dates shifted and amounts noised; contacts, identifiers and
+// free-text notes are left out. Every table is read from one repeatable-read
+// snapshot so the files are consistent with each other. The manifest is
+// written last; ImportAnonymized loads the export into another database.
+func (db *DatabaseService) ExportAnonymized(ctx context.Context, storage BlobStore, exportID string, opts AnonymizeOptions) (*AnonymizedExport, error) {
+	a, err := newAnonymizer(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &AnonymizedExport{ExportID: exportID, CreatedAt: db.Now()}
+
+	txOptions := pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}
+	err = pgx.BeginTxFunc(ctx, db.Pool, txOptions, func(tx pgx.Tx) error {
+		for _, table := range anonymizedTables {
+			result, err := exportAnonymizedTable(ctx, tx, storage, exportID, table, a)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table.Table, err)
+			}
+			export.Tables = append(export.Tables, *result)
+		}
+		return nil
+	})
+	if err != nil {
+		return export, err
+	}
+
+	manifest, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return export, err
+	}
+	return export, storage.Put(ctx, AnonymizedManifestKey(exportID), bytes.NewReader(manifest), "application/json")
+}
+
+func exportAnonymizedTable(ctx context.Context, tx pgx.Tx, storage BlobStore, exportID string, table anonymizedTable, a *anonymizer) (*AnonymizedTableExport, error) {
+	result := &AnonymizedTableExport{Table: table.Table, Parts: []string{}}
+
+	rows, err := tx.Query(ctx, `SELECT row_to_json(t)::TEXT FROM `+table.Table+` t ORDER BY `+table.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		key := fmt.Sprintf("anonymized/%s/%s-%04d.jsonl", exportID, table.Table, len(result.Parts)+1)
+		if err := storage.Put(ctx, key, &buf, "application/x-ndjson"); err != nil {
+			return err
+		}
+		result.Parts = append(result.Parts, key)
+		buf.Reset()
+		return nil
+	}
+
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.UseNumber()
+		row := map[string]any{}
+		if err := decoder.Decode(&row); err != nil {
+			return nil, err
+		}
+		if err := a.apply(table, row); err != nil {
+			return nil, err
+		}
+
+		line, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		result.Rows++
+
+		if result.Rows%anonymizedPartRows == 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, flush()
+}
+
+// ImportAnonymized loads an export written by ExportAnonymized in a single
+// transaction. Rows whose keys already exist are skipped, so an export can
+// be loaded on top of earlier seed data. It returns the manifest with Rows
+// set to the number of rows actually inserted.
+func (db *DatabaseService) ImportAnonymized(ctx context.Context, storage BlobStore, exportID string) (*AnonymizedExport, error) {
+	body, err := storage.Get(ctx, AnonymizedManifestKey(exportID))
+	if err != nil {
+		return nil, err
+	}
+	var export AnonymizedExport
+	err = json.NewDecoder(body).Decode(&export)
+	body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	err = db.inTx(ctx, func(tx pgx.Tx) error {
+		for i, tableExport := range export.Tables {
+			table, ok := findAnonymizedTable(tableExport.Table)
+			if !ok {
+				return fmt.Errorf("export contains unknown table %q", tableExport.Table)
+			}
+
+			export.Tables[i].Rows = 0
+			for _, part := range tableExport.Parts {
+				inserted, err := importAnonymizedPart(ctx, tx, storage, table, part)
+				if err != nil {
+					return fmt.Errorf("%s: %w", part, err)
+				}
+				export.Tables[i].Rows += inserted
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func importAnonymizedPart(ctx context.Context, tx pgx.Tx, storage BlobStore, table anonymizedTable, key string) (int64, error) {
+	body, err := storage.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	var inserted int64
+	var columns string
+	batch := []json.RawMessage{}
+	insert := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO `+table.Table+` (`+columns+`)
+			SELECT `+columns+` FROM jsonb_populate_recordset(NULL::`+table.Table+`, $1::JSONB)
+			ON CONFLICT DO NOTHING
+		`, json.RawMessage(data))
+		if err != nil {
+			return err
+		}
+		inserted += tag.RowsAffected()
+		batch = batch[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		// Every row of a table has the same columns; take them from the
+		// first.
+		if columns == "" {
+			var row map[string]json.RawMessage
+			if err := json.Unmarshal(line, &row); err != nil {
+				return inserted, err
+			}
+			columns = quoteColumns(row)
+		}
+		batch = append(batch, json.RawMessage(append([]byte(nil), line...)))
+		if len(batch) == anonymizedImportRows {
+			if err := insert(); err != nil {
+				return inserted, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return inserted, err
+	}
+	return inserted, insert()
+}
+
+func quoteColumns(row map[string]json.RawMessage) string {
+	columns := make([]string, 0, len(row))
+	for name := range row {
+		columns = append(columns, pgx.Identifier{name}.Sanitize())
+	}
+	sort.Strings(columns)
+	return strings.Join(columns, ", ")
+}
@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+func (db *DatabaseService) ArchivePayment(ctx context.Context, payment *api.PaymentPayload) error {
+	data, err := json.Marshal(payment)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO payment_archive (transaction_reference, customer_id, payload, accepted_at)
+		VALUES ($1, $2, $3, NOW())
+	`
+
+	_, err = db.Exec(ctx, query, payment.TransactionReference, payment.CustomerID, json.RawMessage(data))
+	return err
+}
+
+func (db *DatabaseService) GetArchivedPayments(ctx context.Context, from, to time.Time, customerID string) ([]api.PaymentPayload, error) {
+	query := `
+		SELECT payload
+		FROM payment_archive
+		WHERE accepted_at >= $1 AND accepted_at < $2
+		  AND ($3 = '' OR customer_id = $3)
+		ORDER BY id
+	`
+
+	rows, err := db.Query(ctx, query, from, to, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []api.PaymentPayload{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var payment api.PaymentPayload
+		if err := json.Unmarshal(data, &payment); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// ResetReplayAccount puts the account into db as it stood before its first
+// payment, with its processed transactions cleared, so the archive can be
+// replayed onto it. Only call it on a shadow database.
+func (db *DatabaseService) ResetReplayAccount(ctx context.Context, customer *api.CustomerAccount) error {
+	query := `
+		WITH cleared AS (
+			DELETE FROM processed_transactions WHERE customer_id = $1
+			RETURNING transaction_reference
+		), unclaimed AS (
+			DELETE FROM processed_references WHERE transaction_reference IN (SELECT transaction_reference FROM cleared)
+		)
+		INSERT INTO customer_accounts (customer_id, asset_value, term_weeks, deployment_date, total_paid, outstanding_balance, payment_count, version)
+		VALUES ($1, $2, $3, $4, 0, $2, 0, 0)
+		ON CONFLICT (customer_id) DO UPDATE SET
+			asset_value = EXCLUDED.asset_value,
+			term_weeks = EXCLUDED.term_weeks,
+			deployment_date = EXCLUDED.deployment_date,
+			total_paid = 0,
+			outstanding_balance = EXCLUDED.asset_value,
+			last_payment_date = NULL,
+			payment_count = 0,
+			version = 0
+	`
+
+	_, err := db.Exec(ctx, query, customer.CustomerID, customer.AssetValue, customer.TermWeeks, customer.DeploymentDate)
+	return err
+}
@@ -0,0 +1,88 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const assetColumns = `asset_type, name, price, default_term_weeks, COALESCE(max_term_weeks, 0), active, created_at, updated_at`
+
+func scanAsset(row pgx.Row) (*api.Asset, error) {
+	var asset api.Asset
+	err := row.Scan(
+		&asset.AssetType,
+		&asset.Name,
+		&asset.Price,
+		&asset.DefaultTermWeeks,
+		&asset.MaxTermWeeks,
+		&asset.Active,
+		&asset.CreatedAt,
+		&asset.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
+
+// SaveAsset creates the asset type or replaces its catalog entry.
+func (db *DatabaseService) SaveAsset(ctx context.Context, asset *api.Asset) (*api.Asset, error) {
+	query := `
+		INSERT INTO assets (asset_type, name, price, default_term_weeks, max_term_weeks, active)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6)
+		ON CONFLICT (asset_type) DO UPDATE
+		SET name = EXCLUDED.name,
+		    price = EXCLUDED.price,
+		    default_term_weeks = EXCLUDED.default_term_weeks,
+		    max_term_weeks = EXCLUDED.max_term_weeks,
+		    active = EXCLUDED.active,
+		    updated_at = NOW()
+		RETURNING ` + assetColumns
+
+	return scanAsset(db.QueryRow(ctx, query, asset.AssetType, asset.Name, asset.Price, asset.DefaultTermWeeks, asset.MaxTermWeeks, asset.Active))
+}
+
+func (db *DatabaseService) GetAsset(ctx context.Context, assetType string) (*api.Asset, error) {
+	query := `SELECT ` + assetColumns + ` FROM assets WHERE asset_type = $1`
+	return scanAsset(db.QueryRow(ctx, query, assetType))
+}
+
+func (db *DatabaseService) ListAssets(ctx context.Context, activeOnly bool) ([]*api.Asset, error) {
+	query := `
+		SELECT ` + assetColumns + ` FROM assets
+		WHERE active OR NOT $1
+		ORDER BY asset_type
+	`
+
+	rows, err := db.Query(ctx, query, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []*api.Asset{}
+	for rows.Next() {
+		asset, err := scanAsset(rows)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, rows.Err()
+}
+
+// AssignCustomerAsset records which asset type the account finances. The
+// account's value and term are left as they were agreed.
+func (db *DatabaseService) AssignCustomerAsset(ctx context.Context, customerID, assetType string) (*api.CustomerAccount, error) {
+	query := `
+		UPDATE customer_accounts
+		SET asset_type = NULLIF($2, ''),
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE customer_id = $1
+		RETURNING ` + customerColumns
+
+	return scanCustomer(db.QueryRow(ctx, query, customerID, assetType))
+}
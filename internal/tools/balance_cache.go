@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	balanceChangesChannel = "balance_changes"
+	// allBalances is published when every cached balance is dropped.
+	allBalances = "*"
+	// maxLocalBalances bounds the in-memory cache; it is emptied when full.
+	maxLocalBalances = 100000
+)
+
+type localBalance struct {
+	balance float64
+	expires time.Time
+}
+
+type localBalanceCache struct {
+	mu      sync.Mutex
+	entries map[string]localBalance
+}
+
+func (c *localBalanceCache) get(customerID string, now time.Time) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[customerID]
+	if !ok || now.After(entry.expires) {
+		return 0, false
+	}
+	return entry.balance, true
+}
+
+func (c *localBalanceCache) set(customerID string, balance float64, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= maxLocalBalances {
+		c.entries = make(map[string]localBalance)
+	}
+	c.entries[customerID] = localBalance{balance: balance, expires: expires}
+}
+
+func (c *localBalanceCache) evict(customerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if customerID == allBalances {
+		c.entries = nil
+		return
+	}
+	delete(c.entries, customerID)
+}
+
+func (r *RedisService) balanceKey(customerID string) string {
+	return r.Key("balance:" + customerID)
+}
+
+func (r *RedisService) GetCachedBalance(ctx context.Context, customerID string) (*float64, error) {
+	if r.LocalBalanceTTL > 0 {
+		if balance, ok := r.localBalances.get(customerID, time.Now()); ok {
+			return &balance, nil
+		}
+	}
+
+	result, err := r.Client.Get(ctx, r.balanceKey(customerID)).Result()
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var balance float64
+	if _, err := fmt.Sscanf(result, "%f", &balance); err != nil {
+		return nil, err
+	}
+
+	if r.LocalBalanceTTL > 0 {
+		r.localBalances.set(customerID, balance, time.Now().Add(r.LocalBalanceTTL))
+	}
+	return &balance, nil
+}
+
+// CacheBalance writes a changed balance through to Redis and announces the
+// change so every instance drops its local copy.
+func (r *RedisService) CacheBalance(ctx context.Context, customerID string, balance float64, ttl time.Duration) error {
+	r.localBalances.evict(customerID)
+	pipe := r.Client.TxPipeline()
+	pipe.SetEX(ctx, r.balanceKey(customerID), fmt.Sprintf("%.2f", balance), ttl)
+	pipe.Publish(ctx, r.Key(balanceChangesChannel), customerID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// InvalidateBalance drops the cached balance, in Redis and on every
+// instance, so the next read goes to the database.
+func (r *RedisService) InvalidateBalance(ctx context.Context, customerID string) error {
+	r.localBalances.evict(customerID)
+	pipe := r.Client.TxPipeline()
+	pipe.Del(ctx, r.balanceKey(customerID))
+	pipe.Publish(ctx, r.Key(balanceChangesChannel), customerID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// InvalidateBalances drops every cached balance and returns how many Redis
+// entries it removed.
+func (r *RedisService) InvalidateBalances(ctx context.Context) (int64, error) {
+	r.localBalances.evict(allBalances)
+
+	var removed int64
+	iter := r.Client.Scan(ctx, 0, r.balanceKey("*"), 1000).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 1000 {
+			n, err := r.Client.Del(ctx, batch...).Result()
+			if err != nil {
+				return removed, err
+			}
+			removed += n
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return removed, err
+	}
+	if len(batch) > 0 {
+		n, err := r.Client.Del(ctx, batch...).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, r.Client.Publish(ctx, r.Key(balanceChangesChannel), allBalances).Err()
+}
+
+// ListenBalanceChanges evicts local balances changed by any instance until
+// ctx ends. The subscription reconnects by itself after a Redis outage;
+// local entries still expire after LocalBalanceTTL in the meantime.
+func (r *RedisService) ListenBalanceChanges(ctx context.Context) {
+	pubsub := r.Client.Subscribe(ctx, r.Key(balanceChangesChannel))
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				log.Warn("Balance change subscription closed")
+				return
+			}
+			r.localBalances.evict(message.Payload)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"context"
+	"errors"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const bankFeedColumns = `
+	id, provider, external_id, COALESCE(account_id, ''), amount, currency,
+	COALESCE(narration, ''), COALESCE(reference, ''), transaction_date, status,
+	COALESCE(customer_id, ''), COALESCE(payment_reference, ''), COALESCE(reviewed_by, ''),
+	COALESCE(review_note, ''), reviewed_at, created_at
+`
+
+func scanBankFeedTransaction(row pgx.Row) (*api.BankFeedTransaction, error) {
+	var txn api.BankFeedTransaction
+	err := row.Scan(
+		&txn.ID,
+		&txn.Provider,
+		&txn.ExternalID,
+		&txn.AccountID,
+		&txn.Amount,
+		&txn.Currency,
+		&txn.Narration,
+		&txn.Reference,
+		&txn.TransactionDate,
+		&txn.Status,
+		&txn.CustomerID,
+		&txn.PaymentReference,
+		&txn.ReviewedBy,
+		&txn.ReviewNote,
+		&txn.ReviewedAt,
+		&txn.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+// RecordBankFeedTransaction stores a feed line once per (provider,
+// external_id). Feeds redeliver freely, so a repeat returns created=false and
+// must not be enqueued again.
+func (db *DatabaseService) RecordBankFeedTransaction(ctx context.Context, txn *api.BankFeedTransaction) (*api.BankFeedTransaction, bool, error) {
+	query := `
+		INSERT INTO bank_feed_transactions (
+			provider, external_id, account_id, amount, currency, narration, reference,
+			transaction_date, status, customer_id, payment_reference
+		)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8, $9, NULLIF($10, ''), NULLIF($11, ''))
+		ON CONFLICT (provider, external_id) DO NOTHING
+		RETURNING ` + bankFeedColumns
+
+	recorded, err := scanBankFeedTransaction(db.QueryRow(ctx, query,
+		txn.Provider, txn.ExternalID, txn.AccountID, txn.Amount, txn.Currency, txn.Narration,
+		txn.Reference, txn.TransactionDate, txn.Status, txn.CustomerID, txn.PaymentReference,
+	))
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return recorded, true, nil
+}
+
+func (db *DatabaseService) GetBankFeedTransaction(ctx context.Context, id int64) (*api.BankFeedTransaction, error) {
+	query := `SELECT ` + bankFeedColumns + ` FROM bank_feed_transactions WHERE id = $1`
+	return scanBankFeedTransaction(db.QueryRow(ctx, query, id))
+}
+
+func (db *DatabaseService) ListBankFeedTransactions(ctx context.Context, status api.BankFeedStatus, limit, offset int) ([]*api.BankFeedTransaction, error) {
+	query := `
+		SELECT ` + bankFeedColumns + `
+		FROM bank_feed_transactions
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY transaction_date DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := db.Query(ctx, query, string(status), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []*api.BankFeedTransaction{}
+	for rows.Next() {
+		txn, err := scanBankFeedTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, txn)
+	}
+	return transactions, rows.Err()
+}
+
+// ReviewBankFeedTransaction closes out an unmatched line, either assigning it
+// to a customer or ignoring it. Returns ErrNotFound if it was already
+// reviewed.
+func (db *DatabaseService) ReviewBankFeedTransaction(ctx context.Context, id int64, status api.BankFeedStatus, customerID, paymentReference, reviewer, note string) (*api.BankFeedTransaction, error) {
+	query := `
+		UPDATE bank_feed_transactions
+		SET status = $2,
+		    customer_id = NULLIF($3, ''),
+		    payment_reference = NULLIF($4, ''),
+		    reviewed_by = $5,
+		    review_note = NULLIF($6, ''),
+		    reviewed_at = NOW()
+		WHERE id = $1 AND status = 'UNMATCHED'
+		RETURNING ` + bankFeedColumns
+
+	return scanBankFeedTransaction(db.QueryRow(ctx, query, id, status, customerID, paymentReference, reviewer, note))
+}
+
+// ReturnBankFeedTransactionToReview moves a matched line back into the review
+// queue, keeping the suggested customer, when its payment could not be queued.
+func (db *DatabaseService) ReturnBankFeedTransactionToReview(ctx context.Context, id int64, reason string) error {
+	_, err := db.Exec(ctx, `
+		UPDATE bank_feed_transactions
+		SET status = 'UNMATCHED', payment_reference = NULL, review_note = $2
+		WHERE id = $1 AND status = 'MATCHED'
+	`, id, reason)
+	return err
+}
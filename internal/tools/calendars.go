@@ -0,0 +1,194 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/calendar"
+	"github.com/jackc/pgx/v5"
+)
+
+const holidayCalendarColumns = `calendar_code, name, weekend_days, is_default, created_at, updated_at`
+
+func scanHolidayCalendar(row pgx.Row) (*api.HolidayCalendar, error) {
+	var cal api.HolidayCalendar
+	err := row.Scan(&cal.Code, &cal.Name, &cal.Weekend, &cal.IsDefault, &cal.CreatedAt, &cal.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cal, nil
+}
+
+// SaveHolidayCalendar creates or replaces a calendar's settings. Making it
+// the default clears the flag on the previous default.
+func (db *DatabaseService) SaveHolidayCalendar(ctx context.Context, cal *api.HolidayCalendar) (*api.HolidayCalendar, error) {
+	query := `
+		WITH cleared AS (
+			UPDATE holiday_calendars SET is_default = FALSE, updated_at = NOW()
+			WHERE $4 AND is_default AND calendar_code <> $1
+		)
+		INSERT INTO holiday_calendars (calendar_code, name, weekend_days, is_default)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (calendar_code) DO UPDATE
+		SET name = EXCLUDED.name,
+		    weekend_days = EXCLUDED.weekend_days,
+		    is_default = EXCLUDED.is_default,
+		    updated_at = NOW()
+		RETURNING ` + holidayCalendarColumns
+
+	return scanHolidayCalendar(db.QueryRow(ctx, query, cal.Code, cal.Name, cal.Weekend, cal.IsDefault))
+}
+
+// GetHolidayCalendar returns the calendar with its holidays.
+func (db *DatabaseService) GetHolidayCalendar(ctx context.Context, code string) (*api.HolidayCalendar, error) {
+	query := `SELECT ` + holidayCalendarColumns + ` FROM holiday_calendars WHERE calendar_code = $1`
+
+	cal, err := scanHolidayCalendar(db.QueryRow(ctx, query, code))
+	if err != nil {
+		return nil, err
+	}
+	if cal.Holidays, err = db.ListHolidays(ctx, code); err != nil {
+		return nil, err
+	}
+	return cal, nil
+}
+
+func (db *DatabaseService) ListHolidayCalendars(ctx context.Context) ([]api.HolidayCalendar, error) {
+	query := `SELECT ` + holidayCalendarColumns + ` FROM holiday_calendars ORDER BY calendar_code`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calendars := []api.HolidayCalendar{}
+	for rows.Next() {
+		cal, err := scanHolidayCalendar(rows)
+		if err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, *cal)
+	}
+	return calendars, rows.Err()
+}
+
+// DeleteHolidayCalendar removes the calendar and its holidays; customers on
+// it fall back to the default calendar.
+func (db *DatabaseService) DeleteHolidayCalendar(ctx context.Context, code string) error {
+	result, err := db.Exec(ctx, `DELETE FROM holiday_calendars WHERE calendar_code = $1`, code)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+func (db *DatabaseService) ListHolidays(ctx context.Context, code string) ([]api.Holiday, error) {
+	query := `
+		SELECT TO_CHAR(holiday_date, 'YYYY-MM-DD'), COALESCE(name, '')
+		FROM calendar_holidays
+		WHERE calendar_code = $1
+		ORDER BY holiday_date
+	`
+
+	rows, err := db.Query(ctx, query, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holidays := []api.Holiday{}
+	for rows.Next() {
+		var holiday api.Holiday
+		if err := rows.Scan(&holiday.Date, &holiday.Name); err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, holiday)
+	}
+	return holidays, rows.Err()
+}
+
+// SaveHoliday adds a holiday to the calendar, renaming it if the date is
+// already a holiday.
+func (db *DatabaseService) SaveHoliday(ctx context.Context, code string, holiday api.Holiday) error {
+	query := `
+		INSERT INTO calendar_holidays (calendar_code, holiday_date, name)
+		VALUES ($1, $2::DATE, NULLIF($3, ''))
+		ON CONFLICT (calendar_code, holiday_date) DO UPDATE SET name = EXCLUDED.name
+	`
+
+	_, err := db.Exec(ctx, query, code, holiday.Date, holiday.Name)
+	return err
+}
+
+func (db *DatabaseService) DeleteHoliday(ctx context.Context, code, date string) error {
+	result, err := db.Exec(ctx, `DELETE FROM calendar_holidays WHERE calendar_code = $1 AND holiday_date = $2::DATE`, code, date)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+// AssignCustomerCalendar sets the account's holiday calendar; an empty code
+// puts it back on the default calendar.
+func (db *DatabaseService) AssignCustomerCalendar(ctx context.Context, customerID, code string) (*api.CustomerAccount, error) {
+	query := `
+		UPDATE customer_accounts
+		SET calendar_code = NULLIF($2, ''),
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE customer_id = $1
+		RETURNING ` + customerColumns
+
+	return scanCustomer(db.QueryRow(ctx, query, customerID, code))
+}
+
+// CustomerCalendar loads the calendar used to shift the customer's due
+// dates: theirs if assigned, otherwise the default. It returns nil when
+// neither exists, meaning due dates are not shifted.
+func (db *DatabaseService) CustomerCalendar(ctx context.Context, customer *api.CustomerAccount) (*calendar.Calendar, error) {
+	query := `
+		SELECT ` + holidayCalendarColumns + ` FROM holiday_calendars
+		WHERE calendar_code = NULLIF($1, '') OR (NULLIF($1, '') IS NULL AND is_default)
+	`
+
+	cal, err := scanHolidayCalendar(db.QueryRow(ctx, query, customer.CalendarCode))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	holidays, err := db.ListHolidays(ctx, cal.Code)
+	if err != nil {
+		return nil, err
+	}
+	return buildCalendar(cal.Weekend, holidays)
+}
+
+// buildCalendar turns stored calendar settings into a calendar for due-date
+// shifting. Due dates are naive timestamps, so the calendar works in UTC.
+func buildCalendar(weekendDays []string, holidays []api.Holiday) (*calendar.Calendar, error) {
+	weekend, err := calendar.ParseWeekend(strings.Join(weekendDays, ","))
+	if err != nil {
+		return nil, err
+	}
+	cal := calendar.New(time.UTC, weekend, 0)
+	for _, holiday := range holidays {
+		date, err := time.Parse("2006-01-02", holiday.Date)
+		if err != nil {
+			return nil, err
+		}
+		cal.AddHoliday(date, holiday.Name)
+	}
+	return cal, nil
+}
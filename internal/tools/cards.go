@@ -0,0 +1,275 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrCardTokenExists is returned when saving a gateway token that is
+// already on file.
+var ErrCardTokenExists = duplicateError("card token is already saved")
+
+const cardTokenColumns = `id, customer_id, gateway, token, COALESCE(brand, ''), last4, exp_month, exp_year,
+	status, created_at, revoked_at`
+
+func scanCardToken(row pgx.Row) (*api.CardToken, error) {
+	var card api.CardToken
+	err := row.Scan(
+		&card.ID,
+		&card.CustomerID,
+		&card.Gateway,
+		&card.Token,
+		&card.Brand,
+		&card.Last4,
+		&card.ExpMonth,
+		&card.ExpYear,
+		&card.Status,
+		&card.CreatedAt,
+		&card.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+func (db *DatabaseService) SaveCardToken(ctx context.Context, card *api.CardToken) error {
+	query := `
+		INSERT INTO card_tokens (customer_id, gateway, token, brand, last4, exp_month, exp_year)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+		ON CONFLICT (gateway, token) DO NOTHING
+		RETURNING id, status, created_at
+	`
+
+	err := db.QueryRow(ctx, query, card.CustomerID, card.Gateway, card.Token, card.Brand, card.Last4, card.ExpMonth, card.ExpYear).
+		Scan(&card.ID, &card.Status, &card.CreatedAt)
+	if errors.Is(err, ErrNotFound) {
+		return ErrCardTokenExists
+	}
+	return err
+}
+
+func (db *DatabaseService) GetCardToken(ctx context.Context, id int64) (*api.CardToken, error) {
+	return scanCardToken(db.QueryRow(ctx, `SELECT `+cardTokenColumns+` FROM card_tokens WHERE id = $1`, id))
+}
+
+func (db *DatabaseService) ListCardTokens(ctx context.Context, customerID string) ([]*api.CardToken, error) {
+	query := `
+		SELECT ` + cardTokenColumns + ` FROM card_tokens
+		WHERE customer_id = $1
+		ORDER BY status, created_at DESC
+	`
+
+	rows, err := db.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := []*api.CardToken{}
+	for rows.Next() {
+		card, err := scanCardToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// RevokeCardToken stops charging the card and cancels its pending charges.
+// It returns ErrNotFound if the customer has no such active card.
+func (db *DatabaseService) RevokeCardToken(ctx context.Context, customerID string, id int64) (*api.CardToken, error) {
+	var card *api.CardToken
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		card, err = scanCardToken(tx.QueryRow(ctx, `
+			UPDATE card_tokens SET status = 'REVOKED', revoked_at = NOW()
+			WHERE id = $1 AND customer_id = $2 AND status = 'ACTIVE'
+			RETURNING `+cardTokenColumns, id, customerID))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE card_charges SET status = 'CANCELLED', failure_reason = 'card revoked', updated_at = NOW()
+			WHERE card_id = $1 AND status = 'PENDING'
+		`, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// CardChargeCandidate is an open account with a card to charge.
+type CardChargeCandidate struct {
+	CustomerID string
+	CardID     int64
+}
+
+// CardChargeCandidates lists open accounts with an active card and no
+// pending charge. The account's own card is preferred over its holder's.
+func (db *DatabaseService) CardChargeCandidates(ctx context.Context, limit int) ([]CardChargeCandidate, error) {
+	query := `
+		SELECT customer_id, card.card_id
+		FROM customer_accounts
+		JOIN LATERAL (
+			SELECT t.id AS card_id FROM card_tokens t
+			WHERE t.customer_id IN (customer_accounts.customer_id, customer_accounts.holder_id) AND t.status = 'ACTIVE'
+			ORDER BY t.customer_id = customer_accounts.customer_id DESC, t.created_at DESC
+			LIMIT 1
+		) card ON TRUE
+		WHERE outstanding_balance > 0 AND written_off_at IS NULL
+		  AND NOT EXISTS (
+		      SELECT 1 FROM card_charges c
+		      WHERE c.customer_id = customer_accounts.customer_id AND c.status = 'PENDING')
+		ORDER BY customer_id
+		LIMIT $1
+	`
+
+	rows, err := db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []CardChargeCandidate{}
+	for rows.Next() {
+		var candidate CardChargeCandidate
+		if err := rows.Scan(&candidate.CustomerID, &candidate.CardID); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+const cardChargeColumns = `id, reference, customer_id, card_id, installment, amount, status, attempts,
+	next_attempt_at, COALESCE(gateway_reference, ''), COALESCE(failure_reason, ''), created_at, updated_at`
+
+func scanCardCharge(row pgx.Row) (*api.CardCharge, error) {
+	var charge api.CardCharge
+	err := row.Scan(
+		&charge.ID,
+		&charge.Reference,
+		&charge.CustomerID,
+		&charge.CardID,
+		&charge.Installment,
+		&charge.Amount,
+		&charge.Status,
+		&charge.Attempts,
+		&charge.NextAttemptAt,
+		&charge.GatewayReference,
+		&charge.FailureReason,
+		&charge.CreatedAt,
+		&charge.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &charge, nil
+}
+
+// CreateCardCharge schedules the charge for an installment. It returns
+// false if the installment already has one, whatever its outcome.
+func (db *DatabaseService) CreateCardCharge(ctx context.Context, charge *api.CardCharge) (bool, error) {
+	query := `
+		INSERT INTO card_charges (reference, customer_id, card_id, installment, amount, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (customer_id, installment) DO NOTHING
+		RETURNING ` + cardChargeColumns
+
+	created, err := scanCardCharge(db.QueryRow(ctx, query, charge.Reference, charge.CustomerID, charge.CardID,
+		charge.Installment, charge.Amount, charge.NextAttemptAt))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	*charge = *created
+	return true, nil
+}
+
+// ClaimDueCardCharges claims up to limit pending charges that are due and
+// counts the attempt. A claimed charge isn't due again until lease has
+// passed, so an instance that dies mid-attempt doesn't strand it and
+// instances running together don't charge twice.
+func (db *DatabaseService) ClaimDueCardCharges(ctx context.Context, limit int, lease time.Duration) ([]*api.CardCharge, error) {
+	query := `
+		WITH due AS (
+			SELECT id AS due_id FROM card_charges
+			WHERE status = 'PENDING' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE card_charges c
+		SET attempts = attempts + 1, next_attempt_at = NOW() + $2 * INTERVAL '1 second', updated_at = NOW()
+		FROM due
+		WHERE c.id = due.due_id
+		RETURNING ` + cardChargeColumns
+
+	rows, err := db.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	charges := []*api.CardCharge{}
+	for rows.Next() {
+		charge, err := scanCardCharge(rows)
+		if err != nil {
+			return nil, err
+		}
+		charges = append(charges, charge)
+	}
+	return charges, rows.Err()
+}
+
+// UpdateCardCharge records the outcome of an attempt.
+func (db *DatabaseService) UpdateCardCharge(ctx context.Context, charge *api.CardCharge) error {
+	query := `
+		UPDATE card_charges
+		SET status = $2, amount = $3, next_attempt_at = $4, gateway_reference = NULLIF($5, ''),
+		    failure_reason = NULLIF($6, ''), updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	return db.QueryRow(ctx, query, charge.ID, charge.Status, charge.Amount, charge.NextAttemptAt,
+		charge.GatewayReference, charge.FailureReason).Scan(&charge.UpdatedAt)
+}
+
+// ListCardCharges lists charges, newest first, optionally narrowed to a
+// customer account and status.
+func (db *DatabaseService) ListCardCharges(ctx context.Context, customerID, status string, limit int) ([]*api.CardCharge, error) {
+	query := `
+		SELECT ` + cardChargeColumns + ` FROM card_charges
+		WHERE ($1 = '' OR customer_id = $1)
+		  AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`
+
+	rows, err := db.Query(ctx, query, customerID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	charges := []*api.CardCharge{}
+	for rows.Next() {
+		charge, err := scanCardCharge(rows)
+		if err != nil {
+			return nil, err
+		}
+		charges = append(charges, charge)
+	}
+	return charges, rows.Err()
+}
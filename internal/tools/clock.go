@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Code that depends on the current time takes one so
+// tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the real wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// After returns a channel that receives the time once d has passed on
+// clock. Clocks without an After method of their own wait in real time.
+func After(clock Clock, d time.Duration) <-chan time.Time {
+	if timer, ok := clock.(interface {
+		After(d time.Duration) <-chan time.Time
+	}); ok {
+		return timer.After(d)
+	}
+	return time.After(d)
+}
+
+// ManualClock only moves when told to, so tests can step through days of
+// schedules, promises and reports deterministically.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []manualWaiter
+}
+
+type manualWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.wake()
+	c.mu.Unlock()
+}
+
+// Advance moves the clock forward by d and returns the new time.
+func (c *ManualClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.wake()
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock has been
+// moved on by d.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, manualWaiter{at: c.now.Add(d), ch: ch})
+	c.wake()
+	return ch
+}
+
+// wake fires the waiters that are due. c.mu must be held.
+func (c *ManualClock) wake() {
+	waiting := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.at.After(c.now) {
+			waiting = append(waiting, waiter)
+			continue
+		}
+		waiter.ch <- c.now
+	}
+	c.waiters = waiting
+}
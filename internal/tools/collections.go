@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+)
+
+type WorklistFilter struct {
+	AgentID    string
+	Region     string
+	Branch     string
+	Unassigned bool
+}
+
+type WorklistItem struct {
+	CustomerID         string     `json:"customer_id"`
+	Region             string     `json:"region,omitempty"`
+	Branch             string     `json:"branch,omitempty"`
+	AgentID            string     `json:"agent_id,omitempty"`
+	OutstandingBalance float64    `json:"outstanding_balance"`
+	Arrears            float64    `json:"arrears"`
+	DaysPastDue        int        `json:"days_past_due"`
+	BrokenPromises     int        `json:"broken_promises"`
+	LastPaymentDate    *time.Time `json:"last_payment_date,omitempty"`
+	LastCallStatus     string     `json:"last_call_status,omitempty"`
+	LastCallAt         *time.Time `json:"last_call_at,omitempty"`
+	UnansweredCalls    int        `json:"unanswered_calls"`
+	Priority           float64    `json:"priority"`
+}
+
+// worklistQuery ranks accounts in arrears. Priority weights days past due,
+// adds two weeks for every promise broken in the last 90 days, a week for
+// each of up to three reminder calls in the last 30 days that went
+// unanswered, and the amount at risk expressed in weeks of installments
+// (capped at a year).
+const worklistQuery = `
+	WITH accounts AS (
+		SELECT c.customer_id, COALESCE(c.region, '') AS region, COALESCE(c.branch, '') AS branch,
+		       COALESCE(a.agent_id, '') AS agent_id, c.outstanding_balance, c.last_payment_date,
+		       ` + arrearsExpr + ` AS arrears,
+		       ` + installmentExpr + ` AS installment,
+		       (SELECT COUNT(*) FROM promises_to_pay p
+		        WHERE p.customer_id = c.customer_id AND p.status = 'BROKEN'
+		          AND p.promised_date > CURRENT_DATE - 90) AS broken_promises
+		FROM customer_accounts c
+		LEFT JOIN collection_assignments a ON a.customer_id = c.customer_id
+		WHERE c.written_off_at IS NULL
+		  AND c.outstanding_balance > 0
+		  AND (a.snoozed_until IS NULL OR a.snoozed_until <= NOW())
+		  AND (a.resolved_at IS NULL OR a.resolved_at < c.deployment_date
+		       + FLOOR(EXTRACT(EPOCH FROM NOW() - c.deployment_date) / 604800) * INTERVAL '1 week')
+		  AND ($1 = '' OR a.agent_id = $1)
+		  AND ($2 = '' OR c.region = $2)
+		  AND ($3 = '' OR c.branch = $3)
+		  AND (NOT $4 OR a.agent_id IS NULL)
+	)
+	SELECT customer_id, region, branch, agent_id, outstanding_balance, arrears,
+	       CEIL(arrears / NULLIF(installment, 0))::INT * 7 AS days_past_due,
+	       broken_promises, last_payment_date, COALESCE(last_call_status, ''), last_call_at, unanswered_calls,
+	       CEIL(arrears / NULLIF(installment, 0)) * 7 + broken_promises * 14 + LEAST(unanswered_calls, 3) * 7
+	       + LEAST(outstanding_balance / NULLIF(installment, 0), 52) AS priority
+	FROM accounts
+	WHERE arrears > 0
+	ORDER BY priority DESC NULLS LAST, arrears DESC
+	LIMIT $5
+`
+
+func (db *DatabaseService) GetCollectionsWorklist(ctx context.Context, filter WorklistFilter, limit int) ([]WorklistItem, error) {
+	rows, err := db.Query(ctx, worklistQuery, filter.AgentID, filter.Region, filter.Branch, filter.Unassigned, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []WorklistItem{}
+	for rows.Next() {
+		var item WorklistItem
+		var daysPastDue *int
+		var priority *float64
+		if err := rows.Scan(
+			&item.CustomerID,
+			&item.Region,
+			&item.Branch,
+			&item.AgentID,
+			&item.OutstandingBalance,
+			&item.Arrears,
+			&daysPastDue,
+			&item.BrokenPromises,
+			&item.LastPaymentDate,
+			&item.LastCallStatus,
+			&item.LastCallAt,
+			&item.UnansweredCalls,
+			&priority,
+		); err != nil {
+			return nil, err
+		}
+		if daysPastDue != nil {
+			item.DaysPastDue = *daysPastDue
+		}
+		if priority != nil {
+			item.Priority = roundCents(*priority)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (db *DatabaseService) AssignCollection(ctx context.Context, customerID, agentID string) error {
+	query := `
+		INSERT INTO collection_assignments (customer_id, agent_id)
+		VALUES ($1, NULLIF($2, ''))
+		ON CONFLICT (customer_id) DO UPDATE
+		SET agent_id = EXCLUDED.agent_id, updated_at = NOW()
+	`
+
+	_, err := db.Exec(ctx, query, customerID, agentID)
+	return err
+}
+
+func (db *DatabaseService) SnoozeCollection(ctx context.Context, customerID string, until time.Time) error {
+	query := `
+		INSERT INTO collection_assignments (customer_id, snoozed_until)
+		VALUES ($1, $2)
+		ON CONFLICT (customer_id) DO UPDATE
+		SET snoozed_until = EXCLUDED.snoozed_until, updated_at = NOW()
+	`
+
+	_, err := db.Exec(ctx, query, customerID, until)
+	return err
+}
+
+// ResolveCollection takes the account off the worklist until its next
+// installment falls due.
+func (db *DatabaseService) ResolveCollection(ctx context.Context, customerID, resolution string) error {
+	query := `
+		INSERT INTO collection_assignments (customer_id, resolved_at, resolution)
+		VALUES ($1, NOW(), $2)
+		ON CONFLICT (customer_id) DO UPDATE
+		SET resolved_at = NOW(), resolution = EXCLUDED.resolution, snoozed_until = NULL, updated_at = NOW()
+	`
+
+	_, err := db.Exec(ctx, query, customerID, resolution)
+	return err
+}
+
+func WriteWorklistCSV(out io.Writer, items []WorklistItem) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"customer_id", "region", "branch", "agent_id", "outstanding_balance", "arrears", "days_past_due", "broken_promises", "last_payment_date", "last_call_status", "unanswered_calls", "priority"})
+	for _, item := range items {
+		lastPayment := ""
+		if item.LastPaymentDate != nil {
+			lastPayment = item.LastPaymentDate.Format("2006-01-02")
+		}
+		w.Write([]string{
+			item.CustomerID,
+			item.Region,
+			item.Branch,
+			item.AgentID,
+			fmt.Sprintf("%.2f", item.OutstandingBalance),
+			fmt.Sprintf("%.2f", item.Arrears),
+			fmt.Sprintf("%d", item.DaysPastDue),
+			fmt.Sprintf("%d", item.BrokenPromises),
+			lastPayment,
+			item.LastCallStatus,
+			fmt.Sprintf("%d", item.UnansweredCalls),
+			fmt.Sprintf("%.2f", item.Priority),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,344 @@
+package tools
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrCommissionRunApproved is returned when recalculating or approving a
+// run that has already been approved.
+var ErrCommissionRunApproved = errors.New("commission run is already approved")
+
+const commissionSchemeColumns = `
+	scheme_id, name, tiers, bonus_on_time_rate, bonus_min_customers, bonus_amount, active, created_at, updated_at
+`
+
+func scanCommissionScheme(row pgx.Row) (*api.CommissionScheme, error) {
+	var s api.CommissionScheme
+	err := row.Scan(&s.SchemeID, &s.Name, &s.Tiers, &s.BonusOnTimeRate, &s.BonusMinCustomers, &s.BonusAmount,
+		&s.Active, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ValidateCommissionTiers checks tiers start at 0 and ascend.
+func ValidateCommissionTiers(tiers []api.CommissionTier) error {
+	for i, tier := range tiers {
+		if i == 0 && tier.From != 0 {
+			return errors.New("the first tier must start from 0")
+		}
+		if i > 0 && tier.From <= tiers[i-1].From {
+			return errors.New("tiers must be in ascending order of from")
+		}
+	}
+	return nil
+}
+
+// TieredCommission is the commission on collected under tiers, each rate
+// applying to the part of collected within its tier.
+func TieredCommission(tiers []api.CommissionTier, collected float64) float64 {
+	var commission float64
+	for i, tier := range tiers {
+		if collected <= tier.From {
+			break
+		}
+		upper := collected
+		if i+1 < len(tiers) && tiers[i+1].From < collected {
+			upper = tiers[i+1].From
+		}
+		commission += (upper - tier.From) * tier.Rate
+	}
+	return math.Round(commission*100) / 100
+}
+
+func (db *DatabaseService) SaveCommissionScheme(ctx context.Context, scheme *api.CommissionScheme) (*api.CommissionScheme, error) {
+	query := `
+		INSERT INTO commission_schemes (scheme_id, name, tiers, bonus_on_time_rate, bonus_min_customers, bonus_amount, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (scheme_id) DO UPDATE
+		SET name = EXCLUDED.name,
+		    tiers = EXCLUDED.tiers,
+		    bonus_on_time_rate = EXCLUDED.bonus_on_time_rate,
+		    bonus_min_customers = EXCLUDED.bonus_min_customers,
+		    bonus_amount = EXCLUDED.bonus_amount,
+		    active = EXCLUDED.active,
+		    updated_at = NOW()
+		RETURNING ` + commissionSchemeColumns
+
+	return scanCommissionScheme(db.QueryRow(ctx, query, scheme.SchemeID, scheme.Name, scheme.Tiers, scheme.BonusOnTimeRate,
+		scheme.BonusMinCustomers, scheme.BonusAmount, scheme.Active))
+}
+
+func (db *DatabaseService) GetCommissionScheme(ctx context.Context, schemeID string) (*api.CommissionScheme, error) {
+	query := `SELECT ` + commissionSchemeColumns + ` FROM commission_schemes WHERE scheme_id = $1`
+	return scanCommissionScheme(db.QueryRow(ctx, query, schemeID))
+}
+
+func (db *DatabaseService) ListCommissionSchemes(ctx context.Context) ([]*api.CommissionScheme, error) {
+	rows, err := db.Query(ctx, `SELECT `+commissionSchemeColumns+` FROM commission_schemes ORDER BY scheme_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schemes := []*api.CommissionScheme{}
+	for rows.Next() {
+		scheme, err := scanCommissionScheme(rows)
+		if err != nil {
+			return nil, err
+		}
+		schemes = append(schemes, scheme)
+	}
+	return schemes, rows.Err()
+}
+
+const commissionRunColumns = `
+	id, to_char(period_start, 'YYYY-MM-DD'), to_char(period_end, 'YYYY-MM-DD'), status, agents, total,
+	calculated_by, calculated_at, COALESCE(approved_by, ''), approved_at
+`
+
+func scanCommissionRun(row pgx.Row) (*api.CommissionRun, error) {
+	var r api.CommissionRun
+	err := row.Scan(&r.ID, &r.PeriodStart, &r.PeriodEnd, &r.Status, &r.Agents, &r.Total,
+		&r.CalculatedBy, &r.CalculatedAt, &r.ApprovedBy, &r.ApprovedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// commissionActivity is each active agent's collections from $1 up to $2,
+// with their scheme if they are on an active one. Customers collected from
+// count as on time if they are not in arrears now.
+const commissionActivity = `
+	SELECT a.agent_id, a.commission_rate, COALESCE(s.scheme_id, ''), COALESCE(s.tiers, '[]'::JSONB),
+	       COALESCE(s.bonus_on_time_rate, 0), COALESCE(s.bonus_min_customers, 0), COALESCE(s.bonus_amount, 0),
+	       COUNT(t.transaction_reference), COUNT(DISTINCT t.customer_id), COALESCE(SUM(t.amount), 0),
+	       (SELECT COUNT(*) FROM customer_accounts c
+	        WHERE c.customer_id IN (
+	            SELECT customer_id FROM processed_transactions
+	            WHERE agent_id = a.agent_id AND processed_at >= $1 AND processed_at < $2
+	        ) AND ` + arrearsExpr + ` = 0)
+	FROM agents a
+	LEFT JOIN commission_schemes s ON s.scheme_id = a.scheme_id AND s.active
+	JOIN processed_transactions t ON t.agent_id = a.agent_id AND t.processed_at >= $1 AND t.processed_at < $2
+	WHERE a.active
+	GROUP BY a.agent_id, a.commission_rate, s.scheme_id
+	ORDER BY a.agent_id
+`
+
+// CalculateCommissionRun works out every active agent's commission for the
+// days from through to and stores it as a run, replacing an earlier
+// calculation of the same period unless that has been approved.
+func (db *DatabaseService) CalculateCommissionRun(ctx context.Context, from, to time.Time, calculatedBy string) (*api.CommissionRun, error) {
+	var run *api.CommissionRun
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		run, err = scanCommissionRun(tx.QueryRow(ctx, `
+			INSERT INTO commission_runs (period_start, period_end, calculated_by)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (period_start, period_end) DO UPDATE
+			SET calculated_by = EXCLUDED.calculated_by,
+			    calculated_at = NOW()
+			WHERE commission_runs.status = 'CALCULATED'
+			RETURNING `+commissionRunColumns, from, to, calculatedBy))
+		if errors.Is(err, ErrNotFound) {
+			return ErrCommissionRunApproved
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM commission_lines WHERE run_id = $1`, run.ID); err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, commissionActivity, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			return err
+		}
+		var lines []*api.CommissionLine
+		for rows.Next() {
+			var line api.CommissionLine
+			var flatRate float64
+			var scheme api.CommissionScheme
+			if err := rows.Scan(&line.AgentID, &flatRate, &line.SchemeID, &scheme.Tiers, &scheme.BonusOnTimeRate,
+				&scheme.BonusMinCustomers, &scheme.BonusAmount, &line.Payments, &line.Customers, &line.Collected,
+				&line.OnTimeCustomers); err != nil {
+				rows.Close()
+				return err
+			}
+			if line.SchemeID == "" {
+				scheme.Tiers = []api.CommissionTier{{From: 0, Rate: flatRate}}
+			}
+			line.Commission = TieredCommission(scheme.Tiers, line.Collected)
+			if scheme.BonusAmount > 0 && line.Customers > 0 && line.Customers >= scheme.BonusMinCustomers &&
+				float64(line.OnTimeCustomers) >= scheme.BonusOnTimeRate*float64(line.Customers) {
+				line.Bonus = scheme.BonusAmount
+			}
+			line.Total = math.Round((line.Commission+line.Bonus)*100) / 100
+			lines = append(lines, &line)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO commission_lines (run_id, agent_id, scheme_id, payments, customers, collected, commission,
+				                              on_time_customers, bonus, total)
+				VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10)
+			`, run.ID, line.AgentID, line.SchemeID, line.Payments, line.Customers, line.Collected, line.Commission,
+				line.OnTimeCustomers, line.Bonus, line.Total)
+			if err != nil {
+				return err
+			}
+		}
+
+		run, err = scanCommissionRun(tx.QueryRow(ctx, `
+			UPDATE commission_runs
+			SET agents = (SELECT COUNT(*) FROM commission_lines WHERE run_id = $1),
+			    total = (SELECT COALESCE(SUM(total), 0) FROM commission_lines WHERE run_id = $1)
+			WHERE id = $1
+			RETURNING `+commissionRunColumns, run.ID))
+		run.Lines = lines
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// GetCommissionRun returns the run with its lines, largest first.
+func (db *DatabaseService) GetCommissionRun(ctx context.Context, id int64) (*api.CommissionRun, error) {
+	run, err := scanCommissionRun(db.QueryRow(ctx, `SELECT `+commissionRunColumns+` FROM commission_runs WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT agent_id, COALESCE(scheme_id, ''), payments, customers, collected, commission, on_time_customers,
+		       bonus, total, COALESCE(payout_reference, '')
+		FROM commission_lines
+		WHERE run_id = $1
+		ORDER BY total DESC, agent_id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	run.Lines = []*api.CommissionLine{}
+	for rows.Next() {
+		var l api.CommissionLine
+		if err := rows.Scan(&l.AgentID, &l.SchemeID, &l.Payments, &l.Customers, &l.Collected, &l.Commission,
+			&l.OnTimeCustomers, &l.Bonus, &l.Total, &l.PayoutReference); err != nil {
+			return nil, err
+		}
+		run.Lines = append(run.Lines, &l)
+	}
+	return run, rows.Err()
+}
+
+// ListCommissionRuns returns runs without their lines, latest period first.
+func (db *DatabaseService) ListCommissionRuns(ctx context.Context, limit int) ([]*api.CommissionRun, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+commissionRunColumns+`
+		FROM commission_runs
+		ORDER BY period_end DESC, id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*api.CommissionRun{}
+	for rows.Next() {
+		run, err := scanCommissionRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// CommissionRunExists reports whether the period has been calculated.
+func (db *DatabaseService) CommissionRunExists(ctx context.Context, from, to time.Time) (bool, error) {
+	var exists bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM commission_runs WHERE period_start = $1 AND period_end = $2)
+	`, from, to).Scan(&exists)
+	return exists, err
+}
+
+// ApproveCommissionRun fixes the run so it can be paid out. It returns
+// ErrCommissionRunApproved if it already was.
+func (db *DatabaseService) ApproveCommissionRun(ctx context.Context, id int64, approvedBy string) (*api.CommissionRun, error) {
+	tag, err := db.Exec(ctx, `
+		UPDATE commission_runs
+		SET status = 'APPROVED', approved_by = $2, approved_at = NOW()
+		WHERE id = $1 AND status = 'CALCULATED'
+	`, id, approvedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := db.GetCommissionRun(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return run, ErrCommissionRunApproved
+	}
+	return run, nil
+}
+
+// CommissionPayoutReference is the payout of an agent's commission in a
+// run; it is fixed, so paying a run out again creates no duplicates.
+func CommissionPayoutReference(runID int64, agentID string) string {
+	return fmt.Sprintf("COM-%d-%s", runID, agentID)
+}
+
+func (db *DatabaseService) SetCommissionPayout(ctx context.Context, runID int64, agentID, reference string) error {
+	_, err := db.Exec(ctx, `
+		UPDATE commission_lines SET payout_reference = $3 WHERE run_id = $1 AND agent_id = $2
+	`, runID, agentID, reference)
+	return err
+}
+
+func WriteCommissionRunCSV(out io.Writer, run *api.CommissionRun) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"period_start", "period_end", "agent_id", "scheme_id", "payments", "customers", "collected",
+		"commission", "on_time_customers", "bonus", "total", "payout_reference"})
+	for _, l := range run.Lines {
+		w.Write([]string{
+			run.PeriodStart,
+			run.PeriodEnd,
+			l.AgentID,
+			l.SchemeID,
+			fmt.Sprintf("%d", l.Payments),
+			fmt.Sprintf("%d", l.Customers),
+			fmt.Sprintf("%.2f", l.Collected),
+			fmt.Sprintf("%.2f", l.Commission),
+			fmt.Sprintf("%d", l.OnTimeCustomers),
+			fmt.Sprintf("%.2f", l.Bonus),
+			fmt.Sprintf("%.2f", l.Total),
+			l.PayoutReference,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
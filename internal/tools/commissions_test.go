@@ -0,0 +1,29 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+func TestTieredCommission(t *testing.T) {
+	tiers := []api.CommissionTier{{From: 0, Rate: 0.01}, {From: 100000, Rate: 0.02}, {From: 500000, Rate: 0.03}}
+
+	tests := []struct {
+		collected float64
+		want      float64
+	}{
+		{0, 0},
+		{50000, 500},
+		{100000, 1000},
+		{250000, 4000},
+		{600000, 12000},
+		{333.33, 3.33},
+	}
+
+	for _, tt := range tests {
+		if got := TieredCommission(tiers, tt.collected); got != tt.want {
+			t.Errorf("TieredCommission(%v) = %v; want %v", tt.collected, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const contactColumns = `
+	customer_id, COALESCE(phone, ''), phone_verified, COALESCE(email, ''), email_verified,
+	COALESCE(address_line1, ''), COALESCE(address_line2, ''), COALESCE(city, ''),
+	COALESCE(postal_code, ''), COALESCE(country, ''), consent_sms, consent_email,
+	COALESCE(consent_source, ''), consent_updated_at, updated_at
+`
+
+// scanContact decrypts phone and email, which are sealed at rest when PII
+// encryption is enabled.
+func (db *DatabaseService) scanContact(ctx context.Context, row pgx.Row) (*api.ContactProfile, error) {
+	var contact api.ContactProfile
+	err := row.Scan(
+		&contact.CustomerID,
+		&contact.Phone,
+		&contact.PhoneVerified,
+		&contact.Email,
+		&contact.EmailVerified,
+		&contact.AddressLine1,
+		&contact.AddressLine2,
+		&contact.City,
+		&contact.PostalCode,
+		&contact.Country,
+		&contact.ConsentSMS,
+		&contact.ConsentEmail,
+		&contact.ConsentSource,
+		&contact.ConsentUpdatedAt,
+		&contact.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if contact.Phone, err = db.openPII(ctx, contact.Phone); err != nil {
+		return nil, err
+	}
+	if contact.Email, err = db.openPII(ctx, contact.Email); err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// GetContactProfile returns an empty, opted-out profile for customers that
+// have never had contact details recorded.
+func (db *DatabaseService) GetContactProfile(ctx context.Context, customerID string) (*api.ContactProfile, error) {
+	query := `SELECT ` + contactColumns + ` FROM customer_contacts WHERE customer_id = $1`
+
+	contact, err := db.scanContact(ctx, db.QueryRow(ctx, query, customerID))
+	if errors.Is(err, ErrNotFound) {
+		if _, err := db.GetCustomer(ctx, customerID); err != nil {
+			return nil, err
+		}
+		return &api.ContactProfile{CustomerID: customerID}, nil
+	}
+	return contact, err
+}
+
+// UpdateContactProfile applies the non-nil fields. Changing a phone number
+// or email address clears its verified flag. The comparison happens here
+// rather than in SQL because encrypted values differ on every write.
+func (db *DatabaseService) UpdateContactProfile(ctx context.Context, customerID string, update api.ContactUpdate) (*api.ContactProfile, error) {
+	contact, err := db.GetContactProfile(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Phone != nil && *update.Phone != contact.Phone {
+		contact.Phone = *update.Phone
+		contact.PhoneVerified = false
+	}
+	if update.Email != nil && *update.Email != contact.Email {
+		contact.Email = *update.Email
+		contact.EmailVerified = false
+	}
+	if update.AddressLine1 != nil {
+		contact.AddressLine1 = *update.AddressLine1
+	}
+	if update.AddressLine2 != nil {
+		contact.AddressLine2 = *update.AddressLine2
+	}
+	if update.City != nil {
+		contact.City = *update.City
+	}
+	if update.PostalCode != nil {
+		contact.PostalCode = *update.PostalCode
+	}
+	if update.Country != nil {
+		contact.Country = *update.Country
+	}
+
+	phone, err := db.sealPII(contact.Phone)
+	if err != nil {
+		return nil, err
+	}
+	email, err := db.sealPII(contact.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO customer_contacts (
+			customer_id, phone, phone_verified, email, email_verified,
+			address_line1, address_line2, city, postal_code, country
+		)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''))
+		ON CONFLICT (customer_id) DO UPDATE
+		SET phone = EXCLUDED.phone,
+		    phone_verified = EXCLUDED.phone_verified,
+		    email = EXCLUDED.email,
+		    email_verified = EXCLUDED.email_verified,
+		    address_line1 = EXCLUDED.address_line1,
+		    address_line2 = EXCLUDED.address_line2,
+		    city = EXCLUDED.city,
+		    postal_code = EXCLUDED.postal_code,
+		    country = EXCLUDED.country,
+		    updated_at = NOW()
+		RETURNING ` + contactColumns
+
+	return db.scanContact(ctx, db.QueryRow(ctx, query, customerID,
+		phone, contact.PhoneVerified, email, contact.EmailVerified,
+		contact.AddressLine1, contact.AddressLine2, contact.City, contact.PostalCode, contact.Country,
+	))
+}
+
+func (db *DatabaseService) UpdateConsent(ctx context.Context, customerID string, update api.ConsentUpdate) (*api.ContactProfile, error) {
+	query := `
+		INSERT INTO customer_contacts (customer_id, consent_sms, consent_email, consent_source, consent_updated_at)
+		VALUES ($1, COALESCE($2, FALSE), COALESCE($3, FALSE), $4, NOW())
+		ON CONFLICT (customer_id) DO UPDATE
+		SET consent_sms = COALESCE($2, customer_contacts.consent_sms),
+		    consent_email = COALESCE($3, customer_contacts.consent_email),
+		    consent_source = $4,
+		    consent_updated_at = NOW(),
+		    updated_at = NOW()
+		RETURNING ` + contactColumns
+
+	return db.scanContact(ctx, db.QueryRow(ctx, query, customerID, update.SMS, update.Email, update.Source))
+}
+
+func (db *DatabaseService) MarkContactVerified(ctx context.Context, customerID, channel string) error {
+	column, ok := map[string]string{"sms": "phone_verified", "email": "email_verified"}[channel]
+	if !ok {
+		return fmt.Errorf("unknown contact channel %q", channel)
+	}
+
+	_, err := db.Exec(ctx, `UPDATE customer_contacts SET `+column+` = TRUE, updated_at = NOW() WHERE customer_id = $1`, customerID)
+	return err
+}
+
+// HasConsent reports whether the customer opted in to the channel and has a
+// verified address for it.
+func (db *DatabaseService) HasConsent(ctx context.Context, customerID, channel string) (bool, error) {
+	var query string
+	switch channel {
+	case "sms", "voice", "whatsapp":
+		// Reminder calls and WhatsApp messages go to the same verified phone
+		// under the SMS consent.
+		query = `SELECT consent_sms AND phone_verified FROM customer_contacts WHERE customer_id = $1`
+	case "email":
+		query = `SELECT consent_email AND email_verified FROM customer_contacts WHERE customer_id = $1`
+	default:
+		return false, nil
+	}
+
+	var allowed bool
+	err := db.QueryRow(ctx, query, customerID).Scan(&allowed)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return allowed, err
+}
+
+const preferencesColumns = `customer_id, consent_sms, consent_email, COALESCE(language, ''),
+	COALESCE(quiet_hours_start, ''), COALESCE(quiet_hours_end, ''), COALESCE(timezone, ''), preferences_updated_at`
+
+func scanPreferences(row pgx.Row) (*api.NotificationPreferences, error) {
+	var preferences api.NotificationPreferences
+	err := row.Scan(
+		&preferences.CustomerID,
+		&preferences.SMS,
+		&preferences.Email,
+		&preferences.Language,
+		&preferences.QuietHoursStart,
+		&preferences.QuietHoursEnd,
+		&preferences.Timezone,
+		&preferences.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+// GetNotificationPreferences returns empty, opted-out preferences for
+// customers that have never set any.
+func (db *DatabaseService) GetNotificationPreferences(ctx context.Context, customerID string) (*api.NotificationPreferences, error) {
+	query := `SELECT ` + preferencesColumns + ` FROM customer_contacts WHERE customer_id = $1`
+
+	preferences, err := scanPreferences(db.QueryRow(ctx, query, customerID))
+	if errors.Is(err, ErrNotFound) {
+		if _, err := db.GetCustomer(ctx, customerID); err != nil {
+			return nil, err
+		}
+		return &api.NotificationPreferences{CustomerID: customerID}, nil
+	}
+	return preferences, err
+}
+
+// UpdateNotificationPreferences applies the non-nil fields. Channel changes
+// are recorded as a consent change from source.
+func (db *DatabaseService) UpdateNotificationPreferences(ctx context.Context, customerID string, update api.PreferencesUpdate, source string) (*api.NotificationPreferences, error) {
+	preferences, err := db.GetNotificationPreferences(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	consentChanged := update.SMS != nil || update.Email != nil
+	if update.SMS != nil {
+		preferences.SMS = *update.SMS
+	}
+	if update.Email != nil {
+		preferences.Email = *update.Email
+	}
+	if update.Language != nil {
+		preferences.Language = *update.Language
+	}
+	if update.QuietHoursStart != nil {
+		preferences.QuietHoursStart = *update.QuietHoursStart
+	}
+	if update.QuietHoursEnd != nil {
+		preferences.QuietHoursEnd = *update.QuietHoursEnd
+	}
+	if update.Timezone != nil {
+		preferences.Timezone = *update.Timezone
+	}
+
+	query := `
+		INSERT INTO customer_contacts (
+			customer_id, consent_sms, consent_email, consent_source, consent_updated_at,
+			language, quiet_hours_start, quiet_hours_end, timezone, preferences_updated_at
+		)
+		VALUES ($1, $2, $3, CASE WHEN $4 THEN $5 END, CASE WHEN $4 THEN NOW() END,
+		        NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NOW())
+		ON CONFLICT (customer_id) DO UPDATE
+		SET consent_sms = EXCLUDED.consent_sms,
+		    consent_email = EXCLUDED.consent_email,
+		    consent_source = CASE WHEN $4 THEN EXCLUDED.consent_source ELSE customer_contacts.consent_source END,
+		    consent_updated_at = CASE WHEN $4 THEN NOW() ELSE customer_contacts.consent_updated_at END,
+		    language = EXCLUDED.language,
+		    quiet_hours_start = EXCLUDED.quiet_hours_start,
+		    quiet_hours_end = EXCLUDED.quiet_hours_end,
+		    timezone = EXCLUDED.timezone,
+		    preferences_updated_at = NOW(),
+		    updated_at = NOW()
+		RETURNING ` + preferencesColumns
+
+	return scanPreferences(db.QueryRow(ctx, query, customerID, preferences.SMS, preferences.Email, consentChanged, source,
+		preferences.Language, preferences.QuietHoursStart, preferences.QuietHoursEnd, preferences.Timezone))
+}
@@ -2,24 +2,73 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	log "github.com/sirupsen/logrus"
 )
 
 type DatabaseService struct {
 	Pool *pgxpool.Pool
+	// Clock dates retention cut-offs, snapshots and imports; nil is the
+	// system clock.
+	Clock Clock
+
+	queryTimeout       time.Duration
+	slowQueryThreshold time.Duration
+	traceComments      bool
+	failoverRetry      bool
+	pii                *piiCipher
+	reconnects         reconnectState
+}
+
+func NewDatabaseService(ctx context.Context, cfg *Config) (*DatabaseService, error) {
+	db, err := OpenDatabaseService(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Pool.Ping(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Println("Database connected successfully")
+	return db, nil
 }
 
-func NewDatabaseService(ctx context.Context, databaseURL string) (*DatabaseService, error) {
-	config, err := pgxpool.ParseConfig(databaseURL)
+// OpenDatabaseService sets up the connection pool without waiting for
+// Postgres; connections are made as they are needed. Use Ping to find out
+// whether the database is up.
+func OpenDatabaseService(ctx context.Context, cfg *Config) (*DatabaseService, error) {
+	config, err := pgxpool.ParseConfig(cfg.DatabaseURL)
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.DBApplicationName != "" {
+		config.ConnConfig.RuntimeParams["application_name"] = cfg.DBApplicationName
+	}
+	// With several hosts in DATABASE_URL, only connect to the one that
+	// takes writes unless the URL says otherwise.
+	if len(config.ConnConfig.Fallbacks) > 0 && config.ConnConfig.ValidateConnect == nil {
+		validate, err := targetSessionAttrs(cfg.DBTargetSessionAttrs)
+		if err != nil {
+			return nil, err
+		}
+		config.ConnConfig.ValidateConnect = validate
+	}
+
+	if cfg.DBPgBouncer {
+		usePgBouncerMode(config)
+	}
+
 	config.MaxConns = 50
 	config.MinConns = 10
 	config.MaxConnLifetime = time.Hour
@@ -30,29 +79,87 @@ func NewDatabaseService(ctx context.Context, databaseURL string) (*DatabaseServi
 		return nil, err
 	}
 
-	if err := pool.Ping(ctx); err != nil {
-		return nil, err
-	}
-
-	log.Println("Database connected successfully")
-	return &DatabaseService{Pool: pool}, nil
+	return &DatabaseService{
+		Pool:               pool,
+		queryTimeout:       cfg.DBQueryTimeout,
+		slowQueryThreshold: cfg.DBSlowQueryThreshold,
+		traceComments:      cfg.DBTraceComments,
+		failoverRetry:      cfg.DBFailoverRetry,
+	}, nil
 }
 
 func (db *DatabaseService) Close() {
 	db.Pool.Close()
 }
 
-func (db *DatabaseService) GetCustomer(ctx context.Context, customerID string) (*api.CustomerAccount, error) {
-	query := `
-		SELECT customer_id, asset_value, term_weeks, total_paid, outstanding_balance, 
-		       deployment_date, last_payment_date, payment_count, version
-		FROM customer_accounts
-		WHERE customer_id = $1
-	`
+// IsUnavailable reports whether err means Postgres couldn't be reached, as
+// opposed to a query failing or finding nothing.
+func IsUnavailable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) ||
+		pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Ping round-trips to Postgres and reports how long it took.
+func (db *DatabaseService) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := db.Pool.Ping(ctx)
+	return time.Since(start), err
+}
+
+// Now is the current time on db's clock.
+func (db *DatabaseService) Now() time.Time {
+	if db.Clock == nil {
+		return time.Now()
+	}
+	return db.Clock.Now()
+}
+
+const customerColumns = `
+	customer_id, COALESCE(holder_id, customer_id), asset_value, term_weeks, total_paid, outstanding_balance,
+	deployment_date, last_payment_date, payment_count, version, metadata,
+	COALESCE(region, ''), COALESCE(branch, ''), COALESCE(product_id, ''), COALESCE(asset_type, ''),
+	interest_rate, interest_method, grace_weeks, COALESCE(installment_amount, 0),
+	restructured_at, schedule_baseline, written_off_at, written_off_amount, recovered_amount,
+	COALESCE(calendar_code, ''), projected_payoff_date, risk_score, risk_scored_at
+`
+
+// installmentExpr is the weekly amount due, falling back to the
+// zero-interest split for accounts without a loan product.
+const installmentExpr = `COALESCE(installment_amount, asset_value / NULLIF(term_weeks, 0))`
+
+// arrearsExpr computes how far behind the weekly schedule an account is,
+// counting installments from their holiday-shifted due dates.
+const arrearsExpr = `GREATEST(0, LEAST(total_paid + outstanding_balance,
+	schedule_baseline + ` + installmentExpr + ` * LEAST(grace_weeks + term_weeks - ` + scheduleStartExpr + `,
+		GREATEST(0, weeks_due(deployment_date, calendar_code) - ` + scheduleStartExpr + `)))
+	- total_paid)`
+
+// scheduleStartExpr is the week installments count from: the end of the
+// grace period, or the restructuring date once an account is rescheduled.
+const scheduleStartExpr = `COALESCE(FLOOR(EXTRACT(EPOCH FROM restructured_at - deployment_date) / 604800), grace_weeks)`
 
+type CustomerFilter struct {
+	Region    string
+	Branch    string
+	AssetType string
+}
+
+type CustomerUpdate struct {
+	Metadata api.Metadata
+	Region   *string
+	Branch   *string
+	// Version, when set, makes the update conditional on the account still
+	// being at that version; a mismatch returns ErrVersionConflict.
+	Version *int
+}
+
+func scanCustomer(row pgx.Row) (*api.CustomerAccount, error) {
 	var customer api.CustomerAccount
-	err := db.Pool.QueryRow(ctx, query, customerID).Scan(
+	err := row.Scan(
 		&customer.CustomerID,
+		&customer.HolderID,
 		&customer.AssetValue,
 		&customer.TermWeeks,
 		&customer.TotalPaid,
@@ -61,6 +168,24 @@ func (db *DatabaseService) GetCustomer(ctx context.Context, customerID string) (
 		&customer.LastPaymentDate,
 		&customer.PaymentCount,
 		&customer.Version,
+		&customer.Metadata,
+		&customer.Region,
+		&customer.Branch,
+		&customer.ProductID,
+		&customer.AssetType,
+		&customer.InterestRate,
+		&customer.InterestMethod,
+		&customer.GraceWeeks,
+		&customer.InstallmentAmount,
+		&customer.RestructuredAt,
+		&customer.ScheduleBaseline,
+		&customer.WrittenOffAt,
+		&customer.WrittenOffAmount,
+		&customer.RecoveredAmount,
+		&customer.CalendarCode,
+		&customer.ProjectedPayoffDate,
+		&customer.RiskScore,
+		&customer.RiskScoredAt,
 	)
 
 	if err != nil {
@@ -70,11 +195,87 @@ func (db *DatabaseService) GetCustomer(ctx context.Context, customerID string) (
 	return &customer, nil
 }
 
-func (db *DatabaseService) UpdateCustomerBalance(ctx context.Context, customerID string, amount float64, txnDate string, version int) (bool, error) {
+func (db *DatabaseService) GetCustomer(ctx context.Context, customerID string) (*api.CustomerAccount, error) {
+	query := `SELECT ` + customerColumns + ` FROM customer_accounts WHERE customer_id = $1`
+
+	return scanCustomer(db.QueryRow(ctx, query, customerID))
+}
+
+// GetCustomerVersion reads only the account version, which changes on every
+// mutation, so callers can validate cached representations cheaply.
+func (db *DatabaseService) GetCustomerVersion(ctx context.Context, customerID string) (int, error) {
+	var version int
+	err := db.QueryRow(ctx, `SELECT version FROM customer_accounts WHERE customer_id = $1`, customerID).Scan(&version)
+	return version, err
+}
+
+func (db *DatabaseService) ListCustomers(ctx context.Context, filter CustomerFilter, limit, offset int) ([]*api.CustomerAccount, int, error) {
+	where := `
+		WHERE ($1 = '' OR region = $1)
+		  AND ($2 = '' OR branch = $2)
+		  AND ($3 = '' OR asset_type = $3)
+	`
+
+	var total int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM customer_accounts`+where, filter.Region, filter.Branch, filter.AssetType).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + customerColumns + ` FROM customer_accounts` + where + `
+		ORDER BY customer_id
+		LIMIT $4 OFFSET $5
+	`
+
+	rows, err := db.Query(ctx, query, filter.Region, filter.Branch, filter.AssetType, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	customers := []*api.CustomerAccount{}
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		customers = append(customers, customer)
+	}
+
+	return customers, total, rows.Err()
+}
+
+func (db *DatabaseService) UpdateCustomer(ctx context.Context, customerID string, update CustomerUpdate) (*api.CustomerAccount, error) {
+	query := `
+		UPDATE customer_accounts
+		SET metadata = COALESCE($2, metadata),
+		    region = COALESCE($3, region),
+		    branch = COALESCE($4, branch),
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE customer_id = $1 AND ($5::INT IS NULL OR version = $5)
+		RETURNING ` + customerColumns
+
+	customer, err := scanCustomer(db.QueryRow(ctx, query, customerID, update.Metadata, update.Region, update.Branch, update.Version))
+	if errors.Is(err, ErrNotFound) && update.Version != nil {
+		if _, err := db.GetCustomerVersion(ctx, customerID); err != nil {
+			return nil, err
+		}
+		return nil, ErrVersionConflict
+	}
+	return customer, err
+}
+
+// UpdateCustomerBalance applies a payment to the account as of version,
+// taking waived off the outstanding balance with it: the interest forgiven
+// when the payment settles the loan early (see schedule.Payoff).
+// It returns ErrVersionConflict if the account has changed since.
+func (db *DatabaseService) UpdateCustomerBalance(ctx context.Context, customerID string, amount, waived float64, txnDate string, version int) error {
 	query := `
 		UPDATE customer_accounts
 		SET total_paid = total_paid + $2,
-		    outstanding_balance = GREATEST(0, asset_value - (total_paid + $2)),
+		    outstanding_balance = GREATEST(0, outstanding_balance - $2 - $5),
+		    interest_waived = interest_waived + LEAST($5, GREATEST(0, outstanding_balance - $2)),
+		    recovered_amount = recovered_amount + CASE WHEN written_off_at IS NULL THEN 0 ELSE $2 END,
 		    last_payment_date = $3,
 		    payment_count = payment_count + 1,
 		    version = version + 1,
@@ -84,68 +285,330 @@ func (db *DatabaseService) UpdateCustomerBalance(ctx context.Context, customerID
 	`
 
 	var balance float64
-	err := db.Pool.QueryRow(ctx, query, customerID, amount, txnDate, version).Scan(&balance)
-
-	if err != nil {
-		if err.Error() == "no rows in result set" {
-			return false, nil
-		}
-		return false, err
+	err := db.QueryRow(ctx, query, customerID, amount, txnDate, version, waived).Scan(&balance)
+	if errors.Is(err, ErrNotFound) {
+		return ErrVersionConflict
 	}
-
-	return true, nil
+	return err
 }
 
 func (db *DatabaseService) IsTransactionProcessed(ctx context.Context, txnRef string) (bool, error) {
-	query := `SELECT EXISTS(SELECT 1 FROM processed_transactions WHERE transaction_reference = $1)`
+	query := `SELECT EXISTS(SELECT 1 FROM processed_references WHERE transaction_reference = $1)`
 
 	var exists bool
-	err := db.Pool.QueryRow(ctx, query, txnRef).Scan(&exists)
+	err := db.QueryRow(ctx, query, txnRef).Scan(&exists)
 	return exists, err
 }
 
-func (db *DatabaseService) MarkTransactionProcessed(ctx context.Context, txnRef, customerID string, amount float64) error {
+// MarkTransactionProcessed records the payment with its value date, the
+// business day it counts towards for schedule purposes, and the import batch
+// it came from. The reference is
+// claimed in processed_references first, so a transaction is only recorded
+// once whichever partition it would land in.
+func (db *DatabaseService) MarkTransactionProcessed(ctx context.Context, payment *api.PaymentPayload, amount float64, valueDate time.Time) error {
 	query := `
-		INSERT INTO processed_transactions (transaction_reference, customer_id, amount, processed_at)
-		VALUES ($1, $2, $3, NOW())
-		ON CONFLICT (transaction_reference) DO NOTHING
+		WITH claimed AS (
+			INSERT INTO processed_references (transaction_reference, processed_at)
+			VALUES ($1, NOW())
+			ON CONFLICT (transaction_reference) DO NOTHING
+			RETURNING processed_at
+		)
+		INSERT INTO processed_transactions (transaction_reference, customer_id, amount, processed_at, metadata, agent_id, recovery, value_date, import_batch_id)
+		SELECT $1, $2, $3, claimed.processed_at, $4, NULLIF($5, ''),
+		       EXISTS(SELECT 1 FROM customer_accounts WHERE customer_id = $2 AND written_off_at IS NOT NULL), $6::DATE, $7
+		FROM claimed
 	`
 
-	_, err := db.Pool.Exec(ctx, query, txnRef, customerID, amount)
+	_, err := db.Exec(ctx, query, payment.TransactionReference, payment.CustomerID, amount, payment.Metadata, payment.AgentID,
+		valueDate.Format("2006-01-02"), importBatchID(payment))
 	return err
 }
 
-func (db *DatabaseService) SeedCustomers(ctx context.Context, count int) error {
-	log.Printf("Seeding %d customers...", count)
+// SeedOptions shape the accounts SeedCustomers creates. The zero value
+// seeds the historical fixed accounts: 1,000,000.00 over 50 weeks, deployed
+// within the last 180 days, with no payments.
+type SeedOptions struct {
+	Count int
+	// AssetValues and TermWeeks are drawn from uniformly for each account;
+	// repeat a value to weight it.
+	AssetValues []float64
+	TermWeeks   []int
+	// AssetTypes, when set, are drawn from instead: each account takes its
+	// asset's catalog price and default term.
+	AssetTypes []string
+	// MaxAgeDays spreads deployment dates over this many past days.
+	MaxAgeDays int
+	// PaidPercent of new accounts (0-100) get a payment history: one
+	// installment for each week due so far, each paid with probability
+	// OnTimeRate, so some of them fall behind.
+	PaidPercent float64
+	OnTimeRate  float64
+}
 
-	query := `
-		INSERT INTO customer_accounts (
-			customer_id, 
-			asset_value, 
-			term_weeks, 
-			deployment_date
-		)
-		SELECT 
-			'GIG' || LPAD(generate_series::TEXT, 5, '0'),
-			1000000.00,
-			50,
-			NOW() - (random() * INTERVAL '180 days')
-		FROM generate_series(1, $1)
-		ON CONFLICT (customer_id) DO NOTHING
-	`
+type SeedResult struct {
+	Created      int64 `json:"created"`
+	PaidAccounts int64 `json:"paid_accounts"`
+	Payments     int64 `json:"payments"`
+}
 
-	result, err := db.Pool.Exec(ctx, query, count)
+func (o *SeedOptions) applyDefaults() {
+	if len(o.AssetValues) == 0 {
+		o.AssetValues = []float64{1000000.00}
+	}
+	if len(o.TermWeeks) == 0 {
+		o.TermWeeks = []int{50}
+	}
+	if o.MaxAgeDays <= 0 {
+		o.MaxAgeDays = 180
+	}
+}
+
+// SeedCustomers creates test accounts GIG00001 upwards, skipping IDs that
+// already exist. Seeded payments go through processed_transactions like
+// real ones, with references SEED-<customer>-<week>, and the accounts'
+// totals are rolled up from them.
+func (db *DatabaseService) SeedCustomers(ctx context.Context, opts SeedOptions) (*SeedResult, error) {
+	opts.applyDefaults()
+	log.Printf("Seeding %d customers...", opts.Count)
+
+	result := &SeedResult{}
+	now := db.Now()
+
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			INSERT INTO customer_accounts (
+				customer_id,
+				asset_value,
+				outstanding_balance,
+				term_weeks,
+				asset_type,
+				deployment_date
+			)
+			SELECT s.id, COALESCE(a.price, s.asset_value), COALESCE(a.price, s.asset_value),
+			       COALESCE(a.default_term_weeks, s.term_weeks), a.asset_type, s.deployment_date
+			FROM (
+				SELECT
+					'GIG' || LPAD(n::TEXT, 5, '0') AS id,
+					($2::NUMERIC[])[1 + FLOOR(random() * CARDINALITY($2::NUMERIC[]))::INT] AS asset_value,
+					($3::INT[])[1 + FLOOR(random() * CARDINALITY($3::INT[]))::INT] AS term_weeks,
+					($6::TEXT[])[1 + FLOOR(random() * CARDINALITY($6::TEXT[]))::INT] AS asset_type,
+					$5::TIMESTAMP - (random() * $4 * INTERVAL '1 day') AS deployment_date
+				FROM generate_series(1, $1) AS n
+			) s
+			LEFT JOIN assets a ON a.asset_type = s.asset_type
+			ON CONFLICT (customer_id) DO NOTHING
+			RETURNING customer_id
+		`, opts.Count, opts.AssetValues, opts.TermWeeks, opts.MaxAgeDays, now, opts.AssetTypes)
+		if err != nil {
+			return err
+		}
+		created, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+		result.Created = int64(len(created))
+
+		if opts.PaidPercent <= 0 || len(created) == 0 {
+			return nil
+		}
+
+		// The last installment settles the exact remainder so fully paid
+		// accounts close at zero.
+		tag, err := tx.Exec(ctx, `
+			WITH paying AS MATERIALIZED (
+				SELECT customer_id, asset_value, term_weeks, deployment_date,
+				       ROUND(asset_value / term_weeks, 2) AS installment
+				FROM customer_accounts
+				WHERE customer_id = ANY($1) AND random() * 100 < $2
+			),
+			seeded AS MATERIALIZED (
+				SELECT 'SEED-' || p.customer_id || '-' || w AS transaction_reference,
+				       p.customer_id,
+				       CASE WHEN w = p.term_weeks THEN p.asset_value - p.installment * (p.term_weeks - 1) ELSE p.installment END AS amount,
+				       LEAST($4::TIMESTAMP, p.deployment_date + w * INTERVAL '1 week' + random() * INTERVAL '2 days') AS processed_at
+				FROM paying p
+				CROSS JOIN LATERAL generate_series(1, LEAST(p.term_weeks,
+				    FLOOR(EXTRACT(EPOCH FROM $4::TIMESTAMP - p.deployment_date) / 604800)::INT)) AS w
+				WHERE random() < $3
+			),
+			claimed AS (
+				INSERT INTO processed_references (transaction_reference, processed_at)
+				SELECT transaction_reference, processed_at FROM seeded
+				ON CONFLICT (transaction_reference) DO NOTHING
+				RETURNING transaction_reference
+			)
+			INSERT INTO processed_transactions (transaction_reference, customer_id, amount, processed_at, metadata)
+			SELECT s.transaction_reference, s.customer_id, s.amount, s.processed_at, '{"source": "seed"}'::JSONB
+			FROM seeded s
+			JOIN claimed USING (transaction_reference)
+		`, created, opts.PaidPercent, opts.OnTimeRate, now)
+		if err != nil {
+			return err
+		}
+		result.Payments = tag.RowsAffected()
+
+		tag, err = tx.Exec(ctx, `
+			UPDATE customer_accounts a
+			SET total_paid = t.paid,
+			    outstanding_balance = GREATEST(0, a.asset_value - t.paid),
+			    payment_count = t.payments,
+			    last_payment_date = t.last_paid
+			FROM (
+				SELECT customer_id, SUM(amount) AS paid, COUNT(*) AS payments, MAX(processed_at) AS last_paid
+				FROM processed_transactions
+				WHERE customer_id = ANY($1)
+				GROUP BY customer_id
+			) t
+			WHERE a.customer_id = t.customer_id
+		`, created)
+		if err != nil {
+			return err
+		}
+		result.PaidAccounts = tag.RowsAffected()
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to seed customers: %v", err)
+		return nil, fmt.Errorf("failed to seed customers: %v", err)
 	}
 
-	rowsAffected := result.RowsAffected()
-	log.Printf("Successfully seeded %d customers", rowsAffected)
-	return nil
+	log.Printf("Successfully seeded %d customers (%d with %d payments)", result.Created, result.PaidAccounts, result.Payments)
+	return result, nil
 }
 
 func (db *DatabaseService) GetCustomerCount(ctx context.Context) (int, error) {
 	var count int
-	err := db.Pool.QueryRow(ctx, "SELECT COUNT(*) FROM customer_accounts").Scan(&count)
+	err := db.QueryRow(ctx, "SELECT COUNT(*) FROM customer_accounts").Scan(&count)
 	return count, err
 }
+
+// PaidAsOf is what an account had paid by a point in time.
+type PaidAsOf struct {
+	TotalPaid       float64
+	PaymentCount    int
+	LastPaymentDate *time.Time
+	// ArchivedPayments is how many of PaymentCount were counted from
+	// archive totals.
+	ArchivedPayments int
+	// Incomplete is set when the point in time falls inside an archive of
+	// the account's payments. An archive's totals can't be split, so its
+	// payments are left out.
+	Incomplete bool
+}
+
+// GetPaidAsOf sums the customer's payments effective by asOf, counting
+// archived payments from the archive totals.
+func (db *DatabaseService) GetPaidAsOf(ctx context.Context, customerID string, asOf time.Time) (*PaidAsOf, error) {
+	query := `
+		SELECT t.paid + a.paid, t.payments + a.payments, GREATEST(t.last_paid, a.last_paid), a.payments, a.incomplete
+		FROM (
+			SELECT COALESCE(SUM(amount), 0) AS paid, COUNT(*) AS payments, MAX(processed_at) AS last_paid
+			FROM processed_transactions
+			WHERE customer_id = $1 AND GREATEST(processed_at, value_date::TIMESTAMP) <= $2
+		) t, (
+			SELECT COALESCE(SUM(total_paid) FILTER (WHERE last_effective_at <= $2), 0) AS paid,
+			       COALESCE(SUM(payments) FILTER (WHERE last_effective_at <= $2), 0) AS payments,
+			       MAX(last_effective_at) FILTER (WHERE last_effective_at <= $2) AS last_paid,
+			       COALESCE(BOOL_OR(first_effective_at <= $2 AND last_effective_at > $2), FALSE) AS incomplete
+			FROM transaction_archive_customers
+			WHERE customer_id = $1
+		) a
+	`
+
+	var paid PaidAsOf
+	err := db.QueryRow(ctx, query, customerID, asOf).
+		Scan(&paid.TotalPaid, &paid.PaymentCount, &paid.LastPaymentDate, &paid.ArchivedPayments, &paid.Incomplete)
+	if err != nil {
+		return nil, err
+	}
+	return &paid, nil
+}
+
+type TransactionRecord struct {
+	TransactionReference string       `json:"transaction_reference"`
+	CustomerID           string       `json:"customer_id"`
+	Amount               float64      `json:"amount"`
+	ProcessedAt          time.Time    `json:"processed_at"`
+	Metadata             api.Metadata `json:"metadata,omitempty"`
+	// BalanceAfter is only filled in by GetTransaction, for payments with
+	// a history entry.
+	BalanceAfter *float64 `json:"balance_after,omitempty"`
+	// Archive is the archive file holding the payment, for payments
+	// GetTransaction found in the archive.
+	Archive *api.TransactionArchive `json:"archive,omitempty"`
+}
+
+// GetTransaction returns one of the customer's processed payments. Archived
+// payments are rebuilt from payment history; if it no longer has them, the
+// record only names the archive and the error is ErrTransactionArchived.
+func (db *DatabaseService) GetTransaction(ctx context.Context, customerID, reference string) (*TransactionRecord, error) {
+	query := `
+		SELECT t.transaction_reference, t.customer_id, t.amount, t.processed_at, t.metadata, h.balance_after
+		FROM processed_transactions t
+		LEFT JOIN payment_history h ON h.transaction_reference = t.transaction_reference
+		WHERE t.customer_id = $1 AND t.transaction_reference = $2
+	`
+
+	var txn TransactionRecord
+	err := db.QueryRow(ctx, query, customerID, reference).
+		Scan(&txn.TransactionReference, &txn.CustomerID, &txn.Amount, &txn.ProcessedAt, &txn.Metadata, &txn.BalanceAfter)
+	if errors.Is(err, ErrNotFound) {
+		return db.archivedTransaction(ctx, customerID, reference)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+func (db *DatabaseService) GetRecentTransactions(ctx context.Context, customerID string, limit int) ([]TransactionRecord, error) {
+	query := `
+		SELECT transaction_reference, customer_id, amount, processed_at, metadata
+		FROM processed_transactions
+		WHERE customer_id = $1
+		ORDER BY processed_at DESC
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []TransactionRecord{}
+	for rows.Next() {
+		var txn TransactionRecord
+		if err := rows.Scan(&txn.TransactionReference, &txn.CustomerID, &txn.Amount, &txn.ProcessedAt, &txn.Metadata); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, txn)
+	}
+	return transactions, rows.Err()
+}
+
+// GetPaymentEvents returns the payments applied to an account, oldest
+// first. Recoveries on written-off accounts are left out.
+func (db *DatabaseService) GetPaymentEvents(ctx context.Context, customerID string) ([]api.PaymentEvent, error) {
+	query := `
+		SELECT GREATEST(processed_at, value_date::TIMESTAMP) AS effective_at, amount
+		FROM processed_transactions
+		WHERE customer_id = $1 AND NOT recovery
+		ORDER BY effective_at
+	`
+
+	rows, err := db.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []api.PaymentEvent{}
+	for rows.Next() {
+		var event api.PaymentEvent
+		if err := rows.Scan(&event.Date, &event.Amount); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,273 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrDisputeExists is returned when opening a dispute on a payment that
+	// already has an unresolved one.
+	ErrDisputeExists = errors.New("payment already has an unresolved dispute")
+	// ErrDisputeResolved is returned when updating a resolved dispute.
+	ErrDisputeResolved = errors.New("dispute is already resolved")
+	// ErrDisputedPaymentUnavailable is returned when reversing a payment that
+	// has been archived or already reversed.
+	ErrDisputedPaymentUnavailable = errors.New("disputed payment is archived or already reversed")
+)
+
+const disputeColumns = `
+	id, customer_id, transaction_reference, amount, reason, opened_by, status, COALESCE(assigned_to, ''),
+	COALESCE(resolution_action, ''), adjustment_amount, COALESCE(adjustment_reference, ''),
+	COALESCE(resolved_by, ''), COALESCE(resolution_note, ''), opened_at, investigating_at, resolved_at
+`
+
+func scanDispute(row pgx.Row) (*api.Dispute, error) {
+	var d api.Dispute
+	err := row.Scan(
+		&d.ID,
+		&d.CustomerID,
+		&d.TransactionReference,
+		&d.Amount,
+		&d.Reason,
+		&d.OpenedBy,
+		&d.Status,
+		&d.AssignedTo,
+		&d.ResolutionAction,
+		&d.AdjustmentAmount,
+		&d.AdjustmentReference,
+		&d.ResolvedBy,
+		&d.ResolutionNote,
+		&d.OpenedAt,
+		&d.InvestigatingAt,
+		&d.ResolvedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// OpenDispute opens a dispute on the customer's payment of amount.
+func (db *DatabaseService) OpenDispute(ctx context.Context, customerID, reference string, amount float64, request api.DisputeRequest) (*api.Dispute, error) {
+	query := `
+		INSERT INTO disputes (customer_id, transaction_reference, amount, reason, opened_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (transaction_reference) WHERE resolved_at IS NULL DO NOTHING
+		RETURNING ` + disputeColumns
+
+	dispute, err := scanDispute(db.QueryRow(ctx, query, customerID, reference, amount, request.Reason, request.OpenedBy))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrDisputeExists
+	}
+	return dispute, err
+}
+
+func (db *DatabaseService) GetDispute(ctx context.Context, id int64) (*api.Dispute, error) {
+	query := `SELECT ` + disputeColumns + ` FROM disputes WHERE id = $1`
+	return scanDispute(db.QueryRow(ctx, query, id))
+}
+
+// ListDisputes returns disputes, oldest first, optionally only those with
+// status or those of one customer.
+func (db *DatabaseService) ListDisputes(ctx context.Context, status, customerID string, limit int) ([]*api.Dispute, error) {
+	query := `
+		SELECT ` + disputeColumns + `
+		FROM disputes
+		WHERE ($1 = '' OR status = $1)
+		  AND ($2 = '' OR customer_id = $2)
+		ORDER BY opened_at, id
+		LIMIT $3
+	`
+
+	rows, err := db.Query(ctx, query, status, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disputes := []*api.Dispute{}
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, dispute)
+	}
+	return disputes, rows.Err()
+}
+
+// InvestigateDispute moves the dispute under investigation by assignedTo,
+// or hands an investigation over to them.
+func (db *DatabaseService) InvestigateDispute(ctx context.Context, id int64, assignedTo string) (*api.Dispute, error) {
+	query := `
+		UPDATE disputes
+		SET status = 'INVESTIGATING',
+		    assigned_to = $2,
+		    investigating_at = COALESCE(investigating_at, NOW())
+		WHERE id = $1 AND resolved_at IS NULL
+		RETURNING ` + disputeColumns
+
+	dispute, err := scanDispute(db.QueryRow(ctx, query, id, assignedTo))
+	if !errors.Is(err, ErrNotFound) {
+		return dispute, err
+	}
+	if _, getErr := db.GetDispute(ctx, id); getErr != nil {
+		return nil, getErr
+	}
+	return nil, ErrDisputeResolved
+}
+
+// ResolveDispute closes the dispute and applies its resolution action in
+// one transaction. A reversal moves the payment to reversed_transactions
+// and takes it off the account, returning it; its reference stays
+// processed, so the payment can't come in again. If the payment's
+// settlement day is closed, a dated adjustment of minus its amount is
+// posted instead and recorded on the dispute. An adjustment is only
+// recorded here, with the reference AdjustmentPayment queues it under.
+func (db *DatabaseService) ResolveDispute(ctx context.Context, id int64, resolution api.DisputeResolution) (*api.Dispute, *TransactionRecord, error) {
+	var dispute *api.Dispute
+	var reversed *TransactionRecord
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		current, err := scanDispute(tx.QueryRow(ctx, `SELECT `+disputeColumns+` FROM disputes WHERE id = $1 FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+		if current.ResolvedAt != nil {
+			return ErrDisputeResolved
+		}
+
+		var adjustmentAmount *float64
+		adjustmentReference := ""
+		switch resolution.Action {
+		case api.DisputeActionReversal:
+			settled, err := settledPayments(ctx, tx, "t.customer_id = $1 AND t.transaction_reference = $2",
+				current.CustomerID, current.TransactionReference)
+			if err != nil {
+				return err
+			}
+			if settled {
+				// The payment's day is closed, so it stays and a dated
+				// adjustment takes it back off the account.
+				posted, err := postAdjustment(ctx, tx, &api.SettlementAdjustment{
+					CustomerID:        current.CustomerID,
+					Amount:            -current.Amount,
+					Reason:            fmt.Sprintf("Reversal of disputed payment (dispute %d)", id),
+					CorrectsReference: current.TransactionReference,
+					CreatedBy:         resolution.ResolvedBy,
+				})
+				if err != nil {
+					return err
+				}
+				adjustmentAmount = &posted.Amount
+				adjustmentReference = posted.Reference
+				break
+			}
+
+			var txn TransactionRecord
+			err = tx.QueryRow(ctx, `
+				WITH removed AS (
+					DELETE FROM processed_transactions
+					WHERE customer_id = $2 AND transaction_reference = $3
+					RETURNING transaction_reference, customer_id, amount, processed_at, metadata, agent_id, recovery, value_date
+				)
+				INSERT INTO reversed_transactions (dispute_id, transaction_reference, customer_id, amount, processed_at, metadata, agent_id, recovery, value_date)
+				SELECT $1, transaction_reference, customer_id, amount, processed_at, metadata, agent_id, recovery, value_date
+				FROM removed
+				RETURNING transaction_reference, customer_id, amount, processed_at, metadata
+			`, id, current.CustomerID, current.TransactionReference).
+				Scan(&txn.TransactionReference, &txn.CustomerID, &txn.Amount, &txn.ProcessedAt, &txn.Metadata)
+			if errors.Is(err, ErrNotFound) {
+				return ErrDisputedPaymentUnavailable
+			}
+			if err != nil {
+				return err
+			}
+			if err := removeReversedPayments(ctx, tx, "dispute_id", id); err != nil {
+				return err
+			}
+			reversed = &txn
+		case api.DisputeActionAdjustment:
+			adjustmentAmount = &resolution.AdjustmentAmount
+			adjustmentReference = fmt.Sprintf("DSP-%d-ADJ", id)
+		}
+
+		dispute, err = scanDispute(tx.QueryRow(ctx, `
+			UPDATE disputes
+			SET status = $2,
+			    resolution_action = $3,
+			    adjustment_amount = $4,
+			    adjustment_reference = NULLIF($5, ''),
+			    resolved_by = $6,
+			    resolution_note = $7,
+			    resolved_at = NOW()
+			WHERE id = $1
+			RETURNING `+disputeColumns,
+			id, resolution.Status, resolution.Action, adjustmentAmount, adjustmentReference, resolution.ResolvedBy, resolution.Note))
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return dispute, reversed, nil
+}
+
+// AdjustmentPayment is the payment crediting a dispute's adjustment to the
+// account. Its reference is fixed per dispute, so queueing it again is
+// harmless.
+func AdjustmentPayment(dispute *api.Dispute, now time.Time) *api.PaymentPayload {
+	return &api.PaymentPayload{
+		CustomerID:           dispute.CustomerID,
+		PaymentStatus:        api.StatusComplete,
+		TransactionAmount:    fmt.Sprintf("%.2f", *dispute.AdjustmentAmount),
+		TransactionDate:      now.Format("2006-01-02 15:04:05"),
+		TransactionReference: dispute.AdjustmentReference,
+		Channel:              "adjustment",
+		Metadata: api.Metadata{
+			api.MetadataDisputeID: dispute.ID,
+			"disputed_reference":  dispute.TransactionReference,
+		},
+	}
+}
+
+// DisputeAging buckets unresolved disputes by the days they have been
+// open as of asOf, youngest bucket first; empty buckets are included.
+func (db *DatabaseService) DisputeAging(ctx context.Context, asOf time.Time) ([]api.DisputeAgingBucket, error) {
+	query := `
+		WITH buckets (bucket, min_days, max_days) AS (
+			VALUES ('0-7', 0, 7), ('8-30', 8, 30), ('31-60', 31, 60), ('61-90', 61, 90), ('over-90', 91, NULL)
+		)
+		SELECT b.bucket,
+		       COUNT(d.id) FILTER (WHERE d.status = 'OPEN'),
+		       COUNT(d.id) FILTER (WHERE d.status = 'INVESTIGATING'),
+		       COALESCE(SUM(d.amount), 0)
+		FROM buckets b
+		LEFT JOIN disputes d
+		  ON d.resolved_at IS NULL
+		 AND $1::DATE - d.opened_at::DATE >= b.min_days
+		 AND (b.max_days IS NULL OR $1::DATE - d.opened_at::DATE <= b.max_days)
+		GROUP BY b.bucket, b.min_days
+		ORDER BY b.min_days
+	`
+
+	rows, err := db.Query(ctx, query, asOf)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []api.DisputeAgingBucket{}
+	for rows.Next() {
+		var b api.DisputeAgingBucket
+		if err := rows.Scan(&b.Bucket, &b.Open, &b.Investigating, &b.Amount); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const dunningCaseColumns = `customer_id, status, failed_attempts, COALESCE(charge_reference, ''),
+	COALESCE(last_failure_reason, ''), next_retry_at, delinquent_after, started_at, last_failure_at,
+	delinquent_at, resolved_at, updated_at`
+
+func scanDunningCase(row pgx.Row) (*api.DunningCase, error) {
+	var dunning api.DunningCase
+	err := row.Scan(
+		&dunning.CustomerID,
+		&dunning.Status,
+		&dunning.FailedAttempts,
+		&dunning.ChargeReference,
+		&dunning.LastFailureReason,
+		&dunning.NextRetryAt,
+		&dunning.DelinquentAfter,
+		&dunning.StartedAt,
+		&dunning.LastFailureAt,
+		&dunning.DelinquentAt,
+		&dunning.ResolvedAt,
+		&dunning.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &dunning, nil
+}
+
+func (db *DatabaseService) GetDunningCase(ctx context.Context, customerID string) (*api.DunningCase, error) {
+	return scanDunningCase(db.QueryRow(ctx, `SELECT `+dunningCaseColumns+` FROM dunning_cases WHERE customer_id = $1`, customerID))
+}
+
+// SaveDunningCase writes the account's dunning case as it stands.
+func (db *DatabaseService) SaveDunningCase(ctx context.Context, dunning *api.DunningCase) error {
+	query := `
+		INSERT INTO dunning_cases (customer_id, status, failed_attempts, charge_reference, last_failure_reason,
+		                           next_retry_at, delinquent_after, started_at, last_failure_at, delinquent_at, resolved_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (customer_id) DO UPDATE
+		SET status = EXCLUDED.status,
+		    failed_attempts = EXCLUDED.failed_attempts,
+		    charge_reference = EXCLUDED.charge_reference,
+		    last_failure_reason = EXCLUDED.last_failure_reason,
+		    next_retry_at = EXCLUDED.next_retry_at,
+		    delinquent_after = EXCLUDED.delinquent_after,
+		    started_at = EXCLUDED.started_at,
+		    last_failure_at = EXCLUDED.last_failure_at,
+		    delinquent_at = EXCLUDED.delinquent_at,
+		    resolved_at = EXCLUDED.resolved_at,
+		    updated_at = NOW()
+		RETURNING updated_at
+	`
+
+	return db.QueryRow(ctx, query, dunning.CustomerID, dunning.Status, dunning.FailedAttempts, dunning.ChargeReference,
+		dunning.LastFailureReason, dunning.NextRetryAt, dunning.DelinquentAfter, dunning.StartedAt, dunning.LastFailureAt,
+		dunning.DelinquentAt, dunning.ResolvedAt).Scan(&dunning.UpdatedAt)
+}
+
+// ListDunningCases lists cases, most recently changed first, optionally
+// narrowed to a status.
+func (db *DatabaseService) ListDunningCases(ctx context.Context, status string, limit, offset int) ([]*api.DunningCase, error) {
+	query := `
+		SELECT ` + dunningCaseColumns + ` FROM dunning_cases
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY updated_at DESC, customer_id
+		LIMIT $2 OFFSET $3
+	`
+
+	return db.queryDunningCases(ctx, query, status, limit, offset)
+}
+
+// OpenDunningCases lists up to limit unresolved cases after the given
+// customer ID, for walking them all in batches.
+func (db *DatabaseService) OpenDunningCases(ctx context.Context, after string, limit int) ([]*api.DunningCase, error) {
+	query := `
+		SELECT ` + dunningCaseColumns + ` FROM dunning_cases
+		WHERE status <> 'RESOLVED' AND customer_id > $1
+		ORDER BY customer_id
+		LIMIT $2
+	`
+
+	return db.queryDunningCases(ctx, query, after, limit)
+}
+
+func (db *DatabaseService) queryDunningCases(ctx context.Context, query string, args ...interface{}) ([]*api.DunningCase, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cases := []*api.DunningCase{}
+	for rows.Next() {
+		dunning, err := scanDunningCase(rows)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, dunning)
+	}
+	return cases, rows.Err()
+}
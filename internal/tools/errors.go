@@ -0,0 +1,102 @@
+package tools
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Domain errors returned by DatabaseService and RedisService, for callers to
+// test with errors.Is. The more specific errors, such as ErrRuleNameTaken,
+// match one of them as well as themselves.
+var (
+	// ErrNotFound is a lookup that found nothing. Rows missing from
+	// Postgres still match pgx.ErrNoRows too, and keys missing from Redis
+	// redis.Nil.
+	ErrNotFound = errors.New("not found")
+	// ErrVersionConflict is an update made against a version that has
+	// since changed.
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrDuplicate is a create that collided with an existing record.
+	ErrDuplicate = errors.New("already exists")
+)
+
+// kindError is a specific domain error that also matches the general one it
+// is a kind of.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string        { return e.msg }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+func duplicateError(msg string) error {
+	return &kindError{msg: msg, kind: ErrDuplicate}
+}
+
+// driverError is a pgx or Redis error on its way out of this package, made
+// to match the domain error it amounts to. It keeps the driver's message
+// and still matches the driver error, which callers have long tested for.
+type driverError struct {
+	err  error
+	kind error
+}
+
+func (e *driverError) Error() string        { return e.err.Error() }
+func (e *driverError) Unwrap() error        { return e.err }
+func (e *driverError) Is(target error) bool { return target == e.kind }
+
+// errNoRows is pgx.ErrNoRows as this package returns it, for lookups that
+// come up empty without a query saying so.
+var errNoRows error = &driverError{err: pgx.ErrNoRows, kind: ErrNotFound}
+
+// domainError classifies a driver error: missing rows and keys are
+// ErrNotFound and unique violations ErrDuplicate. Other errors are returned
+// as they are.
+func domainError(err error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case err == nil, errors.Is(err, ErrNotFound), errors.Is(err, ErrDuplicate):
+		return err
+	case errors.Is(err, pgx.ErrNoRows), errors.Is(err, redis.Nil):
+		return &driverError{err: err, kind: ErrNotFound}
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		return &driverError{err: err, kind: ErrDuplicate}
+	}
+	return err
+}
+
+// domainErrorHook classifies the errors of the commands run through
+// RedisService.Client, as DatabaseService's queries are: a missing key is
+// ErrNotFound, and still redis.Nil.
+type domainErrorHook struct{}
+
+func (domainErrorHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (domainErrorHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if err := cmd.Err(); err != nil {
+		return domainError(err)
+	}
+	return nil
+}
+
+func (domainErrorHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+// AfterProcessPipeline classifies each command's error on its own; an
+// error returned here would be set on all of them.
+func (domainErrorHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			cmd.SetErr(domainError(err))
+		}
+	}
+	return nil
+}
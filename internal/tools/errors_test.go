@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestDomainError(t *testing.T) {
+	unique := &pgconn.PgError{Code: "23505"}
+	other := errors.New("connection refused")
+
+	tests := []struct {
+		err   error
+		match []error
+		miss  []error
+	}{
+		{pgx.ErrNoRows, []error{ErrNotFound, pgx.ErrNoRows}, []error{ErrDuplicate}},
+		{fmt.Errorf("load: %w", pgx.ErrNoRows), []error{ErrNotFound, pgx.ErrNoRows}, nil},
+		{redis.Nil, []error{ErrNotFound, redis.Nil}, nil},
+		{unique, []error{ErrDuplicate}, []error{ErrNotFound}},
+		{other, []error{other}, []error{ErrNotFound, ErrDuplicate, ErrVersionConflict}},
+	}
+
+	for _, tt := range tests {
+		err := domainError(tt.err)
+		if err.Error() != tt.err.Error() {
+			t.Errorf("domainError(%v) changed the message to %q", tt.err, err)
+		}
+		for _, target := range tt.match {
+			if !errors.Is(err, target) {
+				t.Errorf("domainError(%v) doesn't match %v", tt.err, target)
+			}
+		}
+		for _, target := range tt.miss {
+			if errors.Is(err, target) {
+				t.Errorf("domainError(%v) matches %v", tt.err, target)
+			}
+		}
+	}
+
+	if domainError(nil) != nil {
+		t.Error("domainError(nil) != nil")
+	}
+	if err := domainError(pgx.ErrNoRows); domainError(err) != err {
+		t.Error("domainError wrapped an already classified error again")
+	}
+}
+
+func TestKindErrors(t *testing.T) {
+	if !errors.Is(ErrRuleNameTaken, ErrDuplicate) || !errors.Is(ErrCardTokenExists, ErrDuplicate) {
+		t.Error("specific duplicate errors don't match ErrDuplicate")
+	}
+	if errors.Is(ErrRuleNameTaken, ErrCardTokenExists) {
+		t.Error("ErrRuleNameTaken matches ErrCardTokenExists")
+	}
+	if !errors.Is(fmt.Errorf("save: %w", ErrRuleNameTaken), ErrRuleNameTaken) {
+		t.Error("wrapped ErrRuleNameTaken doesn't match itself")
+	}
+}
+
+func TestDomainErrorHook(t *testing.T) {
+	ctx := context.Background()
+	hook := domainErrorHook{}
+
+	cmd := redis.NewStringCmd(ctx, "get", "missing")
+	cmd.SetErr(redis.Nil)
+	if err := hook.AfterProcess(ctx, cmd); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AfterProcess returned %v, want ErrNotFound", err)
+	}
+
+	found := redis.NewStringCmd(ctx, "get", "present")
+	missing := redis.NewStringCmd(ctx, "get", "missing")
+	missing.SetErr(redis.Nil)
+	if err := hook.AfterProcessPipeline(ctx, []redis.Cmder{found, missing}); err != nil {
+		t.Errorf("AfterProcessPipeline returned %v", err)
+	}
+	if found.Err() != nil || !errors.Is(missing.Err(), ErrNotFound) || !errors.Is(missing.Err(), redis.Nil) {
+		t.Errorf("pipeline errors: %v, %v", found.Err(), missing.Err())
+	}
+}
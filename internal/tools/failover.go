@@ -0,0 +1,152 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+// minReconnectInterval stops a burst of failing queries from resetting the
+// pool over and over while the new primary is being promoted.
+const minReconnectInterval = time.Second
+
+// failoverCodes are the SQLSTATEs a connection gets when its server stops
+// being the primary or shuts down under it.
+var failoverCodes = map[string]bool{
+	"25006": true, // read_only_sql_transaction: the server was demoted
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"08000": true, // connection_exception
+	"08003": true, // connection_does_not_exist
+	"08006": true, // connection_failure
+}
+
+// DBConnectionState describes the pool and the failovers it has been
+// through.
+type DBConnectionState struct {
+	Host                string     `json:"host,omitempty"`
+	TotalConns          int32      `json:"total_conns"`
+	IdleConns           int32      `json:"idle_conns"`
+	AcquiredConns       int32      `json:"acquired_conns"`
+	ConstructingConns   int32      `json:"constructing_conns"`
+	Reconnects          int64      `json:"reconnects"`
+	LastReconnectAt     *time.Time `json:"last_reconnect_at,omitempty"`
+	LastReconnectReason string     `json:"last_reconnect_reason,omitempty"`
+}
+
+type reconnectState struct {
+	mu     sync.Mutex
+	count  int64
+	at     time.Time
+	reason string
+}
+
+// targetSessionAttrs returns the connect check for a
+// target_session_attrs value.
+func targetSessionAttrs(attrs string) (pgconn.ValidateConnectFunc, error) {
+	switch attrs {
+	case "", "any":
+		return nil, nil
+	case "read-write":
+		return pgconn.ValidateConnectTargetSessionAttrsReadWrite, nil
+	case "primary":
+		return pgconn.ValidateConnectTargetSessionAttrsPrimary, nil
+	}
+	return nil, fmt.Errorf("unsupported DB_TARGET_SESSION_ATTRS %q", attrs)
+}
+
+// isFailover reports whether err means the connection's server went away or
+// stopped taking writes. Timeouts don't count: a slow query isn't a reason
+// to drop every connection.
+func isFailover(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return failoverCodes[pgErr.Code]
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	return errors.As(err, &netErr) && !netErr.Timeout()
+}
+
+// handleFailover resets the pool if err shows the connection it used is on a
+// server that is no longer the primary, and reports whether the statement
+// can be retried: it never reached the server or was rejected unexecuted.
+func (db *DatabaseService) handleFailover(ctx context.Context, err error) bool {
+	if err == nil || !db.failoverRetry || !isFailover(err) || ctx.Err() != nil {
+		return false
+	}
+	db.Reconnect(err.Error())
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "25006" || pgErr.Code == "57P03"
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// Reconnect closes every pooled connection so the next queries connect
+// afresh, to whichever host is now the primary. Connections in use are
+// closed when they are released.
+func (db *DatabaseService) Reconnect(reason string) {
+	db.reconnects.mu.Lock()
+	now := time.Now()
+	if now.Sub(db.reconnects.at) < minReconnectInterval {
+		db.reconnects.mu.Unlock()
+		return
+	}
+	db.reconnects.count++
+	db.reconnects.at = now
+	db.reconnects.reason = reason
+	db.reconnects.mu.Unlock()
+
+	log.Warnf("Resetting database connections: %s", reason)
+	DefaultMetrics.Inc("db_reconnects_total", 1)
+	db.Pool.Reset()
+}
+
+// ConnectionState reports the pool's connections and reconnects, and the
+// host it is connected to if one answers within ctx.
+func (db *DatabaseService) ConnectionState(ctx context.Context) *DBConnectionState {
+	stat := db.Pool.Stat()
+	state := &DBConnectionState{
+		TotalConns:        stat.TotalConns(),
+		IdleConns:         stat.IdleConns(),
+		AcquiredConns:     stat.AcquiredConns(),
+		ConstructingConns: stat.ConstructingConns(),
+	}
+
+	db.reconnects.mu.Lock()
+	state.Reconnects = db.reconnects.count
+	if !db.reconnects.at.IsZero() {
+		at := db.reconnects.at
+		state.LastReconnectAt = &at
+		state.LastReconnectReason = db.reconnects.reason
+	}
+	db.reconnects.mu.Unlock()
+
+	if conn, err := db.Pool.Acquire(ctx); err == nil {
+		state.Host = conn.Conn().PgConn().Conn().RemoteAddr().String()
+		conn.Release()
+	}
+	return state
+}
+
+// RecordPoolMetrics sets the connection pool gauges on m.
+func (db *DatabaseService) RecordPoolMetrics(m *Metrics) {
+	stat := db.Pool.Stat()
+	m.Set("db_pool_total_conns", float64(stat.TotalConns()))
+	m.Set("db_pool_idle_conns", float64(stat.IdleConns()))
+	m.Set("db_pool_acquired_conns", float64(stat.AcquiredConns()))
+	m.Set("db_pool_constructing_conns", float64(stat.ConstructingConns()))
+	m.Set("db_pool_empty_acquire_count", float64(stat.EmptyAcquireCount()))
+}
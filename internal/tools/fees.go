@@ -0,0 +1,204 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrFeeTypeInactive is returned when charging a fee type that is
+	// unknown or no longer active.
+	ErrFeeTypeInactive = errors.New("fee type is unknown or inactive")
+	// ErrFeeNotChargeable is returned when charging a fee to a written-off
+	// account.
+	ErrFeeNotChargeable = errors.New("account is written off")
+)
+
+const feeTypeColumns = `fee_type, name, tax_code, tax_rate, tax_inclusive, active, created_at, updated_at`
+
+func scanFeeType(row pgx.Row) (*api.FeeType, error) {
+	var f api.FeeType
+	err := row.Scan(&f.FeeType, &f.Name, &f.TaxCode, &f.TaxRate, &f.TaxInclusive, &f.Active, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (db *DatabaseService) SaveFeeType(ctx context.Context, feeType *api.FeeType) (*api.FeeType, error) {
+	query := `
+		INSERT INTO fee_types (fee_type, name, tax_code, tax_rate, tax_inclusive, active)
+		VALUES ($1, $2, COALESCE(NULLIF($3, ''), 'VAT'), $4, $5, $6)
+		ON CONFLICT (fee_type) DO UPDATE
+		SET name = EXCLUDED.name,
+		    tax_code = EXCLUDED.tax_code,
+		    tax_rate = EXCLUDED.tax_rate,
+		    tax_inclusive = EXCLUDED.tax_inclusive,
+		    active = EXCLUDED.active,
+		    updated_at = NOW()
+		RETURNING ` + feeTypeColumns
+
+	return scanFeeType(db.QueryRow(ctx, query, feeType.FeeType, feeType.Name, feeType.TaxCode, feeType.TaxRate,
+		feeType.TaxInclusive, feeType.Active))
+}
+
+func (db *DatabaseService) ListFeeTypes(ctx context.Context, activeOnly bool) ([]*api.FeeType, error) {
+	query := `
+		SELECT ` + feeTypeColumns + ` FROM fee_types
+		WHERE active OR NOT $1
+		ORDER BY fee_type
+	`
+
+	rows, err := db.Query(ctx, query, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeTypes := []*api.FeeType{}
+	for rows.Next() {
+		feeType, err := scanFeeType(rows)
+		if err != nil {
+			return nil, err
+		}
+		feeTypes = append(feeTypes, feeType)
+	}
+	return feeTypes, rows.Err()
+}
+
+// ComputeFeeTax splits a fee into its net amount and tax at rate. An
+// inclusive amount already contains the tax; otherwise the tax is added to
+// it. Both are rounded to the cent, and always sum to the gross amount.
+func ComputeFeeTax(amount, rate float64, inclusive bool) (net, tax float64) {
+	cents := math.Round(amount * 100)
+	if inclusive {
+		netCents := math.Round(cents / (1 + rate))
+		return netCents / 100, (cents - netCents) / 100
+	}
+	return cents / 100, math.Round(cents*rate) / 100
+}
+
+const feeChargeColumns = `
+	id, reference, customer_id, fee_type, net_amount, tax_code, tax_rate, tax_amount, gross_amount,
+	COALESCE(description, ''), charged_by, charged_at
+`
+
+func scanFeeCharge(row pgx.Row) (*api.FeeCharge, error) {
+	var f api.FeeCharge
+	err := row.Scan(&f.ID, &f.Reference, &f.CustomerID, &f.FeeType, &f.NetAmount, &f.TaxCode, &f.TaxRate,
+		&f.TaxAmount, &f.GrossAmount, &f.Description, &f.ChargedBy, &f.ChargedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ChargeFee records the fee, referenced FEE-<id>, with its tax under the
+// fee type's current configuration and adds the gross amount to the
+// account's outstanding balance.
+func (db *DatabaseService) ChargeFee(ctx context.Context, customerID string, request api.FeeChargeRequest) (*api.FeeCharge, error) {
+	var charge *api.FeeCharge
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		feeType, err := scanFeeType(tx.QueryRow(ctx,
+			`SELECT `+feeTypeColumns+` FROM fee_types WHERE fee_type = $1 AND active FOR SHARE`, request.FeeType))
+		if errors.Is(err, ErrNotFound) {
+			return ErrFeeTypeInactive
+		}
+		if err != nil {
+			return err
+		}
+
+		net, tax := ComputeFeeTax(request.Amount, feeType.TaxRate, feeType.TaxInclusive)
+		charge, err = scanFeeCharge(tx.QueryRow(ctx, `
+			WITH next AS (SELECT nextval(pg_get_serial_sequence('fee_charges', 'id')) AS id)
+			INSERT INTO fee_charges (id, reference, customer_id, fee_type, net_amount, tax_code, tax_rate, tax_amount,
+			                         gross_amount, description, charged_by)
+			SELECT id, 'FEE-' || id, $1, $2, $3, $4, $5, $6, $3::DECIMAL + $6::DECIMAL, NULLIF($7, ''), $8
+			FROM next
+			RETURNING `+feeChargeColumns,
+			customerID, feeType.FeeType, net, feeType.TaxCode, feeType.TaxRate, tax, request.Description, request.ChargedBy))
+		if err != nil {
+			return err
+		}
+
+		tag, err := tx.Exec(ctx, `
+			UPDATE customer_accounts
+			SET outstanding_balance = outstanding_balance + $2,
+			    version = version + 1,
+			    updated_at = NOW()
+			WHERE customer_id = $1 AND written_off_at IS NULL
+		`, customerID, charge.GrossAmount)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrFeeNotChargeable
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return charge, nil
+}
+
+// ListFeeCharges returns the account's fees, latest first.
+func (db *DatabaseService) ListFeeCharges(ctx context.Context, customerID string, limit int) ([]*api.FeeCharge, error) {
+	query := `
+		SELECT ` + feeChargeColumns + `
+		FROM fee_charges
+		WHERE customer_id = $1
+		ORDER BY charged_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	charges := []*api.FeeCharge{}
+	for rows.Next() {
+		charge, err := scanFeeCharge(rows)
+		if err != nil {
+			return nil, err
+		}
+		charges = append(charges, charge)
+	}
+	return charges, rows.Err()
+}
+
+// GetFeeTaxReport totals fees charged from up to to by day, fee type and
+// tax code.
+func (db *DatabaseService) GetFeeTaxReport(ctx context.Context, from, to time.Time) ([]api.FeeTaxSummary, error) {
+	query := `
+		SELECT to_char(charged_at::DATE, 'YYYY-MM-DD'), fee_type, tax_code, COUNT(*),
+		       SUM(net_amount), SUM(tax_amount), SUM(gross_amount)
+		FROM fee_charges
+		WHERE charged_at >= $1 AND charged_at < $2
+		GROUP BY charged_at::DATE, fee_type, tax_code
+		ORDER BY charged_at::DATE, fee_type, tax_code
+	`
+
+	rows, err := db.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []api.FeeTaxSummary{}
+	for rows.Next() {
+		var s api.FeeTaxSummary
+		if err := rows.Scan(&s.Date, &s.FeeType, &s.TaxCode, &s.Charges, &s.NetAmount, &s.TaxAmount, &s.GrossAmount); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
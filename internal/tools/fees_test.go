@@ -0,0 +1,26 @@
+package tools
+
+import "testing"
+
+func TestComputeFeeTax(t *testing.T) {
+	tests := []struct {
+		amount    float64
+		rate      float64
+		inclusive bool
+		net, tax  float64
+	}{
+		{1000, 0.075, false, 1000, 75},
+		{1075, 0.075, true, 1000, 75},
+		{99.99, 0.075, false, 99.99, 7.5},
+		{100, 0.075, true, 93.02, 6.98},
+		{250, 0, false, 250, 0},
+		{250, 0, true, 250, 0},
+	}
+
+	for _, tt := range tests {
+		net, tax := ComputeFeeTax(tt.amount, tt.rate, tt.inclusive)
+		if net != tt.net || tax != tt.tax {
+			t.Errorf("ComputeFeeTax(%v, %v, %v) = %v, %v; want %v, %v", tt.amount, tt.rate, tt.inclusive, net, tax, tt.net, tt.tax)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+const featureFlagsKey = "feature_flags"
+
+// LoadFlags returns the flags set through the admin API.
+func (r *RedisService) LoadFlags(ctx context.Context) ([]api.FeatureFlag, error) {
+	values, err := r.Client.HGetAll(ctx, r.Key(featureFlagsKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make([]api.FeatureFlag, 0, len(values))
+	for _, value := range values {
+		var flag api.FeatureFlag
+		if err := json.Unmarshal([]byte(value), &flag); err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+func (r *RedisService) SaveFlag(ctx context.Context, flag *api.FeatureFlag) error {
+	data, err := json.Marshal(flag)
+	if err != nil {
+		return err
+	}
+	return r.Client.HSet(ctx, r.Key(featureFlagsKey), flag.Name, data).Err()
+}
+
+// DeleteFlag removes an override; the flag falls back to its configured
+// default, if any.
+func (r *RedisService) DeleteFlag(ctx context.Context, name string) (bool, error) {
+	removed, err := r.Client.HDel(ctx, r.Key(featureFlagsKey), name).Result()
+	return removed > 0, err
+}
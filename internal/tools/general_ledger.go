@@ -0,0 +1,380 @@
+package tools
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// GL roles are the accounts the journal posts to, mapped to the accounting
+// system's own account codes or names by GLAccounts.
+const (
+	GLCash            = "cash"
+	GLLoansReceivable = "loans_receivable"
+	GLRecoveries      = "recoveries"
+	GLAdjustments     = "adjustments"
+	GLBadDebt         = "bad_debt"
+	GLCommissions     = "commissions"
+	GLFeeIncome       = "fee_income"
+	GLTaxPayable      = "tax_payable"
+)
+
+const glDefaultAccounts = "cash=1000,loans_receivable=1200,tax_payable=2300,recoveries=4100,fee_income=4200,adjustments=4900,bad_debt=6100,commissions=6200"
+
+// Journal export formats.
+const (
+	GLFormatCSV        = "csv"
+	GLFormatQuickBooks = "quickbooks"
+	GLFormatSage       = "sage"
+)
+
+// glEntryAccounts gives the debit and credit role of each kind of entry. A
+// kind whose day nets negative posts the other way round.
+var glEntryAccounts = map[string][2]string{
+	"payment":    {GLCash, GLLoansReceivable},
+	"recovery":   {GLCash, GLRecoveries},
+	"adjustment": {GLAdjustments, GLLoansReceivable},
+	"reversal":   {GLLoansReceivable, GLCash},
+	"write_off":  {GLBadDebt, GLLoansReceivable},
+	"refund":     {GLLoansReceivable, GLCash},
+	"commission": {GLCommissions, GLCash},
+	"fee":        {GLLoansReceivable, GLFeeIncome},
+	"fee_tax":    {GLLoansReceivable, GLTaxPayable},
+}
+
+var glEntryMemos = map[string]string{
+	"payment":    "Customer payments",
+	"recovery":   "Recoveries on written-off accounts",
+	"adjustment": "Account adjustments",
+	"reversal":   "Reversed payments",
+	"write_off":  "Balances written off",
+	"refund":     "Refunds and withdrawals paid out",
+	"commission": "Agent commissions paid out",
+	"fee":        "Fees charged, net of tax",
+	"fee_tax":    "Tax on fees charged",
+}
+
+// GLAccounts maps GL roles to the accounting system's accounts.
+type GLAccounts map[string]string
+
+// ParseGLAccounts reads a "role=account,..." mapping over the defaults.
+// Accounts are whatever the accounting system matches journal lines on: a
+// nominal code for Sage, an account name for QuickBooks.
+func ParseGLAccounts(spec string) (GLAccounts, error) {
+	accounts := GLAccounts{}
+	for _, s := range []string{glDefaultAccounts, spec} {
+		for _, entry := range strings.Split(s, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			role, account, ok := strings.Cut(entry, "=")
+			role, account = strings.TrimSpace(role), strings.TrimSpace(account)
+			if !ok || account == "" {
+				return nil, fmt.Errorf("GL account mapping %q must be role=account", entry)
+			}
+			if s == spec {
+				if _, known := accounts[role]; !known {
+					return nil, fmt.Errorf("unknown GL role %q", role)
+				}
+			}
+			accounts[role] = account
+		}
+	}
+	return accounts, nil
+}
+
+// ValidGLFormat reports whether format is a journal export format.
+func ValidGLFormat(format string) bool {
+	return format == GLFormatCSV || format == GLFormatQuickBooks || format == GLFormatSage
+}
+
+// glEntries totals each day's activity by kind from $1 up to $2. Payments
+// later reversed count on the day they were made and again, the other way,
+// on the day of reversal. Archived payments count on the first of their
+// month; those removed by retention no longer count.
+const glEntries = `
+	SELECT to_char(day, 'YYYY-MM-DD'), kind, SUM(amount), SUM(n)
+	FROM (
+		SELECT t.processed_at::DATE AS day,
+		       CASE WHEN a.id IS NOT NULL OR t.metadata ? 'dispute_id' THEN 'adjustment'
+		            WHEN t.recovery THEN 'recovery'
+		            ELSE 'payment' END AS kind,
+		       t.amount, 1 AS n
+		FROM processed_transactions t
+		LEFT JOIN settlement_adjustments a ON a.reference = t.transaction_reference
+		WHERE t.processed_at >= $1 AND t.processed_at < $2
+
+		UNION ALL
+		SELECT processed_at::DATE, CASE WHEN recovery THEN 'recovery' ELSE 'payment' END, amount, 1
+		FROM reversed_transactions WHERE processed_at >= $1 AND processed_at < $2
+
+		UNION ALL
+		SELECT reversed_at::DATE, 'reversal', amount, 1
+		FROM reversed_transactions WHERE reversed_at >= $1 AND reversed_at < $2
+
+		UNION ALL
+		SELECT month, 'payment', total_amount, row_count
+		FROM transaction_archives WHERE month >= $1 AND month < $2
+
+		UNION ALL
+		SELECT written_off_at::DATE, 'write_off', written_off_amount, 1
+		FROM customer_accounts WHERE written_off_at >= $1 AND written_off_at < $2 AND written_off_amount > 0
+
+		UNION ALL
+		SELECT charged_at::DATE, 'fee', net_amount, 1
+		FROM fee_charges WHERE charged_at >= $1 AND charged_at < $2
+
+		UNION ALL
+		SELECT charged_at::DATE, 'fee_tax', tax_amount, 1
+		FROM fee_charges WHERE charged_at >= $1 AND charged_at < $2 AND tax_amount > 0
+
+		UNION ALL
+		SELECT updated_at::DATE, CASE WHEN payout_type = 'commission' THEN 'commission' ELSE 'refund' END, amount, 1
+		FROM payouts WHERE status = 'SUCCEEDED' AND updated_at >= $1 AND updated_at < $2
+	) entries
+	GROUP BY day, kind
+	ORDER BY day, kind
+`
+
+type glEntry struct {
+	day    string
+	kind   string
+	amount float64
+	count  int
+}
+
+func (db *DatabaseService) glEntries(ctx context.Context, from, until time.Time) ([]glEntry, error) {
+	rows, err := db.Query(ctx, glEntries, from, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []glEntry
+	for rows.Next() {
+		var e glEntry
+		if err := rows.Scan(&e.day, &e.kind, &e.amount, &e.count); err != nil {
+			return nil, err
+		}
+		e.amount = math.Round(e.amount*100) / 100
+		if e.amount != 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries, rows.Err()
+}
+
+// sides returns the debit and credit role of the entry and its absolute
+// amount.
+func (e glEntry) sides() (string, string, float64) {
+	roles := glEntryAccounts[e.kind]
+	if e.amount < 0 {
+		return roles[1], roles[0], -e.amount
+	}
+	return roles[0], roles[1], e.amount
+}
+
+// GLJournal returns the journal for the days from through to, two lines
+// per entry.
+func (db *DatabaseService) GLJournal(ctx context.Context, from, to time.Time, accounts GLAccounts) ([]*api.GLJournalLine, error) {
+	entries, err := db.glEntries(ctx, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]*api.GLJournalLine, 0, 2*len(entries))
+	for _, e := range entries {
+		debit, credit, amount := e.sides()
+		journal := fmt.Sprintf("GL-%s-%s", strings.ReplaceAll(e.day, "-", ""), strings.ToUpper(e.kind))
+		memo := fmt.Sprintf("%s (%d)", glEntryMemos[e.kind], e.count)
+		lines = append(lines,
+			&api.GLJournalLine{Date: e.day, Journal: journal, Kind: e.kind, Role: debit, Account: accounts[debit], Debit: amount, Count: e.count, Memo: memo},
+			&api.GLJournalLine{Date: e.day, Journal: journal, Kind: e.kind, Role: credit, Account: accounts[credit], Credit: amount, Count: e.count, Memo: memo},
+		)
+	}
+	return lines, nil
+}
+
+// TrialBalance returns each account's opening balance at from, its debits
+// and credits through to, and its closing balance. Roles mapped to the same
+// account are combined.
+func (db *DatabaseService) TrialBalance(ctx context.Context, from, to time.Time, accounts GLAccounts) (*api.TrialBalance, error) {
+	entries, err := db.glEntries(ctx, time.Time{}, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	byAccount := map[string]*api.TrialBalanceLine{}
+	line := func(role string) *api.TrialBalanceLine {
+		account := accounts[role]
+		l, ok := byAccount[account]
+		if !ok {
+			l = &api.TrialBalanceLine{Account: account}
+			byAccount[account] = l
+		}
+		for _, r := range l.Roles {
+			if r == role {
+				return l
+			}
+		}
+		l.Roles = append(l.Roles, role)
+		return l
+	}
+	for _, role := range []string{GLCash, GLLoansReceivable, GLTaxPayable, GLRecoveries, GLFeeIncome, GLAdjustments, GLBadDebt, GLCommissions} {
+		line(role)
+	}
+
+	start := from.Format("2006-01-02")
+	for _, e := range entries {
+		debit, credit, amount := e.sides()
+		if e.day < start {
+			line(debit).Opening += amount
+			line(credit).Opening -= amount
+			continue
+		}
+		line(debit).Debits += amount
+		line(credit).Credits += amount
+	}
+
+	balance := &api.TrialBalance{From: start, To: to.Format("2006-01-02"), Lines: []*api.TrialBalanceLine{}}
+	for _, l := range byAccount {
+		l.Opening = math.Round(l.Opening*100) / 100
+		l.Debits = math.Round(l.Debits*100) / 100
+		l.Credits = math.Round(l.Credits*100) / 100
+		l.Closing = math.Round((l.Opening+l.Debits-l.Credits)*100) / 100
+		balance.TotalDebits += l.Debits
+		balance.TotalCredits += l.Credits
+		balance.Lines = append(balance.Lines, l)
+	}
+	sort.Slice(balance.Lines, func(i, j int) bool { return balance.Lines[i].Account < balance.Lines[j].Account })
+	balance.TotalDebits = math.Round(balance.TotalDebits*100) / 100
+	balance.TotalCredits = math.Round(balance.TotalCredits*100) / 100
+	return balance, nil
+}
+
+// WriteGLJournal writes the journal as CSV in format: csv, one line per row
+// with every field; quickbooks, the QuickBooks Online journal entry import;
+// or sage, a Sage 50 journal (JD/JC) audit trail import.
+func WriteGLJournal(out io.Writer, format string, lines []*api.GLJournalLine) error {
+	w := csv.NewWriter(out)
+	switch format {
+	case GLFormatQuickBooks:
+		w.Write([]string{"Journal No", "Journal Date", "Account Name", "Debits", "Credits", "Description"})
+	case GLFormatSage:
+		w.Write([]string{"Type", "Account Reference", "Nominal A/C Ref", "Department Code", "Date", "Reference", "Details", "Net Amount", "Tax Code", "Tax Amount"})
+	case GLFormatCSV:
+		w.Write([]string{"date", "journal", "kind", "role", "account", "debit", "credit", "count", "memo"})
+	default:
+		return fmt.Errorf("unknown GL export format %q", format)
+	}
+
+	for _, l := range lines {
+		date, _ := time.Parse("2006-01-02", l.Date)
+		switch format {
+		case GLFormatQuickBooks:
+			w.Write([]string{l.Journal, date.Format("01/02/2006"), l.Account, glAmount(l.Debit), glAmount(l.Credit), l.Memo})
+		case GLFormatSage:
+			typ, amount := "JD", l.Debit
+			if l.Credit > 0 {
+				typ, amount = "JC", l.Credit
+			}
+			w.Write([]string{typ, "", l.Account, "0", date.Format("02/01/2006"), l.Journal, l.Memo, fmt.Sprintf("%.2f", amount), "T9", "0.00"})
+		default:
+			w.Write([]string{l.Date, l.Journal, l.Kind, l.Role, l.Account, fmt.Sprintf("%.2f", l.Debit), fmt.Sprintf("%.2f", l.Credit), fmt.Sprintf("%d", l.Count), l.Memo})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func glAmount(amount float64) string {
+	if amount == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", amount)
+}
+
+func WriteTrialBalanceCSV(out io.Writer, balance *api.TrialBalance) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"account", "roles", "opening", "debits", "credits", "closing"})
+	for _, l := range balance.Lines {
+		w.Write([]string{
+			l.Account,
+			strings.Join(l.Roles, " "),
+			fmt.Sprintf("%.2f", l.Opening),
+			fmt.Sprintf("%.2f", l.Debits),
+			fmt.Sprintf("%.2f", l.Credits),
+			fmt.Sprintf("%.2f", l.Closing),
+		})
+	}
+	w.Write([]string{"total", "", "", fmt.Sprintf("%.2f", balance.TotalDebits), fmt.Sprintf("%.2f", balance.TotalCredits), ""})
+	w.Flush()
+	return w.Error()
+}
+
+// GLExportFileNames names an export's journal and trial balance files.
+func GLExportFileNames(from, to time.Time, format string) (string, string) {
+	period := from.Format("20060102") + "-" + to.Format("20060102")
+	return fmt.Sprintf("gl-journal-%s-%s.csv", format, period), fmt.Sprintf("trial-balance-%s.csv", period)
+}
+
+const glExportColumns = `
+	id, to_char(period_start, 'YYYY-MM-DD'), to_char(period_end, 'YYYY-MM-DD'), format, journal_file,
+	trial_balance_file, lines, total_debits, exported_by, exported_at
+`
+
+func (db *DatabaseService) RecordGLExport(ctx context.Context, export *api.GLExport) (*api.GLExport, error) {
+	query := `
+		INSERT INTO gl_exports (period_start, period_end, format, journal_file, trial_balance_file, lines, total_debits, exported_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + glExportColumns
+
+	var e api.GLExport
+	err := db.QueryRow(ctx, query, export.PeriodStart, export.PeriodEnd, export.Format, export.JournalFile,
+		export.TrialBalanceFile, export.Lines, export.TotalDebits, export.ExportedBy).
+		Scan(&e.ID, &e.PeriodStart, &e.PeriodEnd, &e.Format, &e.JournalFile, &e.TrialBalanceFile, &e.Lines, &e.TotalDebits, &e.ExportedBy, &e.ExportedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListGLExports returns the latest exports first.
+func (db *DatabaseService) ListGLExports(ctx context.Context, limit int) ([]*api.GLExport, error) {
+	rows, err := db.Query(ctx, `SELECT `+glExportColumns+` FROM gl_exports ORDER BY period_end DESC, id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []*api.GLExport{}
+	for rows.Next() {
+		var e api.GLExport
+		if err := rows.Scan(&e.ID, &e.PeriodStart, &e.PeriodEnd, &e.Format, &e.JournalFile, &e.TrialBalanceFile,
+			&e.Lines, &e.TotalDebits, &e.ExportedBy, &e.ExportedAt); err != nil {
+			return nil, err
+		}
+		exports = append(exports, &e)
+	}
+	return exports, rows.Err()
+}
+
+// LastGLExportEnd returns the last day pushed, zero if none has been.
+func (db *DatabaseService) LastGLExportEnd(ctx context.Context) (time.Time, error) {
+	var last *time.Time
+	if err := db.QueryRow(ctx, `SELECT MAX(period_end) FROM gl_exports`).Scan(&last); err != nil {
+		return time.Time{}, err
+	}
+	if last == nil {
+		return time.Time{}, nil
+	}
+	return *last, nil
+}
@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// GroupSummary rolls up every account in a group, guarantors included.
+type GroupSummary struct {
+	GroupID           string  `json:"group_id"`
+	Accounts          int     `json:"accounts"`
+	AccountsInArrears int     `json:"accounts_in_arrears"`
+	TotalDeployed     float64 `json:"total_deployed"`
+	TotalPaid         float64 `json:"total_paid"`
+	TotalOutstanding  float64 `json:"total_outstanding"`
+	TotalArrears      float64 `json:"total_arrears"`
+	// Collected is what the group's accounts paid since CollectedSince.
+	Collected      float64   `json:"collected"`
+	CollectedSince time.Time `json:"collected_since"`
+}
+
+func (db *DatabaseService) CreateCustomerGroup(ctx context.Context, group *api.CustomerGroup) error {
+	if group.AllocationRule == "" {
+		group.AllocationRule = api.AllocateArrearsFirst
+	}
+
+	query := `
+		INSERT INTO customer_groups (group_id, name, allocation_rule)
+		VALUES ($1, $2, $3)
+		RETURNING created_at
+	`
+
+	return db.QueryRow(ctx, query, group.GroupID, group.Name, group.AllocationRule).Scan(&group.CreatedAt)
+}
+
+func (db *DatabaseService) GetCustomerGroup(ctx context.Context, groupID string) (*api.CustomerGroup, error) {
+	query := `SELECT group_id, name, allocation_rule, created_at FROM customer_groups WHERE group_id = $1`
+
+	var group api.CustomerGroup
+	if err := db.QueryRow(ctx, query, groupID).Scan(&group.GroupID, &group.Name, &group.AllocationRule, &group.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (db *DatabaseService) ListCustomerGroups(ctx context.Context) ([]api.CustomerGroup, error) {
+	rows, err := db.Query(ctx, `SELECT group_id, name, allocation_rule, created_at FROM customer_groups ORDER BY group_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []api.CustomerGroup{}
+	for rows.Next() {
+		var group api.CustomerGroup
+		if err := rows.Scan(&group.GroupID, &group.Name, &group.AllocationRule, &group.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// AddGroupMember adds the account to the group, or changes its role if it is
+// already a member.
+func (db *DatabaseService) AddGroupMember(ctx context.Context, groupID, customerID, role string) error {
+	query := `
+		INSERT INTO customer_group_members (group_id, customer_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (group_id, customer_id) DO UPDATE SET role = EXCLUDED.role
+	`
+
+	_, err := db.Exec(ctx, query, groupID, customerID, role)
+	return err
+}
+
+func (db *DatabaseService) RemoveGroupMember(ctx context.Context, groupID, customerID string) error {
+	result, err := db.Exec(ctx, `DELETE FROM customer_group_members WHERE group_id = $1 AND customer_id = $2`, groupID, customerID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+// ListGroupMembers returns the members with their balances and arrears.
+func (db *DatabaseService) ListGroupMembers(ctx context.Context, groupID string) ([]api.GroupMember, error) {
+	query := `
+		SELECT m.customer_id, m.role, c.outstanding_balance, c.total_paid, ` + arrearsExpr + `, m.joined_at
+		FROM customer_group_members m
+		JOIN customer_accounts c USING (customer_id)
+		WHERE m.group_id = $1
+		ORDER BY m.joined_at, m.customer_id
+	`
+
+	rows, err := db.Query(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []api.GroupMember{}
+	for rows.Next() {
+		var member api.GroupMember
+		if err := rows.Scan(&member.CustomerID, &member.Role, &member.OutstandingBalance, &member.TotalPaid, &member.Arrears, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		member.Arrears = roundCents(member.Arrears)
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (db *DatabaseService) GetGroupSummary(ctx context.Context, groupID string, collectedSince time.Time) (*GroupSummary, error) {
+	query := `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE ` + arrearsExpr + ` > 0),
+		       COALESCE(SUM(c.asset_value), 0),
+		       COALESCE(SUM(c.total_paid), 0),
+		       COALESCE(SUM(c.outstanding_balance), 0),
+		       COALESCE(SUM(` + arrearsExpr + `), 0),
+		       (SELECT COALESCE(SUM(t.amount), 0)
+		        FROM processed_transactions t
+		        JOIN customer_group_members g ON g.customer_id = t.customer_id
+		        WHERE g.group_id = $1 AND t.processed_at >= $2)
+		FROM customer_group_members m
+		JOIN customer_accounts c USING (customer_id)
+		WHERE m.group_id = $1
+	`
+
+	summary := GroupSummary{GroupID: groupID, CollectedSince: collectedSince}
+	err := db.QueryRow(ctx, query, groupID, collectedSince).Scan(
+		&summary.Accounts,
+		&summary.AccountsInArrears,
+		&summary.TotalDeployed,
+		&summary.TotalPaid,
+		&summary.TotalOutstanding,
+		&summary.TotalArrears,
+		&summary.Collected,
+	)
+	if err != nil {
+		return nil, err
+	}
+	summary.TotalArrears = roundCents(summary.TotalArrears)
+	return &summary, nil
+}
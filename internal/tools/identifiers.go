@@ -0,0 +1,142 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+type IdentifierMapping struct {
+	Type       api.IdentifierType `json:"type"`
+	Value      string             `json:"value"`
+	CustomerID string             `json:"customer_id"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+func NormalizeIdentifier(identifierType api.IdentifierType, value string) string {
+	value = strings.TrimSpace(value)
+	switch identifierType {
+	case api.IdentifierPhone:
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, value)
+	case api.IdentifierNationalID:
+		return strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+	default:
+		return value
+	}
+}
+
+// sensitiveIdentifier reports whether values of this type are PII that is
+// encrypted at rest when PII encryption is enabled.
+func sensitiveIdentifier(identifierType api.IdentifierType) bool {
+	return identifierType == api.IdentifierPhone || identifierType == api.IdentifierNationalID
+}
+
+// identifierKey is the value stored in identifier_value: the normalized value
+// itself, or its blind index for encrypted types.
+func (db *DatabaseService) identifierKey(identifierType api.IdentifierType, normalized string) string {
+	if !sensitiveIdentifier(identifierType) {
+		return normalized
+	}
+	return db.piiIndex(string(identifierType) + ":" + normalized)
+}
+
+func (db *DatabaseService) ResolveCustomerID(ctx context.Context, identifierType api.IdentifierType, value string) (string, error) {
+	// Plaintext rows written before encryption was enabled match until
+	// ReencryptPII converts them.
+	query := `
+		SELECT customer_id
+		FROM customer_identifiers
+		WHERE identifier_type = $1 AND identifier_value IN ($2, $3)
+		LIMIT 1
+	`
+
+	normalized := NormalizeIdentifier(identifierType, value)
+
+	var customerID string
+	err := db.QueryRow(ctx, query, identifierType, db.identifierKey(identifierType, normalized), normalized).Scan(&customerID)
+	// Dynamic payment references are quoted as partner references; they
+	// resolve to the account their intent is for.
+	if errors.Is(err, ErrNotFound) && identifierType == api.IdentifierPartnerRef && IsPaymentReference(normalized) {
+		err = db.QueryRow(ctx, `SELECT customer_id FROM payment_intents WHERE reference = $1`, strings.ToUpper(normalized)).Scan(&customerID)
+	}
+	return customerID, err
+}
+
+func (db *DatabaseService) AddCustomerIdentifier(ctx context.Context, customerID string, identifierType api.IdentifierType, value string) (*IdentifierMapping, error) {
+	query := `
+		INSERT INTO customer_identifiers (identifier_type, identifier_value, identifier_encrypted, customer_id)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		RETURNING identifier_type, customer_id, created_at
+	`
+
+	normalized := NormalizeIdentifier(identifierType, value)
+	encrypted := ""
+	if sensitiveIdentifier(identifierType) && db.pii != nil {
+		var err error
+		if encrypted, err = db.sealPII(normalized); err != nil {
+			return nil, err
+		}
+	}
+
+	mapping := IdentifierMapping{Value: normalized}
+	err := db.QueryRow(ctx, query, identifierType, db.identifierKey(identifierType, normalized), encrypted, customerID).Scan(
+		&mapping.Type,
+		&mapping.CustomerID,
+		&mapping.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &mapping, nil
+}
+
+func (db *DatabaseService) ListCustomerIdentifiers(ctx context.Context, customerID string) ([]IdentifierMapping, error) {
+	query := `
+		SELECT identifier_type, COALESCE(identifier_encrypted, identifier_value), customer_id, created_at
+		FROM customer_identifiers
+		WHERE customer_id = $1
+		ORDER BY identifier_type, created_at
+	`
+
+	rows, err := db.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := []IdentifierMapping{}
+	for rows.Next() {
+		var mapping IdentifierMapping
+		if err := rows.Scan(&mapping.Type, &mapping.Value, &mapping.CustomerID, &mapping.CreatedAt); err != nil {
+			return nil, err
+		}
+		if mapping.Value, err = db.openPII(ctx, mapping.Value); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, rows.Err()
+}
+
+func (db *DatabaseService) DeleteCustomerIdentifier(ctx context.Context, customerID string, identifierType api.IdentifierType, value string) (bool, error) {
+	query := `
+		DELETE FROM customer_identifiers
+		WHERE customer_id = $1 AND identifier_type = $2 AND identifier_value IN ($3, $4)
+	`
+
+	normalized := NormalizeIdentifier(identifierType, value)
+	result, err := db.Exec(ctx, query, customerID, identifierType, db.identifierKey(identifierType, normalized), normalized)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
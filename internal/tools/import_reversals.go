@@ -0,0 +1,207 @@
+package tools
+
+import (
+	"context"
+	"errors"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrImportReversalExists is returned when requesting a reversal of an
+// import batch that already has a pending or completed one.
+var ErrImportReversalExists = duplicateError("import batch already has a pending or completed reversal")
+
+const importReversalColumns = `
+	id, import_batch_id, status, reason, requested_by, COALESCE(decided_by, ''), COALESCE(decision_note, ''),
+	payments, total_amount, requested_at, decided_at
+`
+
+func scanImportReversal(row pgx.Row) (*api.ImportReversal, error) {
+	var r api.ImportReversal
+	err := row.Scan(
+		&r.ID,
+		&r.ImportBatchID,
+		&r.Status,
+		&r.Reason,
+		&r.RequestedBy,
+		&r.DecidedBy,
+		&r.DecisionNote,
+		&r.Payments,
+		&r.TotalAmount,
+		&r.RequestedAt,
+		&r.DecidedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RequestImportReversal opens a pending reversal of the batch, counting the
+// payments it would reverse now.
+func (db *DatabaseService) RequestImportReversal(ctx context.Context, batchID int64, reason, requestedBy string) (*api.ImportReversal, error) {
+	query := `
+		INSERT INTO import_reversals (import_batch_id, reason, requested_by, payments, total_amount)
+		SELECT $1, $2, $3, COUNT(*), COALESCE(SUM(amount), 0)
+		FROM processed_transactions
+		WHERE import_batch_id = $1
+		ON CONFLICT (import_batch_id) WHERE status <> 'REJECTED' DO NOTHING
+		RETURNING ` + importReversalColumns
+
+	reversal, err := scanImportReversal(db.QueryRow(ctx, query, batchID, reason, requestedBy))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrImportReversalExists
+	}
+	return reversal, err
+}
+
+func (db *DatabaseService) GetImportReversal(ctx context.Context, id int64) (*api.ImportReversal, error) {
+	query := `SELECT ` + importReversalColumns + ` FROM import_reversals WHERE id = $1`
+	return scanImportReversal(db.QueryRow(ctx, query, id))
+}
+
+func (db *DatabaseService) ListImportReversals(ctx context.Context, batchID int64) ([]*api.ImportReversal, error) {
+	query := `
+		SELECT ` + importReversalColumns + `
+		FROM import_reversals
+		WHERE import_batch_id = $1
+		ORDER BY requested_at DESC
+	`
+
+	rows, err := db.Query(ctx, query, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reversals := []*api.ImportReversal{}
+	for rows.Next() {
+		reversal, err := scanImportReversal(rows)
+		if err != nil {
+			return nil, err
+		}
+		reversals = append(reversals, reversal)
+	}
+	return reversals, rows.Err()
+}
+
+// ApproveImportReversal reverses the batch's payments and marks the
+// reversal completed in one transaction, returning it with the payments it
+// reversed. Each payment's processed_transactions row moves to
+// reversed_transactions and its reference is released, so a corrected file
+// can bring it in again; the accounts lose the payments from their totals.
+// Payments already archived are not reversed. It returns ErrNotFound if
+// the reversal is not pending, and ErrSettlementClosed if any of the
+// payments fall on a closed settlement day.
+func (db *DatabaseService) ApproveImportReversal(ctx context.Context, id int64, decidedBy, note string) (*api.ImportReversal, []TransactionRecord, error) {
+	var reversal *api.ImportReversal
+	reversed := []TransactionRecord{}
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var batchID int64
+		err := tx.QueryRow(ctx, `
+			SELECT import_batch_id FROM import_reversals WHERE id = $1 AND status = 'PENDING' FOR UPDATE
+		`, id).Scan(&batchID)
+		if err != nil {
+			return err
+		}
+		settled, err := settledPayments(ctx, tx, "t.import_batch_id = $1", batchID)
+		if err != nil {
+			return err
+		}
+		if settled {
+			return ErrSettlementClosed
+		}
+
+		rows, err := tx.Query(ctx, `
+			WITH removed AS (
+				DELETE FROM processed_transactions
+				WHERE import_batch_id = $2
+				RETURNING transaction_reference, customer_id, amount, processed_at, metadata, agent_id, recovery, value_date
+			), released AS (
+				DELETE FROM processed_references WHERE transaction_reference IN (SELECT transaction_reference FROM removed)
+			)
+			INSERT INTO reversed_transactions (reversal_id, transaction_reference, customer_id, amount, processed_at, metadata, agent_id, recovery, value_date)
+			SELECT $1, transaction_reference, customer_id, amount, processed_at, metadata, agent_id, recovery, value_date
+			FROM removed
+			RETURNING transaction_reference, customer_id, amount, processed_at, metadata
+		`, id, batchID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var txn TransactionRecord
+			if err := rows.Scan(&txn.TransactionReference, &txn.CustomerID, &txn.Amount, &txn.ProcessedAt, &txn.Metadata); err != nil {
+				rows.Close()
+				return err
+			}
+			reversed = append(reversed, txn)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if err := removeReversedPayments(ctx, tx, "reversal_id", id); err != nil {
+			return err
+		}
+
+		reversal, err = scanImportReversal(tx.QueryRow(ctx, `
+			UPDATE import_reversals
+			SET status = 'COMPLETED',
+			    decided_by = $2,
+			    decision_note = NULLIF($3, ''),
+			    decided_at = NOW(),
+			    payments = (SELECT COUNT(*) FROM reversed_transactions WHERE reversal_id = $1),
+			    total_amount = (SELECT COALESCE(SUM(amount), 0) FROM reversed_transactions WHERE reversal_id = $1)
+			WHERE id = $1
+			RETURNING `+importReversalColumns, id, decidedBy, note))
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return reversal, reversed, nil
+}
+
+func (db *DatabaseService) RejectImportReversal(ctx context.Context, id int64, decidedBy, note string) (*api.ImportReversal, error) {
+	query := `
+		UPDATE import_reversals
+		SET status = 'REJECTED',
+		    decided_by = $2,
+		    decision_note = NULLIF($3, ''),
+		    decided_at = NOW()
+		WHERE id = $1 AND status = 'PENDING'
+		RETURNING ` + importReversalColumns
+
+	return scanImportReversal(db.QueryRow(ctx, query, id, decidedBy, note))
+}
+
+// removeReversedPayments takes the payments reversed_transactions holds for
+// one import reversal or dispute, by column, off their accounts' totals.
+// The last payment date falls back to the latest payment left, archived or
+// not.
+func removeReversedPayments(ctx context.Context, tx pgx.Tx, column string, id int64) error {
+	_, err := tx.Exec(ctx, `
+		WITH totals AS (
+			SELECT customer_id, SUM(amount) AS amount, COUNT(*) AS payments,
+			       COALESCE(SUM(amount) FILTER (WHERE recovery), 0) AS recovered
+			FROM reversed_transactions
+			WHERE `+column+` = $1
+			GROUP BY customer_id
+		)
+		UPDATE customer_accounts a
+		SET total_paid = a.total_paid - t.amount,
+		    outstanding_balance = a.outstanding_balance + t.amount,
+		    recovered_amount = a.recovered_amount - t.recovered,
+		    payment_count = a.payment_count - t.payments,
+		    last_payment_date = COALESCE(
+		        (SELECT MAX(processed_at) FROM processed_transactions WHERE customer_id = a.customer_id),
+		        (SELECT MAX(last_effective_at) FROM transaction_archive_customers WHERE customer_id = a.customer_id)),
+		    version = a.version + 1,
+		    updated_at = NOW()
+		FROM totals t
+		WHERE a.customer_id = t.customer_id
+	`, id)
+	return err
+}
@@ -0,0 +1,298 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const importBatchColumns = `
+	id, source, file_name, status, total_rows, queued_rows, duplicate_rows,
+	failed_rows, errors, COALESCE(error, ''), started_at, completed_at,
+	COALESCE(owner, ''), COALESCE(file_bytes, 0), checkpoint_row, checkpoint_offset,
+	checkpoint_at, resumed_at, resumed_rows, cancel_requested_at, COALESCE(cancelled_by, '')
+`
+
+// ImportStaleAfter is how long a running import can go without a
+// checkpoint before it is taken to have died with its instance, and can be
+// resumed elsewhere or cancelled outright.
+const ImportStaleAfter = 2 * time.Minute
+
+func scanImportBatch(row pgx.Row) (*api.ImportBatch, error) {
+	var batch api.ImportBatch
+	err := row.Scan(
+		&batch.ID,
+		&batch.Source,
+		&batch.FileName,
+		&batch.Status,
+		&batch.TotalRows,
+		&batch.QueuedRows,
+		&batch.DuplicateRows,
+		&batch.FailedRows,
+		&batch.Errors,
+		&batch.Error,
+		&batch.StartedAt,
+		&batch.CompletedAt,
+		&batch.Owner,
+		&batch.FileBytes,
+		&batch.CheckpointRow,
+		&batch.CheckpointOffset,
+		&batch.CheckpointAt,
+		&batch.ResumedAt,
+		&batch.ResumedRows,
+		&batch.CancelRequestedAt,
+		&batch.CancelledBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// StartImportBatch opens a batch for a file, run by owner. fileKey
+// identifies the file version at its source; a key that already completed
+// or was cancelled returns started=false so pollers don't import the same
+// file twice, while a failed one is restarted and one whose owner stopped
+// checkpointing is taken over to resume (see ResumedAt). fileBytes is the
+// file's size, or 0 if unknown.
+func (db *DatabaseService) StartImportBatch(ctx context.Context, source, fileName, fileKey, owner string, fileBytes int64) (*api.ImportBatch, bool, error) {
+	query := `
+		INSERT INTO import_batches (source, file_name, file_key, owner, file_bytes, checkpoint_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, 0), NOW())
+		ON CONFLICT (file_key) DO UPDATE
+		SET status = 'RUNNING',
+		    total_rows = 0,
+		    queued_rows = 0,
+		    duplicate_rows = 0,
+		    failed_rows = 0,
+		    errors = '[]',
+		    error = NULL,
+		    started_at = NOW(),
+		    completed_at = NULL,
+		    owner = EXCLUDED.owner,
+		    file_bytes = EXCLUDED.file_bytes,
+		    checkpoint_row = 0,
+		    checkpoint_offset = 0,
+		    checkpoint_at = NOW(),
+		    resumed_at = NULL,
+		    resumed_rows = 0,
+		    cancel_requested_at = NULL,
+		    cancelled_by = NULL
+		WHERE import_batches.status = 'FAILED'
+		RETURNING ` + importBatchColumns
+
+	batch, err := scanImportBatch(db.QueryRow(ctx, query, source, fileName, fileKey, owner, fileBytes))
+	if errors.Is(err, ErrNotFound) && fileKey != "" {
+		batch, err = db.resumeImportBatch(ctx, fileKey, owner)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return batch, true, nil
+}
+
+// resumeImportBatch takes over a running import that has gone
+// ImportStaleAfter without a checkpoint. Row locking lets only one
+// instance win it.
+func (db *DatabaseService) resumeImportBatch(ctx context.Context, fileKey, owner string) (*api.ImportBatch, error) {
+	query := `
+		UPDATE import_batches
+		SET owner = NULLIF($2, ''),
+		    resumed_at = NOW(),
+		    resumed_rows = total_rows,
+		    checkpoint_at = NOW()
+		WHERE file_key = $1
+		  AND status = 'RUNNING'
+		  AND cancel_requested_at IS NULL
+		  AND COALESCE(checkpoint_at, started_at) < NOW() - make_interval(secs => $3)
+		RETURNING ` + importBatchColumns
+
+	return scanImportBatch(db.QueryRow(ctx, query, fileKey, owner, ImportStaleAfter.Seconds()))
+}
+
+// ImportFileKnown reports whether a file version was already imported or is
+// being imported, so it needn't be downloaded again. Failed imports, and
+// running ones that have gone stale, are not known: they are to be run
+// again.
+func (db *DatabaseService) ImportFileKnown(ctx context.Context, fileKey string) (bool, error) {
+	var known bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM import_batches
+			WHERE file_key = $1
+			  AND status <> 'FAILED'
+			  AND NOT (status = 'RUNNING' AND cancel_requested_at IS NULL
+			           AND COALESCE(checkpoint_at, started_at) < NOW() - make_interval(secs => $2))
+		)
+	`, fileKey, ImportStaleAfter.Seconds()).Scan(&known)
+	return known, err
+}
+
+// CheckpointImportBatch records the batch's progress through the file as
+// its owner. It reports whether a cancel has been requested, and returns
+// ErrNotFound if the batch has been taken over by another instance.
+func (db *DatabaseService) CheckpointImportBatch(ctx context.Context, batch *api.ImportBatch) (cancelled bool, err error) {
+	query := `
+		UPDATE import_batches
+		SET total_rows = $3,
+		    queued_rows = $4,
+		    duplicate_rows = $5,
+		    failed_rows = $6,
+		    errors = $7,
+		    checkpoint_row = $8,
+		    checkpoint_offset = $9,
+		    checkpoint_at = NOW()
+		WHERE id = $1 AND status = 'RUNNING' AND owner IS NOT DISTINCT FROM NULLIF($2, '')
+		RETURNING checkpoint_at, cancel_requested_at, COALESCE(cancelled_by, '')
+	`
+
+	err = db.QueryRow(ctx, query, batch.ID, batch.Owner, batch.TotalRows, batch.QueuedRows, batch.DuplicateRows,
+		batch.FailedRows, batch.Errors, batch.CheckpointRow, batch.CheckpointOffset,
+	).Scan(&batch.CheckpointAt, &batch.CancelRequestedAt, &batch.CancelledBy)
+	return batch.CancelRequestedAt != nil, err
+}
+
+// CancelImportBatch asks the running import to stop at its next
+// checkpoint. One whose owner has stopped checkpointing is cancelled
+// outright, as nothing is left to see the request. It returns ErrNotFound
+// if the import isn't running.
+func (db *DatabaseService) CancelImportBatch(ctx context.Context, id int64, cancelledBy string) (*api.ImportBatch, error) {
+	query := `
+		UPDATE import_batches
+		SET cancel_requested_at = COALESCE(cancel_requested_at, NOW()),
+		    cancelled_by = COALESCE(cancelled_by, $2),
+		    status = CASE WHEN COALESCE(checkpoint_at, started_at) < NOW() - make_interval(secs => $3)
+		                  THEN 'CANCELLED' ELSE status END,
+		    completed_at = CASE WHEN COALESCE(checkpoint_at, started_at) < NOW() - make_interval(secs => $3)
+		                        THEN NOW() ELSE completed_at END
+		WHERE id = $1 AND status = 'RUNNING'
+		RETURNING ` + importBatchColumns
+
+	return scanImportBatch(db.QueryRow(ctx, query, id, cancelledBy, ImportStaleAfter.Seconds()))
+}
+
+// FinishImportBatch records the outcome of an import run by batch.Owner.
+// It returns ErrNotFound if the batch has been taken over since.
+func (db *DatabaseService) FinishImportBatch(ctx context.Context, batch *api.ImportBatch) error {
+	query := `
+		UPDATE import_batches
+		SET status = $2,
+		    total_rows = $3,
+		    queued_rows = $4,
+		    duplicate_rows = $5,
+		    failed_rows = $6,
+		    errors = $7,
+		    error = NULLIF($8, ''),
+		    checkpoint_row = $10,
+		    checkpoint_offset = $11,
+		    checkpoint_at = NOW(),
+		    completed_at = NOW()
+		WHERE id = $1 AND status = 'RUNNING' AND owner IS NOT DISTINCT FROM NULLIF($9, '')
+		RETURNING completed_at
+	`
+
+	return db.QueryRow(ctx, query, batch.ID, batch.Status, batch.TotalRows, batch.QueuedRows,
+		batch.DuplicateRows, batch.FailedRows, batch.Errors, batch.Error, batch.Owner,
+		batch.CheckpointRow, batch.CheckpointOffset,
+	).Scan(&batch.CompletedAt)
+}
+
+// ImportBatchProgress works out how far batch has got as of now.
+func ImportBatchProgress(batch *api.ImportBatch, now time.Time) *api.ImportProgress {
+	progress := &api.ImportProgress{
+		RowsProcessed: batch.TotalRows,
+		BytesRead:     batch.CheckpointOffset,
+		UpdatedAt:     batch.StartedAt,
+	}
+	if batch.CheckpointAt != nil {
+		progress.UpdatedAt = *batch.CheckpointAt
+	}
+	if batch.CompletedAt != nil {
+		progress.UpdatedAt = *batch.CompletedAt
+	}
+
+	if batch.FileBytes > 0 {
+		done := 100.0
+		if batch.Status == api.ImportRunning {
+			done = math.Min(100, 100*float64(batch.CheckpointOffset)/float64(batch.FileBytes))
+		}
+		progress.PercentComplete = &done
+	}
+
+	runStart, runRows := batch.StartedAt, batch.TotalRows
+	if batch.ResumedAt != nil {
+		runStart, runRows = *batch.ResumedAt, batch.TotalRows-batch.ResumedRows
+	}
+	if elapsed := progress.UpdatedAt.Sub(runStart).Seconds(); elapsed > 0 && runRows > 0 {
+		progress.RowsPerSecond = float64(runRows) / elapsed
+	}
+
+	// Rows so far, scaled up by the share of the file they took, estimate
+	// the total.
+	if batch.Status == api.ImportRunning && batch.FileBytes > 0 && batch.CheckpointOffset > 0 && progress.RowsPerSecond > 0 {
+		total := float64(batch.TotalRows) * float64(batch.FileBytes) / float64(batch.CheckpointOffset)
+		eta := math.Max(0, (total-float64(batch.TotalRows))/progress.RowsPerSecond-now.Sub(progress.UpdatedAt).Seconds())
+		progress.ETASeconds = &eta
+	}
+	return progress
+}
+
+func (db *DatabaseService) GetImportBatch(ctx context.Context, id int64) (*api.ImportBatch, error) {
+	query := `SELECT ` + importBatchColumns + ` FROM import_batches WHERE id = $1`
+	return scanImportBatch(db.QueryRow(ctx, query, id))
+}
+
+func (db *DatabaseService) ListImportBatches(ctx context.Context, source string, limit, offset int) ([]*api.ImportBatch, error) {
+	query := `
+		SELECT ` + importBatchColumns + `
+		FROM import_batches
+		WHERE ($1 = '' OR source = $1)
+		ORDER BY started_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := db.Query(ctx, query, source, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := []*api.ImportBatch{}
+	for rows.Next() {
+		batch, err := scanImportBatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	return batches, rows.Err()
+}
+
+// importBatchID returns the import batch the payment came from, or nil. The
+// ID is an int64 as the pipeline sets it and a float64 once the payment has
+// been through the queue.
+func importBatchID(payment *api.PaymentPayload) *int64 {
+	var id int64
+	switch value := payment.Metadata[api.MetadataImportBatchID].(type) {
+	case int64:
+		id = value
+	case float64:
+		if value != math.Trunc(value) {
+			return nil
+		}
+		id = int64(value)
+	default:
+		return nil
+	}
+	if id <= 0 {
+		return nil
+	}
+	return &id
+}
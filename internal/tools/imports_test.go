@@ -0,0 +1,60 @@
+package tools
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+func TestImportBatchProgress(t *testing.T) {
+	started := time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) *time.Time {
+		when := started.Add(time.Duration(seconds) * time.Second)
+		return &when
+	}
+
+	running := &api.ImportBatch{
+		Status:           api.ImportRunning,
+		TotalRows:        1000,
+		FileBytes:        400000,
+		CheckpointOffset: 100000,
+		StartedAt:        started,
+		CheckpointAt:     at(10),
+	}
+	progress := ImportBatchProgress(running, *at(10))
+	if progress.RowsPerSecond != 100 {
+		t.Errorf("rows per second = %v, want 100", progress.RowsPerSecond)
+	}
+	if progress.PercentComplete == nil || *progress.PercentComplete != 25 {
+		t.Errorf("percent complete = %v, want 25", progress.PercentComplete)
+	}
+	// 3000 rows to go at 100 a second.
+	if progress.ETASeconds == nil || math.Abs(*progress.ETASeconds-30) > 1e-9 {
+		t.Errorf("eta = %v, want 30", progress.ETASeconds)
+	}
+	if later := ImportBatchProgress(running, *at(15)); *later.ETASeconds != 25 {
+		t.Errorf("eta 5s after the checkpoint = %v, want 25", *later.ETASeconds)
+	}
+
+	// A resumed import's rate covers only the rows since it resumed.
+	resumed := *running
+	resumed.ResumedAt, resumed.ResumedRows = at(100), 800
+	resumed.CheckpointAt = at(104)
+	if progress := ImportBatchProgress(&resumed, *at(104)); progress.RowsPerSecond != 50 {
+		t.Errorf("resumed rows per second = %v, want 50", progress.RowsPerSecond)
+	}
+
+	unsized := *running
+	unsized.FileBytes = 0
+	if progress := ImportBatchProgress(&unsized, *at(10)); progress.PercentComplete != nil || progress.ETASeconds != nil {
+		t.Errorf("progress of a file of unknown size has percent %v, eta %v", progress.PercentComplete, progress.ETASeconds)
+	}
+
+	done := *running
+	done.Status, done.CompletedAt = api.ImportCompleted, at(40)
+	if progress := ImportBatchProgress(&done, *at(60)); *progress.PercentComplete != 100 || progress.ETASeconds != nil || progress.RowsPerSecond != 25 {
+		t.Errorf("completed import progress = %+v", progress)
+	}
+}
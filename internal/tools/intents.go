@@ -0,0 +1,171 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrIntentClosed is returned when cancelling an intent that was already
+// matched or cancelled.
+var ErrIntentClosed = errors.New("payment intent is no longer pending")
+
+// Pending intents past their expiry are reported as EXPIRED.
+const paymentIntentColumns = `reference, customer_id, amount, COALESCE(currency, ''),
+	CASE WHEN status = 'PENDING' AND expires_at <= NOW() THEN 'EXPIRED' ELSE status END,
+	expires_at, COALESCE(transaction_reference, ''), metadata, created_at, matched_at, cancelled_at`
+
+func scanPaymentIntent(row pgx.Row) (*api.PaymentIntent, error) {
+	var intent api.PaymentIntent
+	var metadata []byte
+	err := row.Scan(
+		&intent.Reference,
+		&intent.CustomerID,
+		&intent.Amount,
+		&intent.Currency,
+		&intent.Status,
+		&intent.ExpiresAt,
+		&intent.TransactionReference,
+		&metadata,
+		&intent.CreatedAt,
+		&intent.MatchedAt,
+		&intent.CancelledAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metadata, &intent.Metadata); err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// IsPaymentReference reports whether value has the form of a payment intent
+// reference, in any case.
+func IsPaymentReference(value string) bool {
+	value = strings.TrimSpace(value)
+	return len(value) == len("PI-")+16 && strings.EqualFold(value[:3], "PI-")
+}
+
+// newIntentReference returns a random reference such as PI-3F9C0A17D2B4E865.
+// References are upper case so they survive channels that change case.
+func newIntentReference() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "PI-" + strings.ToUpper(hex.EncodeToString(b)), nil
+}
+
+// CreatePaymentIntent reserves an expected payment and fills in its
+// reference, status and creation time.
+func (db *DatabaseService) CreatePaymentIntent(ctx context.Context, intent *api.PaymentIntent) error {
+	reference, err := newIntentReference()
+	if err != nil {
+		return err
+	}
+	metadata, err := json.Marshal(intent.Metadata)
+	if err != nil {
+		return err
+	}
+	if intent.Metadata == nil {
+		metadata = []byte(`{}`)
+	}
+
+	query := `
+		INSERT INTO payment_intents (reference, customer_id, amount, currency, expires_at, metadata)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		RETURNING ` + paymentIntentColumns
+
+	created, err := scanPaymentIntent(db.QueryRow(ctx, query, reference, intent.CustomerID, intent.Amount, intent.Currency, intent.ExpiresAt, json.RawMessage(metadata)))
+	if err != nil {
+		return err
+	}
+	*intent = *created
+	return nil
+}
+
+func (db *DatabaseService) GetPaymentIntent(ctx context.Context, reference string) (*api.PaymentIntent, error) {
+	query := `SELECT ` + paymentIntentColumns + ` FROM payment_intents WHERE reference = $1`
+	return scanPaymentIntent(db.QueryRow(ctx, query, reference))
+}
+
+// CancelPaymentIntent cancels a pending intent, expired or not. It returns
+// ErrNotFound for an unknown reference and ErrIntentClosed once the
+// intent has been matched or cancelled.
+func (db *DatabaseService) CancelPaymentIntent(ctx context.Context, reference string) (*api.PaymentIntent, error) {
+	query := `
+		UPDATE payment_intents
+		SET status = 'CANCELLED', cancelled_at = NOW()
+		WHERE reference = $1 AND status = 'PENDING'
+		RETURNING ` + paymentIntentColumns
+
+	intent, err := scanPaymentIntent(db.QueryRow(ctx, query, reference))
+	if !errors.Is(err, ErrNotFound) {
+		return intent, err
+	}
+	if _, err := db.GetPaymentIntent(ctx, reference); err != nil {
+		return nil, err
+	}
+	return nil, ErrIntentClosed
+}
+
+// MatchPaymentIntent links a pending intent to the transaction that paid
+// it. It returns false when the intent is no longer pending; a replay of
+// the transaction that already matched it is reported as matched.
+func (db *DatabaseService) MatchPaymentIntent(ctx context.Context, reference, transactionReference string) (bool, error) {
+	query := `
+		UPDATE payment_intents
+		SET status = 'MATCHED', transaction_reference = $2, matched_at = NOW()
+		WHERE reference = $1 AND status = 'PENDING'
+	`
+
+	tag, err := db.Exec(ctx, query, reference, transactionReference)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() > 0 {
+		return true, nil
+	}
+
+	var matched bool
+	err = db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM payment_intents WHERE reference = $1 AND transaction_reference = $2)`,
+		reference, transactionReference).Scan(&matched)
+	return matched, err
+}
+
+// LinkPaymentToIntent matches a payment that carried no intent reference to
+// the customer's oldest unexpired pending intent for exactly the same
+// amount. It returns the intent's reference, or "" if none fits or the
+// transaction is already linked to an intent.
+func (db *DatabaseService) LinkPaymentToIntent(ctx context.Context, customerID string, amount float64, transactionReference string) (string, error) {
+	query := `
+		WITH candidate AS (
+			SELECT reference FROM payment_intents
+			WHERE customer_id = $1 AND amount = $2::DECIMAL(15, 2)
+			  AND status = 'PENDING' AND expires_at > NOW()
+			  AND NOT EXISTS (SELECT 1 FROM payment_intents WHERE transaction_reference = $3)
+			ORDER BY created_at, reference
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE payment_intents i
+		SET status = 'MATCHED', transaction_reference = $3, matched_at = NOW()
+		FROM candidate
+		WHERE i.reference = candidate.reference
+		RETURNING i.reference
+	`
+
+	var reference string
+	err := db.QueryRow(ctx, query, customerID, amount, transactionReference).Scan(&reference)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	return reference, err
+}
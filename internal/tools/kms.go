@@ -0,0 +1,186 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// KeyProvider wraps and unwraps data keys with a master key it manages.
+// KeyID is the master key new data keys are wrapped under.
+type KeyProvider interface {
+	KeyID() string
+	Wrap(ctx context.Context, plaintext []byte) ([]byte, error)
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+func NewKeyProvider(cfg *Config) (KeyProvider, error) {
+	switch cfg.PIIKeyProvider {
+	case "":
+		return nil, nil
+	case "local":
+		return NewLocalKeyProvider(cfg.PIIMasterKeys)
+	case "aws-kms":
+		return NewAWSKMSKeyProvider(cfg.PIIKMSKeyID, cfg.PIIKMSRegion, cfg.PIIKMSAccessKey, cfg.PIIKMSSecretKey)
+	default:
+		return nil, fmt.Errorf("unknown PII key provider %q", cfg.PIIKeyProvider)
+	}
+}
+
+// LocalKeyProvider keeps master keys in configuration, for development and
+// self-hosted installs. Keys are listed "id:base64key,..." with the active
+// key first; older keys stay listed until data keys are rewrapped.
+type LocalKeyProvider struct {
+	active string
+	keys   map[string][]byte
+}
+
+func NewLocalKeyProvider(spec string) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("master key %q must be id:base64key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %s must be 32 bytes, base64 encoded", id)
+		}
+		if p.active == "" {
+			p.active = id
+		}
+		p.keys[id] = key
+	}
+	if p.active == "" {
+		return nil, fmt.Errorf("no master keys configured")
+	}
+	return p, nil
+}
+
+func (p *LocalKeyProvider) KeyID() string { return p.active }
+
+func (p *LocalKeyProvider) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	return sealAESGCM(p.keys[p.active], plaintext)
+}
+
+func (p *LocalKeyProvider) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %s", keyID)
+	}
+	return openAESGCM(key, wrapped)
+}
+
+// AWSKMSKeyProvider wraps data keys with a KMS key via the Encrypt and
+// Decrypt actions, so master key material never leaves KMS.
+type AWSKMSKeyProvider struct {
+	keyID    string
+	endpoint string
+	signer   sigV4Signer
+	client   *http.Client
+}
+
+func NewAWSKMSKeyProvider(keyID, region, accessKey, secretKey string) (*AWSKMSKeyProvider, error) {
+	if keyID == "" || region == "" {
+		return nil, fmt.Errorf("KMS key id and region are required")
+	}
+	return &AWSKMSKeyProvider{
+		keyID:    keyID,
+		endpoint: fmt.Sprintf("https://kms.%s.amazonaws.com/", region),
+		signer:   sigV4Signer{region: region, service: "kms", accessKey: accessKey, secretKey: secretKey},
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *AWSKMSKeyProvider) KeyID() string { return p.keyID }
+
+func (p *AWSKMSKeyProvider) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var response struct {
+		CiphertextBlob []byte
+	}
+	err := p.call(ctx, "Encrypt", map[string]interface{}{"KeyId": p.keyID, "Plaintext": plaintext}, &response)
+	return response.CiphertextBlob, err
+}
+
+func (p *AWSKMSKeyProvider) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	var response struct {
+		Plaintext []byte
+	}
+	err := p.call(ctx, "Decrypt", map[string]interface{}{"KeyId": keyID, "CiphertextBlob": wrapped}, &response)
+	return response.Plaintext, err
+}
+
+func (p *AWSKMSKeyProvider) call(ctx context.Context, action string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	hash := sha256.Sum256(body)
+	p.signer.sign(req, time.Now().UTC(), hex.EncodeToString(hash[:]))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kms %s failed with status %d: %s", action, resp.StatusCode, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openAESGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
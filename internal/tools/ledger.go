@@ -0,0 +1,140 @@
+package tools
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// LedgerBatch is one page of accounts compared with their processed
+// transactions. Last is the last customer ID compared, to resume after.
+type LedgerBatch struct {
+	Scanned int
+	Last    string
+	Drift   []*api.LedgerDrift
+}
+
+// CheckLedgerBatch recomputes total_paid and payment_count from
+// processed_transactions and the archive totals, less the customer payouts
+// that haven't failed, for up to limit accounts after afterID and returns
+// the ones that disagree. Accounts changed or paid since settledBefore are
+// skipped: a payment being applied updates the account before recording
+// the transaction, so they briefly disagree.
+func (db *DatabaseService) CheckLedgerBatch(ctx context.Context, afterID string, limit int, settledBefore time.Time) (*LedgerBatch, error) {
+	query := `
+		WITH batch AS (
+			SELECT customer_id, total_paid, payment_count, updated_at
+			FROM customer_accounts
+			WHERE customer_id > $1
+			ORDER BY customer_id
+			LIMIT $2
+		)
+		SELECT b.customer_id, b.total_paid, b.payment_count,
+		       COALESCE(t.paid, 0) + COALESCE(a.paid, 0) - COALESCE(o.paid, 0), COALESCE(t.payments, 0) + COALESCE(a.payments, 0),
+		       b.updated_at >= $3 OR COALESCE(t.last_processed >= $3, FALSE)
+		FROM batch b
+		LEFT JOIN LATERAL (
+			SELECT SUM(amount) AS paid, COUNT(*) AS payments, MAX(processed_at) AS last_processed
+			FROM processed_transactions
+			WHERE customer_id = b.customer_id
+		) t ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT SUM(total_paid) AS paid, SUM(payments) AS payments
+			FROM transaction_archive_customers
+			WHERE customer_id = b.customer_id
+		) a ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT SUM(amount) AS paid
+			FROM payouts
+			WHERE customer_id = b.customer_id AND status <> 'FAILED'
+		) o ON TRUE
+		ORDER BY b.customer_id
+	`
+
+	rows, err := db.Query(ctx, query, afterID, limit, settledBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batch := &LedgerBatch{Drift: []*api.LedgerDrift{}}
+	for rows.Next() {
+		var drift api.LedgerDrift
+		var settling bool
+		if err := rows.Scan(&drift.CustomerID, &drift.RecordedTotalPaid, &drift.RecordedPaymentCount,
+			&drift.LedgerTotalPaid, &drift.LedgerPaymentCount, &settling); err != nil {
+			return nil, err
+		}
+		batch.Scanned++
+		batch.Last = drift.CustomerID
+		if settling {
+			continue
+		}
+		if math.Abs(drift.RecordedTotalPaid-drift.LedgerTotalPaid) >= 0.005 || drift.RecordedPaymentCount != drift.LedgerPaymentCount {
+			batch.Drift = append(batch.Drift, &drift)
+		}
+	}
+	return batch, rows.Err()
+}
+
+// RecordLedgerDrift stores the drift found on an account and, with repair,
+// resets the account's totals to the ledger's. The repair only applies if
+// the account still holds the totals that were checked; drift.Repaired
+// reports whether it did.
+func (db *DatabaseService) RecordLedgerDrift(ctx context.Context, drift *api.LedgerDrift, repair bool) error {
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		if repair {
+			tag, err := tx.Exec(ctx, `
+				UPDATE customer_accounts
+				SET total_paid = $2,
+				    payment_count = $3,
+				    version = version + 1,
+				    updated_at = NOW()
+				WHERE customer_id = $1 AND total_paid = $4 AND payment_count = $5
+			`, drift.CustomerID, drift.LedgerTotalPaid, drift.LedgerPaymentCount, drift.RecordedTotalPaid, drift.RecordedPaymentCount)
+			if err != nil {
+				return err
+			}
+			drift.Repaired = tag.RowsAffected() > 0
+		}
+
+		return tx.QueryRow(ctx, `
+			INSERT INTO ledger_drift (customer_id, recorded_total_paid, ledger_total_paid, recorded_payment_count, ledger_payment_count, repaired)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, detected_at
+		`, drift.CustomerID, drift.RecordedTotalPaid, drift.LedgerTotalPaid, drift.RecordedPaymentCount, drift.LedgerPaymentCount,
+			drift.Repaired).Scan(&drift.ID, &drift.DetectedAt)
+	})
+}
+
+// ListLedgerDrift returns the latest drift found, newest first, optionally
+// for one customer.
+func (db *DatabaseService) ListLedgerDrift(ctx context.Context, customerID string, limit int) ([]*api.LedgerDrift, error) {
+	query := `
+		SELECT id, customer_id, recorded_total_paid, ledger_total_paid, recorded_payment_count, ledger_payment_count, repaired, detected_at
+		FROM ledger_drift
+		WHERE ($1 = '' OR customer_id = $1)
+		ORDER BY detected_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drifts := []*api.LedgerDrift{}
+	for rows.Next() {
+		var drift api.LedgerDrift
+		if err := rows.Scan(&drift.ID, &drift.CustomerID, &drift.RecordedTotalPaid, &drift.LedgerTotalPaid,
+			&drift.RecordedPaymentCount, &drift.LedgerPaymentCount, &drift.Repaired, &drift.DetectedAt); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, &drift)
+	}
+	return drifts, rows.Err()
+}
@@ -0,0 +1,36 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+const maintenanceKey = "maintenance_mode"
+
+// GetMaintenance returns the maintenance switch; it is off until first set.
+func (r *RedisService) GetMaintenance(ctx context.Context) (*api.MaintenanceMode, error) {
+	data, err := r.Client.Get(ctx, r.Key(maintenanceKey)).Bytes()
+	if errors.Is(err, ErrNotFound) {
+		return &api.MaintenanceMode{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var mode api.MaintenanceMode
+	if err := json.Unmarshal(data, &mode); err != nil {
+		return nil, err
+	}
+	return &mode, nil
+}
+
+func (r *RedisService) SaveMaintenance(ctx context.Context, mode *api.MaintenanceMode) error {
+	data, err := json.Marshal(mode)
+	if err != nil {
+		return err
+	}
+	return r.Client.Set(ctx, r.Key(maintenanceKey), data, 0).Err()
+}
@@ -0,0 +1,313 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrMergeConflict is returned when two accounts can't be merged as they
+// stand; the wrapped message says why.
+var ErrMergeConflict = errors.New("accounts cannot be merged")
+
+// mergedTables hold per-customer rows that move to the survivor as they are.
+// Portfolio snapshots are immutable and keep the duplicate's ID.
+var mergedTables = []string{
+	"processed_transactions",
+	"payment_history",
+	"customer_identifiers",
+	"promises_to_pay",
+	"account_restructurings",
+	"payment_splits",
+	"payment_archive",
+	"payouts",
+	"bank_feed_transactions",
+	"aml_alerts",
+	"screening_holds",
+	"payment_intents",
+	"virtual_accounts",
+	"card_tokens",
+	"transaction_archive_customers",
+	"reversed_transactions",
+	"disputes",
+	"customer_notes",
+	"notification_log",
+	"fee_charges",
+	"voice_calls",
+}
+
+// mergedSingletons hold at most one row per customer (or per group, for
+// memberships). The survivor's row wins; the duplicate's only moves when
+// the survivor has none.
+var mergedSingletons = map[string]string{
+	"collection_assignments": `TRUE`,
+	"customer_contacts":      `TRUE`,
+	"customer_group_members": `s.group_id = d.group_id`,
+	"card_charges":           `s.installment = d.installment`,
+	"dunning_cases":          `TRUE`,
+}
+
+// FindDuplicateCustomers lists pairs of accounts that look like the same
+// customer, strongest first. metadataKeys are the metadata fields that
+// identify a person, e.g. phone or national_id; equal values (ignoring case
+// and surrounding spaces) are a signal. Encrypted phone identifiers are
+// blind-indexed and only match exactly, which their primary key already
+// prevents, so only plain-text ones are compared.
+func (db *DatabaseService) FindDuplicateCustomers(ctx context.Context, metadataKeys []string, limit int) ([]api.DuplicateCandidate, error) {
+	query := `
+		WITH signals AS (
+			SELECT a.customer_id AS a, b.customer_id AS b, 'metadata:' || k AS signal
+			FROM UNNEST($1::TEXT[]) AS k
+			JOIN customer_accounts a ON COALESCE(TRIM(a.metadata->>k), '') <> ''
+			JOIN customer_accounts b ON b.customer_id > a.customer_id
+			 AND LOWER(TRIM(b.metadata->>k)) = LOWER(TRIM(a.metadata->>k))
+			UNION ALL
+			SELECT a.customer_id, b.customer_id, 'identifier:' || a.identifier_type
+			FROM customer_identifiers a
+			JOIN customer_identifiers b ON b.customer_id > a.customer_id
+			 AND b.identifier_type = a.identifier_type
+			 AND a.identifier_encrypted IS NULL AND b.identifier_encrypted IS NULL
+			 AND CASE WHEN a.identifier_type = 'phone'
+			          THEN RIGHT(a.identifier_value, 10) = RIGHT(b.identifier_value, 10)
+			          ELSE UPPER(TRIM(a.identifier_value)) = UPPER(TRIM(b.identifier_value)) END
+			UNION ALL
+			SELECT a.customer_id, b.customer_id, 'profile'
+			FROM customer_accounts a
+			JOIN customer_accounts b ON b.customer_id > a.customer_id
+			 AND b.asset_value = a.asset_value
+			 AND b.deployment_date::DATE = a.deployment_date::DATE
+			 AND b.region IS NOT DISTINCT FROM a.region
+			 AND b.branch IS NOT DISTINCT FROM a.branch
+			 AND b.metadata = a.metadata
+			WHERE a.metadata <> '{}'
+		)
+		SELECT a, b, ARRAY_AGG(DISTINCT signal ORDER BY signal)
+		FROM signals
+		GROUP BY a, b
+		ORDER BY COUNT(DISTINCT signal) DESC, a, b
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, metadataKeys, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []api.DuplicateCandidate{}
+	for rows.Next() {
+		var a, b string
+		var candidate api.DuplicateCandidate
+		if err := rows.Scan(&a, &b, &candidate.Signals); err != nil {
+			return nil, err
+		}
+		candidate.CustomerIDs = []string{a, b}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// MergeCustomers folds the duplicate account into the survivor in one
+// transaction: the duplicate's payments, identifiers, promises and other
+// rows move to the survivor, its payments count towards the survivor's
+// schedule, and the duplicate account is deleted. The survivor keeps its own
+// asset and terms; the duplicate is kept in the merge record for audit.
+func (db *DatabaseService) MergeCustomers(ctx context.Context, survivorID, duplicateID, mergedBy, reason string) (*api.CustomerMerge, error) {
+	merge := &api.CustomerMerge{
+		SurvivorID:  survivorID,
+		DuplicateID: duplicateID,
+		Moved:       map[string]int64{},
+		Discarded:   map[string]int64{},
+		MergedBy:    mergedBy,
+		Reason:      reason,
+	}
+
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		// Payments of closed settlement days move to the survivor as they
+		// are.
+		if err := allowSettledChanges(ctx, tx); err != nil {
+			return err
+		}
+
+		// Lock in ID order so concurrent merges of the same pair can't
+		// deadlock.
+		rows, err := tx.Query(ctx, `SELECT `+customerColumns+` FROM customer_accounts WHERE customer_id IN ($1, $2) ORDER BY customer_id FOR UPDATE`, survivorID, duplicateID)
+		if err != nil {
+			return err
+		}
+		var survivor *api.CustomerAccount
+		for rows.Next() {
+			account, err := scanCustomer(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if account.CustomerID == survivorID {
+				survivor = account
+			} else {
+				merge.Duplicate = account
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if survivor == nil || merge.Duplicate == nil {
+			return errNoRows
+		}
+
+		if survivor.WrittenOffAt != nil || merge.Duplicate.WrittenOffAt != nil {
+			return fmt.Errorf("%w: written-off accounts cannot be merged", ErrMergeConflict)
+		}
+		var bothPending bool
+		err = tx.QueryRow(ctx, `
+			SELECT COUNT(DISTINCT customer_id) = 2 FROM account_restructurings
+			WHERE customer_id IN ($1, $2) AND status = 'PENDING'
+		`, survivorID, duplicateID).Scan(&bothPending)
+		if err != nil {
+			return err
+		}
+		if bothPending {
+			return fmt.Errorf("%w: both accounts have a pending restructuring", ErrMergeConflict)
+		}
+
+		for table, sameKey := range mergedSingletons {
+			tag, err := tx.Exec(ctx, `
+				UPDATE `+table+` d SET customer_id = $1
+				WHERE d.customer_id = $2
+				  AND NOT EXISTS (SELECT 1 FROM `+table+` s WHERE s.customer_id = $1 AND `+sameKey+`)
+			`, survivorID, duplicateID)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			if tag.RowsAffected() > 0 {
+				merge.Moved[table] = tag.RowsAffected()
+			}
+
+			tag, err = tx.Exec(ctx, `DELETE FROM `+table+` WHERE customer_id = $1`, duplicateID)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			if tag.RowsAffected() > 0 {
+				merge.Discarded[table] = tag.RowsAffected()
+			}
+		}
+
+		for _, table := range mergedTables {
+			tag, err := tx.Exec(ctx, `UPDATE `+table+` SET customer_id = $1 WHERE customer_id = $2`, survivorID, duplicateID)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			if tag.RowsAffected() > 0 {
+				merge.Moved[table] = tag.RowsAffected()
+			}
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE customer_accounts
+			SET total_paid = total_paid + $2,
+			    outstanding_balance = GREATEST(0, outstanding_balance - $2),
+			    payment_count = payment_count + $3,
+			    last_payment_date = GREATEST(last_payment_date, $4),
+			    metadata = COALESCE($5::JSONB, '{}') || metadata,
+			    region = COALESCE(region, $6),
+			    branch = COALESCE(branch, $7),
+			    version = version + 1,
+			    updated_at = NOW()
+			WHERE customer_id = $1
+		`, survivorID, merge.Duplicate.TotalPaid, merge.Duplicate.PaymentCount, merge.Duplicate.LastPaymentDate,
+			merge.Duplicate.Metadata, nullIfEmpty(merge.Duplicate.Region), nullIfEmpty(merge.Duplicate.Branch))
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM customer_accounts WHERE customer_id = $1`, duplicateID); err != nil {
+			return err
+		}
+		// The duplicate's additional accounts now belong to the survivor.
+		tag, err := tx.Exec(ctx, `UPDATE customer_accounts SET holder_id = $1 WHERE holder_id = $2`, survivorID, duplicateID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() > 0 {
+			merge.Moved["customer_accounts"] = tag.RowsAffected()
+		}
+		// Earlier duplicates of the duplicate now resolve to the survivor.
+		if _, err := tx.Exec(ctx, `UPDATE customer_merges SET survivor_id = $1 WHERE survivor_id = $2`, survivorID, duplicateID); err != nil {
+			return err
+		}
+
+		snapshot, err := json.Marshal(merge.Duplicate)
+		if err != nil {
+			return err
+		}
+		moved, _ := json.Marshal(merge.Moved)
+		discarded, _ := json.Marshal(merge.Discarded)
+		return tx.QueryRow(ctx, `
+			INSERT INTO customer_merges (survivor_id, duplicate_id, duplicate_snapshot, moved_rows, discarded_rows, merged_by, reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, merged_at
+		`, survivorID, duplicateID, json.RawMessage(snapshot), json.RawMessage(moved), json.RawMessage(discarded), mergedBy, reason).Scan(&merge.ID, &merge.MergedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merge, nil
+}
+
+func nullIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// MergedInto returns the account a merged duplicate now lives on, or
+// ErrNotFound if the ID was never merged.
+func (db *DatabaseService) MergedInto(ctx context.Context, duplicateID string) (string, error) {
+	var survivorID string
+	err := db.QueryRow(ctx, `SELECT survivor_id FROM customer_merges WHERE duplicate_id = $1`, duplicateID).Scan(&survivorID)
+	return survivorID, err
+}
+
+// ListCustomerMerges lists the merges into a survivor, or all merges when
+// survivorID is empty, newest first.
+func (db *DatabaseService) ListCustomerMerges(ctx context.Context, survivorID string, limit int) ([]*api.CustomerMerge, error) {
+	query := `
+		SELECT id, survivor_id, duplicate_id, duplicate_snapshot, moved_rows, discarded_rows, merged_by, reason, merged_at
+		FROM customer_merges
+		WHERE ($1 = '' OR survivor_id = $1)
+		ORDER BY merged_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, survivorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	merges := []*api.CustomerMerge{}
+	for rows.Next() {
+		var merge api.CustomerMerge
+		var snapshot, moved, discarded []byte
+		if err := rows.Scan(&merge.ID, &merge.SurvivorID, &merge.DuplicateID, &snapshot, &moved, &discarded, &merge.MergedBy, &merge.Reason, &merge.MergedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(snapshot, &merge.Duplicate); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(moved, &merge.Moved); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(discarded, &merge.Discarded); err != nil {
+			return nil, err
+		}
+		merges = append(merges, &merge)
+	}
+	return merges, rows.Err()
+}
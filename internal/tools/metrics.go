@@ -0,0 +1,169 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const summaryWindowSize = 1024
+
+var DefaultMetrics = NewMetrics()
+
+type Metrics struct {
+	mu        sync.Mutex
+	counters  map[string]float64
+	gauges    map[string]float64
+	summaries map[string]*summary
+}
+
+type summary struct {
+	values []float64
+	next   int
+	count  uint64
+	sum    float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters:  make(map[string]float64),
+		gauges:    make(map[string]float64),
+		summaries: make(map[string]*summary),
+	}
+}
+
+func (m *Metrics) Inc(name string, delta float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[seriesKey(name, labels)] += delta
+}
+
+func (m *Metrics) Set(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[seriesKey(name, labels)] = value
+}
+
+func (m *Metrics) Observe(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := seriesKey(name, labels)
+	s, ok := m.summaries[key]
+	if !ok {
+		s = &summary{values: make([]float64, 0, summaryWindowSize)}
+		m.summaries[key] = s
+	}
+
+	if len(s.values) < summaryWindowSize {
+		s.values = append(s.values, value)
+	} else {
+		s.values[s.next] = value
+		s.next = (s.next + 1) % summaryWindowSize
+	}
+	s.count++
+	s.sum += value
+}
+
+func (m *Metrics) Quantiles(name string, qs ...float64) []float64 {
+	m.mu.Lock()
+	s, ok := m.summaries[name]
+	var values []float64
+	if ok {
+		values = append(values, s.values...)
+	}
+	m.mu.Unlock()
+
+	return quantiles(values, qs)
+}
+
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	for _, key := range sortedKeys(m.counters) {
+		fmt.Fprintf(&b, "%s %g\n", key, m.counters[key])
+	}
+	for _, key := range sortedKeys(m.gauges) {
+		fmt.Fprintf(&b, "%s %g\n", key, m.gauges[key])
+	}
+
+	summaryKeys := make([]string, 0, len(m.summaries))
+	for key := range m.summaries {
+		summaryKeys = append(summaryKeys, key)
+	}
+	sort.Strings(summaryKeys)
+
+	for _, key := range summaryKeys {
+		s := m.summaries[key]
+		name, labels := splitSeriesKey(key)
+		qs := []float64{0.5, 0.95, 0.99}
+		for i, value := range quantiles(append([]float64(nil), s.values...), qs) {
+			fmt.Fprintf(&b, "%s{%s} %g\n", name, joinLabels(labels, fmt.Sprintf(`quantile="%g"`, qs[i])), value)
+		}
+		fmt.Fprintf(&b, "%s_sum%s %g\n", name, wrapLabels(labels), s.sum)
+		fmt.Fprintf(&b, "%s_count%s %d\n", name, wrapLabels(labels), s.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func quantiles(values []float64, qs []float64) []float64 {
+	result := make([]float64, len(qs))
+	if len(values) == 0 {
+		return result
+	}
+
+	sort.Float64s(values)
+	for i, q := range qs {
+		idx := int(q * float64(len(values)-1))
+		result[i] = values[idx]
+	}
+	return result
+}
+
+func seriesKey(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func splitSeriesKey(key string) (string, string) {
+	if idx := strings.IndexByte(key, '{'); idx >= 0 {
+		return key[:idx], strings.TrimSuffix(key[idx+1:], "}")
+	}
+	return key, ""
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,102 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Migration is one db/migrations file. Its version is the file name
+// without .sql, e.g. 007_webhook_event_log.
+type Migration struct {
+	Version string
+	Path    string
+}
+
+// LoadMigrations lists the migrations in dir in the order they apply.
+func LoadMigrations(dir string) ([]Migration, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	migrations := make([]Migration, 0, len(paths))
+	for _, path := range paths {
+		migrations = append(migrations, Migration{
+			Version: strings.TrimSuffix(filepath.Base(path), ".sql"),
+			Path:    path,
+		})
+	}
+	return migrations, nil
+}
+
+// SchemaInitialized reports whether init.sql has been applied.
+func (db *DatabaseService) SchemaInitialized(ctx context.Context) (bool, error) {
+	var exists bool
+	err := db.QueryRow(ctx, `SELECT to_regclass('customer_accounts') IS NOT NULL`).Scan(&exists)
+	return exists, err
+}
+
+// AppliedMigrations returns the versions recorded in schema_migrations. The
+// table is created by init.sql; a database from before it has no record,
+// reported by tracked being false.
+func (db *DatabaseService) AppliedMigrations(ctx context.Context) (applied map[string]bool, tracked bool, err error) {
+	if err := db.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&tracked); err != nil || !tracked {
+		return nil, false, err
+	}
+
+	rows, err := db.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, true, err
+	}
+	defer rows.Close()
+
+	applied = map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, true, err
+		}
+		applied[version] = true
+	}
+	return applied, true, rows.Err()
+}
+
+// ApplySQLFile runs a schema or migration file. Files manage their own
+// transactions, and can run for longer than the query timeout allows.
+func (db *DatabaseService) ApplySQLFile(ctx context.Context, path string) error {
+	sql, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Without arguments pgx sends the file as one simple query, which may
+	// hold many statements.
+	if _, err := db.Pool.Exec(ctx, string(sql)); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// RecordMigrations marks migrations as applied, whether they were just run
+// or are already reflected in the schema.
+func (db *DatabaseService) RecordMigrations(ctx context.Context, versions ...string) error {
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(100) PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `
+		INSERT INTO schema_migrations (version)
+		SELECT UNNEST($1::TEXT[])
+		ON CONFLICT (version) DO NOTHING
+	`, versions)
+	return err
+}
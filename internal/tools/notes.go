@@ -0,0 +1,121 @@
+package tools
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const customerNoteColumns = `
+	id, customer_id, COALESCE(transaction_reference, ''), author, body, tags, created_at, updated_at
+`
+
+func scanCustomerNote(row pgx.Row) (*api.CustomerNote, error) {
+	var note api.CustomerNote
+	err := row.Scan(
+		&note.ID,
+		&note.CustomerID,
+		&note.TransactionReference,
+		&note.Author,
+		&note.Body,
+		&note.Tags,
+		&note.CreatedAt,
+		&note.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// NormalizeNoteTags lowercases and trims tags, dropping blanks and
+// duplicates, so "Fraud " and "fraud" are one tag.
+func NormalizeNoteTags(tags []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func (db *DatabaseService) CreateCustomerNote(ctx context.Context, note *api.CustomerNote) (*api.CustomerNote, error) {
+	query := `
+		INSERT INTO customer_notes (customer_id, transaction_reference, author, body, tags)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5)
+		RETURNING ` + customerNoteColumns
+
+	return scanCustomerNote(db.QueryRow(ctx, query,
+		note.CustomerID, note.TransactionReference, note.Author, note.Body, NormalizeNoteTags(note.Tags)))
+}
+
+func (db *DatabaseService) GetCustomerNote(ctx context.Context, customerID string, id int64) (*api.CustomerNote, error) {
+	query := `SELECT ` + customerNoteColumns + ` FROM customer_notes WHERE customer_id = $1 AND id = $2`
+	return scanCustomerNote(db.QueryRow(ctx, query, customerID, id))
+}
+
+// ListCustomerNotes returns the account's notes, newest first. A reference
+// keeps only the notes on that payment, and a tag only those carrying it.
+func (db *DatabaseService) ListCustomerNotes(ctx context.Context, customerID, reference, tag string, limit int) ([]*api.CustomerNote, error) {
+	query := `
+		SELECT ` + customerNoteColumns + `
+		FROM customer_notes
+		WHERE customer_id = $1
+		  AND ($2 = '' OR transaction_reference = $2)
+		  AND ($3 = '' OR tags @> ARRAY[$3])
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`
+
+	rows, err := db.Query(ctx, query, customerID, reference, strings.ToLower(strings.TrimSpace(tag)), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []*api.CustomerNote{}
+	for rows.Next() {
+		note, err := scanCustomerNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// UpdateCustomerNote applies update to the note. It returns ErrNotFound
+// if the account has no such note.
+func (db *DatabaseService) UpdateCustomerNote(ctx context.Context, customerID string, id int64, update api.CustomerNoteUpdate) (*api.CustomerNote, error) {
+	var tags []string
+	if update.Tags != nil {
+		tags = NormalizeNoteTags(update.Tags)
+	}
+
+	query := `
+		UPDATE customer_notes
+		SET body = COALESCE($3, body),
+		    tags = COALESCE($4, tags),
+		    updated_at = NOW()
+		WHERE customer_id = $1 AND id = $2
+		RETURNING ` + customerNoteColumns
+
+	return scanCustomerNote(db.QueryRow(ctx, query, customerID, id, update.Body, tags))
+}
+
+func (db *DatabaseService) DeleteCustomerNote(ctx context.Context, customerID string, id int64) (bool, error) {
+	tag, err := db.Exec(ctx, `DELETE FROM customer_notes WHERE customer_id = $1 AND id = $2`, customerID, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
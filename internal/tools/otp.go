@@ -0,0 +1,79 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const maxOTPAttempts = 5
+
+func GenerateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func hashOTP(subject, code string) string {
+	sum := sha256.Sum256([]byte(subject + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *RedisService) StoreOTP(ctx context.Context, subject, code string, ttl, cooldown time.Duration) (bool, error) {
+	allowed, err := r.StartOTPCooldown(ctx, subject, cooldown)
+	if err != nil || !allowed {
+		return false, err
+	}
+	return true, r.SaveOTP(ctx, subject, code, ttl)
+}
+
+// StartOTPCooldown reports whether a code may be sent for subject, and if
+// so holds off the next one for cooldown.
+func (r *RedisService) StartOTPCooldown(ctx context.Context, subject string, cooldown time.Duration) (bool, error) {
+	return r.Client.SetNX(ctx, r.Key("otp_cooldown:"+subject), "1", cooldown).Result()
+}
+
+// SaveOTP stores the code for subject, replacing any earlier one and its
+// failed attempts.
+func (r *RedisService) SaveOTP(ctx context.Context, subject, code string, ttl time.Duration) error {
+	pipe := r.Client.TxPipeline()
+	pipe.SetEX(ctx, r.Key("otp:"+subject), hashOTP(subject, code), ttl)
+	pipe.Del(ctx, r.Key("otp_attempts:"+subject))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisService) VerifyOTP(ctx context.Context, subject, code string) (bool, error) {
+	attempts, err := r.Client.Incr(ctx, r.Key("otp_attempts:"+subject)).Result()
+	if err != nil {
+		return false, err
+	}
+	r.Client.Expire(ctx, r.Key("otp_attempts:"+subject), 15*time.Minute)
+	if attempts > maxOTPAttempts {
+		r.Client.Del(ctx, r.Key("otp:"+subject))
+		return false, nil
+	}
+
+	stored, err := r.Client.Get(ctx, r.Key("otp:"+subject)).Result()
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(hashOTP(subject, code))) != 1 {
+		return false, nil
+	}
+
+	r.Client.Del(ctx, r.Key("otp:"+subject), r.Key("otp_attempts:"+subject))
+	return true, nil
+}
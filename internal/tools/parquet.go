@@ -0,0 +1,421 @@
+package tools
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// A minimal Parquet writer, enough for the transaction archive: one row
+// group, one PLAIN-encoded, gzip-compressed data page per column and flat
+// schemas of required or optional columns. The footer is Thrift's compact
+// protocol, written by hand like the S3 client's signing.
+
+// ParquetKind is the logical type of a Parquet column.
+type ParquetKind int
+
+const (
+	// ParquetString is UTF-8 text; values are strings.
+	ParquetString ParquetKind = iota
+	// ParquetInt64 values are int64.
+	ParquetInt64
+	// ParquetCents is DECIMAL(15, 2) stored as int64 cents; values are
+	// float64 amounts.
+	ParquetCents
+	// ParquetTimestamp is milliseconds since the epoch; values are
+	// time.Time.
+	ParquetTimestamp
+	// ParquetDate is days since the epoch; values are time.Time.
+	ParquetDate
+	// ParquetBool values are bool.
+	ParquetBool
+)
+
+// ParquetField is one column of a Parquet schema. Optional columns accept
+// nil values.
+type ParquetField struct {
+	Name     string
+	Kind     ParquetKind
+	Optional bool
+}
+
+// Parquet's Thrift enums.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetDecimal         = 5
+	parquetDate            = 6
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetGzip = 2
+)
+
+// ParquetWriter buffers rows column by column and writes them out as one
+// file.
+type ParquetWriter struct {
+	fields  []ParquetField
+	columns []parquetColumn
+	rows    int
+}
+
+type parquetColumn struct {
+	values  bytes.Buffer
+	defined []bool
+	bools   []bool
+}
+
+func NewParquetWriter(fields []ParquetField) *ParquetWriter {
+	return &ParquetWriter{fields: fields, columns: make([]parquetColumn, len(fields))}
+}
+
+// Rows is the number of rows appended so far.
+func (w *ParquetWriter) Rows() int { return w.rows }
+
+// Append adds a row, one value per field in schema order.
+func (w *ParquetWriter) Append(values ...interface{}) error {
+	if len(values) != len(w.fields) {
+		return fmt.Errorf("parquet: got %d values for %d columns", len(values), len(w.fields))
+	}
+	// Check the whole row first so a bad value doesn't leave the columns
+	// uneven.
+	for i, field := range w.fields {
+		if err := field.check(values[i]); err != nil {
+			return err
+		}
+	}
+
+	for i, field := range w.fields {
+		column := &w.columns[i]
+		value := values[i]
+		if field.Optional {
+			column.defined = append(column.defined, value != nil)
+		}
+		if value == nil {
+			continue
+		}
+
+		var scratch [8]byte
+		switch field.Kind {
+		case ParquetString:
+			s := value.(string)
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(len(s)))
+			column.values.Write(scratch[:4])
+			column.values.WriteString(s)
+		case ParquetInt64:
+			binary.LittleEndian.PutUint64(scratch[:], uint64(value.(int64)))
+			column.values.Write(scratch[:])
+		case ParquetCents:
+			binary.LittleEndian.PutUint64(scratch[:], uint64(int64(math.Round(value.(float64)*100))))
+			column.values.Write(scratch[:])
+		case ParquetTimestamp:
+			binary.LittleEndian.PutUint64(scratch[:], uint64(value.(time.Time).UnixMilli()))
+			column.values.Write(scratch[:])
+		case ParquetDate:
+			t := value.(time.Time)
+			days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(int32(days)))
+			column.values.Write(scratch[:4])
+		case ParquetBool:
+			column.bools = append(column.bools, value.(bool))
+		}
+	}
+	w.rows++
+	return nil
+}
+
+func (f ParquetField) check(value interface{}) error {
+	if value == nil {
+		if !f.Optional {
+			return fmt.Errorf("parquet: %s is required", f.Name)
+		}
+		return nil
+	}
+
+	ok := false
+	switch f.Kind {
+	case ParquetString:
+		_, ok = value.(string)
+	case ParquetInt64:
+		_, ok = value.(int64)
+	case ParquetCents:
+		_, ok = value.(float64)
+	case ParquetTimestamp, ParquetDate:
+		_, ok = value.(time.Time)
+	case ParquetBool:
+		_, ok = value.(bool)
+	}
+	if !ok {
+		return fmt.Errorf("parquet: %s can't hold %T", f.Name, value)
+	}
+	return nil
+}
+
+func (f ParquetField) physicalType() int32 {
+	switch f.Kind {
+	case ParquetString:
+		return parquetByteArray
+	case ParquetDate:
+		return parquetInt32
+	case ParquetBool:
+		return parquetBoolean
+	default:
+		return parquetInt64
+	}
+}
+
+// WriteTo writes the buffered rows as a Parquet file.
+func (w *ParquetWriter) WriteTo(out io.Writer) (int64, error) {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	chunks := make([]*thriftCompact, len(w.fields))
+	var totalSize int64
+	for i, field := range w.fields {
+		page := w.columns[i].page(field)
+		compressed, err := gzipBytes(page)
+		if err != nil {
+			return 0, err
+		}
+
+		header := &thriftCompact{}
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(compressed)))
+		header.beginStruct(5)
+		header.i32(1, int32(w.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.stop()
+
+		offset := int64(file.Len())
+		file.Write(header.Bytes())
+		file.Write(compressed)
+		uncompressedSize := int64(header.Len() + len(page))
+		compressedSize := int64(header.Len() + len(compressed))
+		totalSize += uncompressedSize
+
+		chunk := &thriftCompact{}
+		chunk.i64(2, offset)
+		chunk.beginStruct(3)
+		chunk.i32(1, field.physicalType())
+		chunk.beginList(2, thriftI32, 2)
+		chunk.listI32(parquetPlain)
+		chunk.listI32(parquetRLE)
+		chunk.beginList(3, thriftBinary, 1)
+		chunk.listString(field.Name)
+		chunk.i32(4, parquetGzip)
+		chunk.i64(5, int64(w.rows))
+		chunk.i64(6, uncompressedSize)
+		chunk.i64(7, compressedSize)
+		chunk.i64(9, offset)
+		chunk.endStruct()
+		chunks[i] = chunk
+	}
+
+	meta := &thriftCompact{}
+	meta.i32(1, 1)
+	meta.beginList(2, thriftStruct, len(w.fields)+1)
+	meta.listStruct(func(root *thriftCompact) {
+		root.string(4, "schema")
+		root.i32(5, int32(len(w.fields)))
+	})
+	for _, field := range w.fields {
+		meta.listStruct(func(element *thriftCompact) {
+			element.i32(1, field.physicalType())
+			repetition := int32(0)
+			if field.Optional {
+				repetition = 1
+			}
+			element.i32(3, repetition)
+			element.string(4, field.Name)
+			switch field.Kind {
+			case ParquetString:
+				element.i32(6, parquetUTF8)
+			case ParquetCents:
+				element.i32(6, parquetDecimal)
+				element.i32(7, 2)
+				element.i32(8, 15)
+			case ParquetTimestamp:
+				element.i32(6, parquetTimestampMillis)
+			case ParquetDate:
+				element.i32(6, parquetDate)
+			}
+		})
+	}
+	meta.i64(3, int64(w.rows))
+	meta.beginList(4, thriftStruct, 1)
+	meta.listStruct(func(group *thriftCompact) {
+		group.beginList(1, thriftStruct, len(chunks))
+		for _, chunk := range chunks {
+			group.listStruct(func(c *thriftCompact) { c.Write(chunk.Bytes()) })
+		}
+		group.i64(2, totalSize)
+		group.i64(3, int64(w.rows))
+	})
+	meta.string(6, "go_payment")
+	meta.stop()
+
+	file.Write(meta.Bytes())
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(meta.Len()))
+	file.Write(length[:])
+	file.WriteString("PAR1")
+
+	return file.WriteTo(out)
+}
+
+// page is the column's uncompressed v1 data page: definition levels for
+// optional columns, then the PLAIN-encoded values.
+func (c *parquetColumn) page(field ParquetField) []byte {
+	var page bytes.Buffer
+	if field.Optional {
+		levels := bitPackedRun(c.defined)
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(levels)))
+		page.Write(length[:])
+		page.Write(levels)
+	}
+	if field.Kind == ParquetBool {
+		page.Write(packBits(c.bools))
+	} else {
+		page.Write(c.values.Bytes())
+	}
+	return page.Bytes()
+}
+
+// bitPackedRun encodes 1-bit values as a single bit-packed run of the
+// RLE/bit-packing hybrid encoding.
+func bitPackedRun(values []bool) []byte {
+	groups := (len(values) + 7) / 8
+	run := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	return append(run, packBits(values)...)
+}
+
+// packBits packs values into bytes, least significant bit first.
+func packBits(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Thrift compact protocol type codes.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact writes a Thrift struct in the compact protocol. Field IDs
+// must be written in increasing order.
+type thriftCompact struct {
+	bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+func (t *thriftCompact) field(id int16, kind byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.WriteByte(kind)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftCompact) varint(v int64) {
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftCompact) uvarint(v uint64) {
+	t.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftCompact) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftCompact) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftCompact) string(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listString(s)
+}
+
+func (t *thriftCompact) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftCompact) endStruct() {
+	t.stop()
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftCompact) stop() {
+	t.WriteByte(0)
+}
+
+func (t *thriftCompact) beginList(id int16, elem byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.WriteByte(byte(size)<<4 | elem)
+	} else {
+		t.WriteByte(0xf0 | elem)
+		t.uvarint(uint64(size))
+	}
+}
+
+func (t *thriftCompact) listI32(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftCompact) listString(s string) {
+	t.uvarint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+// listStruct writes one struct element of a list.
+func (t *thriftCompact) listStruct(write func(*thriftCompact)) {
+	element := &thriftCompact{}
+	write(element)
+	element.stop()
+	t.Write(element.Bytes())
+}
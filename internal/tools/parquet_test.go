@@ -0,0 +1,113 @@
+package tools
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// readCompactStruct reads a flat compact-protocol struct from data and
+// returns its integer fields and its length. Nested structs are read and
+// dropped.
+func readCompactStruct(t *testing.T, data []byte) (map[int16]int64, int) {
+	t.Helper()
+	fields := map[int16]int64{}
+	pos := 0
+	var last int16
+	for {
+		header := data[pos]
+		pos++
+		if header == 0 {
+			return fields, pos
+		}
+		kind := header & 0x0f
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			v, n := binary.Varint(data[pos:])
+			id, pos = int16(v), pos+n
+		}
+		last = id
+		switch kind {
+		case thriftI32, thriftI64:
+			v, n := binary.Varint(data[pos:])
+			fields[id], pos = v, pos+n
+		case thriftStruct:
+			_, n := readCompactStruct(t, data[pos:])
+			pos += n
+		default:
+			t.Fatalf("unexpected field type %d", kind)
+		}
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	w := NewParquetWriter([]ParquetField{
+		{Name: "amount", Kind: ParquetCents},
+		{Name: "agent_id", Kind: ParquetString, Optional: true},
+	})
+	processed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := w.Append(12.5, "A1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(0.1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(processed, "A2"); err == nil {
+		t.Error("Append accepted a time for a decimal column")
+	}
+	if err := w.Append(nil, "A2"); err == nil {
+		t.Error("Append accepted a null in a required column")
+	}
+	if w.Rows() != 2 {
+		t.Fatalf("Rows() = %d, want 2", w.Rows())
+	}
+
+	var out bytes.Buffer
+	if _, err := w.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	file := out.Bytes()
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Fatal("file is not framed by PAR1")
+	}
+	footer := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	if footer <= 0 || footer > len(file)-12 {
+		t.Fatalf("footer length %d out of range", footer)
+	}
+
+	readPage := func(offset int) ([]byte, int) {
+		header, n := readCompactStruct(t, file[offset:])
+		compressed := file[offset+n : offset+n+int(header[3])]
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != int(header[2]) {
+			t.Fatalf("page is %d bytes, header says %d", len(page), header[2])
+		}
+		return page, offset + n + len(compressed)
+	}
+
+	amounts, next := readPage(4)
+	if got := int64(binary.LittleEndian.Uint64(amounts)); len(amounts) != 16 || got != 1250 {
+		t.Errorf("first amount = %d cents in a %d-byte page, want 1250 in 16", got, len(amounts))
+	}
+	if got := int64(binary.LittleEndian.Uint64(amounts[8:])); got != 10 {
+		t.Errorf("second amount = %d cents, want 10", got)
+	}
+
+	agents, _ := readPage(next)
+	// Definition levels: a 2-byte bit-packed run marking the first row
+	// present, then the one value.
+	want := []byte{2, 0, 0, 0, 0x03, 0x01, 2, 0, 0, 0, 'A', '1'}
+	if !bytes.Equal(agents, want) {
+		t.Errorf("agent_id page = %v, want %v", agents, want)
+	}
+}
@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	transactionsTable            = "processed_transactions"
+	transactionsDefaultPartition = "processed_transactions_default"
+	partitionSuffixLayout        = "y2006m01"
+)
+
+// TransactionPartition is a monthly partition of processed_transactions.
+type TransactionPartition struct {
+	Name     string    `json:"name"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Attached bool      `json:"attached"`
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func transactionPartition(month time.Time) TransactionPartition {
+	from := monthStart(month)
+	return TransactionPartition{
+		Name: transactionsTable + "_" + from.Format(partitionSuffixLayout),
+		From: from,
+		To:   from.AddDate(0, 1, 0),
+	}
+}
+
+// parseTransactionPartition recognizes the partitions
+// EnsureTransactionPartitions creates by their name.
+func parseTransactionPartition(name string) (TransactionPartition, bool) {
+	prefix := transactionsTable + "_"
+	if len(name) <= len(prefix) || name[:len(prefix)] != prefix {
+		return TransactionPartition{}, false
+	}
+	month, err := time.Parse(partitionSuffixLayout, name[len(prefix):])
+	if err != nil {
+		return TransactionPartition{}, false
+	}
+	return transactionPartition(month), true
+}
+
+// IsTransactionsPartitioned reports whether processed_transactions has been
+// converted to the partitioned layout (see db/migrations).
+func (db *DatabaseService) IsTransactionsPartitioned(ctx context.Context) (bool, error) {
+	var partitioned bool
+	err := db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM pg_partitioned_table WHERE partrelid = $1::REGCLASS)`, transactionsTable).Scan(&partitioned)
+	return partitioned, err
+}
+
+// ListTransactionPartitions returns the monthly partitions, attached or
+// detached, in month order. Detached ones are found by name.
+func (db *DatabaseService) ListTransactionPartitions(ctx context.Context) ([]TransactionPartition, error) {
+	rows, err := db.Query(ctx, `
+		SELECT c.relname, i.inhrelid IS NOT NULL
+		FROM pg_class c
+		LEFT JOIN pg_inherits i ON i.inhrelid = c.oid AND i.inhparent = $1::REGCLASS
+		WHERE c.relkind IN ('r', 'p') AND c.relname LIKE $2 AND c.relnamespace = 'public'::REGNAMESPACE
+		ORDER BY c.relname
+	`, transactionsTable, transactionsTable+`\_y%`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := []TransactionPartition{}
+	for rows.Next() {
+		var name string
+		var attached bool
+		if err := rows.Scan(&name, &attached); err != nil {
+			return nil, err
+		}
+		if partition, ok := parseTransactionPartition(name); ok {
+			partition.Attached = attached
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions, rows.Err()
+}
+
+// EnsureTransactionPartitions creates the monthly partitions from the month
+// of from through months after it that don't exist yet, and returns their
+// names. Rows already in the default partition for a new month are moved
+// into it, as Postgres won't attach a partition over them.
+func (db *DatabaseService) EnsureTransactionPartitions(ctx context.Context, from time.Time, months int) ([]string, error) {
+	existing, err := db.ListTransactionPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	have := map[string]bool{}
+	for _, partition := range existing {
+		have[partition.Name] = true
+	}
+
+	created := []string{}
+	for i := 0; i <= months; i++ {
+		partition := transactionPartition(monthStart(from).AddDate(0, i, 0))
+		if have[partition.Name] {
+			continue
+		}
+		if err := db.createTransactionPartition(ctx, partition); err != nil {
+			return created, fmt.Errorf("%s: %w", partition.Name, err)
+		}
+		created = append(created, partition.Name)
+	}
+	return created, nil
+}
+
+func (db *DatabaseService) createTransactionPartition(ctx context.Context, partition TransactionPartition) error {
+	// DDL takes no parameters; the name and bounds are generated above.
+	from, to := partition.From.Format("2006-01-02"), partition.To.Format("2006-01-02")
+	name := pgx.Identifier{partition.Name}.Sanitize()
+
+	return db.inTx(ctx, func(tx pgx.Tx) error {
+		statements := []string{
+			`CREATE TABLE ` + name + ` (LIKE ` + transactionsTable + ` INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`,
+			`WITH moved AS (
+				DELETE FROM ` + transactionsDefaultPartition + `
+				WHERE processed_at >= '` + from + `' AND processed_at < '` + to + `'
+				RETURNING *
+			)
+			INSERT INTO ` + name + ` SELECT * FROM moved`,
+			`ALTER TABLE ` + transactionsTable + ` ATTACH PARTITION ` + name + ` FOR VALUES FROM ('` + from + `') TO ('` + to + `')`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DetachTransactionPartitions detaches the attached monthly partitions that
+// end on or before before, and returns their names. Detached partitions
+// keep their rows as standalone tables for archiving or dropping by hand;
+// their references stay in processed_references, so the transactions are
+// still recognized as duplicates.
+func (db *DatabaseService) DetachTransactionPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	partitions, err := db.ListTransactionPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	detached := []string{}
+	for _, partition := range partitions {
+		if !partition.Attached || partition.To.After(before) {
+			continue
+		}
+		name := pgx.Identifier{partition.Name}.Sanitize()
+		if _, err := db.Exec(ctx, `ALTER TABLE `+transactionsTable+` DETACH PARTITION `+name); err != nil {
+			return detached, fmt.Errorf("%s: %w", partition.Name, err)
+		}
+		detached = append(detached, partition.Name)
+	}
+	return detached, nil
+}
@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+const paymentAttemptColumns = `id, transaction_reference, customer_id, COALESCE(instance, ''), worker_id, started_at,
+	queued_ms, duration_ms, version_conflicts, outcome, COALESCE(error, '')`
+
+// RecordPaymentAttempt logs one processing attempt of a payment.
+func (db *DatabaseService) RecordPaymentAttempt(ctx context.Context, attempt *api.PaymentAttempt) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO payment_attempts (transaction_reference, customer_id, instance, worker_id, started_at,
+		                              queued_ms, duration_ms, version_conflicts, outcome, error)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, NULLIF($10, ''))
+	`, attempt.TransactionReference, attempt.CustomerID, attempt.Instance, attempt.WorkerID, attempt.StartedAt,
+		attempt.QueuedMs, attempt.DurationMs, attempt.VersionConflicts, attempt.Outcome, attempt.Error)
+	return err
+}
+
+// ListPaymentAttempts returns every attempt at the payment, oldest first.
+func (db *DatabaseService) ListPaymentAttempts(ctx context.Context, reference string) ([]*api.PaymentAttempt, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+paymentAttemptColumns+`
+		FROM payment_attempts
+		WHERE transaction_reference = $1
+		ORDER BY started_at, id
+	`, reference)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*api.PaymentAttempt{}
+	for rows.Next() {
+		var attempt api.PaymentAttempt
+		err := rows.Scan(&attempt.ID, &attempt.TransactionReference, &attempt.CustomerID, &attempt.Instance, &attempt.WorkerID,
+			&attempt.StartedAt, &attempt.QueuedMs, &attempt.DurationMs, &attempt.VersionConflicts, &attempt.Outcome, &attempt.Error)
+		if err != nil {
+			return nil, err
+		}
+		if attempt.WorkerID != nil {
+			attempt.Worker = WorkerName(attempt.Instance, *attempt.WorkerID)
+		}
+		attempts = append(attempts, &attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// WorkerName identifies a payment worker across instances as
+// "<instance>/<index>", or just the index on an instance without a name. It
+// is the worker field in logs and on attempts.
+func WorkerName(instance string, index int) string {
+	if instance == "" {
+		return strconv.Itoa(index)
+	}
+	return instance + "/" + strconv.Itoa(index)
+}
@@ -0,0 +1,254 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrPayoutExceedsPaid is returned for a customer payout larger than what
+// the customer has paid.
+var ErrPayoutExceedsPaid = errors.New("payout exceeds what the customer has paid")
+
+const payoutColumns = `
+	id, reference, payout_type, COALESCE(customer_id, ''), COALESCE(agent_id, ''),
+	amount, currency, method, destination, status, COALESCE(provider, ''),
+	COALESCE(provider_reference, ''), COALESCE(failure_reason, ''), attempts,
+	created_at, updated_at
+`
+
+func scanPayout(row interface{ Scan(...any) error }) (*api.Payout, error) {
+	var payout api.Payout
+	err := row.Scan(
+		&payout.ID,
+		&payout.Reference,
+		&payout.PayoutType,
+		&payout.CustomerID,
+		&payout.AgentID,
+		&payout.Amount,
+		&payout.Currency,
+		&payout.Method,
+		&payout.Destination,
+		&payout.Status,
+		&payout.Provider,
+		&payout.ProviderReference,
+		&payout.FailureReason,
+		&payout.Attempts,
+		&payout.CreatedAt,
+		&payout.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
+
+// CreatePayout stores a payout, or returns the existing one with false if
+// the reference is taken. A customer payout is taken off the customer's
+// account in the same transaction, as reversing that much of their
+// payments would; it returns ErrNotFound for an unknown customer and
+// ErrPayoutExceedsPaid for more than they have paid.
+func (db *DatabaseService) CreatePayout(ctx context.Context, request *api.PayoutRequest) (*api.Payout, bool, error) {
+	currency := request.Currency
+	if currency == "" {
+		currency = "NGN"
+	}
+	destination, err := json.Marshal(request.Destination)
+	if err != nil {
+		return nil, false, err
+	}
+
+	query := `
+		INSERT INTO payouts (reference, payout_type, customer_id, agent_id, amount, currency, method, destination)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8)
+		ON CONFLICT (reference) DO NOTHING
+		RETURNING ` + payoutColumns
+
+	var payout *api.Payout
+	err = db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		payout, err = scanPayout(tx.QueryRow(ctx, query,
+			request.Reference, request.PayoutType, request.CustomerID, request.AgentID,
+			request.Amount, currency, request.Method, json.RawMessage(destination),
+		))
+		if errors.Is(err, ErrNotFound) {
+			payout = nil
+			return nil
+		}
+		if err != nil || payout.CustomerID == "" {
+			return err
+		}
+		return debitPayout(ctx, tx, payout)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if payout != nil {
+		return payout, true, nil
+	}
+
+	existing, err := db.GetPayout(ctx, request.Reference)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+// debitPayout takes a customer payout off the account.
+func debitPayout(ctx context.Context, tx pgx.Tx, payout *api.Payout) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE customer_accounts
+		SET total_paid = total_paid - $2,
+		    outstanding_balance = outstanding_balance + $2,
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE customer_id = $1 AND total_paid >= $2
+	`, payout.CustomerID, payout.Amount)
+	if err != nil || tag.RowsAffected() == 1 {
+		return err
+	}
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM customer_accounts WHERE customer_id = $1)`, payout.CustomerID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("customer %s: %w", payout.CustomerID, ErrNotFound)
+	}
+	return ErrPayoutExceedsPaid
+}
+
+// creditFailedPayout puts a customer payout that failed for good back on
+// the account.
+func creditFailedPayout(ctx context.Context, tx pgx.Tx, payout *api.Payout) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE customer_accounts
+		SET total_paid = total_paid + $2,
+		    outstanding_balance = GREATEST(outstanding_balance - $2, 0),
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE customer_id = $1
+	`, payout.CustomerID, payout.Amount)
+	return err
+}
+
+func (db *DatabaseService) GetPayout(ctx context.Context, reference string) (*api.Payout, error) {
+	query := `SELECT ` + payoutColumns + ` FROM payouts WHERE reference = $1`
+	return scanPayout(db.QueryRow(ctx, query, reference))
+}
+
+func (db *DatabaseService) ListPayouts(ctx context.Context, status string, limit, offset int) ([]*api.Payout, error) {
+	query := `
+		SELECT ` + payoutColumns + `
+		FROM payouts
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := db.Query(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payouts := []*api.Payout{}
+	for rows.Next() {
+		payout, err := scanPayout(rows)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, payout)
+	}
+	return payouts, rows.Err()
+}
+
+// ClaimPayout moves a PENDING payout that is due into PROCESSING so only
+// one worker sends it.
+func (db *DatabaseService) ClaimPayout(ctx context.Context, reference string) (*api.Payout, error) {
+	query := `
+		UPDATE payouts
+		SET status = 'PROCESSING', attempts = attempts + 1, updated_at = NOW()
+		WHERE reference = $1 AND status = 'PENDING' AND next_attempt_at <= NOW()
+		RETURNING ` + payoutColumns
+
+	return scanPayout(db.QueryRow(ctx, query, reference))
+}
+
+// RetryPayout puts a payout whose attempt failed back to PENDING, due
+// again after delay.
+func (db *DatabaseService) RetryPayout(ctx context.Context, reference, provider, failureReason string, delay time.Duration) error {
+	query := `
+		UPDATE payouts
+		SET status = 'PENDING',
+		    provider = COALESCE(NULLIF($2, ''), provider),
+		    failure_reason = NULLIF($3, ''),
+		    next_attempt_at = NOW() + $4 * INTERVAL '1 second',
+		    updated_at = NOW()
+		WHERE reference = $1 AND status IN ('PENDING', 'PROCESSING')
+	`
+
+	_, err := db.Exec(ctx, query, reference, provider, failureReason, delay.Seconds())
+	return err
+}
+
+// DuePayouts returns the references of up to limit PENDING payouts that
+// are due, oldest first.
+func (db *DatabaseService) DuePayouts(ctx context.Context, limit int) ([]string, error) {
+	query := `
+		SELECT reference FROM payouts
+		WHERE status = 'PENDING' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT $1
+	`
+
+	rows, err := db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	references := []string{}
+	for rows.Next() {
+		var reference string
+		if err := rows.Scan(&reference); err != nil {
+			return nil, err
+		}
+		references = append(references, reference)
+	}
+	return references, rows.Err()
+}
+
+// UpdatePayoutStatus moves a payout that is still PENDING or PROCESSING
+// on; it returns ErrNotFound for one that has succeeded or failed. A
+// customer payout that fails is credited back to the account.
+func (db *DatabaseService) UpdatePayoutStatus(ctx context.Context, reference string, status api.PayoutStatus, provider, providerReference, failureReason string) (*api.Payout, error) {
+	query := `
+		UPDATE payouts
+		SET status = $2,
+		    provider = COALESCE(NULLIF($3, ''), provider),
+		    provider_reference = COALESCE(NULLIF($4, ''), provider_reference),
+		    failure_reason = NULLIF($5, ''),
+		    updated_at = NOW()
+		WHERE reference = $1 AND status IN ('PENDING', 'PROCESSING')
+		RETURNING ` + payoutColumns
+
+	var payout *api.Payout
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		payout, err = scanPayout(tx.QueryRow(ctx, query, reference, status, provider, providerReference, failureReason))
+		if err != nil || status != api.PayoutFailed || payout.CustomerID == "" {
+			return err
+		}
+		return creditFailedPayout(ctx, tx, payout)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payout, nil
+}
@@ -0,0 +1,33 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// usePgBouncerMode makes the pool work behind a transaction-pooling
+// pgBouncer, which may hand each statement to a different server
+// connection: statements go over the simple protocol and nothing is
+// prepared or cached on the server.
+//
+// Without prepared statements pgx can't ask Postgres for parameter types
+// and goes by the Go type instead, so JSON arguments must be
+// json.RawMessage (a []byte is sent as bytea) or api.Metadata, which is
+// registered here.
+func usePgBouncerMode(config *pgxpool.Config) {
+	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	config.ConnConfig.StatementCacheCapacity = 0
+	config.ConnConfig.DescriptionCacheCapacity = 0
+
+	afterConnect := config.AfterConnect
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		conn.TypeMap().RegisterDefaultPgType(api.Metadata{}, "jsonb")
+		if afterConnect != nil {
+			return afterConnect(ctx, conn)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,360 @@
+package tools
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	piiPrefix   = "enc:v1:"
+	indexPrefix = "hmac:"
+)
+
+// piiCipher does envelope encryption: values are sealed with AES-256-GCM data
+// keys, and the data keys are stored wrapped by the KeyProvider's master key.
+// Unwrapped data keys are cached so reads and writes don't call the KMS.
+type piiCipher struct {
+	provider KeyProvider
+	indexKey []byte
+
+	mu     sync.RWMutex
+	keys   map[int64][]byte
+	active int64
+}
+
+// EnablePII turns on transparent encryption of contact and identifier PII.
+// Plaintext rows written before it was enabled stay readable until
+// ReencryptPII converts them.
+func (db *DatabaseService) EnablePII(ctx context.Context, provider KeyProvider, indexKey string) error {
+	key, err := base64.StdEncoding.DecodeString(indexKey)
+	if err != nil || len(key) < 32 {
+		return fmt.Errorf("PII index key must be at least 32 bytes, base64 encoded")
+	}
+
+	cipher := &piiCipher{provider: provider, indexKey: key, keys: make(map[int64][]byte)}
+	db.pii = cipher
+
+	if err := db.loadDataKeys(ctx); err != nil {
+		db.pii = nil
+		return err
+	}
+	if cipher.active == 0 {
+		if _, err := db.RotatePIIDataKey(ctx); err != nil {
+			db.pii = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DatabaseService) loadDataKeys(ctx context.Context) error {
+	rows, err := db.Query(ctx, `SELECT id, master_key_id, wrapped_key, active FROM pii_data_keys`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	keys := make(map[int64][]byte)
+	var active int64
+	for rows.Next() {
+		var id int64
+		var masterKeyID string
+		var wrapped []byte
+		var isActive bool
+		if err := rows.Scan(&id, &masterKeyID, &wrapped, &isActive); err != nil {
+			return err
+		}
+		key, err := db.pii.provider.Unwrap(ctx, masterKeyID, wrapped)
+		if err != nil {
+			return fmt.Errorf("unwrap data key %d: %v", id, err)
+		}
+		keys[id] = key
+		if isActive {
+			active = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	db.pii.mu.Lock()
+	db.pii.keys = keys
+	db.pii.active = active
+	db.pii.mu.Unlock()
+	return nil
+}
+
+// RotatePIIDataKey generates a new data key for future writes. Existing
+// values keep decrypting with their original key until re-encrypted.
+func (db *DatabaseService) RotatePIIDataKey(ctx context.Context) (int64, error) {
+	if db.pii == nil {
+		return 0, fmt.Errorf("PII encryption is not enabled")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+	wrapped, err := db.pii.provider.Wrap(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		WITH retired AS (
+			UPDATE pii_data_keys SET active = FALSE WHERE active
+		)
+		INSERT INTO pii_data_keys (master_key_id, wrapped_key, active)
+		VALUES ($1, $2, TRUE)
+		RETURNING id
+	`
+
+	var id int64
+	if err := db.QueryRow(ctx, query, db.pii.provider.KeyID(), wrapped).Scan(&id); err != nil {
+		return 0, err
+	}
+
+	db.pii.mu.Lock()
+	db.pii.keys[id] = key
+	db.pii.active = id
+	db.pii.mu.Unlock()
+	return id, nil
+}
+
+// RewrapPIIDataKeys re-wraps every data key under the provider's current
+// master key, which completes a master key rotation without touching data.
+func (db *DatabaseService) RewrapPIIDataKeys(ctx context.Context) (int, error) {
+	if db.pii == nil {
+		return 0, fmt.Errorf("PII encryption is not enabled")
+	}
+
+	db.pii.mu.RLock()
+	keys := make(map[int64][]byte, len(db.pii.keys))
+	for id, key := range db.pii.keys {
+		keys[id] = key
+	}
+	db.pii.mu.RUnlock()
+
+	rewrapped := 0
+	for id, key := range keys {
+		wrapped, err := db.pii.provider.Wrap(ctx, key)
+		if err != nil {
+			return rewrapped, err
+		}
+		_, err = db.Exec(ctx,
+			`UPDATE pii_data_keys SET master_key_id = $2, wrapped_key = $3, rewrapped_at = NOW() WHERE id = $1`,
+			id, db.pii.provider.KeyID(), wrapped)
+		if err != nil {
+			return rewrapped, err
+		}
+		rewrapped++
+	}
+	return rewrapped, nil
+}
+
+func (db *DatabaseService) sealPII(value string) (string, error) {
+	if db.pii == nil || value == "" {
+		return value, nil
+	}
+
+	db.pii.mu.RLock()
+	id, key := db.pii.active, db.pii.keys[db.pii.active]
+	db.pii.mu.RUnlock()
+
+	sealed, err := sealAESGCM(key, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return piiPrefix + strconv.FormatInt(id, 10) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openPII decrypts a sealed value and passes plaintext through unchanged.
+func (db *DatabaseService) openPII(ctx context.Context, value string) (string, error) {
+	if !strings.HasPrefix(value, piiPrefix) {
+		return value, nil
+	}
+	if db.pii == nil {
+		return "", fmt.Errorf("encrypted PII found but PII encryption is not enabled")
+	}
+
+	idPart, encoded, ok := strings.Cut(strings.TrimPrefix(value, piiPrefix), ":")
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if !ok || err != nil {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	db.pii.mu.RLock()
+	key, found := db.pii.keys[id]
+	db.pii.mu.RUnlock()
+
+	// Another instance may have rotated in a key we haven't seen yet.
+	if !found {
+		if err := db.loadDataKeys(ctx); err != nil {
+			return "", err
+		}
+		db.pii.mu.RLock()
+		key, found = db.pii.keys[id]
+		db.pii.mu.RUnlock()
+		if !found {
+			return "", fmt.Errorf("unknown data key %d", id)
+		}
+	}
+
+	plaintext, err := openAESGCM(key, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// piiIndex returns a deterministic blind index so encrypted values can still
+// be looked up by equality.
+func (db *DatabaseService) piiIndex(value string) string {
+	if db.pii == nil {
+		return value
+	}
+	mac := hmac.New(sha256.New, db.pii.indexKey)
+	mac.Write([]byte(value))
+	return indexPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (db *DatabaseService) sealedWithActiveKey(value string) bool {
+	db.pii.mu.RLock()
+	defer db.pii.mu.RUnlock()
+	return strings.HasPrefix(value, piiPrefix+strconv.FormatInt(db.pii.active, 10)+":")
+}
+
+// ReencryptPII rewrites contact and identifier PII that is plaintext or
+// sealed with a retired data key, using the active data key.
+func (db *DatabaseService) ReencryptPII(ctx context.Context) (int, error) {
+	if db.pii == nil {
+		return 0, fmt.Errorf("PII encryption is not enabled")
+	}
+
+	contacts, err := db.reencryptContacts(ctx)
+	if err != nil {
+		return contacts, err
+	}
+	identifiers, err := db.reencryptIdentifiers(ctx)
+	return contacts + identifiers, err
+}
+
+func (db *DatabaseService) reencryptContacts(ctx context.Context) (int, error) {
+	rows, err := db.Query(ctx, `
+		SELECT customer_id, COALESCE(phone, ''), COALESCE(email, '')
+		FROM customer_contacts
+		WHERE phone IS NOT NULL OR email IS NOT NULL
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	type contactRow struct{ customerID, phone, email string }
+	var stale []contactRow
+	for rows.Next() {
+		var row contactRow
+		if err := rows.Scan(&row.customerID, &row.phone, &row.email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if (row.phone != "" && !db.sealedWithActiveKey(row.phone)) || (row.email != "" && !db.sealedWithActiveKey(row.email)) {
+			stale = append(stale, row)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, row := range stale {
+		phone, err := db.reseal(ctx, row.phone)
+		if err != nil {
+			return updated, err
+		}
+		email, err := db.reseal(ctx, row.email)
+		if err != nil {
+			return updated, err
+		}
+		_, err = db.Exec(ctx,
+			`UPDATE customer_contacts SET phone = NULLIF($2, ''), email = NULLIF($3, '') WHERE customer_id = $1`,
+			row.customerID, phone, email)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func (db *DatabaseService) reencryptIdentifiers(ctx context.Context) (int, error) {
+	rows, err := db.Query(ctx, `
+		SELECT identifier_type, identifier_value, COALESCE(identifier_encrypted, '')
+		FROM customer_identifiers
+		WHERE identifier_type IN ('phone', 'national_id')
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	type identifierRow struct{ identifierType, key, encrypted string }
+	var stale []identifierRow
+	for rows.Next() {
+		var row identifierRow
+		if err := rows.Scan(&row.identifierType, &row.key, &row.encrypted); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if row.encrypted == "" || !db.sealedWithActiveKey(row.encrypted) {
+			stale = append(stale, row)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, row := range stale {
+		plaintext := row.key
+		if row.encrypted != "" {
+			if plaintext, err = db.openPII(ctx, row.encrypted); err != nil {
+				return updated, err
+			}
+		}
+		sealed, err := db.sealPII(plaintext)
+		if err != nil {
+			return updated, err
+		}
+		_, err = db.Exec(ctx, `
+			UPDATE customer_identifiers
+			SET identifier_value = $3, identifier_encrypted = $4
+			WHERE identifier_type = $1 AND identifier_value = $2
+		`, row.identifierType, row.key, db.piiIndex(row.identifierType+":"+plaintext), sealed)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func (db *DatabaseService) reseal(ctx context.Context, value string) (string, error) {
+	plaintext, err := db.openPII(ctx, value)
+	if err != nil {
+		return "", err
+	}
+	return db.sealPII(plaintext)
+}
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+func (db *DatabaseService) CreateLoanProduct(ctx context.Context, product *api.LoanProduct) error {
+	query := `
+		INSERT INTO loan_products (product_id, name, interest_rate, interest_method, grace_weeks,
+		                           dunning_retry_days, dunning_delinquent_days)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, '{1,3,5}'), COALESCE($7, 14))
+		RETURNING dunning_retry_days, dunning_delinquent_days, created_at
+	`
+
+	return db.QueryRow(ctx, query, product.ProductID, product.Name, product.InterestRate, product.InterestMethod, product.GraceWeeks,
+		product.DunningRetryDays, product.DunningDelinquentDays).
+		Scan(&product.DunningRetryDays, &product.DunningDelinquentDays, &product.CreatedAt)
+}
+
+func (db *DatabaseService) GetLoanProduct(ctx context.Context, productID string) (*api.LoanProduct, error) {
+	query := `
+		SELECT product_id, name, interest_rate, interest_method, grace_weeks,
+		       dunning_retry_days, dunning_delinquent_days, created_at
+		FROM loan_products
+		WHERE product_id = $1
+	`
+
+	var product api.LoanProduct
+	err := db.QueryRow(ctx, query, productID).Scan(
+		&product.ProductID,
+		&product.Name,
+		&product.InterestRate,
+		&product.InterestMethod,
+		&product.GraceWeeks,
+		&product.DunningRetryDays,
+		&product.DunningDelinquentDays,
+		&product.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (db *DatabaseService) ListLoanProducts(ctx context.Context) ([]api.LoanProduct, error) {
+	query := `
+		SELECT product_id, name, interest_rate, interest_method, grace_weeks,
+		       dunning_retry_days, dunning_delinquent_days, created_at
+		FROM loan_products
+		ORDER BY product_id
+	`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []api.LoanProduct{}
+	for rows.Next() {
+		var product api.LoanProduct
+		if err := rows.Scan(&product.ProductID, &product.Name, &product.InterestRate, &product.InterestMethod, &product.GraceWeeks,
+			&product.DunningRetryDays, &product.DunningDelinquentDays, &product.CreatedAt); err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+// DunningPolicy returns the dunning policy of the account's loan product,
+// or ErrNotFound if it has none.
+func (db *DatabaseService) DunningPolicy(ctx context.Context, customerID string) (*api.DunningPolicy, error) {
+	query := `
+		SELECT p.dunning_retry_days, p.dunning_delinquent_days
+		FROM customer_accounts c
+		JOIN loan_products p ON p.product_id = c.product_id
+		WHERE c.customer_id = $1
+	`
+
+	var policy api.DunningPolicy
+	if err := db.QueryRow(ctx, query, customerID).Scan(&policy.RetryDays, &policy.DelinquentDays); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// AssignLoanProduct copies the product terms onto the account so later
+// product edits don't reprice existing loans, and restates the outstanding
+// balance to include the scheduled interest.
+func (db *DatabaseService) AssignLoanProduct(ctx context.Context, customerID string, product *api.LoanProduct, installment, totalRepayable float64) (*api.CustomerAccount, error) {
+	query := `
+		UPDATE customer_accounts
+		SET product_id = $2,
+		    interest_rate = $3,
+		    interest_method = $4,
+		    grace_weeks = $5,
+		    installment_amount = $6,
+		    outstanding_balance = GREATEST(0, $7 - total_paid),
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE customer_id = $1
+		RETURNING ` + customerColumns
+
+	return scanCustomer(db.QueryRow(ctx, query, customerID, product.ProductID, product.InterestRate, product.InterestMethod, product.GraceWeeks, installment, totalRepayable))
+}
@@ -0,0 +1,165 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+type PromiseKeptRate struct {
+	AgentID        string  `json:"agent_id,omitempty"`
+	Region         string  `json:"region,omitempty"`
+	Promises       int     `json:"promises"`
+	Kept           int     `json:"kept"`
+	Broken         int     `json:"broken"`
+	Pending        int     `json:"pending"`
+	KeptRate       float64 `json:"kept_rate"`
+	AmountPromised float64 `json:"amount_promised"`
+	AmountPaid     float64 `json:"amount_paid"`
+}
+
+const promiseColumns = `
+	id, customer_id, agent_id, amount, TO_CHAR(promised_date, 'YYYY-MM-DD'), status,
+	paid_amount, COALESCE(note, ''), created_at, resolved_at
+`
+
+func scanPromise(row pgx.Row) (*api.PromiseToPay, error) {
+	var promise api.PromiseToPay
+	err := row.Scan(
+		&promise.ID,
+		&promise.CustomerID,
+		&promise.AgentID,
+		&promise.Amount,
+		&promise.PromisedDate,
+		&promise.Status,
+		&promise.PaidAmount,
+		&promise.Note,
+		&promise.CreatedAt,
+		&promise.ResolvedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &promise, nil
+}
+
+func (db *DatabaseService) CreatePromise(ctx context.Context, promise *api.PromiseToPay) (*api.PromiseToPay, error) {
+	query := `
+		INSERT INTO promises_to_pay (customer_id, agent_id, amount, promised_date, note)
+		VALUES ($1, $2, $3, $4::DATE, NULLIF($5, ''))
+		RETURNING ` + promiseColumns
+
+	return scanPromise(db.QueryRow(ctx, query, promise.CustomerID, promise.AgentID, promise.Amount, promise.PromisedDate, promise.Note))
+}
+
+func (db *DatabaseService) ListPromises(ctx context.Context, customerID string) ([]*api.PromiseToPay, error) {
+	query := `
+		SELECT ` + promiseColumns + `
+		FROM promises_to_pay
+		WHERE customer_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := db.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promises := []*api.PromiseToPay{}
+	for rows.Next() {
+		promise, err := scanPromise(rows)
+		if err != nil {
+			return nil, err
+		}
+		promises = append(promises, promise)
+	}
+	return promises, rows.Err()
+}
+
+// ApplyPaymentToPromises credits a payment against the customer's open
+// promises, marking each KEPT once the promised amount has been paid. Only
+// promises due on or after the payment's value date are credited.
+func (db *DatabaseService) ApplyPaymentToPromises(ctx context.Context, customerID string, amount float64, valueDate time.Time) (int64, error) {
+	query := `
+		UPDATE promises_to_pay
+		SET paid_amount = paid_amount + $2,
+		    status = CASE WHEN paid_amount + $2 >= amount THEN 'KEPT' ELSE status END,
+		    resolved_at = CASE WHEN paid_amount + $2 >= amount THEN NOW() ELSE resolved_at END
+		WHERE customer_id = $1
+		  AND status = 'PENDING'
+		  AND promised_date >= $3::DATE
+	`
+
+	result, err := db.Exec(ctx, query, customerID, amount, valueDate.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// ExpirePromises marks promises BROKEN once their date has passed unpaid.
+func (db *DatabaseService) ExpirePromises(ctx context.Context) (int64, error) {
+	query := `
+		UPDATE promises_to_pay
+		SET status = 'BROKEN', resolved_at = NOW()
+		WHERE status = 'PENDING' AND promised_date < CURRENT_DATE
+	`
+
+	result, err := db.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// GetPromiseKeptRates groups promises due in the period by "agent" or
+// "region".
+func (db *DatabaseService) GetPromiseKeptRates(ctx context.Context, from, to time.Time, groupBy string) ([]PromiseKeptRate, error) {
+	group := `p.agent_id`
+	if groupBy == "region" {
+		group = `COALESCE(c.region, 'UNASSIGNED')`
+	}
+
+	query := `
+		SELECT ` + group + `,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE p.status = 'KEPT'),
+		       COUNT(*) FILTER (WHERE p.status = 'BROKEN'),
+		       COUNT(*) FILTER (WHERE p.status = 'PENDING'),
+		       COALESCE(SUM(p.amount), 0),
+		       COALESCE(SUM(p.paid_amount), 0)
+		FROM promises_to_pay p
+		JOIN customer_accounts c ON c.customer_id = p.customer_id
+		WHERE p.promised_date >= $1::DATE AND p.promised_date < $2::DATE
+		GROUP BY 1
+		ORDER BY 1
+	`
+
+	rows, err := db.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []PromiseKeptRate{}
+	for rows.Next() {
+		var rate PromiseKeptRate
+		var key string
+		if err := rows.Scan(&key, &rate.Promises, &rate.Kept, &rate.Broken, &rate.Pending, &rate.AmountPromised, &rate.AmountPaid); err != nil {
+			return nil, err
+		}
+		if groupBy == "region" {
+			rate.Region = key
+		} else {
+			rate.AgentID = key
+		}
+		if resolved := rate.Kept + rate.Broken; resolved > 0 {
+			rate.KeptRate = roundCents(float64(rate.Kept) / float64(resolved) * 100)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
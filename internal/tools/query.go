@@ -0,0 +1,203 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// QueryRow, Query and Exec retry a statement once, on a fresh connection,
+// if a failover rejected it before it ran.
+func (db *DatabaseService) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	start := time.Now()
+	row := db.Pool.QueryRow(ctx, db.tagQuery(ctx, sql), args...)
+	return &timedRow{
+		row: row,
+		retry: func(err error) pgx.Row {
+			if !db.handleFailover(ctx, err) {
+				return nil
+			}
+			return db.Pool.QueryRow(ctx, db.tagQuery(ctx, sql), args...)
+		},
+		finish: func(err error) {
+			cancel()
+			db.logSlowQuery(ctx, sql, args, start, err)
+		},
+	}
+}
+
+func (db *DatabaseService) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	start := time.Now()
+	rows, err := db.Pool.Query(ctx, db.tagQuery(ctx, sql), args...)
+	if db.handleFailover(ctx, err) {
+		rows, err = db.Pool.Query(ctx, db.tagQuery(ctx, sql), args...)
+	}
+	if err != nil {
+		cancel()
+		db.logSlowQuery(ctx, sql, args, start, err)
+		return nil, domainError(err)
+	}
+	return &timedRows{Rows: rows, finish: func(err error) {
+		cancel()
+		db.logSlowQuery(ctx, sql, args, start, err)
+	}}, nil
+}
+
+func (db *DatabaseService) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	tag, err := db.Pool.Exec(ctx, db.tagQuery(ctx, sql), args...)
+	if db.handleFailover(ctx, err) {
+		tag, err = db.Pool.Exec(ctx, db.tagQuery(ctx, sql), args...)
+	}
+	db.logSlowQuery(ctx, sql, args, start, err)
+	return tag, domainError(err)
+}
+
+// inTx runs fn in a transaction like pgx.BeginFunc, with the errors
+// classified as the other queries' are, both those of the statements fn
+// runs and the one inTx returns.
+func (db *DatabaseService) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	return runInTx(ctx, db.Pool, fn)
+}
+
+func runInTx(ctx context.Context, db interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}, fn func(tx pgx.Tx) error) error {
+	return domainError(pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		return fn(classifiedTx{tx})
+	}))
+}
+
+// classifiedTx is a transaction whose statements return domain errors.
+type classifiedTx struct {
+	pgx.Tx
+}
+
+func (tx classifiedTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	tag, err := tx.Tx.Exec(ctx, sql, args...)
+	return tag, domainError(err)
+}
+
+func (tx classifiedTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	rows, err := tx.Tx.Query(ctx, sql, args...)
+	return rows, domainError(err)
+}
+
+func (tx classifiedTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return classifiedRow{tx.Tx.QueryRow(ctx, sql, args...)}
+}
+
+type classifiedRow struct {
+	pgx.Row
+}
+
+func (r classifiedRow) Scan(dest ...any) error {
+	return domainError(r.Row.Scan(dest...))
+}
+
+func (db *DatabaseService) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+func (db *DatabaseService) tagQuery(ctx context.Context, sql string) string {
+	if !db.traceComments {
+		return sql
+	}
+	// The ID ends up in the statement text: anything that could close the
+	// comment is left out rather than escaped.
+	traceID := TraceIDFromContext(ctx)
+	if !ValidTraceID(traceID) {
+		return sql
+	}
+	return fmt.Sprintf("/* trace_id=%s */ %s", traceID, sql)
+}
+
+func (db *DatabaseService) logSlowQuery(ctx context.Context, sql string, args []any, start time.Time, err error) {
+	elapsed := time.Since(start)
+	if db.slowQueryThreshold <= 0 || elapsed < db.slowQueryThreshold {
+		return
+	}
+
+	fields := log.Fields{
+		"duration_ms": elapsed.Milliseconds(),
+		"query":       condenseQuery(sql),
+		"args":        redactArgs(args),
+	}
+	if traceID := TraceIDFromContext(ctx); traceID != "" {
+		fields["trace_id"] = traceID
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	log.WithFields(fields).Warn("Slow query")
+}
+
+func condenseQuery(sql string) string {
+	condensed := strings.TrimSpace(whitespace.ReplaceAllString(sql, " "))
+	if len(condensed) > 200 {
+		condensed = condensed[:200] + "..."
+	}
+	return condensed
+}
+
+func redactArgs(args []any) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = fmt.Sprintf("$%d=<%T>", i+1, arg)
+	}
+	return redacted
+}
+
+type timedRow struct {
+	row    pgx.Row
+	retry  func(error) pgx.Row
+	finish func(error)
+}
+
+func (r *timedRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	if err != nil && err != pgx.ErrNoRows {
+		if row := r.retry(err); row != nil {
+			err = row.Scan(dest...)
+		}
+	}
+	r.finish(err)
+	return domainError(err)
+}
+
+type timedRows struct {
+	pgx.Rows
+	finish func(error)
+	closed bool
+}
+
+func (r *timedRows) Close() {
+	r.Rows.Close()
+	if !r.closed {
+		r.closed = true
+		r.finish(r.Rows.Err())
+	}
+}
+
+func (r *timedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.Close()
+	return false
+}
@@ -0,0 +1,114 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// fakeTx records how a transaction ended. Its rows find nothing and its
+// statements fail with a unique violation.
+type fakeTx struct {
+	pgx.Tx
+	committed, rolledBack bool
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	tx.rolledBack = true
+	return nil
+}
+
+func (tx *fakeTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return fakeRow{}
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, &pgconn.PgError{Code: "23505"}
+}
+
+type fakeRow struct{}
+
+func (fakeRow) Scan(dest ...any) error { return pgx.ErrNoRows }
+
+type fakeBeginner struct{ tx *fakeTx }
+
+func (b fakeBeginner) Begin(ctx context.Context) (pgx.Tx, error) { return b.tx, nil }
+
+func TestRunInTx(t *testing.T) {
+	ctx := context.Background()
+
+	tx := &fakeTx{}
+	calls := 0
+	err := runInTx(ctx, fakeBeginner{tx}, func(tx pgx.Tx) error {
+		calls++
+		return nil
+	})
+	if err != nil || calls != 1 || !tx.committed {
+		t.Errorf("successful transaction: err %v, fn called %d times, committed %v", err, calls, tx.committed)
+	}
+
+	tx = &fakeTx{}
+	err = runInTx(ctx, fakeBeginner{tx}, func(tx pgx.Tx) error {
+		var id int64
+		err := tx.QueryRow(ctx, `SELECT id FROM import_batches WHERE id = $1`, 1).Scan(&id)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("row in transaction returned %v, want ErrNotFound", err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO rules (name) VALUES ($1)`, "dup"); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("statement in transaction returned %v, want ErrDuplicate", err)
+		}
+		return pgx.ErrNoRows
+	})
+	if !errors.Is(err, ErrNotFound) || tx.committed || !tx.rolledBack {
+		t.Errorf("failed transaction: err %v, committed %v, rolled back %v", err, tx.committed, tx.rolledBack)
+	}
+}
+
+// TestInTxBeginsOnPool runs inTx against a database that refuses
+// connections: the error from beginning comes back and fn never runs.
+func TestInTxBeginsOnPool(t *testing.T) {
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, "postgres://payment@127.0.0.1:1/payment_system?connect_timeout=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	db := &DatabaseService{Pool: pool}
+
+	called := false
+	err = db.inTx(ctx, func(tx pgx.Tx) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Errorf("inTx without a database: err %v, fn called %v", err, called)
+	}
+}
+
+func TestTagQueryRefusesUnsafeTraceIDs(t *testing.T) {
+	db := &DatabaseService{traceComments: true}
+	sql := "SELECT 1"
+
+	for traceID, want := range map[string]string{
+		"":                             sql,
+		"3f2a-req-01":                  "/* trace_id=3f2a-req-01 */ " + sql,
+		"x */ DROP TABLE payments; /*": sql,
+		"a*/b":                         sql,
+		strings.Repeat("a", 65):        sql,
+	} {
+		got := db.tagQuery(WithTraceID(context.Background(), traceID), sql)
+		if got != want {
+			t.Errorf("trace ID %q: got %q, want %q", traceID, got, want)
+		}
+	}
+}
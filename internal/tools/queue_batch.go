@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/go-redis/redis/v8"
+)
+
+// DequeuePayments waits up to timeout for a payment, then takes up to max-1
+// more that are already queued in the same round trip, in queue order. It
+// returns the payments it could decode along with an error for the
+// messages it couldn't; see DequeuePayment.
+func (r *RedisService) DequeuePayments(ctx context.Context, max int, timeout time.Duration) ([]*api.PaymentPayload, error) {
+	first, err := r.dequeuePayment(ctx, timeout)
+	if err != nil || first == "" {
+		return nil, err
+	}
+	messages := []string{first}
+	var errs []error
+	if max > 1 {
+		more, err := r.dequeueQueued(ctx, max-1)
+		messages = append(messages, more...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	r.countQueueOp(ctx, queueDequeued, time.Now(), len(messages))
+
+	payments := make([]*api.PaymentPayload, 0, len(messages))
+	for _, message := range messages {
+		payment, err := r.takePayment(ctx, message)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		payments = append(payments, payment)
+	}
+	return payments, errors.Join(errs...)
+}
+
+// dequeueQueued takes up to n payment messages without waiting. Under
+// handoff each one moves to the processing list like dequeuePayment's.
+func (r *RedisService) dequeueQueued(ctx context.Context, n int) ([]string, error) {
+	if r.Instance == "" {
+		messages, err := r.Client.LPopCount(ctx, r.Key("payment_queue"), n).Result()
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return messages, err
+	}
+
+	// LMOVE takes one element at a time; pipelining keeps it to one round
+	// trip. Commands after the queue runs dry just find it empty.
+	pipe := r.Client.Pipeline()
+	cmds := make([]*redis.StringCmd, n)
+	for i := range cmds {
+		cmds[i] = pipe.LMove(ctx, r.Key("payment_queue"), r.processingKey(r.Instance), "LEFT", "RIGHT")
+	}
+	pipe.Exec(ctx)
+
+	// Keep every message that moved, even past a failed command, or it
+	// would sit in the processing list until the next handoff.
+	messages := make([]string, 0, n)
+	var failed error
+	for _, cmd := range cmds {
+		message, err := cmd.Result()
+		switch {
+		case err == nil:
+			messages = append(messages, message)
+		case !errors.Is(err, ErrNotFound) && failed == nil:
+			failed = err
+		}
+	}
+	return messages, failed
+}
@@ -0,0 +1,122 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// PaymentSchemaVersion is the payment message version this build writes and
+// the newest it fully understands. Bump it when the meaning of a queued
+// payment changes, and add an upcaster for the previous version.
+const PaymentSchemaVersion = 1
+
+const paymentMessageType = "payment"
+
+var ErrUnsupportedSchemaVersion = errors.New("queued message requires a newer worker")
+
+// queueEnvelope wraps every queued payment. MinReaderVersion is the oldest
+// worker version that can safely process the message: additive changes
+// leave it alone so older workers keep draining the queue during a rolling
+// deploy, while breaking changes raise it so old workers hand the message
+// back instead of misprocessing it. EnqueuedAtMs, in Unix milliseconds,
+// lets queue lag be read without decoding the payload.
+type queueEnvelope struct {
+	SchemaVersion    int             `json:"schema_version"`
+	MinReaderVersion int             `json:"min_reader_version,omitempty"`
+	Type             string          `json:"type"`
+	EnqueuedAtMs     int64           `json:"enqueued_at_ms,omitempty"`
+	Payload          json.RawMessage `json:"payload"`
+}
+
+// paymentUpcasters rewrite a payload from version N to N+1. Version 0 is a
+// bare PaymentPayload written before messages had an envelope.
+var paymentUpcasters = map[int]func(json.RawMessage) (json.RawMessage, error){
+	0: func(payload json.RawMessage) (json.RawMessage, error) { return payload, nil },
+}
+
+func EncodePaymentMessage(payment *api.PaymentPayload) ([]byte, error) {
+	payload, err := json.Marshal(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := queueEnvelope{
+		SchemaVersion:    PaymentSchemaVersion,
+		MinReaderVersion: 1,
+		Type:             paymentMessageType,
+		Payload:          payload,
+	}
+	if payment.EnqueuedAt != nil {
+		envelope.EnqueuedAtMs = payment.EnqueuedAt.UnixMilli()
+	}
+	return json.Marshal(envelope)
+}
+
+// PaymentMessageEnqueuedAt returns when a queued message was enqueued, or
+// false if it doesn't say. Messages written before the envelope carried the
+// time fall back to the payload's enqueued_at.
+func PaymentMessageEnqueuedAt(data []byte) (time.Time, bool) {
+	var envelope queueEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return time.Time{}, false
+	}
+	if envelope.EnqueuedAtMs > 0 {
+		return time.UnixMilli(envelope.EnqueuedAtMs), true
+	}
+
+	payload := envelope.Payload
+	if envelope.Type == "" && envelope.Payload == nil {
+		payload = data
+	}
+	var payment struct {
+		EnqueuedAt *time.Time `json:"enqueued_at"`
+	}
+	if err := json.Unmarshal(payload, &payment); err != nil || payment.EnqueuedAt == nil {
+		return time.Time{}, false
+	}
+	return *payment.EnqueuedAt, true
+}
+
+func DecodePaymentMessage(data []byte) (*api.PaymentPayload, error) {
+	var envelope queueEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+
+	// Messages without an envelope predate versioning.
+	if envelope.Type == "" && envelope.Payload == nil {
+		envelope = queueEnvelope{SchemaVersion: 0, Type: paymentMessageType, Payload: data}
+	}
+
+	if envelope.Type != paymentMessageType {
+		return nil, fmt.Errorf("unexpected message type %q on payment queue", envelope.Type)
+	}
+
+	if envelope.SchemaVersion > PaymentSchemaVersion && envelope.MinReaderVersion > PaymentSchemaVersion {
+		return nil, fmt.Errorf("%w: schema %d needs reader %d, this worker reads %d",
+			ErrUnsupportedSchemaVersion, envelope.SchemaVersion, envelope.MinReaderVersion, PaymentSchemaVersion)
+	}
+
+	payload := envelope.Payload
+	for version := envelope.SchemaVersion; version < PaymentSchemaVersion; version++ {
+		upcast, ok := paymentUpcasters[version]
+		if !ok {
+			return nil, fmt.Errorf("no upcaster from payment schema %d", version)
+		}
+		var err error
+		if payload, err = upcast(payload); err != nil {
+			return nil, fmt.Errorf("upcast payment schema %d: %v", version, err)
+		}
+	}
+
+	// Newer compatible versions decode as-is: unknown fields are ignored.
+	var payment api.PaymentPayload
+	if err := json.Unmarshal(payload, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
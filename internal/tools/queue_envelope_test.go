@@ -0,0 +1,133 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// The fixtures under testdata/queue are messages as written by past (and
+// hypothetical future) API versions. Never edit an existing fixture: add a
+// new one when the schema changes, so old messages stay readable.
+func readQueueFixture(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "queue", name))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	return data
+}
+
+func TestDecodePaymentMessageCompatibility(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		reference string
+		currency  string
+	}{
+		{fixture: "v0_bare.json", reference: "TXN-V0-001"},
+		{fixture: "v1_envelope.json", reference: "TXN-V1-001", currency: "NGN"},
+		{fixture: "v2_additive.json", reference: "TXN-V2-001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			payment, err := DecodePaymentMessage(readQueueFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if payment.TransactionReference != tt.reference {
+				t.Errorf("reference = %q, want %q", payment.TransactionReference, tt.reference)
+			}
+			if payment.CustomerID != "GIG00001" || payment.TransactionAmount != "1000.00" {
+				t.Errorf("unexpected payment: %+v", payment)
+			}
+			if payment.Currency != tt.currency {
+				t.Errorf("currency = %q, want %q", payment.Currency, tt.currency)
+			}
+		})
+	}
+}
+
+func TestDecodePaymentMessageLegacyKeepsEnqueuedAt(t *testing.T) {
+	payment, err := DecodePaymentMessage(readQueueFixture(t, "v0_bare.json"))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payment.EnqueuedAt == nil {
+		t.Fatal("enqueued_at was dropped")
+	}
+}
+
+func TestDecodePaymentMessageRejectsBreakingVersion(t *testing.T) {
+	_, err := DecodePaymentMessage(readQueueFixture(t, "v3_breaking.json"))
+	if !errors.Is(err, ErrUnsupportedSchemaVersion) {
+		t.Fatalf("err = %v, want ErrUnsupportedSchemaVersion", err)
+	}
+}
+
+func TestDecodePaymentMessageRejectsWrongType(t *testing.T) {
+	_, err := DecodePaymentMessage(readQueueFixture(t, "wrong_type.json"))
+	if err == nil || errors.Is(err, ErrUnsupportedSchemaVersion) {
+		t.Fatalf("err = %v, want type mismatch", err)
+	}
+}
+
+func TestDecodePaymentMessageMalformed(t *testing.T) {
+	if _, err := DecodePaymentMessage([]byte("not json")); err == nil {
+		t.Fatal("expected error for malformed message")
+	}
+}
+
+func TestEncodePaymentMessageRoundTrip(t *testing.T) {
+	in := &api.PaymentPayload{
+		CustomerID:           "GIG00002",
+		PaymentStatus:        api.StatusComplete,
+		TransactionAmount:    "250.00",
+		TransactionDate:      "2025-02-01 09:00:00",
+		TransactionReference: "TXN-RT-001",
+		Channel:              "card",
+		Metadata:             api.Metadata{"source": "test"},
+	}
+
+	data, err := EncodePaymentMessage(in)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	out, err := DecodePaymentMessage(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.TransactionReference != in.TransactionReference || out.Channel != in.Channel || out.Metadata["source"] != "test" {
+		t.Errorf("round trip mismatch: %+v", out)
+	}
+}
+
+func TestPaymentMessageEnqueuedAt(t *testing.T) {
+	enqueuedAt := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	data, err := EncodePaymentMessage(&api.PaymentPayload{TransactionReference: "TXN-AGE-001", EnqueuedAt: &enqueuedAt})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if got, ok := PaymentMessageEnqueuedAt(data); !ok || !got.Equal(enqueuedAt) {
+		t.Errorf("envelope enqueued at = %v, %v; want %v", got, ok, enqueuedAt)
+	}
+
+	if _, ok := PaymentMessageEnqueuedAt(readQueueFixture(t, "v0_bare.json")); !ok {
+		t.Error("legacy message lost its enqueue time")
+	}
+	if _, ok := PaymentMessageEnqueuedAt(readQueueFixture(t, "v1_envelope.json")); ok {
+		t.Error("message without an enqueue time reported one")
+	}
+}
+
+func TestEveryOlderSchemaHasUpcaster(t *testing.T) {
+	for version := 0; version < PaymentSchemaVersion; version++ {
+		if _, ok := paymentUpcasters[version]; !ok {
+			t.Errorf("missing upcaster from schema %d", version)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// processingKey is the list of payments instance has dequeued and not yet
+// finished with.
+func (r *RedisService) processingKey(instance string) string {
+	return r.Key("payment_processing:" + instance)
+}
+
+func (r *RedisService) aliveKey(instance string) string {
+	return r.Key("instance_alive:" + instance)
+}
+
+// AckPayment drops a payment from the instance's processing list once it
+// has been applied, or failed for good. It does nothing without handoff.
+func (r *RedisService) AckPayment(ctx context.Context, payment *api.PaymentPayload) error {
+	message, ok := r.dequeued.LoadAndDelete(payment)
+	if !ok {
+		return nil
+	}
+	return r.Client.LRem(ctx, r.processingKey(r.Instance), 1, message).Err()
+}
+
+// release drops a message that will never become a payment from the
+// processing list.
+func (r *RedisService) release(ctx context.Context, message string) {
+	if r.Instance == "" {
+		return
+	}
+	if err := r.Client.LRem(ctx, r.processingKey(r.Instance), 1, message).Err(); err != nil {
+		log.Warnf("Failed to release payment message from %s: %v", r.processingKey(r.Instance), err)
+	}
+}
+
+// HandOffPayments moves the payments instance dequeued but never acked to
+// the front of the queue, in the order they were taken, for any instance
+// to apply. The processor skips ones that turn out to have been applied
+// after all.
+func (r *RedisService) HandOffPayments(ctx context.Context, instance string) (int, error) {
+	handed := 0
+	for {
+		err := r.Client.LMove(ctx, r.processingKey(instance), r.Key("payment_queue"), "RIGHT", "LEFT").Err()
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return handed, err
+		}
+		handed++
+	}
+	if handed > 0 {
+		DefaultMetrics.Inc("payments_handed_off_total", float64(handed))
+		log.Printf("Handed %d in-flight payments of %s back to the queue", handed, instance)
+	}
+	return handed, nil
+}
+
+// KeepAlive marks r.Instance as running for ttl. ReclaimPayments treats
+// instances that stop doing so as gone.
+func (r *RedisService) KeepAlive(ctx context.Context, ttl time.Duration) error {
+	return r.Client.SetEX(ctx, r.aliveKey(r.Instance), "1", ttl).Err()
+}
+
+// ReclaimPayments hands off the payments of instances that stopped without
+// doing it themselves, e.g. killed at the end of their grace period.
+func (r *RedisService) ReclaimPayments(ctx context.Context) (int, error) {
+	prefix := r.processingKey("")
+	var keys []string
+	iter := r.Client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+
+	reclaimed := 0
+	for _, key := range keys {
+		instance := strings.TrimPrefix(key, prefix)
+		if instance == r.Instance {
+			continue
+		}
+		alive, err := r.Client.Exists(ctx, r.aliveKey(instance)).Result()
+		if err != nil {
+			return reclaimed, err
+		}
+		if alive > 0 {
+			continue
+		}
+		handed, err := r.HandOffPayments(ctx, instance)
+		reclaimed += handed
+		if err != nil {
+			return reclaimed, err
+		}
+	}
+	return reclaimed, nil
+}
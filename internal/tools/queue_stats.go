@@ -0,0 +1,135 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	queueEnqueued = "enqueued"
+	queueDequeued = "dequeued"
+	// queueRateRetention is how long the per-minute counters are kept; it
+	// bounds the window QueueRates can look back over.
+	queueRateRetention = 15 * time.Minute
+)
+
+// QueueStats describes how the payment queue is keeping up, across every
+// instance sharing it.
+type QueueStats struct {
+	Depth int64 `json:"depth"`
+	// OldestAgeSeconds is how long the payment at the head of the queue has
+	// waited: the consumer lag. It is 0 when the queue is empty.
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+	// EnqueueRate and DequeueRate are payments per second over Window.
+	EnqueueRate float64 `json:"enqueue_rate"`
+	DequeueRate float64 `json:"dequeue_rate"`
+	Window      string  `json:"window"`
+}
+
+// queueRateMinutes is the number of complete minutes QueueRates averages
+// over for window.
+func queueRateMinutes(window time.Duration) int64 {
+	minutes := int64(window / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	if limit := int64(queueRateRetention/time.Minute) - 1; minutes > limit {
+		minutes = limit
+	}
+	return minutes
+}
+
+func (r *RedisService) queueRateKey(op string, minute int64) string {
+	return r.Key(fmt.Sprintf("payment_queue_rate:%s:%d", op, minute))
+}
+
+// countQueueOp records n enqueues or dequeues in the shared per-minute
+// counters and the local Prometheus counter. It is best effort: a failure
+// only skews the rates.
+func (r *RedisService) countQueueOp(ctx context.Context, op string, at time.Time, n int) {
+	DefaultMetrics.Inc("payment_queue_"+op+"_total", float64(n))
+
+	key := r.queueRateKey(op, at.Unix()/60)
+	pipe := r.Client.Pipeline()
+	pipe.IncrBy(ctx, key, int64(n))
+	pipe.Expire(ctx, key, queueRateRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Debugf("Failed to count payment queue %s: %v", op, err)
+	}
+}
+
+// OldestQueuedAge is how long the payment at the head of the queue has
+// waited, or 0 if the queue is empty or the message doesn't record when it
+// was enqueued.
+func (r *RedisService) OldestQueuedAge(ctx context.Context) (time.Duration, error) {
+	head, err := r.Client.LIndex(ctx, r.Key("payment_queue"), 0).Result()
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	enqueuedAt, ok := PaymentMessageEnqueuedAt([]byte(head))
+	if !ok {
+		return 0, nil
+	}
+	if age := time.Since(enqueuedAt); age > 0 {
+		return age, nil
+	}
+	return 0, nil
+}
+
+// QueueRates returns the enqueue and dequeue rates, in payments per second,
+// over the complete minutes in window (at most queueRateRetention).
+func (r *RedisService) QueueRates(ctx context.Context, window time.Duration) (enqueued, dequeued float64, err error) {
+	minutes := queueRateMinutes(window)
+	current := time.Now().Unix() / 60
+	keys := make([]string, 0, 2*minutes)
+	for _, op := range []string{queueEnqueued, queueDequeued} {
+		for minute := current - minutes; minute < current; minute++ {
+			keys = append(keys, r.queueRateKey(op, minute))
+		}
+	}
+	values, err := r.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var totals [2]float64
+	for i, value := range values {
+		var count float64
+		if s, ok := value.(string); ok {
+			fmt.Sscanf(s, "%f", &count)
+		}
+		totals[int64(i)/minutes] += count
+	}
+	seconds := float64(minutes * 60)
+	return totals[0] / seconds, totals[1] / seconds, nil
+}
+
+// QueueStats gathers the payment queue's depth, lag and rates over window.
+func (r *RedisService) QueueStats(ctx context.Context, window time.Duration) (*QueueStats, error) {
+	depth, err := r.QueueDepth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	age, err := r.OldestQueuedAge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	enqueued, dequeued, err := r.QueueRates(ctx, window)
+	if err != nil {
+		return nil, err
+	}
+	return &QueueStats{
+		Depth:            depth,
+		OldestAgeSeconds: age.Seconds(),
+		EnqueueRate:      enqueued,
+		DequeueRate:      dequeued,
+		Window:           (time.Duration(queueRateMinutes(window)) * time.Minute).String(),
+	}, nil
+}
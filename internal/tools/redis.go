@@ -2,8 +2,9 @@ package tools
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/abjerry97/go_payment/api"
@@ -12,89 +13,202 @@ import (
 )
 
 type RedisService struct {
+	// Client's commands return domain errors: a missing key is ErrNotFound.
 	Client *redis.Client
+	// Namespace prefixes every key, as "<namespace>:<key>", so several
+	// environments can share one Redis. Empty leaves keys unprefixed.
+	Namespace string
+	// LocalBalanceTTL, when positive, keeps balances read from Redis in
+	// memory for that long. Run ListenBalanceChanges so changes made by
+	// other instances evict them.
+	LocalBalanceTTL time.Duration
+	// Instance, when set, turns on queue handoff: DequeuePayment keeps each
+	// payment in the instance's processing list until AckPayment, and
+	// HandOffPayments puts whatever is left there back on the queue.
+	Instance string
+
+	localBalances localBalanceCache
+	// dequeued maps payments taken under handoff to their queue messages.
+	dequeued sync.Map
 }
 
 func NewRedisService(redisURL string) (*RedisService, error) {
-	opts, err := redis.ParseURL(redisURL)
+	r, err := OpenRedisService(redisURL)
 	if err != nil {
 		return nil, err
 	}
 
-	opts.PoolSize = 100
-	opts.MinIdleConns = 20
-	opts.MaxRetries = 3
-
-	Client := redis.NewClient(opts)
-
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := Client.Ping(ctx).Err(); err != nil {
+	if err := r.Client.Ping(ctx).Err(); err != nil {
+		r.Close()
 		return nil, err
 	}
 
 	log.Println("Redis connected successfully")
+	return r, nil
+}
+
+// OpenRedisService sets up the client without waiting for Redis; it
+// connects on first use.
+func OpenRedisService(redisURL string) (*RedisService, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.PoolSize = 100
+	opts.MinIdleConns = 20
+	opts.MaxRetries = 3
+
+	Client := redis.NewClient(opts)
+	Client.AddHook(domainErrorHook{})
 	return &RedisService{Client: Client}, nil
 }
 
+// Key is name within r's namespace. Every key read or written through
+// Client must go through it.
+func (r *RedisService) Key(name string) string {
+	if r.Namespace == "" {
+		return name
+	}
+	return r.Namespace + ":" + name
+}
+
 func (r *RedisService) Close() error {
 	return r.Client.Close()
 }
 
 func (r *RedisService) EnqueuePayment(ctx context.Context, payment *api.PaymentPayload) error {
-	data, err := json.Marshal(payment)
+	enqueuedAt := time.Now()
+	payment.EnqueuedAt = &enqueuedAt
+
+	data, err := EncodePaymentMessage(payment)
 	if err != nil {
 		return err
 	}
 
-	return r.Client.RPush(ctx, "payment_queue", data).Err()
+	if err := r.Client.RPush(ctx, r.Key("payment_queue"), data).Err(); err != nil {
+		return err
+	}
+	r.countQueueOp(ctx, queueEnqueued, enqueuedAt, 1)
+	return nil
 }
 
 func (r *RedisService) DequeuePayment(ctx context.Context, timeout time.Duration) (*api.PaymentPayload, error) {
-	result, err := r.Client.BLPop(ctx, timeout, "payment_queue").Result()
-	if err != nil {
+	message, err := r.dequeuePayment(ctx, timeout)
+	if err != nil || message == "" {
 		return nil, err
 	}
+	r.countQueueOp(ctx, queueDequeued, time.Now(), 1)
+	return r.takePayment(ctx, message)
+}
 
-	if len(result) < 2 {
-		return nil, nil
+// takePayment decodes a dequeued message. Messages from a newer schema go
+// back on the queue, and ones that can't be decoded leave the processing
+// list, with an error either way.
+func (r *RedisService) takePayment(ctx context.Context, message string) (*api.PaymentPayload, error) {
+	payment, err := DecodePaymentMessage([]byte(message))
+	if errors.Is(err, ErrUnsupportedSchemaVersion) {
+		// Leave it for a newer worker rather than dropping it.
+		if requeueErr := r.Client.RPush(ctx, r.Key("payment_queue"), message).Err(); requeueErr != nil {
+			return nil, fmt.Errorf("%v (requeue failed: %v)", err, requeueErr)
+		}
 	}
+	if err != nil {
+		r.release(ctx, message)
+		return payment, err
+	}
+	if r.Instance != "" {
+		r.dequeued.Store(payment, message)
+	}
+	return payment, nil
+}
 
-	var payment api.PaymentPayload
-	if err := json.Unmarshal([]byte(result[1]), &payment); err != nil {
-		return nil, err
+func (r *RedisService) dequeuePayment(ctx context.Context, timeout time.Duration) (string, error) {
+	if r.Instance != "" {
+		return r.Client.BLMove(ctx, r.Key("payment_queue"), r.processingKey(r.Instance), "LEFT", "RIGHT", timeout).Result()
 	}
 
-	return &payment, nil
+	result, err := r.Client.BLPop(ctx, timeout, r.Key("payment_queue")).Result()
+	if err != nil || len(result) < 2 {
+		return "", err
+	}
+	return result[1], nil
 }
 
 func (r *RedisService) IsDuplicate(ctx context.Context, txnRef string) (bool, error) {
-	exists, err := r.Client.Exists(ctx, "txn:"+txnRef).Result()
+	exists, err := r.Client.Exists(ctx, r.Key("txn:"+txnRef)).Result()
 	return exists > 0, err
 }
 
 func (r *RedisService) MarkDuplicate(ctx context.Context, txnRef string, ttl time.Duration) error {
-	return r.Client.SetEX(ctx, "txn:"+txnRef, "1", ttl).Err()
+	return r.Client.SetEX(ctx, r.Key("txn:"+txnRef), "1", ttl).Err()
 }
 
-func (r *RedisService) GetCachedBalance(ctx context.Context, customerID string) (*float64, error) {
-	result, err := r.Client.Get(ctx, "balance:"+customerID).Result()
-	if err == redis.Nil {
-		return nil, nil
+// ClearDuplicates forgets that the references were seen, e.g. after their
+// payments were reversed.
+func (r *RedisService) ClearDuplicates(ctx context.Context, txnRefs ...string) error {
+	if len(txnRefs) == 0 {
+		return nil
+	}
+	keys := make([]string, len(txnRefs))
+	for i, ref := range txnRefs {
+		keys[i] = r.Key("txn:" + ref)
 	}
+	return r.Client.Del(ctx, keys...).Err()
+}
+
+func (r *RedisService) EnqueuePayout(ctx context.Context, reference string) error {
+	return r.Client.RPush(ctx, r.Key("payout_queue"), reference).Err()
+}
+
+func (r *RedisService) DequeuePayout(ctx context.Context, timeout time.Duration) (string, error) {
+	result, err := r.Client.BLPop(ctx, timeout, r.Key("payout_queue")).Result()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	var balance float64
-	if _, err := fmt.Sscanf(result, "%f", &balance); err != nil {
-		return nil, err
+	if len(result) < 2 {
+		return "", nil
 	}
+	return result[1], nil
+}
+
+// QueueDepth is the number of payments waiting to be processed.
+func (r *RedisService) QueueDepth(ctx context.Context) (int64, error) {
+	return r.Client.LLen(ctx, r.Key("payment_queue")).Result()
+}
 
-	return &balance, nil
+// DeferNotification holds an encoded notification until at. Identical
+// notifications deferred together are delivered once.
+func (r *RedisService) DeferNotification(ctx context.Context, data []byte, at time.Time) error {
+	return r.Client.ZAdd(ctx, r.Key("deferred_notifications"), &redis.Z{Score: float64(at.Unix()), Member: string(data)}).Err()
 }
 
-func (r *RedisService) CacheBalance(ctx context.Context, customerID string, balance float64, ttl time.Duration) error {
-	return r.Client.SetEX(ctx, "balance:"+customerID, fmt.Sprintf("%.2f", balance), ttl).Err()
+// DueNotifications takes up to limit deferred notifications that are due
+// by now. Each is returned to only one caller.
+func (r *RedisService) DueNotifications(ctx context.Context, now time.Time, limit int64) ([][]byte, error) {
+	key := r.Key("deferred_notifications")
+	members, err := r.Client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprintf("%d", now.Unix()),
+		Count: limit,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	due := [][]byte{}
+	for _, member := range members {
+		removed, err := r.Client.ZRem(ctx, key, member).Result()
+		if err != nil {
+			return due, err
+		}
+		if removed == 1 {
+			due = append(due, []byte(member))
+		}
+	}
+	return due, nil
 }
@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"context"
+)
+
+type RegionSummary struct {
+	Region             string  `json:"region"`
+	Customers          int     `json:"customers"`
+	ActiveCustomers    int     `json:"active_customers"`
+	CompletedCustomers int     `json:"completed_customers"`
+	DelinquentAccounts int     `json:"delinquent_accounts"`
+	TotalDeployed      float64 `json:"total_deployed_value"`
+	TotalPaid          float64 `json:"total_paid_amount"`
+	TotalOutstanding   float64 `json:"total_outstanding"`
+	TotalArrears       float64 `json:"total_arrears"`
+	WrittenOffAccounts int     `json:"written_off_accounts"`
+	TotalWrittenOff    float64 `json:"total_written_off"`
+	TotalRecovered     float64 `json:"total_recovered"`
+}
+
+type DelinquentAccount struct {
+	CustomerID         string  `json:"customer_id"`
+	Region             string  `json:"region"`
+	Branch             string  `json:"branch"`
+	OutstandingBalance float64 `json:"outstanding_balance"`
+	Arrears            float64 `json:"arrears"`
+	WeeksBehind        float64 `json:"weeks_behind"`
+}
+
+func (db *DatabaseService) GetRegionalRollup(ctx context.Context, region string) ([]RegionSummary, error) {
+	query := `
+		SELECT COALESCE(region, 'UNASSIGNED'),
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE total_paid > 0),
+		       COUNT(*) FILTER (WHERE outstanding_balance = 0 AND written_off_at IS NULL),
+		       COUNT(*) FILTER (WHERE ` + arrearsExpr + ` > 0),
+		       COALESCE(SUM(asset_value), 0),
+		       COALESCE(SUM(total_paid), 0),
+		       COALESCE(SUM(outstanding_balance), 0),
+		       COALESCE(SUM(` + arrearsExpr + `), 0),
+		       COUNT(*) FILTER (WHERE written_off_at IS NOT NULL),
+		       COALESCE(SUM(written_off_amount), 0),
+		       COALESCE(SUM(recovered_amount), 0)
+		FROM customer_accounts
+		WHERE ($1 = '' OR region = $1)
+		GROUP BY COALESCE(region, 'UNASSIGNED')
+		ORDER BY 1
+	`
+
+	rows, err := db.Query(ctx, query, region)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []RegionSummary{}
+	for rows.Next() {
+		var summary RegionSummary
+		if err := rows.Scan(
+			&summary.Region,
+			&summary.Customers,
+			&summary.ActiveCustomers,
+			&summary.CompletedCustomers,
+			&summary.DelinquentAccounts,
+			&summary.TotalDeployed,
+			&summary.TotalPaid,
+			&summary.TotalOutstanding,
+			&summary.TotalArrears,
+			&summary.WrittenOffAccounts,
+			&summary.TotalWrittenOff,
+			&summary.TotalRecovered,
+		); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+func (db *DatabaseService) GetDelinquentAccounts(ctx context.Context, filter CustomerFilter, limit int) ([]DelinquentAccount, error) {
+	query := `
+		SELECT customer_id, COALESCE(region, ''), COALESCE(branch, ''), outstanding_balance,
+		       arrears, arrears / NULLIF(` + installmentExpr + `, 0)
+		FROM (
+			SELECT *, ` + arrearsExpr + ` AS arrears
+			FROM customer_accounts
+			WHERE ($1 = '' OR region = $1)
+			  AND ($2 = '' OR branch = $2)
+			  AND ($3 = '' OR asset_type = $3)
+		) accounts
+		WHERE arrears > 0
+		ORDER BY arrears DESC
+		LIMIT $4
+	`
+
+	rows, err := db.Query(ctx, query, filter.Region, filter.Branch, filter.AssetType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []DelinquentAccount{}
+	for rows.Next() {
+		var account DelinquentAccount
+		var weeksBehind *float64
+		if err := rows.Scan(
+			&account.CustomerID,
+			&account.Region,
+			&account.Branch,
+			&account.OutstandingBalance,
+			&account.Arrears,
+			&weeksBehind,
+		); err != nil {
+			return nil, err
+		}
+		if weeksBehind != nil {
+			account.WeeksBehind = roundCents(*weeksBehind)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
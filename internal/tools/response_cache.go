@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const responseCacheGenerationKey = "response_cache_generation"
+
+// ResponseCacheGeneration returns the current generation of the response
+// cache. Cached responses are keyed under it, so bumping it with
+// InvalidateResponseCache drops them all at once on every replica.
+func (r *RedisService) ResponseCacheGeneration(ctx context.Context) (int64, error) {
+	generation, err := r.Client.Get(ctx, r.Key(responseCacheGenerationKey)).Int64()
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	return generation, err
+}
+
+func (r *RedisService) InvalidateResponseCache(ctx context.Context) error {
+	return r.Client.Incr(ctx, r.Key(responseCacheGenerationKey)).Err()
+}
+
+// GetCachedResponse returns the cached response stored under key in the
+// given generation, or nil if there is none.
+func (r *RedisService) GetCachedResponse(ctx context.Context, generation int64, key string) ([]byte, error) {
+	data, err := r.Client.Get(ctx, r.responseCacheKey(generation, key)).Bytes()
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (r *RedisService) CacheResponse(ctx context.Context, generation int64, key string, data []byte, ttl time.Duration) error {
+	return r.Client.Set(ctx, r.responseCacheKey(generation, key), data, ttl).Err()
+}
+
+func (r *RedisService) responseCacheKey(generation int64, key string) string {
+	return r.Key(fmt.Sprintf("response_cache:%d:%s", generation, key))
+}
@@ -0,0 +1,132 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const restructuringColumns = `
+	id, customer_id, status, old_term_weeks, new_term_weeks, capitalize_arrears,
+	capitalized_amount, old_installment, new_installment, reason, requested_by,
+	COALESCE(decided_by, ''), COALESCE(decision_note, ''), requested_at, decided_at
+`
+
+func scanRestructuring(row pgx.Row) (*api.Restructuring, error) {
+	var r api.Restructuring
+	err := row.Scan(
+		&r.ID,
+		&r.CustomerID,
+		&r.Status,
+		&r.OldTermWeeks,
+		&r.NewTermWeeks,
+		&r.CapitalizeArrears,
+		&r.CapitalizedAmount,
+		&r.OldInstallment,
+		&r.NewInstallment,
+		&r.Reason,
+		&r.RequestedBy,
+		&r.DecidedBy,
+		&r.DecisionNote,
+		&r.RequestedAt,
+		&r.DecidedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (db *DatabaseService) CreateRestructuring(ctx context.Context, r *api.Restructuring) (*api.Restructuring, error) {
+	query := `
+		INSERT INTO account_restructurings (
+			customer_id, old_term_weeks, new_term_weeks, capitalize_arrears,
+			capitalized_amount, old_installment, new_installment, reason, requested_by
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING ` + restructuringColumns
+
+	return scanRestructuring(db.QueryRow(ctx, query,
+		r.CustomerID, r.OldTermWeeks, r.NewTermWeeks, r.CapitalizeArrears,
+		r.CapitalizedAmount, r.OldInstallment, r.NewInstallment, r.Reason, r.RequestedBy,
+	))
+}
+
+func (db *DatabaseService) GetRestructuring(ctx context.Context, id int64) (*api.Restructuring, error) {
+	query := `SELECT ` + restructuringColumns + ` FROM account_restructurings WHERE id = $1`
+
+	return scanRestructuring(db.QueryRow(ctx, query, id))
+}
+
+func (db *DatabaseService) ListRestructurings(ctx context.Context, customerID string) ([]*api.Restructuring, error) {
+	query := `
+		SELECT ` + restructuringColumns + `
+		FROM account_restructurings
+		WHERE customer_id = $1
+		ORDER BY requested_at DESC
+	`
+
+	rows, err := db.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	restructurings := []*api.Restructuring{}
+	for rows.Next() {
+		r, err := scanRestructuring(rows)
+		if err != nil {
+			return nil, err
+		}
+		restructurings = append(restructurings, r)
+	}
+	return restructurings, rows.Err()
+}
+
+// ApproveRestructuring applies the rescheduled terms and marks the request
+// approved in one statement. The account update is guarded by its version so
+// a payment landing between preview and approval forces a retry.
+func (db *DatabaseService) ApproveRestructuring(ctx context.Context, id int64, decidedBy, note string, rescheduled *api.CustomerAccount, capitalized float64) (*api.Restructuring, error) {
+	query := `
+		WITH account AS (
+			UPDATE customer_accounts
+			SET term_weeks = $4,
+			    installment_amount = $5,
+			    schedule_baseline = $6,
+			    restructured_at = $7,
+			    version = version + 1,
+			    updated_at = NOW()
+			WHERE customer_id = $8 AND version = $9
+			  AND EXISTS (SELECT 1 FROM account_restructurings WHERE id = $1 AND status = 'PENDING')
+			RETURNING customer_id
+		)
+		UPDATE account_restructurings
+		SET status = 'APPROVED',
+		    decided_by = $2,
+		    decision_note = NULLIF($3, ''),
+		    decided_at = NOW(),
+		    new_installment = $5,
+		    capitalized_amount = $10
+		WHERE id = $1 AND customer_id IN (SELECT customer_id FROM account)
+		RETURNING ` + restructuringColumns
+
+	return scanRestructuring(db.QueryRow(ctx, query,
+		id, decidedBy, note,
+		rescheduled.TermWeeks, rescheduled.InstallmentAmount, rescheduled.ScheduleBaseline, rescheduled.RestructuredAt,
+		rescheduled.CustomerID, rescheduled.Version, capitalized,
+	))
+}
+
+func (db *DatabaseService) RejectRestructuring(ctx context.Context, id int64, decidedBy, note string) (*api.Restructuring, error) {
+	query := `
+		UPDATE account_restructurings
+		SET status = 'REJECTED',
+		    decided_by = $2,
+		    decision_note = NULLIF($3, ''),
+		    decided_at = NOW()
+		WHERE id = $1 AND status = 'PENDING'
+		RETURNING ` + restructuringColumns
+
+	return scanRestructuring(db.QueryRow(ctx, query, id, decidedBy, note))
+}
@@ -0,0 +1,165 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const retentionBatchSize = 5000
+
+type RetentionPolicy struct {
+	Table     string        `json:"table"`
+	Column    string        `json:"column"`
+	Retention time.Duration `json:"retention"`
+	Archive   bool          `json:"archive"`
+}
+
+type RetentionResult struct {
+	Table       string    `json:"table"`
+	Cutoff      time.Time `json:"cutoff"`
+	Rows        int64     `json:"rows"`
+	DryRun      bool      `json:"dry_run"`
+	ArchiveKeys []string  `json:"archive_keys,omitempty"`
+}
+
+// RetentionPolicies lists the tables with a configured retention period.
+// A zero period keeps rows forever.
+func RetentionPolicies(cfg *Config) []RetentionPolicy {
+	candidates := []RetentionPolicy{
+		{Table: "processed_transactions", Column: "processed_at", Retention: cfg.RetentionProcessedTransactions},
+		{Table: "payment_history", Column: "processed_at", Retention: cfg.RetentionPaymentHistory},
+		{Table: "payment_archive", Column: "accepted_at", Retention: cfg.RetentionPaymentArchive},
+		{Table: "webhook_deliveries", Column: "delivered_at", Retention: cfg.RetentionWebhookDeliveries},
+		{Table: "webhook_events", Column: "created_at", Retention: cfg.RetentionWebhookEvents},
+		{Table: "payment_attempts", Column: "started_at", Retention: cfg.RetentionPaymentAttempts},
+	}
+
+	policies := []RetentionPolicy{}
+	for _, policy := range candidates {
+		if policy.Retention > 0 {
+			policy.Archive = cfg.RetentionArchive
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// ApplyRetention deletes rows older than the policy's retention period. When
+// archiving, each batch is written to the blob store as JSON lines before it
+// is deleted. In dry-run mode it only counts eligible rows.
+func (db *DatabaseService) ApplyRetention(ctx context.Context, policy RetentionPolicy, storage BlobStore, dryRun bool) (*RetentionResult, error) {
+	result := &RetentionResult{
+		Table:  policy.Table,
+		Cutoff: db.Now().Add(-policy.Retention),
+		DryRun: dryRun,
+	}
+
+	where := fmt.Sprintf(`%s < $1`, policy.Column)
+
+	if dryRun {
+		err := db.QueryRow(ctx, `SELECT COUNT(*) FROM `+policy.Table+` WHERE `+where, result.Cutoff).Scan(&result.Rows)
+		return result, err
+	}
+
+	if policy.Archive && storage == nil {
+		return result, fmt.Errorf("archiving %s requires blob storage", policy.Table)
+	}
+
+	for batch := 1; ; batch++ {
+		var deleted int64
+		var err error
+		if policy.Archive {
+			var key string
+			deleted, key, err = db.archiveBatch(ctx, policy, where, result.Cutoff, storage, batch)
+			if key != "" {
+				result.ArchiveKeys = append(result.ArchiveKeys, key)
+			}
+		} else {
+			deleted, err = db.deleteBatch(ctx, policy, where, result.Cutoff)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		result.Rows += deleted
+		if deleted < retentionBatchSize {
+			return result, nil
+		}
+	}
+}
+
+func (db *DatabaseService) deleteBatch(ctx context.Context, policy RetentionPolicy, where string, cutoff time.Time) (int64, error) {
+	return db.execRetention(ctx, `
+		DELETE FROM `+policy.Table+`
+		WHERE (tableoid, ctid) IN (SELECT tableoid, ctid FROM `+policy.Table+` WHERE `+where+` LIMIT $2)
+	`, cutoff, retentionBatchSize)
+}
+
+// execRetention runs a retention delete. Expired transactions go even if
+// their settlement day is closed.
+func (db *DatabaseService) execRetention(ctx context.Context, query string, args ...any) (int64, error) {
+	var deleted int64
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		if err := allowSettledChanges(ctx, tx); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, query, args...)
+		deleted = tag.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
+
+func (db *DatabaseService) archiveBatch(ctx context.Context, policy RetentionPolicy, where string, cutoff time.Time, storage BlobStore, batch int) (int64, string, error) {
+	rows, err := db.Query(ctx, `
+		SELECT tableoid::TEXT, ctid::TEXT, row_to_json(t)::TEXT
+		FROM `+policy.Table+` t
+		WHERE `+where+`
+		ORDER BY `+policy.Column+`
+		LIMIT $2
+	`, cutoff, retentionBatchSize)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var buf bytes.Buffer
+	// A ctid only identifies a row within one partition, so rows are
+	// deleted by table and ctid.
+	tables, ctids := []string{}, []string{}
+	for rows.Next() {
+		var table, ctid, row string
+		if err := rows.Scan(&table, &ctid, &row); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		tables = append(tables, table)
+		ctids = append(ctids, ctid)
+		buf.WriteString(row)
+		buf.WriteByte('\n')
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+	if len(ctids) == 0 {
+		return 0, "", nil
+	}
+
+	key := fmt.Sprintf("retention/%s/%s-%04d.jsonl", policy.Table, db.Now().UTC().Format("20060102T150405"), batch)
+	if err := storage.Put(ctx, key, &buf, "application/x-ndjson"); err != nil {
+		return 0, "", err
+	}
+
+	deleted, err := db.execRetention(ctx, `
+		DELETE FROM `+policy.Table+`
+		WHERE `+where+` AND (tableoid, ctid) IN (SELECT * FROM unnest($2::OID[], $3::TID[]))
+	`, cutoff, tables, ctids)
+	if err != nil {
+		return 0, key, err
+	}
+	return deleted, key, nil
+}
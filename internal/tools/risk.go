@@ -0,0 +1,120 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// RiskBandSummary counts scored open accounts per region and risk band.
+type RiskBandSummary struct {
+	Region           string  `json:"region"`
+	Band             string  `json:"risk_band"`
+	Accounts         int     `json:"accounts"`
+	TotalOutstanding float64 `json:"total_outstanding"`
+	AverageScore     float64 `json:"average_score"`
+}
+
+// ListOpenAccounts pages through accounts with a balance left that haven't
+// been written off, in customer ID order starting after afterID.
+func (db *DatabaseService) ListOpenAccounts(ctx context.Context, afterID string, limit int) ([]*api.CustomerAccount, error) {
+	query := `
+		SELECT ` + customerColumns + ` FROM customer_accounts
+		WHERE customer_id > $1 AND outstanding_balance > 0 AND written_off_at IS NULL
+		ORDER BY customer_id
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*api.CustomerAccount{}
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, customer)
+	}
+	return accounts, rows.Err()
+}
+
+// SaveRiskScore stores the latest risk score and payoff projection. It
+// leaves the account version alone: the score is derived data, and bumping
+// the version would fail clients' If-Match updates every night.
+func (db *DatabaseService) SaveRiskScore(ctx context.Context, customerID string, score int, projectedPayoff *time.Time, scoredAt time.Time) error {
+	query := `
+		UPDATE customer_accounts
+		SET risk_score = $2, projected_payoff_date = $3::DATE, risk_scored_at = $4
+		WHERE customer_id = $1
+	`
+
+	_, err := db.Exec(ctx, query, customerID, score, projectedPayoff, scoredAt)
+	return err
+}
+
+// GetRiskReport summarises scored open accounts by region and band.
+func (db *DatabaseService) GetRiskReport(ctx context.Context, region string) ([]RiskBandSummary, error) {
+	query := `
+		SELECT COALESCE(region, 'UNASSIGNED'),
+		       CASE WHEN risk_score >= 67 THEN 'HIGH' WHEN risk_score >= 34 THEN 'MEDIUM' ELSE 'LOW' END AS band,
+		       COUNT(*),
+		       COALESCE(SUM(outstanding_balance), 0),
+		       COALESCE(AVG(risk_score), 0)
+		FROM customer_accounts
+		WHERE risk_score IS NOT NULL AND outstanding_balance > 0 AND written_off_at IS NULL
+		  AND ($1 = '' OR region = $1)
+		GROUP BY 1, 2
+		ORDER BY 1, 2
+	`
+
+	rows, err := db.Query(ctx, query, region)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []RiskBandSummary{}
+	for rows.Next() {
+		var summary RiskBandSummary
+		if err := rows.Scan(&summary.Region, &summary.Band, &summary.Accounts, &summary.TotalOutstanding, &summary.AverageScore); err != nil {
+			return nil, err
+		}
+		summary.AverageScore = roundCents(summary.AverageScore)
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+// GetHighestRiskAccounts lists open accounts by descending risk score.
+func (db *DatabaseService) GetHighestRiskAccounts(ctx context.Context, filter CustomerFilter, minScore, limit int) ([]*api.CustomerAccount, error) {
+	query := `
+		SELECT ` + customerColumns + ` FROM customer_accounts
+		WHERE risk_score >= $3 AND outstanding_balance > 0 AND written_off_at IS NULL
+		  AND ($1 = '' OR region = $1)
+		  AND ($2 = '' OR branch = $2)
+		  AND ($5 = '' OR asset_type = $5)
+		ORDER BY risk_score DESC, outstanding_balance DESC
+		LIMIT $4
+	`
+
+	rows, err := db.Query(ctx, query, filter.Region, filter.Branch, minScore, limit, filter.AssetType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*api.CustomerAccount{}
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, customer)
+	}
+	return accounts, rows.Err()
+}
@@ -0,0 +1,133 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrRuleNameTaken is returned when saving a payment rule under another
+// rule's name.
+var ErrRuleNameTaken = duplicateError("a payment rule with this name already exists")
+
+const paymentRuleColumns = `id, name, COALESCE(description, ''), position, enabled, conditions, action,
+	COALESCE(priority, ''), COALESCE(target_customer_id, ''), COALESCE(message, ''), updated_by, created_at, updated_at`
+
+func scanPaymentRule(row pgx.Row) (*api.PaymentRule, error) {
+	var rule api.PaymentRule
+	var conditions []byte
+	err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&rule.Description,
+		&rule.Position,
+		&rule.Enabled,
+		&conditions,
+		&rule.Action,
+		&rule.Priority,
+		&rule.TargetCustomerID,
+		&rule.Message,
+		&rule.UpdatedBy,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(conditions, &rule.Conditions); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (db *DatabaseService) CreatePaymentRule(ctx context.Context, rule *api.PaymentRule) error {
+	conditions, err := json.Marshal(rule.Conditions)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO payment_rules (name, description, position, enabled, conditions, action, priority, target_customer_id, message, updated_by)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id, created_at, updated_at
+	`
+
+	err = db.QueryRow(ctx, query, rule.Name, rule.Description, rule.Position, rule.Enabled, json.RawMessage(conditions), rule.Action,
+		rule.Priority, rule.TargetCustomerID, rule.Message, rule.UpdatedBy).Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+	if errors.Is(err, ErrNotFound) {
+		return ErrRuleNameTaken
+	}
+	return err
+}
+
+// UpdatePaymentRule replaces the rule with the given ID. It returns
+// ErrNotFound if there is no such rule.
+func (db *DatabaseService) UpdatePaymentRule(ctx context.Context, rule *api.PaymentRule) error {
+	conditions, err := json.Marshal(rule.Conditions)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE payment_rules
+		SET name = $2, description = NULLIF($3, ''), position = $4, enabled = $5, conditions = $6, action = $7,
+		    priority = NULLIF($8, ''), target_customer_id = NULLIF($9, ''), message = NULLIF($10, ''),
+		    updated_by = $11, updated_at = NOW()
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM payment_rules WHERE name = $2 AND id <> $1)
+		RETURNING created_at, updated_at
+	`
+
+	err = db.QueryRow(ctx, query, rule.ID, rule.Name, rule.Description, rule.Position, rule.Enabled, json.RawMessage(conditions), rule.Action,
+		rule.Priority, rule.TargetCustomerID, rule.Message, rule.UpdatedBy).Scan(&rule.CreatedAt, &rule.UpdatedAt)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if _, err := db.GetPaymentRule(ctx, rule.ID); err != nil {
+		return err
+	}
+	return ErrRuleNameTaken
+}
+
+func (db *DatabaseService) GetPaymentRule(ctx context.Context, id int64) (*api.PaymentRule, error) {
+	return scanPaymentRule(db.QueryRow(ctx, `SELECT `+paymentRuleColumns+` FROM payment_rules WHERE id = $1`, id))
+}
+
+func (db *DatabaseService) DeletePaymentRule(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.Exec(ctx, `DELETE FROM payment_rules WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListPaymentRules returns every rule in evaluation order.
+func (db *DatabaseService) ListPaymentRules(ctx context.Context) ([]*api.PaymentRule, error) {
+	return db.queryPaymentRules(ctx, `SELECT `+paymentRuleColumns+` FROM payment_rules ORDER BY position, id`)
+}
+
+// LoadPaymentRules returns the enabled rules in evaluation order.
+func (db *DatabaseService) LoadPaymentRules(ctx context.Context) ([]*api.PaymentRule, error) {
+	return db.queryPaymentRules(ctx, `SELECT `+paymentRuleColumns+` FROM payment_rules WHERE enabled ORDER BY position, id`)
+}
+
+func (db *DatabaseService) queryPaymentRules(ctx context.Context, query string) ([]*api.PaymentRule, error) {
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*api.PaymentRule{}
+	for rows.Next() {
+		rule, err := scanPaymentRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
@@ -0,0 +1,377 @@
+package tools
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxReportRows caps how many groups one saved report run returns.
+const MaxReportRows = 10000
+
+// reportDimensions are the columns saved reports may group accounts by.
+var reportDimensions = map[string]string{
+	"region":           `COALESCE(region, 'UNASSIGNED')`,
+	"branch":           `COALESCE(branch, 'UNASSIGNED')`,
+	"product_id":       `COALESCE(product_id, 'NONE')`,
+	"asset_type":       `COALESCE(asset_type, 'NONE')`,
+	"calendar_code":    `COALESCE(calendar_code, 'DEFAULT')`,
+	"status":           accountStatusExpr,
+	"risk_band":        `CASE WHEN risk_score IS NULL THEN 'UNSCORED' WHEN risk_score >= 67 THEN 'HIGH' WHEN risk_score >= 34 THEN 'MEDIUM' ELSE 'LOW' END`,
+	"deployment_month": `TO_CHAR(deployment_date, 'YYYY-MM')`,
+}
+
+// reportMeasures are the aggregates saved reports may compute per group.
+var reportMeasures = map[string]string{
+	"accounts":            `COUNT(*)`,
+	"delinquent_accounts": `COUNT(*) FILTER (WHERE ` + arrearsExpr + ` > 0)`,
+	"asset_value":         `COALESCE(SUM(asset_value), 0)`,
+	"total_paid":          `COALESCE(SUM(total_paid), 0)`,
+	"outstanding_balance": `COALESCE(SUM(outstanding_balance), 0)`,
+	"arrears":             `COALESCE(SUM(` + arrearsExpr + `), 0)`,
+	"payment_count":       `COALESCE(SUM(payment_count), 0)`,
+	"written_off_amount":  `COALESCE(SUM(written_off_amount), 0)`,
+	"recovered_amount":    `COALESCE(SUM(recovered_amount), 0)`,
+	"average_risk_score":  `COALESCE(ROUND(AVG(risk_score), 2), 0)`,
+}
+
+// reportFilters are the conditions saved reports may narrow accounts by;
+// each takes the filter value as its only parameter.
+var reportFilters = map[string]string{
+	"region":          `region = %s`,
+	"branch":          `branch = %s`,
+	"product_id":      `product_id = %s`,
+	"asset_type":      `asset_type = %s`,
+	"status":          accountStatusExpr + ` = %s`,
+	"deployed_from":   `deployment_date >= %s::DATE`,
+	"deployed_to":     `deployment_date < %s::DATE + 1`,
+	"min_outstanding": `outstanding_balance >= %s::DECIMAL`,
+	"min_risk_score":  `risk_score >= %s::SMALLINT`,
+}
+
+const accountStatusExpr = `CASE WHEN written_off_at IS NOT NULL THEN 'WRITTEN_OFF' WHEN outstanding_balance = 0 THEN 'COMPLETED' ELSE 'ACTIVE' END`
+
+// ReportFields lists the dimension, measure and filter names saved reports
+// can use.
+func ReportFields() map[string][]string {
+	return map[string][]string{
+		"dimensions": reportFieldNames(reportDimensions),
+		"measures":   reportFieldNames(reportMeasures),
+		"filters":    reportFieldNames(reportFilters),
+	}
+}
+
+func reportFieldNames(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateSavedReport checks every field the report uses is whitelisted.
+func ValidateSavedReport(report *api.SavedReport) error {
+	seen := map[string]bool{}
+	for _, name := range report.Dimensions {
+		if _, ok := reportDimensions[name]; !ok {
+			return fmt.Errorf("unknown dimension %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("dimension %q listed twice", name)
+		}
+		seen[name] = true
+	}
+	for _, name := range report.Measures {
+		if _, ok := reportMeasures[name]; !ok {
+			return fmt.Errorf("unknown measure %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("column %q listed twice", name)
+		}
+		seen[name] = true
+	}
+	for name := range report.Filters {
+		if _, ok := reportFilters[name]; !ok {
+			return fmt.Errorf("unknown filter %q", name)
+		}
+	}
+	if report.Schedule != "" && report.Delivery == (api.ReportDelivery{}) {
+		return fmt.Errorf("scheduled reports need at least one delivery target")
+	}
+	return nil
+}
+
+// buildReportQuery turns a validated report into SQL. Only whitelisted
+// expressions are interpolated; filter values are always parameters.
+func buildReportQuery(report *api.SavedReport) (string, []any) {
+	columns := []string{}
+	for _, name := range report.Dimensions {
+		columns = append(columns, reportDimensions[name]+`::TEXT`)
+	}
+	for _, name := range report.Measures {
+		columns = append(columns, reportMeasures[name]+`::FLOAT8`)
+	}
+
+	conditions := []string{}
+	args := []any{}
+	names := make([]string, 0, len(report.Filters))
+	for name := range report.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, report.Filters[name])
+		conditions = append(conditions, fmt.Sprintf(reportFilters[name], fmt.Sprintf("$%d", len(args))))
+	}
+
+	query := `SELECT ` + strings.Join(columns, ", ") + ` FROM customer_accounts`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	if len(report.Dimensions) > 0 {
+		positions := make([]string, len(report.Dimensions))
+		for i := range positions {
+			positions[i] = fmt.Sprintf("%d", i+1)
+		}
+		query += ` GROUP BY ` + strings.Join(positions, ", ") + ` ORDER BY ` + strings.Join(positions, ", ")
+	}
+	query += fmt.Sprintf(` LIMIT %d`, MaxReportRows+1)
+	return query, args
+}
+
+// RunSavedReport executes the report against the current account data.
+func (db *DatabaseService) RunSavedReport(ctx context.Context, report *api.SavedReport) (*api.ReportResult, error) {
+	if err := ValidateSavedReport(report); err != nil {
+		return nil, err
+	}
+
+	query, args := buildReportQuery(report)
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &api.ReportResult{
+		ReportID:    report.ReportID,
+		Name:        report.Name,
+		Columns:     append(append([]string{}, report.Dimensions...), report.Measures...),
+		Rows:        [][]interface{}{},
+		GeneratedAt: db.Now(),
+	}
+	for rows.Next() {
+		if len(result.Rows) == MaxReportRows {
+			result.Truncated = true
+			break
+		}
+
+		dimensions := make([]string, len(report.Dimensions))
+		measures := make([]float64, len(report.Measures))
+		dest := make([]any, 0, len(result.Columns))
+		for i := range dimensions {
+			dest = append(dest, &dimensions[i])
+		}
+		for i := range measures {
+			dest = append(dest, &measures[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make([]interface{}, 0, len(result.Columns))
+		for _, value := range dimensions {
+			row = append(row, value)
+		}
+		for _, value := range measures {
+			row = append(row, value)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, rows.Err()
+}
+
+// WriteReportCSV writes the result as CSV with a header row.
+func WriteReportCSV(out io.Writer, result *api.ReportResult) error {
+	w := csv.NewWriter(out)
+	w.Write(result.Columns)
+	for _, row := range result.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			switch v := value.(type) {
+			case float64:
+				record[i] = fmt.Sprintf("%.2f", v)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// SavedReportBlobKey is where a run of the report is stored.
+func SavedReportBlobKey(reportID string, at time.Time) string {
+	return fmt.Sprintf("reports/%s/%s.csv", reportID, at.UTC().Format("20060102T150405Z"))
+}
+
+// NextReportRun is when a report on the given schedule next falls due
+// after from, or nil for on-demand reports.
+func NextReportRun(schedule string, from time.Time) *time.Time {
+	var next time.Time
+	switch schedule {
+	case "HOURLY":
+		next = from.Add(time.Hour)
+	case "DAILY":
+		next = from.AddDate(0, 0, 1)
+	case "WEEKLY":
+		next = from.AddDate(0, 0, 7)
+	default:
+		return nil
+	}
+	return &next
+}
+
+const savedReportColumns = `report_id, name, dimensions, measures, filters, COALESCE(schedule, ''),
+	COALESCE(deliver_email, ''), COALESCE(deliver_webhook_url, ''), deliver_storage,
+	created_by, next_run_at, last_run_at, COALESCE(last_run_status, ''), created_at`
+
+func scanSavedReport(row pgx.Row) (*api.SavedReport, error) {
+	var report api.SavedReport
+	err := row.Scan(
+		&report.ReportID,
+		&report.Name,
+		&report.Dimensions,
+		&report.Measures,
+		&report.Filters,
+		&report.Schedule,
+		&report.Delivery.Email,
+		&report.Delivery.WebhookURL,
+		&report.Delivery.Storage,
+		&report.CreatedBy,
+		&report.NextRunAt,
+		&report.LastRunAt,
+		&report.LastRunStatus,
+		&report.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// SaveReport creates or replaces a saved report definition. Changing the
+// schedule restarts it from now.
+func (db *DatabaseService) SaveReport(ctx context.Context, report *api.SavedReport) (*api.SavedReport, error) {
+	if report.Dimensions == nil {
+		report.Dimensions = []string{}
+	}
+	if report.Filters == nil {
+		report.Filters = map[string]string{}
+	}
+	filters, err := json.Marshal(report.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO saved_reports (report_id, name, dimensions, measures, filters, schedule,
+		                           deliver_email, deliver_webhook_url, deliver_storage, created_by, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11)
+		ON CONFLICT (report_id) DO UPDATE
+		SET name = EXCLUDED.name,
+		    dimensions = EXCLUDED.dimensions,
+		    measures = EXCLUDED.measures,
+		    filters = EXCLUDED.filters,
+		    deliver_email = EXCLUDED.deliver_email,
+		    deliver_webhook_url = EXCLUDED.deliver_webhook_url,
+		    deliver_storage = EXCLUDED.deliver_storage,
+		    next_run_at = CASE WHEN saved_reports.schedule IS NOT DISTINCT FROM EXCLUDED.schedule
+		                       THEN saved_reports.next_run_at ELSE EXCLUDED.next_run_at END,
+		    schedule = EXCLUDED.schedule
+		RETURNING ` + savedReportColumns
+
+	return scanSavedReport(db.QueryRow(ctx, query,
+		report.ReportID,
+		report.Name,
+		report.Dimensions,
+		report.Measures,
+		json.RawMessage(filters),
+		report.Schedule,
+		report.Delivery.Email,
+		report.Delivery.WebhookURL,
+		report.Delivery.Storage,
+		report.CreatedBy,
+		NextReportRun(report.Schedule, db.Now()),
+	))
+}
+
+func (db *DatabaseService) GetSavedReport(ctx context.Context, reportID string) (*api.SavedReport, error) {
+	query := `SELECT ` + savedReportColumns + ` FROM saved_reports WHERE report_id = $1`
+	return scanSavedReport(db.QueryRow(ctx, query, reportID))
+}
+
+func (db *DatabaseService) ListSavedReports(ctx context.Context) ([]*api.SavedReport, error) {
+	return db.querySavedReports(ctx, `SELECT `+savedReportColumns+` FROM saved_reports ORDER BY report_id`)
+}
+
+// ListDueReports returns scheduled reports whose next run is at or before
+// now.
+func (db *DatabaseService) ListDueReports(ctx context.Context, now time.Time) ([]*api.SavedReport, error) {
+	query := `
+		SELECT ` + savedReportColumns + ` FROM saved_reports
+		WHERE schedule IS NOT NULL AND next_run_at <= $1
+		ORDER BY next_run_at
+	`
+	return db.querySavedReports(ctx, query, now)
+}
+
+func (db *DatabaseService) querySavedReports(ctx context.Context, query string, args ...any) ([]*api.SavedReport, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*api.SavedReport{}
+	for rows.Next() {
+		report, err := scanSavedReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (db *DatabaseService) DeleteSavedReport(ctx context.Context, reportID string) error {
+	tag, err := db.Exec(ctx, `DELETE FROM saved_reports WHERE report_id = $1`, reportID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+// RecordReportRun stores the outcome of a run and, for scheduled runs, when
+// the report is next due.
+func (db *DatabaseService) RecordReportRun(ctx context.Context, reportID, status string, ranAt time.Time, nextRun *time.Time) error {
+	query := `
+		UPDATE saved_reports
+		SET last_run_at = $2, last_run_status = $3, next_run_at = COALESCE($4, next_run_at)
+		WHERE report_id = $1
+	`
+	_, err := db.Exec(ctx, query, reportID, ranAt, status, nextRun)
+	return err
+}
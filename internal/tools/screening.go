@@ -0,0 +1,248 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrHoldReviewed is returned when deciding on a hold that was already
+// rejected.
+var ErrHoldReviewed = errors.New("hold has already been reviewed")
+
+// AddScreeningEntry adds a blacklist entry. Phone numbers are stored as the
+// same key customer_identifiers uses, so they match without being kept in
+// plain text when PII encryption is on.
+func (db *DatabaseService) AddScreeningEntry(ctx context.Context, entry *api.ScreeningEntry) error {
+	value, display := entry.Value, entry.Value
+	switch entry.EntryType {
+	case api.ScreeningPhone:
+		normalized := NormalizeIdentifier(api.IdentifierPhone, entry.Value)
+		if normalized == "" {
+			return errors.New("phone number has no digits")
+		}
+		value = db.identifierKey(api.IdentifierPhone, normalized)
+		display = maskPhone(normalized)
+	case api.ScreeningCustomerID:
+		value = strings.TrimSpace(entry.Value)
+		display = value
+	}
+
+	query := `
+		INSERT INTO screening_entries (entry_type, value, display_value, reason, added_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	if err := db.QueryRow(ctx, query, entry.EntryType, value, display, entry.Reason, entry.AddedBy).Scan(&entry.ID, &entry.CreatedAt); err != nil {
+		return err
+	}
+	entry.Value = display
+	return nil
+}
+
+// maskPhone keeps only the last four digits.
+func maskPhone(digits string) string {
+	if len(digits) <= 4 {
+		return strings.Repeat("*", len(digits))
+	}
+	return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
+
+func (db *DatabaseService) ListScreeningEntries(ctx context.Context, entryType string) ([]api.ScreeningEntry, error) {
+	query := `
+		SELECT id, entry_type, display_value, reason, added_by, created_at
+		FROM screening_entries
+		WHERE ($1 = '' OR entry_type = $1)
+		ORDER BY id
+	`
+
+	rows, err := db.Query(ctx, query, entryType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []api.ScreeningEntry{}
+	for rows.Next() {
+		var entry api.ScreeningEntry
+		if err := rows.Scan(&entry.ID, &entry.EntryType, &entry.Value, &entry.Reason, &entry.AddedBy, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (db *DatabaseService) DeleteScreeningEntry(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.Exec(ctx, `DELETE FROM screening_entries WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// MatchScreeningEntry returns the first entry the payment matches, or nil.
+func (db *DatabaseService) MatchScreeningEntry(ctx context.Context, payment *api.PaymentPayload) (*api.ScreeningEntry, error) {
+	// Entries for the customer cover all of their accounts, so match the
+	// account holder as well as the account.
+	query := `
+		WITH ids AS (
+			SELECT $1::VARCHAR AS id
+			UNION
+			SELECT holder_id FROM customer_accounts WHERE customer_id = $1 AND holder_id IS NOT NULL
+		)
+		SELECT id, entry_type, display_value, reason, added_by, created_at
+		FROM screening_entries
+		WHERE (entry_type = 'CUSTOMER_ID' AND value IN (SELECT id FROM ids))
+		   OR (entry_type = 'REFERENCE_PATTERN' AND $2 ILIKE value)
+		   OR (entry_type = 'PHONE' AND value IN (
+		          SELECT identifier_value FROM customer_identifiers
+		          WHERE customer_id IN (SELECT id FROM ids) AND identifier_type = 'phone'))
+		ORDER BY id
+		LIMIT 1
+	`
+
+	var entry api.ScreeningEntry
+	err := db.QueryRow(ctx, query, payment.CustomerID, payment.TransactionReference).Scan(
+		&entry.ID, &entry.EntryType, &entry.Value, &entry.Reason, &entry.AddedBy, &entry.CreatedAt,
+	)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// HoldPayment stores the payment for review. A payment already held keeps
+// its original hold; the returned hold is whichever is on record.
+func (db *DatabaseService) HoldPayment(ctx context.Context, payment *api.PaymentPayload, entry *api.ScreeningEntry) (*api.ScreeningHold, error) {
+	return db.holdPayment(ctx, payment, &entry.ID, entry.EntryType, entry.Value)
+}
+
+// HoldPaymentForRule stores the payment for review under a HOLD payment
+// rule, alongside the screening holds.
+func (db *DatabaseService) HoldPaymentForRule(ctx context.Context, payment *api.PaymentPayload, rule *api.PaymentRule) (*api.ScreeningHold, error) {
+	return db.holdPayment(ctx, payment, nil, api.ScreeningRule, rule.Name)
+}
+
+func (db *DatabaseService) holdPayment(ctx context.Context, payment *api.PaymentPayload, entryID *int64, entryType, matched string) (*api.ScreeningHold, error) {
+	data, err := json.Marshal(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO screening_holds (transaction_reference, customer_id, entry_id, entry_type, matched_value, payload)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (transaction_reference, entry_type, matched_value) DO NOTHING
+	`
+
+	if _, err := db.Exec(ctx, query, payment.TransactionReference, payment.CustomerID, entryID, entryType, matched, json.RawMessage(data)); err != nil {
+		return nil, err
+	}
+	return db.getScreeningHold(ctx, `transaction_reference = $1 AND entry_type = $2 AND matched_value = $3`,
+		payment.TransactionReference, entryType, matched)
+}
+
+// GetScreeningHoldByReference returns the screening entry hold for a
+// transaction, or ErrNotFound if it was never held by one. Holds placed by
+// payment rules are looked up with GetRuleHold.
+func (db *DatabaseService) GetScreeningHoldByReference(ctx context.Context, reference string) (*api.ScreeningHold, error) {
+	return db.getScreeningHold(ctx, `transaction_reference = $1 AND entry_type <> $2 ORDER BY id LIMIT 1`, reference, api.ScreeningRule)
+}
+
+// GetRuleHold returns the hold the named payment rule placed on a
+// transaction, or ErrNotFound if that rule never held it.
+func (db *DatabaseService) GetRuleHold(ctx context.Context, reference, rule string) (*api.ScreeningHold, error) {
+	return db.getScreeningHold(ctx, `transaction_reference = $1 AND entry_type = $2 AND matched_value = $3`, reference, api.ScreeningRule, rule)
+}
+
+func (db *DatabaseService) GetScreeningHold(ctx context.Context, id int64) (*api.ScreeningHold, error) {
+	return db.getScreeningHold(ctx, `id = $1`, id)
+}
+
+const screeningHoldColumns = `id, transaction_reference, customer_id, entry_id, entry_type, matched_value, payload,
+	status, COALESCE(reviewed_by, ''), COALESCE(notes, ''), created_at, reviewed_at`
+
+func scanScreeningHold(row pgx.Row) (*api.ScreeningHold, error) {
+	var hold api.ScreeningHold
+	var data []byte
+	err := row.Scan(
+		&hold.ID,
+		&hold.TransactionReference,
+		&hold.CustomerID,
+		&hold.EntryID,
+		&hold.EntryType,
+		&hold.MatchedValue,
+		&data,
+		&hold.Status,
+		&hold.ReviewedBy,
+		&hold.Notes,
+		&hold.CreatedAt,
+		&hold.ReviewedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &hold.Payment); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+func (db *DatabaseService) getScreeningHold(ctx context.Context, where string, args ...any) (*api.ScreeningHold, error) {
+	query := `SELECT ` + screeningHoldColumns + ` FROM screening_holds WHERE ` + where
+	return scanScreeningHold(db.QueryRow(ctx, query, args...))
+}
+
+func (db *DatabaseService) ListScreeningHolds(ctx context.Context, status string, limit int) ([]*api.ScreeningHold, error) {
+	query := `
+		SELECT ` + screeningHoldColumns + ` FROM screening_holds
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY created_at, id
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []*api.ScreeningHold{}
+	for rows.Next() {
+		hold, err := scanScreeningHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+// ReviewScreeningHold records the decision on a hold. Releasing an already
+// released hold is allowed so a failed re-queue can be retried; nothing
+// moves out of REJECTED.
+func (db *DatabaseService) ReviewScreeningHold(ctx context.Context, id int64, status, reviewer, notes string) (*api.ScreeningHold, error) {
+	query := `
+		UPDATE screening_holds
+		SET status = $2, reviewed_by = $3, notes = $4, reviewed_at = NOW()
+		WHERE id = $1 AND (status = 'HELD' OR (status = 'RELEASED' AND $2 = 'RELEASED'))
+		RETURNING ` + screeningHoldColumns
+
+	hold, err := scanScreeningHold(db.QueryRow(ctx, query, id, status, reviewer, notes))
+	if !errors.Is(err, ErrNotFound) {
+		return hold, err
+	}
+	if _, err := db.GetScreeningHold(ctx, id); err != nil {
+		return nil, err
+	}
+	return nil, ErrHoldReviewed
+}
@@ -0,0 +1,288 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrSettlementClosed is returned when closing a day that is already
+	// closed, or changing transactions of a closed day.
+	ErrSettlementClosed = errors.New("settlement day is closed")
+	// ErrSettlementNotClosed is returned when reopening a day that isn't
+	// closed.
+	ErrSettlementNotClosed = errors.New("settlement day is not closed")
+)
+
+const settlementBatchColumns = `
+	to_char(business_date, 'YYYY-MM-DD'), status, payments, total_amount, customers, adjustments, adjustment_total,
+	fees, fee_total, fee_tax, closed_by, closed_at, COALESCE(reopened_by, ''), COALESCE(reopen_reason, ''), reopened_at
+`
+
+func scanSettlementBatch(row pgx.Row) (*api.SettlementBatch, error) {
+	var b api.SettlementBatch
+	err := row.Scan(
+		&b.BusinessDate,
+		&b.Status,
+		&b.Payments,
+		&b.TotalAmount,
+		&b.Customers,
+		&b.Adjustments,
+		&b.AdjustmentTotal,
+		&b.Fees,
+		&b.FeeTotal,
+		&b.FeeTax,
+		&b.ClosedBy,
+		&b.ClosedAt,
+		&b.ReopenedBy,
+		&b.ReopenReason,
+		&b.ReopenedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// settlementSummary totals a business day's processed transactions, with
+// adjustments apart from payments, and its fees. $1 is the day.
+const settlementSummary = `
+	SELECT p.payments, p.total_amount, p.customers, p.adjustments, p.adjustment_total, f.fees, f.fee_total, f.fee_tax
+	FROM (
+		SELECT COUNT(*) FILTER (WHERE a.id IS NULL) AS payments,
+		       COALESCE(SUM(t.amount) FILTER (WHERE a.id IS NULL), 0) AS total_amount,
+		       COUNT(DISTINCT t.customer_id) AS customers,
+		       COUNT(a.id) AS adjustments,
+		       COALESCE(SUM(a.amount), 0) AS adjustment_total
+		FROM processed_transactions t
+		LEFT JOIN settlement_adjustments a ON a.reference = t.transaction_reference
+		WHERE t.processed_at >= $1::DATE AND t.processed_at < $1::DATE + 1
+	) p, (
+		SELECT COUNT(*) AS fees, COALESCE(SUM(net_amount), 0) AS fee_total, COALESCE(SUM(tax_amount), 0) AS fee_tax
+		FROM fee_charges
+		WHERE charged_at >= $1::DATE AND charged_at < $1::DATE + 1
+	) f
+`
+
+// allowSettledChanges lets tx change processed transactions of closed days.
+// Only housekeeping that keeps them as they are uses it: archiving,
+// retention and merges.
+func allowSettledChanges(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `SELECT set_config('app.settlement_override', 'on', TRUE)`)
+	return err
+}
+
+// GetSettlementBatch returns the day's batch: as closed if it has been,
+// otherwise its live totals with status OPEN or REOPENED.
+func (db *DatabaseService) GetSettlementBatch(ctx context.Context, day time.Time) (*api.SettlementBatch, error) {
+	batch, err := scanSettlementBatch(db.QueryRow(ctx,
+		`SELECT `+settlementBatchColumns+` FROM settlement_batches WHERE business_date = $1`, day))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if batch != nil && batch.Status == api.SettlementClosed {
+		return batch, nil
+	}
+	if batch == nil {
+		batch = &api.SettlementBatch{BusinessDate: day.Format("2006-01-02"), Status: api.SettlementOpen}
+	}
+
+	err = db.QueryRow(ctx, settlementSummary, day).
+		Scan(&batch.Payments, &batch.TotalAmount, &batch.Customers, &batch.Adjustments, &batch.AdjustmentTotal,
+			&batch.Fees, &batch.FeeTotal, &batch.FeeTax)
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// ListSettlementBatches returns the batches of days closed or reopened
+// between from and to inclusive, latest first.
+func (db *DatabaseService) ListSettlementBatches(ctx context.Context, from, to time.Time) ([]*api.SettlementBatch, error) {
+	query := `
+		SELECT ` + settlementBatchColumns + `
+		FROM settlement_batches
+		WHERE business_date BETWEEN $1 AND $2
+		ORDER BY business_date DESC
+	`
+
+	rows, err := db.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := []*api.SettlementBatch{}
+	for rows.Next() {
+		batch, err := scanSettlementBatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	return batches, rows.Err()
+}
+
+// CloseSettlementBatch closes the day, fixing its totals. A reopened day
+// can be closed again, with its totals recomputed. It returns
+// ErrSettlementClosed if the day is already closed.
+func (db *DatabaseService) CloseSettlementBatch(ctx context.Context, day time.Time, closedBy string) (*api.SettlementBatch, error) {
+	query := `
+		WITH summary AS (` + settlementSummary + `)
+		INSERT INTO settlement_batches (business_date, status, payments, total_amount, customers, adjustments, adjustment_total,
+		                                fees, fee_total, fee_tax, closed_by)
+		SELECT $1, 'CLOSED', payments, total_amount, customers, adjustments, adjustment_total, fees, fee_total, fee_tax, $2
+		FROM summary
+		ON CONFLICT (business_date) DO UPDATE SET
+			status = 'CLOSED',
+			payments = EXCLUDED.payments,
+			total_amount = EXCLUDED.total_amount,
+			customers = EXCLUDED.customers,
+			adjustments = EXCLUDED.adjustments,
+			adjustment_total = EXCLUDED.adjustment_total,
+			fees = EXCLUDED.fees,
+			fee_total = EXCLUDED.fee_total,
+			fee_tax = EXCLUDED.fee_tax,
+			closed_by = EXCLUDED.closed_by,
+			closed_at = NOW()
+		WHERE settlement_batches.status <> 'CLOSED'
+		RETURNING ` + settlementBatchColumns
+
+	batch, err := scanSettlementBatch(db.QueryRow(ctx, query, day, closedBy))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrSettlementClosed
+	}
+	return batch, err
+}
+
+// ReopenSettlementBatch reopens a closed day so its transactions can be
+// changed again. It returns ErrSettlementNotClosed if the day isn't closed.
+func (db *DatabaseService) ReopenSettlementBatch(ctx context.Context, day time.Time, reopenedBy, reason string) (*api.SettlementBatch, error) {
+	query := `
+		UPDATE settlement_batches
+		SET status = 'REOPENED',
+		    reopened_by = $2,
+		    reopen_reason = $3,
+		    reopened_at = NOW()
+		WHERE business_date = $1 AND status = 'CLOSED'
+		RETURNING ` + settlementBatchColumns
+
+	batch, err := scanSettlementBatch(db.QueryRow(ctx, query, day, reopenedBy, reason))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrSettlementNotClosed
+	}
+	return batch, err
+}
+
+// settledPayments reports whether any processed transaction matched by
+// where (with args) falls on a closed day.
+func settledPayments(ctx context.Context, tx pgx.Tx, where string, args ...any) (bool, error) {
+	var settled bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM processed_transactions t
+			JOIN settlement_batches b ON b.business_date = t.processed_at::DATE AND b.status = 'CLOSED'
+			WHERE `+where+`
+		)
+	`, args...).Scan(&settled)
+	return settled, err
+}
+
+// PostSettlementAdjustment records the adjustment as a transaction on the
+// current business day, referenced ADJ-<id>, and applies it to the
+// account. It counts as a payment, so the ledger check agrees with it.
+func (db *DatabaseService) PostSettlementAdjustment(ctx context.Context, adjustment *api.SettlementAdjustment) (*api.SettlementAdjustment, error) {
+	var posted *api.SettlementAdjustment
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		posted, err = postAdjustment(ctx, tx, adjustment)
+		return err
+	})
+	return posted, err
+}
+
+func postAdjustment(ctx context.Context, tx pgx.Tx, adjustment *api.SettlementAdjustment) (*api.SettlementAdjustment, error) {
+	posted := *adjustment
+	err := tx.QueryRow(ctx, `
+		WITH next AS (SELECT nextval(pg_get_serial_sequence('settlement_adjustments', 'id')) AS id)
+		INSERT INTO settlement_adjustments (id, reference, business_date, customer_id, amount, reason, corrects_reference, created_by)
+		SELECT id, 'ADJ-' || id, NOW()::DATE, $1, $2, $3, NULLIF($4, ''), $5
+		FROM next
+		RETURNING id, reference, to_char(business_date, 'YYYY-MM-DD'), created_at
+	`, adjustment.CustomerID, adjustment.Amount, adjustment.Reason, adjustment.CorrectsReference, adjustment.CreatedBy).
+		Scan(&posted.ID, &posted.Reference, &posted.BusinessDate, &posted.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := api.Metadata{"adjustment_id": posted.ID, "reason": posted.Reason}
+	if posted.CorrectsReference != "" {
+		metadata["corrects_reference"] = posted.CorrectsReference
+	}
+	_, err = tx.Exec(ctx, `
+		WITH claimed AS (
+			INSERT INTO processed_references (transaction_reference, processed_at)
+			VALUES ($1, NOW())
+			RETURNING processed_at
+		)
+		INSERT INTO processed_transactions (transaction_reference, customer_id, amount, processed_at, metadata)
+		SELECT $1, $2, $3, processed_at, $4
+		FROM claimed
+	`, posted.Reference, posted.CustomerID, posted.Amount, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE customer_accounts
+		SET total_paid = total_paid + $2,
+		    outstanding_balance = outstanding_balance - $2,
+		    payment_count = payment_count + 1,
+		    last_payment_date = CASE WHEN $2 > 0 THEN NOW() ELSE last_payment_date END,
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE customer_id = $1
+	`, posted.CustomerID, posted.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, fmt.Errorf("customer %s not found", posted.CustomerID)
+	}
+	return &posted, nil
+}
+
+// ListSettlementAdjustments returns the adjustments posted on day, oldest
+// first.
+func (db *DatabaseService) ListSettlementAdjustments(ctx context.Context, day time.Time) ([]*api.SettlementAdjustment, error) {
+	query := `
+		SELECT id, reference, to_char(business_date, 'YYYY-MM-DD'), customer_id, amount, reason,
+		       COALESCE(corrects_reference, ''), created_by, created_at
+		FROM settlement_adjustments
+		WHERE business_date = $1
+		ORDER BY id
+	`
+
+	rows, err := db.Query(ctx, query, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := []*api.SettlementAdjustment{}
+	for rows.Next() {
+		var a api.SettlementAdjustment
+		if err := rows.Scan(&a.ID, &a.Reference, &a.BusinessDate, &a.CustomerID, &a.Amount, &a.Reason,
+			&a.CorrectsReference, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, &a)
+	}
+	return adjustments, rows.Err()
+}
@@ -0,0 +1,124 @@
+package tools
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type PortfolioSnapshot struct {
+	SnapshotDate       time.Time  `json:"snapshot_date"`
+	CustomerID         string     `json:"customer_id"`
+	AssetValue         float64    `json:"asset_value"`
+	TotalPaid          float64    `json:"total_paid"`
+	OutstandingBalance float64    `json:"outstanding_balance"`
+	PaymentCount       int        `json:"payment_count"`
+	Status             string     `json:"status"`
+	LastPaymentDate    *time.Time `json:"last_payment_date,omitempty"`
+}
+
+// CreatePortfolioSnapshot captures every account as of date and returns
+// the number of rows written. A date is captured once: if it already has
+// rows, nothing is written and 0 is returned, so the hourly job doesn't add
+// accounts opened later in the day to an earlier snapshot. The lock makes
+// concurrent runs wait for each other instead of both capturing the date.
+func (db *DatabaseService) CreatePortfolioSnapshot(ctx context.Context, date time.Time) (int64, error) {
+	query := `
+		INSERT INTO portfolio_snapshots (
+			snapshot_date, customer_id, asset_value, total_paid,
+			outstanding_balance, payment_count, status, last_payment_date
+		)
+		SELECT $1::DATE, customer_id, asset_value, total_paid,
+		       outstanding_balance, payment_count,
+		       CASE
+		           WHEN written_off_at IS NOT NULL THEN 'WRITTEN_OFF'
+		           WHEN outstanding_balance = 0 THEN 'COMPLETED'
+		           WHEN total_paid > 0 THEN 'IN_PROGRESS'
+		           ELSE 'NOT_STARTED'
+		       END,
+		       last_payment_date
+		FROM customer_accounts
+		WHERE NOT EXISTS (SELECT 1 FROM portfolio_snapshots WHERE snapshot_date = $1::DATE)
+	`
+
+	var created int64
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `LOCK TABLE portfolio_snapshots IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+		result, err := tx.Exec(ctx, query, date)
+		if err != nil {
+			return err
+		}
+		created = result.RowsAffected()
+		return nil
+	})
+	return created, err
+}
+
+func (db *DatabaseService) GetPortfolioSnapshot(ctx context.Context, date time.Time) ([]PortfolioSnapshot, error) {
+	query := `
+		SELECT snapshot_date, customer_id, asset_value, total_paid,
+		       outstanding_balance, payment_count, status, last_payment_date
+		FROM portfolio_snapshots
+		WHERE snapshot_date = $1::DATE
+		ORDER BY customer_id
+	`
+
+	rows, err := db.Query(ctx, query, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []PortfolioSnapshot{}
+	for rows.Next() {
+		var snapshot PortfolioSnapshot
+		if err := rows.Scan(
+			&snapshot.SnapshotDate,
+			&snapshot.CustomerID,
+			&snapshot.AssetValue,
+			&snapshot.TotalPaid,
+			&snapshot.OutstandingBalance,
+			&snapshot.PaymentCount,
+			&snapshot.Status,
+			&snapshot.LastPaymentDate,
+		); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+func SnapshotBlobKey(date time.Time) string {
+	return fmt.Sprintf("snapshots/%s/portfolio-%s.csv", date.Format("2006/01"), date.Format("2006-01-02"))
+}
+
+func WriteSnapshotsCSV(out io.Writer, snapshots []PortfolioSnapshot) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"snapshot_date", "customer_id", "asset_value", "total_paid", "outstanding_balance", "payment_count", "status", "last_payment_date"})
+	for _, snapshot := range snapshots {
+		lastPayment := ""
+		if snapshot.LastPaymentDate != nil {
+			lastPayment = snapshot.LastPaymentDate.Format(time.RFC3339)
+		}
+		w.Write([]string{
+			snapshot.SnapshotDate.Format("2006-01-02"),
+			snapshot.CustomerID,
+			fmt.Sprintf("%.2f", snapshot.AssetValue),
+			fmt.Sprintf("%.2f", snapshot.TotalPaid),
+			fmt.Sprintf("%.2f", snapshot.OutstandingBalance),
+			fmt.Sprintf("%d", snapshot.PaymentCount),
+			snapshot.Status,
+			lastPayment,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+// RecordPaymentSplits links the split payments to the original transfer
+// reference. Splits already recorded are left as they are.
+func (db *DatabaseService) RecordPaymentSplits(ctx context.Context, originalReference string, splits []api.PaymentSplit) error {
+	references := make([]string, len(splits))
+	customers := make([]string, len(splits))
+	amounts := make([]string, len(splits))
+	for i, split := range splits {
+		references[i] = split.SplitReference
+		customers[i] = split.CustomerID
+		amounts[i] = split.Amount
+	}
+
+	query := `
+		INSERT INTO payment_splits (split_reference, original_reference, customer_id, amount)
+		SELECT reference, $1, customer_id, amount::DECIMAL
+		FROM UNNEST($2::TEXT[], $3::TEXT[], $4::TEXT[]) AS s(reference, customer_id, amount)
+		ON CONFLICT (split_reference) DO NOTHING
+	`
+
+	_, err := db.Exec(ctx, query, originalReference, references, customers, amounts)
+	return err
+}
+
+func (db *DatabaseService) GetPaymentSplits(ctx context.Context, originalReference string) ([]api.PaymentSplit, error) {
+	query := `
+		SELECT split_reference, customer_id, amount::TEXT
+		FROM payment_splits
+		WHERE original_reference = $1
+		ORDER BY LENGTH(split_reference), split_reference
+	`
+
+	rows, err := db.Query(ctx, query, originalReference)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	splits := []api.PaymentSplit{}
+	for rows.Next() {
+		var split api.PaymentSplit
+		if err := rows.Scan(&split.SplitReference, &split.CustomerID, &split.Amount); err != nil {
+			return nil, err
+		}
+		splits = append(splits, split)
+	}
+	return splits, rows.Err()
+}
@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Backoff paces retries of a dependency that isn't up yet: the first wait
+// is Initial, each later one twice the last up to Max, and retrying stops
+// once MaxWait has passed since the first attempt.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	MaxWait time.Duration
+}
+
+// Retry calls connect until it succeeds and returns its last error if it
+// still fails after MaxWait, or ctx's error if ctx ends first. A zero
+// MaxWait retries until ctx ends.
+func (b Backoff) Retry(ctx context.Context, name string, connect func(ctx context.Context) error) error {
+	start := time.Now()
+	wait := b.Initial
+	if wait <= 0 {
+		wait = time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := connect(attemptCtx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("%s is available after %d attempts", name, attempt)
+			}
+			return nil
+		}
+
+		elapsed := time.Since(start)
+		if b.MaxWait > 0 && elapsed+wait > b.MaxWait {
+			return err
+		}
+		log.Warnf("%s is not available (attempt %d): %v; retrying in %s", name, attempt, err, wait)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if b.Max > 0 && wait > b.Max {
+			wait = b.Max
+		}
+	}
+}
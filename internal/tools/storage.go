@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LocalBlobPath is the API route that serves LocalBlobStore blobs to the
+// links PresignGet signs.
+const LocalBlobPath = "/api/v1/blobs"
+
+// ErrBlobLinkInvalid is returned for a local blob link that wasn't signed
+// by the store or has expired.
+var ErrBlobLinkInvalid = errors.New("blob link is invalid or expired")
+
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	PresignGet(key string, expiry time.Duration) (string, error)
+}
+
+func NewBlobStore(cfg *Config) (BlobStore, error) {
+	switch cfg.BlobStore {
+	case "", "local":
+		secret := []byte(cfg.BlobSecretKey)
+		if len(secret) == 0 {
+			// Links then only work on this instance until it restarts.
+			log.Warn("BLOB_SECRET_KEY is not set; signing local blob links with a random key")
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+		}
+		return NewLocalBlobStore(cfg.BlobLocalDir, cfg.BlobPublicURL, secret), nil
+	case "s3":
+		return NewS3BlobStore(cfg.BlobEndpoint, cfg.BlobRegion, cfg.BlobBucket, cfg.BlobAccessKey, cfg.BlobSecretKey)
+	case "gcs":
+		return NewGCSBlobStore(cfg.BlobBucket, cfg.BlobAccessKey, cfg.BlobSecretKey)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.BlobStore)
+	}
+}
+
+// LocalBlobStore keeps blobs in a directory. The API serves them on
+// LocalBlobPath to links signed with secret, so they can be handed out like
+// the S3 and GCS presigned URLs.
+type LocalBlobStore struct {
+	dir       string
+	publicURL string
+	secret    []byte
+}
+
+// NewLocalBlobStore stores blobs under dir. publicURL is the address the
+// API is reached on; without one, links are relative to it.
+func NewLocalBlobStore(dir, publicURL string, secret []byte) *LocalBlobStore {
+	return &LocalBlobStore{dir: dir, publicURL: strings.TrimSuffix(publicURL, "/"), secret: secret}
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, body)
+	return err
+}
+
+func (s *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// PresignGet returns a link to the blob on LocalBlobPath that is valid for
+// expiry.
+func (s *LocalBlobStore) PresignGet(key string, expiry time.Duration) (string, error) {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {s.sign(key, expires)}}
+	link := url.URL{Path: LocalBlobPath + "/" + key, RawQuery: query.Encode()}
+	return s.publicURL + link.String(), nil
+}
+
+// VerifyGet checks a link PresignGet returned: the signature must match
+// key and expires, and expires must not have passed at now.
+func (s *LocalBlobStore) VerifyGet(key, expires, signature string, now time.Time) error {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return ErrBlobLinkInvalid
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(key, expires))) {
+		return ErrBlobLinkInvalid
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > unix {
+		return ErrBlobLinkInvalid
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	cleaned, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned)), nil
+}
+
+// cleanBlobKey resolves key to a path inside the store, without the
+// leading slash.
+func cleanBlobKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return cleaned[1:], nil
+}
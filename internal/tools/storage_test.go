@@ -0,0 +1,60 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalBlobLinks(t *testing.T) {
+	store := NewLocalBlobStore(t.TempDir(), "https://payments.example.com/", []byte("secret"))
+	key := "snapshots/2026/10/portfolio-2026-10-15.csv"
+	if err := store.Put(context.Background(), key, strings.NewReader("customer_id\n"), "text/csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := store.PresignGet(key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Host != "payments.example.com" || parsed.Path != LocalBlobPath+"/"+key {
+		t.Fatalf("link %s doesn't point at the blob route", link)
+	}
+	expires, signature := parsed.Query().Get("expires"), parsed.Query().Get("signature")
+
+	// The route's wildcard hands the handler the key with a leading slash.
+	if err := store.VerifyGet("/"+key, expires, signature, time.Now()); err != nil {
+		t.Fatalf("fresh link: %v", err)
+	}
+	body, err := store.Get(context.Background(), "/"+key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "customer_id\n" {
+		t.Fatalf("got %q", data)
+	}
+
+	for name, check := range map[string]func() error{
+		"expired":      func() error { return store.VerifyGet(key, expires, signature, time.Now().Add(2*time.Minute)) },
+		"other key":    func() error { return store.VerifyGet("snapshots/other.csv", expires, signature, time.Now()) },
+		"later expiry": func() error { return store.VerifyGet(key, expires+"0", signature, time.Now()) },
+		"other secret": func() error {
+			return NewLocalBlobStore("", "", []byte("other")).VerifyGet(key, expires, signature, time.Now())
+		},
+		"escaping root": func() error { return store.VerifyGet("/../../etc/passwd", expires, signature, time.Now()) },
+	} {
+		if err := check(); !errors.Is(err, ErrBlobLinkInvalid) {
+			t.Errorf("%s: err = %v, want ErrBlobLinkInvalid", name, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrSuspenseReviewed is returned when reviewing a suspense payment that
+// has already been rejected or assigned elsewhere.
+var ErrSuspenseReviewed = errors.New("suspense payment has already been reviewed")
+
+const suspenseColumns = `id, transaction_reference, customer_id, amount, payment, reason, status,
+	COALESCE(assigned_customer_id, ''), COALESCE(reviewed_by, ''), COALESCE(review_note, ''), reviewed_at, created_at`
+
+func scanSuspensePayment(row pgx.Row) (*api.SuspensePayment, error) {
+	var suspense api.SuspensePayment
+	var data []byte
+	err := row.Scan(
+		&suspense.ID,
+		&suspense.TransactionReference,
+		&suspense.CustomerID,
+		&suspense.Amount,
+		&data,
+		&suspense.Reason,
+		&suspense.Status,
+		&suspense.AssignedCustomerID,
+		&suspense.ReviewedBy,
+		&suspense.ReviewNote,
+		&suspense.ReviewedAt,
+		&suspense.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &suspense.Payment); err != nil {
+		return nil, err
+	}
+	return &suspense, nil
+}
+
+// SuspendPayment parks a payment that can't be applied to its customer.
+// Suspending the same transaction again is a no-op.
+func (db *DatabaseService) SuspendPayment(ctx context.Context, payment *api.PaymentPayload, amount float64, reason string) error {
+	data, err := json.Marshal(payment)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO suspense_payments (transaction_reference, customer_id, amount, payment, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (transaction_reference) DO NOTHING
+	`, payment.TransactionReference, payment.CustomerID, amount, json.RawMessage(data), reason)
+	return err
+}
+
+func (db *DatabaseService) GetSuspensePayment(ctx context.Context, id int64) (*api.SuspensePayment, error) {
+	return scanSuspensePayment(db.QueryRow(ctx, `SELECT `+suspenseColumns+` FROM suspense_payments WHERE id = $1`, id))
+}
+
+func (db *DatabaseService) ListSuspensePayments(ctx context.Context, status string, limit int) ([]*api.SuspensePayment, error) {
+	query := `
+		SELECT ` + suspenseColumns + ` FROM suspense_payments
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY created_at, id
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*api.SuspensePayment{}
+	for rows.Next() {
+		suspense, err := scanSuspensePayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, suspense)
+	}
+	return payments, rows.Err()
+}
+
+// ReviewSuspensePayment records the decision on a suspense payment.
+// Assigning it again to the same customer is allowed so a failed re-queue
+// can be retried; nothing moves out of REJECTED.
+func (db *DatabaseService) ReviewSuspensePayment(ctx context.Context, id int64, status, customerID, reviewer, note string) (*api.SuspensePayment, error) {
+	query := `
+		UPDATE suspense_payments
+		SET status = $2, assigned_customer_id = NULLIF($3, ''), reviewed_by = $4, review_note = NULLIF($5, ''), reviewed_at = NOW()
+		WHERE id = $1 AND (status = 'OPEN' OR (status = 'ASSIGNED' AND $2 = 'ASSIGNED' AND assigned_customer_id = $3))
+		RETURNING ` + suspenseColumns
+
+	suspense, err := scanSuspensePayment(db.QueryRow(ctx, query, id, status, customerID, reviewer, note))
+	if !errors.Is(err, ErrNotFound) {
+		return suspense, err
+	}
+	if _, err := db.GetSuspensePayment(ctx, id); err != nil {
+		return nil, err
+	}
+	return nil, ErrSuspenseReviewed
+}
@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const messageTemplateColumns = `template_id, locale, version, channel, COALESCE(subject, ''), body, variables,
+	updated_by, created_at`
+
+func scanMessageTemplate(row pgx.Row) (*api.MessageTemplate, error) {
+	var template api.MessageTemplate
+	err := row.Scan(
+		&template.TemplateID,
+		&template.Locale,
+		&template.Version,
+		&template.Channel,
+		&template.Subject,
+		&template.Body,
+		&template.Variables,
+		&template.UpdatedBy,
+		&template.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// SaveMessageTemplate stores the template as the next version for its
+// locale and fills in the version and creation time.
+func (db *DatabaseService) SaveMessageTemplate(ctx context.Context, template *api.MessageTemplate) error {
+	query := `
+		INSERT INTO message_templates (template_id, locale, version, channel, subject, body, variables, updated_by)
+		SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, NULLIF($4, ''), $5, $6, $7
+		FROM message_templates
+		WHERE template_id = $1 AND locale = $2
+		RETURNING version, created_at
+	`
+
+	variables := template.Variables
+	if variables == nil {
+		variables = []string{}
+	}
+	return db.QueryRow(ctx, query, template.TemplateID, template.Locale, template.Channel, template.Subject,
+		template.Body, variables, template.UpdatedBy).Scan(&template.Version, &template.CreatedAt)
+}
+
+// GetMessageTemplate returns the given version of the template in locale;
+// version 0 is the latest.
+func (db *DatabaseService) GetMessageTemplate(ctx context.Context, templateID, locale string, version int) (*api.MessageTemplate, error) {
+	query := `
+		SELECT ` + messageTemplateColumns + ` FROM message_templates
+		WHERE template_id = $1 AND locale = $2 AND ($3 = 0 OR version = $3)
+		ORDER BY version DESC
+		LIMIT 1
+	`
+
+	return scanMessageTemplate(db.QueryRow(ctx, query, templateID, locale, version))
+}
+
+// ListMessageTemplates lists the latest version of every template and
+// locale.
+func (db *DatabaseService) ListMessageTemplates(ctx context.Context) ([]*api.MessageTemplate, error) {
+	query := `
+		SELECT DISTINCT ON (template_id, locale) ` + messageTemplateColumns + `
+		FROM message_templates
+		ORDER BY template_id, locale, version DESC
+	`
+
+	return db.queryMessageTemplates(ctx, query)
+}
+
+// ListMessageTemplateVersions lists every version of the template in
+// locale, newest first.
+func (db *DatabaseService) ListMessageTemplateVersions(ctx context.Context, templateID, locale string) ([]*api.MessageTemplate, error) {
+	query := `
+		SELECT ` + messageTemplateColumns + ` FROM message_templates
+		WHERE template_id = $1 AND locale = $2
+		ORDER BY version DESC
+	`
+
+	return db.queryMessageTemplates(ctx, query, templateID, locale)
+}
+
+func (db *DatabaseService) queryMessageTemplates(ctx context.Context, query string, args ...any) ([]*api.MessageTemplate, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*api.MessageTemplate{}
+	for rows.Next() {
+		template, err := scanMessageTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
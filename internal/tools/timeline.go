@@ -0,0 +1,188 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+func (db *DatabaseService) RecordNotification(ctx context.Context, record api.NotificationRecord) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO notification_log (customer_id, channel, template, subject, status, error, provider_message_id)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, ''), NULLIF($7, ''))
+	`, record.CustomerID, record.Channel, record.Template, record.Subject, record.Status, record.Error, record.ProviderMessageID)
+	return err
+}
+
+// RecordDelivery applies a provider's delivery receipt to the logged
+// notification it sent as messageID. Receipts can arrive out of order, so a
+// status only replaces an earlier one: SENT, then DELIVERED, then READ,
+// with FAILED after SENT. It reports whether the message was found.
+func (db *DatabaseService) RecordDelivery(ctx context.Context, messageID, status, deliveryError string, at time.Time) (bool, error) {
+	tag, err := db.Exec(ctx, `
+		UPDATE notification_log
+		SET delivery_status = CASE
+		        WHEN delivery_status IS NULL THEN $2
+		        WHEN $2 = 'FAILED' AND delivery_status = 'SENT' THEN $2
+		        WHEN array_position(ARRAY['SENT', 'DELIVERED', 'READ'], $2::TEXT)
+		             > COALESCE(array_position(ARRAY['SENT', 'DELIVERED', 'READ'], delivery_status::TEXT), 4) THEN $2
+		        ELSE delivery_status
+		    END,
+		    delivery_error = COALESCE(NULLIF($3, ''), delivery_error),
+		    delivery_updated_at = GREATEST(delivery_updated_at, $4)
+		WHERE provider_message_id = $1
+	`, messageID, status, deliveryError, at)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// timelineQuery gathers an account's activity from every table that
+// records some, one SELECT per kind of event. Each returns the event type,
+// when it happened, its amount, reference and actor if any, and details.
+const timelineQuery = `
+	SELECT type, occurred_at, amount, reference, actor, details FROM (
+		SELECT 'payment' AS type, processed_at AS occurred_at, amount, transaction_reference AS reference, NULL AS actor,
+		       jsonb_strip_nulls(jsonb_build_object('agent_id', agent_id, 'value_date', value_date,
+		           'recovery', NULLIF(recovery, FALSE), 'import_batch_id', import_batch_id)) AS details
+		FROM processed_transactions WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'payments_archived', a.created_at, c.total_paid, a.object_key, NULL,
+		       jsonb_build_object('payments', c.payments, 'month', to_char(a.month, 'YYYY-MM'))
+		FROM transaction_archive_customers c JOIN transaction_archives a ON a.id = c.archive_id
+		WHERE c.customer_id = $1
+
+		UNION ALL
+		SELECT 'payment_reversed', t.reversed_at, t.amount, t.transaction_reference, COALESCE(r.decided_by, d.resolved_by),
+		       jsonb_strip_nulls(jsonb_build_object('reversal_id', r.id, 'import_batch_id', r.import_batch_id,
+		           'dispute_id', d.id, 'reason', COALESCE(r.reason, d.resolution_note)))
+		FROM reversed_transactions t
+		LEFT JOIN import_reversals r ON r.id = t.reversal_id
+		LEFT JOIN disputes d ON d.id = t.dispute_id
+		WHERE t.customer_id = $1
+
+		UNION ALL
+		SELECT 'dispute_opened', opened_at, amount, transaction_reference, opened_by,
+		       jsonb_build_object('dispute_id', id, 'reason', reason)
+		FROM disputes WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'dispute_' || lower(status), resolved_at, adjustment_amount, transaction_reference, resolved_by,
+		       jsonb_strip_nulls(jsonb_build_object('dispute_id', id, 'action', resolution_action,
+		           'adjustment_reference', adjustment_reference, 'note', resolution_note))
+		FROM disputes WHERE customer_id = $1 AND resolved_at IS NOT NULL
+
+		UNION ALL
+		SELECT 'fee_charged', charged_at, gross_amount, reference, charged_by,
+		       jsonb_strip_nulls(jsonb_build_object('fee_type', fee_type, 'net_amount', net_amount,
+		           'tax_code', tax_code, 'tax_amount', tax_amount, 'description', description))
+		FROM fee_charges WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'payment_held', created_at, NULL, transaction_reference, reviewed_by,
+		       jsonb_strip_nulls(jsonb_build_object('status', status, 'entry_type', entry_type, 'notes', notes))
+		FROM screening_holds WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'payout', created_at, amount, reference, NULL,
+		       jsonb_build_object('payout_type', payout_type, 'status', status, 'method', method)
+		FROM payouts WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'write_off', written_off_at, written_off_amount, NULL, NULL,
+		       jsonb_strip_nulls(jsonb_build_object('reason', write_off_reason))
+		FROM customer_accounts WHERE customer_id = $1 AND written_off_at IS NOT NULL
+
+		UNION ALL
+		SELECT 'restructuring_requested', requested_at, NULL, NULL, requested_by,
+		       jsonb_build_object('restructuring_id', id, 'old_term_weeks', old_term_weeks,
+		           'new_term_weeks', new_term_weeks, 'reason', reason)
+		FROM account_restructurings WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'restructuring_' || lower(status), decided_at, NULLIF(capitalized_amount, 0), NULL, decided_by,
+		       jsonb_strip_nulls(jsonb_build_object('restructuring_id', id, 'new_installment', new_installment,
+		           'note', decision_note))
+		FROM account_restructurings WHERE customer_id = $1 AND decided_at IS NOT NULL
+
+		UNION ALL
+		SELECT 'ledger_adjusted', detected_at, ledger_total_paid - recorded_total_paid, NULL, NULL,
+		       jsonb_build_object('recorded_total_paid', recorded_total_paid, 'ledger_total_paid', ledger_total_paid,
+		           'recorded_payment_count', recorded_payment_count, 'ledger_payment_count', ledger_payment_count)
+		FROM ledger_drift WHERE customer_id = $1 AND repaired
+
+		UNION ALL
+		SELECT 'promise_made', created_at, amount, NULL, agent_id,
+		       jsonb_strip_nulls(jsonb_build_object('promise_id', id, 'promised_date', promised_date, 'note', note))
+		FROM promises_to_pay WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'promise_' || lower(status), resolved_at, paid_amount, NULL, NULL,
+		       jsonb_build_object('promise_id', id, 'promised_amount', amount)
+		FROM promises_to_pay WHERE customer_id = $1 AND resolved_at IS NOT NULL
+
+		UNION ALL
+		SELECT 'dunning_started', started_at, NULL, charge_reference, NULL,
+		       jsonb_strip_nulls(jsonb_build_object('reason', last_failure_reason))
+		FROM dunning_cases WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'dunning_delinquent', delinquent_at, NULL, NULL, NULL, '{}'::JSONB
+		FROM dunning_cases WHERE customer_id = $1 AND delinquent_at IS NOT NULL
+
+		UNION ALL
+		SELECT 'dunning_resolved', resolved_at, NULL, NULL, NULL, '{}'::JSONB
+		FROM dunning_cases WHERE customer_id = $1 AND resolved_at IS NOT NULL
+
+		UNION ALL
+		SELECT 'account_merged', merged_at, NULL, NULL, merged_by,
+		       jsonb_build_object('duplicate_id', duplicate_id, 'reason', reason)
+		FROM customer_merges WHERE survivor_id = $1
+
+		UNION ALL
+		SELECT 'notification', created_at, NULL, NULL, NULL,
+		       jsonb_strip_nulls(jsonb_build_object('channel', channel, 'template', template, 'subject', subject,
+		           'status', status, 'error', error, 'delivery_status', delivery_status,
+		           'delivery_error', delivery_error))
+		FROM notification_log WHERE customer_id = $1
+
+		UNION ALL
+		SELECT 'note', created_at, NULL, transaction_reference, author,
+		       jsonb_strip_nulls(jsonb_build_object('note_id', id, 'body', body, 'tags', tags, 'updated_at', updated_at))
+		FROM customer_notes WHERE customer_id = $1
+	) events
+	WHERE occurred_at < $2
+	ORDER BY occurred_at DESC
+	LIMIT $3
+`
+
+// CustomerTimeline returns up to limit of the account's events before
+// before, newest first: payments and reversals, adjustments, fees, status
+// changes, notifications and notes. Page back by passing the last event's time.
+func (db *DatabaseService) CustomerTimeline(ctx context.Context, customerID string, before time.Time, limit int) ([]*api.TimelineEvent, error) {
+	rows, err := db.Query(ctx, timelineQuery, customerID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*api.TimelineEvent{}
+	for rows.Next() {
+		var event api.TimelineEvent
+		var reference, actor *string
+		if err := rows.Scan(&event.Type, &event.OccurredAt, &event.Amount, &reference, &actor, &event.Details); err != nil {
+			return nil, err
+		}
+		if reference != nil {
+			event.Reference = *reference
+		}
+		if actor != nil {
+			event.Actor = *actor
+		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
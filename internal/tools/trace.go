@@ -0,0 +1,38 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+)
+
+type traceIDKey struct{}
+
+// traceIDPattern is what a trace ID may look like. IDs go into SQL
+// comments, so anything else from a client is replaced.
+var traceIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+// ValidTraceID reports whether a client-supplied trace ID may be used.
+func ValidTraceID(traceID string) bool {
+	return traceIDPattern.MatchString(traceID)
+}
+
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+func TraceIDFromContext(ctx context.Context) string {
+	if traceID, ok := ctx.Value(traceIDKey{}).(string); ok {
+		return traceID
+	}
+	return ""
+}
+
+func NewTraceID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,265 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrTransactionArchived is returned for a payment whose row has been moved
+// to the archive and can't be rebuilt from payment history.
+var ErrTransactionArchived = errors.New("transaction has been archived")
+
+const transactionArchiveBatchSize = 50000
+
+// transactionArchiveFields is the Parquet schema of archived
+// processed_transactions rows.
+var transactionArchiveFields = []ParquetField{
+	{Name: "transaction_reference", Kind: ParquetString},
+	{Name: "customer_id", Kind: ParquetString},
+	{Name: "amount", Kind: ParquetCents},
+	{Name: "processed_at", Kind: ParquetTimestamp},
+	{Name: "metadata", Kind: ParquetString, Optional: true},
+	{Name: "agent_id", Kind: ParquetString, Optional: true},
+	{Name: "recovery", Kind: ParquetBool},
+	{Name: "value_date", Kind: ParquetDate, Optional: true},
+	{Name: "import_batch_id", Kind: ParquetInt64, Optional: true},
+}
+
+// ArchiveTransactions moves processed transactions from before cutoff to
+// the blob store, oldest month first, as Parquet files of up to 50,000 rows
+// under archive/processed_transactions/month=YYYY-MM/. Each file is recorded
+// with per-account totals before its rows are deleted, so balances and the
+// ledger check still count them. processed_references keeps the archived
+// references, so archived payments are still recognised as duplicates.
+func (db *DatabaseService) ArchiveTransactions(ctx context.Context, storage BlobStore, cutoff time.Time) ([]*api.TransactionArchive, error) {
+	if storage == nil {
+		return nil, fmt.Errorf("archiving transactions requires blob storage")
+	}
+
+	archives := []*api.TransactionArchive{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return archives, err
+		}
+		archive, err := db.archiveTransactionBatch(ctx, storage, cutoff)
+		if err != nil || archive == nil {
+			return archives, err
+		}
+		archives = append(archives, archive)
+	}
+}
+
+// archiveTransactionBatch archives the oldest batch of rows before cutoff,
+// all from one month, or returns nil if there are none. The rows stay
+// locked from export until they are deleted, so a merge can't move them in
+// between.
+func (db *DatabaseService) archiveTransactionBatch(ctx context.Context, storage BlobStore, cutoff time.Time) (*api.TransactionArchive, error) {
+	var archive *api.TransactionArchive
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		if err := allowSettledChanges(ctx, tx); err != nil {
+			return err
+		}
+
+		var month *time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT date_trunc('month', MIN(processed_at)) FROM processed_transactions WHERE processed_at < $1
+		`, cutoff).Scan(&month)
+		if err != nil || month == nil {
+			return err
+		}
+		end := month.AddDate(0, 1, 0)
+		if end.After(cutoff) {
+			end = cutoff
+		}
+
+		rows, err := tx.Query(ctx, `
+			SELECT tableoid::TEXT, ctid::TEXT, transaction_reference, customer_id, amount, processed_at,
+			       metadata::TEXT, agent_id, recovery, value_date, import_batch_id
+			FROM processed_transactions
+			WHERE processed_at >= $1 AND processed_at < $2
+			ORDER BY processed_at
+			LIMIT $3
+			FOR UPDATE
+		`, *month, end, transactionArchiveBatchSize)
+		if err != nil {
+			return err
+		}
+
+		writer := NewParquetWriter(transactionArchiveFields)
+		tables, ctids := []string{}, []string{}
+		next := &api.TransactionArchive{Month: month.Format("2006-01")}
+		for rows.Next() {
+			var table, ctid, reference, customerID string
+			var amount float64
+			var processedAt time.Time
+			var metadata, agentID *string
+			var recovery bool
+			var valueDate *time.Time
+			var batchID *int64
+			if err := rows.Scan(&table, &ctid, &reference, &customerID, &amount, &processedAt,
+				&metadata, &agentID, &recovery, &valueDate, &batchID); err != nil {
+				rows.Close()
+				return err
+			}
+			if err := writer.Append(reference, customerID, amount, processedAt,
+				optionalString(metadata), optionalString(agentID), recovery, optionalTime(valueDate), optionalInt64(batchID)); err != nil {
+				rows.Close()
+				return err
+			}
+			tables = append(tables, table)
+			ctids = append(ctids, ctid)
+			if next.Rows == 0 {
+				next.FirstProcessedAt = processedAt
+			}
+			next.LastProcessedAt = processedAt
+			next.Rows++
+			next.TotalAmount += amount
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if next.Rows == 0 {
+			return nil
+		}
+
+		// Keyed by the first row, so a batch retried after a failed commit
+		// overwrites its own file.
+		next.ObjectKey = fmt.Sprintf("archive/processed_transactions/month=%s/part-%s.parquet",
+			next.Month, next.FirstProcessedAt.UTC().Format("20060102T150405.000000"))
+		var buf bytes.Buffer
+		if _, err := writer.WriteTo(&buf); err != nil {
+			return err
+		}
+		if err := storage.Put(ctx, next.ObjectKey, &buf, "application/vnd.apache.parquet"); err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(ctx, `
+			INSERT INTO transaction_archives (month, object_key, row_count, total_amount, first_processed_at, last_processed_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at
+		`, *month, next.ObjectKey, next.Rows, next.TotalAmount, next.FirstProcessedAt, next.LastProcessedAt).
+			Scan(&next.ID, &next.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO transaction_archive_customers (archive_id, customer_id, payments, total_paid, first_effective_at, last_effective_at)
+			SELECT $1, customer_id, COUNT(*), SUM(amount),
+			       MIN(GREATEST(processed_at, value_date::TIMESTAMP)), MAX(GREATEST(processed_at, value_date::TIMESTAMP))
+			FROM processed_transactions
+			WHERE (tableoid, ctid) IN (SELECT * FROM unnest($2::OID[], $3::TID[]))
+			GROUP BY customer_id
+		`, next.ID, tables, ctids)
+		if err != nil {
+			return err
+		}
+
+		tag, err := tx.Exec(ctx, `
+			DELETE FROM processed_transactions
+			WHERE (tableoid, ctid) IN (SELECT * FROM unnest($1::OID[], $2::TID[]))
+		`, tables, ctids)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() != int64(next.Rows) {
+			return fmt.Errorf("archived %d rows but deleted %d", next.Rows, tag.RowsAffected())
+		}
+		archive = next
+		return nil
+	})
+	return archive, err
+}
+
+func optionalString(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+func optionalInt64(n *int64) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+func optionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+// ListTransactionArchives returns the archive files, newest month first,
+// optionally only those of month (YYYY-MM).
+func (db *DatabaseService) ListTransactionArchives(ctx context.Context, month string, limit int) ([]*api.TransactionArchive, error) {
+	query := `
+		SELECT id, to_char(month, 'YYYY-MM'), object_key, row_count, total_amount, first_processed_at, last_processed_at, created_at
+		FROM transaction_archives
+		WHERE ($1 = '' OR to_char(month, 'YYYY-MM') = $1)
+		ORDER BY month DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, month, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archives := []*api.TransactionArchive{}
+	for rows.Next() {
+		var archive api.TransactionArchive
+		if err := rows.Scan(&archive.ID, &archive.Month, &archive.ObjectKey, &archive.Rows, &archive.TotalAmount,
+			&archive.FirstProcessedAt, &archive.LastProcessedAt, &archive.CreatedAt); err != nil {
+			return nil, err
+		}
+		archives = append(archives, &archive)
+	}
+	return archives, rows.Err()
+}
+
+// archivedTransaction looks for the customer's payment in the archive. If
+// payment history still has the payment, the record is rebuilt from it;
+// otherwise it only has the reference, the processing time and the archive,
+// and the error is ErrTransactionArchived. It returns ErrNotFound if the
+// payment was never archived.
+func (db *DatabaseService) archivedTransaction(ctx context.Context, customerID, reference string) (*TransactionRecord, error) {
+	query := `
+		SELECT r.processed_at, a.id, to_char(a.month, 'YYYY-MM'), a.object_key, a.row_count, a.total_amount,
+		       a.first_processed_at, a.last_processed_at, a.created_at,
+		       h.amount, h.balance_after
+		FROM processed_references r
+		JOIN transaction_archives a ON r.processed_at BETWEEN a.first_processed_at AND a.last_processed_at
+		JOIN transaction_archive_customers c ON c.archive_id = a.id AND c.customer_id = $1
+		LEFT JOIN payment_history h ON h.transaction_reference = r.transaction_reference AND h.customer_id = $1
+		WHERE r.transaction_reference = $2
+		ORDER BY a.id
+		LIMIT 1
+	`
+
+	txn := TransactionRecord{TransactionReference: reference, CustomerID: customerID, Archive: &api.TransactionArchive{}}
+	var amount *float64
+	err := db.QueryRow(ctx, query, customerID, reference).Scan(&txn.ProcessedAt,
+		&txn.Archive.ID, &txn.Archive.Month, &txn.Archive.ObjectKey, &txn.Archive.Rows, &txn.Archive.TotalAmount,
+		&txn.Archive.FirstProcessedAt, &txn.Archive.LastProcessedAt, &txn.Archive.CreatedAt,
+		&amount, &txn.BalanceAfter)
+	if err != nil {
+		return nil, err
+	}
+	if amount == nil {
+		return &txn, ErrTransactionArchived
+	}
+	txn.Amount = *amount
+	return &txn, nil
+}
@@ -0,0 +1,140 @@
+package tools
+
+import (
+	"context"
+	"errors"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrVirtualAccountClosed is returned when closing a virtual account that is
+// already closed.
+var ErrVirtualAccountClosed = errors.New("virtual account is already closed")
+
+const virtualAccountColumns = `account_number, customer_id, provider, bank_name, account_name,
+	COALESCE(provider_reference, ''), status, created_at, closed_at`
+
+func scanVirtualAccount(row pgx.Row) (*api.VirtualAccount, error) {
+	var account api.VirtualAccount
+	err := row.Scan(
+		&account.AccountNumber,
+		&account.CustomerID,
+		&account.Provider,
+		&account.BankName,
+		&account.AccountName,
+		&account.ProviderReference,
+		&account.Status,
+		&account.CreatedAt,
+		&account.ClosedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// SaveVirtualAccount records an account number the provider assigned.
+func (db *DatabaseService) SaveVirtualAccount(ctx context.Context, account *api.VirtualAccount) error {
+	query := `
+		INSERT INTO virtual_accounts (account_number, customer_id, provider, bank_name, account_name, provider_reference)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		RETURNING status, created_at
+	`
+
+	return db.QueryRow(ctx, query, account.AccountNumber, account.CustomerID, account.Provider, account.BankName,
+		account.AccountName, account.ProviderReference).Scan(&account.Status, &account.CreatedAt)
+}
+
+// ActiveVirtualAccount returns the customer's open account with the
+// provider, or ErrNotFound.
+func (db *DatabaseService) ActiveVirtualAccount(ctx context.Context, customerID, provider string) (*api.VirtualAccount, error) {
+	query := `
+		SELECT ` + virtualAccountColumns + ` FROM virtual_accounts
+		WHERE customer_id = $1 AND provider = $2 AND status = 'ACTIVE'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	return scanVirtualAccount(db.QueryRow(ctx, query, customerID, provider))
+}
+
+func (db *DatabaseService) ListVirtualAccounts(ctx context.Context, customerID string) ([]*api.VirtualAccount, error) {
+	query := `
+		SELECT ` + virtualAccountColumns + ` FROM virtual_accounts
+		WHERE customer_id = $1
+		ORDER BY status, created_at DESC
+	`
+
+	rows, err := db.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*api.VirtualAccount{}
+	for rows.Next() {
+		account, err := scanVirtualAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// ResolveVirtualAccount returns the customer account a virtual account
+// number belongs to, or ErrNotFound. Closed accounts still resolve, so
+// late transfers into them aren't lost.
+func (db *DatabaseService) ResolveVirtualAccount(ctx context.Context, accountNumber string) (string, error) {
+	var customerID string
+	err := db.QueryRow(ctx, `SELECT customer_id FROM virtual_accounts WHERE account_number = $1`, accountNumber).Scan(&customerID)
+	return customerID, err
+}
+
+// CloseVirtualAccount marks an account closed. It returns ErrNotFound for
+// an unknown number and ErrVirtualAccountClosed if it was already closed.
+func (db *DatabaseService) CloseVirtualAccount(ctx context.Context, accountNumber string) (*api.VirtualAccount, error) {
+	query := `
+		UPDATE virtual_accounts SET status = 'CLOSED', closed_at = NOW()
+		WHERE account_number = $1 AND status = 'ACTIVE'
+		RETURNING ` + virtualAccountColumns
+
+	account, err := scanVirtualAccount(db.QueryRow(ctx, query, accountNumber))
+	if !errors.Is(err, ErrNotFound) {
+		return account, err
+	}
+	if _, err := db.ResolveVirtualAccount(ctx, accountNumber); err != nil {
+		return nil, err
+	}
+	return nil, ErrVirtualAccountClosed
+}
+
+// AccountsWithoutVirtualAccount lists open accounts with a balance that have
+// no active account with the provider, oldest first.
+func (db *DatabaseService) AccountsWithoutVirtualAccount(ctx context.Context, provider string, limit int) ([]string, error) {
+	query := `
+		SELECT c.customer_id FROM customer_accounts c
+		WHERE c.outstanding_balance > 0 AND c.written_off_at IS NULL
+		  AND NOT EXISTS (
+		      SELECT 1 FROM virtual_accounts v
+		      WHERE v.customer_id = c.customer_id AND v.provider = $1 AND v.status = 'ACTIVE')
+		ORDER BY c.created_at, c.customer_id
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, provider, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customerIDs := []string{}
+	for rows.Next() {
+		var customerID string
+		if err := rows.Scan(&customerID); err != nil {
+			return nil, err
+		}
+		customerIDs = append(customerIDs, customerID)
+	}
+	return customerIDs, rows.Err()
+}
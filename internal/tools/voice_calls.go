@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const voiceCallColumns = `
+	id, reference, customer_id, template_id, COALESCE(provider, ''), COALESCE(provider_call_id, ''),
+	COALESCE(script, ''), status, COALESCE(duration_seconds, 0), COALESCE(detail, ''), requested_by,
+	created_at, placed_at, ended_at
+`
+
+func scanVoiceCall(row pgx.Row) (*api.VoiceCall, error) {
+	var v api.VoiceCall
+	err := row.Scan(&v.ID, &v.Reference, &v.CustomerID, &v.TemplateID, &v.Provider, &v.ProviderCallID,
+		&v.Script, &v.Status, &v.DurationSeconds, &v.Detail, &v.RequestedBy, &v.CreatedAt, &v.PlacedAt, &v.EndedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// CreateVoiceCall queues a reminder call to the customer, referenced
+// CALL-<id>.
+func (db *DatabaseService) CreateVoiceCall(ctx context.Context, customerID, templateID, requestedBy string) (*api.VoiceCall, error) {
+	return scanVoiceCall(db.QueryRow(ctx, `
+		WITH next AS (SELECT nextval(pg_get_serial_sequence('voice_calls', 'id')) AS id)
+		INSERT INTO voice_calls (id, reference, customer_id, template_id, requested_by)
+		SELECT id, 'CALL-' || id, $1, $2, $3
+		FROM next
+		RETURNING `+voiceCallColumns, customerID, templateID, requestedBy))
+}
+
+// VoiceCallPlaced records that the provider accepted the call, with the
+// script it will read.
+func (db *DatabaseService) VoiceCallPlaced(ctx context.Context, reference, provider, providerCallID, script string) error {
+	_, err := db.Exec(ctx, `
+		UPDATE voice_calls
+		SET status = 'PLACED', provider = $2, provider_call_id = NULLIF($3, ''), script = $4, placed_at = NOW()
+		WHERE reference = $1 AND status = 'QUEUED'
+	`, reference, provider, providerCallID, script)
+	return err
+}
+
+// EndVoiceCall records how a call ended. The call is found by reference,
+// or by the provider's call ID when the callback doesn't carry one. A call
+// that has already ended is returned unchanged, so repeated callbacks are
+// harmless.
+func (db *DatabaseService) EndVoiceCall(ctx context.Context, reference, providerCallID string, status api.VoiceCallStatus, durationSeconds int, detail string) (*api.VoiceCall, error) {
+	return scanVoiceCall(db.QueryRow(ctx, `
+		WITH call AS (
+			SELECT id FROM voice_calls
+			WHERE ($1 <> '' AND reference = $1) OR ($1 = '' AND $2 <> '' AND provider_call_id = $2)
+			LIMIT 1
+		), ended AS (
+			UPDATE voice_calls v
+			SET status = $3, duration_seconds = $4, detail = NULLIF($5, ''), ended_at = NOW(),
+			    provider_call_id = COALESCE(v.provider_call_id, NULLIF($2, ''))
+			FROM call
+			WHERE v.id = call.id AND v.status IN ('QUEUED', 'PLACED')
+			RETURNING v.*
+		)
+		SELECT `+voiceCallColumns+` FROM ended
+		UNION ALL
+		SELECT `+voiceCallColumns+` FROM voice_calls
+		WHERE id = (SELECT id FROM call) AND NOT EXISTS (SELECT 1 FROM ended)
+	`, reference, providerCallID, status, durationSeconds, detail))
+}
+
+// FindVoiceCall looks a call up by reference, or by the provider's call ID
+// when reference is empty.
+func (db *DatabaseService) FindVoiceCall(ctx context.Context, reference, providerCallID string) (*api.VoiceCall, error) {
+	return scanVoiceCall(db.QueryRow(ctx, `
+		SELECT `+voiceCallColumns+` FROM voice_calls
+		WHERE ($1 <> '' AND reference = $1) OR ($1 = '' AND $2 <> '' AND provider_call_id = $2)
+		LIMIT 1
+	`, reference, providerCallID))
+}
+
+// ListVoiceCalls returns the customer's calls, latest first.
+func (db *DatabaseService) ListVoiceCalls(ctx context.Context, customerID string, limit int) ([]*api.VoiceCall, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+voiceCallColumns+`
+		FROM voice_calls
+		WHERE customer_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calls := []*api.VoiceCall{}
+	for rows.Next() {
+		call, err := scanVoiceCall(rows)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return calls, rows.Err()
+}
+
+// VoiceReminderTarget is an account in arrears with what a reminder call
+// says about it.
+type VoiceReminderTarget struct {
+	CustomerID         string
+	Arrears            float64
+	DaysPastDue        int
+	OutstandingBalance float64
+}
+
+// VoiceReminderTargets returns open accounts at least minDaysPastDue
+// behind that haven't had a reminder call in the last recallDays days,
+// most overdue first, or just customerID's account whatever its calls.
+func (db *DatabaseService) VoiceReminderTargets(ctx context.Context, customerID string, minDaysPastDue, recallDays, limit int) ([]VoiceReminderTarget, error) {
+	query := `
+		WITH accounts AS (
+			SELECT customer_id, outstanding_balance,
+			       ` + arrearsExpr + ` AS arrears,
+			       ` + installmentExpr + ` AS installment
+			FROM customer_accounts
+			WHERE written_off_at IS NULL AND outstanding_balance > 0 AND ($1 = '' OR customer_id = $1)
+		)
+		SELECT customer_id, arrears, COALESCE(CEIL(arrears / NULLIF(installment, 0))::INT * 7, 0), outstanding_balance
+		FROM accounts a
+		WHERE arrears > 0
+		  AND ($1 <> '' OR (
+		      COALESCE(CEIL(arrears / NULLIF(installment, 0))::INT * 7, 0) >= $2
+		      AND NOT EXISTS (SELECT 1 FROM voice_calls v
+		                      WHERE v.customer_id = a.customer_id AND v.created_at > NOW() - $3 * INTERVAL '1 day')))
+		ORDER BY arrears / NULLIF(installment, 0) DESC NULLS LAST, customer_id
+		LIMIT $4
+	`
+
+	rows, err := db.Query(ctx, query, customerID, minDaysPastDue, recallDays, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []VoiceReminderTarget{}
+	for rows.Next() {
+		var t VoiceReminderTarget
+		if err := rows.Scan(&t.CustomerID, &t.Arrears, &t.DaysPastDue, &t.OutstandingBalance); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
@@ -0,0 +1,471 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// WebhookEventFamilies are the kinds of event sent to webhook subscribers:
+// payout.<status> as payouts move, settlement_import.<status> as settlement
+// files finish importing.
+var WebhookEventFamilies = []string{"payout", "settlement_import"}
+
+// ValidateWebhookEventTypes checks each subscribed event type is *, a known
+// family, or an event in one.
+func ValidateWebhookEventTypes(eventTypes []string) error {
+	for _, eventType := range eventTypes {
+		if eventType == "*" {
+			continue
+		}
+		family, event, dotted := strings.Cut(eventType, ".")
+		known := false
+		for _, f := range WebhookEventFamilies {
+			if f == family {
+				known = true
+			}
+		}
+		if !known || (dotted && event == "") {
+			return fmt.Errorf("unknown event type %q; use *, one of %s, or <family>.<event>", eventType, strings.Join(WebhookEventFamilies, ", "))
+		}
+	}
+	return nil
+}
+
+// WebhookEventMatches reports whether a subscription to eventTypes receives
+// eventType.
+func WebhookEventMatches(eventTypes []string, eventType string) bool {
+	for _, subscribed := range eventTypes {
+		if subscribed == "*" || subscribed == eventType || strings.HasPrefix(eventType, subscribed+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// NewWebhookSecret generates a signing secret for a subscription that
+// didn't bring one.
+func NewWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+const webhookSubscriptionColumns = `id, client_id, url, event_types, active, created_at, updated_at`
+
+func scanWebhookSubscription(row pgx.Row) (*api.WebhookSubscription, error) {
+	var s api.WebhookSubscription
+	err := row.Scan(&s.ID, &s.ClientID, &s.URL, &s.EventTypes, &s.Active, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateWebhookSubscription registers the subscription with its first
+// signing key, generating the secret when the request has none. Secrets are
+// stored sealed like other sensitive fields and returned this once.
+func (db *DatabaseService) CreateWebhookSubscription(ctx context.Context, request *api.WebhookSubscriptionRequest) (*api.WebhookSubscription, error) {
+	secret := request.Secret
+	if secret == "" {
+		var err error
+		if secret, err = NewWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+	sealed, err := db.sealPII(secret)
+	if err != nil {
+		return nil, err
+	}
+	active := request.Active == nil || *request.Active
+
+	var subscription *api.WebhookSubscription
+	err = db.inTx(ctx, func(tx pgx.Tx) error {
+		subscription, err = scanWebhookSubscription(tx.QueryRow(ctx, `
+			INSERT INTO webhook_subscriptions (client_id, url, event_types, active)
+			VALUES ($1, $2, $3, $4)
+			RETURNING `+webhookSubscriptionColumns,
+			request.ClientID, request.URL, request.EventTypes, active))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO webhook_signing_keys (subscription_id, version, secret) VALUES ($1, 1, $2)
+		`, subscription.ID, sealed)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	subscription.Secret = secret
+	subscription.KeyID = webhookKeyID(1)
+	return subscription, nil
+}
+
+// UpdateWebhookSubscription changes the subscription. The active flag is
+// kept unless the request sets it; secrets change by rotation.
+func (db *DatabaseService) UpdateWebhookSubscription(ctx context.Context, id int64, request *api.WebhookSubscriptionRequest) (*api.WebhookSubscription, error) {
+	return scanWebhookSubscription(db.QueryRow(ctx, `
+		UPDATE webhook_subscriptions
+		SET client_id = $2, url = $3, event_types = $4, active = COALESCE($5, active), updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+webhookSubscriptionColumns,
+		id, request.ClientID, request.URL, request.EventTypes, request.Active))
+}
+
+// DeleteWebhookSubscription removes the subscription and its delivery log.
+func (db *DatabaseService) DeleteWebhookSubscription(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (db *DatabaseService) GetWebhookSubscription(ctx context.Context, id int64) (*api.WebhookSubscription, error) {
+	return scanWebhookSubscription(db.QueryRow(ctx,
+		`SELECT `+webhookSubscriptionColumns+` FROM webhook_subscriptions WHERE id = $1`, id))
+}
+
+// ListWebhookSubscriptions returns the subscriptions of one API client, or
+// of all of them when clientID is empty.
+func (db *DatabaseService) ListWebhookSubscriptions(ctx context.Context, clientID string) ([]*api.WebhookSubscription, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+webhookSubscriptionColumns+`
+		FROM webhook_subscriptions
+		WHERE $1 = '' OR client_id = $1
+		ORDER BY id
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []*api.WebhookSubscription{}
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// WebhookTargets returns the active subscriptions that receive eventType.
+func (db *DatabaseService) WebhookTargets(ctx context.Context, eventType string) ([]*api.WebhookSubscription, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+webhookSubscriptionColumns+`
+		FROM webhook_subscriptions
+		WHERE active
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []*api.WebhookSubscription
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		if WebhookEventMatches(subscription.EventTypes, eventType) {
+			targets = append(targets, subscription)
+		}
+	}
+	return targets, rows.Err()
+}
+
+// ErrLastWebhookKey is returned when expiring a subscription's only
+// current signing key, which would leave deliveries unsigned.
+var ErrLastWebhookKey = errors.New("subscription has no other current signing key")
+
+func webhookKeyID(version int) string {
+	return "v" + strconv.Itoa(version)
+}
+
+func webhookKeyVersion(keyID string) (int, bool) {
+	version, err := strconv.Atoi(strings.TrimPrefix(keyID, "v"))
+	return version, err == nil && strings.HasPrefix(keyID, "v") && version > 0
+}
+
+const webhookKeyColumns = `subscription_id, version, created_at, expires_at, expires_at IS NULL OR expires_at > NOW()`
+
+func scanWebhookKey(row pgx.Row) (*api.WebhookSigningKey, error) {
+	var k api.WebhookSigningKey
+	var version int
+	if err := row.Scan(&k.SubscriptionID, &version, &k.CreatedAt, &k.ExpiresAt, &k.Active); err != nil {
+		return nil, err
+	}
+	k.KeyID = webhookKeyID(version)
+	return &k, nil
+}
+
+// RotateWebhookKey adds a signing key, generating the secret when none is
+// given, and sets the current keys to expire after grace. Until then
+// deliveries carry signatures with both, so the partner can switch over at
+// any point in the window. The new secret is returned this once.
+func (db *DatabaseService) RotateWebhookKey(ctx context.Context, subscriptionID int64, secret string, grace time.Duration) (*api.WebhookSigningKey, error) {
+	if secret == "" {
+		var err error
+		if secret, err = NewWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+	sealed, err := db.sealPII(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var key *api.WebhookSigningKey
+	err = db.inTx(ctx, func(tx pgx.Tx) error {
+		// Locking the subscription serialises rotations, which would
+		// otherwise race for the next version.
+		var id int64
+		if err := tx.QueryRow(ctx, `SELECT id FROM webhook_subscriptions WHERE id = $1 FOR UPDATE`, subscriptionID).Scan(&id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `
+			UPDATE webhook_signing_keys
+			SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), NOW() + $2 * INTERVAL '1 second')
+			WHERE subscription_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		`, subscriptionID, int64(grace.Seconds()))
+		if err != nil {
+			return err
+		}
+		key, err = scanWebhookKey(tx.QueryRow(ctx, `
+			INSERT INTO webhook_signing_keys (subscription_id, version, secret)
+			SELECT $1, COALESCE(MAX(version), 0) + 1, $2 FROM webhook_signing_keys WHERE subscription_id = $1
+			RETURNING `+webhookKeyColumns, subscriptionID, sealed))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	key.Secret = secret
+	return key, nil
+}
+
+// ExpireWebhookKey stops signing with a key now, for a secret that has
+// leaked. The subscription must keep another current key.
+func (db *DatabaseService) ExpireWebhookKey(ctx context.Context, subscriptionID int64, keyID string) (*api.WebhookSigningKey, error) {
+	version, ok := webhookKeyVersion(keyID)
+	if !ok {
+		return nil, errNoRows
+	}
+
+	var key *api.WebhookSigningKey
+	err := db.inTx(ctx, func(tx pgx.Tx) error {
+		var others int
+		err := tx.QueryRow(ctx, `
+			SELECT COUNT(*) FROM webhook_signing_keys
+			WHERE subscription_id = $1 AND version <> $2 AND (expires_at IS NULL OR expires_at > NOW())
+		`, subscriptionID, version).Scan(&others)
+		if err != nil {
+			return err
+		}
+		if others == 0 {
+			return ErrLastWebhookKey
+		}
+		key, err = scanWebhookKey(tx.QueryRow(ctx, `
+			UPDATE webhook_signing_keys
+			SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), NOW())
+			WHERE subscription_id = $1 AND version = $2
+			RETURNING `+webhookKeyColumns, subscriptionID, version))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ListWebhookKeys returns the subscription's signing keys, newest first,
+// without their secrets.
+func (db *DatabaseService) ListWebhookKeys(ctx context.Context, subscriptionID int64) ([]*api.WebhookSigningKey, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+webhookKeyColumns+`
+		FROM webhook_signing_keys
+		WHERE subscription_id = $1
+		ORDER BY version DESC
+	`, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*api.WebhookSigningKey{}
+	for rows.Next() {
+		key, err := scanWebhookKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// WebhookKey is a current signing key with its secret.
+type WebhookKey struct {
+	KeyID  string
+	Secret string
+}
+
+// WebhookSigningKeys returns the keys deliveries to the subscription are
+// signed with, newest first.
+func (db *DatabaseService) WebhookSigningKeys(ctx context.Context, subscriptionID int64) ([]WebhookKey, error) {
+	rows, err := db.Query(ctx, `
+		SELECT version, secret
+		FROM webhook_signing_keys
+		WHERE subscription_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY version DESC
+	`, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []WebhookKey
+	for rows.Next() {
+		var version int
+		var sealed string
+		if err := rows.Scan(&version, &sealed); err != nil {
+			return nil, err
+		}
+		keys = append(keys, WebhookKey{KeyID: webhookKeyID(version), Secret: sealed})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		if keys[i].Secret, err = db.openPII(ctx, keys[i].Secret); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// RecordWebhookEvent appends an event to the log before it is delivered.
+func (db *DatabaseService) RecordWebhookEvent(ctx context.Context, eventType string, payload []byte) (*api.WebhookEvent, error) {
+	var e api.WebhookEvent
+	err := db.QueryRow(ctx, `
+		INSERT INTO webhook_events (event_type, payload) VALUES ($1, $2)
+		RETURNING id, event_type, payload, created_at
+	`, eventType, payload).Scan(&e.ID, &e.Type, &e.Payload, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// WebhookEventFilter selects events after the AfterID cursor, up to and
+// including UntilID when it is set. EventTypes, when set, are subscription
+// event types the events must match.
+type WebhookEventFilter struct {
+	AfterID    int64
+	UntilID    int64
+	EventTypes []string
+	Limit      int
+}
+
+// ListWebhookEvents returns events in log order.
+func (db *DatabaseService) ListWebhookEvents(ctx context.Context, filter WebhookEventFilter) ([]*api.WebhookEvent, error) {
+	// Subscriptions name events, families or *; families have no dots of
+	// their own, so the part before the first dot is the family.
+	rows, err := db.Query(ctx, `
+		SELECT id, event_type, payload, created_at
+		FROM webhook_events
+		WHERE id > $1
+		  AND ($2 = 0 OR id <= $2)
+		  AND (CARDINALITY($3::TEXT[]) = 0 OR '*' = ANY($3) OR event_type = ANY($3) OR split_part(event_type, '.', 1) = ANY($3))
+		ORDER BY id
+		LIMIT $4
+	`, filter.AfterID, filter.UntilID, filter.EventTypes, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*api.WebhookEvent{}
+	for rows.Next() {
+		var e api.WebhookEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+const webhookDeliveryColumns = `
+	id, subscription_id, event_id, event_type, payload, test, replay, success, COALESCE(status_code, 0),
+	COALESCE(error, ''), duration_ms, delivered_at
+`
+
+func scanWebhookDelivery(row pgx.Row) (*api.WebhookDelivery, error) {
+	var d api.WebhookDelivery
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Test, &d.Replay, &d.Success, &d.StatusCode,
+		&d.Error, &d.DurationMs, &d.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RecordWebhookDelivery logs a delivery attempt.
+func (db *DatabaseService) RecordWebhookDelivery(ctx context.Context, delivery *api.WebhookDelivery) (*api.WebhookDelivery, error) {
+	return scanWebhookDelivery(db.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, test, replay, success, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), NULLIF($9, ''), $10)
+		RETURNING `+webhookDeliveryColumns,
+		delivery.SubscriptionID, delivery.EventID, delivery.EventType, delivery.Payload, delivery.Test, delivery.Replay,
+		delivery.Success, delivery.StatusCode, delivery.Error, delivery.DurationMs))
+}
+
+// WebhookDeliveryFilter narrows the delivery log. Success, when set, keeps
+// only successful or only failed deliveries.
+type WebhookDeliveryFilter struct {
+	EventType string
+	Success   *bool
+	Limit     int
+}
+
+// ListWebhookDeliveries returns the subscription's deliveries, newest first.
+func (db *DatabaseService) ListWebhookDeliveries(ctx context.Context, subscriptionID int64, filter WebhookDeliveryFilter) ([]*api.WebhookDelivery, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries
+		WHERE subscription_id = $1
+		  AND ($2 = '' OR event_type = $2)
+		  AND ($3::BOOLEAN IS NULL OR success = $3)
+		ORDER BY delivered_at DESC, id DESC
+		LIMIT $4
+	`, subscriptionID, filter.EventType, filter.Success, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*api.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
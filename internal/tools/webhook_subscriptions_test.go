@@ -0,0 +1,41 @@
+package tools
+
+import "testing"
+
+func TestWebhookEventMatches(t *testing.T) {
+	tests := []struct {
+		subscribed []string
+		event      string
+		want       bool
+	}{
+		{[]string{"*"}, "payout.SUCCEEDED", true},
+		{[]string{"payout"}, "payout.FAILED", true},
+		{[]string{"payout.FAILED"}, "payout.FAILED", true},
+		{[]string{"payout.FAILED"}, "payout.SUCCEEDED", false},
+		{[]string{"payout"}, "payouts.FAILED", false},
+		{[]string{"settlement_import", "payout.FAILED"}, "settlement_import.COMPLETED", true},
+		{nil, "payout.FAILED", false},
+	}
+
+	for _, tt := range tests {
+		if got := WebhookEventMatches(tt.subscribed, tt.event); got != tt.want {
+			t.Errorf("WebhookEventMatches(%v, %q) = %v, want %v", tt.subscribed, tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWebhookEventTypes(t *testing.T) {
+	valid := [][]string{{"*"}, {"payout"}, {"payout.SUCCEEDED", "settlement_import.FAILED"}}
+	for _, eventTypes := range valid {
+		if err := ValidateWebhookEventTypes(eventTypes); err != nil {
+			t.Errorf("ValidateWebhookEventTypes(%v) = %v, want nil", eventTypes, err)
+		}
+	}
+
+	invalid := [][]string{{"payments"}, {"payout."}, {"payout", "refund.CREATED"}}
+	for _, eventTypes := range invalid {
+		if err := ValidateWebhookEventTypes(eventTypes); err == nil {
+			t.Errorf("ValidateWebhookEventTypes(%v) = nil, want error", eventTypes)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+)
+
+type WriteOffSummary struct {
+	Region             string  `json:"region"`
+	AccountsWrittenOff int     `json:"accounts_written_off"`
+	AmountWrittenOff   float64 `json:"amount_written_off"`
+	RecoveryPayments   int     `json:"recovery_payments"`
+	AmountRecovered    float64 `json:"amount_recovered"`
+}
+
+// WriteOffCustomer moves the remaining balance off the book. Later payments
+// still post but are counted as recoveries rather than collections.
+func (db *DatabaseService) WriteOffCustomer(ctx context.Context, customerID, reason string) (*api.CustomerAccount, error) {
+	query := `
+		UPDATE customer_accounts
+		SET written_off_at = NOW(),
+		    written_off_amount = outstanding_balance,
+		    write_off_reason = $2,
+		    outstanding_balance = 0,
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE customer_id = $1 AND written_off_at IS NULL AND outstanding_balance > 0
+		RETURNING ` + customerColumns
+
+	return scanCustomer(db.QueryRow(ctx, query, customerID, reason))
+}
+
+// GetWriteOffReport summarises write-offs booked and recoveries received in
+// the period, per region.
+func (db *DatabaseService) GetWriteOffReport(ctx context.Context, from, to time.Time, region string) ([]WriteOffSummary, error) {
+	query := `
+		WITH written_off AS (
+			SELECT COALESCE(region, 'UNASSIGNED') AS region,
+			       COUNT(*) AS accounts,
+			       SUM(written_off_amount) AS amount
+			FROM customer_accounts
+			WHERE written_off_at >= $1 AND written_off_at < $2
+			  AND ($3 = '' OR region = $3)
+			GROUP BY 1
+		), recovered AS (
+			SELECT COALESCE(c.region, 'UNASSIGNED') AS region,
+			       COUNT(*) AS payments,
+			       SUM(t.amount) AS amount
+			FROM processed_transactions t
+			JOIN customer_accounts c ON c.customer_id = t.customer_id
+			WHERE t.recovery AND t.processed_at >= $1 AND t.processed_at < $2
+			  AND ($3 = '' OR c.region = $3)
+			GROUP BY 1
+		)
+		SELECT COALESCE(w.region, r.region),
+		       COALESCE(w.accounts, 0), COALESCE(w.amount, 0),
+		       COALESCE(r.payments, 0), COALESCE(r.amount, 0)
+		FROM written_off w
+		FULL OUTER JOIN recovered r ON r.region = w.region
+		ORDER BY 1
+	`
+
+	rows, err := db.Query(ctx, query, from, to, region)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []WriteOffSummary{}
+	for rows.Next() {
+		var summary WriteOffSummary
+		if err := rows.Scan(
+			&summary.Region,
+			&summary.AccountsWrittenOff,
+			&summary.AmountWrittenOff,
+			&summary.RecoveryPayments,
+			&summary.AmountRecovered,
+		); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
@@ -0,0 +1,67 @@
+package ussd
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/abjerry97/go_payment/internal/validation"
+	"github.com/gin-gonic/gin"
+)
+
+// AfricasTalkingHandler implements the form-encoded callback used by
+// Africa's Talking and compatible aggregators, where "text" carries the
+// cumulative input ("1*2") and the reply is prefixed with CON or END.
+func AfricasTalkingHandler(menu *Menu) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		text := c.PostForm("text")
+		if idx := strings.LastIndex(text, "*"); idx >= 0 {
+			text = text[idx+1:]
+		}
+
+		resp := menu.Handle(c.Request.Context(), Request{
+			SessionID: c.PostForm("sessionId"),
+			Phone:     c.PostForm("phoneNumber"),
+			Input:     text,
+		})
+
+		prefix := "CON "
+		if resp.End {
+			prefix = "END "
+		}
+		c.String(http.StatusOK, prefix+resp.Text)
+	}
+}
+
+// JSONHandler implements the JSON callback shape used by Hubtel/Nalo-style
+// gateways: {"sessionId", "msisdn", "userData"} in, {"message", "continueSession"} out.
+func JSONHandler(menu *Menu) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			SessionID  string `json:"sessionId" binding:"required"`
+			MSISDN     string `json:"msisdn" binding:"required"`
+			UserData   string `json:"userData"`
+			NewSession bool   `json:"newSession"`
+		}
+
+		if !validation.BindJSON(c, &request) {
+			return
+		}
+
+		input := request.UserData
+		if request.NewSession {
+			input = ""
+		}
+
+		resp := menu.Handle(c.Request.Context(), Request{
+			SessionID: request.SessionID,
+			Phone:     request.MSISDN,
+			Input:     input,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"sessionId":       request.SessionID,
+			"message":         resp.Text,
+			"continueSession": !resp.End,
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package ussd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/abjerry97/go_payment/internal/schedule"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+const sessionTTL = 3 * time.Minute
+
+type Request struct {
+	SessionID string
+	Phone     string
+	Input     string
+}
+
+type Response struct {
+	Text string
+	End  bool
+}
+
+type session struct {
+	Phone      string `json:"phone"`
+	CustomerID string `json:"customer_id"`
+}
+
+type Menu struct {
+	db    *tools.DatabaseService
+	redis *tools.RedisService
+}
+
+func NewMenu(db *tools.DatabaseService, redis *tools.RedisService) *Menu {
+	return &Menu{db: db, redis: redis}
+}
+
+func (m *Menu) Handle(ctx context.Context, req Request) Response {
+	sess, err := m.loadSession(ctx, req)
+	if err != nil {
+		log.Printf("USSD session %s error: %v", req.SessionID, err)
+		return Response{Text: "Service unavailable. Please try again later.", End: true}
+	}
+	if sess == nil {
+		return Response{Text: "This phone number is not linked to an account.", End: true}
+	}
+
+	switch strings.TrimSpace(req.Input) {
+	case "":
+		return Response{Text: "Welcome\n1. Check balance\n2. Last payment\n3. Next due date"}
+	case "1":
+		return m.balance(ctx, sess)
+	case "2":
+		return m.lastPayment(ctx, sess)
+	case "3":
+		return m.nextDue(ctx, sess)
+	default:
+		return Response{Text: "Invalid option.\n1. Check balance\n2. Last payment\n3. Next due date"}
+	}
+}
+
+func (m *Menu) balance(ctx context.Context, sess *session) Response {
+	customer, err := m.db.GetCustomer(ctx, sess.CustomerID)
+	if err != nil {
+		return Response{Text: "Unable to fetch your balance.", End: true}
+	}
+
+	return Response{
+		Text: fmt.Sprintf("Account %s\nPaid: %s\nBalance: %s",
+			customer.CustomerID,
+			i18n.FormatAmount(i18n.DefaultLocale, customer.TotalPaid),
+			i18n.FormatAmount(i18n.DefaultLocale, customer.OutstandingBalance)),
+		End: true,
+	}
+}
+
+func (m *Menu) lastPayment(ctx context.Context, sess *session) Response {
+	transactions, err := m.db.GetRecentTransactions(ctx, sess.CustomerID, 1)
+	if err != nil {
+		return Response{Text: "Unable to fetch your payments.", End: true}
+	}
+	if len(transactions) == 0 {
+		return Response{Text: "No payments recorded yet.", End: true}
+	}
+
+	txn := transactions[0]
+	return Response{
+		Text: fmt.Sprintf("Last payment: %s on %s\nRef: %s",
+			i18n.FormatAmount(i18n.DefaultLocale, txn.Amount),
+			i18n.FormatDate(i18n.DefaultLocale, txn.ProcessedAt),
+			txn.TransactionReference),
+		End: true,
+	}
+}
+
+func (m *Menu) nextDue(ctx context.Context, sess *session) Response {
+	customer, err := m.db.GetCustomer(ctx, sess.CustomerID)
+	if err != nil {
+		return Response{Text: "Unable to fetch your schedule.", End: true}
+	}
+
+	cal, err := m.db.CustomerCalendar(ctx, customer)
+	if err != nil {
+		log.Printf("USSD: failed to load holiday calendar for %s: %v", customer.CustomerID, err)
+	}
+	next := schedule.NextInstallment(customer, m.db.Now(), cal)
+	if next == nil {
+		return Response{Text: "Your asset is fully paid. Thank you!", End: true}
+	}
+
+	return Response{
+		Text: fmt.Sprintf("Next due: %s\nAmount due: %s",
+			i18n.FormatDate(i18n.DefaultLocale, next.DueDate),
+			i18n.FormatAmount(i18n.DefaultLocale, next.AmountDue)),
+		End: true,
+	}
+}
+
+func (m *Menu) loadSession(ctx context.Context, req Request) (*session, error) {
+	key := m.redis.Key("ussd:session:" + req.SessionID)
+
+	data, err := m.redis.Client.Get(ctx, key).Bytes()
+	if err == nil {
+		// A session only answers for the phone that started it.
+		var sess session
+		if err := json.Unmarshal(data, &sess); err == nil && sess.Phone == req.Phone {
+			return &sess, nil
+		}
+	} else if !errors.Is(err, tools.ErrNotFound) {
+		return nil, err
+	}
+
+	customerID, err := m.db.ResolveCustomerID(ctx, api.IdentifierPhone, req.Phone)
+	if err != nil {
+		return nil, nil
+	}
+
+	sess := &session{Phone: req.Phone, CustomerID: customerID}
+	if data, err := json.Marshal(sess); err == nil {
+		m.redis.Client.SetEX(ctx, key, data, sessionTTL)
+	}
+	return sess, nil
+}
@@ -0,0 +1,77 @@
+package ussd
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Verifier checks that a callback came from the USSD aggregator, which
+// vouches for the phone number the menu answers about. Aggregators don't
+// sign their callbacks, so it takes a shared token, sent as X-USSD-Token
+// or the token query parameter of the callback URL, or the aggregator's
+// addresses.
+type Verifier struct {
+	token    string
+	networks []*net.IPNet
+}
+
+// NewVerifier takes the token and a comma-separated list of IPs and CIDR
+// ranges; either may be empty. It returns nil if both are, and a nil
+// Verifier refuses every callback.
+func NewVerifier(token, allowedIPs string) (*Verifier, error) {
+	v := &Verifier{token: token}
+	for _, entry := range strings.Split(allowedIPs, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid USSD allowed IP %q: %w", entry, err)
+		}
+		v.networks = append(v.networks, network)
+	}
+	if v.token == "" && len(v.networks) == 0 {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// Verify reports whether r carries the token or comes from an allowed
+// address. The peer address is used, not forwarded headers.
+func (v *Verifier) Verify(r *http.Request) bool {
+	if v == nil {
+		return false
+	}
+	if v.token != "" {
+		token := r.Header.Get("X-USSD-Token")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(v.token)) == 1 {
+			return true
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range v.networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package ussd
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	if v, err := NewVerifier("", " "); err != nil || v != nil {
+		t.Fatalf("unconfigured: got %v, %v; want nil, nil", v, err)
+	}
+	var unconfigured *Verifier
+	if unconfigured.Verify(httptest.NewRequest("POST", "/ussd/callback", nil)) {
+		t.Fatal("a nil verifier accepted a callback")
+	}
+
+	v, err := NewVerifier("s3cret", "196.201.214.0/24, 10.0.0.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		target string
+		remote string
+		header string
+		want   bool
+	}{
+		{"token header", "/ussd/callback", "203.0.113.9:4000", "s3cret", true},
+		{"token query", "/ussd/callback?token=s3cret", "203.0.113.9:4000", "", true},
+		{"wrong token", "/ussd/callback?token=guess", "203.0.113.9:4000", "", false},
+		{"allowed range", "/ussd/callback", "196.201.214.200:4000", "", true},
+		{"allowed IP", "/ussd/callback", "10.0.0.7:4000", "", true},
+		{"other address", "/ussd/callback", "10.0.0.8:4000", "", false},
+	} {
+		r := httptest.NewRequest("POST", tc.target, nil)
+		r.RemoteAddr = tc.remote
+		if tc.header != "" {
+			r.Header.Set("X-USSD-Token", tc.header)
+		}
+		if got := v.Verify(r); got != tc.want {
+			t.Errorf("%s: Verify = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := NewVerifier("", "not-an-ip"); err == nil {
+		t.Error("accepted an invalid allowed IP")
+	}
+}
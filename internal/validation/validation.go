@@ -0,0 +1,180 @@
+// Package validation turns request binding failures into stable, localized
+// field violations so clients never see Go struct names.
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/i18n"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	CodeValidationFailed = "validation_failed"
+	CodeInvalidJSON      = "invalid_json"
+	CodeInvalidType      = "invalid_type"
+	CodeInvalid          = "invalid"
+)
+
+var registerOnce sync.Once
+
+// RegisterJSONFieldNames makes the gin validator report fields by their JSON
+// names. BindJSON calls it; servers may call it earlier at start-up.
+func RegisterJSONFieldNames() {
+	registerOnce.Do(func() {
+		engine, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		engine.RegisterTagNameFunc(jsonFieldName)
+	})
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// BindJSON binds the request body into obj. On failure it writes a 400
+// ValidationErrorResponse in the request locale and returns false.
+func BindJSON(c *gin.Context, obj interface{}) bool {
+	RegisterJSONFieldNames()
+	if err := c.ShouldBindJSON(obj); err != nil {
+		Abort(c, err, obj)
+		return false
+	}
+	return true
+}
+
+// Abort writes the 400 response for a binding error on obj.
+func Abort(c *gin.Context, err error, obj interface{}) {
+	locale := c.GetString("locale")
+	response := api.ValidationErrorResponse{
+		Error:      i18n.Translate(locale, i18n.MsgValidationFailed),
+		Code:       CodeValidationFailed,
+		Violations: Translate(locale, err, obj),
+	}
+	if len(response.Violations) == 0 {
+		response.Error = i18n.Translate(locale, i18n.MsgInvalidJSON)
+		response.Code = CodeInvalidJSON
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, response)
+}
+
+// Translate converts a binding error into field violations. It returns nil
+// for malformed bodies that can't be pinned to a field. obj is the bind
+// target, used to name fields referenced by cross-field rules.
+func Translate(locale string, err error, obj interface{}) []api.FieldViolation {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		violations := make([]api.FieldViolation, 0, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			violations = append(violations, translateFieldError(locale, fieldErr, obj))
+		}
+		return violations
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		field := typeErr.Field
+		return []api.FieldViolation{{
+			Field:   field,
+			Code:    CodeInvalidType,
+			Message: i18n.Translate(locale, i18n.ValidationPrefix+CodeInvalidType, field, jsonTypeName(typeErr.Type)),
+		}}
+	}
+
+	return nil
+}
+
+func translateFieldError(locale string, fieldErr validator.FieldError, obj interface{}) api.FieldViolation {
+	field := fieldPath(fieldErr.Namespace())
+	code := fieldErr.Tag()
+	param := fieldErr.Param()
+
+	key := i18n.ValidationPrefix + code
+	switch code {
+	case "max", "min", "len":
+		switch fieldErr.Kind() {
+		case reflect.String:
+			key += ".length"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			key += ".items"
+		}
+	case "oneof":
+		param = strings.ReplaceAll(param, " ", ", ")
+	case "required_without":
+		param = jsonNameOf(obj, param)
+	}
+
+	var message string
+	switch {
+	case !i18n.Has(key):
+		code = CodeInvalid
+		message = i18n.Translate(locale, i18n.ValidationPrefix+CodeInvalid, field)
+	case param == "":
+		message = i18n.Translate(locale, key, field)
+	default:
+		message = i18n.Translate(locale, key, field, param)
+	}
+
+	return api.FieldViolation{Field: field, Code: code, Message: message}
+}
+
+// fieldPath drops the root struct name from a validator namespace, e.g.
+// "SplitPaymentRequest.splits[0].amount" becomes "splits[0].amount".
+func fieldPath(namespace string) string {
+	if i := strings.IndexByte(namespace, '.'); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+// jsonNameOf maps a Go field name on obj's top-level struct to its JSON
+// name, falling back to the name as given.
+func jsonNameOf(obj interface{}, goName string) string {
+	t := reflect.TypeOf(obj)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return goName
+	}
+	if field, ok := t.FieldByName(goName); ok {
+		if name := jsonFieldName(field); name != "" {
+			return name
+		}
+	}
+	return goName
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
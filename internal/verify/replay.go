@@ -0,0 +1,130 @@
+// Package verify checks that processing is idempotent end to end: it replays
+// the archive of accepted payments into a shadow database and compares the
+// resulting balances with production.
+package verify
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Divergence is one account field that differs between production and the
+// replay.
+type Divergence struct {
+	CustomerID string  `json:"customer_id"`
+	Field      string  `json:"field"`
+	Production float64 `json:"production"`
+	Replayed   float64 `json:"replayed"`
+}
+
+// Failure is an archived payment the replay could not apply.
+type Failure struct {
+	TransactionReference string `json:"transaction_reference"`
+	CustomerID           string `json:"customer_id"`
+	Error                string `json:"error"`
+}
+
+type Report struct {
+	Payments    int          `json:"payments"`
+	Customers   int          `json:"customers"`
+	Divergences []Divergence `json:"divergences"`
+	Failures    []Failure    `json:"failures"`
+}
+
+// Clean reports whether the replay matched production exactly.
+func (r *Report) Clean() bool {
+	return len(r.Divergences) == 0 && len(r.Failures) == 0
+}
+
+// nopQueue keeps the replay away from the production queue and caches.
+type nopQueue struct{}
+
+func (nopQueue) DequeuePayment(ctx context.Context, timeout time.Duration) (*api.PaymentPayload, error) {
+	return nil, nil
+}
+
+func (nopQueue) MarkDuplicate(ctx context.Context, txnRef string, ttl time.Duration) error {
+	return nil
+}
+
+func (nopQueue) CacheBalance(ctx context.Context, customerID string, balance float64, ttl time.Duration) error {
+	return nil
+}
+
+// Replay resets every account with archived payments (or just customerID's)
+// in shadow to its state before its first payment, applies the archive to it
+// in acceptance order through the payment processor, and compares the
+// result with production.
+//
+// Balances only match when every payment reached the account through the
+// archive; manual adjustments and reversals show up as divergences.
+func Replay(ctx context.Context, production, shadow *tools.DatabaseService, customerID string, logger *log.Logger) (*Report, error) {
+	payments, err := production.GetArchivedPayments(ctx, time.Time{}, production.Now().Add(time.Hour), customerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payment archive: %v", err)
+	}
+
+	report := &Report{Payments: len(payments), Divergences: []Divergence{}, Failures: []Failure{}}
+
+	var customers []*api.CustomerAccount
+	seen := map[string]bool{}
+	for _, payment := range payments {
+		if seen[payment.CustomerID] {
+			continue
+		}
+		seen[payment.CustomerID] = true
+
+		customer, err := production.GetCustomer(ctx, payment.CustomerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get customer %s: %v", payment.CustomerID, err)
+		}
+		if err := shadow.ResetReplayAccount(ctx, customer); err != nil {
+			return nil, fmt.Errorf("failed to reset shadow account %s: %v", payment.CustomerID, err)
+		}
+		customers = append(customers, customer)
+	}
+	report.Customers = len(customers)
+	logger.Printf("Replaying %d archived payments across %d accounts", len(payments), len(customers))
+
+	processor := processors.NewPaymentProcessor(shadow, nopQueue{}, 1, processors.WithLogger(logger))
+	for i := range payments {
+		payment := &payments[i]
+		if err := processor.Process(ctx, payment); err != nil {
+			report.Failures = append(report.Failures, Failure{
+				TransactionReference: payment.TransactionReference,
+				CustomerID:           payment.CustomerID,
+				Error:                err.Error(),
+			})
+		}
+	}
+
+	for _, expected := range customers {
+		replayed, err := shadow.GetCustomer(ctx, expected.CustomerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read shadow account %s: %v", expected.CustomerID, err)
+		}
+		report.compare(expected.CustomerID, "total_paid", expected.TotalPaid, replayed.TotalPaid)
+		report.compare(expected.CustomerID, "outstanding_balance", expected.OutstandingBalance, replayed.OutstandingBalance)
+		report.compare(expected.CustomerID, "payment_count", float64(expected.PaymentCount), float64(replayed.PaymentCount))
+	}
+
+	return report, nil
+}
+
+func (r *Report) compare(customerID, field string, production, replayed float64) {
+	if math.Abs(production-replayed) >= 0.005 {
+		r.Divergences = append(r.Divergences, Divergence{
+			CustomerID: customerID,
+			Field:      field,
+			Production: production,
+			Replayed:   replayed,
+		})
+	}
+}
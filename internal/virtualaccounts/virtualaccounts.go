@@ -0,0 +1,188 @@
+package virtualaccounts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Provider assigns dedicated account numbers. Create must be idempotent
+// per customer account: asking again returns the same number.
+type Provider interface {
+	Name() string
+	Create(ctx context.Context, customerID, accountName string) (*api.VirtualAccount, error)
+}
+
+// SandboxProvider hands out stable fake numbers for testing.
+type SandboxProvider struct{}
+
+func (SandboxProvider) Name() string { return "sandbox" }
+
+func (SandboxProvider) Create(ctx context.Context, customerID, accountName string) (*api.VirtualAccount, error) {
+	h := fnv.New32a()
+	h.Write([]byte(customerID))
+	return &api.VirtualAccount{
+		AccountNumber:     fmt.Sprintf("99%08d", h.Sum32()%100000000),
+		BankName:          "Sandbox Bank",
+		AccountName:       accountName,
+		ProviderReference: "SANDBOX-" + customerID,
+	}, nil
+}
+
+// HTTPProvider requests accounts from a JSON API shaped like most
+// virtual-account providers: {reference, account_name} in, {account_number,
+// bank_name, account_name, reference} out. The customer account ID is the
+// reference, which providers use to deduplicate.
+type HTTPProvider struct {
+	name   string
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewHTTPProvider(name, url, apiKey string) *HTTPProvider {
+	return &HTTPProvider{
+		name:   name,
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *HTTPProvider) Name() string { return p.name }
+
+func (p *HTTPProvider) Create(ctx context.Context, customerID, accountName string) (*api.VirtualAccount, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"reference":    customerID,
+		"account_name": accountName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		AccountNumber string `json:"account_number"`
+		BankName      string `json:"bank_name"`
+		AccountName   string `json:"account_name"`
+		Reference     string `json:"reference"`
+		Message       string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&response)
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned status %d: %s", p.name, resp.StatusCode, response.Message)
+	}
+	if response.AccountNumber == "" {
+		return nil, fmt.Errorf("%s returned no account number", p.name)
+	}
+	if response.AccountName == "" {
+		response.AccountName = accountName
+	}
+
+	return &api.VirtualAccount{
+		AccountNumber:     response.AccountNumber,
+		BankName:          response.BankName,
+		AccountName:       response.AccountName,
+		ProviderReference: response.Reference,
+	}, nil
+}
+
+// Service provisions virtual accounts. Transfers into them arrive through
+// the provider's bank feed webhook and resolve to the account by number.
+type Service struct {
+	db       *tools.DatabaseService
+	provider Provider
+	// NamePrefix is put before the customer account ID in account names,
+	// e.g. "GoPayment/".
+	NamePrefix string
+	// BatchSize is how many accounts each Run provisions.
+	BatchSize int
+}
+
+func NewService(db *tools.DatabaseService, provider Provider) *Service {
+	return &Service{db: db, provider: provider, BatchSize: 100}
+}
+
+func (s *Service) Provider() string { return s.provider.Name() }
+
+// Provision returns the customer account's active virtual account,
+// requesting one from the provider if it has none. created reports whether
+// a new number was assigned.
+func (s *Service) Provision(ctx context.Context, customerID string) (account *api.VirtualAccount, created bool, err error) {
+	account, err = s.db.ActiveVirtualAccount(ctx, customerID, s.provider.Name())
+	if err == nil {
+		return account, false, nil
+	}
+	if !errors.Is(err, tools.ErrNotFound) {
+		return nil, false, err
+	}
+
+	account, err = s.provider.Create(ctx, customerID, s.NamePrefix+customerID)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", s.provider.Name(), err)
+	}
+	account.CustomerID = customerID
+	account.Provider = s.provider.Name()
+	if err := s.db.SaveVirtualAccount(ctx, account); err != nil {
+		return nil, false, err
+	}
+
+	tools.DefaultMetrics.Inc("virtual_accounts_provisioned_total", 1, "provider", account.Provider)
+	log.Printf("Virtual account %s (%s) provisioned for %s", account.AccountNumber, account.BankName, customerID)
+	return account, true, nil
+}
+
+// ProvisionMissing provisions accounts for up to limit open customer
+// accounts that don't have one yet. A failure for one account is logged and
+// the rest carry on; it is retried on the next run.
+func (s *Service) ProvisionMissing(ctx context.Context, limit int) (int, error) {
+	customerIDs, err := s.db.AccountsWithoutVirtualAccount(ctx, s.provider.Name(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	provisioned := 0
+	for _, customerID := range customerIDs {
+		if err := ctx.Err(); err != nil {
+			return provisioned, err
+		}
+		if _, _, err := s.Provision(ctx, customerID); err != nil {
+			log.Warnf("Failed to provision virtual account for %s: %v", customerID, err)
+			continue
+		}
+		provisioned++
+	}
+	return provisioned, nil
+}
+
+// Run provisions a batch of missing accounts. Register it with the
+// scheduler to give every open account its own number over time.
+func (s *Service) Run(ctx context.Context) error {
+	provisioned, err := s.ProvisionMissing(ctx, s.BatchSize)
+	if provisioned > 0 {
+		log.Printf("Provisioned %d virtual accounts with %s", provisioned, s.provider.Name())
+	}
+	return err
+}
@@ -0,0 +1,277 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// Dispatcher sends events to the webhook subscriptions that want them. It
+// is a tools.Alerter, so it stands in wherever an event used to go to a
+// single configured webhook URL; Fallback, when set, still gets every
+// event.
+//
+// Events are logged to webhook_events first. Each delivery is the event as
+// JSON, signed in X-Webhook-Signature (see Sign) with every current key of
+// the subscription, and logged to webhook_deliveries whatever the outcome.
+type Dispatcher struct {
+	db       *tools.DatabaseService
+	Fallback tools.Alerter
+	client   *http.Client
+}
+
+func NewDispatcher(db *tools.DatabaseService, fallback tools.Alerter) *Dispatcher {
+	return &Dispatcher{
+		db:       db,
+		Fallback: fallback,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// DeliveryKind says why an event is being delivered.
+type DeliveryKind int
+
+const (
+	LiveDelivery DeliveryKind = iota
+	TestDelivery
+	ReplayDelivery
+)
+
+// Send logs the event and delivers it to every active subscription for its
+// type. A failing subscriber doesn't stop delivery to the others, and can
+// catch up from the log later.
+func (d *Dispatcher) Send(ctx context.Context, alert tools.Alert) error {
+	var errs []error
+	if d.Fallback != nil {
+		if err := d.Fallback.Send(ctx, alert); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	event, err := d.db.RecordWebhookEvent(ctx, alert.Type, payload)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("log webhook event: %w", err))...)
+	}
+
+	targets, err := d.db.WebhookTargets(ctx, alert.Type)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("load webhook subscriptions: %w", err))...)
+	}
+	for _, target := range targets {
+		delivery, err := d.Deliver(ctx, target, event, LiveDelivery)
+		if err != nil {
+			errs = append(errs, err)
+		} else if !delivery.Success {
+			errs = append(errs, fmt.Errorf("webhook subscription %d: %s", target.ID, delivery.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewEvent makes an event that isn't in the log, for test deliveries.
+func NewEvent(alert tools.Alert) (*api.WebhookEvent, error) {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return nil, err
+	}
+	return &api.WebhookEvent{Type: alert.Type, Payload: payload, CreatedAt: alert.Timestamp}, nil
+}
+
+// Deliver posts the event to one subscription and logs the attempt. The
+// error is only for failing to log it; the delivery's own outcome is on the
+// returned record.
+func (d *Dispatcher) Deliver(ctx context.Context, subscription *api.WebhookSubscription, event *api.WebhookEvent, kind DeliveryKind) (*api.WebhookDelivery, error) {
+	delivery := &api.WebhookDelivery{
+		SubscriptionID: subscription.ID,
+		EventType:      event.Type,
+		Payload:        event.Payload,
+		Test:           kind == TestDelivery,
+		Replay:         kind == ReplayDelivery,
+	}
+	if event.ID != 0 {
+		delivery.EventID = &event.ID
+	}
+	keys, err := d.db.WebhookSigningKeys(ctx, subscription.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load signing keys of subscription %d: %w", subscription.ID, err)
+	}
+
+	started := time.Now()
+	delivery.StatusCode, err = d.post(ctx, subscription, keys, event, kind)
+	delivery.DurationMs = int(time.Since(started).Milliseconds())
+	switch {
+	case err != nil:
+		delivery.Error = err.Error()
+	case delivery.StatusCode >= 300:
+		delivery.Error = fmt.Sprintf("webhook returned status %d", delivery.StatusCode)
+	default:
+		delivery.Success = true
+	}
+
+	result := "success"
+	if !delivery.Success {
+		result = "failure"
+	}
+	tools.DefaultMetrics.Inc("webhook_deliveries_total", 1, "result", result)
+
+	recorded, err := d.db.RecordWebhookDelivery(ctx, delivery)
+	if err != nil {
+		log.Printf("Failed to log webhook delivery of %s to subscription %d: %v", event.Type, subscription.ID, err)
+		return delivery, err
+	}
+	return recorded, nil
+}
+
+// ReplayResult is how far a replay got. NextCursor is the last event
+// delivered, to replay from again after a failure or when HasMore.
+type ReplayResult struct {
+	Replayed   int                  `json:"replayed"`
+	NextCursor int64                `json:"next_cursor"`
+	HasMore    bool                 `json:"has_more"`
+	Failed     *api.WebhookDelivery `json:"failed,omitempty"`
+}
+
+// Replay re-delivers the subscription's events after the from cursor, up
+// to and including to when it is set, in log order and at most limit of
+// them. It stops at the first failed delivery so events still arrive in
+// order.
+func (d *Dispatcher) Replay(ctx context.Context, subscription *api.WebhookSubscription, from, to int64, limit int) (*ReplayResult, error) {
+	events, err := d.db.ListWebhookEvents(ctx, tools.WebhookEventFilter{
+		AfterID:    from,
+		UntilID:    to,
+		EventTypes: subscription.EventTypes,
+		Limit:      limit + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReplayResult{NextCursor: from}
+	if len(events) > limit {
+		events, result.HasMore = events[:limit], true
+	}
+	for _, event := range events {
+		delivery, err := d.Deliver(ctx, subscription, event, ReplayDelivery)
+		if err != nil {
+			return result, err
+		}
+		if !delivery.Success {
+			result.Failed, result.HasMore = delivery, true
+			break
+		}
+		result.Replayed++
+		result.NextCursor = event.ID
+	}
+	return result, nil
+}
+
+func (d *Dispatcher) post(ctx context.Context, subscription *api.WebhookSubscription, keys []tools.WebhookKey, event *api.WebhookEvent, kind DeliveryKind) (int, error) {
+	if len(keys) == 0 {
+		return 0, fmt.Errorf("subscription has no current signing key")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(event.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-Subscription", strconv.FormatInt(subscription.ID, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(keys, time.Now(), event.Payload))
+	// The event ID lets consumers drop duplicates, which replays send on
+	// purpose.
+	if event.ID != 0 {
+		req.Header.Set("X-Webhook-Event-ID", strconv.FormatInt(event.ID, 10))
+	}
+	if kind == ReplayDelivery {
+		req.Header.Set("X-Webhook-Replay", "true")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Sign returns the X-Webhook-Signature of body sent at t:
+//
+//	t=<unix seconds>,<key id>=<hex HMAC-SHA256 of "<t>.<body>">,...
+//
+// with a signature for each key, so a subscriber checks whichever key it
+// holds while a rotation is under way. The timestamp is signed too, so a
+// captured delivery can't be replayed outside the tolerance window.
+func Sign(keys []tools.WebhookKey, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	parts := []string{"t=" + timestamp}
+	for _, key := range keys {
+		parts = append(parts, key.KeyID+"="+signature(key.Secret, timestamp, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var (
+	ErrSignatureMissing = errors.New("webhook signature missing or malformed")
+	ErrSignatureExpired = errors.New("webhook signature timestamp outside tolerance")
+	ErrSignatureInvalid = errors.New("webhook signature does not match")
+)
+
+// Verify checks an X-Webhook-Signature the way subscribers should: the
+// timestamp must be within tolerance of now and one of the signatures must
+// match a key the subscriber holds, looked up by key ID.
+func Verify(header string, body []byte, secrets map[string]string, tolerance time.Duration, now time.Time) error {
+	var timestamp string
+	signatures := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrSignatureMissing
+		}
+		if name == "t" {
+			timestamp = value
+		} else {
+			signatures[name] = value
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrSignatureMissing
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+	for keyID, secret := range secrets {
+		got, ok := signatures[keyID]
+		if ok && hmac.Equal([]byte(got), []byte(signature(secret, timestamp, body))) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
@@ -0,0 +1,40 @@
+package webhooks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abjerry97/go_payment/internal/tools"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"type":"payout.SUCCEEDED"}`)
+	sent := time.Unix(1700000000, 0)
+	header := Sign([]tools.WebhookKey{{KeyID: "v2", Secret: "new-secret"}, {KeyID: "v1", Secret: "old-secret"}}, sent, body)
+
+	tests := []struct {
+		name    string
+		secrets map[string]string
+		body    []byte
+		now     time.Time
+		want    error
+	}{
+		{"new key", map[string]string{"v2": "new-secret"}, body, sent, nil},
+		{"old key during rotation", map[string]string{"v1": "old-secret"}, body, sent.Add(4 * time.Minute), nil},
+		{"wrong secret", map[string]string{"v1": "guess"}, body, sent, ErrSignatureInvalid},
+		{"unknown key", map[string]string{"v3": "new-secret"}, body, sent, ErrSignatureInvalid},
+		{"tampered body", map[string]string{"v2": "new-secret"}, []byte(`{"type":"payout.FAILED"}`), sent, ErrSignatureInvalid},
+		{"replayed late", map[string]string{"v2": "new-secret"}, body, sent.Add(6 * time.Minute), ErrSignatureExpired},
+	}
+
+	for _, tt := range tests {
+		if err := Verify(header, tt.body, tt.secrets, 5*time.Minute, tt.now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if err := Verify("sha256=abc", body, map[string]string{"v1": "old-secret"}, 5*time.Minute, sent); !errors.Is(err, ErrSignatureMissing) {
+		t.Errorf("Verify(unversioned header) = %v, want %v", err, ErrSignatureMissing)
+	}
+}
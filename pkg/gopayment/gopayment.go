@@ -0,0 +1,229 @@
+// Package gopayment embeds the payment service in another program: the
+// queue-driven payment processor and the HTTP API, built from a Config or
+// from stores the caller provides.
+//
+// This package is the supported API. Its exported names follow semantic
+// versioning; the internal packages behind it may change at any time.
+//
+//	svc, err := gopayment.New(ctx, gopayment.LoadConfig(),
+//		gopayment.WithWorkers(8),
+//		gopayment.WithHooks(gopayment.HookFuncs{Before: fraudCheck}),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer svc.Close()
+//	svc.Start(ctx)
+//	mux.Handle("/", svc.Handler())
+package gopayment
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/abjerry97/go_payment/api"
+	"github.com/abjerry97/go_payment/internal/calendar"
+	"github.com/abjerry97/go_payment/internal/flags"
+	"github.com/abjerry97/go_payment/internal/processors"
+	"github.com/abjerry97/go_payment/internal/server"
+	"github.com/abjerry97/go_payment/internal/tools"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	Config          = tools.Config
+	Database        = tools.DatabaseService
+	Queue           = tools.RedisService
+	Payment         = api.PaymentPayload
+	CustomerAccount = api.CustomerAccount
+
+	// PaymentStore and PaymentQueue are what a Processor needs; Database
+	// and Queue implement them, and embedders may supply their own.
+	PaymentStore = processors.PaymentStore
+	PaymentQueue = processors.PaymentQueue
+
+	Processor   = processors.PaymentProcessor
+	Hook        = processors.Hook
+	HookFuncs   = processors.HookFuncs
+	ApplyResult = processors.ApplyResult
+	Calendar    = calendar.Calendar
+	Flags       = flags.Provider
+	Clock       = tools.Clock
+	ManualClock = tools.ManualClock
+	Metrics     = tools.Metrics
+	Server      = server.APIServer
+)
+
+// ErrRejectedByHook is returned for payments a BeforeApply hook refused.
+var ErrRejectedByHook = processors.ErrRejectedByHook
+
+// LoadConfig reads the configuration from the environment, as the standalone
+// service does.
+func LoadConfig() *Config {
+	return tools.LoadConfig()
+}
+
+func OpenDatabase(ctx context.Context, cfg *Config) (*Database, error) {
+	return tools.NewDatabaseService(ctx, cfg)
+}
+
+func OpenQueue(redisURL string) (*Queue, error) {
+	return tools.NewRedisService(redisURL)
+}
+
+// NewCalendar builds a business calendar for value-dating payments; see
+// WithCalendar.
+func NewCalendar(location *time.Location, weekend []time.Weekday, cutOff time.Duration) *Calendar {
+	return calendar.New(location, weekend, cutOff)
+}
+
+// NewManualClock returns a clock that stands still until moved; see
+// WithClock.
+func NewManualClock(now time.Time) *ManualClock {
+	return tools.NewManualClock(now)
+}
+
+// NewMetrics returns an empty metrics registry; see WithMetrics.
+func NewMetrics() *Metrics {
+	return tools.NewMetrics()
+}
+
+// Option customises the processor and server built by NewProcessor,
+// NewServer or New. Options that only concern one of them are ignored by
+// the other.
+type Option func(*options)
+
+type options struct {
+	processor []processors.Option
+	server    []server.Option
+	clock     Clock
+}
+
+func buildOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		o.processor = append(o.processor, func(p *Processor) { p.WorkerCount = n })
+	}
+}
+
+func WithHooks(hooks ...Hook) Option {
+	return func(o *options) { o.processor = append(o.processor, processors.WithHooks(hooks...)) }
+}
+
+func WithCalendar(cal *Calendar) Option {
+	return func(o *options) {
+		o.processor = append(o.processor, func(p *Processor) { p.Calendar = cal })
+	}
+}
+
+// WithFlags gates processing changes with provider; see
+// flags.BusinessDayValueDating.
+func WithFlags(provider *Flags) Option {
+	return func(o *options) { o.processor = append(o.processor, processors.WithFlags(provider)) }
+}
+
+// WithLogger sends the processor's logs and the server's request log to
+// logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) {
+		o.processor = append(o.processor, processors.WithLogger(logger))
+		o.server = append(o.server, server.WithLogger(logger))
+	}
+}
+
+// WithMetrics records metrics in metrics, and serves them on /metrics,
+// instead of the process-wide registry.
+func WithMetrics(metrics *Metrics) Option {
+	return func(o *options) {
+		o.processor = append(o.processor, processors.WithMetrics(metrics))
+		o.server = append(o.server, server.WithMetrics(metrics))
+	}
+}
+
+// WithClock replaces the wall clock, e.g. with NewManualClock in tests.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+		o.processor = append(o.processor, processors.WithClock(clock))
+		o.server = append(o.server, server.WithClock(clock))
+	}
+}
+
+// WithMiddleware runs gin middleware on every API route.
+func WithMiddleware(middleware ...gin.HandlerFunc) Option {
+	return func(o *options) { o.server = append(o.server, server.WithMiddleware(middleware...)) }
+}
+
+// NewProcessor builds a payment processor over any store and queue. It runs
+// one worker unless WithWorkers says otherwise.
+func NewProcessor(store PaymentStore, queue PaymentQueue, opts ...Option) *Processor {
+	return processors.NewPaymentProcessor(store, queue, 1, buildOptions(opts).processor...)
+}
+
+// NewServer builds the HTTP API over the service's stores.
+func NewServer(db *Database, queue *Queue, processor *Processor, opts ...Option) *Server {
+	return server.NewAPIServer(db, queue, processor, buildOptions(opts).server...)
+}
+
+// Service is the processor and HTTP API wired to a database and queue.
+type Service struct {
+	Config    *Config
+	Database  *Database
+	Queue     *Queue
+	Processor *Processor
+	Server    *Server
+}
+
+// New connects to the database and queue named in cfg and builds the
+// processor, with cfg.WorkerCount workers unless an option overrides it,
+// and the HTTP API. Call Start to begin processing and Close when done.
+// Integrations the standalone binary also sets up, such as notifications,
+// payouts and settlement polling, are configured on Server's fields.
+func New(ctx context.Context, cfg *Config, opts ...Option) (*Service, error) {
+	db, err := OpenDatabase(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	queue, err := OpenQueue(cfg.RedisURL)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	queue.Namespace = cfg.RedisNamespace
+	db.Clock = buildOptions(opts).clock
+
+	processor := NewProcessor(db, queue, append([]Option{WithWorkers(cfg.WorkerCount)}, opts...)...)
+	return &Service{
+		Config:    cfg,
+		Database:  db,
+		Queue:     queue,
+		Processor: processor,
+		Server:    NewServer(db, queue, processor, opts...),
+	}, nil
+}
+
+// Start launches the processor's workers.
+func (s *Service) Start(ctx context.Context) {
+	s.Processor.Start(ctx)
+}
+
+// Handler serves the HTTP API.
+func (s *Service) Handler() http.Handler {
+	return s.Server.Handler()
+}
+
+// Close stops the processor and closes the stores.
+func (s *Service) Close() {
+	s.Processor.Stop()
+	s.Queue.Close()
+	s.Database.Close()
+}